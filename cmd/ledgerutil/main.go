@@ -11,7 +11,10 @@ import (
 	"os"
 
 	"github.com/hyperledger/fabric/internal/ledgerutil/compare"
+	"github.com/hyperledger/fabric/internal/ledgerutil/exporthistory"
 	"github.com/hyperledger/fabric/internal/ledgerutil/identifytxs"
+	"github.com/hyperledger/fabric/internal/ledgerutil/inspecthistory"
+	"github.com/hyperledger/fabric/internal/ledgerutil/verifyhistory"
 	"gopkg.in/alecthomas/kingpin.v2"
 )
 
@@ -26,8 +29,19 @@ const (
 		"from ledgerutil compare."
 	blockStorePathDesc = "Path to file system of target peer, used to access block store. Defaults to '/var/hyperledger/production'. " +
 		"IMPORTANT: If the configuration for target peer's file system path was changed, the new path MUST be provided."
-	blockStorePathDefault = "/var/hyperledger/production"
-	outputDirIdDesc       = "Location for identified transactions json results output directory. Default is the current directory."
+	blockStorePathDefault     = "/var/hyperledger/production"
+	outputDirIdDesc           = "Location for identified transactions json results output directory. Default is the current directory."
+	exporthistoryErrorMessage = "Ledger Export History Error: "
+	fsPathDesc                = "Path to file system of target peer, used to access block store and history database. Defaults to '/var/hyperledger/production'. " +
+		"IMPORTANT: If the configuration for target peer's file system path was changed, the new path MUST be provided."
+	outputDirExDesc            = "Location for exported history output file. Default is the current directory."
+	inspecthistoryErrorMessage = "Ledger Inspect History Error: "
+	historyFSPathDesc          = "Path to file system of target peer, used to access history database. Defaults to '/var/hyperledger/production'. " +
+		"IMPORTANT: If the configuration for target peer's file system path was changed, the new path MUST be provided."
+	verifyhistoryErrorMessage = "Ledger Verify History Error: "
+	outputDirVhDesc           = "Location for the verification report json file. Default is the current directory."
+	mspDirDesc                = "MSP directory of the signing identity, used to sign the exported audit bundle. Valid with the audit format only."
+	verifyauditErrorMessage   = "Ledger Verify Audit Error: "
 )
 
 var (
@@ -44,6 +58,32 @@ var (
 	blockStorePath    = identifytxsApp.Arg("blockStorePath", blockStorePathDesc).Default(blockStorePathDefault).String()
 	outputDirId       = identifytxsApp.Flag("outputDir", outputDirIdDesc).Short('o').String()
 
+	exporthistoryApp = app.Command("exporthistory", "Export the history of a namespace or a key for offline audit.")
+	exChannelID      = exporthistoryApp.Arg("channelID", "Channel whose history is to be exported.").Required().String()
+	exNamespace      = exporthistoryApp.Arg("namespace", "Namespace whose history is to be exported.").Required().String()
+	exFSPath         = exporthistoryApp.Arg("fsPath", fsPathDesc).Default(blockStorePathDefault).String()
+	exKey            = exporthistoryApp.Flag("key", "Export the history of this key only.").Short('k').String()
+	exStartBlock     = exporthistoryApp.Flag("startBlock", "Export the entries from this block onward.").Uint64()
+	exEndBlock       = exporthistoryApp.Flag("endBlock", "Export the entries up to and including this block. If set to 0, there is no upper limit.").Uint64()
+	exDeletesOnly    = exporthistoryApp.Flag("deletesOnly", "Export the deletes only.").Bool()
+	exFormat         = exporthistoryApp.Flag("format", "Output format, json, csv, or audit.").Default(exporthistory.FormatJSON).Enum(exporthistory.FormatJSON, exporthistory.FormatCSV, exporthistory.FormatAudit)
+	exMSPDir         = exporthistoryApp.Flag("mspDir", mspDirDesc).String()
+	outputDirEx      = exporthistoryApp.Flag("outputDir", outputDirExDesc).Short('o').String()
+
+	inspecthistoryApp = app.Command("inspecthistory", "Print the raw history index entries and the savepoint of a channel for troubleshooting.")
+	inChannelID       = inspecthistoryApp.Arg("channelID", "Channel whose history database is to be inspected.").Required().String()
+	inFSPath          = inspecthistoryApp.Arg("fsPath", historyFSPathDesc).Default(blockStorePathDefault).String()
+	inNamespace       = inspecthistoryApp.Flag("namespace", "Print the entries of this namespace only. If not set, all the entries are printed.").Short('n').String()
+	inKey             = inspecthistoryApp.Flag("key", "Print the entries of this key only. Requires the namespace.").Short('k').String()
+
+	verifyhistoryApp = app.Command("verifyhistory", "Verify the integrity of the history database of a channel.")
+	vhChannelID      = verifyhistoryApp.Arg("channelID", "Channel whose history database is to be verified.").Required().String()
+	vhFSPath         = verifyhistoryApp.Arg("fsPath", fsPathDesc).Default(blockStorePathDefault).String()
+	outputDirVh      = verifyhistoryApp.Flag("outputDir", outputDirVhDesc).Short('o').String()
+
+	verifyauditApp = app.Command("verifyaudit", "Verify an audit bundle exported by the exporthistory command.")
+	vaBundlePath   = verifyauditApp.Arg("bundlePath", "Path to the audit bundle.").Required().String()
+
 	args = os.Args[1:]
 )
 
@@ -104,5 +144,87 @@ func main() {
 			os.Exit(1)
 		}
 		fmt.Printf("\nSuccessfully ran identify transactions tool. Transactions were checked between blocks %d and %d.", firstBlock, lastBlock)
+
+	case exporthistoryApp.FullCommand():
+
+		// Determine result file location
+		if *outputDirEx == "" {
+			*outputDirEx, err = os.Getwd()
+			if err != nil {
+				fmt.Printf("%s%s\n", exporthistoryErrorMessage, err)
+				os.Exit(1)
+			}
+		}
+
+		filter := &exporthistory.Filter{
+			Namespace:   *exNamespace,
+			Key:         *exKey,
+			StartBlock:  *exStartBlock,
+			EndBlock:    *exEndBlock,
+			DeletesOnly: *exDeletesOnly,
+		}
+		var outputFile string
+		var count int
+		if *exMSPDir == "" {
+			outputFile, count, err = exporthistory.ExportHistory(*exFSPath, *exChannelID, filter, *exFormat, *outputDirEx)
+		} else {
+			if *exFormat != exporthistory.FormatAudit {
+				fmt.Printf("%s--mspDir is valid with the %s format only\n", exporthistoryErrorMessage, exporthistory.FormatAudit)
+				os.Exit(1)
+			}
+			var signer *exporthistory.AuditSigner
+			if signer, err = exporthistory.LoadAuditSigner(*exMSPDir); err == nil {
+				outputFile, count, err = exporthistory.ExportAuditBundle(*exFSPath, *exChannelID, filter, signer, *outputDirEx)
+			}
+		}
+		if err != nil {
+			fmt.Printf("%s%s\n", exporthistoryErrorMessage, err)
+			os.Exit(1)
+		}
+		fmt.Printf("\nSuccessfully exported history. %d entries saved to %s.\n", count, outputFile)
+
+	case inspecthistoryApp.FullCommand():
+
+		if _, err := inspecthistory.InspectHistory(*inFSPath, *inChannelID, *inNamespace, *inKey, os.Stdout); err != nil {
+			fmt.Printf("%s%s\n", inspecthistoryErrorMessage, err)
+			os.Exit(1)
+		}
+
+	case verifyhistoryApp.FullCommand():
+
+		// Determine result json file location
+		if *outputDirVh == "" {
+			*outputDirVh, err = os.Getwd()
+			if err != nil {
+				fmt.Printf("%s%s\n", verifyhistoryErrorMessage, err)
+				os.Exit(1)
+			}
+		}
+
+		report, outputFile, err := verifyhistory.VerifyHistory(*vhFSPath, *vhChannelID, *outputDirVh)
+		if err != nil {
+			fmt.Printf("%s%s\n", verifyhistoryErrorMessage, err)
+			os.Exit(1)
+		}
+		if report.IssueCount != 0 {
+			fmt.Printf("\nHistory database verification failed. Report saved to %s. Total issues found: %d\n", outputFile, report.IssueCount)
+			os.Exit(2)
+		}
+		fmt.Printf("\nSuccessfully verified history database. %d entries checked. Report saved to %s.\n", report.EntriesChecked, outputFile)
+
+	case verifyauditApp.FullCommand():
+
+		bundle, err := exporthistory.VerifyAuditBundle(*vaBundlePath)
+		if err != nil {
+			fmt.Printf("%s%s\n", verifyauditErrorMessage, err)
+			os.Exit(1)
+		}
+		fmt.Printf("\nSuccessfully verified audit bundle of channel %s, namespace %s. %d records, digest %x.\n",
+			bundle.ChannelID, bundle.Filter.Namespace, bundle.NumRecords, bundle.FinalDigest)
+		if bundle.SignerCert == nil {
+			fmt.Println("The bundle is not signed.")
+		} else {
+			fmt.Printf("The bundle is signed by:\n%s", bundle.SignerCert)
+		}
 	}
 }
@@ -52,6 +52,58 @@ func TestArguments(t *testing.T) {
 			exitCode: 1,
 			args:     []string{"identifytxs"},
 		},
+		"exporthistory-help": {
+			exitCode: 0,
+			args:     []string{"exporthistory", "--help"},
+		},
+		"exporthistory": {
+			exitCode: 1,
+			args:     []string{"exporthistory", "mychannel"},
+		},
+		"exporthistory-invalid-format": {
+			exitCode: 1,
+			args:     []string{"exporthistory", "mychannel", "marbles", "--format", "xml"},
+		},
+		"exporthistory-mspdir-without-audit-format": {
+			exitCode: 1,
+			args:     []string{"exporthistory", "mychannel", "marbles", "--mspDir", "/non-existent/msp"},
+		},
+		"verifyaudit-help": {
+			exitCode: 0,
+			args:     []string{"verifyaudit", "--help"},
+		},
+		"verifyaudit": {
+			exitCode: 1,
+			args:     []string{"verifyaudit"},
+		},
+		"verifyaudit-non-existent-bundle": {
+			exitCode: 1,
+			args:     []string{"verifyaudit", "/non-existent/bundle.audit"},
+		},
+		"inspecthistory-help": {
+			exitCode: 0,
+			args:     []string{"inspecthistory", "--help"},
+		},
+		"inspecthistory": {
+			exitCode: 1,
+			args:     []string{"inspecthistory"},
+		},
+		"inspecthistory-non-existent-fs": {
+			exitCode: 1,
+			args:     []string{"inspecthistory", "mychannel", "/non-existent/fs"},
+		},
+		"verifyhistory-help": {
+			exitCode: 0,
+			args:     []string{"verifyhistory", "--help"},
+		},
+		"verifyhistory": {
+			exitCode: 1,
+			args:     []string{"verifyhistory"},
+		},
+		"verifyhistory-non-existent-fs": {
+			exitCode: 1,
+			args:     []string{"verifyhistory", "mychannel", "/non-existent/fs"},
+		},
 	}
 
 	// Build ledger binary
@@ -79,6 +79,26 @@ func extractSerializedBlockInfo(serializedBlockBytes []byte) (*serializedBlockIn
 	return info, nil
 }
 
+// extractTxEnvelopesBytes returns the transaction envelopes of a serialized block, without computing the txids
+// and decoding the metadata as function `deserializeBlock` does
+func extractTxEnvelopesBytes(serializedBlockBytes []byte) ([][]byte, error) {
+	b := newBuffer(serializedBlockBytes)
+	if _, err := extractHeader(b); err != nil {
+		return nil, err
+	}
+	numItems, err := b.DecodeVarint()
+	if err != nil {
+		return nil, errors.Wrap(err, "error decoding the length of block data")
+	}
+	txEnvelopesBytes := make([][]byte, numItems)
+	for i := range txEnvelopesBytes {
+		if txEnvelopesBytes[i], err = b.DecodeRawBytes(false); err != nil {
+			return nil, errors.Wrap(err, "error decoding the transaction envelope")
+		}
+	}
+	return txEnvelopesBytes, nil
+}
+
 func addHeaderBytes(blockHeader *common.BlockHeader, buf *proto.Buffer) error {
 	if err := buf.EncodeVarint(blockHeader.Number); err != nil {
 		return errors.Wrapf(err, "error encoding the block number [%d]", blockHeader.Number)
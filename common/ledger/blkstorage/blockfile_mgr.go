@@ -626,6 +626,40 @@ func (mgr *blockfileMgr) retrieveTransactionByBlockNumTranNum(blockNum uint64, t
 	return mgr.fetchTransactionEnvelope(loc)
 }
 
+// retrieveTransactionsByBlockNumTranNums reads the block once and extracts the envelopes of the given transactions,
+// in the order of the given tranNums
+func (mgr *blockfileMgr) retrieveTransactionsByBlockNumTranNums(blockNum uint64, tranNums []uint64) ([]*common.Envelope, error) {
+	logger.Debugf("retrieveTransactionsByBlockNumTranNums() - blockNum = [%d], tranNums = %v", blockNum, tranNums)
+	if blockNum < mgr.firstPossibleBlockNumberInBlockFiles() {
+		return nil, errors.Errorf(
+			"cannot serve block [%d]. The ledger is bootstrapped from a snapshot. First available block = [%d]",
+			blockNum, mgr.firstPossibleBlockNumberInBlockFiles(),
+		)
+	}
+	loc, err := mgr.index.getBlockLocByBlockNum(blockNum)
+	if err != nil {
+		return nil, err
+	}
+	blockBytes, err := mgr.fetchBlockBytes(loc)
+	if err != nil {
+		return nil, err
+	}
+	txEnvelopesBytes, err := extractTxEnvelopesBytes(blockBytes)
+	if err != nil {
+		return nil, err
+	}
+	txEnvelopes := make([]*common.Envelope, len(tranNums))
+	for i, tranNum := range tranNums {
+		if tranNum >= uint64(len(txEnvelopesBytes)) {
+			return nil, errors.Errorf("no such blockNumber, transactionNumber <%d, %d> in block", blockNum, tranNum)
+		}
+		if txEnvelopes[i], err = protoutil.GetEnvelopeFromBlock(txEnvelopesBytes[tranNum]); err != nil {
+			return nil, err
+		}
+	}
+	return txEnvelopes, nil
+}
+
 func (mgr *blockfileMgr) fetchBlock(lp *fileLocPointer) (*common.Block, error) {
 	blockBytes, err := mgr.fetchBlockBytes(lp)
 	if err != nil {
@@ -373,6 +373,36 @@ func TestBlockfileMgrGetTxByBlockNumTranNum(t *testing.T) {
 	}
 }
 
+func TestBlockfileMgrGetTxsByBlockNumTranNums(t *testing.T) {
+	env := newTestEnv(t, NewConf(t.TempDir(), 0))
+	defer env.Cleanup()
+	blkfileMgrWrapper := newTestBlockfileWrapper(env, "testLedger")
+	defer blkfileMgrWrapper.close()
+	blocks := testutil.ConstructTestBlocks(t, 3)
+	blkfileMgrWrapper.addBlocks(blocks)
+	for blockIndex, blk := range blocks {
+		// the transactions are returned in the order requested, including the repeated ones
+		tranNums := []uint64{}
+		for tranIndex := len(blk.Data.Data) - 1; tranIndex >= 0; tranIndex-- {
+			tranNums = append(tranNums, uint64(tranIndex))
+		}
+		tranNums = append(tranNums, 0)
+		txEnvelopes, err := blkfileMgrWrapper.blockfileMgr.retrieveTransactionsByBlockNumTranNums(uint64(blockIndex), tranNums)
+		require.NoError(t, err)
+		require.Len(t, txEnvelopes, len(tranNums))
+		for i, tranNum := range tranNums {
+			txEnvelope, err := protoutil.GetEnvelopeFromBlock(blk.Data.Data[tranNum])
+			require.NoError(t, err, "Error while unmarshalling tx")
+			require.Equal(t, txEnvelope, txEnvelopes[i])
+		}
+	}
+
+	_, err := blkfileMgrWrapper.blockfileMgr.retrieveTransactionsByBlockNumTranNums(0, []uint64{0, uint64(len(blocks[0].Data.Data))})
+	require.EqualError(t, err, fmt.Sprintf("no such blockNumber, transactionNumber <0, %d> in block", len(blocks[0].Data.Data)))
+	_, err = blkfileMgrWrapper.blockfileMgr.retrieveTransactionsByBlockNumTranNums(uint64(len(blocks)), []uint64{0})
+	require.Error(t, err)
+}
+
 func TestBlockfileMgrRestart(t *testing.T) {
 	env := newTestEnv(t, NewConf(t.TempDir(), 0))
 	defer env.Cleanup()
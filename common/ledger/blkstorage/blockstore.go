@@ -87,6 +87,13 @@ func (store *BlockStore) RetrieveTxByBlockNumTranNum(blockNum uint64, tranNum ui
 	return store.fileMgr.retrieveTransactionByBlockNumTranNum(blockNum, tranNum)
 }
 
+// RetrieveTxsByBlockNumTranNums returns the transactions for the given tranNums in the block blockNum, in the order
+// of the given tranNums. Unlike retrieving each of the transactions by function `RetrieveTxByBlockNumTranNum`,
+// the block is read from the block file once
+func (store *BlockStore) RetrieveTxsByBlockNumTranNums(blockNum uint64, tranNums []uint64) ([]*common.Envelope, error) {
+	return store.fileMgr.retrieveTransactionsByBlockNumTranNums(blockNum, tranNums)
+}
+
 // RetrieveBlockByTxID returns the block for the specified txID
 func (store *BlockStore) RetrieveBlockByTxID(txID string) (*common.Block, error) {
 	return store.fileMgr.retrieveBlockByTxID(txID)
@@ -64,12 +64,14 @@ func (dbInst *DB) Open() {
 	if dbInst.dbState == opened {
 		return
 	}
-	dbOpts := &opt.Options{}
+	dbOpts := &opt.Options{ReadOnly: dbInst.conf.ReadOnly}
 	dbPath := dbInst.conf.DBPath
 	var err error
 	var dirEmpty bool
-	if dirEmpty, err = fileutil.CreateDirIfMissing(dbPath); err != nil {
-		panic(fmt.Sprintf("Error creating dir if missing: %s", err))
+	if !dbInst.conf.ReadOnly {
+		if dirEmpty, err = fileutil.CreateDirIfMissing(dbPath); err != nil {
+			panic(fmt.Sprintf("Error creating dir if missing: %s", err))
+		}
 	}
 	dbOpts.ErrorIfMissing = !dirEmpty
 	if dbInst.db, err = leveldb.OpenFile(dbPath, dbOpts); err != nil {
@@ -159,6 +161,41 @@ func (dbInst *DB) GetIterator(startKey []byte, endKey []byte) iterator.Iterator
 	return dbInst.db.NewIterator(&goleveldbutil.Range{Start: startKey, Limit: endKey}, dbInst.readOpts)
 }
 
+// CompactRange compacts the underlying storage for the keys between the startKey (inclusive) and the endKey
+// (exclusive), so that the space held by the deleted and the overwritten keys in the range is reclaimed.
+// A nil startKey represents the first available key and a nil endKey represent a logical key after the last available key
+func (dbInst *DB) CompactRange(startKey []byte, endKey []byte) error {
+	dbInst.mutex.RLock()
+	defer dbInst.mutex.RUnlock()
+	if err := dbInst.db.CompactRange(goleveldbutil.Range{Start: startKey, Limit: endKey}); err != nil {
+		return errors.Wrap(err, "error compacting leveldb range")
+	}
+	return nil
+}
+
+// ApproximateSize returns the approximate size in bytes, on the disk, of the keys between the startKey (inclusive)
+// and the endKey (exclusive). The data not yet flushed from the memtable is not counted
+func (dbInst *DB) ApproximateSize(startKey []byte, endKey []byte) (int64, error) {
+	dbInst.mutex.RLock()
+	defer dbInst.mutex.RUnlock()
+	sizes, err := dbInst.db.SizeOf([]goleveldbutil.Range{{Start: startKey, Limit: endKey}})
+	if err != nil {
+		return 0, errors.Wrap(err, "error computing the size of leveldb range")
+	}
+	return sizes.Sum(), nil
+}
+
+// LevelSizes returns the size in bytes, on the disk, of the tables at each level of the db
+func (dbInst *DB) LevelSizes() ([]int64, error) {
+	dbInst.mutex.RLock()
+	defer dbInst.mutex.RUnlock()
+	stats := &leveldb.DBStats{}
+	if err := dbInst.db.Stats(stats); err != nil {
+		return nil, errors.Wrap(err, "error retrieving leveldb stats")
+	}
+	return stats.LevelSizes, nil
+}
+
 // WriteBatch writes a batch
 func (dbInst *DB) WriteBatch(batch *leveldb.Batch, sync bool) error {
 	dbInst.mutex.RLock()
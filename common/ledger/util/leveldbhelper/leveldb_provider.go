@@ -12,6 +12,7 @@ import (
 	"sync"
 
 	"github.com/hyperledger/fabric/common/ledger/dataformat"
+	"github.com/hyperledger/fabric/internal/fileutil"
 	"github.com/pkg/errors"
 	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/iterator"
@@ -42,9 +43,13 @@ type closeFunc func()
 // either the db is empty (i.e., opening for the first time) or the value
 // of the formatVersionKey is equal to `ExpectedFormat`. Otherwise, an error is returned.
 // A nil value for ExpectedFormat indicates that the format is never set and hence there is no such record.
+//
+// `ReadOnly` opens an existing db for reads only. The writes to a db opened as read-only fail. The db cannot be
+// opened as read-only while it is open by another process, as leveldb does not allow for concurrent access.
 type Conf struct {
 	DBPath         string
 	ExpectedFormat string
+	ReadOnly       bool
 }
 
 // Provider enables to use a single leveldb as multiple logical leveldbs
@@ -90,6 +95,15 @@ func RetrieveDataFormatInfo(dbPath string) (*DataFormatInfo, error) {
 
 // NewProvider constructs a Provider
 func NewProvider(conf *Conf) (*Provider, error) {
+	if conf.ReadOnly {
+		empty, err := fileutil.DirEmpty(conf.DBPath)
+		if err != nil {
+			return nil, err
+		}
+		if empty {
+			return nil, errors.Errorf("leveldb at [%s] does not exist and cannot be opened as read-only", conf.DBPath)
+		}
+	}
 	db, err := openDBAndCheckFormat(conf)
 	if err != nil {
 		return nil, err
@@ -120,7 +134,7 @@ func openDBAndCheckFormat(conf *Conf) (d *DB, e error) {
 		return nil, err
 	}
 
-	if dbEmpty && conf.ExpectedFormat != "" {
+	if dbEmpty && conf.ExpectedFormat != "" && !conf.ReadOnly {
 		logger.Infof("DB is empty Setting db format as %s", conf.ExpectedFormat)
 		if err := internalDB.Put(formatVersionKey, []byte(conf.ExpectedFormat), true); err != nil {
 			return nil, err
@@ -175,6 +189,12 @@ func (p *Provider) GetDBHandle(dbName string) *DBHandle {
 	return dbHandle
 }
 
+// LevelSizes returns the size in bytes, on the disk, of the tables at each level of the underlying leveldb, which
+// holds the data of all the dbs of the provider
+func (p *Provider) LevelSizes() ([]int64, error) {
+	return p.db.LevelSizes()
+}
+
 // Close closes the underlying leveldb
 func (p *Provider) Close() {
 	p.db.Close()
@@ -287,12 +307,7 @@ func (h *DBHandle) WriteBatch(batch *UpdateBatch, sync bool) error {
 // The resultset contains all the keys that are present in the db between the startKey (inclusive) and the endKey (exclusive).
 // A nil startKey represents the first available key and a nil endKey represent a logical key after the last available key
 func (h *DBHandle) GetIterator(startKey []byte, endKey []byte) (*Iterator, error) {
-	sKey := constructLevelKey(h.dbName, startKey)
-	eKey := constructLevelKey(h.dbName, endKey)
-	if endKey == nil {
-		// replace the last byte 'dbNameKeySep' by 'lastKeyIndicator'
-		eKey[len(eKey)-1] = lastKeyIndicator
-	}
+	sKey, eKey := h.levelRange(startKey, endKey)
 	logger.Debugf("Getting iterator for range [%#v] - [%#v]", sKey, eKey)
 	itr := h.db.GetIterator(sKey, eKey)
 	if err := itr.Error(); err != nil {
@@ -302,6 +317,32 @@ func (h *DBHandle) GetIterator(startKey []byte, endKey []byte) (*Iterator, error
 	return &Iterator{h.dbName, itr}, nil
 }
 
+// CompactRange compacts the underlying storage for the keys between the startKey (inclusive) and the endKey
+// (exclusive), so that the space held by the deleted and the overwritten keys is reclaimed. As with GetIterator,
+// a nil startKey and a nil endKey represent the range of all the keys of the db
+func (h *DBHandle) CompactRange(startKey []byte, endKey []byte) error {
+	sKey, eKey := h.levelRange(startKey, endKey)
+	return h.db.CompactRange(sKey, eKey)
+}
+
+// ApproximateSize returns the approximate size in bytes, on the disk, of the keys between the startKey (inclusive)
+// and the endKey (exclusive). As with GetIterator, a nil startKey and a nil endKey represent the range of all the
+// keys of the db
+func (h *DBHandle) ApproximateSize(startKey []byte, endKey []byte) (int64, error) {
+	sKey, eKey := h.levelRange(startKey, endKey)
+	return h.db.ApproximateSize(sKey, eKey)
+}
+
+func (h *DBHandle) levelRange(startKey []byte, endKey []byte) ([]byte, []byte) {
+	sKey := constructLevelKey(h.dbName, startKey)
+	eKey := constructLevelKey(h.dbName, endKey)
+	if endKey == nil {
+		// replace the last byte 'dbNameKeySep' by 'lastKeyIndicator'
+		eKey[len(eKey)-1] = lastKeyIndicator
+	}
+	return sKey, eKey
+}
+
 // Close closes the DBHandle after its db data have been deleted
 func (h *DBHandle) Close() {
 	if h.closeFunc != nil {
@@ -509,6 +509,28 @@ func TestRetrieveDataFormatInfo(t *testing.T) {
 	})
 }
 
+func TestReadOnly(t *testing.T) {
+	dbPath := t.TempDir()
+	p, err := NewProvider(&Conf{DBPath: dbPath, ExpectedFormat: "2.0"})
+	require.NoError(t, err)
+	require.NoError(t, p.GetDBHandle("db1").Put([]byte("key1"), []byte("value1"), true))
+	p.Close()
+
+	readOnlyProvider, err := NewProvider(&Conf{DBPath: dbPath, ExpectedFormat: "2.0", ReadOnly: true})
+	require.NoError(t, err)
+	defer readOnlyProvider.Close()
+	db := readOnlyProvider.GetDBHandle("db1")
+	val, err := db.Get([]byte("key1"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("value1"), val)
+	require.ErrorIs(t, db.Put([]byte("key2"), []byte("value2"), true), leveldb.ErrReadOnly)
+
+	_, err = NewProvider(&Conf{DBPath: t.TempDir(), ReadOnly: true})
+	require.Contains(t, err.Error(), "does not exist and cannot be opened as read-only")
+	_, err = NewProvider(&Conf{DBPath: dbPath + "-non-existent", ReadOnly: true})
+	require.Contains(t, err.Error(), "error opening dir")
+}
+
 func TestUpdateBatch(t *testing.T) {
 	b := &UpdateBatch{
 		dbName:       "mydb",
@@ -676,3 +698,43 @@ func createTestValues(dbname string, start int, end int) []string {
 	}
 	return values
 }
+
+func TestCompactRangeAndApproximateSize(t *testing.T) {
+	env := newTestProviderEnv(t, testDBPath)
+	defer env.cleanup()
+	p := env.provider
+
+	db1 := p.GetDBHandle("db1")
+	db2 := p.GetDBHandle("db2")
+	for i := 0; i < 10000; i++ {
+		require.NoError(t, db1.Put([]byte(createTestLongKey(i)), []byte(createTestValue("db1", i)), false))
+	}
+	require.NoError(t, db2.Put([]byte(createTestKey(0)), []byte(createTestValue("db2", 0)), false))
+
+	// the data held in the memtable is flushed by the compaction and counted afterwards
+	require.NoError(t, db1.CompactRange(nil, nil))
+	size, err := db1.ApproximateSize(nil, nil)
+	require.NoError(t, err)
+	require.Greater(t, size, int64(10000))
+	halfSize, err := db1.ApproximateSize(nil, []byte(createTestLongKey(5000)))
+	require.NoError(t, err)
+	require.Less(t, halfSize, size)
+	levelSizes, err := p.LevelSizes()
+	require.NoError(t, err)
+	levelsTotal := int64(0)
+	for _, levelSize := range levelSizes {
+		levelsTotal += levelSize
+	}
+	require.GreaterOrEqual(t, levelsTotal, size)
+
+	require.NoError(t, p.Drop("db1"))
+	require.NoError(t, db1.CompactRange(nil, nil))
+	reclaimedSize, err := db1.ApproximateSize(nil, nil)
+	require.NoError(t, err)
+	require.Less(t, reclaimedSize, size/10)
+
+	// the compaction of db1 leaves the data of db2 intact
+	val, err := db2.Get([]byte(createTestKey(0)))
+	require.NoError(t, err)
+	require.Equal(t, []byte(createTestValue("db2", 0)), val)
+}
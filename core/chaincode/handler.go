@@ -7,6 +7,7 @@ SPDX-License-Identifier: Apache-2.0
 package chaincode
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"strconv"
@@ -26,6 +27,7 @@ import (
 	"github.com/hyperledger/fabric/core/container/ccintf"
 	"github.com/hyperledger/fabric/core/ledger"
 	"github.com/hyperledger/fabric/core/scc"
+	"github.com/hyperledger/fabric/protoutil"
 	"github.com/pkg/errors"
 )
 
@@ -1180,7 +1182,7 @@ func (h *Handler) HandleInvokeChaincode(msg *pb.ChaincodeMessage, txContext *Tra
 		}
 		defer sim.Done()
 
-		hqe, err := lgr.NewHistoryQueryExecutor()
+		hqe, err := newHistoryQueryExecutor(lgr, txContext.Proposal)
 		if err != nil {
 			return nil, errors.WithStack(err)
 		}
@@ -1205,6 +1207,24 @@ func (h *Handler) HandleInvokeChaincode(msg *pb.ChaincodeMessage, txContext *Tra
 	return &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_RESPONSE, Payload: res, Txid: msg.Txid, ChannelId: msg.ChannelId}, nil
 }
 
+// newHistoryQueryExecutor returns a history query executor of the ledger for the queries issued on behalf of the creator
+// of the proposal, so that the ledger accounts the queries to the creator, if it accounts the history queries per client
+func newHistoryQueryExecutor(lgr ledger.PeerLedger, proposal *pb.Proposal) (ledger.HistoryQueryExecutor, error) {
+	p, ok := lgr.(ledger.ClientHistoryQueryExecutorProvider)
+	if !ok || proposal == nil {
+		return lgr.NewHistoryQueryExecutor()
+	}
+	header, err := protoutil.UnmarshalHeader(proposal.Header)
+	if err != nil {
+		return nil, err
+	}
+	signatureHeader, err := protoutil.UnmarshalSignatureHeader(header.SignatureHeader)
+	if err != nil {
+		return nil, err
+	}
+	return p.NewHistoryQueryExecutorForClient(context.Background(), signatureHeader.Creator)
+}
+
 func (h *Handler) Execute(txParams *ccprovider.TransactionParams, namespace string, msg *pb.ChaincodeMessage, timeout time.Duration) (*pb.ChaincodeMessage, error) {
 	chaincodeLogger.Debugf("Entry")
 	defer chaincodeLogger.Debugf("Exit")
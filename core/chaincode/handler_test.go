@@ -7,10 +7,12 @@ SPDX-License-Identifier: Apache-2.0
 package chaincode_test
 
 import (
+	"context"
 	"io"
 	"time"
 
 	"github.com/golang/protobuf/proto"
+	cb "github.com/hyperledger/fabric-protos-go/common"
 	pb "github.com/hyperledger/fabric-protos-go/peer"
 	"github.com/hyperledger/fabric/common/metrics/metricsfakes"
 	"github.com/hyperledger/fabric/common/util"
@@ -20,7 +22,9 @@ import (
 	"github.com/hyperledger/fabric/core/chaincode/mock"
 	"github.com/hyperledger/fabric/core/common/ccprovider"
 	"github.com/hyperledger/fabric/core/common/sysccprovider"
+	"github.com/hyperledger/fabric/core/ledger"
 	"github.com/hyperledger/fabric/core/scc"
+	"github.com/hyperledger/fabric/protoutil"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	"github.com/pkg/errors"
@@ -2426,6 +2430,46 @@ var _ = Describe("Handler", func() {
 					Expect(err).To(MatchError("razzies"))
 				})
 			})
+
+			Context("when the target ledger accounts the history queries per client", func() {
+				var clientLedger *clientHistoryPeerLedger
+
+				BeforeEach(func() {
+					clientLedger = &clientHistoryPeerLedger{
+						PeerLedger: fakePeerLedger,
+						hqe:        newHistoryQueryExecutor,
+					}
+					fakeLedgerGetter.GetLedgerReturns(clientLedger)
+					txContext.Proposal = &pb.Proposal{
+						Header: protoutil.MarshalOrPanic(&cb.Header{
+							SignatureHeader: protoutil.MarshalOrPanic(&cb.SignatureHeader{
+								Creator: []byte("creator"),
+							}),
+						}),
+					}
+				})
+
+				It("creates the history query executor for the creator of the proposal", func() {
+					_, err := handler.HandleInvokeChaincode(incomingMessage, txContext)
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(fakePeerLedger.NewHistoryQueryExecutorCallCount()).To(Equal(0))
+					Expect(clientLedger.creators).To(Equal([][]byte{[]byte("creator")}))
+					txParams, _, _ := fakeInvoker.InvokeArgsForCall(0)
+					Expect(txParams.HistoryQueryExecutor).To(BeIdenticalTo(newHistoryQueryExecutor))
+				})
+
+				Context("when the proposal header is malformed", func() {
+					BeforeEach(func() {
+						txContext.Proposal = &pb.Proposal{Header: []byte("garbage")}
+					})
+
+					It("returns an error", func() {
+						_, err := handler.HandleInvokeChaincode(incomingMessage, txContext)
+						Expect(err).To(MatchError(ContainSubstring("error unmarshalling Header")))
+					})
+				})
+			})
 		})
 
 		Context("when the target is a system chaincode", func() {
@@ -3106,3 +3150,15 @@ var _ = Describe("Handler", func() {
 		Entry("unknown", chaincode.State(999), "UNKNOWN"),
 	)
 })
+
+// clientHistoryPeerLedger is a peer ledger that accounts the history queries per client
+type clientHistoryPeerLedger struct {
+	*mock.PeerLedger
+	hqe      ledger.HistoryQueryExecutor
+	creators [][]byte
+}
+
+func (l *clientHistoryPeerLedger) NewHistoryQueryExecutorForClient(ctx context.Context, creator []byte) (ledger.HistoryQueryExecutor, error) {
+	l.creators = append(l.creators, creator)
+	return l.hqe, nil
+}
@@ -14,10 +14,11 @@ import (
 )
 
 // The targz metadata provider is reference for other providers (such as what CAR would
-// implement). Currently it treats only statedb metadata but will be generalized in future
-// to allow for arbitrary metadata to be packaged with the chaincode.
+// implement). Currently it treats the statedb metadata and the history indexing manifest but
+// will be generalized in future to allow for arbitrary metadata to be packaged with the chaincode.
 const (
 	ccPackageStatedbDir = "META-INF/statedb/"
+	ccPackageHistoryDir = "META-INF/history/"
 )
 
 type PersistenceAdapter func([]byte) ([]byte, error)
@@ -41,7 +42,7 @@ func MetadataAsTarEntries(code []byte) ([]byte, error) {
 	tr := tar.NewReader(gr)
 
 	// For each file in the code package tar,
-	// add it to the statedb artifact tar if it has "statedb" in the path
+	// add it to the statedb artifact tar if it has "statedb" or "history" in the path
 	for {
 		header, err := tr.Next()
 		if err == io.EOF {
@@ -52,7 +53,7 @@ func MetadataAsTarEntries(code []byte) ([]byte, error) {
 			return nil, err
 		}
 
-		if !strings.HasPrefix(header.Name, ccPackageStatedbDir) {
+		if !strings.HasPrefix(header.Name, ccPackageStatedbDir) && !strings.HasPrefix(header.Name, ccPackageHistoryDir) {
 			continue
 		}
 
@@ -87,3 +87,18 @@ func TestMetadata(t *testing.T) {
 	require.Nil(t, err)
 	require.Equal(t, count, 2)
 }
+
+func TestHistoryMetadata(t *testing.T) {
+	entries := []tarEntry{{ccPackageStatedbDir + "/m1", []byte("m1data")}, {ccPackageHistoryDir + "indexing.json", []byte("{}")}}
+	cds := getCodePackage([]byte("cc code"), entries)
+	metadata, err := MetadataAsTarEntries(cds)
+	require.Nil(t, err)
+	count, err := getNumEntries(metadata)
+	require.Nil(t, err)
+	require.Equal(t, count, 2)
+
+	// the history manifest is not taken as a couchdb artifact
+	couchdbEntries, err := ExtractFileEntries(metadata, "couchdb")
+	require.Nil(t, err)
+	require.Len(t, couchdbEntries, 0)
+}
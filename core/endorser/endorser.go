@@ -49,8 +49,9 @@ type Support interface {
 	GetTxSimulator(ledgername string, txid string) (ledger.TxSimulator, error)
 
 	// GetHistoryQueryExecutor gives handle to a history query executor for the
-	// specified ledger
-	GetHistoryQueryExecutor(ledgername string) (ledger.HistoryQueryExecutor, error)
+	// specified ledger, for the queries issued in the context of the given
+	// request on behalf of the client with the given serialized identity
+	GetHistoryQueryExecutor(ctx context.Context, ledgername string, creator []byte) (ledger.HistoryQueryExecutor, error)
 
 	// GetTransactionByID retrieves a transaction by id
 	GetTransactionByID(chid, txID string) (*pb.ProcessedTransaction, error)
@@ -347,7 +348,7 @@ func (e *Endorser) ProcessProposal(ctx context.Context, signedProp *pb.SignedPro
 		e.Metrics.ProposalDuration.With(meterLabels...).Observe(time.Since(startTime).Seconds())
 	}()
 
-	pResp, err := e.ProcessProposalSuccessfullyOrError(up)
+	pResp, err := e.ProcessProposalSuccessfullyOrError(ctx, up)
 	if err != nil {
 		endorserLogger.Warnw("Failed to invoke chaincode", "channel", up.ChannelHeader.ChannelId, "chaincode", up.ChaincodeName, "error", err.Error())
 		// Return a nil error since clients are expected to look at the ProposalResponse response status code (500) and message.
@@ -366,7 +367,7 @@ func (e *Endorser) ProcessProposal(ctx context.Context, signedProp *pb.SignedPro
 	return pResp, nil
 }
 
-func (e *Endorser) ProcessProposalSuccessfullyOrError(up *UnpackedProposal) (*pb.ProposalResponse, error) {
+func (e *Endorser) ProcessProposalSuccessfullyOrError(ctx context.Context, up *UnpackedProposal) (*pb.ProposalResponse, error) {
 	txParams := &ccprovider.TransactionParams{
 		ChannelID:  up.ChannelHeader.ChannelId,
 		TxID:       up.ChannelHeader.TxId,
@@ -391,7 +392,7 @@ func (e *Endorser) ProcessProposalSuccessfullyOrError(up *UnpackedProposal) (*pb
 		// released, the following txsim.Done() simply returns.
 		defer txSim.Done()
 
-		hqe, err := e.Support.GetHistoryQueryExecutor(up.ChannelID())
+		hqe, err := e.Support.GetHistoryQueryExecutor(ctx, up.ChannelID(), up.SignatureHeader.Creator)
 		if err != nil {
 			return nil, err
 		}
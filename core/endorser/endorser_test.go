@@ -275,7 +275,7 @@ var _ = Describe("Endorser", func() {
 			Payload: []byte("response-payload"),
 		})).To(BeTrue())
 		Expect(fakeSupport.GetHistoryQueryExecutorCallCount()).To(Equal(1))
-		ledgerName := fakeSupport.GetHistoryQueryExecutorArgsForCall(0)
+		_, ledgerName, _ := fakeSupport.GetHistoryQueryExecutorArgsForCall(0)
 		Expect(ledgerName).To(Equal("channel-id"))
 	})
 
@@ -346,12 +346,18 @@ var _ = Describe("Endorser", func() {
 		})
 	})
 
-	It("gets a history query executor", func() {
-		_, err := e.ProcessProposal(context.Background(), signedProposal)
+	It("gets a history query executor for the request context and the proposal creator", func() {
+		type ctxKey struct{}
+		ctx := context.WithValue(context.Background(), ctxKey{}, "request")
+		_, err := e.ProcessProposal(ctx, signedProposal)
 		Expect(err).NotTo(HaveOccurred())
 		Expect(fakeSupport.GetHistoryQueryExecutorCallCount()).To(Equal(1))
-		ledgerName := fakeSupport.GetHistoryQueryExecutorArgsForCall(0)
+		queryCtx, ledgerName, creator := fakeSupport.GetHistoryQueryExecutorArgsForCall(0)
+		Expect(queryCtx.Value(ctxKey{})).To(Equal("request"))
 		Expect(ledgerName).To(Equal("channel-id"))
+		Expect(creator).To(Equal(protoutil.MarshalOrPanic(&mspproto.SerializedIdentity{
+			Mspid: "msp-id",
+		})))
 	})
 
 	Context("when getting the history query executor fails", func() {
@@ -2,6 +2,7 @@
 package fake
 
 import (
+	"context"
 	"sync"
 
 	"github.com/hyperledger/fabric-protos-go/peer"
@@ -101,10 +102,12 @@ type Support struct {
 	getDeployedCCInfoProviderReturnsOnCall map[int]struct {
 		result1 ledger.DeployedChaincodeInfoProvider
 	}
-	GetHistoryQueryExecutorStub        func(string) (ledger.HistoryQueryExecutor, error)
+	GetHistoryQueryExecutorStub        func(context.Context, string, []byte) (ledger.HistoryQueryExecutor, error)
 	getHistoryQueryExecutorMutex       sync.RWMutex
 	getHistoryQueryExecutorArgsForCall []struct {
-		arg1 string
+		arg1 context.Context
+		arg2 string
+		arg3 []byte
 	}
 	getHistoryQueryExecutorReturns struct {
 		result1 ledger.HistoryQueryExecutor
@@ -584,16 +587,23 @@ func (fake *Support) GetDeployedCCInfoProviderReturnsOnCall(i int, result1 ledge
 	}{result1}
 }
 
-func (fake *Support) GetHistoryQueryExecutor(arg1 string) (ledger.HistoryQueryExecutor, error) {
+func (fake *Support) GetHistoryQueryExecutor(arg1 context.Context, arg2 string, arg3 []byte) (ledger.HistoryQueryExecutor, error) {
+	var arg3Copy []byte
+	if arg3 != nil {
+		arg3Copy = make([]byte, len(arg3))
+		copy(arg3Copy, arg3)
+	}
 	fake.getHistoryQueryExecutorMutex.Lock()
 	ret, specificReturn := fake.getHistoryQueryExecutorReturnsOnCall[len(fake.getHistoryQueryExecutorArgsForCall)]
 	fake.getHistoryQueryExecutorArgsForCall = append(fake.getHistoryQueryExecutorArgsForCall, struct {
-		arg1 string
-	}{arg1})
-	fake.recordInvocation("GetHistoryQueryExecutor", []interface{}{arg1})
+		arg1 context.Context
+		arg2 string
+		arg3 []byte
+	}{arg1, arg2, arg3Copy})
+	fake.recordInvocation("GetHistoryQueryExecutor", []interface{}{arg1, arg2, arg3Copy})
 	fake.getHistoryQueryExecutorMutex.Unlock()
 	if fake.GetHistoryQueryExecutorStub != nil {
-		return fake.GetHistoryQueryExecutorStub(arg1)
+		return fake.GetHistoryQueryExecutorStub(arg1, arg2, arg3)
 	}
 	if specificReturn {
 		return ret.result1, ret.result2
@@ -608,17 +618,17 @@ func (fake *Support) GetHistoryQueryExecutorCallCount() int {
 	return len(fake.getHistoryQueryExecutorArgsForCall)
 }
 
-func (fake *Support) GetHistoryQueryExecutorCalls(stub func(string) (ledger.HistoryQueryExecutor, error)) {
+func (fake *Support) GetHistoryQueryExecutorCalls(stub func(context.Context, string, []byte) (ledger.HistoryQueryExecutor, error)) {
 	fake.getHistoryQueryExecutorMutex.Lock()
 	defer fake.getHistoryQueryExecutorMutex.Unlock()
 	fake.GetHistoryQueryExecutorStub = stub
 }
 
-func (fake *Support) GetHistoryQueryExecutorArgsForCall(i int) string {
+func (fake *Support) GetHistoryQueryExecutorArgsForCall(i int) (context.Context, string, []byte) {
 	fake.getHistoryQueryExecutorMutex.RLock()
 	defer fake.getHistoryQueryExecutorMutex.RUnlock()
 	argsForCall := fake.getHistoryQueryExecutorArgsForCall[i]
-	return argsForCall.arg1
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
 }
 
 func (fake *Support) GetHistoryQueryExecutorReturns(result1 ledger.HistoryQueryExecutor, result2 error) {
@@ -7,6 +7,7 @@ SPDX-License-Identifier: Apache-2.0
 package endorser
 
 import (
+	"context"
 	"fmt"
 
 	pb "github.com/hyperledger/fabric-protos-go/peer"
@@ -67,12 +68,17 @@ func (s *SupportImpl) GetTxSimulator(ledgername string, txid string) (ledger.TxS
 }
 
 // GetHistoryQueryExecutor gives handle to a history query executor for the
-// specified ledger
-func (s *SupportImpl) GetHistoryQueryExecutor(ledgername string) (ledger.HistoryQueryExecutor, error) {
+// specified ledger. If the ledger schedules and accounts the history queries
+// per client, the queries are scheduled as per the given request context and
+// accounted to the client with the given serialized identity
+func (s *SupportImpl) GetHistoryQueryExecutor(ctx context.Context, ledgername string, creator []byte) (ledger.HistoryQueryExecutor, error) {
 	lgr := s.Peer.GetLedger(ledgername)
 	if lgr == nil {
 		return nil, errors.Errorf("Channel does not exist: %s", ledgername)
 	}
+	if p, ok := lgr.(ledger.ClientHistoryQueryExecutorProvider); ok {
+		return p.NewHistoryQueryExecutorForClient(ctx, creator)
+	}
 	return lgr.NewHistoryQueryExecutor()
 }
 
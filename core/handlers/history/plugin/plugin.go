@@ -0,0 +1,91 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"sync"
+
+	"github.com/hyperledger/fabric/core/ledger"
+)
+
+// NewHistoryView creates a history view that indexes the deletes under the namespace of the deleted key, so that the
+// keys deleted in a namespace can be listed
+func NewHistoryView() ledger.HistoryView {
+	return &deletesView{}
+}
+
+// NewHistoryCommitListener creates a history commit listener that tracks the last block handled for each ledger
+func NewHistoryCommitListener() ledger.HistoryCommitListener {
+	return &lastBlockListener{lastBlocks: map[string]uint64{}}
+}
+
+// NewHistoryProjector creates a history projector that counts the key changes of each block for each ledger
+func NewHistoryProjector() ledger.HistoryProjector {
+	return &changeCountProjector{counts: map[string]map[uint64]int{}}
+}
+
+type deletesView struct{}
+
+func (v *deletesView) Name() string {
+	return "deletes"
+}
+
+func (v *deletesView) Project(ns, key string, value []byte, isDelete bool) ([]string, error) {
+	if !isDelete {
+		return nil, nil
+	}
+	return []string{ns}, nil
+}
+
+type lastBlockListener struct {
+	lock       sync.Mutex
+	lastBlocks map[string]uint64
+}
+
+func (l *lastBlockListener) Name() string {
+	return "lastblock"
+}
+
+func (l *lastBlockListener) HandleHistoryCommit(commit *ledger.HistoryCommit) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.lastBlocks[commit.LedgerID] = commit.BlockNum
+	return nil
+}
+
+type changeCountProjector struct {
+	lock   sync.Mutex
+	counts map[string]map[uint64]int
+}
+
+func (p *changeCountProjector) Name() string {
+	return "changecount"
+}
+
+func (p *changeCountProjector) Reset(ledgerID string, fromBlock uint64) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for blockNum := range p.counts[ledgerID] {
+		if blockNum >= fromBlock {
+			delete(p.counts[ledgerID], blockNum)
+		}
+	}
+	return nil
+}
+
+func (p *changeCountProjector) Apply(ledgerID string, blockNum uint64, changes []*ledger.HistoryKeyChange) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.counts[ledgerID] == nil {
+		p.counts[ledgerID] = map[uint64]int{}
+	}
+	p.counts[ledgerID][blockNum] = len(changes)
+	return nil
+}
+
+func main() {
+}
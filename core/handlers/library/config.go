@@ -18,6 +18,10 @@ type Config struct {
 	Decorators  []*HandlerConfig `yaml:"decorators"`
 	Endorsers   PluginMapping    `yaml:"endorsers"`
 	Validators  PluginMapping    `yaml:"validators"`
+
+	HistoryViews           []*HandlerConfig `yaml:"historyViews"`
+	HistoryCommitListeners []*HandlerConfig `yaml:"historyCommitListeners"`
+	HistoryProjectors      []*HandlerConfig `yaml:"historyProjectors"`
 }
 
 // PluginMapping stores a map between chaincode id to plugin config
@@ -39,6 +43,19 @@ func LoadConfig() (Config, error) {
 		return Config{}, err
 	}
 
+	var historyViews, historyCommitListeners, historyProjectors []*HandlerConfig
+	if err := mapstructure.Decode(viper.Get("peer.handlers.historyViews"), &historyViews); err != nil {
+		return Config{}, err
+	}
+
+	if err := mapstructure.Decode(viper.Get("peer.handlers.historyCommitListeners"), &historyCommitListeners); err != nil {
+		return Config{}, err
+	}
+
+	if err := mapstructure.Decode(viper.Get("peer.handlers.historyProjectors"), &historyProjectors); err != nil {
+		return Config{}, err
+	}
+
 	endorsers, validators := make(PluginMapping), make(PluginMapping)
 	e := viper.GetStringMap("peer.handlers.endorsers")
 	for k := range e {
@@ -59,5 +76,9 @@ func LoadConfig() (Config, error) {
 		Decorators:  decorators,
 		Endorsers:   endorsers,
 		Validators:  validators,
+
+		HistoryViews:           historyViews,
+		HistoryCommitListeners: historyCommitListeners,
+		HistoryProjectors:      historyProjectors,
 	}, nil
 }
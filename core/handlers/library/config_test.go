@@ -36,6 +36,15 @@ peer:
       vscc:
         name: DefaultValidation
         library: /path/to/vscc.so
+    historyViews:
+      - name: HistoryView
+        library: /path/to/historyview.so
+    historyCommitListeners:
+      - name: HistoryCommitListener
+        library: /path/to/historylistener.so
+    historyProjectors:
+      - name: HistoryProjector
+        library: /path/to/historyprojector.so
 `
 
 	viper.SetConfigType("yaml")
@@ -60,6 +69,15 @@ peer:
 		Validators: PluginMapping{
 			"vscc": &HandlerConfig{Name: "DefaultValidation", Library: "/path/to/vscc.so"},
 		},
+		HistoryViews: []*HandlerConfig{
+			{Name: "HistoryView", Library: "/path/to/historyview.so"},
+		},
+		HistoryCommitListeners: []*HandlerConfig{
+			{Name: "HistoryCommitListener", Library: "/path/to/historylistener.so"},
+		},
+		HistoryProjectors: []*HandlerConfig{
+			{Name: "HistoryProjector", Library: "/path/to/historyprojector.so"},
+		},
 	}
 	require.EqualValues(t, expect, actual)
 }
@@ -18,6 +18,7 @@ import (
 	"github.com/hyperledger/fabric/core/handlers/decoration"
 	endorsement "github.com/hyperledger/fabric/core/handlers/endorsement/api"
 	validation "github.com/hyperledger/fabric/core/handlers/validation/api"
+	"github.com/hyperledger/fabric/core/ledger"
 )
 
 // loadPlugin loads a pluggable handler
@@ -38,6 +39,12 @@ func (r *registry) loadPlugin(pluginPath string, handlerType HandlerType, extraA
 		r.initEndorsementPlugin(p, extraArgs...)
 	} else if handlerType == Validation {
 		r.initValidationPlugin(p, extraArgs...)
+	} else if handlerType == HistoryView {
+		r.initHistoryViewPlugin(p)
+	} else if handlerType == HistoryCommitListener {
+		r.initHistoryCommitListenerPlugin(p)
+	} else if handlerType == HistoryProjector {
+		r.initHistoryProjectorPlugin(p)
 	}
 }
 
@@ -114,6 +121,57 @@ func (r *registry) initValidationPlugin(p *plugin.Plugin, extraArgs ...string) {
 	r.validators[extraArgs[0]] = factory
 }
 
+// initHistoryViewPlugin constructs a history view from the given plugin
+func (r *registry) initHistoryViewPlugin(p *plugin.Plugin) {
+	constructorSymbol, err := p.Lookup(historyViewPluginFactory)
+	if err != nil {
+		panicWithLookupError(historyViewPluginFactory, err)
+	}
+	constructor, ok := constructorSymbol.(func() ledger.HistoryView)
+	if !ok {
+		panicWithDefinitionError(historyViewPluginFactory)
+	}
+	view := constructor()
+	if view == nil {
+		logger.Panicf("history view instance returned nil")
+	}
+	r.historyViews = append(r.historyViews, view)
+}
+
+// initHistoryCommitListenerPlugin constructs a history commit listener from the given plugin
+func (r *registry) initHistoryCommitListenerPlugin(p *plugin.Plugin) {
+	constructorSymbol, err := p.Lookup(historyCommitListenerPluginFactory)
+	if err != nil {
+		panicWithLookupError(historyCommitListenerPluginFactory, err)
+	}
+	constructor, ok := constructorSymbol.(func() ledger.HistoryCommitListener)
+	if !ok {
+		panicWithDefinitionError(historyCommitListenerPluginFactory)
+	}
+	listener := constructor()
+	if listener == nil {
+		logger.Panicf("history commit listener instance returned nil")
+	}
+	r.historyCommitListeners = append(r.historyCommitListeners, listener)
+}
+
+// initHistoryProjectorPlugin constructs a history projector from the given plugin
+func (r *registry) initHistoryProjectorPlugin(p *plugin.Plugin) {
+	constructorSymbol, err := p.Lookup(historyProjectorPluginFactory)
+	if err != nil {
+		panicWithLookupError(historyProjectorPluginFactory, err)
+	}
+	constructor, ok := constructorSymbol.(func() ledger.HistoryProjector)
+	if !ok {
+		panicWithDefinitionError(historyProjectorPluginFactory)
+	}
+	projector := constructor()
+	if projector == nil {
+		logger.Panicf("history projector instance returned nil")
+	}
+	r.historyProjectors = append(r.historyProjectors, projector)
+}
+
 // panicWithLookupError panics when a handler constructor lookup fails
 func panicWithLookupError(factory string, err error) {
 	logger.Panicf("Plugin must contain constructor with name %s. Error from lookup: %s", factory, err)
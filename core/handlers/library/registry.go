@@ -16,6 +16,7 @@ import (
 	"github.com/hyperledger/fabric/core/handlers/decoration"
 	endorsement2 "github.com/hyperledger/fabric/core/handlers/endorsement/api"
 	validation "github.com/hyperledger/fabric/core/handlers/validation/api"
+	"github.com/hyperledger/fabric/core/ledger"
 )
 
 var logger = flogging.MustGetLogger("core.handlers")
@@ -40,10 +41,19 @@ const (
 	Decoration
 	Endorsement
 	Validation
-
-	authPluginFactory      = "NewFilter"
-	decoratorPluginFactory = "NewDecorator"
-	pluginFactory          = "NewPluginFactory"
+	// HistoryView handler - index the writes committed to the history database under the rows of a view
+	HistoryView
+	// HistoryCommitListener handler - maintain custom indexes over the writes committed to the history database
+	HistoryCommitListener
+	// HistoryProjector handler - maintain a read model derived from the key changes of the committed blocks
+	HistoryProjector
+
+	authPluginFactory                  = "NewFilter"
+	decoratorPluginFactory             = "NewDecorator"
+	pluginFactory                      = "NewPluginFactory"
+	historyViewPluginFactory           = "NewHistoryView"
+	historyCommitListenerPluginFactory = "NewHistoryCommitListener"
+	historyProjectorPluginFactory      = "NewHistoryProjector"
 )
 
 type registry struct {
@@ -51,6 +61,10 @@ type registry struct {
 	decorators []decoration.Decorator
 	endorsers  map[string]endorsement2.PluginFactory
 	validators map[string]validation.PluginFactory
+
+	historyViews           []ledger.HistoryView
+	historyCommitListeners []ledger.HistoryCommitListener
+	historyProjectors      []ledger.HistoryProjector
 }
 
 var (
@@ -87,6 +101,16 @@ func (r *registry) loadHandlers(c Config) {
 	for chaincodeID, config := range c.Validators {
 		r.evaluateModeAndLoad(config, Validation, chaincodeID)
 	}
+
+	for _, config := range c.HistoryViews {
+		r.evaluateModeAndLoad(config, HistoryView)
+	}
+	for _, config := range c.HistoryCommitListeners {
+		r.evaluateModeAndLoad(config, HistoryCommitListener)
+	}
+	for _, config := range c.HistoryProjectors {
+		r.evaluateModeAndLoad(config, HistoryProjector)
+	}
 }
 
 // evaluateModeAndLoad if a library path is provided, load the shared object
@@ -123,6 +147,12 @@ func (r *registry) loadCompiled(handlerFactory string, handlerType HandlerType,
 			logger.Panicf("expected 1 argument in extraArgs")
 		}
 		r.validators[extraArgs[0]] = inst.(validation.PluginFactory)
+	} else if handlerType == HistoryView {
+		r.historyViews = append(r.historyViews, inst.(ledger.HistoryView))
+	} else if handlerType == HistoryCommitListener {
+		r.historyCommitListeners = append(r.historyCommitListeners, inst.(ledger.HistoryCommitListener))
+	} else if handlerType == HistoryProjector {
+		r.historyProjectors = append(r.historyProjectors, inst.(ledger.HistoryProjector))
 	}
 }
 
@@ -137,6 +167,12 @@ func (r *registry) Lookup(handlerType HandlerType) interface{} {
 		return r.endorsers
 	} else if handlerType == Validation {
 		return r.validators
+	} else if handlerType == HistoryView {
+		return r.historyViews
+	} else if handlerType == HistoryCommitListener {
+		return r.historyCommitListeners
+	} else if handlerType == HistoryProjector {
+		return r.historyProjectors
 	}
 
 	return nil
@@ -16,6 +16,7 @@ import (
 	"github.com/hyperledger/fabric-protos-go/peer"
 	endorsement "github.com/hyperledger/fabric/core/handlers/endorsement/api"
 	validation "github.com/hyperledger/fabric/core/handlers/validation/api"
+	"github.com/hyperledger/fabric/core/ledger"
 	"github.com/stretchr/testify/require"
 )
 
@@ -24,6 +25,7 @@ const (
 	decoratorPluginPackage = "github.com/hyperledger/fabric/core/handlers/decoration/plugin"
 	endorsementTestPlugin  = "github.com/hyperledger/fabric/core/handlers/endorsement/testdata/"
 	validationTestPlugin   = "github.com/hyperledger/fabric/core/handlers/validation/testdata/"
+	historyPluginPackage   = "github.com/hyperledger/fabric/core/handlers/history/plugin"
 )
 
 var (
@@ -131,6 +133,37 @@ func TestValidationPlugin(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestHistoryPlugins(t *testing.T) {
+	if noplugin {
+		t.Skip("plugins disabled")
+	}
+
+	testDir := t.TempDir()
+
+	pluginPath := filepath.Join(testDir, "historyplugin.so")
+	buildPlugin(t, pluginPath, historyPluginPackage)
+
+	testReg := registry{}
+	testReg.loadPlugin(pluginPath, HistoryView)
+	testReg.loadPlugin(pluginPath, HistoryCommitListener)
+	testReg.loadPlugin(pluginPath, HistoryProjector)
+
+	views := testReg.Lookup(HistoryView).([]ledger.HistoryView)
+	require.Len(t, views, 1, "Expected history view to be registered")
+	rows, err := views[0].Project("ns", "key", nil, true)
+	require.NoError(t, err)
+	require.Equal(t, []string{"ns"}, rows)
+
+	listeners := testReg.Lookup(HistoryCommitListener).([]ledger.HistoryCommitListener)
+	require.Len(t, listeners, 1, "Expected history commit listener to be registered")
+	require.NoError(t, listeners[0].HandleHistoryCommit(&ledger.HistoryCommit{LedgerID: "ledger1", BlockNum: 1}))
+
+	projectors := testReg.Lookup(HistoryProjector).([]ledger.HistoryProjector)
+	require.Len(t, projectors, 1, "Expected history projector to be registered")
+	require.NoError(t, projectors[0].Apply("ledger1", 1, []*ledger.HistoryKeyChange{{Namespace: "ns", Key: "key"}}))
+	require.NoError(t, projectors[0].Reset("ledger1", 1))
+}
+
 func TestLoadPluginInvalidPath(t *testing.T) {
 	if noplugin {
 		t.Skip("plugins disabled")
@@ -11,6 +11,7 @@ import (
 
 	"github.com/hyperledger/fabric/core/handlers/auth"
 	"github.com/hyperledger/fabric/core/handlers/decoration"
+	"github.com/hyperledger/fabric/core/ledger"
 	"github.com/stretchr/testify/require"
 )
 
@@ -31,6 +32,16 @@ func TestInitRegistry(t *testing.T) {
 	decorators, isDecorators := decorationHandlers.([]decoration.Decorator)
 	require.True(t, isDecorators)
 	require.Len(t, decorators, 1)
+
+	historyViews, isHistoryViews := r.Lookup(HistoryView).([]ledger.HistoryView)
+	require.True(t, isHistoryViews)
+	require.Empty(t, historyViews)
+	historyCommitListeners, isHistoryCommitListeners := r.Lookup(HistoryCommitListener).([]ledger.HistoryCommitListener)
+	require.True(t, isHistoryCommitListeners)
+	require.Empty(t, historyCommitListeners)
+	historyProjectors, isHistoryProjectors := r.Lookup(HistoryProjector).([]ledger.HistoryProjector)
+	require.True(t, isHistoryProjectors)
+	require.Empty(t, historyProjectors)
 }
 
 func TestLoadCompiledInvalid(t *testing.T) {
@@ -0,0 +1,128 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package kvledger
+
+import (
+	"github.com/hyperledger/fabric/common/ledger/util"
+	"github.com/hyperledger/fabric/common/ledger/util/leveldbhelper"
+	"github.com/pkg/errors"
+)
+
+var commitIntentKeyPrefix = []byte("i")
+
+// commitJournal is a write-ahead journal that records the intent to commit a block to both the block store and
+// the history database. The intent is recorded, under a key per block, before the block is added to the block store
+// and is cleared once the block is committed to the history database. As the history commit may lag behind the block
+// commits (the group commit and the asynchronous commit), more than one intent may be pending at a time. The intents
+// pending on the ledger startup identify the blocks for which the commit was interrupted and hence, the repair is
+// driven by the journal rather than being inferred from a comparison of the savepoints.
+type commitJournal struct {
+	ledgerID string
+	dbHandle *leveldbhelper.DBHandle
+}
+
+func newCommitJournal(ledgerID string, dbHandle *leveldbhelper.DBHandle) *commitJournal {
+	return &commitJournal{
+		ledgerID: ledgerID,
+		dbHandle: dbHandle,
+	}
+}
+
+// recordIntent records the intent to commit the given block. The put is not synced to the disk, so that the block
+// commits do not wait for an additional fsync. The intent survives a crash of the peer process, as the write is in
+// the leveldb log, but may be lost in a crash of the host. A lost intent leaves the repair to the comparison of the
+// savepoints on the ledger startup (see function `recoverDBs`), which recommits the blocks missing from the history
+// database all the same
+func (j *commitJournal) recordIntent(blockNum uint64) error {
+	return j.dbHandle.Put(commitIntentKey(blockNum), []byte{}, false)
+}
+
+// clearIntents clears the intents recorded for the blocks up to the given block. The intents recorded for the
+// subsequent blocks are retained. The deletes are not synced to the disk, as a stale intent for a block that is
+// committed to both the stores is a no-op for the repair
+func (j *commitJournal) clearIntents(uptoBlockNum uint64) error {
+	blockNums, err := j.intentsBefore(commitIntentKey(uptoBlockNum + 1))
+	if err != nil || len(blockNums) == 0 {
+		return err
+	}
+	batch := j.dbHandle.NewUpdateBatch()
+	for _, blockNum := range blockNums {
+		batch.Delete(commitIntentKey(blockNum))
+	}
+	return j.dbHandle.WriteBatch(batch, false)
+}
+
+// pendingIntents returns, in increasing order, the blocks for which the intents are recorded and not yet cleared
+func (j *commitJournal) pendingIntents() ([]uint64, error) {
+	return j.intentsBefore(append(commitIntentKeyPrefix[:1:1], 0xff))
+}
+
+func (j *commitJournal) intentsBefore(endKey []byte) ([]uint64, error) {
+	itr, err := j.dbHandle.GetIterator(commitIntentKeyPrefix, endKey)
+	if err != nil {
+		return nil, err
+	}
+	defer itr.Release()
+	var blockNums []uint64
+	for itr.Next() {
+		blockNum, _, err := util.DecodeOrderPreservingVarUint64(itr.Key()[len(commitIntentKeyPrefix):])
+		if err != nil {
+			return nil, errors.WithMessagef(err, "error while decoding the commit intent for ledger [%s]", j.ledgerID)
+		}
+		blockNums = append(blockNums, blockNum)
+	}
+	return blockNums, itr.Error()
+}
+
+func commitIntentKey(blockNum uint64) []byte {
+	return append(commitIntentKeyPrefix[:1:1], util.EncodeOrderPreservingVarUint64(blockNum)...)
+}
+
+// repairFromCommitJournal completes the commit of the blocks recorded in the pending intents, if any. The intents
+// for the blocks not present in the block store are discarded, as the commit was interrupted before the block store
+// was updated and there is nothing to repair. The blocks present in the block store, along with any prior block
+// missing from the history database, are recommitted to the history database. In either case, the intents are
+// cleared.
+func (l *kvLedger) repairFromCommitJournal() error {
+	blockNums, err := l.commitJournal.pendingIntents()
+	if err != nil || len(blockNums) == 0 {
+		return err
+	}
+	info, err := l.blockStore.GetBlockchainInfo()
+	if err != nil {
+		return err
+	}
+	lastPendingBlockNum := blockNums[len(blockNums)-1]
+	var toRepair []uint64
+	for _, blockNum := range blockNums {
+		if blockNum >= info.Height {
+			logger.Infow("Discarding the commit intent for the block not present in the block store",
+				"channel", l.ledgerID, "blockNum", blockNum, "blockStoreHeight", info.Height)
+			continue
+		}
+		toRepair = append(toRepair, blockNum)
+	}
+	if l.historyDB != nil && len(toRepair) > 0 {
+		lastBlockToRepair := toRepair[len(toRepair)-1]
+		savepoint, err := l.historyDB.GetLastSavepoint()
+		if err != nil {
+			return err
+		}
+		var nextBlockInHistory uint64
+		if savepoint != nil {
+			nextBlockInHistory = savepoint.BlockNum + 1
+		}
+		if nextBlockInHistory <= lastBlockToRepair {
+			logger.Infow("Repairing the history database from the commit journal",
+				"channel", l.ledgerID, "pendingBlocks", toRepair, "nextBlockInHistory", nextBlockInHistory)
+			if err := l.recommitLostBlocks(nextBlockInHistory, lastBlockToRepair, l.historyDB); err != nil {
+				return err
+			}
+		}
+	}
+	return l.commitJournal.clearIntents(lastPendingBlockNum)
+}
@@ -0,0 +1,102 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package kvledger
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/ledger/testutil"
+	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommitJournal(t *testing.T) {
+	conf := testConfig(t)
+	nsCollBtlConfs := []*nsCollBtlConfig{
+		{
+			namespace: "ns",
+			btlConfig: map[string]uint64{"coll": 0},
+		},
+	}
+	provider1 := testutilNewProviderWithCollectionConfig(t, nsCollBtlConfs, conf)
+	defer provider1.Close()
+
+	bg, gb := testutil.NewBlockGenerator(t, "testLedger", false)
+	ledger1, err := provider1.CreateFromGenesisBlock(gb)
+	require.NoError(t, err)
+	defer ledger1.Close()
+	kvLedger1 := ledger1.(*kvLedger)
+
+	blockAndPvtdata1 := prepareNextBlockForTest(t, ledger1, bg, "SimulateForBlk1",
+		map[string]string{"key1": "value1.1"}, map[string]string{"key1": "pvtValue1.1"})
+	require.NoError(t, ledger1.CommitLegacy(blockAndPvtdata1, &ledger.CommitOptions{}))
+	pendingBlockNums, err := kvLedger1.commitJournal.pendingIntents()
+	require.NoError(t, err)
+	require.Empty(t, pendingBlockNums)
+
+	// the peer records the intents for blocks 2 and 3 and fails after committing the blocks to the block store and
+	// the state database but before committing the blocks to the history database, as with the asynchronous commit
+	for i, value := range []string{"value1.2", "value1.3"} {
+		blockAndPvtdata := prepareNextBlockForTest(t, ledger1, bg, fmt.Sprintf("SimulateForBlk%d", i+2),
+			map[string]string{"key1": value}, map[string]string{"key1": "pvt" + value})
+		_, _, _, err = kvLedger1.txmgr.ValidateAndPrepare(blockAndPvtdata, true)
+		require.NoError(t, err)
+		require.NoError(t, kvLedger1.commitJournal.recordIntent(uint64(i+2)))
+		require.NoError(t, kvLedger1.commitToPvtAndBlockStore(blockAndPvtdata, nil))
+		require.NoError(t, kvLedger1.txmgr.Commit())
+	}
+	pendingBlockNums, err = kvLedger1.commitJournal.pendingIntents()
+	require.NoError(t, err)
+	require.Equal(t, []uint64{2, 3}, pendingBlockNums)
+	checkBCSummaryForTest(t, ledger1,
+		&bcSummary{
+			stateDBSavePoint:   uint64(3),
+			historyDBSavePoint: uint64(1),
+		},
+	)
+	ledger1.Close()
+	provider1.Close()
+
+	// the history database is repaired from the journal upon reopening the ledger
+	provider2 := testutilNewProviderWithCollectionConfig(t, nsCollBtlConfs, conf)
+	defer provider2.Close()
+	ledger2, err := provider2.Open("testLedger")
+	require.NoError(t, err)
+	defer ledger2.Close()
+	checkBCSummaryForTest(t, ledger2,
+		&bcSummary{
+			stateDBSavePoint:   uint64(3),
+			historyDBSavePoint: uint64(3),
+			historyKey:         "key1",
+			historyVals:        []string{"value1.3", "value1.2", "value1.1"},
+		},
+	)
+	kvLedger2 := ledger2.(*kvLedger)
+	pendingBlockNums, err = kvLedger2.commitJournal.pendingIntents()
+	require.NoError(t, err)
+	require.Empty(t, pendingBlockNums)
+
+	// clearing the intents up to a block retains the intents for the subsequent blocks
+	require.NoError(t, kvLedger2.commitJournal.recordIntent(4))
+	require.NoError(t, kvLedger2.commitJournal.recordIntent(5))
+	require.NoError(t, kvLedger2.commitJournal.clearIntents(3))
+	pendingBlockNums, err = kvLedger2.commitJournal.pendingIntents()
+	require.NoError(t, err)
+	require.Equal(t, []uint64{4, 5}, pendingBlockNums)
+
+	// the intents for the blocks that did not reach the block store are discarded
+	require.NoError(t, kvLedger2.repairFromCommitJournal())
+	pendingBlockNums, err = kvLedger2.commitJournal.pendingIntents()
+	require.NoError(t, err)
+	require.Empty(t, pendingBlockNums)
+	checkBCSummaryForTest(t, ledger2,
+		&bcSummary{
+			historyDBSavePoint: uint64(3),
+		},
+	)
+}
@@ -0,0 +1,133 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package kvledger
+
+import (
+	"time"
+
+	"github.com/hyperledger/fabric/common/ledger/blkstorage"
+	"github.com/hyperledger/fabric/common/ledger/util/leveldbhelper"
+	"github.com/hyperledger/fabric/common/metrics/disabled"
+	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/history"
+	"github.com/pkg/errors"
+)
+
+// CompactHistory compacts the history DB of a ledger for the given namespace, or all the history of the ledger if
+// the namespace is empty, so that the space held by the deleted entries is reclaimed. This function is to be invoked
+// while the peer is shut down. For a running peer, the history is compacted via the admin API of the history.
+func CompactHistory(config *ledger.Config, ledgerID, namespace string) error {
+	if !config.HistoryDBConfig.Enabled {
+		return errors.New("history database not enabled")
+	}
+	fileLock := leveldbhelper.NewFileLock(fileLockPath(config.RootFSPath))
+	if err := fileLock.Lock(); err != nil {
+		return errors.WithMessage(err, "as another peer node command is executing,"+
+			" wait for that command to complete its execution or terminate it before retrying")
+	}
+	defer fileLock.Unlock()
+
+	blkStoreProvider, err := blkstorage.NewProvider(
+		blkstorage.NewConf(
+			BlockStorePath(config.RootFSPath),
+			maxBlockFileSize,
+		),
+		&blkstorage.IndexConfig{AttrsToIndex: attrsToIndex},
+		&disabled.Provider{},
+	)
+	if err != nil {
+		return err
+	}
+	exists, err := blkStoreProvider.Exists(ledgerID)
+	blkStoreProvider.Close()
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return errors.Errorf("ledger [%s] does not exist", ledgerID)
+	}
+
+	historydbProvider, err := history.NewDBProvider(HistoryDBPath(config.RootFSPath))
+	if err != nil {
+		return err
+	}
+	defer historydbProvider.Close()
+
+	reclaimed, err := historydbProvider.GetDBHandle(ledgerID).Compact(namespace)
+	if err != nil {
+		return err
+	}
+	logger.Infow("History has been successfully compacted", "ledgerID", ledgerID, "namespace", namespace,
+		"reclaimedBytes", reclaimed)
+	return nil
+}
+
+// HistoryCompactionStatus is the status of a compaction of the history of a channel started via the admin API
+type HistoryCompactionStatus struct {
+	// Namespace is the namespace whose history is compacted, or empty if all the history of the channel is compacted
+	Namespace string    `json:"namespace"`
+	Running   bool      `json:"running"`
+	StartTime time.Time `json:"startTime"`
+	// EndTime, ReclaimedBytes and Error are set once the compaction completes
+	EndTime        *time.Time `json:"endTime,omitempty"`
+	ReclaimedBytes int64      `json:"reclaimedBytes"`
+	Error          string     `json:"error,omitempty"`
+}
+
+// errHistoryCompactionInProgress is returned when a compaction is requested while another compaction of the history of
+// the ledger is running
+var errHistoryCompactionInProgress = errors.New("a compaction of the history is in progress")
+
+// startHistoryCompaction starts compacting, in the background, the history of the ledger for the given namespace,
+// or all the history of the ledger if the namespace is empty. Only one compaction runs at a time for a ledger, as the
+// compactions compete with the commits for the disk. The status of the compaction is returned by function
+// `historyCompactionStatus`
+func (l *kvLedger) startHistoryCompaction(namespace string) (*HistoryCompactionStatus, error) {
+	l.historyCompactionLock.Lock()
+	defer l.historyCompactionLock.Unlock()
+	if l.historyCompactionClosed {
+		return nil, errors.Errorf("ledger [%s] is closed", l.ledgerID)
+	}
+	if l.historyCompaction != nil && l.historyCompaction.Running {
+		return nil, errHistoryCompactionInProgress
+	}
+	l.historyCompaction = &HistoryCompactionStatus{
+		Namespace: namespace,
+		Running:   true,
+		StartTime: time.Now(),
+	}
+	status := *l.historyCompaction
+	l.historyCompactionWG.Add(1)
+	go func() {
+		defer l.historyCompactionWG.Done()
+		reclaimed, err := l.historyDB.Compact(namespace)
+		if err != nil {
+			logger.Errorw("Error while compacting history", "channel", l.ledgerID, "namespace", namespace, "error", err)
+		}
+		l.historyCompactionLock.Lock()
+		defer l.historyCompactionLock.Unlock()
+		endTime := time.Now()
+		l.historyCompaction.Running = false
+		l.historyCompaction.EndTime = &endTime
+		l.historyCompaction.ReclaimedBytes = reclaimed
+		if err != nil {
+			l.historyCompaction.Error = err.Error()
+		}
+	}()
+	return &status, nil
+}
+
+// historyCompactionStatus returns the status of the last compaction started via the admin API, if any
+func (l *kvLedger) historyCompactionStatus() *HistoryCompactionStatus {
+	l.historyCompactionLock.Lock()
+	defer l.historyCompactionLock.Unlock()
+	if l.historyCompaction == nil {
+		return nil
+	}
+	status := *l.historyCompaction
+	return &status
+}
@@ -0,0 +1,74 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package kvledger
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/hyperledger/fabric/common/ledger/testutil"
+	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/hyperledger/fabric/core/ledger/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompactHistory(t *testing.T) {
+	conf := testConfig(t)
+	provider := testutilNewProvider(conf, t, &mock.DeployedChaincodeInfoProvider{})
+
+	blkGenerator, genesisBlk := testutil.NewBlockGenerator(t, "testLedgerid", false)
+	lgr, err := provider.CreateFromGenesisBlock(genesisBlk)
+	require.NoError(t, err)
+	kvlgr := lgr.(*kvLedger)
+	for i, value := range []string{"value1.1", "value1.2"} {
+		blockAndPvtdata := prepareNextBlockForTest(t, kvlgr, blkGenerator, fmt.Sprintf("SimulateForBlk%d", i+1),
+			map[string]string{"key1": value},
+			nil,
+		)
+		require.NoError(t, kvlgr.CommitLegacy(blockAndPvtdata, &ledger.CommitOptions{}))
+	}
+
+	_, err = kvlgr.historyDB.Compact("ns")
+	require.NoError(t, err)
+
+	// the offline compaction fails while the provider is open
+	err = CompactHistory(conf, "testLedgerid", "ns")
+	require.ErrorContains(t, err, "as another peer node command is executing")
+	lgr.Close()
+	provider.Close()
+
+	require.EqualError(t, CompactHistory(conf, "non-existing-ledger", "ns"), "ledger [non-existing-ledger] does not exist")
+	require.NoError(t, CompactHistory(conf, "testLedgerid", "ns"))
+	require.NoError(t, CompactHistory(conf, "testLedgerid", ""))
+
+	// the compaction leaves the history intact
+	provider = testutilNewProvider(conf, t, &mock.DeployedChaincodeInfoProvider{})
+	defer provider.Close()
+	lgr, err = provider.Open("testLedgerid")
+	require.NoError(t, err)
+	defer lgr.Close()
+	hqe, err := lgr.NewHistoryQueryExecutor()
+	require.NoError(t, err)
+	itr, err := hqe.GetHistoryForKey("ns", "key1")
+	require.NoError(t, err)
+	defer itr.Close()
+	for _, expectedValue := range []string{"value1.2", "value1.1"} {
+		res, err := itr.Next()
+		require.NoError(t, err)
+		require.Equal(t, expectedValue, string(res.(*queryresult.KeyModification).Value))
+	}
+	res, err := itr.Next()
+	require.NoError(t, err)
+	require.Nil(t, res)
+}
+
+func TestCompactHistoryDisabled(t *testing.T) {
+	conf := testConfig(t)
+	conf.HistoryDBConfig.Enabled = false
+	require.EqualError(t, CompactHistory(conf, "testLedgerid", "ns"), "history database not enabled")
+}
@@ -0,0 +1,186 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"encoding/binary"
+	"path/filepath"
+
+	"github.com/hyperledger/fabric/common/ledger/util/leveldbhelper"
+	"github.com/hyperledger/fabric/internal/fileutil"
+	"github.com/pkg/errors"
+)
+
+// BackfillProgress captures the progress of backfilling the history for the blocks that precede
+// the snapshot from which the ledger was bootstrapped
+type BackfillProgress struct {
+	EntriesProcessed uint64
+	TotalEntries     uint64
+	Done             bool
+}
+
+// ErrBackfillStopped is returned by function `Backfill` if the backfill is stopped before completion
+var ErrBackfillStopped = errors.New("history backfill stopped")
+
+// HasBackfillArchive returns true if the given dir contains a history archive, i.e., the history
+// files produced by function `ExportHistory`
+func HasBackfillArchive(dir string) (bool, error) {
+	exist, _, err := fileutil.FileExists(filepath.Join(dir, snapshotDataFileName))
+	return exist, err
+}
+
+// Backfill imports the history entries for the blocks up to and including lastBlockInSnapshot from the
+// history archive present in the given dir. The archive is expected to be the history files of a snapshot
+// of the same channel that is generated by a peer with a complete history (i.e., a peer with the config
+// `ledger.history.includeInSnapshots` enabled and not bootstrapped from a snapshot itself), at a height
+// same as or higher than the snapshot from which this ledger was bootstrapped. The entries beyond lastBlockInSnapshot
+// are skipped, as this ledger builds the history for the subsequent blocks during the regular block commits.
+//
+// The progress is persisted along with the imported entries so that the backfill resumes from where it was
+// left off when invoked again, for instance, after a peer restart. The backfill checks for the stop signal
+// after writing each batch and returns ErrBackfillStopped if signaled.
+func (d *DB) Backfill(dir string, lastBlockInSnapshot uint64, stop <-chan struct{}) error {
+	progress, err := d.BackfillProgress()
+	if err != nil {
+		return err
+	}
+	if progress.Done {
+		return nil
+	}
+
+	archive, err := openHistoryArchive(dir)
+	if err != nil {
+		return err
+	}
+	defer archive.close()
+
+	if archive.lastBlockNum < lastBlockInSnapshot {
+		return errors.Errorf("history archive is exported at block [%d], which is prior to the snapshot block [%d]",
+			archive.lastBlockNum, lastBlockInSnapshot)
+	}
+	progress.TotalEntries = archive.numEntries
+	logger.Infow("Backfilling history from archive", "channel", d.name, "archiveDir", dir,
+		"entriesProcessed", progress.EntriesProcessed, "totalEntries", progress.TotalEntries)
+
+	// skip the entries that have been processed previously
+	for i := uint64(0); i < progress.EntriesProcessed; i++ {
+		if _, _, err := archive.next(); err != nil {
+			return err
+		}
+	}
+
+	batch := d.levelDB.NewUpdateBatch()
+	for progress.EntriesProcessed < progress.TotalEntries {
+		if err := d.backfillBatch(archive, lastBlockInSnapshot, progress, batch); err != nil {
+			return err
+		}
+		batch.Reset()
+		logger.Infow("Backfilling history from archive", "channel", d.name,
+			"entriesProcessed", progress.EntriesProcessed, "totalEntries", progress.TotalEntries)
+
+		select {
+		case <-stop:
+			if !progress.Done {
+				return ErrBackfillStopped
+			}
+		default:
+		}
+	}
+
+	if !progress.Done {
+		// the archive contains no entries
+		progress.Done = true
+		if err := d.levelDB.Put(backfillProgressKey, progress.toBytes(), true); err != nil {
+			return err
+		}
+	}
+	logger.Infow("Completed backfilling history from archive", "channel", d.name, "totalEntries", progress.TotalEntries)
+	return nil
+}
+
+// backfillBatch writes a batch of entries from the archive, along with the updated progress and index statistics.
+// The statsLock is held while the batch is prepared and written, as the block commits may update the index
+// statistics concurrently
+func (d *DB) backfillBatch(archive *historyArchive, lastBlockInSnapshot uint64, progress *BackfillProgress,
+	batch *leveldbhelper.UpdateBatch) error {
+	d.statsLock.Lock()
+	defer d.statsLock.Unlock()
+	if err := d.flushPendingLocked(); err != nil {
+		return err
+	}
+	statsTracker := newIndexStatsTracker(d)
+
+	for progress.EntriesProcessed < progress.TotalEntries && batch.Size() < importHistoryBatchSize {
+		key, val, err := archive.next()
+		if err != nil {
+			return err
+		}
+		progress.EntriesProcessed++
+
+		ns, k, blockNum, _, err := decodeDataKey(key)
+		if err != nil {
+			return err
+		}
+		if blockNum > lastBlockInSnapshot || !d.isKeyIndexed(ns, k) {
+			continue
+		}
+		batch.Put(key, val)
+		if err := trackEntry(statsTracker, key, val); err != nil {
+			return err
+		}
+		if err := d.addViewRowsForInlineEntry(batch, key, val); err != nil {
+			return err
+		}
+		if err := d.addTxKeyRowForEntry(batch, key); err != nil {
+			return err
+		}
+	}
+
+	progress.Done = progress.EntriesProcessed == progress.TotalEntries
+	batch.Put(backfillProgressKey, progress.toBytes())
+	batchKeys := statsTracker.flush(batch)
+	if err := d.levelDB.WriteBatch(batch, true); err != nil {
+		return err
+	}
+	d.noHistoryCache.invalidate(batchKeys)
+	// the backfill adds the entries below the savepoint and hence, does not advance the indexed height
+	d.queryResultCache.invalidate(batchKeys, 0)
+	return nil
+}
+
+// BackfillProgress returns the persisted progress of the history backfill
+func (d *DB) BackfillProgress() (*BackfillProgress, error) {
+	b, err := d.levelDB.Get(backfillProgressKey)
+	if err != nil {
+		return nil, err
+	}
+	if b == nil {
+		return &BackfillProgress{}, nil
+	}
+	return backfillProgressFromBytes(b)
+}
+
+func (p *BackfillProgress) toBytes() []byte {
+	b := make([]byte, 17)
+	binary.BigEndian.PutUint64(b[0:8], p.EntriesProcessed)
+	binary.BigEndian.PutUint64(b[8:16], p.TotalEntries)
+	if p.Done {
+		b[16] = 1
+	}
+	return b
+}
+
+func backfillProgressFromBytes(b []byte) (*BackfillProgress, error) {
+	if len(b) != 17 {
+		return nil, errors.Errorf("unexpected length of the backfill progress bytes: %d", len(b))
+	}
+	return &BackfillProgress{
+		EntriesProcessed: binary.BigEndian.Uint64(b[0:8]),
+		TotalEntries:     binary.BigEndian.Uint64(b[8:16]),
+		Done:             b[16] == 1,
+	}, nil
+}
@@ -0,0 +1,110 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric/common/ledger/testutil"
+	util2 "github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/ledger/internal/version"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackfill(t *testing.T) {
+	env := newTestHistoryEnv(t)
+	defer env.cleanup()
+	provider := env.testBlockStorageEnv.provider
+	store, err := provider.Open("ledger1")
+	require.NoError(t, err)
+	defer store.Shutdown()
+
+	historydb := env.testHistoryDBProvider.GetDBHandle("ledger1")
+	bg, gb := testutil.NewBlockGenerator(t, "ledger1", false)
+	require.NoError(t, store.AddBlock(gb))
+	require.NoError(t, historydb.Commit(gb))
+	for i := 1; i <= 5; i++ {
+		simulator, err := env.txmgr.NewTxSimulator(util2.GenerateUUID())
+		require.NoError(t, err)
+		require.NoError(t, simulator.SetState("ns1", "key1", []byte{byte(i)}))
+		simulator.Done()
+		simRes, err := simulator.GetTxSimulationResults()
+		require.NoError(t, err)
+		pubSimResBytes, err := simRes.GetPubSimulationBytes()
+		require.NoError(t, err)
+		block := bg.NextBlock([][]byte{pubSimResBytes})
+		require.NoError(t, store.AddBlock(block))
+		require.NoError(t, historydb.Commit(block))
+	}
+
+	// archive covers blocks 1 to 5, whereas the snapshot is taken at block 3
+	archiveDir := t.TempDir()
+	_, err = historydb.ExportHistory(archiveDir, testNewHashFunc, store)
+	require.NoError(t, err)
+	exists, err := HasBackfillArchive(archiveDir)
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	emptyStore, err := provider.Open("ledger1-from-snapshot")
+	require.NoError(t, err)
+	defer emptyStore.Shutdown()
+
+	t.Run("backfill-in-one-go", func(t *testing.T) {
+		p := env.testHistoryDBProvider
+		require.NoError(t, p.MarkStartingSavepoint("ledger1-from-snapshot", version.NewHeight(3, 1)))
+		db := p.GetDBHandle("ledger1-from-snapshot")
+		require.NoError(t, db.Backfill(archiveDir, 3, nil))
+
+		progress, err := db.BackfillProgress()
+		require.NoError(t, err)
+		require.Equal(t, &BackfillProgress{EntriesProcessed: 5, TotalEntries: 5, Done: true}, progress)
+
+		qe, err := db.NewQueryExecutor(emptyStore)
+		require.NoError(t, err)
+		testutilVerifyResults(t, qe, "ns1", "key1", []string{"\x03", "\x02", "\x01"})
+
+		// backfill is a no-op once done
+		require.NoError(t, db.Backfill(archiveDir, 3, nil))
+	})
+
+	t.Run("backfill-resumes-after-stop", func(t *testing.T) {
+		defer func(orig int) { importHistoryBatchSize = orig }(importHistoryBatchSize)
+		importHistoryBatchSize = 1
+
+		p := env.testHistoryDBProvider
+		require.NoError(t, p.MarkStartingSavepoint("ledger1-resumed", version.NewHeight(3, 1)))
+		db := p.GetDBHandle("ledger1-resumed")
+
+		stop := make(chan struct{})
+		close(stop)
+		require.Equal(t, ErrBackfillStopped, db.Backfill(archiveDir, 3, stop))
+		progress, err := db.BackfillProgress()
+		require.NoError(t, err)
+		require.Equal(t, &BackfillProgress{EntriesProcessed: 1, TotalEntries: 5}, progress)
+
+		require.NoError(t, db.Backfill(archiveDir, 3, nil))
+		progress, err = db.BackfillProgress()
+		require.NoError(t, err)
+		require.Equal(t, &BackfillProgress{EntriesProcessed: 5, TotalEntries: 5, Done: true}, progress)
+
+		qe, err := db.NewQueryExecutor(emptyStore)
+		require.NoError(t, err)
+		testutilVerifyResults(t, qe, "ns1", "key1", []string{"\x03", "\x02", "\x01"})
+	})
+
+	t.Run("backfill-progress-not-exported", func(t *testing.T) {
+		db := env.testHistoryDBProvider.GetDBHandle("ledger1-from-snapshot")
+		reexportDir := t.TempDir()
+		filesAndHashes, err := db.ExportHistory(reexportDir, testNewHashFunc, emptyStore)
+		require.NoError(t, err)
+		require.Len(t, filesAndHashes, 2)
+		archive, err := openHistoryArchive(reexportDir)
+		require.NoError(t, err)
+		defer archive.close()
+		require.Equal(t, uint64(3), archive.numEntries)
+	})
+}
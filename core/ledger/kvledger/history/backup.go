@@ -0,0 +1,336 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"hash"
+	"io"
+	"sync/atomic"
+
+	"github.com/hyperledger/fabric/core/ledger/internal/version"
+	"github.com/pkg/errors"
+)
+
+const (
+	backupFormat = byte(1)
+	// maxBackupFieldSize bounds the size of a field while reading a backup, so that a corrupted length does not
+	// cause an unbounded allocation
+	maxBackupFieldSize = 1 << 30
+)
+
+var restoreHistoryBatchSize = 1024 * 1024
+
+// BackupInfo is the summary of an incremental backup of the history, as written by function `Backup`
+type BackupInfo struct {
+	LedgerID string
+	// SinceBlock is the first block whose entries are included in the backup
+	SinceBlock uint64
+	// Savepoint is the savepoint of the historydb at the time of the backup. The next incremental backup is expected
+	// to be taken since the block following the savepoint
+	Savepoint  *version.Height
+	NumEntries uint64
+}
+
+// Backup writes to w the history index entries, along with the rows of the history views, for the blocks starting
+// from sinceBlock up to the savepoint of the historydb, so that the backups can be taken incrementally, each since
+// the block following the savepoint of the previous backup (see `BackupInfo`), instead of copying the historydb.
+// The entries are written as stored, i.e., an entry that is not stored inline is resolved from the block store of
+// the restoring peer. The backup starts with a header carrying the ledger ID, sinceBlock, and the savepoint, and ends
+// with a trailer carrying the number of entries and the SHA-256 digest of the entries.
+func (d *DB) Backup(sinceBlock uint64, w io.Writer) (*BackupInfo, error) {
+	if err := d.Flush(); err != nil {
+		return nil, err
+	}
+	savepoint, err := d.GetLastSavepoint()
+	if err != nil {
+		return nil, err
+	}
+	if savepoint == nil {
+		return nil, errors.Errorf("no history found for ledger [%s]", d.name)
+	}
+	if sinceBlock > savepoint.BlockNum+1 {
+		return nil, errors.Errorf("cannot take a backup since block [%d] as the history savepoint is at block [%d]",
+			sinceBlock, savepoint.BlockNum)
+	}
+
+	bw := &backupWriter{writer: bufio.NewWriter(w), digest: sha256.New()}
+	bw.writeByte(backupFormat)
+	bw.writeBytes([]byte(d.name))
+	bw.writeUvarint(sinceBlock)
+	bw.writeBytes(savepoint.ToBytes())
+
+	itr, err := d.levelDB.GetIterator(nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer itr.Release()
+	info := &BackupInfo{LedgerID: d.name, SinceBlock: sinceBlock, Savepoint: savepoint}
+	for itr.Next() && bw.err == nil {
+		key := itr.Key()
+		blockNum, ok, err := backupEntryBlockNum(key)
+		if err != nil {
+			return nil, err
+		}
+		// the entries beyond the savepoint are committed after the savepoint was read and are left for the next backup
+		if !ok || blockNum < sinceBlock || blockNum > savepoint.BlockNum {
+			continue
+		}
+		bw.writeEntry(key, itr.Value())
+		info.NumEntries++
+	}
+	if err := itr.Error(); err != nil {
+		return nil, errors.Wrap(err, "internal leveldb error while iterating for history entries")
+	}
+	// an empty key, which is not a valid entry key, ends the entries
+	bw.writeBytes(nil)
+	bw.writeUvarint(info.NumEntries)
+	bw.writeBytes(bw.digest.Sum(nil))
+	if bw.err == nil {
+		bw.err = bw.writer.Flush()
+	}
+	if bw.err != nil {
+		return nil, errors.Wrap(bw.err, "error while writing the history backup")
+	}
+	return info, nil
+}
+
+// Restore adds to the historydb the entries from a backup written by function `Backup`, along with the updates to the
+// index statistics, and moves the savepoint to the savepoint of the backup, if ahead. The backup is expected to be
+// for this ledger and since a block not beyond the block following the savepoint, so that the backups are restored in
+// the order they were taken. The entries already present in the historydb are skipped and hence, an interrupted
+// restore is resumed by restoring the same backup again. The savepoint is moved only after the number of entries and
+// the digest in the trailer are verified.
+func (d *DB) Restore(r io.Reader) (*BackupInfo, error) {
+	br := &backupReader{reader: bufio.NewReader(r), digest: sha256.New()}
+	if format := br.readByte(); br.err == nil && format != backupFormat {
+		return nil, errors.Errorf("unexpected history backup format [%d]", format)
+	}
+	ledgerID := string(br.readBytes())
+	sinceBlock := br.readUvarint()
+	savepointBytes := br.readBytes()
+	if br.err != nil {
+		return nil, errors.WithMessage(br.err, "error while reading the history backup header")
+	}
+	backupSavepoint, _, err := version.NewHeightFromBytes(savepointBytes)
+	if err != nil {
+		return nil, errors.WithMessage(err, "invalid savepoint in the history backup header")
+	}
+	if ledgerID != d.name {
+		return nil, errors.Errorf("history backup is for ledger [%s], not for ledger [%s]", ledgerID, d.name)
+	}
+
+	d.statsLock.Lock()
+	defer d.statsLock.Unlock()
+	if err := d.flushPendingLocked(); err != nil {
+		return nil, err
+	}
+	nextBlock, err := d.NextIndexBatchBlock()
+	if err != nil {
+		return nil, err
+	}
+	if sinceBlock > nextBlock {
+		return nil, errors.Errorf("history backup since block [%d] cannot be restored, expected a backup since block [%d] or earlier",
+			sinceBlock, nextBlock)
+	}
+
+	info := &BackupInfo{LedgerID: ledgerID, SinceBlock: sinceBlock, Savepoint: backupSavepoint}
+	statsTracker := newIndexStatsTracker(d)
+	batch := d.levelDB.NewUpdateBatch()
+	writeBatch := func(height uint64) error {
+		batchKeys := statsTracker.flush(batch)
+		if err := d.levelDB.WriteBatch(batch, true); err != nil {
+			return err
+		}
+		d.noHistoryCache.invalidate(batchKeys)
+		d.queryResultCache.invalidate(batchKeys, height)
+		if height > 0 {
+			atomic.StoreUint64(&d.appliedHeight, height)
+		}
+		batch.Reset()
+		return nil
+	}
+	for {
+		key, val := br.readEntry()
+		if br.err != nil {
+			return nil, errors.WithMessagef(br.err, "error while reading history backup entry [%d]", info.NumEntries+1)
+		}
+		if len(key) == 0 {
+			break
+		}
+		info.NumEntries++
+		blockNum, ok, err := backupEntryBlockNum(key)
+		if err != nil {
+			return nil, err
+		}
+		if !ok || blockNum < sinceBlock || blockNum > backupSavepoint.BlockNum {
+			return nil, errors.Errorf("invalid history backup entry for key [%x], not an entry for blocks [%d] to [%d]",
+				key, sinceBlock, backupSavepoint.BlockNum)
+		}
+		existing, err := d.levelDB.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil {
+			continue
+		}
+		batch.Put(key, val)
+		if isDataKey(key) {
+			if err := trackEntry(statsTracker, key, val); err != nil {
+				return nil, err
+			}
+			if err := clearValueState(batch, key); err != nil {
+				return nil, err
+			}
+		}
+		if batch.Size() >= restoreHistoryBatchSize {
+			if err := writeBatch(0); err != nil {
+				return nil, err
+			}
+		}
+	}
+	digest := br.digest.Sum(nil)
+	numEntries := br.readUvarint()
+	expectedDigest := br.readBytes()
+	if br.err != nil {
+		return nil, errors.WithMessage(br.err, "error while reading the history backup trailer")
+	}
+	if numEntries != info.NumEntries || !bytes.Equal(digest, expectedDigest) {
+		return nil, errors.Errorf("history backup is corrupted, the trailer records [%d] entries with digest [%x], whereas the backup contains [%d] entries with digest [%x]",
+			numEntries, expectedDigest, info.NumEntries, digest)
+	}
+
+	height := uint64(0)
+	if backupSavepoint.BlockNum+1 > nextBlock {
+		batch.Put(savePointKey, backupSavepoint.ToBytes())
+		height = backupSavepoint.BlockNum + 1
+	}
+	if err := writeBatch(height); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// backupEntryBlockNum returns the block number of a history index entry, of a row of a history view or of the
+// transaction index, or of a recorded value size, and false for the other keys, which are not included in the backups
+func backupEntryBlockNum(key []byte) (uint64, bool, error) {
+	switch {
+	case isDataKey(key):
+		_, _, blockNum, _, err := decodeDataKey(key)
+		return blockNum, err == nil, err
+	case bytes.HasPrefix(key, viewRowKeyPrefix):
+		dataKey, err := decodeViewRowKey(key)
+		if err != nil {
+			return 0, false, err
+		}
+		_, _, blockNum, _, err := decodeDataKey(dataKey)
+		return blockNum, err == nil, err
+	case bytes.HasPrefix(key, txKeyKeyPrefix):
+		blockNum, _, _, err := decodeTxKeyRowKey(key)
+		return blockNum, err == nil, err
+	case bytes.HasPrefix(key, valueSizeKeyPrefix):
+		_, _, blockNum, _, err := decodeDataKey(decodeValueSizeKey(key))
+		return blockNum, err == nil, err
+	default:
+		return 0, false, nil
+	}
+}
+
+// backupWriter writes the fields of a backup, recording the first error encountered. The entries are added to the digest
+type backupWriter struct {
+	writer *bufio.Writer
+	digest hash.Hash
+	err    error
+}
+
+func (w *backupWriter) writeByte(b byte) {
+	if w.err == nil {
+		w.err = w.writer.WriteByte(b)
+	}
+}
+
+func (w *backupWriter) writeUvarint(n uint64) {
+	if w.err == nil {
+		var buf [binary.MaxVarintLen64]byte
+		_, w.err = w.writer.Write(buf[:binary.PutUvarint(buf[:], n)])
+	}
+}
+
+func (w *backupWriter) writeBytes(b []byte) {
+	w.writeUvarint(uint64(len(b)))
+	if w.err == nil {
+		_, w.err = w.writer.Write(b)
+	}
+}
+
+func (w *backupWriter) writeEntry(key, val []byte) {
+	w.writeBytes(key)
+	w.writeBytes(val)
+	writeDigestField(w.digest, key)
+	writeDigestField(w.digest, val)
+}
+
+// backupReader reads the fields of a backup, recording the first error encountered. The entries are added to the digest
+type backupReader struct {
+	reader *bufio.Reader
+	digest hash.Hash
+	err    error
+}
+
+func (r *backupReader) readByte() byte {
+	if r.err != nil {
+		return 0
+	}
+	var b byte
+	b, r.err = r.reader.ReadByte()
+	return b
+}
+
+func (r *backupReader) readUvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	var n uint64
+	n, r.err = binary.ReadUvarint(r.reader)
+	return n
+}
+
+func (r *backupReader) readBytes() []byte {
+	n := r.readUvarint()
+	if r.err != nil {
+		return nil
+	}
+	if n > maxBackupFieldSize {
+		r.err = errors.Errorf("invalid field length [%d]", n)
+		return nil
+	}
+	b := make([]byte, n)
+	_, r.err = io.ReadFull(r.reader, b)
+	return b
+}
+
+// readEntry reads an entry, or the empty key that ends the entries
+func (r *backupReader) readEntry() ([]byte, []byte) {
+	key := r.readBytes()
+	if r.err != nil || len(key) == 0 {
+		return nil, nil
+	}
+	val := r.readBytes()
+	writeDigestField(r.digest, key)
+	writeDigestField(r.digest, val)
+	return key, val
+}
+
+func writeDigestField(h hash.Hash, b []byte) {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(len(b)))
+	h.Write(buf[:])
+	h.Write(b)
+}
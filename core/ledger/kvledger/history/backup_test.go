@@ -0,0 +1,142 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/ledger/testutil"
+	util2 "github.com/hyperledger/fabric/common/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackupAndRestore(t *testing.T) {
+	env := newTestHistoryEnv(t)
+	defer env.cleanup()
+	store, err := env.testBlockStorageEnv.provider.Open("ledger1")
+	require.NoError(t, err)
+	defer store.Shutdown()
+
+	source := env.testHistoryDBProvider.GetDBHandle("ledger1")
+	bg, gb := testutil.NewBlockGenerator(t, "ledger1", false)
+	require.NoError(t, store.AddBlock(gb))
+	require.NoError(t, source.Commit(gb))
+	commitBlock := func(i int) {
+		simulator, err := env.txmgr.NewTxSimulator(util2.GenerateUUID())
+		require.NoError(t, err)
+		require.NoError(t, simulator.SetState("ns1", "key1", []byte{byte(i)}))
+		require.NoError(t, simulator.SetState("ns2", "key2", []byte{byte(i)}))
+		simulator.Done()
+		simRes, err := simulator.GetTxSimulationResults()
+		require.NoError(t, err)
+		pubSimResBytes, err := simRes.GetPubSimulationBytes()
+		require.NoError(t, err)
+		block := bg.NextBlock([][]byte{pubSimResBytes})
+		require.NoError(t, store.AddBlock(block))
+		require.NoError(t, source.Commit(block))
+	}
+	for i := 1; i <= 3; i++ {
+		commitBlock(i)
+	}
+
+	// a full backup followed by an incremental backup since the savepoint of the full backup
+	full := &bytes.Buffer{}
+	fullInfo, err := source.Backup(0, full)
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), fullInfo.Savepoint.BlockNum)
+	require.Equal(t, uint64(6), fullInfo.NumEntries)
+	for i := 4; i <= 5; i++ {
+		commitBlock(i)
+	}
+	incremental := &bytes.Buffer{}
+	incrementalInfo, err := source.Backup(fullInfo.Savepoint.BlockNum+1, incremental)
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), incrementalInfo.SinceBlock)
+	require.Equal(t, uint64(5), incrementalInfo.Savepoint.BlockNum)
+	require.Equal(t, uint64(4), incrementalInfo.NumEntries)
+
+	_, err = source.Backup(7, &bytes.Buffer{})
+	require.EqualError(t, err, "cannot take a backup since block [7] as the history savepoint is at block [5]")
+
+	targetProvider, err := NewDBProvider(t.TempDir())
+	require.NoError(t, err)
+	defer targetProvider.Close()
+	target := targetProvider.GetDBHandle("ledger1")
+
+	// the incremental backup cannot be restored before the full backup
+	_, err = target.Restore(bytes.NewReader(incremental.Bytes()))
+	require.EqualError(t, err, "history backup since block [4] cannot be restored, expected a backup since block [0] or earlier")
+
+	// an interrupted restore leaves the savepoint unchanged and is resumed by restoring the backup again
+	restoreHistoryBatchSize = 1
+	defer func() { restoreHistoryBatchSize = 1024 * 1024 }()
+	_, err = target.Restore(bytes.NewReader(full.Bytes()[:full.Len()-20]))
+	require.Error(t, err)
+	savepoint, err := target.GetLastSavepoint()
+	require.NoError(t, err)
+	require.Nil(t, savepoint)
+	info, err := target.Restore(bytes.NewReader(full.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, fullInfo, info)
+	info, err = target.Restore(bytes.NewReader(incremental.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, incrementalInfo, info)
+
+	sourceSavepoint, err := source.GetLastSavepoint()
+	require.NoError(t, err)
+	targetSavepoint, err := target.GetLastSavepoint()
+	require.NoError(t, err)
+	require.Equal(t, sourceSavepoint, targetSavepoint)
+	var sourceKeys, targetKeys [][]byte
+	require.NoError(t, source.ScanIndex("", "", func(e *IndexEntry) error {
+		sourceKeys = append(sourceKeys, e.RawKey)
+		return nil
+	}))
+	require.NoError(t, target.ScanIndex("", "", func(e *IndexEntry) error {
+		targetKeys = append(targetKeys, e.RawKey)
+		return nil
+	}))
+	require.Equal(t, sourceKeys, targetKeys)
+	for _, ns := range []string{"ns1", "ns2"} {
+		sourceStats, err := source.GetIndexStats(ns)
+		require.NoError(t, err)
+		targetStats, err := target.GetIndexStats(ns)
+		require.NoError(t, err)
+		require.Equal(t, uint64(5), targetStats.TotalIndexEntries)
+		require.Equal(t, sourceStats.DistinctKeys, targetStats.DistinctKeys)
+		require.Equal(t, sourceStats.LastIndexedBlock, targetStats.LastIndexedBlock)
+	}
+
+	// the restored entries are resolved from the block store
+	qe, err := target.NewQueryExecutor(store)
+	require.NoError(t, err)
+	testutilVerifyResults(t, qe, "ns1", "key1", []string{"\x05", "\x04", "\x03", "\x02", "\x01"})
+
+	// restoring a backup again does not change the history
+	_, err = target.Restore(bytes.NewReader(full.Bytes()))
+	require.NoError(t, err)
+	targetStats, err := target.GetIndexStats("ns1")
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), targetStats.TotalIndexEntries)
+	targetSavepoint, err = target.GetLastSavepoint()
+	require.NoError(t, err)
+	require.Equal(t, sourceSavepoint, targetSavepoint)
+
+	t.Run("invalid-backups", func(t *testing.T) {
+		_, err := targetProvider.GetDBHandle("ledger2").Restore(bytes.NewReader(full.Bytes()))
+		require.EqualError(t, err, "history backup is for ledger [ledger1], not for ledger [ledger2]")
+
+		tampered := append([]byte(nil), full.Bytes()...)
+		tampered[len(tampered)-1] ^= 0xff
+		_, err = target.Restore(bytes.NewReader(tampered))
+		require.Contains(t, err.Error(), "history backup is corrupted, the trailer records [6] entries with digest")
+
+		_, err = target.Restore(bytes.NewReader([]byte{2}))
+		require.EqualError(t, err, "unexpected history backup format [2]")
+	})
+}
@@ -0,0 +1,262 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric/common/flogging"
+	"github.com/hyperledger/fabric/common/ledger/blkstorage"
+	"github.com/hyperledger/fabric/common/ledger/testutil"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/rwsetutil"
+	"github.com/hyperledger/fabric/protoutil"
+	"github.com/stretchr/testify/require"
+)
+
+// The benchmarks in this file measure the throughput of the history index writes and the latency of the
+// history queries for synthetic workloads. The workload can be tuned via the following flags, for instance
+//
+//	go test -run=^$ -bench=. -benchBlocks=1000 -benchNumKeys=100000 -benchZipfS=1.5 ./core/ledger/kvledger/history
+//
+// Each benchmark runs for the key distributions "uniform" (all the keys are equally likely to be written),
+// "zipf" (a few hot keys receive most of the writes, as controlled by benchZipfS), and "sequential" (each key
+// is written once before any key is written again). The results can be compared across releases via benchstat.
+var (
+	benchNumKeys     = flag.Int("benchNumKeys", 10000, "number of distinct keys in the synthetic workload")
+	benchBlocks      = flag.Int("benchBlocks", 200, "number of blocks committed before measuring the query latency")
+	benchTxsPerBlock = flag.Int("benchTxsPerBlock", 10, "number of transactions in each synthetic block")
+	benchWritesPerTx = flag.Int("benchWritesPerTx", 4, "number of keys written by each synthetic transaction")
+	benchValueSize   = flag.Int("benchValueSize", 200, "size of the values written by the synthetic transactions")
+	benchZipfS       = flag.Float64("benchZipfS", 1.1, "skew (s > 1) of the zipf key distribution")
+)
+
+const benchNamespace = "benchns"
+
+// keyDistribution returns the index of the next key to write
+type keyDistribution func() int
+
+var keyDistributions = []struct {
+	name string
+	new  func(r *rand.Rand, numKeys int) keyDistribution
+}{
+	{
+		name: "uniform",
+		new: func(r *rand.Rand, numKeys int) keyDistribution {
+			return func() int { return r.Intn(numKeys) }
+		},
+	},
+	{
+		name: "zipf",
+		new: func(r *rand.Rand, numKeys int) keyDistribution {
+			z := rand.NewZipf(r, *benchZipfS, 1, uint64(numKeys-1))
+			return func() int { return int(z.Uint64()) }
+		},
+	},
+	{
+		name: "sequential",
+		new: func(r *rand.Rand, numKeys int) keyDistribution {
+			next := -1
+			return func() int {
+				next = (next + 1) % numKeys
+				return next
+			}
+		},
+	},
+}
+
+// syntheticBlockGenerator generates blocks with transactions that write the keys picked by a key distribution
+type syntheticBlockGenerator struct {
+	nextKey      keyDistribution
+	value        []byte
+	nextBlockNum uint64
+	previousHash []byte
+}
+
+func newSyntheticBlockGenerator(nextKey keyDistribution) *syntheticBlockGenerator {
+	return &syntheticBlockGenerator{
+		nextKey: nextKey,
+		value:   make([]byte, *benchValueSize),
+	}
+}
+
+func (g *syntheticBlockGenerator) nextBlock(b *testing.B) *common.Block {
+	var envs []*common.Envelope
+	for i := 0; i < *benchTxsPerBlock; i++ {
+		rwsetBuilder := rwsetutil.NewRWSetBuilder()
+		for j := 0; j < *benchWritesPerTx; j++ {
+			rwsetBuilder.AddToWriteSet(benchNamespace, benchKey(g.nextKey()), g.value)
+		}
+		simRes, err := rwsetBuilder.GetTxSimulationResults()
+		require.NoError(b, err)
+		pubSimResBytes, err := simRes.GetPubSimulationBytes()
+		require.NoError(b, err)
+		env, _, err := testutil.ConstructTransactionFromTxDetails(
+			&testutil.TxDetails{
+				TxID:              fmt.Sprintf("tx-%d-%d", g.nextBlockNum, i),
+				ChaincodeName:     benchNamespace,
+				ChaincodeVersion:  "v1",
+				SimulationResults: pubSimResBytes,
+				Type:              common.HeaderType_ENDORSER_TRANSACTION,
+			},
+			false,
+		)
+		require.NoError(b, err)
+		envs = append(envs, env)
+	}
+	block := testutil.NewBlock(envs, g.nextBlockNum, g.previousHash)
+	g.nextBlockNum++
+	g.previousHash = protoutil.BlockHeaderHash(block.Header)
+	return block
+}
+
+func benchKey(i int) string {
+	return fmt.Sprintf("key_%09d", i)
+}
+
+// BenchmarkCommit measures the throughput of committing the synthetic blocks to the historydb. The blocks are
+// generated before the timer starts, so that only the history index writes are measured.
+func BenchmarkCommit(b *testing.B) {
+	defer quietLogging()()
+	for _, dist := range keyDistributions {
+		b.Run(dist.name, func(b *testing.B) {
+			provider, err := NewDBProvider(b.TempDir())
+			require.NoError(b, err)
+			defer provider.Close()
+			db := provider.GetDBHandle("benchledger")
+
+			g := newSyntheticBlockGenerator(dist.new(rand.New(rand.NewSource(1)), *benchNumKeys))
+			blocks := make([]*common.Block, b.N)
+			for i := range blocks {
+				blocks[i] = g.nextBlock(b)
+			}
+
+			b.ResetTimer()
+			startTime := time.Now()
+			for _, block := range blocks {
+				if err := db.Commit(block); err != nil {
+					b.Fatal(err)
+				}
+			}
+			elapsed := time.Since(startTime)
+			b.StopTimer()
+
+			writes := float64(b.N * *benchTxsPerBlock * *benchWritesPerTx)
+			b.ReportMetric(writes/elapsed.Seconds(), "writes/s")
+			b.ReportMetric(float64(b.N)/elapsed.Seconds(), "blocks/s")
+		})
+	}
+}
+
+// BenchmarkQueries measures the latency of the history queries on a historydb populated with benchBlocks
+// synthetic blocks. The keys queried follow the same distribution as the keys written.
+func BenchmarkQueries(b *testing.B) {
+	defer quietLogging()()
+	for _, dist := range keyDistributions {
+		b.Run(dist.name, func(b *testing.B) {
+			db, blockStore, nextKey := populateForQueries(b, dist.new)
+
+			b.Run("GetHistoryForKey", func(b *testing.B) {
+				qe, err := db.NewQueryExecutor(blockStore)
+				require.NoError(b, err)
+				entries := 0
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					itr, err := qe.GetHistoryForKey(benchNamespace, benchKey(nextKey()))
+					if err != nil {
+						b.Fatal(err)
+					}
+					for {
+						res, err := itr.Next()
+						if err != nil {
+							b.Fatal(err)
+						}
+						if res == nil {
+							break
+						}
+						entries++
+					}
+					itr.Close()
+				}
+				b.ReportMetric(float64(entries)/float64(b.N), "entries/op")
+			})
+
+			b.Run("ScanKey", func(b *testing.B) {
+				entries := 0
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					err := db.Scan(benchNamespace, benchKey(nextKey()), blockStore, func(*Entry) error {
+						entries++
+						return nil
+					})
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.ReportMetric(float64(entries)/float64(b.N), "entries/op")
+			})
+
+			b.Run("ScanIndexNamespace", func(b *testing.B) {
+				entries := 0
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					err := db.ScanIndex(benchNamespace, "", func(*IndexEntry) error {
+						entries++
+						return nil
+					})
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.ReportMetric(float64(entries)/float64(b.N), "entries/op")
+			})
+
+			b.Run("GetIndexStats", func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					if _, err := db.GetIndexStats(benchNamespace); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		})
+	}
+}
+
+// populateForQueries commits benchBlocks synthetic blocks to a block store and to a historydb and returns
+// these along with the key distribution to be used for the queries
+func populateForQueries(b *testing.B, newDist func(r *rand.Rand, numKeys int) keyDistribution) (*DB, *blkstorage.BlockStore, keyDistribution) {
+	blockStoreEnv := newBlockStorageTestEnv(b)
+	b.Cleanup(blockStoreEnv.cleanup)
+	blockStore, err := blockStoreEnv.provider.Open("benchledger")
+	require.NoError(b, err)
+	b.Cleanup(blockStore.Shutdown)
+
+	provider, err := NewDBProvider(b.TempDir())
+	require.NoError(b, err)
+	b.Cleanup(provider.Close)
+	db := provider.GetDBHandle("benchledger")
+
+	g := newSyntheticBlockGenerator(newDist(rand.New(rand.NewSource(1)), *benchNumKeys))
+	for i := 0; i < *benchBlocks; i++ {
+		block := g.nextBlock(b)
+		require.NoError(b, blockStore.AddBlock(block))
+		require.NoError(b, db.Commit(block))
+	}
+	return db, blockStore, newDist(rand.New(rand.NewSource(2)), *benchNumKeys)
+}
+
+// quietLogging raises the log level for the duration of a benchmark, so that the debug logs enabled
+// for the tests in this package are not measured. Returns the function that restores the log level
+func quietLogging() func() {
+	spec := flogging.Global.Spec()
+	flogging.ActivateSpec("error")
+	return func() { flogging.ActivateSpec(spec) }
+}
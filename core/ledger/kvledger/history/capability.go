@@ -0,0 +1,98 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// CapabilityValueInlining is the capability that allows a chaincode to request, via its indexing manifest, the key
+	// modifications of its namespace to be stored inline in the history entries
+	CapabilityValueInlining = "ValueInlining"
+	// CapabilityDeltaEncoding is the capability that allows the inline values to be stored as deltas against the
+	// previous values of the keys (see function `EnableDeltaEncoding`)
+	CapabilityDeltaEncoding = "DeltaEncoding"
+	// CapabilityUnchangedWriteSkipping is the capability that allows the writes that do not change the values of the
+	// keys to be left out of the history (see function `EnableUnchangedWriteSkipping`)
+	CapabilityUnchangedWriteSkipping = "UnchangedWriteSkipping"
+)
+
+// supportedCapabilities are the capabilities supported by this version of the historydb
+var supportedCapabilities = map[string]struct{}{
+	CapabilityValueInlining:          {},
+	CapabilityDeltaEncoding:          {},
+	CapabilityUnchangedWriteSkipping: {},
+}
+
+// EnableCapabilities enables the features of the historydb that change the on-disk format of the history or the
+// semantics of the history queries. Such a feature is not used unless its capability is enabled, so that the peers
+// of a channel, some of which may run a version that does not support the feature, build compatible histories until
+// the capability is enabled on all of them. An error is returned for a capability not supported by this version, so
+// that a peer does not start with a configuration meant for a newer version.
+func (p *DBProvider) EnableCapabilities(capabilities []string) error {
+	if len(capabilities) == 0 {
+		p.capabilities = nil
+		return nil
+	}
+	enabled := map[string]struct{}{}
+	for _, c := range capabilities {
+		if _, ok := supportedCapabilities[c]; !ok {
+			return errors.Errorf("history capability [%s] is not supported by this peer", c)
+		}
+		enabled[c] = struct{}{}
+	}
+	p.capabilities = enabled
+	return nil
+}
+
+// SupportedCapabilities returns, in sorted order, the capabilities supported by this version of the historydb
+func SupportedCapabilities() []string {
+	capabilities := make([]string, 0, len(supportedCapabilities))
+	for c := range supportedCapabilities {
+		capabilities = append(capabilities, c)
+	}
+	sort.Strings(capabilities)
+	return capabilities
+}
+
+// EnabledCapabilities returns, in sorted order, the capabilities enabled via function `EnableCapabilities`
+func (p *DBProvider) EnabledCapabilities() []string {
+	capabilities := make([]string, 0, len(p.capabilities))
+	for c := range p.capabilities {
+		capabilities = append(capabilities, c)
+	}
+	sort.Strings(capabilities)
+	return capabilities
+}
+
+func (p *DBProvider) capabilityEnabled(capability string) bool {
+	_, ok := p.capabilities[capability]
+	return ok
+}
+
+// mergeCapabilities returns, in sorted order, the capabilities that are in either of the given sorted lists
+func mergeCapabilities(a, b []string) []string {
+	merged := map[string]struct{}{}
+	for _, c := range a {
+		merged[c] = struct{}{}
+	}
+	for _, c := range b {
+		merged[c] = struct{}{}
+	}
+	if len(merged) == 0 {
+		return nil
+	}
+	capabilities := make([]string, 0, len(merged))
+	for c := range merged {
+		capabilities = append(capabilities, c)
+	}
+	sort.Strings(capabilities)
+	return capabilities
+}
@@ -0,0 +1,70 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric/common/ledger/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnableCapabilities(t *testing.T) {
+	env := newTestHistoryEnv(t)
+	defer env.cleanup()
+	provider := env.testHistoryDBProvider
+
+	require.EqualError(t, provider.EnableCapabilities([]string{CapabilityValueInlining, "TimestampIndex"}),
+		"history capability [TimestampIndex] is not supported by this peer")
+	require.False(t, provider.capabilityEnabled(CapabilityValueInlining))
+	require.NoError(t, provider.EnableCapabilities([]string{CapabilityValueInlining}))
+	require.True(t, provider.capabilityEnabled(CapabilityValueInlining))
+	require.Equal(t, []string{CapabilityValueInlining}, provider.EnabledCapabilities())
+	require.NoError(t, provider.EnableCapabilities(nil))
+	require.False(t, provider.capabilityEnabled(CapabilityValueInlining))
+	require.Empty(t, provider.EnabledCapabilities())
+	require.Equal(t, []string{CapabilityDeltaEncoding, CapabilityUnchangedWriteSkipping, CapabilityValueInlining}, SupportedCapabilities())
+}
+
+func TestIndexFormatCapabilities(t *testing.T) {
+	env := newTestHistoryEnv(t)
+	defer env.cleanup()
+	provider := env.testHistoryDBProvider
+
+	// the capabilities enabled later are added to those recorded, without an upgrade of the index format
+	historydb := provider.GetDBHandle("ledger1")
+	require.NoError(t, historydb.DetectIndexFormat())
+	require.Equal(t, &IndexFormat{Version: indexFormatVersion}, historydb.IndexFormat())
+	require.NoError(t, provider.EnableCapabilities([]string{CapabilityValueInlining}))
+	historydb = provider.GetDBHandle("ledger1")
+	require.NoError(t, historydb.DetectIndexFormat())
+	expectedFormat := &IndexFormat{Version: indexFormatVersion, Capabilities: []string{CapabilityValueInlining}}
+	require.Equal(t, expectedFormat, historydb.IndexFormat())
+	require.Equal(t, expectedFormat, provider.GetDBHandle("ledger1").IndexFormat())
+	require.NoError(t, historydb.UpgradeFormat(nil, nil))
+
+	// the recorded capabilities are retained after the capability is disabled
+	require.NoError(t, provider.EnableCapabilities(nil))
+	historydb = provider.GetDBHandle("ledger1")
+	require.NoError(t, historydb.DetectIndexFormat())
+	require.Equal(t, expectedFormat, historydb.IndexFormat())
+
+	// the capabilities of a legacy history are recorded along with the upgraded format
+	require.NoError(t, provider.EnableCapabilities([]string{CapabilityValueInlining}))
+	_, gb := testutil.NewBlockGenerator(t, "ledger2", false)
+	historydb = provider.GetDBHandle("ledger2")
+	require.NoError(t, historydb.Commit(gb))
+	require.NoError(t, historydb.DetectIndexFormat())
+	require.Equal(t, &IndexFormat{Capabilities: []string{CapabilityValueInlining}}, historydb.IndexFormat())
+	require.Nil(t, provider.GetDBHandle("ledger2").IndexFormat())
+
+	// a history indexed with a capability not supported by this peer is not served
+	require.NoError(t, provider.writeIndexFormat("ledger3",
+		&indexFormatDescriptor{format: &IndexFormat{Version: indexFormatVersion, Capabilities: []string{"TimestampIndex"}}}))
+	require.EqualError(t, provider.GetDBHandle("ledger3").DetectIndexFormat(),
+		"the history of channel [ledger3] has been indexed with capability [TimestampIndex], which is not supported by this peer")
+}
@@ -0,0 +1,169 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+
+	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/hyperledger/fabric/internal/ccmetadata"
+	"github.com/pkg/errors"
+)
+
+// IndexingManifest declares the history indexing hints of a chaincode. A chaincode ships the manifest as a JSON file
+// at META-INF/history/indexing.json in its package, and the hints are applied to the namespace of the chaincode when
+// the chaincode becomes invocable on a channel, i.e., when it is both installed on the peer and committed to the
+// channel. As the manifest is taken from the package installed on the peer, the peers that do not have the chaincode
+// installed index the namespace as per their configuration only.
+type IndexingManifest struct {
+	// IncludeKeys and ExcludeKeys are the patterns of the keys that are indexed and that are not indexed, as in
+	// function `SetKeyIndexingPolicies`. They apply only if the peer configures no key patterns for the namespace
+	IncludeKeys []string `json:"includeKeys"`
+	ExcludeKeys []string `json:"excludeKeys"`
+	// FieldIndexes are the fields of the JSON values that are indexed, in addition to those configured on the peer,
+	// as in function `EnableFieldIndexes`
+	FieldIndexes []string `json:"fieldIndexes"`
+	// InlineValues stores the key modification inline in the history entries, so that the history of the namespace
+	// is served without retrieving the transactions from the block store, at the cost of the disk space. It applies
+	// only if the capability CapabilityValueInlining is enabled on the peer
+	InlineValues bool `json:"inlineValues"`
+}
+
+// chaincodeHints are the indexing hints of a namespace, as compiled from the indexing manifest of its chaincode
+type chaincodeHints struct {
+	keyPolicy    *keyIndexingPolicy
+	fieldView    *fieldIndexView
+	inlineValues bool
+}
+
+// HandleChaincodeDeploy implements the interface `ledger.ChaincodeLifecycleEventListener`. It applies the indexing
+// manifest, if any, in the db artifacts of the chaincode to the history entries committed afterwards. A chaincode
+// deployed without a manifest, as may be the case for a new version, drops the hints of the previous version. The hints
+// are held in memory, as the listener is invoked for the invocable chaincodes each time the ledger is opened.
+func (d *DB) HandleChaincodeDeploy(chaincodeDefinition *ledger.ChaincodeDefinition, dbArtifactsTar []byte) error {
+	if chaincodeDefinition == nil {
+		return errors.New("chaincode definition not found while applying the history indexing manifest")
+	}
+	ns := chaincodeDefinition.Name
+	manifest, err := extractIndexingManifest(dbArtifactsTar)
+	if err != nil {
+		return errors.WithMessagef(err, "error while applying the history indexing manifest of chaincode [%s]", ns)
+	}
+	var hints *chaincodeHints
+	if manifest != nil {
+		if hints, err = compileIndexingManifest(ns, manifest); err != nil {
+			return errors.WithMessagef(err, "error while applying the history indexing manifest of chaincode [%s]", ns)
+		}
+		if hints.inlineValues && !d.provider.capabilityEnabled(CapabilityValueInlining) {
+			logger.Warnw("Ignoring the inlineValues hint of the history indexing manifest, as the capability is not enabled",
+				"channel", d.name, "chaincode", ns, "capability", CapabilityValueInlining)
+			hints.inlineValues = false
+		}
+		logger.Infow("Applying the history indexing manifest of chaincode", "channel", d.name, "chaincode", ns,
+			"includeKeys", manifest.IncludeKeys, "excludeKeys", manifest.ExcludeKeys,
+			"fieldIndexes", manifest.FieldIndexes, "inlineValues", manifest.InlineValues)
+	}
+	d.setChaincodeHints(ns, hints)
+	return nil
+}
+
+// ChaincodeDeployDone implements the interface `ledger.ChaincodeLifecycleEventListener`
+func (d *DB) ChaincodeDeployDone(succeeded bool) {
+	// NOOP
+}
+
+// extractIndexingManifest returns the indexing manifest in the db artifacts tar or nil, if the tar contains none
+func extractIndexingManifest(dbArtifactsTar []byte) (*IndexingManifest, error) {
+	if len(dbArtifactsTar) == 0 {
+		return nil, nil
+	}
+	tarReader := tar.NewReader(bytes.NewReader(dbArtifactsTar))
+	for {
+		hdr, err := tarReader.Next()
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "error while reading the db artifacts")
+		}
+		if hdr.Name != ccmetadata.HistoryIndexingManifestPath {
+			continue
+		}
+		manifestBytes, err := ioutil.ReadAll(tarReader)
+		if err != nil {
+			return nil, errors.Wrap(err, "error while reading the history indexing manifest")
+		}
+		decoder := json.NewDecoder(bytes.NewReader(manifestBytes))
+		decoder.DisallowUnknownFields()
+		manifest := &IndexingManifest{}
+		if err := decoder.Decode(manifest); err != nil {
+			return nil, errors.Wrap(err, "error while unmarshalling the history indexing manifest")
+		}
+		return manifest, nil
+	}
+}
+
+func compileIndexingManifest(ns string, manifest *IndexingManifest) (*chaincodeHints, error) {
+	hints := &chaincodeHints{inlineValues: manifest.InlineValues}
+	if len(manifest.IncludeKeys) > 0 || len(manifest.ExcludeKeys) > 0 {
+		policy := &keyIndexingPolicy{}
+		for _, pattern := range manifest.IncludeKeys {
+			if pattern == "" {
+				return nil, errors.New("invalid key pattern, the pattern cannot be empty")
+			}
+			policy.includes = append(policy.includes, compileKeyPattern(pattern))
+		}
+		for _, pattern := range manifest.ExcludeKeys {
+			if pattern == "" {
+				return nil, errors.New("invalid key pattern, the pattern cannot be empty")
+			}
+			policy.excludes = append(policy.excludes, compileKeyPattern(pattern))
+		}
+		hints.keyPolicy = policy
+	}
+	if len(manifest.FieldIndexes) > 0 {
+		for _, field := range manifest.FieldIndexes {
+			if field == "" || bytes.Contains([]byte(field), compositeKeySep) {
+				return nil, errors.Errorf("invalid field index [%s], the field cannot be empty or contain the byte 0x00", field)
+			}
+		}
+		hints.fieldView = &fieldIndexView{fields: map[string][]string{ns: append([]string{}, manifest.FieldIndexes...)}}
+	}
+	return hints, nil
+}
+
+// setChaincodeHints replaces the hints of the namespace, or removes them if hints is nil. The hints are replaced
+// copy-on-write, so that the commits read them without locking
+func (d *DB) setChaincodeHints(ns string, hints *chaincodeHints) {
+	d.chaincodeHintsLock.Lock()
+	defer d.chaincodeHintsLock.Unlock()
+	current := d.loadChaincodeHints()
+	updated := make(map[string]*chaincodeHints, len(current)+1)
+	for k, v := range current {
+		updated[k] = v
+	}
+	if hints == nil {
+		delete(updated, ns)
+	} else {
+		updated[ns] = hints
+	}
+	d.chaincodeHints.Store(updated)
+}
+
+func (d *DB) loadChaincodeHints() map[string]*chaincodeHints {
+	hints, _ := d.chaincodeHints.Load().(map[string]*chaincodeHints)
+	return hints
+}
+
+// hintsFor returns the indexing hints of the namespace, or nil if its chaincode declares none
+func (d *DB) hintsFor(ns string) *chaincodeHints {
+	return d.loadChaincodeHints()[ns]
+}
@@ -0,0 +1,133 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"archive/tar"
+	"bytes"
+	"testing"
+
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/hyperledger/fabric/common/ledger/testutil"
+	util2 "github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChaincodeIndexingManifest(t *testing.T) {
+	env := newTestHistoryEnv(t)
+	defer env.cleanup()
+	store, err := env.testBlockStorageEnv.provider.Open("ledger1")
+	require.NoError(t, err)
+	defer store.Shutdown()
+	historydb := env.testHistoryDBProvider.GetDBHandle("ledger1")
+
+	bg, gb := testutil.NewBlockGenerator(t, "ledger1", false)
+	require.NoError(t, store.AddBlock(gb))
+	require.NoError(t, historydb.Commit(gb))
+	commitWrites := func(writes map[string]string) *common.Block {
+		simulator, err := env.txmgr.NewTxSimulator(util2.GenerateUUID())
+		require.NoError(t, err)
+		for k, v := range writes {
+			require.NoError(t, simulator.SetState("mycc", k, []byte(v)))
+		}
+		simulator.Done()
+		simRes, err := simulator.GetTxSimulationResults()
+		require.NoError(t, err)
+		pubSimResBytes, err := simRes.GetPubSimulationBytes()
+		require.NoError(t, err)
+		block := bg.NextBlock([][]byte{pubSimResBytes})
+		require.NoError(t, store.AddBlock(block))
+		require.NoError(t, historydb.Commit(block))
+		return block
+	}
+	indexEntries := func() map[string]bool {
+		entries := map[string]bool{}
+		require.NoError(t, historydb.ScanIndex("mycc", "", func(e *IndexEntry) error {
+			entries[e.Key] = e.Inline
+			return nil
+		}))
+		return entries
+	}
+	ccDef := &ledger.ChaincodeDefinition{Name: "mycc", Version: "1.0"}
+
+	// the db artifacts without a manifest leave the indexing as configured on the peer
+	require.NoError(t, historydb.HandleChaincodeDeploy(ccDef, dbArtifactsTar(t, map[string]string{
+		"META-INF/statedb/couchdb/indexes/index.json": `{}`,
+	})))
+	commitWrites(map[string]string{"asset~1": `{"owner":"alice"}`, "lock~1": "1"})
+	require.Equal(t, map[string]bool{"asset~1": false, "lock~1": false}, indexEntries())
+
+	// the values are not inlined unless the capability is enabled
+	manifestTar := dbArtifactsTar(t, map[string]string{
+		"META-INF/history/indexing.json": `{"excludeKeys":["lock~*"],"fieldIndexes":["owner"],"inlineValues":true}`,
+	})
+	require.NoError(t, historydb.HandleChaincodeDeploy(ccDef, manifestTar))
+	require.False(t, historydb.hintsFor("mycc").inlineValues)
+	require.NotNil(t, historydb.hintsFor("mycc").keyPolicy)
+
+	require.NoError(t, env.testHistoryDBProvider.EnableCapabilities([]string{CapabilityValueInlining}))
+	require.NoError(t, historydb.HandleChaincodeDeploy(ccDef, manifestTar))
+	require.True(t, historydb.hintsFor("mycc").inlineValues)
+	commitWrites(map[string]string{"asset~1": `{"owner":"bob"}`, "asset~2": `{"owner":"alice"}`, "lock~1": "2"})
+	entries := indexEntries()
+	require.Len(t, entries, 3)
+	require.True(t, entries["asset~2"])
+	require.False(t, entries["lock~1"])
+
+	// the inline entries are served without the block store
+	qe, err := historydb.NewQueryExecutor(nil)
+	require.NoError(t, err)
+	itr, err := qe.GetHistoryForKey("mycc", "asset~2")
+	require.NoError(t, err)
+	kmod, err := itr.Next()
+	require.NoError(t, err)
+	require.Equal(t, []byte(`{"owner":"alice"}`), kmod.(*queryresult.KeyModification).Value)
+	itr.Close()
+	_, err = qe.GetHistoryForKey("mycc", "lock~1")
+	require.Equal(t, &KeyNotIndexedError{Namespace: "mycc", Key: "lock~1"}, err)
+
+	// the declared field index covers the writes committed after the manifest is applied
+	var owners []string
+	require.NoError(t, historydb.GetHistoryByField("mycc", "owner", "alice", store, func(e *Entry) error {
+		owners = append(owners, e.Key)
+		return nil
+	}))
+	require.Equal(t, []string{"asset~2"}, owners)
+	require.EqualError(t, historydb.GetHistoryByField("mycc", "color", "red", store, func(*Entry) error { return nil }),
+		"field [color] is not indexed for namespace [mycc]")
+
+	// an invalid manifest is rejected and the hints of the chaincode are retained
+	err = historydb.HandleChaincodeDeploy(ccDef, dbArtifactsTar(t, map[string]string{
+		"META-INF/history/indexing.json": `{"unknownField":true}`,
+	}))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "error while applying the history indexing manifest of chaincode [mycc]")
+	require.NotNil(t, historydb.hintsFor("mycc"))
+
+	// a new version of the chaincode without a manifest drops the hints
+	require.NoError(t, historydb.HandleChaincodeDeploy(&ledger.ChaincodeDefinition{Name: "mycc", Version: "2.0"}, nil))
+	require.Nil(t, historydb.hintsFor("mycc"))
+	commitWrites(map[string]string{"lock~2": "1"})
+	_, ok := indexEntries()["lock~2"]
+	require.True(t, ok)
+	require.EqualError(t, historydb.GetHistoryByField("mycc", "owner", "alice", store, func(*Entry) error { return nil }),
+		"field [owner] is not indexed for namespace [mycc]")
+}
+
+func dbArtifactsTar(t *testing.T, files map[string]string) []byte {
+	buf := bytes.NewBuffer(nil)
+	tw := tar.NewWriter(buf)
+	for name, content := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Size: int64(len(content)), Mode: 0o600}))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	return buf.Bytes()
+}
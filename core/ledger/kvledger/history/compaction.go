@@ -0,0 +1,235 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hyperledger/fabric/common/ledger/util/leveldbhelper"
+	"github.com/pkg/errors"
+)
+
+// pendingCompactionsDBName is the name of the db, within the historydb leveldb, that records the channels whose
+// history is pending compaction. The name cannot be taken by a channel, as a channel name starts with a letter
+const pendingCompactionsDBName = "_pending_compactions"
+
+// EnableScheduledCompaction enables compacting, during a daily maintenance window, the history of the channels from
+// which the entries were deleted in bulk, i.e., the history dropped via function `Drop`, as is the case when a channel
+// is removed or its history is replaced by a rebuild. LevelDB reclaims the space of the deleted entries only as the
+// tables holding them get compacted, which, for a range that receives few writes afterwards, may not happen for a
+// long time. The window starts daily at windowStart, specified as `HH:MM` in the local time of the peer, and lasts
+// for windowDuration. The history deleted outside the window is compacted in the next window, including after a
+// restart of the peer, as the pending compactions are persisted. A windowDuration of 0 or less leaves the scheduled
+// compaction disabled. The space reclaimed is reported in the metric ledger_history_compaction_reclaimed_bytes and
+// the space pending the compaction in the metric ledger_history_compaction_pending_bytes.
+func (p *DBProvider) EnableScheduledCompaction(windowStart string, windowDuration time.Duration) error {
+	if p.compactions != nil {
+		p.compactions.close()
+		p.compactions = nil
+	}
+	if windowDuration <= 0 {
+		return nil
+	}
+	start, err := time.Parse("15:04", windowStart)
+	if err != nil {
+		return errors.Wrapf(err, "invalid compaction window start [%s], expected HH:MM", windowStart)
+	}
+	if windowDuration > 24*time.Hour {
+		return errors.Errorf("invalid compaction window duration [%s], the window cannot be longer than a day", windowDuration)
+	}
+	p.compactions = &compactionScheduler{
+		provider:       p,
+		pending:        p.leveldbProvider.GetDBHandle(pendingCompactionsDBName),
+		windowStart:    time.Duration(start.Hour())*time.Hour + time.Duration(start.Minute())*time.Minute,
+		windowDuration: windowDuration,
+	}
+	p.compactions.start()
+	return nil
+}
+
+// compactionScheduler compacts the history of the channels pending compaction during the maintenance window
+type compactionScheduler struct {
+	provider *DBProvider
+	// pending records the names of the channels whose history is pending compaction
+	pending        *leveldbhelper.DBHandle
+	windowStart    time.Duration
+	windowDuration time.Duration
+
+	// mutex serializes the compactions
+	mutex   sync.Mutex
+	stop    chan struct{}
+	stopped sync.WaitGroup
+}
+
+func (s *compactionScheduler) start() {
+	interval := time.Minute
+	if s.windowDuration < 2*interval {
+		interval = s.windowDuration / 2
+	}
+	s.stop = make(chan struct{})
+	s.stopped.Add(1)
+	go func() {
+		defer s.stopped.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case now := <-ticker.C:
+				if _, err := s.compactPending(now); err != nil {
+					logger.Errorw("Error while compacting the history database", "error", err)
+				}
+				if err := s.reportPending(); err != nil {
+					logger.Warnw("Error while reporting the pending compactions of the history database", "error", err)
+				}
+			}
+		}
+	}()
+}
+
+// markPending records the channel as pending compaction
+func (s *compactionScheduler) markPending(name string) error {
+	return errors.WithMessagef(s.pending.Put([]byte(name), emptyValue, true),
+		"error while recording channel [%s] as pending compaction", name)
+}
+
+// inWindow returns true if the given time falls in the maintenance window
+func (s *compactionScheduler) inWindow(now time.Time) bool {
+	year, month, day := now.Date()
+	start := time.Date(year, month, day, 0, 0, 0, 0, now.Location()).Add(s.windowStart)
+	if now.Before(start) {
+		// the window started on the previous day may still be open
+		start = start.AddDate(0, 0, -1)
+	}
+	return now.Sub(start) < s.windowDuration
+}
+
+// compactPending compacts the history of the channels pending compaction, if the given time falls in the
+// maintenance window, and returns the names of the channels compacted. The window is checked before compacting
+// each channel, so that the compactions started within the window do not run long past it
+func (s *compactionScheduler) compactPending(now time.Time) ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.inWindow(now) {
+		return nil, nil
+	}
+	names, err := s.pendingNames()
+	if err != nil {
+		return nil, err
+	}
+	var compacted []string
+	for i, name := range names {
+		if i > 0 && !s.inWindow(time.Now()) {
+			break
+		}
+		if err := s.compact(name); err != nil {
+			return compacted, err
+		}
+		compacted = append(compacted, name)
+	}
+	return compacted, nil
+}
+
+func (s *compactionScheduler) pendingNames() ([]string, error) {
+	itr, err := s.pending.GetIterator(nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer itr.Release()
+	var names []string
+	for itr.Next() {
+		if err := itr.Error(); err != nil {
+			return nil, errors.Wrap(err, "internal leveldb error while iterating for pending compactions")
+		}
+		names = append(names, string(itr.Key()))
+	}
+	return names, nil
+}
+
+// compact compacts the history of the channel and reports the space reclaimed
+func (s *compactionScheduler) compact(name string) error {
+	if _, err := compactRange(s.provider.leveldbProvider.GetDBHandle(name), nil, nil,
+		s.provider.stats.ledgerStats(name), s.provider.reportLevelSizes); err != nil {
+		return errors.WithMessagef(err, "error while compacting the history of channel [%s]", name)
+	}
+	return errors.WithMessagef(s.pending.Delete([]byte(name), true),
+		"error while removing channel [%s] from the pending compactions", name)
+}
+
+// reportPending reports the size on the disk of the history of the channels pending compaction, which is the space
+// to be reclaimed by the scheduled compactions, along with the sizes of the levels of leveldb
+func (s *compactionScheduler) reportPending() error {
+	names, err := s.pendingNames()
+	if err != nil {
+		return err
+	}
+	pendingBytes := int64(0)
+	for _, name := range names {
+		size, err := s.provider.leveldbProvider.GetDBHandle(name).ApproximateSize(nil, nil)
+		if err != nil {
+			return err
+		}
+		pendingBytes += size
+	}
+	s.provider.stats.updatePendingCompactionBytes(pendingBytes)
+	s.provider.reportLevelSizes()
+	return nil
+}
+
+// close stops the scheduler, after the compaction in progress, if any, completes
+func (s *compactionScheduler) close() {
+	close(s.stop)
+	s.stopped.Wait()
+}
+
+// Compact compacts the entries of the namespace in the history of the channel, or all the history of the channel if
+// the namespace is empty, and returns the approximate space reclaimed in bytes. This is meant to be triggered by an
+// administrator after a large number of entries is deleted from the range, as leveldb otherwise reclaims the space
+// of the deleted entries only as it gets to compact the tables holding them, which, for a range that receives few
+// writes, may not happen for days. The compaction runs in the goroutine of the caller and competes with the commits
+// for the disk. As for the scheduled compaction, the space reclaimed is reported in the metric
+// ledger_history_compaction_reclaimed_bytes.
+func (d *DB) Compact(ns string) (int64, error) {
+	var startKey, endKey []byte
+	if ns != "" {
+		startKey, endKey = scanRange(ns, "")
+	}
+	reclaimed, err := compactRange(d.levelDB, startKey, endKey, d.stats, d.reportLevelSizes)
+	if err != nil {
+		return 0, errors.WithMessagef(err, "error while compacting the history of namespace [%s] of channel [%s]", ns, d.name)
+	}
+	return reclaimed, nil
+}
+
+// compactRange compacts the range of the db and reports the space reclaimed and the sizes of the levels of leveldb
+// afterwards. Returns the space reclaimed
+func compactRange(db *leveldbhelper.DBHandle, startKey, endKey []byte, stats *ledgerStats, reportLevelSizes func()) (int64, error) {
+	startCompaction := time.Now()
+	sizeBefore, err := db.ApproximateSize(startKey, endKey)
+	if err != nil {
+		return 0, err
+	}
+	if err := db.CompactRange(startKey, endKey); err != nil {
+		return 0, err
+	}
+	sizeAfter, err := db.ApproximateSize(startKey, endKey)
+	if err != nil {
+		return 0, err
+	}
+	reclaimed := sizeBefore - sizeAfter
+	if reclaimed < 0 {
+		// the size before does not count the deletes held in the memtable, which are flushed by the compaction
+		reclaimed = 0
+	}
+	stats.updateCompaction(reclaimed, time.Since(startCompaction))
+	reportLevelSizes()
+	logger.Infow("Compacted the history database", "channel", stats.ledgerid, "startKey", startKey,
+		"reclaimedBytes", reclaimed, "sizeBytes", sizeAfter, "duration", time.Since(startCompaction).String())
+	return reclaimed, nil
+}
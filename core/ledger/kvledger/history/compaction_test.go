@@ -0,0 +1,194 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/common/metrics"
+	"github.com/hyperledger/fabric/common/metrics/metricsfakes"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompactionWindow(t *testing.T) {
+	provider, err := NewDBProvider(t.TempDir())
+	require.NoError(t, err)
+	defer provider.Close()
+
+	require.EqualError(t, provider.EnableScheduledCompaction("25:00", time.Hour),
+		`invalid compaction window start [25:00], expected HH:MM: parsing time "25:00": hour out of range`)
+	require.EqualError(t, provider.EnableScheduledCompaction("02:00", 25*time.Hour),
+		"invalid compaction window duration [25h0m0s], the window cannot be longer than a day")
+	require.NoError(t, provider.EnableScheduledCompaction("23:30", 2*time.Hour))
+
+	for _, tc := range []struct {
+		now      string
+		inWindow bool
+	}{
+		{"2026-10-16T23:29:59Z", false},
+		{"2026-10-16T23:30:00Z", true},
+		{"2026-10-17T01:29:59Z", true},
+		{"2026-10-17T01:30:00Z", false},
+		{"2026-10-17T12:00:00Z", false},
+	} {
+		now, err := time.Parse(time.RFC3339, tc.now)
+		require.NoError(t, err)
+		require.Equal(t, tc.inWindow, provider.compactions.inWindow(now), tc.now)
+	}
+
+	// a duration of 0 disables the scheduled compaction
+	require.NoError(t, provider.EnableScheduledCompaction("23:30", 0))
+	require.Nil(t, provider.compactions)
+}
+
+func TestScheduledCompaction(t *testing.T) {
+	dbPath := t.TempDir()
+	provider, err := NewDBProvider(dbPath)
+	require.NoError(t, err)
+	require.NoError(t, provider.EnableScheduledCompaction("02:00", time.Hour))
+
+	historydb := provider.GetDBHandle("ledger1")
+	batch := historydb.levelDB.NewUpdateBatch()
+	for i := 0; i < 10000; i++ {
+		batch.Put(constructDataKey("ns1", fmt.Sprintf("key-%06d", i), uint64(i), 0), []byte(fmt.Sprintf("value-%06d", i)))
+	}
+	require.NoError(t, historydb.levelDB.WriteBatch(batch, true))
+	require.NoError(t, historydb.levelDB.CompactRange(nil, nil))
+	require.NoError(t, provider.Drop("ledger1"))
+
+	// the pending compaction survives a restart of the peer
+	provider.Close()
+	provider, err = NewDBProvider(dbPath)
+	require.NoError(t, err)
+	defer provider.Close()
+	fakeProvider := &metricsfakes.Provider{}
+	reclaimedBytes := &metricsfakes.Counter{}
+	reclaimedBytes.WithReturns(reclaimedBytes)
+	fakeProvider.NewCounterStub = func(opts metrics.CounterOpts) metrics.Counter {
+		if opts.Name == "compaction_reclaimed_bytes" {
+			return reclaimedBytes
+		}
+		c := &metricsfakes.Counter{}
+		c.WithReturns(c)
+		return c
+	}
+	gauges := map[string]*metricsfakes.Gauge{}
+	fakeProvider.NewGaugeStub = func(opts metrics.GaugeOpts) metrics.Gauge {
+		g := &metricsfakes.Gauge{}
+		g.WithReturns(g)
+		gauges[opts.Name] = g
+		return g
+	}
+	fakeHist := &metricsfakes.Histogram{}
+	fakeHist.WithReturns(fakeHist)
+	fakeProvider.NewHistogramReturns(fakeHist)
+	provider.EnableMetrics(fakeProvider)
+	require.NoError(t, provider.EnableScheduledCompaction("02:00", time.Hour))
+
+	// the dropped history is reported as pending the compaction
+	require.NoError(t, provider.compactions.reportPending())
+	pendingBytes := gauges[pendingCompactionOpts.Name]
+	require.Equal(t, 1, pendingBytes.SetCallCount())
+	require.Greater(t, pendingBytes.SetArgsForCall(0), float64(10000))
+
+	// nothing is compacted outside the window
+	compacted, err := provider.compactions.compactPending(time.Date(2026, 10, 16, 12, 0, 0, 0, time.Local))
+	require.NoError(t, err)
+	require.Empty(t, compacted)
+	require.Equal(t, 0, reclaimedBytes.AddCallCount())
+
+	compacted, err = provider.compactions.compactPending(time.Date(2026, 10, 16, 2, 15, 0, 0, time.Local))
+	require.NoError(t, err)
+	require.Equal(t, []string{"ledger1"}, compacted)
+	require.Equal(t, 1, reclaimedBytes.AddCallCount())
+	require.Equal(t, []string{"channel", "ledger1"}, reclaimedBytes.WithArgsForCall(0))
+	require.Greater(t, reclaimedBytes.AddArgsForCall(0), float64(10000))
+	size, err := provider.GetDBHandle("ledger1").levelDB.ApproximateSize(nil, nil)
+	require.NoError(t, err)
+	require.Less(t, size, int64(10000))
+
+	// the compacted channel is no longer pending
+	compacted, err = provider.compactions.compactPending(time.Date(2026, 10, 16, 2, 30, 0, 0, time.Local))
+	require.NoError(t, err)
+	require.Empty(t, compacted)
+	require.NoError(t, provider.compactions.reportPending())
+	require.Equal(t, float64(0), pendingBytes.SetArgsForCall(1))
+	require.NotZero(t, gauges[levelSizeOpts.Name].SetCallCount())
+}
+
+func TestCompactNamespace(t *testing.T) {
+	provider, err := NewDBProvider(t.TempDir())
+	require.NoError(t, err)
+	defer provider.Close()
+	fakeProvider := &metricsfakes.Provider{}
+	reclaimedBytes := &metricsfakes.Counter{}
+	reclaimedBytes.WithReturns(reclaimedBytes)
+	fakeProvider.NewCounterStub = func(opts metrics.CounterOpts) metrics.Counter {
+		if opts.Name == "compaction_reclaimed_bytes" {
+			return reclaimedBytes
+		}
+		c := &metricsfakes.Counter{}
+		c.WithReturns(c)
+		return c
+	}
+	levelSize := &metricsfakes.Gauge{}
+	fakeProvider.NewGaugeStub = func(opts metrics.GaugeOpts) metrics.Gauge {
+		if opts.Name == "level_size" {
+			levelSize.WithReturns(levelSize)
+			return levelSize
+		}
+		g := &metricsfakes.Gauge{}
+		g.WithReturns(g)
+		return g
+	}
+	fakeHist := &metricsfakes.Histogram{}
+	fakeHist.WithReturns(fakeHist)
+	fakeProvider.NewHistogramReturns(fakeHist)
+	provider.EnableMetrics(fakeProvider)
+
+	historydb := provider.GetDBHandle("ledger1")
+	batch := historydb.levelDB.NewUpdateBatch()
+	for i := 0; i < 10000; i++ {
+		for _, ns := range []string{"ns1", "ns2"} {
+			batch.Put(constructDataKey(ns, fmt.Sprintf("key-%06d", i), uint64(i), 0), []byte(fmt.Sprintf("value-%06d", i)))
+		}
+	}
+	require.NoError(t, historydb.levelDB.WriteBatch(batch, true))
+	require.NoError(t, historydb.levelDB.CompactRange(nil, nil))
+	ns2Size, err := historydb.levelDB.ApproximateSize(scanRange("ns2", ""))
+	require.NoError(t, err)
+
+	batch = historydb.levelDB.NewUpdateBatch()
+	for i := 0; i < 10000; i++ {
+		batch.Delete(constructDataKey("ns1", fmt.Sprintf("key-%06d", i), uint64(i), 0))
+	}
+	require.NoError(t, historydb.levelDB.WriteBatch(batch, true))
+
+	reclaimed, err := historydb.Compact("ns1")
+	require.NoError(t, err)
+	require.Greater(t, reclaimed, int64(10000))
+	require.Equal(t, 1, reclaimedBytes.AddCallCount())
+	require.Equal(t, []string{"channel", "ledger1"}, reclaimedBytes.WithArgsForCall(0))
+	require.Equal(t, float64(reclaimed), reclaimedBytes.AddArgsForCall(0))
+	require.NotZero(t, levelSize.SetCallCount())
+	require.Equal(t, []string{"level", "0"}, levelSize.WithArgsForCall(0))
+
+	// the entries of the other namespace are left intact
+	size, err := historydb.levelDB.ApproximateSize(scanRange("ns2", ""))
+	require.NoError(t, err)
+	require.InDelta(t, ns2Size, size, float64(ns2Size)/10)
+	val, err := historydb.levelDB.Get(constructDataKey("ns2", "key-000000", 0, 0))
+	require.NoError(t, err)
+	require.Equal(t, []byte("value-000000"), val)
+
+	// an empty namespace compacts the whole history of the channel
+	reclaimed, err = historydb.Compact("")
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, reclaimed, int64(0))
+}
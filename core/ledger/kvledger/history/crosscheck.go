@@ -0,0 +1,207 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"bytes"
+
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/hyperledger/fabric/core/ledger/internal/version"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/statedb"
+	"github.com/pkg/errors"
+)
+
+// StateReader reads the current state for cross-checking the history against it. The state database of the
+// ledger satisfies this interface
+type StateReader interface {
+	GetState(namespace string, key string) (*statedb.VersionedValue, error)
+	GetStateRangeScanIterator(namespace string, startKey string, endKey string) (statedb.ResultsIterator, error)
+}
+
+// StateMismatch describes a key for which the latest modification in the history does not agree with the state
+type StateMismatch struct {
+	Namespace string
+	Key       string
+	// HistoryHeight is the height of the latest history entry for the key, nil if the key has no history
+	HistoryHeight *version.Height
+	// HistoryIsDelete is true if the latest history entry for the key is a delete
+	HistoryIsDelete bool
+	// StateHeight is the version of the key in the state, nil if the key is not present in the state
+	StateHeight *version.Height
+	// Reason describes the mismatch
+	Reason string
+}
+
+// StateCrossCheckReport is the outcome of cross-checking the history against the state (see function `CrossCheckState`)
+type StateCrossCheckReport struct {
+	Namespace string
+	Key       string
+	// KeysChecked is the number of keys, across the history and the state, that are checked
+	KeysChecked uint64
+	// KeysSkipped is the number of keys that are modified in the state by the blocks not yet indexed in the history
+	KeysSkipped uint64
+	Mismatches  []*StateMismatch
+}
+
+// CrossCheckState compares, for each key in the namespace ns or, if key is not empty, for the given key only, the
+// latest key modification in the history with the value of the key in the given state, and reports the keys for which
+// they disagree. A key with history is expected to be present in the state with the version and the value of its latest
+// history entry, or be absent from the state if the latest entry is a delete. A key present in the state is expected to
+// have history, unless it is excluded by the indexing policies. The keys modified in the state by the blocks beyond the
+// savepoint of the history are skipped, as the history may lag behind the state with the asynchronous history commit.
+// The keys written before the history was enabled for the ledger show up as missing in the history. This is intended
+// for detecting a divergence between the history and the state after a crash recovery or a manual intervention.
+func (d *DB) CrossCheckState(ns, key string, state StateReader, txFetcher TxFetcher) (*StateCrossCheckReport, error) {
+	if ns == "" {
+		return nil, errors.New("namespace is required for cross-checking the history against the state")
+	}
+	key = d.normalizeKey(ns, key)
+	savepoint, err := d.GetLastSavepoint()
+	if err != nil {
+		return nil, err
+	}
+	report := &StateCrossCheckReport{Namespace: ns, Key: key}
+	if savepoint == nil {
+		return report, nil
+	}
+	// notIndexed returns true if the state version is for a block not yet indexed in the history
+	notIndexed := func(vv *statedb.VersionedValue) bool {
+		return vv != nil && vv.Version.BlockNum > savepoint.BlockNum
+	}
+
+	resolver := d.newResolvePool(txFetcher, func(k []byte, keyModification *queryresult.KeyModification) error {
+		_, entryKey, blockNum, tranNum, err := decodeDataKey(k)
+		if err != nil {
+			return err
+		}
+		report.KeysChecked++
+		vv, err := state.GetState(ns, entryKey)
+		if err != nil {
+			return err
+		}
+		if notIndexed(vv) {
+			report.KeysSkipped++
+			return nil
+		}
+		mismatch := &StateMismatch{
+			Namespace:       ns,
+			Key:             entryKey,
+			HistoryHeight:   version.NewHeight(blockNum, tranNum),
+			HistoryIsDelete: keyModification.IsDelete,
+		}
+		if vv != nil {
+			mismatch.StateHeight = vv.Version
+		}
+		switch {
+		case keyModification.IsDelete && vv != nil:
+			mismatch.Reason = "key is deleted in the history but present in the state"
+		case keyModification.IsDelete:
+			return nil
+		case vv == nil:
+			mismatch.Reason = "key is present in the history but absent from the state"
+		case vv.Version.Compare(mismatch.HistoryHeight) != 0:
+			mismatch.Reason = "version of the key in the state differs from the latest history entry"
+		case !bytes.Equal(vv.Value, keyModification.Value):
+			mismatch.Reason = "value of the key in the state differs from the latest history entry"
+		default:
+			return nil
+		}
+		report.Mismatches = append(report.Mismatches, mismatch)
+		return nil
+	})
+	defer resolver.close()
+	if err := d.addLatestEntries(ns, key, savepoint.BlockNum, resolver); err != nil {
+		return nil, err
+	}
+	if err := resolver.flush(); err != nil {
+		return nil, err
+	}
+
+	// the keys present in the state but without any history
+	checkStateKey := func(stateKey string, vv *statedb.VersionedValue) error {
+		if notIndexed(vv) || !d.isKeyIndexed(ns, d.normalizeKey(ns, stateKey)) {
+			return nil
+		}
+		hasHistory, err := d.hasEntries(constructRangeScan(ns, d.normalizeKey(ns, stateKey)))
+		if err != nil || hasHistory {
+			return err
+		}
+		report.KeysChecked++
+		report.Mismatches = append(report.Mismatches, &StateMismatch{
+			Namespace:   ns,
+			Key:         stateKey,
+			StateHeight: vv.Version,
+			Reason:      "key is present in the state but has no history",
+		})
+		return nil
+	}
+	if key != "" {
+		vv, err := state.GetState(ns, key)
+		if err != nil || vv == nil {
+			return report, err
+		}
+		if err := checkStateKey(key, vv); err != nil {
+			return nil, err
+		}
+		return report, nil
+	}
+	itr, err := state.GetStateRangeScanIterator(ns, "", "")
+	if err != nil {
+		return nil, err
+	}
+	defer itr.Close()
+	for {
+		kv, err := itr.Next()
+		if err != nil {
+			return nil, err
+		}
+		if kv == nil {
+			return report, nil
+		}
+		if err := checkStateKey(kv.Key, kv.VersionedValue); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// addLatestEntries adds to the resolver the latest history entry, up to the block lastBlock, of each key in the
+// namespace ns or, if key is not empty, of the given key only
+func (d *DB) addLatestEntries(ns, key string, lastBlock uint64, resolver *resolvePool) error {
+	itr, err := d.levelDB.GetIterator(scanRange(ns, key))
+	if err != nil {
+		return err
+	}
+	defer itr.Release()
+
+	var latestKey, latestVal []byte
+	latestEntryKey := ""
+	for itr.Next() {
+		if err := itr.Error(); err != nil {
+			return errors.Wrap(err, "internal leveldb error while iterating for history entries")
+		}
+		_, entryKey, blockNum, _, err := decodeDataKey(itr.Key())
+		if err != nil {
+			return err
+		}
+		if latestKey != nil && entryKey != latestEntryKey {
+			if err := resolver.add(latestKey, latestVal); err != nil {
+				return err
+			}
+			latestKey = nil
+		}
+		if blockNum > lastBlock {
+			continue
+		}
+		latestKey = append(latestKey[:0], itr.Key()...)
+		latestVal = append(latestVal[:0], itr.Value()...)
+		latestEntryKey = entryKey
+	}
+	if latestKey == nil {
+		return nil
+	}
+	return resolver.add(latestKey, latestVal)
+}
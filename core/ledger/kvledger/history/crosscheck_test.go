@@ -0,0 +1,133 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric/common/ledger/testutil"
+	util2 "github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/ledger/internal/version"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/privacyenabledstate"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCrossCheckState(t *testing.T) {
+	env := newTestHistoryEnv(t)
+	defer env.cleanup()
+	store, err := env.testBlockStorageEnv.provider.Open("ledger1")
+	require.NoError(t, err)
+	defer store.Shutdown()
+	stateDB := env.testDBEnv.GetDBHandle("ledger1")
+
+	historydb := env.testHistoryDBProvider.GetDBHandle("ledger1")
+	bg, gb := testutil.NewBlockGenerator(t, "ledger1", false)
+	commit := func(block *common.Block) {
+		require.NoError(t, store.AddBlock(block))
+		require.NoError(t, historydb.Commit(block))
+	}
+	commit(gb)
+	for i := 1; i <= 3; i++ {
+		simulator, err := env.txmgr.NewTxSimulator(util2.GenerateUUID())
+		require.NoError(t, err)
+		require.NoError(t, simulator.SetState("ns1", "key1", []byte(fmt.Sprintf("value%d", i))))
+		if i < 3 {
+			require.NoError(t, simulator.SetState("ns1", "key2", []byte(fmt.Sprintf("value%d", i))))
+		} else {
+			require.NoError(t, simulator.DeleteState("ns1", "key2"))
+		}
+		simulator.Done()
+		simRes, err := simulator.GetTxSimulationResults()
+		require.NoError(t, err)
+		pubSimResBytes, err := simRes.GetPubSimulationBytes()
+		require.NoError(t, err)
+		commit(bg.NextBlock([][]byte{pubSimResBytes}))
+	}
+	applyState := func(update func(batch *privacyenabledstate.UpdateBatch)) {
+		batch := privacyenabledstate.NewUpdateBatch()
+		update(batch)
+		require.NoError(t, stateDB.ApplyPrivacyAwareUpdates(batch, version.NewHeight(3, 0)))
+	}
+	applyState(func(batch *privacyenabledstate.UpdateBatch) {
+		batch.PubUpdates.Put("ns1", "key1", []byte("value3"), version.NewHeight(3, 0))
+	})
+
+	t.Run("consistent", func(t *testing.T) {
+		report, err := historydb.CrossCheckState("ns1", "", stateDB, store)
+		require.NoError(t, err)
+		require.Empty(t, report.Mismatches)
+		require.Equal(t, uint64(2), report.KeysChecked)
+	})
+
+	t.Run("namespace-required", func(t *testing.T) {
+		_, err := historydb.CrossCheckState("", "", stateDB, store)
+		require.EqualError(t, err, "namespace is required for cross-checking the history against the state")
+	})
+
+	t.Run("diverged", func(t *testing.T) {
+		applyState(func(batch *privacyenabledstate.UpdateBatch) {
+			batch.PubUpdates.Put("ns1", "key1", []byte("tampered"), version.NewHeight(3, 0))
+			batch.PubUpdates.Put("ns1", "key2", []byte("value2"), version.NewHeight(2, 0))
+			batch.PubUpdates.Put("ns1", "key3", []byte("value"), version.NewHeight(1, 0))
+			// a key written by a block not yet indexed in the history is skipped
+			batch.PubUpdates.Put("ns1", "key4", []byte("value"), version.NewHeight(4, 0))
+		})
+
+		report, err := historydb.CrossCheckState("ns1", "", stateDB, store)
+		require.NoError(t, err)
+		require.Equal(t, uint64(3), report.KeysChecked)
+		require.Equal(t, []*StateMismatch{
+			{
+				Namespace:     "ns1",
+				Key:           "key1",
+				HistoryHeight: version.NewHeight(3, 0),
+				StateHeight:   version.NewHeight(3, 0),
+				Reason:        "value of the key in the state differs from the latest history entry",
+			},
+			{
+				Namespace:       "ns1",
+				Key:             "key2",
+				HistoryHeight:   version.NewHeight(3, 0),
+				HistoryIsDelete: true,
+				StateHeight:     version.NewHeight(2, 0),
+				Reason:          "key is deleted in the history but present in the state",
+			},
+			{
+				Namespace:   "ns1",
+				Key:         "key3",
+				StateHeight: version.NewHeight(1, 0),
+				Reason:      "key is present in the state but has no history",
+			},
+		}, report.Mismatches)
+
+		report, err = historydb.CrossCheckState("ns1", "key3", stateDB, store)
+		require.NoError(t, err)
+		require.Len(t, report.Mismatches, 1)
+		require.Equal(t, "key3", report.Mismatches[0].Key)
+
+		applyState(func(batch *privacyenabledstate.UpdateBatch) {
+			batch.PubUpdates.Delete("ns1", "key1", version.NewHeight(3, 0))
+			batch.PubUpdates.Put("ns1", "key2", []byte("value"), version.NewHeight(5, 0))
+		})
+		report, err = historydb.CrossCheckState("ns1", "key1", stateDB, store)
+		require.NoError(t, err)
+		require.Equal(t, []*StateMismatch{
+			{
+				Namespace:     "ns1",
+				Key:           "key1",
+				HistoryHeight: version.NewHeight(3, 0),
+				Reason:        "key is present in the history but absent from the state",
+			},
+		}, report.Mismatches)
+		report, err = historydb.CrossCheckState("ns1", "key2", stateDB, store)
+		require.NoError(t, err)
+		require.Empty(t, report.Mismatches)
+		require.Equal(t, uint64(1), report.KeysSkipped)
+	})
+}
@@ -7,11 +7,18 @@ SPDX-License-Identifier: Apache-2.0
 package history
 
 import (
+	"sync"
+	"sync/atomic"
+	"time"
+
 	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/hyperledger/fabric-protos-go/ledger/rwset/kvrwset"
 	"github.com/hyperledger/fabric/common/flogging"
-	"github.com/hyperledger/fabric/common/ledger/blkstorage"
 	"github.com/hyperledger/fabric/common/ledger/dataformat"
 	"github.com/hyperledger/fabric/common/ledger/util/leveldbhelper"
+	"github.com/hyperledger/fabric/common/metrics"
+	"github.com/hyperledger/fabric/common/metrics/disabled"
 	"github.com/hyperledger/fabric/core/ledger"
 	"github.com/hyperledger/fabric/core/ledger/internal/version"
 	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/rwsetutil"
@@ -25,6 +32,57 @@ var logger = flogging.MustGetLogger("history")
 // DBProvider provides handle to HistoryDB for a given channel
 type DBProvider struct {
 	leveldbProvider *leveldbhelper.Provider
+	views           []ledger.HistoryView
+	commitListeners []ledger.HistoryCommitListener
+	// projections delivers the blocks to the registered projectors, see function `RegisterProjector`
+	projections     *projections
+	groupCommitConf *groupCommitConfig
+	stats           *stats
+	txCacheConf     *txCacheConfig
+	prefetchDepth   int
+	// noHistoryCacheSize is the maximum number of keys with no history cached per ledger
+	noHistoryCacheSize int
+	decodeWorkers      int
+	scanners           *scannerRegistry
+	indexingDisabled   map[string]struct{}
+	// normalizedNamespaces holds the namespaces whose keys are indexed in the Unicode NFC
+	normalizedNamespaces map[string]struct{}
+	// queryResultCacheSize is the maximum number of keys whose query results are cached per ledger, and
+	// queryResultCacheMaxEntries is the maximum number of results cached for a key
+	queryResultCacheSize       int
+	queryResultCacheMaxEntries int
+	// slowQueries logs the slow history queries, shared by the ledgers so that the rate of the logs is bounded
+	// across the channels
+	slowQueries *slowQueryLog
+	// scheduler schedules the retrievals from the block store by the history queries of all the ledgers
+	scheduler *queryScheduler
+	// quotas limits the usage of the history queries per client across the ledgers, see function `EnableQueryQuotas`
+	quotas *queryQuotas
+	// pageTokenKey is the key with which the page tokens of the paged queries are sealed. It is generated for each
+	// provider, so the tokens issued before a restart of the peer are invalid after the restart
+	pageTokenKey []byte
+	// responseCaps caps the size of the responses of the queries that return all their results at once, see function
+	// `EnableResponseCaps`
+	responseCaps *responseCaps
+	// keyIndexingPolicies maps a namespace to the key patterns that control which keys are indexed
+	keyIndexingPolicies map[string]*keyIndexingPolicy
+	// compactions is the scheduler of the compactions of the dropped history, if enabled
+	compactions *compactionScheduler
+	// migrationTarget is the provider to which the history is dual-written, if the migration is enabled
+	migrationTarget      *DBProvider
+	shadowReadSampleRate float64
+	// capabilities holds the enabled capabilities, see function `EnableCapabilities`
+	capabilities map[string]struct{}
+	// skipUnchangedNamespaces holds the namespaces for which the writes that do not change the value are not indexed
+	skipUnchangedNamespaces map[string]struct{}
+	// deltaEncodedNamespaces holds the namespaces whose inline values are delta encoded, with every
+	// deltaCheckpointInterval-th version of a key stored in full
+	deltaEncodedNamespaces  map[string]struct{}
+	deltaCheckpointInterval uint64
+	// valueSizeNamespaces holds the namespaces for which the sizes of the written values are recorded
+	valueSizeNamespaces map[string]struct{}
+	// transactionIndex is true if the keys written by each transaction are indexed by the block and transaction number
+	transactionIndex bool
 }
 
 // NewDBProvider instantiates DBProvider
@@ -39,57 +97,367 @@ func NewDBProvider(path string) (*DBProvider, error) {
 	if err != nil {
 		return nil, err
 	}
+	pageTokenKey, err := newPageTokenKey()
+	if err != nil {
+		levelDBProvider.Close()
+		return nil, err
+	}
 	return &DBProvider{
 		leveldbProvider: levelDBProvider,
+		stats:           newStats(&disabled.Provider{}),
+		scanners:        newScannerRegistry(),
+		pageTokenKey:    pageTokenKey,
 	}, nil
 }
 
+// EnableMetrics enables the metrics for the history commits to be reported to the given metrics provider
+func (p *DBProvider) EnableMetrics(metricsProvider metrics.Provider) {
+	p.stats = newStats(metricsProvider)
+}
+
 // MarkStartingSavepoint creates historydb to be used for a ledger that is created from a snapshot
 func (p *DBProvider) MarkStartingSavepoint(name string, savepoint *version.Height) error {
 	db := p.GetDBHandle(name)
-	err := db.levelDB.Put(savePointKey, savepoint.ToBytes(), true)
-	return errors.WithMessagef(err, "error while writing the starting save point for ledger [%s]", name)
+	if err := db.levelDB.Put(savePointKey, savepoint.ToBytes(), true); err != nil {
+		return errors.WithMessagef(err, "error while writing the starting save point for ledger [%s]", name)
+	}
+	if p.migrationTarget != nil {
+		return p.migrationTarget.MarkStartingSavepoint(name, savepoint)
+	}
+	return nil
 }
 
-// GetDBHandle gets the handle to a named database
+// GetDBHandle gets the handle to a named database. The history is served in the index format recorded for the
+// ledger, if any, which may differ from the configured format until the history is upgraded via function
+// `UpgradeFormat` (see function `DetectIndexFormat`)
 func (p *DBProvider) GetDBHandle(name string) *DB {
-	return &DB{
-		levelDB: p.leveldbProvider.GetDBHandle(name),
-		name:    name,
+	descriptor, err := p.readIndexFormat(name)
+	var db *DB
+	if err != nil || descriptor == nil {
+		db = p.newDB(name, name, p.normalizedNamespaces, p.stats.ledgerStats(name))
+		// the error is returned by function `DetectIndexFormat`, which fails the opening of the ledger
+		db.formatErr = err
+	} else {
+		db = p.newDB(name, generationDBName(name, descriptor.generation), descriptor.format.normalizedNamespaces(),
+			p.stats.ledgerStats(name))
+		db.format = descriptor
+	}
+	// the listeners are not set by function `newDB`, so that the side db of an upgrade of the index format does not
+	// notify them
+	db.commitListeners = p.commitListeners
+	return db
+}
+
+// newDB returns the historydb for the ledger `name` held by the db `dbName` within the historydb leveldb
+func (p *DBProvider) newDB(name, dbName string, normalizedNamespaces map[string]struct{}, stats *ledgerStats) *DB {
+	db := &DB{
+		levelDB:        p.leveldbProvider.GetDBHandle(dbName),
+		name:           name,
+		provider:       p,
+		views:          p.views,
+		groupCommit:    newGroupCommit(p.groupCommitConf),
+		stats:          stats,
+		txCache:        newTxCache(p.txCacheConf, stats),
+		txRetrievals:   newTxRetrievalGroup(),
+		prefetchDepth:  p.prefetchDepth,
+		noHistoryCache: newNoHistoryCache(p.noHistoryCacheSize, stats),
+		decodeWorkers:  p.decodeWorkers,
+		scanners:       p.scanners,
+		// indexingDisabled holds the namespaces for which the history indexing is disabled
+		indexingDisabled:        p.indexingDisabled,
+		normalizedNamespaces:    normalizedNamespaces,
+		skipUnchangedNamespaces: p.skipUnchangedNamespaces,
+		deltaEncodedNamespaces:  p.deltaEncodedNamespaces,
+		deltaCheckpointInterval: p.deltaCheckpointInterval,
+		valueSizeNamespaces:     p.valueSizeNamespaces,
+		transactionIndex:        p.transactionIndex,
+		keyIndexingPolicies:     p.keyIndexingPolicies,
+		slowQueries:             p.slowQueries,
+		scheduler:               p.scheduler,
+		quotas:                  p.quotas,
+		responseCaps:            p.responseCaps,
+		reportLevelSizes:        p.reportLevelSizes,
+	}
+	db.queryResultCache = newQueryResultCache(p.queryResultCacheSize, p.queryResultCacheMaxEntries, stats, db.IndexedHeight)
+	// an error in reading the savepoint fails the opening of the ledger, in the recovery of the history
+	if height, err := db.IndexedHeight(); err == nil {
+		db.appliedHeight = height
 	}
+	if p.migrationTarget != nil {
+		db.migration = newMigration(db, p.migrationTarget.GetDBHandle(name), p.shadowReadSampleRate)
+	}
+	return db
+}
+
+// reportLevelSizes reports the sizes of the levels of the leveldb that holds the history of all the channels
+func (p *DBProvider) reportLevelSizes() {
+	levelSizes, err := p.leveldbProvider.LevelSizes()
+	if err != nil {
+		logger.Warnw("Error while retrieving the level sizes of the history database", "error", err)
+		return
+	}
+	p.stats.updateLevelSizes(levelSizes)
 }
 
 // Close closes the underlying db
 func (p *DBProvider) Close() {
+	p.scanners.close()
+	if p.compactions != nil {
+		p.compactions.close()
+	}
 	p.leveldbProvider.Close()
+	if p.migrationTarget != nil {
+		p.migrationTarget.Close()
+	}
 }
 
-// Drop drops channel-specific data from the history db
+// Drop drops channel-specific data, including that of all the generations of the index format, the checkpoints of the
+// history projectors and the pending invalidations, from the history db. If the scheduled compaction is enabled, the
+// channel is recorded for compacting the dropped data in the next maintenance window
 func (p *DBProvider) Drop(channelName string) error {
-	return p.leveldbProvider.Drop(channelName)
+	if err := p.dropHistory(channelName); err != nil {
+		return err
+	}
+	if err := p.dropProjections(channelName); err != nil {
+		return err
+	}
+	return p.dropInvalidations(channelName)
+}
+
+// dropHistory drops the history entries of the channel, including that of all the generations of the index format,
+// which is replaced by the history rebuilt (see function `replaceHistory`)
+func (p *DBProvider) dropHistory(channelName string) error {
+	if err := p.dropDB(channelName); err != nil {
+		return err
+	}
+	descriptor, err := p.readIndexFormat(channelName)
+	if err != nil {
+		return err
+	}
+	if descriptor != nil {
+		// the generations prior to the previous are dropped by the upgrades and the next generation may hold the
+		// history partially upgraded
+		for generation := uint64(1); generation <= descriptor.generation+1; generation++ {
+			if err := p.dropDBIfNotEmpty(generationDBName(channelName, generation)); err != nil {
+				return err
+			}
+		}
+	}
+	if err := p.deleteIndexFormat(channelName); err != nil {
+		return err
+	}
+	if p.migrationTarget != nil {
+		return p.migrationTarget.Drop(channelName)
+	}
+	return nil
+}
+
+// dropDB drops the db `dbName` within the historydb leveldb and records it as pending compaction, if the scheduled
+// compaction is enabled
+func (p *DBProvider) dropDB(dbName string) error {
+	if err := p.leveldbProvider.Drop(dbName); err != nil {
+		return err
+	}
+	if p.compactions != nil {
+		return p.compactions.markPending(dbName)
+	}
+	return nil
 }
 
 // DB maintains and provides access to history data for a particular channel
 type DB struct {
 	levelDB *leveldbhelper.DBHandle
 	name    string
+	views   []ledger.HistoryView
+	// commitListeners are notified of the writes of each block committed, see function `RegisterCommitListener`
+	commitListeners []ledger.HistoryCommitListener
+	// appliedHeight is the height of the blocks whose history writes are written to the leveldb, i.e., excluding the
+	// blocks whose writes are held back by the group commit. It is accessed atomically
+	appliedHeight uint64
+	// statsLock serializes the updates to the index statistics between the block commits and the backfill.
+	// The statsLock also guards the pending writes of the group commit
+	statsLock   sync.Mutex
+	groupCommit *groupCommit
+	stats       *ledgerStats
+	txCache     *txCache
+	// txRetrievals coalesces the concurrent retrievals of the same transaction from the block store
+	txRetrievals *txRetrievalGroup
+	// prefetchDepth is the number of entries, per history query, whose transactions are retrieved ahead of the consumer
+	prefetchDepth  int
+	noHistoryCache *noHistoryCache
+	// queryResultCache caches the results of the history queries for the hot keys
+	queryResultCache *queryResultCache
+	// decodeWorkers is the number of goroutines resolving the key modifications for a bulk scan
+	decodeWorkers int
+	// scanners tracks the open history scanners, shared by the ledgers of the DBProvider
+	scanners                *scannerRegistry
+	indexingDisabled        map[string]struct{}
+	normalizedNamespaces    map[string]struct{}
+	skipUnchangedNamespaces map[string]struct{}
+	deltaEncodedNamespaces  map[string]struct{}
+	deltaCheckpointInterval uint64
+	valueSizeNamespaces     map[string]struct{}
+	transactionIndex        bool
+	keyIndexingPolicies     map[string]*keyIndexingPolicy
+	slowQueries             *slowQueryLog
+	scheduler               *queryScheduler
+	quotas                  *queryQuotas
+	responseCaps            *responseCaps
+	// reportLevelSizes reports the sizes of the levels of the leveldb shared by the ledgers of the DBProvider
+	reportLevelSizes func()
+	// migration dual-writes the history to the migration target, if the migration is enabled
+	migration *migration
+	// provider is the provider of this historydb
+	provider *DBProvider
+	// format is the recorded index format of the history, if any, formatErr is the error, if any, in reading the
+	// recorded index format, and formatUpgrade is the upgrade of the history to the configured index format in
+	// progress, if any, which is set and read under the statsLock
+	format        *indexFormatDescriptor
+	formatErr     error
+	formatUpgrade *formatUpgrade
+	// chaincodeHints holds the map of namespace to the indexing hints declared by its chaincode, replaced as a whole
+	// under the chaincodeHintsLock
+	chaincodeHints     atomic.Value
+	chaincodeHintsLock sync.Mutex
 }
 
 // Commit implements method in HistoryDB interface
 func (d *DB) Commit(block *common.Block) error {
+	startCommit := time.Now()
 	blockNo := block.Header.Number
-	// Set the starting tranNo to 0
-	var tranNo uint64
 
-	dbBatch := d.levelDB.NewUpdateBatch()
+	d.statsLock.Lock()
+	defer d.statsLock.Unlock()
+	if d.groupCommit != nil && d.groupCommit.err != nil {
+		return d.groupCommit.err
+	}
+	// the writes are added to the batch of the pending blocks, if any
+	dbBatch, statsTracker := d.pendingBatch()
 
 	logger.Debugf("Channel [%s]: Updating history database for blockNo [%v] with [%d] transactions",
 		d.name, blockNo, len(block.Data.Data))
 
+	// add a history record for each write. The dataKeys are built into a pooled buffer, as the batch copies the keys
+	numKeys := 0
+	keyBuf := keyBufferPool.Get().(*[]byte)
+	defer keyBufferPool.Put(keyBuf)
+	var commit *ledger.HistoryCommit
+	if len(d.commitListeners) > 0 {
+		commit = &ledger.HistoryCommit{LedgerID: d.name, BlockNum: blockNo}
+	}
+	tranNo, err := d.visitBlockTxs(block, func(tranNo uint64, chdr *common.ChannelHeader, payload *common.Payload, txRWSet *rwsetutil.TxRwSet) error {
+		if err := d.addLifecycleApproval(dbBatch, blockNo, tranNo, payload, txRWSet); err != nil {
+			return err
+		}
+		if commit != nil {
+			commit.Txs = append(commit.Txs, newHistoryTxWrites(tranNo, chdr, txRWSet))
+		}
+		return visitTxWrites(tranNo, chdr, txRWSet, func(tranNo uint64, chdr *common.ChannelHeader, ns string, kvWrite *kvrwset.KVWrite) error {
+			key := d.normalizeKey(ns, kvWrite.Key)
+			if !d.isKeyIndexed(ns, key) {
+				return nil
+			}
+			if _, ok := d.skipUnchangedNamespaces[ns]; ok {
+				unchanged, err := statsTracker.isUnchanged(ns, key, kvWrite.Value, rwsetutil.IsKVWriteDelete(kvWrite))
+				if err != nil || unchanged {
+					return err
+				}
+			}
+			dataKey := appendDataKey((*keyBuf)[:0], ns, key, blockNo, tranNo)
+			*keyBuf = dataKey
+			// No value is required, write an empty byte array (emptyValue) since Put() of nil is not allowed,
+			// unless the chaincode requests the key modification to be stored inline
+			val := emptyValue
+			if hints := d.hintsFor(ns); hints != nil && hints.inlineValues {
+				var err error
+				if val, err = statsTracker.marshalInline(ns, key, blockNo, tranNo, &queryresult.KeyModification{
+					TxId:      chdr.TxId,
+					Value:     kvWrite.Value,
+					Timestamp: chdr.Timestamp,
+					IsDelete:  rwsetutil.IsKVWriteDelete(kvWrite),
+				}); err != nil {
+					return err
+				}
+			}
+			dbBatch.Put(dataKey, val)
+			if _, ok := d.valueSizeNamespaces[ns]; ok {
+				addValueSize(dbBatch, dataKey, len(kvWrite.Value), rwsetutil.IsKVWriteDelete(kvWrite))
+			}
+			d.addTxKeyRow(dbBatch, blockNo, tranNo, dataKey)
+			numKeys++
+			if err := statsTracker.add(ns, key, blockNo, len(dataKey)+len(val)); err != nil {
+				return err
+			}
+			return d.addViewRows(dbBatch, dataKey, ns, key, kvWrite.Value, rwsetutil.IsKVWriteDelete(kvWrite))
+		})
+	})
+	if err == nil && commit != nil {
+		err = d.notifyCommitListeners(commit)
+	}
+	if err != nil {
+		d.discardPendingLocked(err)
+		return err
+	}
+
+	// add savepoint for recovery purpose
+	height := version.NewHeight(blockNo, tranNo)
+	dbBatch.Put(savePointKey, height.ToBytes())
+
+	// write the block's history records and savepoint to LevelDB, or hold them as pending for the group commit
+	if err := d.writeBlockBatch(dbBatch, statsTracker, blockNo+1); err != nil {
+		return err
+	}
+	if d.migration != nil {
+		d.migration.commit(block)
+	}
+	if d.formatUpgrade != nil {
+		// the upgraded history catches up with the block, should the block fail to be indexed in the upgraded history
+		if err := d.formatUpgrade.commit(block); err != nil {
+			logger.Warnw("Error while committing block to the upgraded history", "channel", d.name,
+				"blockNum", blockNo, "error", err)
+		}
+	}
+	d.stats.updateKeysIndexed(numKeys)
+	d.stats.updateCommitTime(time.Since(startCommit))
+
+	logger.Debugf("Channel [%s]: Updates committed to history database for blockNo [%v]", d.name, blockNo)
+	return nil
+}
+
+// visitBlockWrites invokes the function visit for each key write of the valid endorser transactions in the block,
+// along with the transaction number and the channel header of the transaction. Returns the number of transactions
+// in the block
+func (d *DB) visitBlockWrites(block *common.Block,
+	visit func(tranNo uint64, chdr *common.ChannelHeader, ns string, kvWrite *kvrwset.KVWrite) error) (uint64, error) {
+	return d.visitBlockTxs(block, func(tranNo uint64, chdr *common.ChannelHeader, _ *common.Payload, txRWSet *rwsetutil.TxRwSet) error {
+		return visitTxWrites(tranNo, chdr, txRWSet, visit)
+	})
+}
+
+// visitTxWrites invokes the function visit for each key write in the read-write set of a transaction
+func visitTxWrites(tranNo uint64, chdr *common.ChannelHeader, txRWSet *rwsetutil.TxRwSet,
+	visit func(tranNo uint64, chdr *common.ChannelHeader, ns string, kvWrite *kvrwset.KVWrite) error) error {
+	for _, nsRWSet := range txRWSet.NsRwSets {
+		for _, kvWrite := range nsRWSet.KvRwSet.Writes {
+			if err := visit(tranNo, chdr, nsRWSet.NameSpace, kvWrite); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// visitBlockTxs invokes the function visit for each valid endorser transaction in the block, along with the
+// transaction number, the channel header, the payload, and the read-write set of the transaction. Returns the
+// number of transactions in the block
+func (d *DB) visitBlockTxs(block *common.Block,
+	visit func(tranNo uint64, chdr *common.ChannelHeader, payload *common.Payload, txRWSet *rwsetutil.TxRwSet) error) (uint64, error) {
+	// Set the starting tranNo to 0
+	var tranNo uint64
+
 	// Get the invalidation byte array for the block
 	txsFilter := txflags.ValidationFlags(block.Metadata.Metadata[common.BlockMetadataIndex_TRANSACTIONS_FILTER])
 
-	// write each tran's write set to history db
 	for _, envBytes := range block.Data.Data {
 
 		// If the tran is marked as invalid, skip it
@@ -102,38 +470,31 @@ func (d *DB) Commit(block *common.Block) error {
 
 		env, err := protoutil.GetEnvelopeFromBlock(envBytes)
 		if err != nil {
-			return err
+			return 0, err
 		}
 
 		payload, err := protoutil.UnmarshalPayload(env.Payload)
 		if err != nil {
-			return err
+			return 0, err
 		}
 
 		chdr, err := protoutil.UnmarshalChannelHeader(payload.Header.ChannelHeader)
 		if err != nil {
-			return err
+			return 0, err
 		}
 
 		if common.HeaderType(chdr.Type) == common.HeaderType_ENDORSER_TRANSACTION {
 			// extract RWSet from transaction
 			respPayload, err := protoutil.GetActionFromEnvelope(envBytes)
 			if err != nil {
-				return err
+				return 0, err
 			}
 			txRWSet := &rwsetutil.TxRwSet{}
 			if err = txRWSet.FromProtoBytes(respPayload.Results); err != nil {
-				return err
+				return 0, err
 			}
-			// add a history record for each write
-			for _, nsRWSet := range txRWSet.NsRwSets {
-				ns := nsRWSet.NameSpace
-
-				for _, kvWrite := range nsRWSet.KvRwSet.Writes {
-					dataKey := constructDataKey(ns, kvWrite.Key, blockNo, tranNo)
-					// No value is required, write an empty byte array (emptyValue) since Put() of nil is not allowed
-					dbBatch.Put(dataKey, emptyValue)
-				}
+			if err := visit(tranNo, chdr, payload, txRWSet); err != nil {
+				return 0, err
 			}
 
 		} else {
@@ -141,24 +502,29 @@ func (d *DB) Commit(block *common.Block) error {
 		}
 		tranNo++
 	}
+	return tranNo, nil
+}
 
-	// add savepoint for recovery purpose
-	height := version.NewHeight(blockNo, tranNo)
-	dbBatch.Put(savePointKey, height.ToBytes())
+// NewQueryExecutor implements method in HistoryDB interface
+func (d *DB) NewQueryExecutor(txFetcher TxFetcher) (ledger.HistoryQueryExecutor, error) {
+	return &QueryExecutor{levelDB: d.levelDB, txFetcher: txFetcher, historyDB: d}, nil
+}
 
-	// write the block's history records and savepoint to LevelDB
-	// Setting snyc to true as a precaution, false may be an ok optimization after further testing.
-	if err := d.levelDB.WriteBatch(dbBatch, true); err != nil {
-		return err
+// IndexedHeight returns the height of the blocks whose writes are indexed, i.e., the block number following the
+// savepoint. The history writes for a committed block are reflected in the queries only after the block is indexed,
+// which may lag behind the block commits with the asynchronous history commit or the group commit
+func (d *DB) IndexedHeight() (uint64, error) {
+	savepoint, err := d.GetLastSavepoint()
+	if err != nil || savepoint == nil {
+		return 0, err
 	}
-
-	logger.Debugf("Channel [%s]: Updates committed to history database for blockNo [%v]", d.name, blockNo)
-	return nil
+	return savepoint.BlockNum + 1, nil
 }
 
-// NewQueryExecutor implements method in HistoryDB interface
-func (d *DB) NewQueryExecutor(blockStore *blkstorage.BlockStore) (ledger.HistoryQueryExecutor, error) {
-	return &QueryExecutor{d.levelDB, blockStore}, nil
+// AppliedHeight returns the height of the blocks whose history writes are written to the history database. Unlike
+// function `IndexedHeight`, the height is tracked in memory and hence, may be retrieved on every block commit
+func (d *DB) AppliedHeight() uint64 {
+	return atomic.LoadUint64(&d.appliedHeight)
 }
 
 // GetLastSavepoint implements returns the height till which the history is present in the db
@@ -176,14 +542,30 @@ func (d *DB) GetLastSavepoint() (*version.Height, error) {
 
 // ShouldRecover implements method in interface kvledger.Recoverer
 func (d *DB) ShouldRecover(lastAvailableBlock uint64) (bool, uint64, error) {
-	savepoint, err := d.GetLastSavepoint()
+	nextBlock, err := d.NextIndexBatchBlock()
 	if err != nil {
 		return false, 0, err
 	}
-	if savepoint == nil {
-		return true, 0, nil
+	// the migration target, if behind, is caught up along with the historydb
+	if d.migration != nil {
+		targetNextBlock, err := d.migration.target.NextIndexBatchBlock()
+		if err != nil {
+			return false, 0, err
+		}
+		if targetNextBlock < nextBlock {
+			nextBlock = targetNextBlock
+		}
 	}
-	return savepoint.BlockNum != lastAvailableBlock, savepoint.BlockNum + 1, nil
+	return nextBlock != lastAvailableBlock+1, nextBlock, nil
+}
+
+// PurgeCaches removes all the entries from the caches of this historydb, i.e., the decoded transaction cache, the cache
+// of the keys with no history and the query result cache, so that the subsequent queries are served from the leveldb
+// and the block store
+func (d *DB) PurgeCaches() {
+	d.txCache.purge()
+	d.noHistoryCache.purge()
+	d.queryResultCache.purge()
 }
 
 // Name returns the name of the database that manages historical states.
@@ -201,5 +583,15 @@ func (d *DB) CommitLostBlock(blockAndPvtdata *ledger.BlockAndPvtData) error {
 	} else {
 		logger.Debugf("Recommitting block [%d] to history database", block.Header.Number)
 	}
+	if d.migration != nil {
+		nextBlock, err := d.NextIndexBatchBlock()
+		if err != nil {
+			return err
+		}
+		if block.Header.Number < nextBlock {
+			d.migration.commit(block)
+			return nil
+		}
+	}
 	return d.Commit(block)
 }
@@ -26,6 +26,7 @@ import (
 	"github.com/hyperledger/fabric/core/ledger"
 	"github.com/hyperledger/fabric/core/ledger/internal/version"
 	"github.com/hyperledger/fabric/internal/pkg/txflags"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/require"
 )
 
@@ -432,12 +433,117 @@ func TestHistoryWithBlockNumber256(t *testing.T) {
 	testutilVerifyResults(t, qhistory, "ns1", "key", expectedHistoryResults)
 }
 
+func TestHistoryQueryPrefetch(t *testing.T) {
+	env := newTestHistoryEnv(t)
+	defer env.cleanup()
+	store1, err := env.testBlockStorageEnv.provider.Open("ledger1")
+	require.NoError(t, err)
+	defer store1.Shutdown()
+
+	env.testHistoryDBProvider.EnableQueryPrefetch(3)
+	historydb := env.testHistoryDBProvider.GetDBHandle("ledger1")
+	bg, gb := testutil.NewBlockGenerator(t, "ledger1", false)
+	require.NoError(t, store1.AddBlock(gb))
+	require.NoError(t, historydb.Commit(gb))
+
+	expectedHistoryResults := []string{}
+	for i := 1; i <= 10; i++ {
+		simulator, _ := env.txmgr.NewTxSimulator(util2.GenerateUUID())
+		value := fmt.Sprintf("value%d", i)
+		require.NoError(t, simulator.SetState("ns1", "key", []byte(value)))
+		simulator.Done()
+		simRes, _ := simulator.GetTxSimulationResults()
+		pubSimResBytes, _ := simRes.GetPubSimulationBytes()
+		block := bg.NextBlock([][]byte{pubSimResBytes})
+		require.NoError(t, store1.AddBlock(block))
+		require.NoError(t, historydb.Commit(block))
+		expectedHistoryResults = append([]string{value}, expectedHistoryResults...)
+	}
+
+	qhistory, err := historydb.NewQueryExecutor(store1)
+	require.NoError(t, err)
+	testutilVerifyResults(t, qhistory, "ns1", "key", expectedHistoryResults)
+
+	// the scanner reads ahead no more than the prefetch depth
+	itr, err := qhistory.GetHistoryForKey("ns1", "key")
+	require.NoError(t, err)
+	kmod, err := itr.Next()
+	require.NoError(t, err)
+	require.Equal(t, []byte("value10"), kmod.(*queryresult.KeyModification).Value)
+	require.Len(t, itr.(*historyScanner).lookahead, 3)
+	// the transactions being prefetched are not waited upon when the scanner is closed early
+	itr.Close()
+
+	env.testHistoryDBProvider.EnableQueryPrefetch(100)
+	historydb = env.testHistoryDBProvider.GetDBHandle("ledger1")
+	qhistory, err = historydb.NewQueryExecutor(store1)
+	require.NoError(t, err)
+	testutilVerifyResults(t, qhistory, "ns1", "key", expectedHistoryResults)
+}
+
+func TestHistoryRecordPool(t *testing.T) {
+	record := newHistoryRecord(5, 2)
+	record.inlineVal = []byte("value")
+	record.fetched = make(chan struct{})
+	record.err = errors.New("error")
+	record.release()
+
+	// a record obtained from the pool carries nothing over from its previous use
+	record = newHistoryRecord(7, 1)
+	require.Equal(t, &historyRecord{blockNum: 7, tranNum: 1}, record)
+}
+
 func TestName(t *testing.T) {
 	env := newTestHistoryEnv(t)
 	defer env.cleanup()
 	require.Equal(t, "history", env.testHistoryDB.Name())
 }
 
+func TestPurgeCaches(t *testing.T) {
+	env := newTestHistoryEnv(t)
+	defer env.cleanup()
+	store, err := env.testBlockStorageEnv.provider.Open("ledger1")
+	require.NoError(t, err)
+	defer store.Shutdown()
+
+	env.testHistoryDBProvider.EnableDecodedTxCache(10, 0)
+	env.testHistoryDBProvider.EnableNoHistoryCache(10)
+	env.testHistoryDBProvider.EnableQueryResultCache(10, 10)
+	historydb := env.testHistoryDBProvider.GetDBHandle("ledger1")
+	bg, gb := testutil.NewBlockGenerator(t, "ledger1", false)
+	simulator, err := env.txmgr.NewTxSimulator(util2.GenerateUUID())
+	require.NoError(t, err)
+	require.NoError(t, simulator.SetState("ns1", "key1", []byte("value1")))
+	simulator.Done()
+	simRes, err := simulator.GetTxSimulationResults()
+	require.NoError(t, err)
+	pubSimResBytes, err := simRes.GetPubSimulationBytes()
+	require.NoError(t, err)
+	for _, block := range []*common.Block{gb, bg.NextBlock([][]byte{pubSimResBytes})} {
+		require.NoError(t, store.AddBlock(block))
+		require.NoError(t, historydb.Commit(block))
+	}
+
+	qe, err := historydb.NewQueryExecutor(store)
+	require.NoError(t, err)
+	testutilVerifyResults(t, qe, "ns1", "key1", []string{"value1"})
+	testutilVerifyResults(t, qe, "ns1", "key2", []string{})
+	require.Equal(t, 1, historydb.txCache.len())
+	require.Equal(t, 1, historydb.noHistoryCache.len())
+	require.Equal(t, 2, historydb.queryResultCache.len())
+
+	historydb.PurgeCaches()
+	require.Equal(t, 0, historydb.txCache.len())
+	require.Equal(t, 0, historydb.noHistoryCache.len())
+	require.Equal(t, 0, historydb.queryResultCache.len())
+	// the queries are served as before and repopulate the caches
+	testutilVerifyResults(t, qe, "ns1", "key1", []string{"value1"})
+	testutilVerifyResults(t, qe, "ns1", "key2", []string{})
+	require.Equal(t, 1, historydb.txCache.len())
+	require.Equal(t, 1, historydb.noHistoryCache.len())
+	require.Equal(t, 2, historydb.queryResultCache.len())
+}
+
 func TestDrop(t *testing.T) {
 	env := newTestHistoryEnv(t)
 	defer env.cleanup()
@@ -0,0 +1,214 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/pkg/errors"
+)
+
+// deltaEncodedValueMarker is the first byte of an inline value that holds the key modification with the value encoded
+// as a delta. As a field number cannot be 0, a marshalled key modification never begins with this byte
+const deltaEncodedValueMarker = 0x00
+
+// EnableDeltaEncoding enables, for the given namespaces, storing the values of the key modifications that are stored
+// inline in the history entries, i.e., those of the chaincodes that request the values to be inlined and those applied
+// from a replication stream, as deltas against the previous inline value of the key. This suits the namespaces of
+// large documents that are updated in parts. A delta holds the bytes that differ from the previous value, between
+// their common prefix and suffix, and the value is reconstructed when the entry is read. Every checkpointInterval-th
+// version of a key, and any version for which the delta is not smaller than the value, is stored in full, so that the
+// reconstruction reads at most checkpointInterval entries. The entries imported from a snapshot or backfilled from an
+// archive are stored in full. As the delta-encoded entries cannot be read by the peers that do not support the delta
+// encoding, the capability CapabilityDeltaEncoding is required to be enabled (see function `EnableCapabilities`).
+func (p *DBProvider) EnableDeltaEncoding(namespaces []string, checkpointInterval int) error {
+	if len(namespaces) == 0 {
+		p.deltaEncodedNamespaces = nil
+		return nil
+	}
+	if !p.capabilityEnabled(CapabilityDeltaEncoding) {
+		return errors.Errorf("delta encoding of the history values requires the capability [%s] to be enabled", CapabilityDeltaEncoding)
+	}
+	if checkpointInterval < 2 {
+		return errors.Errorf("invalid checkpoint interval [%d] for the delta encoding, expected a value of 2 or more", checkpointInterval)
+	}
+	deltaEncodedNamespaces := map[string]struct{}{}
+	for _, ns := range namespaces {
+		if ns == "" {
+			return errors.New("invalid namespace for the delta encoding, the namespace cannot be empty")
+		}
+		deltaEncodedNamespaces[ns] = struct{}{}
+	}
+	p.deltaEncodedNamespaces = deltaEncodedNamespaces
+	p.deltaCheckpointInterval = uint64(checkpointInterval)
+	return nil
+}
+
+// inlineBase is the latest inline value of a key, against which the next inline value of the key is delta encoded
+type inlineBase struct {
+	blockNum, tranNum uint64
+	value             []byte
+	isDelete          bool
+	// depth is the number of deltas to apply to the nearest full value to reconstruct the value
+	depth uint64
+}
+
+// marshalInline returns the inline value of the history entry for the given key modification, with the value delta
+// encoded against the previous inline value of the key if the delta encoding is enabled for the namespace. The
+// previous inline value is looked up in the entries being added to the batch and then in the historydb
+func (t *indexStatsTracker) marshalInline(ns, key string, blockNum, tranNum uint64, km *queryresult.KeyModification) ([]byte, error) {
+	if _, ok := t.db.deltaEncodedNamespaces[ns]; !ok {
+		return marshalKeyModification(km)
+	}
+	scanKey := string(constructRangeScan(ns, key).startKey)
+	base, ok := t.inlineBases[scanKey]
+	if !ok {
+		var err error
+		if base, err = t.db.latestInlineBase(ns, key); err != nil {
+			return nil, err
+		}
+	}
+	current := &inlineBase{blockNum: blockNum, tranNum: tranNum, value: km.Value, isDelete: km.IsDelete}
+	t.inlineBases[scanKey] = current
+
+	// a base at the same height is the entry being replaced, as for the writes to two forms of a normalized key
+	if base == nil || base.isDelete || km.IsDelete || base.depth+1 >= t.db.deltaCheckpointInterval ||
+		(base.blockNum == blockNum && base.tranNum == tranNum) {
+		return marshalKeyModification(km)
+	}
+	prefixLen, suffixLen := commonPrefixSuffixLen(base.value, km.Value)
+	middle := km.Value[prefixLen : len(km.Value)-suffixLen]
+	deltaKM := &queryresult.KeyModification{TxId: km.TxId, Value: middle, Timestamp: km.Timestamp}
+	deltaKMBytes, err := marshalKeyModification(deltaKM)
+	if err != nil {
+		return nil, err
+	}
+	buf := proto.NewBuffer([]byte{deltaEncodedValueMarker})
+	// the errors are always nil for the encoding of varints
+	_ = buf.EncodeVarint(base.depth + 1)
+	_ = buf.EncodeVarint(base.blockNum)
+	_ = buf.EncodeVarint(base.tranNum)
+	_ = buf.EncodeVarint(uint64(prefixLen))
+	_ = buf.EncodeVarint(uint64(suffixLen))
+	delta := append(buf.Bytes(), deltaKMBytes...)
+	full, err := marshalKeyModification(km)
+	if err != nil || len(delta) >= len(full) {
+		return full, err
+	}
+	current.depth = base.depth + 1
+	return delta, nil
+}
+
+// latestInlineBase returns the latest entry of the key as the base for the delta encoding, or nil if the key has no
+// entries or the latest entry is not stored inline
+func (d *DB) latestInlineBase(ns, key string) (*inlineBase, error) {
+	rangeScan := constructRangeScan(ns, key)
+	itr, err := d.levelDB.GetIterator(rangeScan.startKey, rangeScan.endKey)
+	if err != nil {
+		return nil, err
+	}
+	defer itr.Release()
+	if !itr.Last() {
+		if err := itr.Error(); err != nil {
+			return nil, errors.Wrap(err, "internal leveldb error while iterating for history entries")
+		}
+		return nil, nil
+	}
+	if len(itr.Value()) == 0 {
+		return nil, nil
+	}
+	blockNum, tranNum, err := rangeScan.decodeBlockNumTranNum(itr.Key())
+	if err != nil {
+		return nil, err
+	}
+	km, depth, err := d.decodeInline(ns, key, itr.Value())
+	if err != nil {
+		return nil, err
+	}
+	return &inlineBase{blockNum: blockNum, tranNum: tranNum, value: km.Value, isDelete: km.IsDelete, depth: depth}, nil
+}
+
+// decodeInlineEntry decodes the key modification stored inline in a history entry of the given key, reconstructing
+// the value if delta encoded
+func (d *DB) decodeInlineEntry(ns, key string, val []byte) (*queryresult.KeyModification, error) {
+	km, _, err := d.decodeInline(ns, key, val)
+	return km, err
+}
+
+// decodeInline decodes the key modification stored inline and returns it along with the number of deltas applied to
+// reconstruct its value
+func (d *DB) decodeInline(ns, key string, val []byte) (*queryresult.KeyModification, uint64, error) {
+	if len(val) == 0 || val[0] != deltaEncodedValueMarker {
+		km, err := decodeInlineKeyModification(val)
+		return km, 0, err
+	}
+	buf := proto.NewBuffer(val[1:])
+	var fields [5]uint64
+	for i := range fields {
+		var err error
+		if fields[i], err = buf.DecodeVarint(); err != nil {
+			return nil, 0, errors.Wrap(err, "error while decoding the delta encoded key modification")
+		}
+	}
+	depth, baseBlockNum, baseTranNum, prefixLen, suffixLen := fields[0], fields[1], fields[2], fields[3], fields[4]
+	km, err := decodeInlineKeyModification(buf.Unread())
+	if err != nil {
+		return nil, 0, err
+	}
+	baseVal, err := d.levelDB.Get(constructDataKey(ns, key, baseBlockNum, baseTranNum))
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(baseVal) == 0 {
+		return nil, 0, errors.Errorf("base entry at block [%d] transaction [%d] not found for the delta encoded value of namespace [%s] key [%s]",
+			baseBlockNum, baseTranNum, ns, key)
+	}
+	base, baseDepth, err := d.decodeInline(ns, key, baseVal)
+	if err != nil {
+		return nil, 0, err
+	}
+	if baseDepth+1 != depth || prefixLen+suffixLen > uint64(len(base.Value)) {
+		return nil, 0, errors.Errorf("delta encoded value of namespace [%s] key [%s] does not match its base entry at block [%d] transaction [%d]",
+			ns, key, baseBlockNum, baseTranNum)
+	}
+	value := make([]byte, 0, prefixLen+uint64(len(km.Value))+suffixLen)
+	value = append(value, base.Value[:prefixLen]...)
+	value = append(value, km.Value...)
+	km.Value = append(value, base.Value[uint64(len(base.Value))-suffixLen:]...)
+	return km, depth, nil
+}
+
+// isDeltaEncoded returns true if the inline value holds a delta encoded key modification
+func isDeltaEncoded(val []byte) bool {
+	return len(val) > 0 && val[0] == deltaEncodedValueMarker
+}
+
+func marshalKeyModification(km *queryresult.KeyModification) ([]byte, error) {
+	b, err := proto.Marshal(km)
+	if err != nil {
+		return nil, errors.Wrap(err, "error while marshalling key modification")
+	}
+	return b, nil
+}
+
+// commonPrefixSuffixLen returns the lengths of the common prefix and of the common suffix of the given values, which
+// do not overlap in either of the values
+func commonPrefixSuffixLen(a, b []byte) (int, int) {
+	maxLen := len(a)
+	if len(b) < maxLen {
+		maxLen = len(b)
+	}
+	prefixLen := 0
+	for prefixLen < maxLen && a[prefixLen] == b[prefixLen] {
+		prefixLen++
+	}
+	suffixLen := 0
+	for suffixLen < maxLen-prefixLen && a[len(a)-1-suffixLen] == b[len(b)-1-suffixLen] {
+		suffixLen++
+	}
+	return prefixLen, suffixLen
+}
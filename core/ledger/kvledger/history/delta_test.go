@@ -0,0 +1,157 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric/common/ledger/testutil"
+	util2 "github.com/hyperledger/fabric/common/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeltaEncoding(t *testing.T) {
+	env := newTestHistoryEnv(t)
+	defer env.cleanup()
+	provider := env.testHistoryDBProvider
+	require.EqualError(t, provider.EnableDeltaEncoding([]string{"mycc"}, 3),
+		"delta encoding of the history values requires the capability [DeltaEncoding] to be enabled")
+	require.NoError(t, provider.EnableCapabilities([]string{CapabilityValueInlining, CapabilityDeltaEncoding}))
+	require.EqualError(t, provider.EnableDeltaEncoding([]string{"mycc"}, 1),
+		"invalid checkpoint interval [1] for the delta encoding, expected a value of 2 or more")
+	require.EqualError(t, provider.EnableDeltaEncoding([]string{""}, 3),
+		"invalid namespace for the delta encoding, the namespace cannot be empty")
+	require.NoError(t, provider.EnableDeltaEncoding([]string{"mycc"}, 3))
+
+	store, err := env.testBlockStorageEnv.provider.Open("ledger1")
+	require.NoError(t, err)
+	defer store.Shutdown()
+	historydb := provider.GetDBHandle("ledger1")
+	require.NoError(t, historydb.DetectIndexFormat())
+	historydb.setChaincodeHints("mycc", &chaincodeHints{inlineValues: true})
+	bg, gb := testutil.NewBlockGenerator(t, "ledger1", false)
+	var blocks []*common.Block
+	commit := func(block *common.Block) {
+		require.NoError(t, store.AddBlock(block))
+		require.NoError(t, historydb.Commit(block))
+		blocks = append(blocks, block)
+	}
+	commit(gb)
+	padding := strings.Repeat("x", 200)
+	document := func(version int) string {
+		return fmt.Sprintf(`{"owner":"alice","padding":"%s","version":%d}`, padding, version)
+	}
+	// each value is written to the key by a transaction, with an empty value for a delete
+	commitValues := func(values ...string) {
+		var txs [][]byte
+		for _, v := range values {
+			simulator, err := env.txmgr.NewTxSimulator(util2.GenerateUUID())
+			require.NoError(t, err)
+			if v == "" {
+				require.NoError(t, simulator.DeleteState("mycc", "doc1"))
+			} else {
+				require.NoError(t, simulator.SetState("mycc", "doc1", []byte(v)))
+			}
+			simulator.Done()
+			simRes, err := simulator.GetTxSimulationResults()
+			require.NoError(t, err)
+			pubSimResBytes, err := simRes.GetPubSimulationBytes()
+			require.NoError(t, err)
+			txs = append(txs, pubSimResBytes)
+		}
+		commit(bg.NextBlock(txs))
+	}
+	commitValues(document(1))
+	commitValues(document(2), document(3))
+	commitValues(document(4))
+	commitValues("")
+	commitValues(document(5), "x")
+
+	expectedVals := []string{"x", document(5), "", document(4), document(3), document(2), document(1)}
+	qe, err := historydb.NewQueryExecutor(nil)
+	require.NoError(t, err)
+	testutilVerifyResults(t, qe, "mycc", "doc1", expectedVals)
+
+	// every third version is stored in full, as are the versions following a delete and those whose delta is larger
+	deltaEncoded := func(db *DB) []bool {
+		var encoded []bool
+		itr, err := db.levelDB.GetIterator(scanRange("mycc", "doc1"))
+		require.NoError(t, err)
+		defer itr.Release()
+		for itr.Next() {
+			encoded = append(encoded, isDeltaEncoded(itr.Value()))
+		}
+		return encoded
+	}
+	require.Equal(t, []bool{false, true, true, false, false, false, false}, deltaEncoded(historydb))
+
+	// the delta encoding applies to the index batches applied from a replication stream
+	replicaProvider, err := NewDBProvider(t.TempDir())
+	require.NoError(t, err)
+	defer replicaProvider.Close()
+	require.NoError(t, replicaProvider.EnableCapabilities([]string{CapabilityDeltaEncoding}))
+	require.NoError(t, replicaProvider.EnableDeltaEncoding([]string{"mycc"}, 3))
+	replica := replicaProvider.GetDBHandle("ledger1")
+	for _, block := range blocks {
+		batch, err := historydb.NewIndexBatch(block)
+		require.NoError(t, err)
+		require.NoError(t, replica.ApplyIndexBatch(batch))
+	}
+	replicaQE, err := replica.NewQueryExecutor(nil)
+	require.NoError(t, err)
+	testutilVerifyResults(t, replicaQE, "mycc", "doc1", expectedVals)
+	require.Equal(t, []bool{false, true, true, false, false, false, false}, deltaEncoded(replica))
+
+	// the bulk reads reconstruct the values
+	histories, err := historydb.GetHistoriesForKeys("mycc", []string{"doc1"}, nil)
+	require.NoError(t, err)
+	require.Len(t, histories["doc1"], len(expectedVals))
+	require.Equal(t, []byte(document(3)), histories["doc1"][4].Value)
+
+	// the values are stored in full by an upgrade of the index format
+	require.NoError(t, provider.EnableKeyNormalization([]string{"mycc"}))
+	require.NoError(t, historydb.UpgradeFormat(store, nil))
+	historydb = provider.GetDBHandle("ledger1")
+	require.NoError(t, historydb.DetectIndexFormat())
+	require.Equal(t, []bool{false, false, false, false, false, false, false}, deltaEncoded(historydb))
+	qe, err = historydb.NewQueryExecutor(nil)
+	require.NoError(t, err)
+	testutilVerifyResults(t, qe, "mycc", "doc1", expectedVals)
+
+	// a delta whose base is missing is reported
+	require.NoError(t, replica.levelDB.Delete(constructDataKey("mycc", "doc1", 1, 0), true))
+	itr, err := replicaQE.GetHistoryForKey("mycc", "doc1")
+	require.NoError(t, err)
+	defer itr.Close()
+	for i := 0; i < 4; i++ {
+		_, err = itr.Next()
+		require.NoError(t, err)
+	}
+	_, err = itr.Next()
+	require.EqualError(t, err, "base entry at block [1] transaction [0] not found for the delta encoded value of namespace [mycc] key [doc1]")
+}
+
+func TestCommonPrefixSuffixLen(t *testing.T) {
+	for _, tc := range []struct {
+		a, b              string
+		prefixLen, suffix int
+	}{
+		{"", "", 0, 0},
+		{"abc", "abc", 3, 0},
+		{"abcd", "abxd", 2, 1},
+		{"aaa", "aaaa", 3, 0},
+		{"xaa", "aa", 0, 2},
+		{"abc", "xyz", 0, 0},
+	} {
+		prefixLen, suffixLen := commonPrefixSuffixLen([]byte(tc.a), []byte(tc.b))
+		require.Equal(t, tc.prefixLen, prefixLen, "%s %s", tc.a, tc.b)
+		require.Equal(t, tc.suffix, suffixLen, "%s %s", tc.a, tc.b)
+	}
+}
@@ -0,0 +1,129 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+// QueryPlan describes how a history query would be executed, as reported by the explain functions without executing
+// the query, for tuning the heavy queries
+type QueryPlan struct {
+	Namespace string
+	Key       string
+	// IndexStartKey and IndexEndKey bound the range of the history index that the query scans
+	IndexStartKey []byte
+	IndexEndKey   []byte
+	// IndexedHeight is the height of the blocks reflected in the results of the query
+	IndexedHeight uint64
+	// EstimatedEntries is the number of history entries that the query is expected to return. It is derived from the
+	// index statistics of the namespace, unless EstimateExact is true, as for a query answered from a cache
+	EstimatedEntries uint64
+	EstimateExact    bool
+	// BlockFetches is true if the key modifications are expected to be resolved from the transactions in the block
+	// store, i.e., the query is not answered from a cache and the chaincode does not request the values to be stored
+	// inline. The entries imported from a snapshot or backfilled from an archive carry the values inline regardless
+	BlockFetches bool
+	// Caches are the caches consulted by the query, in the order in which they are consulted, as named in the label
+	// "cache" of the cache metrics. ServedFromCache is the cache that answers the query, if any
+	Caches          []string
+	ServedFromCache string
+	// PrefetchDepth is the number of entries whose transactions are retrieved ahead of the consumer of a query for a
+	// key, and DecodeWorkers is the number of goroutines resolving the entries for a bulk scan
+	PrefetchDepth int
+	DecodeWorkers int
+}
+
+// ExplainHistoryForKey returns the plan for the query `GetHistoryForKey` for the given key, at the indexed height of
+// the query executor, without executing the query. An error is returned if the query would fail for the key not being
+// indexed. Unlike the query, the explain does not affect the caches.
+func (q *QueryExecutor) ExplainHistoryForKey(namespace, key string) (*QueryPlan, error) {
+	d := q.historyDB
+	if !d.isIndexed(namespace) {
+		return nil, &IndexingDisabledError{Namespace: namespace}
+	}
+	key = d.normalizeKey(namespace, key)
+	if !d.isKeyIndexed(namespace, key) {
+		return nil, &KeyNotIndexedError{Namespace: namespace, Key: key}
+	}
+	indexedHeight, err := q.IndexedHeight()
+	if err != nil {
+		return nil, err
+	}
+	rangeScan := constructRangeScan(namespace, key)
+	plan := &QueryPlan{
+		Namespace:     namespace,
+		Key:           key,
+		IndexStartKey: rangeScan.startKey,
+		IndexEndKey:   rangeScan.endKey,
+		IndexedHeight: indexedHeight,
+		PrefetchDepth: d.prefetchDepth,
+	}
+	if d.noHistoryCache != nil {
+		plan.Caches = append(plan.Caches, noHistoryCacheName)
+		if d.noHistoryCache.peek(rangeScan.startKey) {
+			plan.ServedFromCache, plan.EstimateExact = noHistoryCacheName, true
+			return plan, nil
+		}
+	}
+	if d.queryResultCache != nil {
+		plan.Caches = append(plan.Caches, queryResultCacheName)
+		if numResults, ok := d.queryResultCache.peek(rangeScan.startKey, indexedHeight); ok {
+			plan.ServedFromCache, plan.EstimateExact = queryResultCacheName, true
+			plan.EstimatedEntries = uint64(numResults)
+			return plan, nil
+		}
+	}
+	if err := d.estimateEntries(plan); err != nil {
+		return nil, err
+	}
+	if plan.BlockFetches && d.txCache != nil {
+		plan.Caches = append(plan.Caches, decodedTxCacheName)
+	}
+	return plan, nil
+}
+
+// ExplainScan returns the plan for the function `Scan` for the given namespace or key, without executing the scan.
+// A scan consults only the decoded transaction cache, which it leaves untouched on a miss.
+func (d *DB) ExplainScan(ns, key string) (*QueryPlan, error) {
+	key = d.normalizeKey(ns, key)
+	indexedHeight, err := d.IndexedHeight()
+	if err != nil {
+		return nil, err
+	}
+	startKey, endKey := scanRange(ns, key)
+	plan := &QueryPlan{
+		Namespace:     ns,
+		Key:           key,
+		IndexStartKey: startKey,
+		IndexEndKey:   endKey,
+		IndexedHeight: indexedHeight,
+		DecodeWorkers: d.decodeWorkers,
+	}
+	if err := d.estimateEntries(plan); err != nil {
+		return nil, err
+	}
+	if plan.BlockFetches && d.txCache != nil {
+		plan.Caches = append(plan.Caches, decodedTxCacheName)
+	}
+	return plan, nil
+}
+
+// estimateEntries sets the estimated number of entries, and whether the block fetches are needed to resolve them, in
+// the plan. The entries for a key are estimated as the average number of entries per key in the namespace
+func (d *DB) estimateEntries(plan *QueryPlan) error {
+	stats, err := d.GetIndexStats(plan.Namespace)
+	if err != nil {
+		return err
+	}
+	switch {
+	case plan.Key == "":
+		plan.EstimatedEntries = stats.TotalIndexEntries
+	case stats.DistinctKeys > 0:
+		// rounded up, so that a key with history is not estimated to have none
+		plan.EstimatedEntries = (stats.TotalIndexEntries + stats.DistinctKeys - 1) / stats.DistinctKeys
+	}
+	hints := d.hintsFor(plan.Namespace)
+	plan.BlockFetches = plan.EstimatedEntries > 0 && (hints == nil || !hints.inlineValues)
+	return nil
+}
@@ -0,0 +1,121 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric/common/ledger/testutil"
+	util2 "github.com/hyperledger/fabric/common/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExplain(t *testing.T) {
+	env := newTestHistoryEnv(t)
+	defer env.cleanup()
+	store, err := env.testBlockStorageEnv.provider.Open("ledger1")
+	require.NoError(t, err)
+	defer store.Shutdown()
+
+	env.testHistoryDBProvider.EnableNoHistoryCache(10)
+	env.testHistoryDBProvider.EnableQueryResultCache(10, 10)
+	env.testHistoryDBProvider.EnableDecodedTxCache(10, 0)
+	env.testHistoryDBProvider.EnableQueryPrefetch(4)
+	env.testHistoryDBProvider.EnableParallelDecoding(2)
+	historydb := env.testHistoryDBProvider.GetDBHandle("ledger1")
+	bg, gb := testutil.NewBlockGenerator(t, "ledger1", false)
+	commit := func(block *common.Block) {
+		require.NoError(t, store.AddBlock(block))
+		require.NoError(t, historydb.Commit(block))
+	}
+	nextBlock := func(key, value string) *common.Block {
+		simulator, err := env.txmgr.NewTxSimulator(util2.GenerateUUID())
+		require.NoError(t, err)
+		require.NoError(t, simulator.SetState("ns1", key, []byte(value)))
+		simulator.Done()
+		simRes, err := simulator.GetTxSimulationResults()
+		require.NoError(t, err)
+		pubSimResBytes, err := simRes.GetPubSimulationBytes()
+		require.NoError(t, err)
+		return bg.NextBlock([][]byte{pubSimResBytes})
+	}
+	commit(gb)
+	commit(nextBlock("key1", "value1"))
+	commit(nextBlock("key1", "value2"))
+	commit(nextBlock("key2", "value1"))
+
+	qe, err := historydb.NewQueryExecutor(store)
+	require.NoError(t, err)
+	explainer := qe.(*QueryExecutor)
+	rangeScan := constructRangeScan("ns1", "key1")
+	plan, err := explainer.ExplainHistoryForKey("ns1", "key1")
+	require.NoError(t, err)
+	require.Equal(t, &QueryPlan{
+		Namespace:        "ns1",
+		Key:              "key1",
+		IndexStartKey:    rangeScan.startKey,
+		IndexEndKey:      rangeScan.endKey,
+		IndexedHeight:    4,
+		EstimatedEntries: 2,
+		BlockFetches:     true,
+		Caches:           []string{noHistoryCacheName, queryResultCacheName, decodedTxCacheName},
+		PrefetchDepth:    4,
+	}, plan)
+	// the explain does not affect the caches
+	require.Equal(t, 0, historydb.noHistoryCache.len())
+	require.Equal(t, 0, historydb.queryResultCache.len())
+
+	// the queries answered from the caches are estimated exactly and need no block fetches
+	testutilVerifyResults(t, qe, "ns1", "key1", []string{"value2", "value1"})
+	plan, err = explainer.ExplainHistoryForKey("ns1", "key1")
+	require.NoError(t, err)
+	require.Equal(t, queryResultCacheName, plan.ServedFromCache)
+	require.Equal(t, uint64(2), plan.EstimatedEntries)
+	require.True(t, plan.EstimateExact)
+	require.False(t, plan.BlockFetches)
+
+	testutilVerifyResults(t, qe, "ns1", "key3", []string{})
+	plan, err = explainer.ExplainHistoryForKey("ns1", "key3")
+	require.NoError(t, err)
+	require.Equal(t, noHistoryCacheName, plan.ServedFromCache)
+	require.Equal(t, []string{noHistoryCacheName}, plan.Caches)
+	require.Equal(t, uint64(0), plan.EstimatedEntries)
+	require.True(t, plan.EstimateExact)
+
+	t.Run("scan", func(t *testing.T) {
+		plan, err := historydb.ExplainScan("ns1", "")
+		require.NoError(t, err)
+		startKey, endKey := scanRange("ns1", "")
+		require.Equal(t, &QueryPlan{
+			Namespace:        "ns1",
+			IndexStartKey:    startKey,
+			IndexEndKey:      endKey,
+			IndexedHeight:    4,
+			EstimatedEntries: 3,
+			BlockFetches:     true,
+			Caches:           []string{decodedTxCacheName},
+			DecodeWorkers:    2,
+		}, plan)
+
+		plan, err = historydb.ExplainScan("ns2", "")
+		require.NoError(t, err)
+		require.Equal(t, uint64(0), plan.EstimatedEntries)
+		require.False(t, plan.BlockFetches)
+		require.Empty(t, plan.Caches)
+	})
+
+	t.Run("inline-values", func(t *testing.T) {
+		historydb.setChaincodeHints("ns1", &chaincodeHints{inlineValues: true})
+		defer historydb.setChaincodeHints("ns1", nil)
+		plan, err := explainer.ExplainHistoryForKey("ns1", "key2")
+		require.NoError(t, err)
+		require.Equal(t, uint64(2), plan.EstimatedEntries)
+		require.False(t, plan.BlockFetches)
+		require.Equal(t, []string{noHistoryCacheName, queryResultCacheName}, plan.Caches)
+	})
+}
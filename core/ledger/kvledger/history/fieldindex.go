@@ -0,0 +1,136 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// fieldIndexViewName is the name of the history view that maintains the JSON field indexes
+const fieldIndexViewName = "fieldindex"
+
+// EnableFieldIndexes enables the indexes on the fields of the JSON values written to the namespaces. The fieldIndexes
+// maps a namespace to the fields to be indexed. A field is the name of a top-level field of the JSON value or a
+// dot separated path to a nested field, such as `owner.name`. The indexes are maintained as a history view and hence,
+// cover the history entries added after the indexes are enabled (see function `RegisterView`).
+func (p *DBProvider) EnableFieldIndexes(fieldIndexes map[string][]string) error {
+	if len(fieldIndexes) == 0 {
+		return nil
+	}
+	view := &fieldIndexView{fields: map[string][]string{}}
+	for ns, fields := range fieldIndexes {
+		for _, field := range fields {
+			if ns == "" || field == "" || bytes.Contains([]byte(ns+field), compositeKeySep) {
+				return errors.Errorf("invalid field index [%s] for namespace [%s], the namespace and the field cannot be empty or contain the byte 0x00",
+					field, ns)
+			}
+		}
+		view.fields[ns] = append([]string{}, fields...)
+	}
+	return p.RegisterView(view)
+}
+
+// GetHistoryByField invokes the function visit for the history entries in the namespace ns whose written JSON value
+// has the given value for the field. A string field matches its string value. A number, boolean, or null field
+// matches its JSON representation, for instance, `42`, `true`, or `null`. The entries are visited in the order of
+// keys and, for a key, in the order of oldest to newest. Deletes are not indexed and hence, never visited. The field
+// is expected to be configured on the peer, or declared in the indexing manifest of the chaincode.
+func (d *DB) GetHistoryByField(ns, field, value string, txFetcher TxFetcher, visit func(*Entry) error) error {
+	view, ok := d.view(fieldIndexViewName).(*fieldIndexView)
+	configured := ok && view.indexes(ns, field)
+	hints := d.hintsFor(ns)
+	declared := hints != nil && hints.fieldView != nil && hints.fieldView.indexes(ns, field)
+	if !configured && !declared {
+		return errors.Errorf("field [%s] is not indexed for namespace [%s]", field, ns)
+	}
+	return d.queryViewRow(fieldIndexViewName, constructFieldIndexRow(ns, field, value), txFetcher, visit)
+}
+
+// fieldIndexView is a history view that indexes the writes by the values of the configured JSON fields
+type fieldIndexView struct {
+	fields map[string][]string
+}
+
+func (v *fieldIndexView) Name() string {
+	return fieldIndexViewName
+}
+
+// Project returns a row for each configured field present in the written value. A value that is not a JSON
+// object and a field that is an object or an array are not indexed
+func (v *fieldIndexView) Project(ns, key string, value []byte, isDelete bool) ([]string, error) {
+	fields := v.fields[ns]
+	if len(fields) == 0 || isDelete {
+		return nil, nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(value))
+	decoder.UseNumber()
+	var doc map[string]interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, nil
+	}
+
+	var rows []string
+	for _, field := range fields {
+		fieldValue, ok := lookupField(doc, field)
+		if !ok {
+			continue
+		}
+		if s, ok := fieldValueString(fieldValue); ok {
+			rows = append(rows, constructFieldIndexRow(ns, field, s))
+		}
+	}
+	return rows, nil
+}
+
+func (v *fieldIndexView) indexes(ns, field string) bool {
+	for _, f := range v.fields[ns] {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupField returns the value of the field, which may be a dot separated path to a nested field
+func lookupField(doc map[string]interface{}, field string) (interface{}, bool) {
+	var current interface{} = doc
+	for _, name := range strings.Split(field, ".") {
+		obj, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if current, ok = obj[name]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+func fieldValueString(fieldValue interface{}) (string, bool) {
+	switch v := fieldValue.(type) {
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case bool:
+		return strconv.FormatBool(v), true
+	case nil:
+		return "null", true
+	default:
+		return "", false
+	}
+}
+
+// constructFieldIndexRow builds the row of the format namespace~field~value
+func constructFieldIndexRow(ns, field, value string) string {
+	return ns + string(compositeKeySep) + field + string(compositeKeySep) + value
+}
@@ -0,0 +1,97 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric/common/ledger/testutil"
+	util2 "github.com/hyperledger/fabric/common/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetHistoryByField(t *testing.T) {
+	env := newTestHistoryEnv(t)
+	defer env.cleanup()
+	store, err := env.testBlockStorageEnv.provider.Open("ledger1")
+	require.NoError(t, err)
+	defer store.Shutdown()
+
+	require.NoError(t, env.testHistoryDBProvider.EnableFieldIndexes(map[string][]string{
+		"ns1": {"owner", "size", "details.color"},
+	}))
+	historydb := env.testHistoryDBProvider.GetDBHandle("ledger1")
+	bg, gb := testutil.NewBlockGenerator(t, "ledger1", false)
+	require.NoError(t, store.AddBlock(gb))
+	require.NoError(t, historydb.Commit(gb))
+
+	writes := []map[string]string{
+		{"key1": `{"owner":"alice","size":10,"details":{"color":"red"}}`, "key2": `{"owner":"bob"}`},
+		{"key1": `{"owner":"bob","size":10.5}`, "key2": `not-json`},
+		{"key1": `{"owner":["alice"],"size":null}`, "key2": ""},
+	}
+	for _, blockWrites := range writes {
+		simulator, err := env.txmgr.NewTxSimulator(util2.GenerateUUID())
+		require.NoError(t, err)
+		for k, v := range blockWrites {
+			if v == "" {
+				require.NoError(t, simulator.DeleteState("ns1", k))
+				continue
+			}
+			require.NoError(t, simulator.SetState("ns1", k, []byte(v)))
+			require.NoError(t, simulator.SetState("ns2", k, []byte(v)))
+		}
+		simulator.Done()
+		simRes, err := simulator.GetTxSimulationResults()
+		require.NoError(t, err)
+		pubSimResBytes, err := simRes.GetPubSimulationBytes()
+		require.NoError(t, err)
+		block := bg.NextBlock([][]byte{pubSimResBytes})
+		require.NoError(t, store.AddBlock(block))
+		require.NoError(t, historydb.Commit(block))
+	}
+
+	type result struct {
+		key      string
+		blockNum uint64
+	}
+	getHistoryByField := func(field, value string) []result {
+		var results []result
+		require.NoError(t, historydb.GetHistoryByField("ns1", field, value, store, func(e *Entry) error {
+			results = append(results, result{e.Key, e.BlockNum})
+			return nil
+		}))
+		return results
+	}
+
+	require.Equal(t, []result{{"key1", 1}}, getHistoryByField("owner", "alice"))
+	require.Equal(t, []result{{"key1", 2}, {"key2", 1}}, getHistoryByField("owner", "bob"))
+	require.Equal(t, []result{{"key1", 1}}, getHistoryByField("size", "10"))
+	require.Equal(t, []result{{"key1", 2}}, getHistoryByField("size", "10.5"))
+	require.Equal(t, []result{{"key1", 3}}, getHistoryByField("size", "null"))
+	require.Equal(t, []result{{"key1", 1}}, getHistoryByField("details.color", "red"))
+	require.Nil(t, getHistoryByField("owner", "carol"))
+
+	err = historydb.GetHistoryByField("ns2", "owner", "alice", store, func(e *Entry) error { return nil })
+	require.EqualError(t, err, "field [owner] is not indexed for namespace [ns2]")
+	err = historydb.GetHistoryByField("ns1", "color", "red", store, func(e *Entry) error { return nil })
+	require.EqualError(t, err, "field [color] is not indexed for namespace [ns1]")
+}
+
+func TestEnableFieldIndexes(t *testing.T) {
+	provider, err := NewDBProvider(t.TempDir())
+	require.NoError(t, err)
+	defer provider.Close()
+
+	require.NoError(t, provider.EnableFieldIndexes(nil))
+	require.Empty(t, provider.views)
+	require.EqualError(t, provider.EnableFieldIndexes(map[string][]string{"ns1": {""}}),
+		"invalid field index [] for namespace [ns1], the namespace and the field cannot be empty or contain the byte 0x00")
+	require.NoError(t, provider.EnableFieldIndexes(map[string][]string{"ns1": {"owner"}}))
+	require.EqualError(t, provider.EnableFieldIndexes(map[string][]string{"ns1": {"owner"}}),
+		"history view [fieldindex] is already registered")
+}
@@ -0,0 +1,457 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric/common/ledger/blkstorage"
+	"github.com/hyperledger/fabric/common/metrics/disabled"
+	"github.com/hyperledger/fabric/core/ledger/internal/version"
+	"github.com/pkg/errors"
+)
+
+const (
+	// indexFormatsDBName is the name of the db, within the historydb leveldb, that records the index format of the
+	// history of each channel
+	indexFormatsDBName = "_index_formats"
+	// indexFormatVersion is the version of the encoding of the history index written by this code. The history
+	// indexed before the index format was recorded is taken to be of version 0
+	indexFormatVersion = 1
+)
+
+// ErrFormatUpgradeStopped is returned by function `UpgradeFormat` if the upgrade is stopped before completion
+var ErrFormatUpgradeStopped = errors.New("history index format upgrade stopped")
+
+// IndexFormat describes the on-disk format of the history index of a ledger
+type IndexFormat struct {
+	// Version is the version of the encoding of the index
+	Version uint64
+	// NormalizedKeyNamespaces are the namespaces, in sorted order, whose keys are indexed in the Unicode
+	// Normalization Form C
+	NormalizedKeyNamespaces []string
+	// Capabilities are the capabilities, in sorted order, that have been enabled while indexing the history (see
+	// function `EnableCapabilities`). As these do not change the encoding of the entries indexed without them,
+	// enabling a capability does not require an upgrade of the index format
+	Capabilities []string
+}
+
+func (f *IndexFormat) String() string {
+	return fmt.Sprintf("version=%d normalizedKeyNamespaces=%v capabilities=%v", f.Version, f.NormalizedKeyNamespaces, f.Capabilities)
+}
+
+func (f *IndexFormat) equal(other *IndexFormat) bool {
+	if f.Version != other.Version || len(f.NormalizedKeyNamespaces) != len(other.NormalizedKeyNamespaces) {
+		return false
+	}
+	for i, ns := range f.NormalizedKeyNamespaces {
+		if ns != other.NormalizedKeyNamespaces[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *IndexFormat) normalizedNamespaces() map[string]struct{} {
+	if len(f.NormalizedKeyNamespaces) == 0 {
+		return nil
+	}
+	normalizedNamespaces := map[string]struct{}{}
+	for _, ns := range f.NormalizedKeyNamespaces {
+		normalizedNamespaces[ns] = struct{}{}
+	}
+	return normalizedNamespaces
+}
+
+// indexFormatDescriptor is the index format recorded for the history of a ledger, along with the generation of the
+// db, within the historydb leveldb, that holds the history in this format. Each upgrade of the index format builds
+// the history in the db of the next generation
+type indexFormatDescriptor struct {
+	format     *IndexFormat
+	generation uint64
+}
+
+func (d *indexFormatDescriptor) toBytes() []byte {
+	buf := proto.NewBuffer(nil)
+	// the errors are always nil for the encoding of varints and strings
+	_ = buf.EncodeVarint(d.format.Version)
+	_ = buf.EncodeVarint(d.generation)
+	_ = buf.EncodeVarint(uint64(len(d.format.NormalizedKeyNamespaces)))
+	for _, ns := range d.format.NormalizedKeyNamespaces {
+		_ = buf.EncodeStringBytes(ns)
+	}
+	_ = buf.EncodeVarint(uint64(len(d.format.Capabilities)))
+	for _, c := range d.format.Capabilities {
+		_ = buf.EncodeStringBytes(c)
+	}
+	return buf.Bytes()
+}
+
+func indexFormatDescriptorFromBytes(b []byte) (*indexFormatDescriptor, error) {
+	buf := proto.NewBuffer(b)
+	d := &indexFormatDescriptor{format: &IndexFormat{}}
+	var err error
+	if d.format.Version, err = buf.DecodeVarint(); err != nil {
+		return nil, errors.Wrap(err, "error while decoding the version of the history index format")
+	}
+	if d.generation, err = buf.DecodeVarint(); err != nil {
+		return nil, errors.Wrap(err, "error while decoding the generation of the history index format")
+	}
+	numNamespaces, err := buf.DecodeVarint()
+	if err != nil {
+		return nil, errors.Wrap(err, "error while decoding the normalized namespaces of the history index format")
+	}
+	for i := uint64(0); i < numNamespaces; i++ {
+		ns, err := buf.DecodeStringBytes()
+		if err != nil {
+			return nil, errors.Wrap(err, "error while decoding the normalized namespaces of the history index format")
+		}
+		d.format.NormalizedKeyNamespaces = append(d.format.NormalizedKeyNamespaces, ns)
+	}
+	numCapabilities, err := buf.DecodeVarint()
+	if err != nil {
+		return nil, errors.Wrap(err, "error while decoding the capabilities of the history index format")
+	}
+	for i := uint64(0); i < numCapabilities; i++ {
+		c, err := buf.DecodeStringBytes()
+		if err != nil {
+			return nil, errors.Wrap(err, "error while decoding the capabilities of the history index format")
+		}
+		d.format.Capabilities = append(d.format.Capabilities, c)
+	}
+	return d, nil
+}
+
+// generationDBName returns the name of the db, within the historydb leveldb, that holds the history of the ledger
+// `name` for the given generation of the index format. The generation 0 is held by the db named after the ledger, as
+// is the history indexed before the index format was recorded. As a ledger name cannot contain an underscore, the db
+// names of the other generations do not collide with those of the ledgers
+func generationDBName(name string, generation uint64) string {
+	if generation == 0 {
+		return name
+	}
+	return fmt.Sprintf("%s_g%d", name, generation)
+}
+
+// configuredIndexFormat returns the index format as configured for this provider
+func (p *DBProvider) configuredIndexFormat() *IndexFormat {
+	f := &IndexFormat{Version: indexFormatVersion}
+	for ns := range p.normalizedNamespaces {
+		f.NormalizedKeyNamespaces = append(f.NormalizedKeyNamespaces, ns)
+	}
+	sort.Strings(f.NormalizedKeyNamespaces)
+	for c := range p.capabilities {
+		f.Capabilities = append(f.Capabilities, c)
+	}
+	sort.Strings(f.Capabilities)
+	return f
+}
+
+// readIndexFormat returns the index format recorded for the ledger `name`, or nil if not recorded
+func (p *DBProvider) readIndexFormat(name string) (*indexFormatDescriptor, error) {
+	b, err := p.leveldbProvider.GetDBHandle(indexFormatsDBName).Get([]byte(name))
+	if err != nil || b == nil {
+		return nil, err
+	}
+	return indexFormatDescriptorFromBytes(b)
+}
+
+func (p *DBProvider) writeIndexFormat(name string, descriptor *indexFormatDescriptor) error {
+	return errors.WithMessagef(
+		p.leveldbProvider.GetDBHandle(indexFormatsDBName).Put([]byte(name), descriptor.toBytes(), true),
+		"error while recording the history index format for channel [%s]", name,
+	)
+}
+
+func (p *DBProvider) deleteIndexFormat(name string) error {
+	return errors.WithMessagef(
+		p.leveldbProvider.GetDBHandle(indexFormatsDBName).Delete([]byte(name), true),
+		"error while deleting the history index format for channel [%s]", name,
+	)
+}
+
+// dropDBIfNotEmpty drops the db `dbName` within the historydb leveldb, as with function `dropDB`, unless empty
+func (p *DBProvider) dropDBIfNotEmpty(dbName string) error {
+	empty, err := p.leveldbProvider.GetDBHandle(dbName).IsEmpty()
+	if err != nil || empty {
+		return err
+	}
+	return p.dropDB(dbName)
+}
+
+// IndexFormat returns the index format in which the history of this ledger is served, as detected via function
+// `DetectIndexFormat`, or nil if not detected
+func (d *DB) IndexFormat() *IndexFormat {
+	if d.format == nil {
+		return nil
+	}
+	return d.format.format
+}
+
+// DetectIndexFormat detects the index format of the history of this ledger, so that an upgrade to the configured
+// index format, if required, can be performed via function `UpgradeFormat`. For a ledger with no history yet, the
+// configured index format is recorded. The history indexed before the index format was recorded is taken to be of
+// version 0, with the keys normalized as configured, and is served as such until upgraded. An error is returned if
+// the recorded version is newer than the supported version, or if the history has been indexed with a capability
+// that is not supported, for instance, after a downgrade of the peer. The capabilities enabled on this peer are added
+// to those recorded. This function is expected to be invoked when the ledger is opened, including when the peer joins
+// the channel, before any block is committed.
+func (d *DB) DetectIndexFormat() error {
+	if d.formatErr != nil {
+		return errors.WithMessagef(d.formatErr, "error while reading the history index format for channel [%s]", d.name)
+	}
+	configured := d.provider.configuredIndexFormat()
+	if d.format == nil {
+		empty, err := d.levelDB.IsEmpty()
+		if err != nil {
+			return err
+		}
+		if empty {
+			descriptor := &indexFormatDescriptor{format: configured}
+			if err := d.provider.writeIndexFormat(d.name, descriptor); err != nil {
+				return err
+			}
+			d.format = descriptor
+			return nil
+		}
+		// the capabilities of a legacy history are recorded along with the upgraded format
+		d.format = &indexFormatDescriptor{format: &IndexFormat{NormalizedKeyNamespaces: configured.NormalizedKeyNamespaces}}
+	}
+	if d.format.format.Version > indexFormatVersion {
+		return errors.Errorf("the history index format version [%d] of channel [%s] is newer than the supported version [%d]",
+			d.format.format.Version, d.name, indexFormatVersion)
+	}
+	for _, c := range d.format.format.Capabilities {
+		if _, ok := supportedCapabilities[c]; !ok {
+			return errors.Errorf("the history of channel [%s] has been indexed with capability [%s], which is not supported by this peer",
+				d.name, c)
+		}
+	}
+	if capabilities := mergeCapabilities(d.format.format.Capabilities, configured.Capabilities); len(capabilities) > len(d.format.format.Capabilities) {
+		format := *d.format.format
+		format.Capabilities = capabilities
+		descriptor := &indexFormatDescriptor{format: &format, generation: d.format.generation}
+		if d.format.format.Version > 0 {
+			if err := d.provider.writeIndexFormat(d.name, descriptor); err != nil {
+				return err
+			}
+		}
+		d.format = descriptor
+	}
+	if !d.format.format.equal(configured) {
+		logger.Infow("The history index format differs from the configured format, the history needs to be upgraded",
+			"channel", d.name, "format", d.format.format, "configuredFormat", configured)
+	}
+	return nil
+}
+
+// UpgradeFormat upgrades the history of this ledger to the configured index format, for instance, after the key
+// normalization is enabled for a namespace, while the blocks continue to be committed and the history continues to be
+// served in the detected index format. The history in the configured format is built in the db of the next generation
+// by converting the entries up to the savepoint, including those imported from a snapshot, whereas the subsequent
+// blocks are indexed in both the formats, as committed or, to catch up, as retrieved from the blockStore. Once caught
+// up, the configured format is recorded and the upgraded history is served when the ledger is opened next, for
+// instance, at the next start of the peer, at which time this function drops the history in the previous format and
+// records the invalidation of the blocks rebuilt, if the next generation was built by a rebuild (see function
+// `PrepareRebuild`).
+//
+// An upgrade interrupted by a stop of the peer, or via the stop signal, in which case ErrFormatUpgradeStopped is
+// returned, starts over when invoked again. Function `DetectIndexFormat` is expected to be invoked before this function.
+func (d *DB) UpgradeFormat(blockStore *blkstorage.BlockStore, stop <-chan struct{}) error {
+	if d.format == nil {
+		return errors.Errorf("history index format of channel [%s] not detected", d.name)
+	}
+	if generation := d.format.generation; generation > 0 {
+		if err := d.provider.dropDBIfNotEmpty(generationDBName(d.name, generation-1)); err != nil {
+			return err
+		}
+		if err := d.recordRebuildInvalidation(); err != nil {
+			return err
+		}
+	}
+	target := d.provider.configuredIndexFormat()
+	target.Capabilities = mergeCapabilities(d.format.format.Capabilities, target.Capabilities)
+	if d.format.format.equal(target) {
+		return nil
+	}
+
+	upgrade, err := d.startNextGeneration(target, nil)
+	if err != nil {
+		return err
+	}
+	logger.Infow("Upgrading the history index format", "channel", d.name, "format", d.format.format, "targetFormat", target)
+	if err := upgrade.run(blockStore, stop); err != nil {
+		return err
+	}
+	logger.Infow("History index format upgraded, the upgraded history is served when the channel is opened next",
+		"channel", d.name, "targetFormat", target)
+	return nil
+}
+
+// startNextGeneration claims the building of the history of the next generation in the target format, which fails if
+// the next generation is being built or has been built and is pending the next opening of the ledger. The entries up
+// to the savepoint are to be converted or, if rebuildFrom is not nil, the entries for the blocks prior to the block
+// rebuildFrom, which is expected to be at most the savepoint. The history is built by function `run`
+func (d *DB) startNextGeneration(target *IndexFormat, rebuildFrom *uint64) (*formatUpgrade, error) {
+	d.statsLock.Lock()
+	defer d.statsLock.Unlock()
+	if d.formatUpgrade != nil {
+		return nil, errors.Errorf("an upgrade or a rebuild of the history of channel [%s] is in progress or pending the next opening of the channel",
+			d.name)
+	}
+	savepoint, err := d.flushedSavepointLocked()
+	if err != nil {
+		return nil, err
+	}
+	upgrade := &formatUpgrade{db: d, target: target, generation: d.format.generation + 1, rebuildFrom: rebuildFrom}
+	switch {
+	case rebuildFrom != nil:
+		if savepoint == nil {
+			return nil, errors.Errorf("cannot rebuild the history of channel [%s] as no block is indexed yet", d.name)
+		}
+		if *rebuildFrom > savepoint.BlockNum {
+			return nil, errors.Errorf("cannot rebuild history from block [%d] as the history of channel [%s] is indexed up to block [%d]",
+				*rebuildFrom, d.name, savepoint.BlockNum)
+		}
+		upgrade.copy, upgrade.lastCopiedBlock = *rebuildFrom > 0, *rebuildFrom-1
+	case savepoint != nil:
+		upgrade.copy, upgrade.lastCopiedBlock = true, savepoint.BlockNum
+	}
+	d.formatUpgrade = upgrade
+	return upgrade, nil
+}
+
+// formatUpgrade builds the history of the next generation in the target format, by an upgrade of the index format or
+// by a rebuild, and indexes the blocks in the history of the next generation as they are committed to the historydb.
+// The fields side and copied are accessed under the statsLock of the historydb
+type formatUpgrade struct {
+	db         *DB
+	target     *IndexFormat
+	generation uint64
+	// rebuildFrom is the first block rebuilt, for a rebuild
+	rebuildFrom *uint64
+	// copy is true if the entries up to the block lastCopiedBlock are to be converted to the history of the next
+	// generation
+	copy            bool
+	lastCopiedBlock uint64
+	side            *DB
+	// copied is set once the entries up to the block lastCopiedBlock are converted to the history of the next
+	// generation, before which the blocks are not indexed in the history of the next generation
+	copied bool
+}
+
+// run builds the history of the next generation claimed via function `startNextGeneration` and, once caught up with
+// the historydb, records the target format along with the next generation. The claim is released if the history
+// of the next generation is not completed
+func (u *formatUpgrade) run(blockStore *blkstorage.BlockStore, stop <-chan struct{}) error {
+	d := u.db
+	completed := false
+	defer func() {
+		if !completed {
+			d.statsLock.Lock()
+			d.formatUpgrade = nil
+			d.statsLock.Unlock()
+		}
+	}()
+
+	sideName := generationDBName(d.name, u.generation)
+	if err := d.provider.dropDBIfNotEmpty(sideName); err != nil {
+		return err
+	}
+	side := d.provider.newDB(d.name, sideName, u.target.normalizedNamespaces(), newStats(&disabled.Provider{}).ledgerStats(d.name))
+	side.groupCommit = nil
+	side.migration = nil
+	if u.copy {
+		if err := copyHistory(d, side, u.lastCopiedBlock, true, stop); err != nil {
+			return err
+		}
+	}
+	d.statsLock.Lock()
+	u.side = side
+	u.copied = true
+	d.statsLock.Unlock()
+
+	for {
+		select {
+		case <-stop:
+			return ErrFormatUpgradeStopped
+		default:
+		}
+		d.statsLock.Lock()
+		caughtUp, nextBlock, err := u.caughtUpLocked()
+		if err == nil && caughtUp && u.rebuildFrom != nil && nextBlock > *u.rebuildFrom {
+			err = side.levelDB.Put(rebuildInvalidationKey, encodeRebuildInvalidation(*u.rebuildFrom, nextBlock-1), true)
+		}
+		if err == nil && caughtUp {
+			err = d.provider.writeIndexFormat(d.name, &indexFormatDescriptor{format: u.target, generation: u.generation})
+		}
+		d.statsLock.Unlock()
+		if err != nil {
+			return err
+		}
+		if caughtUp {
+			completed = true
+			return nil
+		}
+		block, err := blockStore.RetrieveBlockByNumber(nextBlock)
+		if err != nil {
+			return err
+		}
+		d.statsLock.Lock()
+		err = u.commit(block)
+		d.statsLock.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// commit indexes the block in the history of the next generation, if the block is the one expected next by it. The
+// other blocks are skipped, as the history of the next generation either has them or catches up with them
+func (u *formatUpgrade) commit(block *common.Block) error {
+	if !u.copied {
+		return nil
+	}
+	nextBlock, err := u.side.NextIndexBatchBlock()
+	if err != nil || block.Header.Number != nextBlock {
+		return err
+	}
+	if hints := u.db.loadChaincodeHints(); hints != nil {
+		u.side.chaincodeHints.Store(hints)
+	}
+	return u.side.Commit(block)
+}
+
+// caughtUpLocked returns true if the history of the next generation has all the blocks committed to the historydb,
+// along with the block expected next by the history of the next generation
+func (u *formatUpgrade) caughtUpLocked() (bool, uint64, error) {
+	var nextBlock uint64
+	savepoint, err := u.db.flushedSavepointLocked()
+	if err != nil {
+		return false, 0, err
+	}
+	if savepoint != nil {
+		nextBlock = savepoint.BlockNum + 1
+	}
+	sideNextBlock, err := u.side.NextIndexBatchBlock()
+	if err != nil {
+		return false, 0, err
+	}
+	return sideNextBlock >= nextBlock, sideNextBlock, nil
+}
+
+// flushedSavepointLocked writes the pending history writes, if any, held by the group commit and returns the
+// savepoint. The caller is expected to hold the statsLock
+func (d *DB) flushedSavepointLocked() (*version.Height, error) {
+	if err := d.flushPendingLocked(); err != nil {
+		return nil, err
+	}
+	return d.GetLastSavepoint()
+}
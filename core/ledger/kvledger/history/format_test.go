@@ -0,0 +1,163 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric/common/ledger/testutil"
+	util2 "github.com/hyperledger/fabric/common/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndexFormatDescriptorEncoding(t *testing.T) {
+	descriptor := &indexFormatDescriptor{
+		format:     &IndexFormat{Version: 1, NormalizedKeyNamespaces: []string{"ns1", "ns2"}, Capabilities: []string{CapabilityValueInlining}},
+		generation: 3,
+	}
+	decoded, err := indexFormatDescriptorFromBytes(descriptor.toBytes())
+	require.NoError(t, err)
+	require.Equal(t, descriptor, decoded)
+
+	_, err = indexFormatDescriptorFromBytes(descriptor.toBytes()[:4])
+	require.EqualError(t, err, "error while decoding the normalized namespaces of the history index format: unexpected EOF")
+}
+
+func TestDetectIndexFormat(t *testing.T) {
+	env := newTestHistoryEnv(t)
+	defer env.cleanup()
+	provider := env.testHistoryDBProvider
+	require.NoError(t, provider.EnableKeyNormalization([]string{"ns2", "ns1"}))
+
+	// the configured format is recorded for a ledger with no history
+	historydb := provider.GetDBHandle("ledger1")
+	require.Nil(t, historydb.IndexFormat())
+	require.NoError(t, historydb.DetectIndexFormat())
+	expectedFormat := &IndexFormat{Version: indexFormatVersion, NormalizedKeyNamespaces: []string{"ns1", "ns2"}}
+	require.Equal(t, expectedFormat, historydb.IndexFormat())
+	require.Equal(t, expectedFormat, provider.GetDBHandle("ledger1").IndexFormat())
+
+	// the history indexed before the format is recorded is of version 0
+	bg, gb := testutil.NewBlockGenerator(t, "ledger2", false)
+	historydb = provider.GetDBHandle("ledger2")
+	require.NoError(t, historydb.Commit(gb))
+	require.NoError(t, historydb.DetectIndexFormat())
+	require.Equal(t, &IndexFormat{NormalizedKeyNamespaces: []string{"ns1", "ns2"}}, historydb.IndexFormat())
+	require.Nil(t, provider.GetDBHandle("ledger2").IndexFormat())
+	require.NoError(t, historydb.Commit(bg.NextBlock(nil)))
+
+	// a format newer than supported is not served
+	require.NoError(t, provider.writeIndexFormat("ledger3",
+		&indexFormatDescriptor{format: &IndexFormat{Version: indexFormatVersion + 1}}))
+	require.EqualError(t, provider.GetDBHandle("ledger3").DetectIndexFormat(),
+		"the history index format version [2] of channel [ledger3] is newer than the supported version [1]")
+
+	provider.Close()
+	require.EqualError(t, provider.GetDBHandle("ledger1").DetectIndexFormat(),
+		"error while reading the history index format for channel [ledger1]: error retrieving leveldb key [[]byte{0x5f, 0x69, 0x6e, 0x64, 0x65, 0x78, 0x5f, 0x66, 0x6f, 0x72, 0x6d, 0x61, 0x74, 0x73, 0x0, 0x6c, 0x65, 0x64, 0x67, 0x65, 0x72, 0x31}]: leveldb: closed")
+}
+
+func TestUpgradeFormat(t *testing.T) {
+	env := newTestHistoryEnv(t)
+	defer env.cleanup()
+	provider := env.testHistoryDBProvider
+	require.NoError(t, provider.RegisterView(&ownerView{}))
+	store, err := env.testBlockStorageEnv.provider.Open("ledger1")
+	require.NoError(t, err)
+	defer store.Shutdown()
+
+	bg, gb := testutil.NewBlockGenerator(t, "ledger1", false)
+	nextBlock := func(i int) *common.Block {
+		simulator, err := env.txmgr.NewTxSimulator(util2.GenerateUUID())
+		require.NoError(t, err)
+		// the key is in the decomposed form, which the upgraded history normalizes to the composed form
+		require.NoError(t, simulator.SetState("ns1", "cafe\u0301", []byte{byte(i)}))
+		require.NoError(t, simulator.SetState("ns1", "caf\u00e9", []byte{byte(i)}))
+		require.NoError(t, simulator.SetState("ns2", "key2", []byte("alice")))
+		simulator.Done()
+		simRes, err := simulator.GetTxSimulationResults()
+		require.NoError(t, err)
+		pubSimResBytes, err := simRes.GetPubSimulationBytes()
+		require.NoError(t, err)
+		block := bg.NextBlock([][]byte{pubSimResBytes})
+		require.NoError(t, store.AddBlock(block))
+		return block
+	}
+	historydb := provider.GetDBHandle("ledger1")
+	require.NoError(t, historydb.DetectIndexFormat())
+	require.NoError(t, store.AddBlock(gb))
+	require.NoError(t, historydb.Commit(gb))
+	for i := 1; i <= 2; i++ {
+		require.NoError(t, historydb.Commit(nextBlock(i)))
+	}
+	// nothing to upgrade
+	require.NoError(t, historydb.UpgradeFormat(store, nil))
+	require.Equal(t, &IndexFormat{Version: indexFormatVersion}, provider.GetDBHandle("ledger1").IndexFormat())
+
+	// the history continues to be served in the recorded format after the key normalization is enabled
+	require.NoError(t, provider.EnableKeyNormalization([]string{"ns1"}))
+	historydb = provider.GetDBHandle("ledger1")
+	require.NoError(t, historydb.DetectIndexFormat())
+	require.Equal(t, &IndexFormat{Version: indexFormatVersion}, historydb.IndexFormat())
+	qe, err := historydb.NewQueryExecutor(store)
+	require.NoError(t, err)
+	testutilVerifyResults(t, qe, "ns1", "cafe\u0301", []string{"\x02", "\x01"})
+
+	// an interrupted upgrade starts over
+	stop := make(chan struct{})
+	close(stop)
+	require.Equal(t, ErrFormatUpgradeStopped, historydb.UpgradeFormat(store, stop))
+	require.NoError(t, historydb.Commit(nextBlock(3)))
+	require.NoError(t, historydb.UpgradeFormat(store, nil))
+
+	// the blocks committed after the upgrade are indexed in both the formats until the ledger is opened next
+	require.NoError(t, historydb.Commit(nextBlock(4)))
+	qe, err = historydb.NewQueryExecutor(store)
+	require.NoError(t, err)
+	testutilVerifyResults(t, qe, "ns1", "cafe\u0301", []string{"\x04", "\x03", "\x02", "\x01"})
+	testutilVerifyResults(t, qe, "ns1", "caf\u00e9", []string{"\x04", "\x03", "\x02", "\x01"})
+
+	historydb = provider.GetDBHandle("ledger1")
+	require.NoError(t, historydb.DetectIndexFormat())
+	require.Equal(t, &IndexFormat{Version: indexFormatVersion, NormalizedKeyNamespaces: []string{"ns1"}}, historydb.IndexFormat())
+	qe, err = historydb.NewQueryExecutor(store)
+	require.NoError(t, err)
+	// the writes to both the forms of the key within a transaction are indexed as a single entry
+	expectedVals := []string{"\x04", "\x03", "\x02", "\x01"}
+	testutilVerifyResults(t, qe, "ns1", "cafe\u0301", expectedVals)
+	testutilVerifyResults(t, qe, "ns1", "caf\u00e9", expectedVals)
+	testutilVerifyResults(t, qe, "ns2", "key2", []string{"alice", "alice", "alice", "alice"})
+	var viewEntries int
+	require.NoError(t, historydb.QueryView("owner", "alice", store, func(e *Entry) error {
+		viewEntries++
+		return nil
+	}))
+	require.Equal(t, 4, viewEntries)
+	stats, err := historydb.GetIndexStats("ns1")
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), stats.DistinctKeys)
+	require.Equal(t, uint64(4), stats.LastIndexedBlock)
+	savepoint, err := historydb.GetLastSavepoint()
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), savepoint.BlockNum)
+
+	// the history in the previous format is dropped
+	empty, err := provider.leveldbProvider.GetDBHandle("ledger1").IsEmpty()
+	require.NoError(t, err)
+	require.False(t, empty)
+	require.NoError(t, historydb.UpgradeFormat(store, nil))
+	empty, err = provider.leveldbProvider.GetDBHandle("ledger1").IsEmpty()
+	require.NoError(t, err)
+	require.True(t, empty)
+
+	require.NoError(t, provider.Drop("ledger1"))
+	empty, err = provider.leveldbProvider.GetDBHandle(generationDBName("ledger1", 1)).IsEmpty()
+	require.NoError(t, err)
+	require.True(t, empty)
+	require.Nil(t, provider.GetDBHandle("ledger1").IndexFormat())
+}
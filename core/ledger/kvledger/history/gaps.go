@@ -0,0 +1,232 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric-protos-go/ledger/rwset/kvrwset"
+	"github.com/hyperledger/fabric/common/ledger/blkstorage"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/rwsetutil"
+	"github.com/pkg/errors"
+)
+
+// VersionRef identifies a version of a key by the height of the transaction that wrote it
+type VersionRef struct {
+	Namespace string
+	Key       string
+	BlockNum  uint64
+	TranNum   uint64
+}
+
+// VersionGapReport is the outcome of checking the versions recorded in the history index against the block
+// store and the index statistics (see function `CheckVersionGaps`)
+type VersionGapReport struct {
+	Namespace string
+	Key       string
+	// FromBlock and ToBlock are the range of blocks checked against the block store. ToBlock is capped
+	// at the savepoint of the history index
+	FromBlock uint64
+	ToBlock   uint64
+	// EntriesChecked is the number of index entries visited for the namespace or the key
+	EntriesChecked uint64
+	// UnchangedWrites is the number of writes in the checked blocks that are not expected to be indexed, as they do
+	// not change the values of the keys (see function `EnableUnchangedWriteSkipping`)
+	UnchangedWrites uint64
+	// Missing are the writes in the checked blocks that are expected to be indexed but have no index entry,
+	// which indicates a missed commit
+	Missing []*VersionRef
+	// Unexpected are the index entries in the checked blocks for which the transaction does not write the key
+	Unexpected []*VersionRef
+	// BeyondSavepoint are the index entries for the blocks beyond the savepoint of the history index
+	BeyondSavepoint []*VersionRef
+	// StatsMismatches describes the index statistics that do not line up with the entries in the index. The
+	// statistics are checked only for a namespace-wide check
+	StatsMismatches []string
+}
+
+// HasGaps returns true if the check found any inconsistency
+func (r *VersionGapReport) HasGaps() bool {
+	return len(r.Missing) > 0 || len(r.Unexpected) > 0 || len(r.BeyondSavepoint) > 0 || len(r.StatsMismatches) > 0
+}
+
+// CheckVersionGaps checks that the versions recorded in the history index for the namespace ns or, if key is not
+// empty, for the given key only, line up with the writes of the valid transactions in the blocks fromBlock to toBlock
+// of the block store, and that the index holds no entries beyond its savepoint. For a namespace-wide check, the
+// entry count, the distinct key count, and the last indexed block maintained in the index statistics are checked
+// against the entries as well. The writes are expected to be indexed as per the current namespace and key indexing
+// policies and hence, a change in the policies since the blocks were committed shows up as gaps. For a namespace for
+// which the unchanged writes are skipped, a write is expected to be indexed only if it changes the value of the key,
+// as of the latest entry of the key before fromBlock and the writes that follow in the checked blocks. An unchanged
+// write that is indexed nonetheless is not reported, as the tracking of the values is reset by a rebuild or a
+// rollback, after which the first write to each key is indexed. As each block in
+// the range is retrieved from the block store, the range is expected to be kept small; this is intended for
+// diagnosing a suspected missed commit or a pruning bug, not for a routine check.
+func (d *DB) CheckVersionGaps(ns, key string, fromBlock, toBlock uint64, blockStore *blkstorage.BlockStore) (*VersionGapReport, error) {
+	if ns == "" {
+		return nil, errors.New("namespace is required for checking the version gaps")
+	}
+	key = d.normalizeKey(ns, key)
+	savepoint, err := d.GetLastSavepoint()
+	if err != nil {
+		return nil, err
+	}
+	report := &VersionGapReport{Namespace: ns, Key: key, FromBlock: fromBlock, ToBlock: toBlock}
+	checkBlocks := savepoint != nil && fromBlock <= toBlock
+	if checkBlocks && toBlock > savepoint.BlockNum {
+		report.ToBlock = savepoint.BlockNum
+		checkBlocks = fromBlock <= savepoint.BlockNum
+	}
+
+	expected := map[string]*VersionRef{}
+	unchanged := map[string]struct{}{}
+	_, skipUnchanged := d.skipUnchangedNamespaces[ns]
+	valueStates := map[string]*valueState{}
+	if checkBlocks {
+		for blockNum := fromBlock; blockNum <= report.ToBlock; blockNum++ {
+			block, err := blockStore.RetrieveBlockByNumber(blockNum)
+			if err != nil {
+				return nil, errors.WithMessagef(err, "error while retrieving block [%d]", blockNum)
+			}
+			if _, err := d.visitBlockWrites(block, func(tranNo uint64, _ *common.ChannelHeader, writeNs string, kvWrite *kvrwset.KVWrite) error {
+				if writeNs != ns {
+					return nil
+				}
+				writeKey := d.normalizeKey(ns, kvWrite.Key)
+				if (key != "" && writeKey != key) || !d.isKeyIndexed(ns, writeKey) {
+					return nil
+				}
+				dataKey := string(constructDataKey(ns, writeKey, blockNum, tranNo))
+				if skipUnchanged {
+					state, ok := valueStates[writeKey]
+					if !ok {
+						var err error
+						if state, err = d.valueStateBefore(ns, writeKey, fromBlock, blockStore); err != nil {
+							return err
+						}
+					}
+					isDelete := rwsetutil.IsKVWriteDelete(kvWrite)
+					if state.isSameWrite(kvWrite.Value, isDelete) {
+						unchanged[dataKey] = struct{}{}
+						valueStates[writeKey] = state
+						return nil
+					}
+					if state == nil {
+						state = &valueState{}
+					}
+					state.track(kvWrite.Value, isDelete)
+					valueStates[writeKey] = state
+				}
+				expected[dataKey] = &VersionRef{
+					Namespace: ns, Key: writeKey, BlockNum: blockNum, TranNum: tranNo,
+				}
+				return nil
+			}); err != nil {
+				return nil, err
+			}
+		}
+	}
+	report.UnchangedWrites = uint64(len(unchanged))
+
+	var distinctKeys, lastBlock uint64
+	prevKey := ""
+	err = d.ScanIndex(ns, key, func(e *IndexEntry) error {
+		if e.DecodeErr != nil {
+			return errors.WithMessagef(e.DecodeErr, "error while decoding history index entry [%x]", e.RawKey)
+		}
+		report.EntriesChecked++
+		if report.EntriesChecked == 1 || e.Key != prevKey {
+			distinctKeys++
+			prevKey = e.Key
+		}
+		if e.BlockNum > lastBlock {
+			lastBlock = e.BlockNum
+		}
+		ref := &VersionRef{Namespace: e.Namespace, Key: e.Key, BlockNum: e.BlockNum, TranNum: e.TranNum}
+		if savepoint == nil || e.BlockNum > savepoint.BlockNum {
+			report.BeyondSavepoint = append(report.BeyondSavepoint, ref)
+			return nil
+		}
+		if !checkBlocks || e.BlockNum < fromBlock || e.BlockNum > report.ToBlock {
+			return nil
+		}
+		if _, ok := expected[string(e.RawKey)]; ok {
+			delete(expected, string(e.RawKey))
+			return nil
+		}
+		if _, ok := unchanged[string(e.RawKey)]; ok {
+			return nil
+		}
+		report.Unexpected = append(report.Unexpected, ref)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ref := range expected {
+		report.Missing = append(report.Missing, ref)
+	}
+	sort.Slice(report.Missing, func(i, j int) bool {
+		a, b := report.Missing[i], report.Missing[j]
+		if a.Key != b.Key {
+			return a.Key < b.Key
+		}
+		if a.BlockNum != b.BlockNum {
+			return a.BlockNum < b.BlockNum
+		}
+		return a.TranNum < b.TranNum
+	})
+
+	if key != "" {
+		return report, nil
+	}
+	stats, err := d.GetIndexStats(ns)
+	if err != nil {
+		return nil, err
+	}
+	if stats.TotalIndexEntries != report.EntriesChecked {
+		report.StatsMismatches = append(report.StatsMismatches,
+			fmt.Sprintf("total index entries in statistics is %d, found %d entries", stats.TotalIndexEntries, report.EntriesChecked))
+	}
+	if stats.DistinctKeys != distinctKeys {
+		report.StatsMismatches = append(report.StatsMismatches,
+			fmt.Sprintf("distinct keys in statistics is %d, found %d keys", stats.DistinctKeys, distinctKeys))
+	}
+	if stats.LastIndexedBlock != lastBlock {
+		report.StatsMismatches = append(report.StatsMismatches,
+			fmt.Sprintf("last indexed block in statistics is %d, found %d", stats.LastIndexedBlock, lastBlock))
+	}
+	return report, nil
+}
+
+// valueStateBefore returns the value of the key as of the given block, i.e., the value of the latest entry of the key
+// before the block, as the writes that follow the entry without changing the value are not indexed. Returns nil if the
+// key has no entries before the block
+func (d *DB) valueStateBefore(ns, key string, blockNum uint64, txFetcher TxFetcher) (*valueState, error) {
+	rangeScan := constructRangeScan(ns, key)
+	itr, err := d.levelDB.GetIterator(rangeScan.startKey, constructDataKey(ns, key, blockNum, 0))
+	if err != nil {
+		return nil, err
+	}
+	defer itr.Release()
+	if !itr.Last() {
+		if err := itr.Error(); err != nil {
+			return nil, errors.Wrap(err, "internal leveldb error while iterating for history entries")
+		}
+		return nil, nil
+	}
+	km, err := d.resolveKeyModification(itr.Key(), itr.Value(), txFetcher, readAround)
+	if err != nil {
+		return nil, err
+	}
+	state := &valueState{}
+	state.track(km.Value, km.IsDelete)
+	return state, nil
+}
@@ -0,0 +1,81 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric/common/ledger/testutil"
+	util2 "github.com/hyperledger/fabric/common/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckVersionGaps(t *testing.T) {
+	env := newTestHistoryEnv(t)
+	defer env.cleanup()
+	store, err := env.testBlockStorageEnv.provider.Open("ledger1")
+	require.NoError(t, err)
+	defer store.Shutdown()
+
+	historydb := env.testHistoryDBProvider.GetDBHandle("ledger1")
+	bg, gb := testutil.NewBlockGenerator(t, "ledger1", false)
+	commit := func(block *common.Block) {
+		require.NoError(t, store.AddBlock(block))
+		require.NoError(t, historydb.Commit(block))
+	}
+	commit(gb)
+	for i := 1; i <= 3; i++ {
+		simulator, err := env.txmgr.NewTxSimulator(util2.GenerateUUID())
+		require.NoError(t, err)
+		require.NoError(t, simulator.SetState("ns1", "key1", []byte(fmt.Sprintf("value%d", i))))
+		require.NoError(t, simulator.SetState("ns1", "key2", []byte(fmt.Sprintf("value%d", i))))
+		simulator.Done()
+		simRes, err := simulator.GetTxSimulationResults()
+		require.NoError(t, err)
+		pubSimResBytes, err := simRes.GetPubSimulationBytes()
+		require.NoError(t, err)
+		commit(bg.NextBlock([][]byte{pubSimResBytes}))
+	}
+
+	t.Run("consistent", func(t *testing.T) {
+		report, err := historydb.CheckVersionGaps("ns1", "", 1, 10, store)
+		require.NoError(t, err)
+		require.False(t, report.HasGaps())
+		require.Equal(t, uint64(3), report.ToBlock)
+		require.Equal(t, uint64(6), report.EntriesChecked)
+	})
+
+	t.Run("namespace-required", func(t *testing.T) {
+		_, err := historydb.CheckVersionGaps("", "", 1, 3, store)
+		require.EqualError(t, err, "namespace is required for checking the version gaps")
+	})
+
+	t.Run("damaged", func(t *testing.T) {
+		require.NoError(t, historydb.levelDB.Delete(constructDataKey("ns1", "key1", 2, 0), true))
+		require.NoError(t, historydb.levelDB.Put(constructDataKey("ns1", "key2", 2, 1), emptyValue, true))
+		require.NoError(t, historydb.levelDB.Put(constructDataKey("ns1", "key2", 5, 0), emptyValue, true))
+
+		report, err := historydb.CheckVersionGaps("ns1", "", 1, 3, store)
+		require.NoError(t, err)
+		require.True(t, report.HasGaps())
+		require.Equal(t, []*VersionRef{{Namespace: "ns1", Key: "key1", BlockNum: 2, TranNum: 0}}, report.Missing)
+		require.Equal(t, []*VersionRef{{Namespace: "ns1", Key: "key2", BlockNum: 2, TranNum: 1}}, report.Unexpected)
+		require.Equal(t, []*VersionRef{{Namespace: "ns1", Key: "key2", BlockNum: 5, TranNum: 0}}, report.BeyondSavepoint)
+		require.Equal(t, []string{
+			"total index entries in statistics is 6, found 7 entries",
+			"last indexed block in statistics is 3, found 5",
+		}, report.StatsMismatches)
+
+		// the statistics are not checked for a single key and the blocks outside the range are not checked
+		report, err = historydb.CheckVersionGaps("ns1", "key1", 3, 3, store)
+		require.NoError(t, err)
+		require.False(t, report.HasGaps())
+		require.Equal(t, uint64(2), report.EntriesChecked)
+	})
+}
@@ -0,0 +1,176 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/hyperledger/fabric/common/ledger/util/leveldbhelper"
+	"github.com/pkg/errors"
+)
+
+type groupCommitConfig struct {
+	maxBlocks     int
+	flushInterval time.Duration
+}
+
+// groupCommit holds the history writes of the blocks that are committed in quick succession, so that the writes
+// for multiple blocks are coalesced into a single leveldb write batch. The pending writes include the savepoint of
+// the last block and hence, a crash before the flush is recovered by recommitting the blocks from the block store.
+type groupCommit struct {
+	conf         *groupCommitConfig
+	batch        *leveldbhelper.UpdateBatch
+	statsTracker *indexStatsTracker
+	numBlocks    int
+	height       uint64
+	lastCommit   time.Time
+	timer        *time.Timer
+	// err is the error encountered while flushing the pending writes. Once set, the subsequent commits fail, as the
+	// history for the blocks of the failed flush is missing
+	err error
+}
+
+// EnableGroupCommit enables coalescing the history writes of up to maxBlocks blocks into a single write batch. The
+// writes are coalesced only when a block is committed within the flushInterval of the previous block, as is the case
+// when the peer is catching up with the channel. The pending writes are flushed once maxBlocks blocks are pending or
+// once the flushInterval elapses since the first pending block, whichever happens first. A block committed after a
+// pause longer than the flushInterval is written right away. A maxBlocks of 0 or 1 leaves the group commit disabled.
+func (p *DBProvider) EnableGroupCommit(maxBlocks int, flushInterval time.Duration) error {
+	if maxBlocks <= 1 {
+		return nil
+	}
+	if flushInterval <= 0 {
+		return errors.Errorf("invalid flush interval [%s] for the group commit, the interval must be positive", flushInterval)
+	}
+	p.groupCommitConf = &groupCommitConfig{
+		maxBlocks:     maxBlocks,
+		flushInterval: flushInterval,
+	}
+	return nil
+}
+
+func newGroupCommit(conf *groupCommitConfig) *groupCommit {
+	if conf == nil {
+		return nil
+	}
+	return &groupCommit{conf: conf}
+}
+
+// Flush writes the pending history writes, if any, held by the group commit
+func (d *DB) Flush() error {
+	d.statsLock.Lock()
+	defer d.statsLock.Unlock()
+	return d.flushPendingLocked()
+}
+
+// HasPendingWrites returns true if the history writes for one or more committed blocks are yet to be flushed
+func (d *DB) HasPendingWrites() bool {
+	d.statsLock.Lock()
+	defer d.statsLock.Unlock()
+	return d.groupCommit != nil && d.groupCommit.numBlocks > 0
+}
+
+// pendingBatch returns the write batch and the stats tracker of the pending group, if any. Otherwise, returns
+// a new write batch and a new stats tracker. The caller is expected to hold the statsLock
+func (d *DB) pendingBatch() (*leveldbhelper.UpdateBatch, *indexStatsTracker) {
+	if g := d.groupCommit; g != nil && g.numBlocks > 0 {
+		return g.batch, g.statsTracker
+	}
+	return d.levelDB.NewUpdateBatch(), newIndexStatsTracker(d)
+}
+
+// writeBlockBatch writes, or holds as pending, the batch that contains the history writes for a block, including
+// the writes of the previously pending blocks. The height is the height of the blocks covered by the batch. The
+// caller is expected to hold the statsLock
+func (d *DB) writeBlockBatch(batch *leveldbhelper.UpdateBatch, statsTracker *indexStatsTracker, height uint64) error {
+	g := d.groupCommit
+	if g == nil {
+		batchKeys := statsTracker.flush(batch)
+		// Setting snyc to true as a precaution, false may be an ok optimization after further testing.
+		if err := d.levelDB.WriteBatch(batch, true); err != nil {
+			return err
+		}
+		d.noHistoryCache.invalidate(batchKeys)
+		d.queryResultCache.invalidate(batchKeys, height)
+		d.stats.updateIndexBatchSize(batch.Size())
+		d.stats.updateSavepointHeight(height)
+		atomic.StoreUint64(&d.appliedHeight, height)
+		return nil
+	}
+
+	now := time.Now()
+	catchingUp := g.numBlocks > 0 || now.Sub(g.lastCommit) < g.conf.flushInterval
+	g.lastCommit = now
+	g.batch, g.statsTracker, g.height = batch, statsTracker, height
+	g.numBlocks++
+	if !catchingUp || g.numBlocks >= g.conf.maxBlocks {
+		return d.flushPendingLocked()
+	}
+	if g.timer == nil {
+		g.timer = time.AfterFunc(g.conf.flushInterval, d.flushOnTimer)
+	}
+	return nil
+}
+
+// flushPendingLocked writes the pending history writes, if any. The caller is expected to hold the statsLock
+func (d *DB) flushPendingLocked() error {
+	g := d.groupCommit
+	if g == nil {
+		return nil
+	}
+	if g.err != nil {
+		return g.err
+	}
+	if g.timer != nil {
+		g.timer.Stop()
+		g.timer = nil
+	}
+	if g.numBlocks == 0 {
+		return nil
+	}
+	numBlocks := g.numBlocks
+	batchKeys := g.statsTracker.flush(g.batch)
+	batchSize := g.batch.Size()
+	err := d.levelDB.WriteBatch(g.batch, true)
+	g.batch, g.statsTracker, g.numBlocks = nil, nil, 0
+	if err != nil {
+		g.err = errors.WithMessagef(err, "error while flushing the history writes of [%d] blocks", numBlocks)
+		return g.err
+	}
+	d.noHistoryCache.invalidate(batchKeys)
+	d.queryResultCache.invalidate(batchKeys, g.height)
+	d.stats.updateIndexBatchSize(batchSize)
+	d.stats.updateSavepointHeight(g.height)
+	atomic.StoreUint64(&d.appliedHeight, g.height)
+	logger.Debugf("Channel [%s]: Flushed history writes of [%d] blocks", d.name, numBlocks)
+	return nil
+}
+
+func (d *DB) flushOnTimer() {
+	if err := d.Flush(); err != nil {
+		logger.Errorw("Failed to flush the pending history writes", "channel", d.name, "error", err)
+	}
+}
+
+// discardPendingLocked discards the pending history writes. This is invoked when a block fails to be added to the
+// pending batch, as the batch may contain the writes of the block partially. The caller is expected to hold the
+// statsLock
+func (d *DB) discardPendingLocked(cause error) {
+	g := d.groupCommit
+	if g == nil {
+		return
+	}
+	if g.timer != nil {
+		g.timer.Stop()
+		g.timer = nil
+	}
+	g.batch, g.statsTracker, g.numBlocks = nil, nil, 0
+	if g.err == nil {
+		g.err = errors.WithMessage(cause, "pending history writes discarded")
+	}
+}
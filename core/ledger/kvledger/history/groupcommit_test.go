@@ -0,0 +1,110 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric/common/ledger/testutil"
+	util2 "github.com/hyperledger/fabric/common/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGroupCommit(t *testing.T) {
+	env := newTestHistoryEnv(t)
+	defer env.cleanup()
+	store, err := env.testBlockStorageEnv.provider.Open("ledger1")
+	require.NoError(t, err)
+	defer store.Shutdown()
+
+	require.NoError(t, env.testHistoryDBProvider.EnableGroupCommit(3, time.Hour))
+	historydb := env.testHistoryDBProvider.GetDBHandle("ledger1")
+	bg, gb := testutil.NewBlockGenerator(t, "ledger1", false)
+	commit := func(block *common.Block) {
+		require.NoError(t, store.AddBlock(block))
+		require.NoError(t, historydb.Commit(block))
+	}
+	nextBlock := func(value string) *common.Block {
+		simulator, err := env.txmgr.NewTxSimulator(util2.GenerateUUID())
+		require.NoError(t, err)
+		require.NoError(t, simulator.SetState("ns1", "key1", []byte(value)))
+		simulator.Done()
+		simRes, err := simulator.GetTxSimulationResults()
+		require.NoError(t, err)
+		pubSimResBytes, err := simRes.GetPubSimulationBytes()
+		require.NoError(t, err)
+		return bg.NextBlock([][]byte{pubSimResBytes})
+	}
+	verifySavepoint := func(expected uint64) {
+		savepoint, err := historydb.GetLastSavepoint()
+		require.NoError(t, err)
+		require.Equal(t, expected, savepoint.BlockNum)
+		require.Equal(t, expected+1, historydb.AppliedHeight())
+	}
+
+	// the first block is written right away, as it does not follow a recent commit
+	commit(gb)
+	verifySavepoint(0)
+	require.False(t, historydb.HasPendingWrites())
+
+	// the blocks committed in quick succession are held back until three blocks are pending
+	commit(nextBlock("value1"))
+	commit(nextBlock("value2"))
+	verifySavepoint(0)
+	require.True(t, historydb.HasPendingWrites())
+	commit(nextBlock("value3"))
+	verifySavepoint(3)
+	require.False(t, historydb.HasPendingWrites())
+
+	commit(nextBlock("value4"))
+	verifySavepoint(3)
+	require.NoError(t, historydb.Flush())
+	verifySavepoint(4)
+	// the applied height of a handle obtained afterwards is that of the savepoint
+	require.Equal(t, uint64(5), env.testHistoryDBProvider.GetDBHandle("ledger1").AppliedHeight())
+
+	qe, err := historydb.NewQueryExecutor(store)
+	require.NoError(t, err)
+	testutilVerifyResults(t, qe, "ns1", "key1", []string{"value4", "value3", "value2", "value1"})
+	stats, err := historydb.GetIndexStats("ns1")
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), stats.DistinctKeys)
+	require.Equal(t, uint64(4), stats.TotalIndexEntries)
+	require.Equal(t, uint64(4), stats.LastIndexedBlock)
+
+	t.Run("flush-interval", func(t *testing.T) {
+		historydb.groupCommit.conf = &groupCommitConfig{maxBlocks: 100, flushInterval: 50 * time.Millisecond}
+		commit(nextBlock("value5"))
+		commit(nextBlock("value6"))
+		require.Eventually(t, func() bool { return !historydb.HasPendingWrites() }, time.Second, 10*time.Millisecond)
+		verifySavepoint(6)
+	})
+
+	t.Run("flush-error", func(t *testing.T) {
+		historydb.groupCommit.conf = &groupCommitConfig{maxBlocks: 100, flushInterval: time.Hour}
+		commit(nextBlock("value7"))
+		commit(nextBlock("value8"))
+		require.True(t, historydb.HasPendingWrites())
+		env.testHistoryDBProvider.Close()
+		require.Contains(t, historydb.Flush().Error(), "error while flushing the history writes of [2] blocks")
+		require.Equal(t, historydb.groupCommit.err, historydb.Commit(nextBlock("value9")))
+	})
+}
+
+func TestEnableGroupCommit(t *testing.T) {
+	provider, err := NewDBProvider(t.TempDir())
+	require.NoError(t, err)
+	defer provider.Close()
+
+	require.NoError(t, provider.EnableGroupCommit(1, 0))
+	require.Nil(t, provider.GetDBHandle("ledger1").groupCommit)
+	require.EqualError(t, provider.EnableGroupCommit(10, 0), "invalid flush interval [0s] for the group commit, the interval must be positive")
+	require.NoError(t, provider.EnableGroupCommit(10, time.Second))
+	require.Equal(t, &groupCommitConfig{maxBlocks: 10, flushInterval: time.Second}, provider.GetDBHandle("ledger1").groupCommit.conf)
+}
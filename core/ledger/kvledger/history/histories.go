@@ -0,0 +1,143 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/pkg/errors"
+)
+
+// GetHistoriesForKeys returns the history of each of the given keys in the namespace, as function `GetHistoryForKey`
+// does for a single key, i.e., in the order of newest to oldest. The map has an entry for each of the requested keys,
+// with no modifications for a key that has no history. Instead of a range scan per key, the keys are sorted in the
+// order of their entries in the index and the index is swept once, seeking to the newest entry of each key. This
+// is intended for the reconciliation jobs that query the history of many keys at once. As for the bulk scans, the key
+// modifications are resolved by the parallel decoding, if enabled, and the transactions retrieved from the block store
+// are not added to the decoded transaction cache. The requested keys that share a key as indexed, due to the key
+// normalization, share the same history.
+// If the response caps are enabled (see function `EnableResponseCaps`) and the histories exceed a cap, a
+// ResponseTooLargeError is returned and the histories are to be retrieved via function `GetHistoriesForKeysPage`.
+func (d *DB) GetHistoriesForKeys(ns string, keys []string, txFetcher TxFetcher) (map[string][]*queryresult.KeyModification, error) {
+	results, next, err := d.getHistoriesForKeys(ns, keys, txFetcher, nil)
+	if err != nil {
+		return nil, err
+	}
+	if next != nil {
+		return nil, d.responseCaps.tooLargeError()
+	}
+	return results, nil
+}
+
+// getHistoriesForKeys sweeps the index for the histories of the keys, in the order of the keys in the index and, for
+// each key, in the order of newest to oldest, from the index entry startAt, or from the newest entry of the first key
+// if startAt is nil, until the response caps are reached. If a cap is reached, next is the index entry at which the
+// sweep is to be continued and the results hold the keys whose entries are swept before next
+func (d *DB) getHistoriesForKeys(ns string, keys []string, txFetcher TxFetcher, startAt []byte) (
+	results map[string][]*queryresult.KeyModification, next []byte, err error) {
+	if !d.isIndexed(ns) {
+		return nil, nil, &IndexingDisabledError{Namespace: ns}
+	}
+	// histories is keyed by the keys as indexed, which differ from the requested keys if the key normalization
+	// is enabled for the namespace
+	histories := make(map[string][]*queryresult.KeyModification, len(keys))
+	indexedKeys := make(map[string]string, len(keys))
+	rangeScans := make([]*rangeScan, 0, len(keys))
+	for _, key := range keys {
+		indexedKey := d.normalizeKey(ns, key)
+		if !d.isKeyIndexed(ns, indexedKey) {
+			return nil, nil, &KeyNotIndexedError{Namespace: ns, Key: key}
+		}
+		indexedKeys[key] = indexedKey
+		if _, ok := histories[indexedKey]; ok {
+			continue
+		}
+		histories[indexedKey] = nil
+		rangeScans = append(rangeScans, constructRangeScan(ns, indexedKey))
+	}
+	if len(rangeScans) == 0 {
+		return histories, nil, nil
+	}
+	// the range scan start keys are prefixed with the key length and hence, are not in the order of the keys
+	sort.Slice(rangeScans, func(i, j int) bool {
+		return bytes.Compare(rangeScans[i].startKey, rangeScans[j].startKey) < 0
+	})
+
+	// the keys whose entries precede startAt are done in the previous pages
+	for startAt != nil && len(rangeScans) > 0 && bytes.Compare(rangeScans[0].endKey, startAt) <= 0 {
+		rangeScans = rangeScans[1:]
+	}
+	if len(rangeScans) == 0 {
+		return map[string][]*queryresult.KeyModification{}, nil, nil
+	}
+
+	itr, err := d.levelDB.GetIterator(rangeScans[0].startKey, rangeScans[len(rangeScans)-1].endKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer itr.Release()
+	counter := d.responseCaps.newCounter()
+	resolver := d.newResolvePool(txFetcher, func(k []byte, keyModification *queryresult.KeyModification) error {
+		if !counter.add(len(keyModification.Value)) {
+			next = append([]byte(nil), k...)
+			return errResponseCapReached
+		}
+		_, entryKey, _, _, err := decodeDataKey(k)
+		if err != nil {
+			return err
+		}
+		histories[entryKey] = append(histories[entryKey], keyModification)
+		return nil
+	})
+	defer resolver.close()
+
+	for _, rangeScan := range rangeScans {
+		// the sweep starts at the newest entry of the key or, for the key of startAt, at the entry startAt
+		last := rangeScan.endKey
+		if startAt != nil && bytes.HasPrefix(startAt, rangeScan.startKey) {
+			last = startAt
+		}
+		valid := itr.Seek(last)
+		if !valid || !bytes.Equal(itr.Key(), last) {
+			valid = itr.Prev()
+		}
+		for valid && bytes.HasPrefix(itr.Key(), rangeScan.startKey) {
+			if err = resolver.add(itr.Key(), itr.Value()); err != nil {
+				break
+			}
+			valid = itr.Prev()
+		}
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		if err = itr.Error(); err != nil {
+			return nil, nil, errors.Wrap(err, "internal leveldb error while iterating for history entries")
+		}
+		err = resolver.flush()
+	}
+	if err != nil && err != errResponseCapReached {
+		return nil, nil, err
+	}
+	results = make(map[string][]*queryresult.KeyModification, len(indexedKeys))
+	for key, indexedKey := range indexedKeys {
+		rangeScan := constructRangeScan(ns, indexedKey)
+		if startAt != nil && bytes.Compare(rangeScan.endKey, startAt) <= 0 {
+			continue
+		}
+		// the keys after next, and the key at next if none of its entries are in the page, are in the next pages
+		if next != nil && (bytes.Compare(rangeScan.startKey, next) >= 0 ||
+			bytes.HasPrefix(next, rangeScan.startKey) && len(histories[indexedKey]) == 0) {
+			continue
+		}
+		results[key] = histories[indexedKey]
+	}
+	return results, next, nil
+}
@@ -0,0 +1,111 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/hyperledger/fabric/common/ledger/testutil"
+	util2 "github.com/hyperledger/fabric/common/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetHistoriesForKeys(t *testing.T) {
+	env := newTestHistoryEnv(t)
+	defer env.cleanup()
+	store, err := env.testBlockStorageEnv.provider.Open("ledger1")
+	require.NoError(t, err)
+	defer store.Shutdown()
+
+	historydb := env.testHistoryDBProvider.GetDBHandle("ledger1")
+	bg, gb := testutil.NewBlockGenerator(t, "ledger1", false)
+	commit := func(block *common.Block) {
+		require.NoError(t, store.AddBlock(block))
+		require.NoError(t, historydb.Commit(block))
+	}
+	commit(gb)
+	writes := [][]string{
+		{"key1", "key10", "k"},
+		{"key1", "key2"},
+		{"key10", "key3"},
+	}
+	for i, keys := range writes {
+		simulator, err := env.txmgr.NewTxSimulator(util2.GenerateUUID())
+		require.NoError(t, err)
+		for _, key := range keys {
+			require.NoError(t, simulator.SetState("ns1", key, []byte(fmt.Sprintf("%s-%d", key, i))))
+		}
+		require.NoError(t, simulator.SetState("ns2", "key1", []byte("value")))
+		simulator.Done()
+		simRes, err := simulator.GetTxSimulationResults()
+		require.NoError(t, err)
+		pubSimResBytes, err := simRes.GetPubSimulationBytes()
+		require.NoError(t, err)
+		commit(bg.NextBlock([][]byte{pubSimResBytes}))
+	}
+
+	values := func(modifications []*queryresult.KeyModification) []string {
+		vals := []string{}
+		for _, m := range modifications {
+			vals = append(vals, string(m.Value))
+		}
+		return vals
+	}
+	verify := func(t *testing.T, historydb *DB) {
+		histories, err := historydb.GetHistoriesForKeys("ns1", []string{"key10", "missing", "key1", "k", "key3", "key1"}, store)
+		require.NoError(t, err)
+		require.Len(t, histories, 5)
+		require.Equal(t, []string{"key10-2", "key10-0"}, values(histories["key10"]))
+		require.Equal(t, []string{"key1-1", "key1-0"}, values(histories["key1"]))
+		require.Equal(t, []string{"k-0"}, values(histories["k"]))
+		require.Equal(t, []string{"key3-2"}, values(histories["key3"]))
+		require.Empty(t, histories["missing"])
+
+		// the results are same as those of the queries for the individual keys
+		qe, err := historydb.NewQueryExecutor(store)
+		require.NoError(t, err)
+		for key, modifications := range histories {
+			itr, err := qe.GetHistoryForKey("ns1", key)
+			require.NoError(t, err)
+			for _, expected := range modifications {
+				result, err := itr.Next()
+				require.NoError(t, err)
+				require.Equal(t, expected.TxId, result.(*queryresult.KeyModification).TxId)
+			}
+			result, err := itr.Next()
+			require.NoError(t, err)
+			require.Nil(t, result)
+			itr.Close()
+		}
+	}
+
+	t.Run("sequential", func(t *testing.T) {
+		verify(t, historydb)
+	})
+
+	t.Run("parallel-decoding", func(t *testing.T) {
+		env.testHistoryDBProvider.EnableParallelDecoding(4)
+		defer env.testHistoryDBProvider.EnableParallelDecoding(0)
+		verify(t, env.testHistoryDBProvider.GetDBHandle("ledger1"))
+	})
+
+	t.Run("no-keys", func(t *testing.T) {
+		histories, err := historydb.GetHistoriesForKeys("ns1", nil, store)
+		require.NoError(t, err)
+		require.Empty(t, histories)
+	})
+
+	t.Run("indexing-disabled", func(t *testing.T) {
+		historydb.indexingDisabled = map[string]struct{}{"ns1": {}}
+		defer func() { historydb.indexingDisabled = nil }()
+		_, err := historydb.GetHistoriesForKeys("ns1", []string{"key1"}, store)
+		require.IsType(t, &IndexingDisabledError{}, err)
+	})
+}
@@ -0,0 +1,185 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"bytes"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/common/ledger/util"
+	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/pkg/errors"
+)
+
+// invalidationsDBName is the name of the db, within the historydb leveldb, that records the invalidations of the
+// history pending the delivery to the commit listeners, keyed by the ledger and the sequence of the recording
+const invalidationsDBName = "_invalidations"
+
+// RecordInvalidation records an invalidation of the history of a range of blocks of a ledger, which is delivered to
+// the commit listeners that implement ledger.HistoryInvalidationListener by function `NotifyInvalidations`. The
+// invalidations of a ledger are delivered in the order of the recording.
+func (p *DBProvider) RecordInvalidation(invalidation *ledger.HistoryInvalidation) error {
+	if invalidation.FromBlock > invalidation.ToBlock {
+		return errors.Errorf("invalid history invalidation, the from block [%d] is after the to block [%d]",
+			invalidation.FromBlock, invalidation.ToBlock)
+	}
+	db := p.leveldbProvider.GetDBHandle(invalidationsDBName)
+	pending, err := p.pendingInvalidations(invalidation.LedgerID)
+	if err != nil {
+		return err
+	}
+	var seq uint64
+	if len(pending) > 0 {
+		seq = pending[len(pending)-1].seq + 1
+	}
+	if err := db.Put(constructInvalidationKey(invalidation.LedgerID, seq), encodeInvalidation(invalidation), true); err != nil {
+		return err
+	}
+	logger.Infow("History invalidation recorded", "channel", invalidation.LedgerID, "fromBlock", invalidation.FromBlock,
+		"toBlock", invalidation.ToBlock, "reason", invalidation.Reason)
+	return nil
+}
+
+// NotifyInvalidations delivers the invalidations recorded for the ledger, in the order of the recording, to the commit
+// listeners that implement ledger.HistoryInvalidationListener, and removes each invalidation once it is delivered to
+// all the listeners. An invalidation for which a listener returns an error remains recorded and hence, is delivered
+// again on the next invocation. This is to be invoked when the ledger is opened, before the blocks are committed.
+func (d *DB) NotifyInvalidations() error {
+	pending, err := d.provider.pendingInvalidations(d.name)
+	if err != nil {
+		return err
+	}
+	db := d.provider.leveldbProvider.GetDBHandle(invalidationsDBName)
+	for _, p := range pending {
+		for _, l := range d.commitListeners {
+			invalidationListener, ok := l.(ledger.HistoryInvalidationListener)
+			if !ok {
+				continue
+			}
+			if err := invalidationListener.HandleHistoryInvalidation(p.invalidation); err != nil {
+				return errors.WithMessagef(err, "error while notifying history commit listener [%s] of the invalidation of blocks [%d] to [%d]",
+					l.Name(), p.invalidation.FromBlock, p.invalidation.ToBlock)
+			}
+		}
+		if err := db.Delete(constructInvalidationKey(d.name, p.seq), true); err != nil {
+			return err
+		}
+		logger.Infow("History invalidation delivered", "channel", d.name, "fromBlock", p.invalidation.FromBlock,
+			"toBlock", p.invalidation.ToBlock, "reason", p.invalidation.Reason)
+	}
+	return nil
+}
+
+// ImportInvalidations moves the invalidations, of all the ledgers, recorded in the provider `from` to this provider,
+// in the order of the recording. This allows for recording the invalidations while the history is dropped, for
+// instance, by a rollback of the ledger.
+func (p *DBProvider) ImportInvalidations(from *DBProvider) error {
+	fromDB := from.leveldbProvider.GetDBHandle(invalidationsDBName)
+	itr, err := fromDB.GetIterator(nil, nil)
+	if err != nil {
+		return err
+	}
+	defer itr.Release()
+	for itr.Next() {
+		sep := bytes.Index(itr.Key(), compositeKeySep)
+		if sep < 0 {
+			return errors.Errorf("unexpected history invalidation key [%x]", itr.Key())
+		}
+		invalidation, err := decodeInvalidation(string(itr.Key()[:sep]), itr.Value())
+		if err != nil {
+			return err
+		}
+		if err := p.RecordInvalidation(invalidation); err != nil {
+			return err
+		}
+		if err := fromDB.Delete(append([]byte{}, itr.Key()...), true); err != nil {
+			return err
+		}
+	}
+	if err := itr.Error(); err != nil {
+		return errors.Wrap(err, "internal leveldb error while iterating for history invalidations")
+	}
+	return nil
+}
+
+type pendingInvalidation struct {
+	seq          uint64
+	invalidation *ledger.HistoryInvalidation
+}
+
+// pendingInvalidations returns the invalidations recorded for the ledger, in the order of the recording
+func (p *DBProvider) pendingInvalidations(name string) ([]*pendingInvalidation, error) {
+	db := p.leveldbProvider.GetDBHandle(invalidationsDBName)
+	prefix := append([]byte(name), compositeKeySep...)
+	itr, err := db.GetIterator(prefix, append(append([]byte{}, prefix...), 0xff))
+	if err != nil {
+		return nil, err
+	}
+	defer itr.Release()
+
+	var pending []*pendingInvalidation
+	for itr.Next() {
+		seq, _, err := util.DecodeOrderPreservingVarUint64(bytes.TrimPrefix(itr.Key(), prefix))
+		if err != nil {
+			return nil, err
+		}
+		invalidation, err := decodeInvalidation(name, itr.Value())
+		if err != nil {
+			return nil, err
+		}
+		pending = append(pending, &pendingInvalidation{seq: seq, invalidation: invalidation})
+	}
+	if err := itr.Error(); err != nil {
+		return nil, errors.Wrap(err, "internal leveldb error while iterating for history invalidations")
+	}
+	return pending, nil
+}
+
+// dropInvalidations removes the invalidations recorded for the ledger
+func (p *DBProvider) dropInvalidations(name string) error {
+	pending, err := p.pendingInvalidations(name)
+	if err != nil {
+		return err
+	}
+	db := p.leveldbProvider.GetDBHandle(invalidationsDBName)
+	batch := db.NewUpdateBatch()
+	for _, pi := range pending {
+		batch.Delete(constructInvalidationKey(name, pi.seq))
+	}
+	return db.WriteBatch(batch, true)
+}
+
+func constructInvalidationKey(name string, seq uint64) []byte {
+	k := append([]byte(name), compositeKeySep...)
+	return append(k, util.EncodeOrderPreservingVarUint64(seq)...)
+}
+
+func encodeInvalidation(invalidation *ledger.HistoryInvalidation) []byte {
+	buf := proto.NewBuffer(nil)
+	// the errors are always nil for the encoding of varints and bytes
+	_ = buf.EncodeVarint(invalidation.FromBlock)
+	_ = buf.EncodeVarint(invalidation.ToBlock)
+	_ = buf.EncodeStringBytes(invalidation.Reason)
+	return buf.Bytes()
+}
+
+func decodeInvalidation(name string, b []byte) (*ledger.HistoryInvalidation, error) {
+	buf := proto.NewBuffer(b)
+	fromBlock, err := buf.DecodeVarint()
+	if err != nil {
+		return nil, errors.Wrap(err, "error while decoding the history invalidation")
+	}
+	toBlock, err := buf.DecodeVarint()
+	if err != nil {
+		return nil, errors.Wrap(err, "error while decoding the history invalidation")
+	}
+	reason, err := buf.DecodeStringBytes()
+	if err != nil {
+		return nil, errors.Wrap(err, "error while decoding the history invalidation")
+	}
+	return &ledger.HistoryInvalidation{LedgerID: name, FromBlock: fromBlock, ToBlock: toBlock, Reason: reason}, nil
+}
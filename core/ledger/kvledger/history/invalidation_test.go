@@ -0,0 +1,102 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric/common/ledger/testutil"
+	util2 "github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+type testInvalidationListener struct {
+	testCommitListener
+	invalidations []*ledger.HistoryInvalidation
+	err           error
+}
+
+func (l *testInvalidationListener) HandleHistoryInvalidation(invalidation *ledger.HistoryInvalidation) error {
+	if l.err != nil {
+		return l.err
+	}
+	l.invalidations = append(l.invalidations, invalidation)
+	return nil
+}
+
+func TestInvalidations(t *testing.T) {
+	env := newTestHistoryEnv(t)
+	defer env.cleanup()
+	provider := env.testHistoryDBProvider
+	store, err := env.testBlockStorageEnv.provider.Open("ledger1")
+	require.NoError(t, err)
+	defer store.Shutdown()
+
+	// the listeners that do not implement ledger.HistoryInvalidationListener are not notified of the invalidations
+	require.NoError(t, provider.RegisterCommitListener(&testCommitListener{name: "listener1"}))
+	listener := &testInvalidationListener{testCommitListener: testCommitListener{name: "listener2"}}
+	require.NoError(t, provider.RegisterCommitListener(listener))
+
+	require.EqualError(t, provider.RecordInvalidation(&ledger.HistoryInvalidation{LedgerID: "ledger1", FromBlock: 2, ToBlock: 1}),
+		"invalid history invalidation, the from block [2] is after the to block [1]")
+	invalidation1 := &ledger.HistoryInvalidation{LedgerID: "ledger1", FromBlock: 3, ToBlock: 5, Reason: ledger.HistoryInvalidationReasonLedgerRollback}
+	invalidation2 := &ledger.HistoryInvalidation{LedgerID: "ledger1", FromBlock: 1, ToBlock: 2, Reason: ledger.HistoryInvalidationReasonLedgerReset}
+	invalidation3 := &ledger.HistoryInvalidation{LedgerID: "ledger2", FromBlock: 1, ToBlock: 1, Reason: ledger.HistoryInvalidationReasonLedgerReset}
+	for _, invalidation := range []*ledger.HistoryInvalidation{invalidation1, invalidation2, invalidation3} {
+		require.NoError(t, provider.RecordInvalidation(invalidation))
+	}
+
+	// an invalidation for which a listener returns an error is delivered again
+	historydb := provider.GetDBHandle("ledger1")
+	listener.err = errors.New("listener error")
+	require.EqualError(t, historydb.NotifyInvalidations(),
+		"error while notifying history commit listener [listener2] of the invalidation of blocks [3] to [5]: listener error")
+	listener.err = nil
+	require.NoError(t, historydb.NotifyInvalidations())
+	require.Equal(t, []*ledger.HistoryInvalidation{invalidation1, invalidation2}, listener.invalidations)
+	require.NoError(t, historydb.NotifyInvalidations())
+	require.Len(t, listener.invalidations, 2)
+
+	// the invalidations of a dropped ledger are removed
+	require.NoError(t, provider.Drop("ledger2"))
+	require.NoError(t, provider.GetDBHandle("ledger2").NotifyInvalidations())
+	require.Len(t, listener.invalidations, 2)
+
+	// a rebuild invalidates the rebuilt blocks
+	bg, gb := testutil.NewBlockGenerator(t, "ledger1", false)
+	require.NoError(t, store.AddBlock(gb))
+	require.NoError(t, historydb.Commit(gb))
+	for i := 0; i < 3; i++ {
+		simulator, err := env.txmgr.NewTxSimulator(util2.GenerateUUID())
+		require.NoError(t, err)
+		require.NoError(t, simulator.SetState("ns1", "key1", []byte("value")))
+		simulator.Done()
+		simRes, err := simulator.GetTxSimulationResults()
+		require.NoError(t, err)
+		pubSimResBytes, err := simRes.GetPubSimulationBytes()
+		require.NoError(t, err)
+		block := bg.NextBlock([][]byte{pubSimResBytes})
+		require.NoError(t, store.AddBlock(block))
+		require.NoError(t, historydb.Commit(block))
+	}
+	sideProvider, err := NewDBProvider(t.TempDir())
+	require.NoError(t, err)
+	defer sideProvider.Close()
+	require.NoError(t, provider.Rebuild("ledger1", sideProvider, store, 2, nil))
+	historydb = provider.GetDBHandle("ledger1")
+	require.NoError(t, historydb.NotifyInvalidations())
+	require.Equal(t, &ledger.HistoryInvalidation{LedgerID: "ledger1", FromBlock: 2, ToBlock: 3, Reason: ledger.HistoryInvalidationReasonHistoryRebuild},
+		listener.invalidations[2])
+	pending, err := sideProvider.pendingInvalidations("ledger1")
+	require.NoError(t, err)
+	require.Empty(t, pending)
+
+	_, err = decodeInvalidation("ledger1", []byte{0xff})
+	require.Error(t, err)
+}
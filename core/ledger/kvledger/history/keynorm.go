@@ -0,0 +1,47 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"golang.org/x/text/unicode/norm"
+)
+
+// EnableKeyNormalization enables the Unicode normalization of the keys of the given namespaces, so that the keys
+// that are visually identical but differently encoded, as written by the clients using different SDKs, share a single
+// history. A key that is valid UTF-8 is indexed, and looked up by the history queries, in the Normalization Form C
+// (NFC); any other key is left as is. The normalization applies to the block commits, including those replayed by a
+// rebuild of the history, the index batches built for a replication stream, and the history queries. The entries
+// imported from a snapshot or applied from a replication stream are taken as they are. The entries indexed before
+// the normalization is enabled for a namespace remain under their original keys until the history is rebuilt or
+// upgraded to the configured format (see function `UpgradeFormat`).
+func (p *DBProvider) EnableKeyNormalization(namespaces []string) error {
+	if len(namespaces) == 0 {
+		p.normalizedNamespaces = nil
+		return nil
+	}
+	normalizedNamespaces := map[string]struct{}{}
+	for _, ns := range namespaces {
+		if ns == "" {
+			return errors.New("invalid namespace for the key normalization, the namespace cannot be empty")
+		}
+		normalizedNamespaces[ns] = struct{}{}
+	}
+	p.normalizedNamespaces = normalizedNamespaces
+	return nil
+}
+
+// normalizeKey returns the key as indexed in the namespace, i.e., in the NFC if the key normalization is enabled
+// for the namespace and the key is valid UTF-8
+func (d *DB) normalizeKey(ns, key string) string {
+	if _, ok := d.normalizedNamespaces[ns]; !ok || !utf8.ValidString(key) {
+		return key
+	}
+	return norm.NFC.String(key)
+}
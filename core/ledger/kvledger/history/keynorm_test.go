@@ -0,0 +1,83 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric/common/ledger/testutil"
+	util2 "github.com/hyperledger/fabric/common/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyNormalization(t *testing.T) {
+	env := newTestHistoryEnv(t)
+	defer env.cleanup()
+	require.EqualError(t, env.testHistoryDBProvider.EnableKeyNormalization([]string{"ns1", ""}),
+		"invalid namespace for the key normalization, the namespace cannot be empty")
+	require.NoError(t, env.testHistoryDBProvider.EnableKeyNormalization([]string{"ns1"}))
+	defer env.testHistoryDBProvider.EnableKeyNormalization(nil)
+
+	store, err := env.testBlockStorageEnv.provider.Open("ledger1")
+	require.NoError(t, err)
+	defer store.Shutdown()
+	historydb := env.testHistoryDBProvider.GetDBHandle("ledger1")
+	bg, gb := testutil.NewBlockGenerator(t, "ledger1", false)
+	commit := func(block *common.Block) {
+		require.NoError(t, store.AddBlock(block))
+		require.NoError(t, historydb.Commit(block))
+	}
+	commit(gb)
+
+	// the composed and the decomposed forms of the same key
+	composed, decomposed := "café", "café"
+	for i, key := range []string{composed, decomposed} {
+		simulator, err := env.txmgr.NewTxSimulator(util2.GenerateUUID())
+		require.NoError(t, err)
+		for _, ns := range []string{"ns1", "ns2"} {
+			require.NoError(t, simulator.SetState(ns, key, []byte{byte(i)}))
+		}
+		simulator.Done()
+		simRes, err := simulator.GetTxSimulationResults()
+		require.NoError(t, err)
+		pubSimResBytes, err := simRes.GetPubSimulationBytes()
+		require.NoError(t, err)
+		commit(bg.NextBlock([][]byte{pubSimResBytes}))
+	}
+
+	qe, err := historydb.NewQueryExecutor(store)
+	require.NoError(t, err)
+	// the keys of ns1 share a single history, looked up by either form
+	testutilVerifyResults(t, qe, "ns1", composed, []string{"\x01", "\x00"})
+	testutilVerifyResults(t, qe, "ns1", decomposed, []string{"\x01", "\x00"})
+	// the keys of ns2 are indexed as written
+	testutilVerifyResults(t, qe, "ns2", composed, []string{"\x00"})
+	testutilVerifyResults(t, qe, "ns2", decomposed, []string{"\x01"})
+
+	histories, err := historydb.GetHistoriesForKeys("ns1", []string{composed, decomposed}, store)
+	require.NoError(t, err)
+	require.Len(t, histories, 2)
+	require.Len(t, histories[composed], 2)
+	require.Equal(t, histories[composed], histories[decomposed])
+
+	keys, err := historydb.ListIndexedKeys("ns1", "", 10)
+	require.NoError(t, err)
+	require.Equal(t, []string{composed}, keys.Keys)
+	keys, err = historydb.ListIndexedKeys("ns2", "", 10)
+	require.NoError(t, err)
+	require.Equal(t, []string{composed, decomposed}, keys.Keys)
+
+	stats, err := historydb.GetIndexStats("ns1")
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), stats.DistinctKeys)
+
+	// a key that is not valid UTF-8 is left as is
+	require.Equal(t, "key\xffe\u0301", historydb.normalizeKey("ns1", "key\xffe\u0301"))
+	require.Equal(t, composed, historydb.normalizeKey("ns1", decomposed))
+	require.Equal(t, decomposed, historydb.normalizeKey("ns2", decomposed))
+}
@@ -0,0 +1,122 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// KeyNotIndexedError is returned by the history queries for a key that is excluded from the history indexing by
+// the key indexing policy of its namespace, set via function `SetKeyIndexingPolicies`
+type KeyNotIndexedError struct {
+	Namespace string
+	Key       string
+}
+
+func (e *KeyNotIndexedError) Error() string {
+	return fmt.Sprintf("history indexing disabled for key [%s] in namespace [%s] by the key indexing policy", e.Key, e.Namespace)
+}
+
+// SetKeyIndexingPolicies controls, per namespace, which of the written keys are indexed, so that the ephemeral keys,
+// such as locks and counters, do not bloat the history database. The includes and the excludes map a namespace to
+// the key patterns. A pattern matches a key in full, where `*` matches any sequence of characters, including none,
+// and `?` matches any single character. Hence, a prefix is specified as, for instance, `lock~*`. A key of a namespace
+// with a policy is indexed if it matches any of the include patterns, or if the namespace has no include patterns,
+// and it does not match any of the exclude patterns. The keys of the namespaces without a policy are all indexed.
+// As with function `DisableIndexing`, the policies apply to the block commits, the snapshot import, the backfill, and
+// the index batches applied from a replication stream, and the history queries for an excluded key fail with a
+// KeyNotIndexedError.
+func (p *DBProvider) SetKeyIndexingPolicies(includes, excludes map[string][]string) error {
+	policies := map[string]*keyIndexingPolicy{}
+	add := func(patternsByNs map[string][]string, include bool) error {
+		for ns, patterns := range patternsByNs {
+			policy, ok := policies[ns]
+			if !ok {
+				policy = &keyIndexingPolicy{}
+				policies[ns] = policy
+			}
+			for _, pattern := range patterns {
+				if ns == "" || pattern == "" {
+					return errors.Errorf("invalid key pattern [%s] for namespace [%s], the namespace and the pattern cannot be empty",
+						pattern, ns)
+				}
+				re := compileKeyPattern(pattern)
+				if include {
+					policy.includes = append(policy.includes, re)
+				} else {
+					policy.excludes = append(policy.excludes, re)
+				}
+			}
+		}
+		return nil
+	}
+	if err := add(includes, true); err != nil {
+		return err
+	}
+	if err := add(excludes, false); err != nil {
+		return err
+	}
+	if len(policies) == 0 {
+		policies = nil
+	}
+	p.keyIndexingPolicies = policies
+	return nil
+}
+
+// keyIndexingPolicy holds the compiled key patterns of a namespace
+type keyIndexingPolicy struct {
+	includes []*regexp.Regexp
+	excludes []*regexp.Regexp
+}
+
+func (p *keyIndexingPolicy) indexes(key string) bool {
+	included := len(p.includes) == 0
+	for _, re := range p.includes {
+		if re.MatchString(key) {
+			included = true
+			break
+		}
+	}
+	if !included {
+		return false
+	}
+	for _, re := range p.excludes {
+		if re.MatchString(key) {
+			return false
+		}
+	}
+	return true
+}
+
+// compileKeyPattern converts a key pattern to an anchored regular expression. The pattern is quoted, except for the
+// wildcards `*` and `?`, and hence, always compiles
+func compileKeyPattern(pattern string) *regexp.Regexp {
+	quoted := regexp.QuoteMeta(pattern)
+	quoted = strings.ReplaceAll(quoted, `\*`, `.*`)
+	quoted = strings.ReplaceAll(quoted, `\?`, `.`)
+	return regexp.MustCompile(`^(?s:` + quoted + `)$`)
+}
+
+// isKeyIndexed returns false if the history indexing is disabled for the namespace, or the key is excluded by the
+// key indexing policy of the namespace. The policy configured on the peer takes precedence over the one declared
+// by the chaincode
+func (d *DB) isKeyIndexed(ns, key string) bool {
+	if !d.isIndexed(ns) {
+		return false
+	}
+	if policy, ok := d.keyIndexingPolicies[ns]; ok {
+		return policy.indexes(key)
+	}
+	if hints := d.hintsFor(ns); hints != nil && hints.keyPolicy != nil {
+		return hints.keyPolicy.indexes(key)
+	}
+	return true
+}
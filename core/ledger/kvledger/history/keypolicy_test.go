@@ -0,0 +1,110 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric/common/ledger/testutil"
+	util2 "github.com/hyperledger/fabric/common/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyIndexingPolicy(t *testing.T) {
+	policy := &keyIndexingPolicy{
+		includes: []*regexp.Regexp{compileKeyPattern("asset~*"), compileKeyPattern("lock~*")},
+		excludes: []*regexp.Regexp{compileKeyPattern("lock~?")},
+	}
+	require.True(t, policy.indexes("asset~1"))
+	require.True(t, policy.indexes("asset~"))
+	require.True(t, policy.indexes("lock~12"))
+	require.False(t, policy.indexes("lock~1"))
+	require.False(t, policy.indexes("counter"))
+	// the patterns match the keys in full and the other characters are literal
+	require.False(t, policy.indexes("my-asset~1"))
+	require.False(t, compileKeyPattern("a.b").MatchString("axb"))
+	require.True(t, compileKeyPattern("a*").MatchString("a\nb"))
+
+	excludeOnly := &keyIndexingPolicy{excludes: []*regexp.Regexp{compileKeyPattern("*~tmp")}}
+	require.True(t, excludeOnly.indexes("asset1"))
+	require.False(t, excludeOnly.indexes("asset1~tmp"))
+}
+
+func TestSetKeyIndexingPolicies(t *testing.T) {
+	env := newTestHistoryEnv(t)
+	defer env.cleanup()
+	store, err := env.testBlockStorageEnv.provider.Open("ledger1")
+	require.NoError(t, err)
+	defer store.Shutdown()
+
+	require.EqualError(t, env.testHistoryDBProvider.SetKeyIndexingPolicies(nil, map[string][]string{"ns1": {""}}),
+		"invalid key pattern [] for namespace [ns1], the namespace and the pattern cannot be empty")
+	require.NoError(t, env.testHistoryDBProvider.SetKeyIndexingPolicies(
+		map[string][]string{"ns1": {"asset~*"}},
+		map[string][]string{"ns2": {"lock~*"}},
+	))
+	historydb := env.testHistoryDBProvider.GetDBHandle("ledger1")
+
+	bg, gb := testutil.NewBlockGenerator(t, "ledger1", false)
+	require.NoError(t, store.AddBlock(gb))
+	require.NoError(t, historydb.Commit(gb))
+	simulator, err := env.txmgr.NewTxSimulator(util2.GenerateUUID())
+	require.NoError(t, err)
+	for _, k := range []string{"asset~1", "counter"} {
+		require.NoError(t, simulator.SetState("ns1", k, []byte("value1")))
+		require.NoError(t, simulator.SetState("ns3", k, []byte("value1")))
+	}
+	for _, k := range []string{"asset~1", "lock~1"} {
+		require.NoError(t, simulator.SetState("ns2", k, []byte("value1")))
+	}
+	simulator.Done()
+	simRes, err := simulator.GetTxSimulationResults()
+	require.NoError(t, err)
+	pubSimResBytes, err := simRes.GetPubSimulationBytes()
+	require.NoError(t, err)
+	block := bg.NextBlock([][]byte{pubSimResBytes})
+	require.NoError(t, store.AddBlock(block))
+	require.NoError(t, historydb.Commit(block))
+
+	indexedKeys := func(historydb *DB) []string {
+		var keys []string
+		require.NoError(t, historydb.ScanIndex("", "", func(e *IndexEntry) error {
+			keys = append(keys, e.Namespace+":"+e.Key)
+			return nil
+		}))
+		return keys
+	}
+	expectedKeys := []string{"ns1:asset~1", "ns2:asset~1", "ns3:asset~1", "ns3:counter"}
+	require.ElementsMatch(t, expectedKeys, indexedKeys(historydb))
+
+	qe, err := historydb.NewQueryExecutor(store)
+	require.NoError(t, err)
+	itr, err := qe.GetHistoryForKey("ns2", "asset~1")
+	require.NoError(t, err)
+	kmod, err := itr.Next()
+	require.NoError(t, err)
+	require.NotNil(t, kmod)
+	itr.Close()
+	_, err = qe.GetHistoryForKey("ns2", "lock~1")
+	require.Equal(t, &KeyNotIndexedError{Namespace: "ns2", Key: "lock~1"}, err)
+	require.EqualError(t, err, "history indexing disabled for key [lock~1] in namespace [ns2] by the key indexing policy")
+
+	// the subscriber of the index batches applies its own policies
+	subscriberProvider, err := NewDBProvider(t.TempDir())
+	require.NoError(t, err)
+	defer subscriberProvider.Close()
+	require.NoError(t, subscriberProvider.SetKeyIndexingPolicies(nil, map[string][]string{"ns3": {"count*"}}))
+	subscriber := subscriberProvider.GetDBHandle("ledger1")
+	for _, b := range []*common.Block{gb, block} {
+		batch, err := historydb.NewIndexBatch(b)
+		require.NoError(t, err)
+		require.NoError(t, subscriber.ApplyIndexBatch(batch))
+	}
+	require.ElementsMatch(t, []string{"ns1:asset~1", "ns1:counter", "ns2:asset~1", "ns2:lock~1", "ns3:asset~1"}, indexedKeys(subscriber))
+}
@@ -8,6 +8,7 @@ package history
 
 import (
 	"bytes"
+	"sync"
 
 	"github.com/hyperledger/fabric/common/ledger/util"
 	"github.com/pkg/errors"
@@ -24,6 +25,19 @@ var (
 	compositeKeySep = []byte{0x00} // used as a separator between different components of dataKey
 	savePointKey    = []byte{'s'}  // a single key in db for persisting savepoint
 	emptyValue      = []byte{}     // used to store as value for keys where only key needs to be stored (e.g., dataKeys)
+
+	// metadataKeyPrefix is used as a prefix for the keys that maintain the bookkeeping information in the historydb.
+	// As a namespace cannot be empty, a dataKey never begins with this prefix
+	metadataKeyPrefix          = []byte{0x00}
+	backfillProgressKey        = []byte{0x00, 'b'} // a single key in db for persisting the progress of the history backfill
+	lifecycleApprovalKeyPrefix = []byte{0x00, 'l'} // prefix for the keys that persist the chaincode definition approvals
+	indexStatsKeyPrefix        = []byte{0x00, 'n'} // prefix for the keys that persist the index statistics, one per namespace
+	viewRowKeyPrefix           = []byte{0x00, 'v'} // prefix for the keys that persist the rows of the history views
+	sizeSampleKeyPrefix        = []byte{0x00, 'z'} // prefix for the keys that persist the sampled on-disk index size, one per namespace
+	valueStateKeyPrefix        = []byte{0x00, 'u'} // prefix for the keys that persist the tracked value of the keys whose unchanged writes are skipped
+	valueSizeKeyPrefix         = []byte{0x00, 'w'} // prefix for the keys that persist the sizes of the written values, one per dataKey
+	txKeyKeyPrefix             = []byte{0x00, 't'} // prefix for the keys that index the dataKeys by the block and transaction number
+	rebuildInvalidationKey     = []byte{0x00, 'r'} // a single key in db for persisting the blocks rebuilt in the history of the next generation
 )
 
 // constructDataKey builds the key of the format namespace~len(key)~key~blocknum~trannum
@@ -32,15 +46,30 @@ var (
 //
 //	a historydb rebuild when upgrading an older version to v2.0.
 func constructDataKey(ns string, key string, blocknum uint64, trannum uint64) dataKey {
-	k := append([]byte(ns), compositeKeySep...)
+	return appendDataKey(nil, ns, key, blocknum, trannum)
+}
+
+// appendDataKey appends the dataKey, as constructed by function `constructDataKey`, to buf
+func appendDataKey(buf []byte, ns string, key string, blocknum uint64, trannum uint64) dataKey {
+	k := append(buf, ns...)
+	k = append(k, compositeKeySep...)
 	k = append(k, util.EncodeOrderPreservingVarUint64(uint64(len(key)))...)
-	k = append(k, []byte(key)...)
+	k = append(k, key...)
 	k = append(k, compositeKeySep...)
 	k = append(k, util.EncodeOrderPreservingVarUint64(blocknum)...)
 	k = append(k, util.EncodeOrderPreservingVarUint64(trannum)...)
 	return dataKey(k)
 }
 
+// keyBufferPool holds the buffers for constructing the keys that are added to a write batch, which copies the keys,
+// so that the block commits do not allocate a key per write
+var keyBufferPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, 0, 256)
+		return &buf
+	},
+}
+
 // constructRangescanKeys returns start and endKey for performing a range scan
 // that covers all the keys for <ns, key>.
 // startKey = namespace~len(key)~key~
@@ -58,7 +87,11 @@ func constructRangeScan(ns string, key string) *rangeScan {
 }
 
 func (r *rangeScan) decodeBlockNumTranNum(dataKey dataKey) (uint64, uint64, error) {
-	blockNumTranNumBytes := bytes.TrimPrefix(dataKey, r.startKey)
+	return decodeBlockNumTranNum(bytes.TrimPrefix(dataKey, r.startKey))
+}
+
+// decodeBlockNumTranNum decodes the blockNum and tranNum from the trailing part of a dataKey
+func decodeBlockNumTranNum(blockNumTranNumBytes []byte) (uint64, uint64, error) {
 	blockNum, blockBytesConsumed, err := util.DecodeOrderPreservingVarUint64(blockNumTranNumBytes)
 	if err != nil {
 		return 0, 0, err
@@ -76,3 +109,38 @@ func (r *rangeScan) decodeBlockNumTranNum(dataKey dataKey) (uint64, uint64, erro
 	}
 	return blockNum, tranNum, nil
 }
+
+// isDataKey returns true if the key is a dataKey and not one of the keys used for bookkeeping
+func isDataKey(key []byte) bool {
+	return !bytes.Equal(key, savePointKey) && !bytes.HasPrefix(key, metadataKeyPrefix)
+}
+
+// decodeDataKey decodes the namespace, key, blockNum, and tranNum from a dataKey
+// constructed via function `constructDataKey`
+func decodeDataKey(dataKey dataKey) (string, string, uint64, uint64, error) {
+	sepIndex := bytes.Index(dataKey, compositeKeySep)
+	if sepIndex == -1 {
+		return "", "", 0, 0, errors.Errorf("invalid data key [%x], namespace separator not found", []byte(dataKey))
+	}
+	ns := string(dataKey[:sepIndex])
+	remaining := dataKey[sepIndex+1:]
+
+	keyLen, keyLenBytesConsumed, err := util.DecodeOrderPreservingVarUint64(remaining)
+	if err != nil {
+		return "", "", 0, 0, err
+	}
+	remaining = remaining[keyLenBytesConsumed:]
+	if uint64(len(remaining)) < keyLen+uint64(len(compositeKeySep)) {
+		return "", "", 0, 0, errors.Errorf("invalid data key [%x], insufficient bytes for key of length %d", []byte(dataKey), keyLen)
+	}
+	key := string(remaining[:keyLen])
+	if !bytes.Equal(remaining[keyLen:keyLen+uint64(len(compositeKeySep))], compositeKeySep) {
+		return "", "", 0, 0, errors.Errorf("invalid data key [%x], key separator not found", []byte(dataKey))
+	}
+
+	blockNum, tranNum, err := decodeBlockNumTranNum(remaining[keyLen+uint64(len(compositeKeySep)):])
+	if err != nil {
+		return "", "", 0, 0, err
+	}
+	return ns, key, blockNum, tranNum, nil
+}
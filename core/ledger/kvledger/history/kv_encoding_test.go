@@ -54,3 +54,42 @@ func TestSplitCompositeKey(t *testing.T) {
 	require.Equal(t, blkNum, uint64(20))
 	require.Equal(t, txNum, uint64(200))
 }
+
+func TestDecodeDataKey(t *testing.T) {
+	testData := []struct {
+		ns, key         string
+		blkNum, tranNum uint64
+	}{
+		{"ns1", "key1", 1, 0},
+		{"ns1", "key1\x00", 1, 5},
+		{"ns1", "\x00key\x00\x001", 100, 100},
+		{"ns1", "", 256, 1},
+	}
+	for _, testDatum := range testData {
+		ns, key, blkNum, tranNum, err := decodeDataKey(
+			constructDataKey(testDatum.ns, testDatum.key, testDatum.blkNum, testDatum.tranNum),
+		)
+		require.NoError(t, err)
+		require.Equal(t, testDatum.ns, ns)
+		require.Equal(t, testDatum.key, key)
+		require.Equal(t, testDatum.blkNum, blkNum)
+		require.Equal(t, testDatum.tranNum, tranNum)
+	}
+
+	_, _, _, _, err := decodeDataKey(savePointKey)
+	require.EqualError(t, err, "invalid data key [73], namespace separator not found")
+
+	truncatedKey := constructDataKey("ns1", "key1", 1, 0)[:6]
+	_, _, _, _, err = decodeDataKey(truncatedKey)
+	require.EqualError(t, err, "invalid data key [6e7331000104], insufficient bytes for key of length 4")
+
+	missingSepKey := constructDataKey("ns1", "key1", 1, 0)
+	missingSepKey[10] = 0x01
+	_, _, _, _, err = decodeDataKey(missingSepKey)
+	require.EqualError(t, err, "invalid data key [6e73310001046b65793101010100], key separator not found")
+
+	// a key built into a buffer with a prefix decodes as the one constructed on its own
+	buf := append(make([]byte, 0, 64), "prefix"...)
+	appended := appendDataKey(buf, "ns1", "key1", 20, 200)
+	require.Equal(t, constructDataKey("ns1", "key1", 20, 200), appended[len("prefix"):])
+}
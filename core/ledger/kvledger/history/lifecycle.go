@@ -0,0 +1,351 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"bytes"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric-protos-go/peer"
+	lb "github.com/hyperledger/fabric-protos-go/peer/lifecycle"
+	"github.com/hyperledger/fabric/common/ledger/util"
+	"github.com/hyperledger/fabric/common/ledger/util/leveldbhelper"
+	"github.com/hyperledger/fabric/core/chaincode/implicitcollection"
+	"github.com/hyperledger/fabric/core/ledger/internal/version"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/rwsetutil"
+	"github.com/hyperledger/fabric/protoutil"
+	"github.com/pkg/errors"
+)
+
+const (
+	// lifecycleNamespace is the namespace in which the `_lifecycle` system chaincode maintains the chaincode definitions
+	lifecycleNamespace = "_lifecycle"
+	// approveFuncName is the `_lifecycle` function that approves a chaincode definition for the org of the peer
+	approveFuncName = "ApproveChaincodeDefinitionForMyOrg"
+)
+
+// ChaincodeDefinitionCommit is a commit of a chaincode definition, decoded from the history of the `_lifecycle`
+// namespace, along with the approvals for its sequence committed prior to it
+type ChaincodeDefinitionCommit struct {
+	Name            string
+	Sequence        int64
+	BlockNum        uint64
+	TranNum         uint64
+	TxID            string
+	Timestamp       *timestamp.Timestamp
+	EndorsementInfo *lb.ChaincodeEndorsementInfo
+	ValidationInfo  *lb.ChaincodeValidationInfo
+	Collections     *peer.CollectionConfigPackage
+	// Approvals are the latest approvals, one per org, for the sequence committed prior to the definition
+	Approvals []*ChaincodeApproval
+}
+
+// ChaincodeApproval is an approval of a chaincode definition by an org
+type ChaincodeApproval struct {
+	MSPID    string
+	BlockNum uint64
+	TranNum  uint64
+	// Definition is the definition as approved, i.e., the arguments of the approval transaction
+	Definition *lb.ApproveChaincodeDefinitionForMyOrgArgs
+	// Matches is set for an approval listed with a chaincode definition commit and is true if the approved definition
+	// is the committed definition. The fields left empty in the approval, which take the channel defaults, are not compared
+	Matches bool
+}
+
+// GetChaincodeDefinitionHistory returns the commits of the definition of the chaincode name, from the oldest to the
+// newest, decoded from the history of the `_lifecycle` namespace. The approvals are recorded as the blocks are indexed
+// and hence, are not available for the blocks prior to the snapshot from which the ledger was bootstrapped, or if the
+// history indexing is disabled for the `_lifecycle` namespace.
+func (d *DB) GetChaincodeDefinitionHistory(name string, txFetcher TxFetcher) ([]*ChaincodeDefinitionCommit, error) {
+	fieldEntries := map[string][]*Entry{}
+	for _, field := range []string{"Sequence", "EndorsementInfo", "ValidationInfo", "Collections"} {
+		if err := d.Scan(lifecycleNamespace, lifecycleFieldKey(name, field), txFetcher, func(e *Entry) error {
+			if !e.KeyModification.IsDelete {
+				fieldEntries[field] = append(fieldEntries[field], e)
+			}
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	// only the fields that change are written by a commit and hence, a definition is assembled from the latest
+	// write of each field up to the commit of its sequence
+	current := &ChaincodeDefinitionCommit{}
+	applied := map[string]int{}
+	applyFields := func(height *version.Height) error {
+		for _, field := range []string{"EndorsementInfo", "ValidationInfo", "Collections"} {
+			for ; applied[field] < len(fieldEntries[field]); applied[field]++ {
+				e := fieldEntries[field][applied[field]]
+				if version.NewHeight(e.BlockNum, e.TranNum).Compare(height) > 0 {
+					break
+				}
+				b, err := decodeBytesStateData(e.KeyModification.Value)
+				if err != nil {
+					return errors.WithMessagef(err, "error while decoding field [%s] of chaincode definition [%s]", field, name)
+				}
+				var msg proto.Message
+				switch field {
+				case "EndorsementInfo":
+					current.EndorsementInfo = &lb.ChaincodeEndorsementInfo{}
+					msg = current.EndorsementInfo
+				case "ValidationInfo":
+					current.ValidationInfo = &lb.ChaincodeValidationInfo{}
+					msg = current.ValidationInfo
+				default:
+					current.Collections = &peer.CollectionConfigPackage{}
+					msg = current.Collections
+				}
+				if err := proto.Unmarshal(b, msg); err != nil {
+					return errors.Wrapf(err, "error while unmarshalling field [%s] of chaincode definition [%s]", field, name)
+				}
+			}
+		}
+		return nil
+	}
+
+	var commits []*ChaincodeDefinitionCommit
+	for _, e := range fieldEntries["Sequence"] {
+		height := version.NewHeight(e.BlockNum, e.TranNum)
+		if err := applyFields(height); err != nil {
+			return nil, err
+		}
+		sequence, err := decodeInt64StateData(e.KeyModification.Value)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "error while decoding sequence of chaincode definition [%s]", name)
+		}
+		c := &ChaincodeDefinitionCommit{
+			Name:            name,
+			Sequence:        sequence,
+			BlockNum:        e.BlockNum,
+			TranNum:         e.TranNum,
+			TxID:            e.KeyModification.TxId,
+			Timestamp:       e.KeyModification.Timestamp,
+			EndorsementInfo: current.EndorsementInfo,
+			ValidationInfo:  current.ValidationInfo,
+			Collections:     current.Collections,
+		}
+		approvals, err := d.GetChaincodeApprovals(name, sequence)
+		if err != nil {
+			return nil, err
+		}
+		latest := map[string]int{}
+		for _, a := range approvals {
+			if version.NewHeight(a.BlockNum, a.TranNum).Compare(height) >= 0 {
+				break
+			}
+			a.Matches = c.matches(a.Definition)
+			if i, ok := latest[a.MSPID]; ok {
+				c.Approvals[i] = a
+				continue
+			}
+			latest[a.MSPID] = len(c.Approvals)
+			c.Approvals = append(c.Approvals, a)
+		}
+		commits = append(commits, c)
+	}
+	return commits, nil
+}
+
+// GetChaincodeApprovals returns the approvals, by all the orgs, of the given sequence of the definition of the
+// chaincode name, in the order they are committed. An org that approves the sequence multiple times has an
+// approval listed for each time.
+func (d *DB) GetChaincodeApprovals(name string, sequence int64) ([]*ChaincodeApproval, error) {
+	prefix := constructLifecycleApprovalPrefix(name, sequence)
+	itr, err := d.levelDB.GetIterator(prefix, append(prefix, 0xff))
+	if err != nil {
+		return nil, err
+	}
+	defer itr.Release()
+
+	var approvals []*ChaincodeApproval
+	for itr.Next() {
+		if err := itr.Error(); err != nil {
+			return nil, errors.Wrap(err, "internal leveldb error while iterating for chaincode approvals")
+		}
+		blockNum, tranNum, err := decodeBlockNumTranNum(itr.Key()[len(prefix):])
+		if err != nil {
+			return nil, err
+		}
+		approval, err := decodeLifecycleApproval(itr.Value())
+		if err != nil {
+			return nil, err
+		}
+		approval.BlockNum, approval.TranNum = blockNum, tranNum
+		approvals = append(approvals, approval)
+	}
+	return approvals, nil
+}
+
+// addLifecycleApproval adds to the batch the approval of a chaincode definition, if the transaction is one
+func (d *DB) addLifecycleApproval(batch *leveldbhelper.UpdateBatch, blockNum, tranNum uint64, payload *common.Payload,
+	txRWSet *rwsetutil.TxRwSet) error {
+	if !d.isIndexed(lifecycleNamespace) {
+		return nil
+	}
+	mspID := approvingOrg(txRWSet)
+	if mspID == "" {
+		return nil
+	}
+	args, err := approvalArgs(payload)
+	if err != nil || args == nil {
+		return err
+	}
+	argsBytes, err := proto.Marshal(args)
+	if err != nil {
+		return errors.Wrap(err, "error while marshalling chaincode approval")
+	}
+	k := append(constructLifecycleApprovalPrefix(args.Name, args.Sequence), util.EncodeOrderPreservingVarUint64(blockNum)...)
+	k = append(k, util.EncodeOrderPreservingVarUint64(tranNum)...)
+	v := append(util.EncodeOrderPreservingVarUint64(uint64(len(mspID))), mspID...)
+	batch.Put(k, append(v, argsBytes...))
+	return nil
+}
+
+// approvingOrg returns the org whose implicit collection the transaction writes to in the `_lifecycle` namespace,
+// which is where an approval is recorded, or an empty string if the transaction writes to no such collection
+func approvingOrg(txRWSet *rwsetutil.TxRwSet) string {
+	for _, nsRWSet := range txRWSet.NsRwSets {
+		if nsRWSet.NameSpace != lifecycleNamespace {
+			continue
+		}
+		for _, collRWSet := range nsRWSet.CollHashedRwSets {
+			isImplicit, mspID := implicitcollection.MspIDIfImplicitCollection(collRWSet.CollectionName)
+			if isImplicit && len(collRWSet.HashedRwSet.GetHashedWrites()) > 0 {
+				return mspID
+			}
+		}
+	}
+	return ""
+}
+
+// approvalArgs returns the arguments of the transaction if it invokes the `_lifecycle` function that approves
+// a chaincode definition, or nil otherwise
+func approvalArgs(payload *common.Payload) (*lb.ApproveChaincodeDefinitionForMyOrgArgs, error) {
+	tx, err := protoutil.UnmarshalTransaction(payload.Data)
+	if err != nil {
+		return nil, err
+	}
+	if len(tx.Actions) == 0 {
+		return nil, nil
+	}
+	actionPayload, _, err := protoutil.GetPayloads(tx.Actions[0])
+	if err != nil {
+		return nil, err
+	}
+	cpp, err := protoutil.UnmarshalChaincodeProposalPayload(actionPayload.ChaincodeProposalPayload)
+	if err != nil {
+		return nil, err
+	}
+	cis, err := protoutil.UnmarshalChaincodeInvocationSpec(cpp.Input)
+	if err != nil {
+		return nil, err
+	}
+	args := cis.GetChaincodeSpec().GetInput().GetArgs()
+	if len(args) != 2 || string(args[0]) != approveFuncName {
+		return nil, nil
+	}
+	approveArgs := &lb.ApproveChaincodeDefinitionForMyOrgArgs{}
+	if err := proto.Unmarshal(args[1], approveArgs); err != nil {
+		return nil, errors.Wrap(err, "error while unmarshalling chaincode approval")
+	}
+	return approveArgs, nil
+}
+
+// matches returns true if the approved definition is the committed definition. The fields left empty in
+// the approval take the channel defaults and are not compared
+func (c *ChaincodeDefinitionCommit) matches(approved *lb.ApproveChaincodeDefinitionForMyOrgArgs) bool {
+	ei, vi := c.EndorsementInfo, c.ValidationInfo
+	if ei == nil || vi == nil || approved.Version != ei.Version || approved.InitRequired != ei.InitRequired {
+		return false
+	}
+	if approved.EndorsementPlugin != "" && approved.EndorsementPlugin != ei.EndorsementPlugin {
+		return false
+	}
+	if approved.ValidationPlugin != "" && approved.ValidationPlugin != vi.ValidationPlugin {
+		return false
+	}
+	if len(approved.ValidationParameter) > 0 && !bytes.Equal(approved.ValidationParameter, vi.ValidationParameter) {
+		return false
+	}
+	return len(approved.Collections.GetConfig()) == len(c.Collections.GetConfig()) &&
+		(len(approved.Collections.GetConfig()) == 0 || proto.Equal(approved.Collections, c.Collections))
+}
+
+// lifecycleFieldKey returns the key in the `_lifecycle` namespace of a field of the definition of the chaincode name
+func lifecycleFieldKey(name, field string) string {
+	return "namespaces/fields/" + name + "/" + field
+}
+
+// constructLifecycleApprovalPrefix builds the prefix of the format lifecycleApprovalKeyPrefix~name~sequence
+// of the keys of the approvals, which are followed by the blocknum and the trannum of the approval
+func constructLifecycleApprovalPrefix(name string, sequence int64) []byte {
+	k := append([]byte{}, lifecycleApprovalKeyPrefix...)
+	k = append(k, name...)
+	k = append(k, compositeKeySep...)
+	return append(k, util.EncodeOrderPreservingVarUint64(uint64(sequence))...)
+}
+
+// decodeLifecycleApprovalBlockNum decodes the block number from a key constructed by function `addLifecycleApproval`
+func decodeLifecycleApprovalBlockNum(k []byte) (uint64, error) {
+	remaining := bytes.TrimPrefix(k, lifecycleApprovalKeyPrefix)
+	sepIndex := bytes.Index(remaining, compositeKeySep)
+	if sepIndex == -1 {
+		return 0, errors.Errorf("invalid chaincode approval key [%x], name separator not found", k)
+	}
+	remaining = remaining[sepIndex+1:]
+	_, sequenceBytesConsumed, err := util.DecodeOrderPreservingVarUint64(remaining)
+	if err != nil {
+		return 0, err
+	}
+	blockNum, _, err := decodeBlockNumTranNum(remaining[sequenceBytesConsumed:])
+	return blockNum, err
+}
+
+func decodeLifecycleApproval(b []byte) (*ChaincodeApproval, error) {
+	mspIDLen, bytesConsumed, err := util.DecodeOrderPreservingVarUint64(b)
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(b)-bytesConsumed) < mspIDLen {
+		return nil, errors.Errorf("invalid chaincode approval [%x], insufficient bytes for msp id of length %d", b, mspIDLen)
+	}
+	b = b[bytesConsumed:]
+	definition := &lb.ApproveChaincodeDefinitionForMyOrgArgs{}
+	if err := proto.Unmarshal(b[mspIDLen:], definition); err != nil {
+		return nil, errors.Wrap(err, "error while unmarshalling chaincode approval")
+	}
+	return &ChaincodeApproval{MSPID: string(b[:mspIDLen]), Definition: definition}, nil
+}
+
+// decodeInt64StateData decodes the value of an integer field, as serialized by the `_lifecycle` system chaincode
+func decodeInt64StateData(b []byte) (int64, error) {
+	stateData := &lb.StateData{}
+	if err := proto.Unmarshal(b, stateData); err != nil {
+		return 0, errors.Wrap(err, "error while unmarshalling state data")
+	}
+	v, ok := stateData.Type.(*lb.StateData_Int64)
+	if !ok {
+		return 0, errors.Errorf("expected state data of type int64, found %T", stateData.Type)
+	}
+	return v.Int64, nil
+}
+
+// decodeBytesStateData decodes the value of a bytes field, as serialized by the `_lifecycle` system chaincode
+func decodeBytesStateData(b []byte) ([]byte, error) {
+	stateData := &lb.StateData{}
+	if err := proto.Unmarshal(b, stateData); err != nil {
+		return nil, errors.Wrap(err, "error while unmarshalling state data")
+	}
+	v, ok := stateData.Type.(*lb.StateData_Bytes)
+	if !ok {
+		return nil, errors.Errorf("expected state data of type bytes, found %T", stateData.Type)
+	}
+	return v.Bytes, nil
+}
@@ -0,0 +1,185 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric-protos-go/ledger/rwset/kvrwset"
+	"github.com/hyperledger/fabric-protos-go/peer"
+	lb "github.com/hyperledger/fabric-protos-go/peer/lifecycle"
+	"github.com/hyperledger/fabric/common/ledger/testutil"
+	"github.com/hyperledger/fabric/common/ledger/testutil/fakes"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/rwsetutil"
+	"github.com/hyperledger/fabric/protoutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChaincodeDefinitionHistory(t *testing.T) {
+	env := newTestHistoryEnv(t)
+	defer env.cleanup()
+	store, err := env.testBlockStorageEnv.provider.Open("ledger1")
+	require.NoError(t, err)
+	defer store.Shutdown()
+
+	historydb := env.testHistoryDBProvider.GetDBHandle("ledger1")
+	_, gb := testutil.NewBlockGenerator(t, "ledger1", false)
+	require.NoError(t, store.AddBlock(gb))
+	require.NoError(t, historydb.Commit(gb))
+	prevBlock := gb
+	commit := func(envs ...*common.Envelope) {
+		block := testutil.NewBlock(envs, prevBlock.Header.Number+1, protoutil.BlockHeaderHash(prevBlock.Header))
+		require.NoError(t, store.AddBlock(block))
+		require.NoError(t, historydb.Commit(block))
+		prevBlock = block
+	}
+
+	approval := func(mspID string, sequence int64, ccVersion string) *common.Envelope {
+		args := &lb.ApproveChaincodeDefinitionForMyOrgArgs{Name: "cc", Sequence: sequence, Version: ccVersion}
+		return lifecycleTx(t, args, &rwsetutil.TxRwSet{
+			NsRwSets: []*rwsetutil.NsRwSet{
+				{
+					NameSpace: "_lifecycle",
+					KvRwSet:   &kvrwset.KVRWSet{},
+					CollHashedRwSets: []*rwsetutil.CollHashedRwSet{
+						{
+							CollectionName: "_implicit_org_" + mspID,
+							HashedRwSet: &kvrwset.HashedRWSet{
+								HashedWrites: []*kvrwset.KVWriteHash{{KeyHash: []byte("key-hash"), ValueHash: []byte("value-hash")}},
+							},
+						},
+					},
+				},
+			},
+		})
+	}
+	validationInfo := &lb.ChaincodeValidationInfo{ValidationPlugin: "vscc", ValidationParameter: []byte("policy")}
+	definitionCommit := func(sequence int64, endorsementInfo *lb.ChaincodeEndorsementInfo, withValidationInfo bool) *common.Envelope {
+		writes := []*kvrwset.KVWrite{
+			{Key: lifecycleFieldKey("cc", "Sequence"), Value: stateData(t, &lb.StateData_Int64{Int64: sequence})},
+			{Key: lifecycleFieldKey("cc", "EndorsementInfo"), Value: stateData(t, &lb.StateData_Bytes{Bytes: protoutil.MarshalOrPanic(endorsementInfo)})},
+		}
+		if withValidationInfo {
+			writes = append(writes,
+				&kvrwset.KVWrite{Key: lifecycleFieldKey("cc", "ValidationInfo"), Value: stateData(t, &lb.StateData_Bytes{Bytes: protoutil.MarshalOrPanic(validationInfo)})},
+				&kvrwset.KVWrite{Key: lifecycleFieldKey("cc", "Collections"), Value: stateData(t, &lb.StateData_Bytes{})},
+			)
+		}
+		return lifecycleTx(t, nil, &rwsetutil.TxRwSet{
+			NsRwSets: []*rwsetutil.NsRwSet{{NameSpace: "_lifecycle", KvRwSet: &kvrwset.KVRWSet{Writes: writes}}},
+		})
+	}
+
+	commit(approval("Org1MSP", 1, "v1"), approval("Org2MSP", 1, "v0"))
+	commit(approval("Org2MSP", 1, "v1"), definitionCommit(1, &lb.ChaincodeEndorsementInfo{Version: "v1", EndorsementPlugin: "escc"}, true))
+	commit(approval("Org1MSP", 2, "v2"), definitionCommit(2, &lb.ChaincodeEndorsementInfo{Version: "v2", EndorsementPlugin: "escc"}, false))
+	commit(approval("Org2MSP", 2, "v2"))
+
+	commits, err := historydb.GetChaincodeDefinitionHistory("cc", store)
+	require.NoError(t, err)
+	require.Len(t, commits, 2)
+
+	require.Equal(t, int64(1), commits[0].Sequence)
+	require.Equal(t, uint64(2), commits[0].BlockNum)
+	require.Equal(t, uint64(1), commits[0].TranNum)
+	require.NotEmpty(t, commits[0].TxID)
+	require.True(t, proto.Equal(&lb.ChaincodeEndorsementInfo{Version: "v1", EndorsementPlugin: "escc"}, commits[0].EndorsementInfo))
+	require.True(t, proto.Equal(validationInfo, commits[0].ValidationInfo))
+	require.True(t, proto.Equal(&peer.CollectionConfigPackage{}, commits[0].Collections))
+	require.Len(t, commits[0].Approvals, 2)
+	require.Equal(t, "Org1MSP", commits[0].Approvals[0].MSPID)
+	require.Equal(t, uint64(1), commits[0].Approvals[0].BlockNum)
+	require.True(t, commits[0].Approvals[0].Matches)
+	// the approval by Org2MSP is the latest, for the sequence, prior to the commit
+	require.Equal(t, "Org2MSP", commits[0].Approvals[1].MSPID)
+	require.Equal(t, uint64(2), commits[0].Approvals[1].BlockNum)
+	require.Equal(t, "v1", commits[0].Approvals[1].Definition.Version)
+	require.True(t, commits[0].Approvals[1].Matches)
+
+	require.Equal(t, int64(2), commits[1].Sequence)
+	require.Equal(t, "v2", commits[1].EndorsementInfo.Version)
+	// the validation info is unchanged and hence, not written by the commit of the sequence 2
+	require.True(t, proto.Equal(validationInfo, commits[1].ValidationInfo))
+	require.Len(t, commits[1].Approvals, 1)
+	require.Equal(t, "Org1MSP", commits[1].Approvals[0].MSPID)
+	require.True(t, commits[1].Approvals[0].Matches)
+
+	approvals, err := historydb.GetChaincodeApprovals("cc", 2)
+	require.NoError(t, err)
+	require.Len(t, approvals, 2)
+	require.Equal(t, "Org2MSP", approvals[1].MSPID)
+	require.Equal(t, uint64(4), approvals[1].BlockNum)
+
+	approvals, err = historydb.GetChaincodeApprovals("cc", 3)
+	require.NoError(t, err)
+	require.Empty(t, approvals)
+
+	commits, err = historydb.GetChaincodeDefinitionHistory("unknown", store)
+	require.NoError(t, err)
+	require.Empty(t, commits)
+}
+
+func TestChaincodeApprovalMatches(t *testing.T) {
+	c := &ChaincodeDefinitionCommit{
+		EndorsementInfo: &lb.ChaincodeEndorsementInfo{Version: "v1", EndorsementPlugin: "escc"},
+		ValidationInfo:  &lb.ChaincodeValidationInfo{ValidationPlugin: "vscc", ValidationParameter: []byte("policy")},
+		Collections:     &peer.CollectionConfigPackage{},
+	}
+	require.True(t, c.matches(&lb.ApproveChaincodeDefinitionForMyOrgArgs{Version: "v1"}))
+	require.True(t, c.matches(&lb.ApproveChaincodeDefinitionForMyOrgArgs{
+		Version: "v1", EndorsementPlugin: "escc", ValidationPlugin: "vscc", ValidationParameter: []byte("policy"),
+	}))
+	require.False(t, c.matches(&lb.ApproveChaincodeDefinitionForMyOrgArgs{Version: "v2"}))
+	require.False(t, c.matches(&lb.ApproveChaincodeDefinitionForMyOrgArgs{Version: "v1", InitRequired: true}))
+	require.False(t, c.matches(&lb.ApproveChaincodeDefinitionForMyOrgArgs{Version: "v1", ValidationParameter: []byte("other")}))
+	require.False(t, c.matches(&lb.ApproveChaincodeDefinitionForMyOrgArgs{
+		Version:     "v1",
+		Collections: &peer.CollectionConfigPackage{Config: []*peer.CollectionConfig{{}}},
+	}))
+}
+
+// lifecycleTx constructs a transaction invoking the `_lifecycle` system chaincode with the given read-write set and,
+// if approveArgs is not nil, with the arguments for approving a chaincode definition
+func lifecycleTx(t *testing.T, approveArgs *lb.ApproveChaincodeDefinitionForMyOrgArgs, txRWSet *rwsetutil.TxRwSet) *common.Envelope {
+	simRes, err := txRWSet.ToProtoBytes()
+	require.NoError(t, err)
+	var args [][]byte
+	if approveArgs != nil {
+		args = [][]byte{[]byte("ApproveChaincodeDefinitionForMyOrg"), protoutil.MarshalOrPanic(approveArgs)}
+	} else {
+		args = [][]byte{[]byte("CommitChaincodeDefinition")}
+	}
+	ccid := &peer.ChaincodeID{Name: "_lifecycle"}
+	signer := &fakes.SigningIdentity{}
+	prop, _, err := protoutil.CreateChaincodeProposal(
+		common.HeaderType_ENDORSER_TRANSACTION,
+		"testchannelid",
+		&peer.ChaincodeInvocationSpec{ChaincodeSpec: &peer.ChaincodeSpec{ChaincodeId: ccid, Input: &peer.ChaincodeInput{Args: args}}},
+		nil,
+	)
+	require.NoError(t, err)
+	presp, err := protoutil.CreateProposalResponse(prop.Header, prop.Payload, nil, simRes, nil, ccid, signer)
+	require.NoError(t, err)
+	env, err := protoutil.CreateSignedTx(prop, signer, presp)
+	require.NoError(t, err)
+	return env
+}
+
+func stateData(t *testing.T, data interface{}) []byte {
+	stateData := &lb.StateData{}
+	switch d := data.(type) {
+	case *lb.StateData_Int64:
+		stateData.Type = d
+	case *lb.StateData_Bytes:
+		stateData.Type = d
+	}
+	b, err := proto.Marshal(stateData)
+	require.NoError(t, err)
+	return b
+}
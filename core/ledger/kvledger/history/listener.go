@@ -0,0 +1,58 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/rwsetutil"
+	"github.com/pkg/errors"
+)
+
+// RegisterCommitListener registers a listener that is notified of the blocks committed, after the registration, to the
+// historydbs obtained from this provider. The listener is not notified of the blocks committed by a rebuild of the
+// history, of the index batches applied from a replication stream, or of the entries imported from a snapshot or
+// backfilled from an archive. The listeners are expected to be registered before the historydbs are used.
+func (p *DBProvider) RegisterCommitListener(listener ledger.HistoryCommitListener) error {
+	name := listener.Name()
+	if name == "" {
+		return errors.New("invalid history commit listener name, the name cannot be empty")
+	}
+	for _, l := range p.commitListeners {
+		if l.Name() == name {
+			return errors.Errorf("history commit listener [%s] is already registered", name)
+		}
+	}
+	p.commitListeners = append(p.commitListeners, listener)
+	return nil
+}
+
+// newHistoryTxWrites returns the writes of a transaction, as passed to the commit listeners
+func newHistoryTxWrites(tranNo uint64, chdr *common.ChannelHeader, txRWSet *rwsetutil.TxRwSet) *ledger.HistoryTxWrites {
+	txWrites := &ledger.HistoryTxWrites{
+		TranNum:   tranNo,
+		TxID:      chdr.TxId,
+		Timestamp: chdr.Timestamp.AsTime(),
+	}
+	for _, nsRWSet := range txRWSet.NsRwSets {
+		for _, kvWrite := range nsRWSet.KvRwSet.Writes {
+			txWrites.Writes = append(txWrites.Writes, &ledger.HistoryWrite{Namespace: nsRWSet.NameSpace, Write: kvWrite})
+		}
+	}
+	return txWrites
+}
+
+// notifyCommitListeners invokes the commit listeners, in the order of the registration, with the writes of a block
+func (d *DB) notifyCommitListeners(commit *ledger.HistoryCommit) error {
+	for _, l := range d.commitListeners {
+		if err := l.HandleHistoryCommit(commit); err != nil {
+			return errors.WithMessagef(err, "error while notifying history commit listener [%s] of block [%d]",
+				l.Name(), commit.BlockNum)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/hyperledger/fabric/common/ledger/testutil"
+	util2 "github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/hyperledger/fabric/internal/pkg/txflags"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+type testCommitListener struct {
+	name    string
+	commits []*ledger.HistoryCommit
+	err     error
+}
+
+func (l *testCommitListener) Name() string {
+	return l.name
+}
+
+func (l *testCommitListener) HandleHistoryCommit(commit *ledger.HistoryCommit) error {
+	if l.err != nil {
+		return l.err
+	}
+	l.commits = append(l.commits, commit)
+	return nil
+}
+
+func TestCommitListener(t *testing.T) {
+	env := newTestHistoryEnv(t)
+	defer env.cleanup()
+	provider := env.testHistoryDBProvider
+	require.EqualError(t, provider.RegisterCommitListener(&testCommitListener{}),
+		"invalid history commit listener name, the name cannot be empty")
+	listener := &testCommitListener{name: "listener1"}
+	require.NoError(t, provider.RegisterCommitListener(listener))
+	require.EqualError(t, provider.RegisterCommitListener(&testCommitListener{name: "listener1"}),
+		"history commit listener [listener1] is already registered")
+	require.NoError(t, provider.DisableIndexing([]string{"ns2"}))
+
+	historydb := provider.GetDBHandle("ledger1")
+	bg, gb := testutil.NewBlockGenerator(t, "ledger1", false)
+	require.NoError(t, historydb.Commit(gb))
+	require.Equal(t, []*ledger.HistoryCommit{{LedgerID: "ledger1", BlockNum: 0}}, listener.commits)
+
+	var txs [][]byte
+	var txIDs []string
+	for _, value := range []string{"value1", "value2"} {
+		txID := util2.GenerateUUID()
+		simulator, err := env.txmgr.NewTxSimulator(txID)
+		require.NoError(t, err)
+		require.NoError(t, simulator.SetState("ns1", "key1", []byte(value)))
+		require.NoError(t, simulator.DeleteState("ns2", "key2"))
+		simulator.Done()
+		simRes, err := simulator.GetTxSimulationResults()
+		require.NoError(t, err)
+		pubSimResBytes, err := simRes.GetPubSimulationBytes()
+		require.NoError(t, err)
+		txs = append(txs, pubSimResBytes, pubSimResBytes)
+		txIDs = append(txIDs, txID, txID)
+	}
+	block1 := bg.NextBlockWithTxid(txs, txIDs)
+	// the second of the duplicate transactions is invalid
+	txsFilter := txflags.ValidationFlags(block1.Metadata.Metadata[common.BlockMetadataIndex_TRANSACTIONS_FILTER])
+	txsFilter.SetFlag(1, peer.TxValidationCode_DUPLICATE_TXID)
+	txsFilter.SetFlag(3, peer.TxValidationCode_DUPLICATE_TXID)
+	block1.Metadata.Metadata[common.BlockMetadataIndex_TRANSACTIONS_FILTER] = txsFilter
+	require.NoError(t, historydb.Commit(block1))
+
+	require.Len(t, listener.commits, 2)
+	commit := listener.commits[1]
+	require.Equal(t, "ledger1", commit.LedgerID)
+	require.Equal(t, uint64(1), commit.BlockNum)
+	require.Len(t, commit.Txs, 2)
+	for i, tx := range commit.Txs {
+		require.Equal(t, uint64(2*i), tx.TranNum)
+		require.Equal(t, txIDs[2*i], tx.TxID)
+		require.False(t, tx.Timestamp.IsZero())
+		// the writes to the namespaces that are not indexed are included
+		require.Len(t, tx.Writes, 2)
+		require.Equal(t, "ns1", tx.Writes[0].Namespace)
+		require.Equal(t, "key1", tx.Writes[0].Write.Key)
+		require.Equal(t, []byte([]string{"value1", "value2"}[i]), tx.Writes[0].Write.Value)
+		require.Equal(t, "ns2", tx.Writes[1].Namespace)
+		require.True(t, tx.Writes[1].Write.IsDelete)
+	}
+
+	// an error returned by a listener fails the commit and the block is not indexed
+	listener.err = errors.New("listener error")
+	err := historydb.Commit(bg.NextBlock(txs[:1]))
+	require.EqualError(t, err, "error while notifying history commit listener [listener1] of block [2]: listener error")
+	savepoint, err := historydb.GetLastSavepoint()
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), savepoint.BlockNum)
+}
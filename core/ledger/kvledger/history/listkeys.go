@@ -0,0 +1,111 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"github.com/pkg/errors"
+)
+
+// IndexedKeys is a page of the keys that have history in a namespace (see function `ListIndexedKeys`)
+type IndexedKeys struct {
+	Keys []string
+	// HasMore is true if there are more keys after the last key in the page
+	HasMore bool
+	// NextPageToken continues the listing after the page, if HasMore is true. It is set only by function
+	// `ListIndexedKeysPage`
+	NextPageToken string
+	// EstimatedTotal is the number of the keys with history in the namespace, as maintained by the index statistics.
+	// For a history index populated by a peer version that did not maintain the statistics, it undercounts the keys
+	// until the history is rebuilt
+	EstimatedTotal uint64
+}
+
+// ListIndexedKeys returns up to limit keys that have history in the namespace, following the key startAfter, so
+// that the keys can be discovered without knowing them in advance. The keys are listed in the order of the history
+// index, which is the order of the length of the keys and, for the keys of the same length, the lexicographic order.
+// An empty startAfter lists from the first key. A page is continued by passing the last key of the previous page as
+// startAfter. The index is read for one entry per key, skipping over the rest of the entries of the key. A page is
+// truncated at the response caps, if enabled (see function `EnableResponseCaps`), with HasMore set.
+func (d *DB) ListIndexedKeys(ns, startAfter string, limit int) (*IndexedKeys, error) {
+	if ns == "" {
+		return nil, errors.New("namespace is required for listing the indexed keys")
+	}
+	if limit <= 0 {
+		return nil, errors.Errorf("invalid limit [%d] for listing the indexed keys, must be positive", limit)
+	}
+	stats, err := d.GetIndexStats(ns)
+	if err != nil {
+		return nil, err
+	}
+	result := &IndexedKeys{EstimatedTotal: stats.DistinctKeys}
+
+	startKey, endKey := scanRange(ns, "")
+	if startAfter != "" {
+		startKey = constructRangeScan(ns, d.normalizeKey(ns, startAfter)).endKey
+	}
+	itr, err := d.levelDB.GetIterator(startKey, endKey)
+	if err != nil {
+		return nil, err
+	}
+	defer itr.Release()
+	counter := d.responseCaps.newCounter()
+	for valid := itr.Next(); valid; {
+		_, key, _, _, err := decodeDataKey(itr.Key())
+		if err != nil {
+			return nil, err
+		}
+		if len(result.Keys) == limit || !counter.add(len(key)) {
+			result.HasMore = true
+			break
+		}
+		result.Keys = append(result.Keys, key)
+		// skip the remaining entries of the key
+		valid = itr.Seek(constructRangeScan(ns, key).endKey)
+	}
+	if err := itr.Error(); err != nil {
+		return nil, errors.Wrap(err, "internal leveldb error while iterating for history entries")
+	}
+	return result, nil
+}
+
+// ListIndexedKeysPage lists the keys that have history in the namespace like function `ListIndexedKeys`, except
+// that a page is continued by passing the NextPageToken of the previous page rather than a key. The tokens are sealed
+// with a key of the peer and bound to the ledger and the namespace, so that a client can neither forge a position nor
+// pass a token to a different listing. An empty token lists from the first key. A token issued before the history was
+// rolled back, reset or rebuilt fails with a StalePageTokenError and an altered or foreign token fails with an
+// InvalidPageTokenError
+func (d *DB) ListIndexedKeysPage(ns, token string, limit int) (*IndexedKeys, error) {
+	height, err := d.IndexedHeight()
+	if err != nil {
+		return nil, err
+	}
+	queryHash := pageQueryHash(d.name, "ListIndexedKeys", ns)
+	startAfter := ""
+	if token != "" {
+		t, err := openPageToken(d.provider.pageTokenKey, token, queryHash)
+		if err != nil {
+			return nil, err
+		}
+		if t.height > height {
+			return nil, &StalePageTokenError{TokenHeight: t.height, IndexedHeight: height}
+		}
+		startAfter = string(t.position)
+	}
+	result, err := d.ListIndexedKeys(ns, startAfter, limit)
+	if err != nil {
+		return nil, err
+	}
+	if result.HasMore {
+		next := &pageToken{
+			position:  []byte(result.Keys[len(result.Keys)-1]),
+			height:    height,
+			queryHash: queryHash,
+		}
+		result.NextPageToken = next.seal(d.provider.pageTokenKey)
+	}
+	return result, nil
+}
@@ -0,0 +1,75 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric/common/ledger/testutil"
+	util2 "github.com/hyperledger/fabric/common/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListIndexedKeys(t *testing.T) {
+	env := newTestHistoryEnv(t)
+	defer env.cleanup()
+	store, err := env.testBlockStorageEnv.provider.Open("ledger1")
+	require.NoError(t, err)
+	defer store.Shutdown()
+
+	historydb := env.testHistoryDBProvider.GetDBHandle("ledger1")
+	bg, gb := testutil.NewBlockGenerator(t, "ledger1", false)
+	commit := func(block *common.Block) {
+		require.NoError(t, store.AddBlock(block))
+		require.NoError(t, historydb.Commit(block))
+	}
+	commit(gb)
+	for i := 0; i < 2; i++ {
+		simulator, err := env.txmgr.NewTxSimulator(util2.GenerateUUID())
+		require.NoError(t, err)
+		for _, key := range []string{"key2", "key10", "key1", "k"} {
+			require.NoError(t, simulator.SetState("ns1", key, []byte("value")))
+		}
+		require.NoError(t, simulator.SetState("ns2", "other", []byte("value")))
+		simulator.Done()
+		simRes, err := simulator.GetTxSimulationResults()
+		require.NoError(t, err)
+		pubSimResBytes, err := simRes.GetPubSimulationBytes()
+		require.NoError(t, err)
+		commit(bg.NextBlock([][]byte{pubSimResBytes}))
+	}
+
+	// the keys are listed in the order of their length and then, lexicographically
+	keys, err := historydb.ListIndexedKeys("ns1", "", 10)
+	require.NoError(t, err)
+	require.Equal(t, &IndexedKeys{
+		Keys:           []string{"k", "key1", "key2", "key10"},
+		EstimatedTotal: 4,
+	}, keys)
+
+	keys, err = historydb.ListIndexedKeys("ns1", "", 2)
+	require.NoError(t, err)
+	require.Equal(t, []string{"k", "key1"}, keys.Keys)
+	require.True(t, keys.HasMore)
+	keys, err = historydb.ListIndexedKeys("ns1", "key1", 2)
+	require.NoError(t, err)
+	require.Equal(t, []string{"key2", "key10"}, keys.Keys)
+	require.False(t, keys.HasMore)
+	keys, err = historydb.ListIndexedKeys("ns1", "key10", 2)
+	require.NoError(t, err)
+	require.Empty(t, keys.Keys)
+
+	keys, err = historydb.ListIndexedKeys("ns3", "", 2)
+	require.NoError(t, err)
+	require.Equal(t, &IndexedKeys{}, keys)
+
+	_, err = historydb.ListIndexedKeys("", "", 2)
+	require.EqualError(t, err, "namespace is required for listing the indexed keys")
+	_, err = historydb.ListIndexedKeys("ns1", "", 0)
+	require.EqualError(t, err, "invalid limit [0] for listing the indexed keys, must be positive")
+}
@@ -0,0 +1,353 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/common/metrics"
+)
+
+type stats struct {
+	keysIndexed       metrics.Histogram
+	indexBatchSize    metrics.Histogram
+	commitTime        metrics.Histogram
+	savepointHeight   metrics.Gauge
+	cacheHits         metrics.Counter
+	cacheMisses       metrics.Counter
+	cacheEvictions    metrics.Counter
+	cacheEntries      metrics.Gauge
+	cacheBytes        metrics.Gauge
+	openScanners      metrics.Gauge
+	reclaimedBytes    metrics.Counter
+	compactionTime    metrics.Histogram
+	distinctKeys      metrics.Gauge
+	levelSize         metrics.Gauge
+	pendingCompaction metrics.Gauge
+	shadowReads       metrics.Counter
+	divergences       metrics.Counter
+	migrationFailures metrics.Counter
+	entriesScanned    metrics.Counter
+	bytesReturned     metrics.Counter
+	quotaRejections   metrics.Counter
+}
+
+func newStats(metricsProvider metrics.Provider) *stats {
+	stats := &stats{}
+	stats.keysIndexed = metricsProvider.NewHistogram(keysIndexedOpts)
+	stats.indexBatchSize = metricsProvider.NewHistogram(indexBatchSizeOpts)
+	stats.commitTime = metricsProvider.NewHistogram(commitTimeOpts)
+	stats.savepointHeight = metricsProvider.NewGauge(savepointHeightOpts)
+	stats.cacheHits = metricsProvider.NewCounter(cacheHitsOpts)
+	stats.cacheMisses = metricsProvider.NewCounter(cacheMissesOpts)
+	stats.cacheEvictions = metricsProvider.NewCounter(cacheEvictionsOpts)
+	stats.cacheEntries = metricsProvider.NewGauge(cacheEntriesOpts)
+	stats.cacheBytes = metricsProvider.NewGauge(cacheBytesOpts)
+	stats.openScanners = metricsProvider.NewGauge(openScannersOpts)
+	stats.reclaimedBytes = metricsProvider.NewCounter(reclaimedBytesOpts)
+	stats.compactionTime = metricsProvider.NewHistogram(compactionTimeOpts)
+	stats.distinctKeys = metricsProvider.NewGauge(distinctKeysOpts)
+	stats.levelSize = metricsProvider.NewGauge(levelSizeOpts)
+	stats.pendingCompaction = metricsProvider.NewGauge(pendingCompactionOpts)
+	stats.shadowReads = metricsProvider.NewCounter(shadowReadsOpts)
+	stats.divergences = metricsProvider.NewCounter(divergencesOpts)
+	stats.migrationFailures = metricsProvider.NewCounter(migrationFailuresOpts)
+	stats.entriesScanned = metricsProvider.NewCounter(entriesScannedOpts)
+	stats.bytesReturned = metricsProvider.NewCounter(bytesReturnedOpts)
+	stats.quotaRejections = metricsProvider.NewCounter(quotaRejectionsOpts)
+	return stats
+}
+
+type ledgerStats struct {
+	stats    *stats
+	ledgerid string
+}
+
+func (s *stats) ledgerStats(ledgerid string) *ledgerStats {
+	return &ledgerStats{
+		s, ledgerid,
+	}
+}
+
+func (s *ledgerStats) updateKeysIndexed(numKeys int) {
+	s.stats.keysIndexed.With("channel", s.ledgerid).Observe(float64(numKeys))
+}
+
+func (s *ledgerStats) updateIndexBatchSize(sizeBytes int) {
+	s.stats.indexBatchSize.With("channel", s.ledgerid).Observe(float64(sizeBytes))
+}
+
+func (s *ledgerStats) updateCommitTime(timeTaken time.Duration) {
+	s.stats.commitTime.With("channel", s.ledgerid).Observe(timeTaken.Seconds())
+}
+
+func (s *ledgerStats) updateSavepointHeight(height uint64) {
+	s.stats.savepointHeight.With("channel", s.ledgerid).Set(float64(height))
+}
+
+// the names of the caches, as reported in the label "cache" of the cache metrics
+const (
+	decodedTxCacheName   = "decoded_tx"
+	noHistoryCacheName   = "no_history"
+	queryResultCacheName = "query_result"
+)
+
+func (s *ledgerStats) cacheHit(cacheName string) {
+	s.stats.cacheHits.With("channel", s.ledgerid, "cache", cacheName).Add(1)
+}
+
+func (s *ledgerStats) cacheMiss(cacheName string) {
+	s.stats.cacheMisses.With("channel", s.ledgerid, "cache", cacheName).Add(1)
+}
+
+func (s *ledgerStats) cacheEviction(cacheName string) {
+	s.stats.cacheEvictions.With("channel", s.ledgerid, "cache", cacheName).Add(1)
+}
+
+func (s *ledgerStats) updateCacheSize(cacheName string, numEntries, numBytes int) {
+	s.stats.cacheEntries.With("channel", s.ledgerid, "cache", cacheName).Set(float64(numEntries))
+	if cacheName == decodedTxCacheName {
+		s.stats.cacheBytes.With("channel", s.ledgerid, "cache", cacheName).Set(float64(numBytes))
+	}
+}
+
+func (s *ledgerStats) updateOpenScanners(numOpen int) {
+	s.stats.openScanners.With("channel", s.ledgerid).Set(float64(numOpen))
+}
+
+func (s *ledgerStats) updateCompaction(reclaimedBytes int64, timeTaken time.Duration) {
+	s.stats.reclaimedBytes.With("channel", s.ledgerid).Add(float64(reclaimedBytes))
+	s.stats.compactionTime.With("channel", s.ledgerid).Observe(timeTaken.Seconds())
+}
+
+func (s *stats) updateLevelSizes(levelSizes []int64) {
+	for level, size := range levelSizes {
+		s.levelSize.With("level", strconv.Itoa(level)).Set(float64(size))
+	}
+}
+
+func (s *stats) updatePendingCompactionBytes(pendingBytes int64) {
+	s.pendingCompaction.Set(float64(pendingBytes))
+}
+
+func (s *ledgerStats) updateDistinctKeys(ns string, distinctKeys uint64) {
+	s.stats.distinctKeys.With("channel", s.ledgerid, "namespace", ns).Set(float64(distinctKeys))
+}
+
+func (s *ledgerStats) shadowRead(diverged bool) {
+	s.stats.shadowReads.With("channel", s.ledgerid).Add(1)
+	if diverged {
+		s.stats.divergences.With("channel", s.ledgerid).Add(1)
+	}
+}
+
+func (s *ledgerStats) migrationCommitFailure() {
+	s.stats.migrationFailures.With("channel", s.ledgerid).Add(1)
+}
+
+func (s *ledgerStats) queryUsage(client string, entries, bytes uint64) {
+	s.stats.entriesScanned.With("channel", s.ledgerid, "client", client).Add(float64(entries))
+	s.stats.bytesReturned.With("channel", s.ledgerid, "client", client).Add(float64(bytes))
+}
+
+func (s *ledgerStats) quotaRejection(client, resource string) {
+	s.stats.quotaRejections.With("channel", s.ledgerid, "client", client, "resource", resource).Add(1)
+}
+
+var (
+	keysIndexedOpts = metrics.HistogramOpts{
+		Namespace:    "ledger",
+		Subsystem:    "history",
+		Name:         "keys_indexed",
+		Help:         "Number of key writes indexed in the history database per block.",
+		LabelNames:   []string{"channel"},
+		StatsdFormat: "%{#fqname}.%{channel}",
+		Buckets:      []float64{0, 10, 100, 1000, 10000, 100000},
+	}
+
+	indexBatchSizeOpts = metrics.HistogramOpts{
+		Namespace:    "ledger",
+		Subsystem:    "history",
+		Name:         "index_batch_size",
+		Help:         "Size in bytes of the batches written to the history database.",
+		LabelNames:   []string{"channel"},
+		StatsdFormat: "%{#fqname}.%{channel}",
+		Buckets:      []float64{1024, 10240, 102400, 1048576, 10485760, 104857600},
+	}
+
+	commitTimeOpts = metrics.HistogramOpts{
+		Namespace:    "ledger",
+		Subsystem:    "history",
+		Name:         "commit_time",
+		Help:         "Time taken in seconds for committing a block to the history database.",
+		LabelNames:   []string{"channel"},
+		StatsdFormat: "%{#fqname}.%{channel}",
+		Buckets:      []float64{0.005, 0.01, 0.015, 0.05, 0.1, 1, 10},
+	}
+
+	savepointHeightOpts = metrics.GaugeOpts{
+		Namespace:    "ledger",
+		Subsystem:    "history",
+		Name:         "savepoint_height",
+		Help:         "Height of the blocks written to the history database, as recorded by its savepoint.",
+		LabelNames:   []string{"channel"},
+		StatsdFormat: "%{#fqname}.%{channel}",
+	}
+
+	cacheHitsOpts = metrics.CounterOpts{
+		Namespace:    "ledger",
+		Subsystem:    "history",
+		Name:         "cache_hits",
+		Help:         "Number of lookups served by a history query cache.",
+		LabelNames:   []string{"channel", "cache"},
+		StatsdFormat: "%{#fqname}.%{channel}.%{cache}",
+	}
+
+	cacheMissesOpts = metrics.CounterOpts{
+		Namespace:    "ledger",
+		Subsystem:    "history",
+		Name:         "cache_misses",
+		Help:         "Number of lookups not served by a history query cache.",
+		LabelNames:   []string{"channel", "cache"},
+		StatsdFormat: "%{#fqname}.%{channel}.%{cache}",
+	}
+
+	cacheEvictionsOpts = metrics.CounterOpts{
+		Namespace:    "ledger",
+		Subsystem:    "history",
+		Name:         "cache_evictions",
+		Help:         "Number of entries evicted from a history query cache to stay within its limits.",
+		LabelNames:   []string{"channel", "cache"},
+		StatsdFormat: "%{#fqname}.%{channel}.%{cache}",
+	}
+
+	cacheEntriesOpts = metrics.GaugeOpts{
+		Namespace:    "ledger",
+		Subsystem:    "history",
+		Name:         "cache_entries",
+		Help:         "Number of entries held by a history query cache.",
+		LabelNames:   []string{"channel", "cache"},
+		StatsdFormat: "%{#fqname}.%{channel}.%{cache}",
+	}
+
+	cacheBytesOpts = metrics.GaugeOpts{
+		Namespace:    "ledger",
+		Subsystem:    "history",
+		Name:         "cache_bytes",
+		Help:         "Size in bytes of the read-write sets held by the decoded transaction cache.",
+		LabelNames:   []string{"channel", "cache"},
+		StatsdFormat: "%{#fqname}.%{channel}.%{cache}",
+	}
+
+	openScannersOpts = metrics.GaugeOpts{
+		Namespace:    "ledger",
+		Subsystem:    "history",
+		Name:         "open_scanners",
+		Help:         "Number of history query iterators that are open, i.e., returned to the clients and not yet closed.",
+		LabelNames:   []string{"channel"},
+		StatsdFormat: "%{#fqname}.%{channel}",
+	}
+
+	reclaimedBytesOpts = metrics.CounterOpts{
+		Namespace:    "ledger",
+		Subsystem:    "history",
+		Name:         "compaction_reclaimed_bytes",
+		Help:         "Approximate disk space in bytes reclaimed by the compactions of the history database.",
+		LabelNames:   []string{"channel"},
+		StatsdFormat: "%{#fqname}.%{channel}",
+	}
+
+	compactionTimeOpts = metrics.HistogramOpts{
+		Namespace:    "ledger",
+		Subsystem:    "history",
+		Name:         "compaction_time",
+		Help:         "Time taken in seconds for a compaction of the history of a channel.",
+		LabelNames:   []string{"channel"},
+		StatsdFormat: "%{#fqname}.%{channel}",
+		Buckets:      []float64{0.1, 1, 10, 60, 600, 3600},
+	}
+
+	distinctKeysOpts = metrics.GaugeOpts{
+		Namespace:    "ledger",
+		Subsystem:    "history",
+		Name:         "distinct_keys",
+		Help:         "Number of distinct keys with history in a namespace, as maintained by the index statistics.",
+		LabelNames:   []string{"channel", "namespace"},
+		StatsdFormat: "%{#fqname}.%{channel}.%{namespace}",
+	}
+
+	levelSizeOpts = metrics.GaugeOpts{
+		Namespace:    "ledger",
+		Subsystem:    "history",
+		Name:         "level_size",
+		Help:         "Size in bytes, on the disk, of the tables at a level of the leveldb of the history database.",
+		LabelNames:   []string{"level"},
+		StatsdFormat: "%{#fqname}.%{level}",
+	}
+
+	pendingCompactionOpts = metrics.GaugeOpts{
+		Namespace: "ledger",
+		Subsystem: "history",
+		Name:      "compaction_pending_bytes",
+		Help:      "Approximate disk space in bytes held by the dropped history pending the scheduled compaction.",
+	}
+
+	shadowReadsOpts = metrics.CounterOpts{
+		Namespace:    "ledger",
+		Subsystem:    "history",
+		Name:         "migration_shadow_reads",
+		Help:         "Number of history queries sampled for comparing the history database with the migration target.",
+		LabelNames:   []string{"channel"},
+		StatsdFormat: "%{#fqname}.%{channel}",
+	}
+
+	divergencesOpts = metrics.CounterOpts{
+		Namespace:    "ledger",
+		Subsystem:    "history",
+		Name:         "migration_divergences",
+		Help:         "Number of sampled history queries whose results from the migration target differ from those of the history database.",
+		LabelNames:   []string{"channel"},
+		StatsdFormat: "%{#fqname}.%{channel}",
+	}
+
+	migrationFailuresOpts = metrics.CounterOpts{
+		Namespace:    "ledger",
+		Subsystem:    "history",
+		Name:         "migration_commit_failures",
+		Help:         "Number of blocks that could not be committed to the migration target of the history database.",
+		LabelNames:   []string{"channel"},
+		StatsdFormat: "%{#fqname}.%{channel}",
+	}
+
+	entriesScannedOpts = metrics.CounterOpts{
+		Namespace:    "ledger",
+		Subsystem:    "history",
+		Name:         "client_entries_scanned",
+		Help:         "Number of history entries scanned by the history queries of a client, as accounted to the quotas of the client.",
+		LabelNames:   []string{"channel", "client"},
+		StatsdFormat: "%{#fqname}.%{channel}.%{client}",
+	}
+
+	bytesReturnedOpts = metrics.CounterOpts{
+		Namespace:    "ledger",
+		Subsystem:    "history",
+		Name:         "client_bytes_returned",
+		Help:         "Number of bytes of the values returned by the history queries of a client, as accounted to the quotas of the client.",
+		LabelNames:   []string{"channel", "client"},
+		StatsdFormat: "%{#fqname}.%{channel}.%{client}",
+	}
+
+	quotaRejectionsOpts = metrics.CounterOpts{
+		Namespace:    "ledger",
+		Subsystem:    "history",
+		Name:         "quota_rejections",
+		Help:         "Number of history queries of a client failed for exceeding a quota of the client.",
+		LabelNames:   []string{"channel", "client", "resource"},
+		StatsdFormat: "%{#fqname}.%{channel}.%{client}.%{resource}",
+	}
+)
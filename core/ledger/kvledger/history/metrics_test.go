@@ -0,0 +1,174 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/common/ledger/testutil"
+	"github.com/hyperledger/fabric/common/metrics"
+	"github.com/hyperledger/fabric/common/metrics/metricsfakes"
+	util2 "github.com/hyperledger/fabric/common/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatsHistoryCommit(t *testing.T) {
+	env := newTestHistoryEnv(t)
+	defer env.cleanup()
+	store, err := env.testBlockStorageEnv.provider.Open("ledger1")
+	require.NoError(t, err)
+	defer store.Shutdown()
+
+	fakeProvider := &metricsfakes.Provider{}
+	hists := map[string]*metricsfakes.Histogram{}
+	fakeProvider.NewHistogramStub = func(opts metrics.HistogramOpts) metrics.Histogram {
+		fakeHist := &metricsfakes.Histogram{}
+		fakeHist.WithStub = func(lableValues ...string) metrics.Histogram {
+			return fakeHist
+		}
+		hists[opts.Name] = fakeHist
+		return fakeHist
+	}
+	gauges := map[string]*metricsfakes.Gauge{}
+	fakeProvider.NewGaugeStub = func(opts metrics.GaugeOpts) metrics.Gauge {
+		fakeGauge := &metricsfakes.Gauge{}
+		fakeGauge.WithStub = func(lableValues ...string) metrics.Gauge {
+			return fakeGauge
+		}
+		gauges[opts.Name] = fakeGauge
+		return fakeGauge
+	}
+	env.testHistoryDBProvider.EnableMetrics(fakeProvider)
+	historydb := env.testHistoryDBProvider.GetDBHandle("ledger1")
+
+	bg, gb := testutil.NewBlockGenerator(t, "ledger1", false)
+	require.NoError(t, store.AddBlock(gb))
+	require.NoError(t, historydb.Commit(gb))
+	simulator, err := env.txmgr.NewTxSimulator(util2.GenerateUUID())
+	require.NoError(t, err)
+	require.NoError(t, simulator.SetState("ns1", "key1", []byte("value1")))
+	require.NoError(t, simulator.SetState("ns1", "key2", []byte("value2")))
+	simulator.Done()
+	simRes, err := simulator.GetTxSimulationResults()
+	require.NoError(t, err)
+	pubSimResBytes, err := simRes.GetPubSimulationBytes()
+	require.NoError(t, err)
+	block1 := bg.NextBlock([][]byte{pubSimResBytes})
+	require.NoError(t, store.AddBlock(block1))
+	require.NoError(t, historydb.Commit(block1))
+
+	fakeSavepointGauge := gauges[savepointHeightOpts.Name]
+	keysIndexed := hists[keysIndexedOpts.Name]
+	require.Equal(t, 2, keysIndexed.ObserveCallCount())
+	require.Equal(t, []string{"channel", "ledger1"}, keysIndexed.WithArgsForCall(1))
+	require.Equal(t, float64(0), keysIndexed.ObserveArgsForCall(0))
+	require.Equal(t, float64(2), keysIndexed.ObserveArgsForCall(1))
+	require.Equal(t, 2, hists[commitTimeOpts.Name].ObserveCallCount())
+	require.Equal(t, 2, hists[indexBatchSizeOpts.Name].ObserveCallCount())
+	require.Greater(t, hists[indexBatchSizeOpts.Name].ObserveArgsForCall(1), hists[indexBatchSizeOpts.Name].ObserveArgsForCall(0))
+	require.Equal(t, 2, fakeSavepointGauge.SetCallCount())
+	require.Equal(t, []string{"channel", "ledger1"}, fakeSavepointGauge.WithArgsForCall(1))
+	require.Equal(t, float64(2), fakeSavepointGauge.SetArgsForCall(1))
+	distinctKeys := gauges[distinctKeysOpts.Name]
+	require.Equal(t, 1, distinctKeys.SetCallCount())
+	require.Equal(t, []string{"channel", "ledger1", "namespace", "ns1"}, distinctKeys.WithArgsForCall(0))
+	require.Equal(t, float64(2), distinctKeys.SetArgsForCall(0))
+
+	// with the group commit, the batch size and the savepoint height are reported upon the flush
+	historydb.groupCommit = newGroupCommit(&groupCommitConfig{maxBlocks: 10, flushInterval: time.Hour})
+	historydb.groupCommit.lastCommit = time.Now()
+	block2 := bg.NextBlock([][]byte{pubSimResBytes})
+	require.NoError(t, store.AddBlock(block2))
+	require.NoError(t, historydb.Commit(block2))
+	require.Equal(t, 3, keysIndexed.ObserveCallCount())
+	require.Equal(t, 2, hists[indexBatchSizeOpts.Name].ObserveCallCount())
+	require.NoError(t, historydb.Flush())
+	require.Equal(t, 3, hists[indexBatchSizeOpts.Name].ObserveCallCount())
+	require.Equal(t, float64(3), fakeSavepointGauge.SetArgsForCall(2))
+	// rewriting the same keys leaves the distinct keys unchanged
+	require.Equal(t, 2, distinctKeys.SetCallCount())
+	require.Equal(t, float64(2), distinctKeys.SetArgsForCall(1))
+}
+
+func TestStatsHistoryCaches(t *testing.T) {
+	env := newTestHistoryEnv(t)
+	defer env.cleanup()
+	store, err := env.testBlockStorageEnv.provider.Open("ledger1")
+	require.NoError(t, err)
+	defer store.Shutdown()
+
+	fakeProvider := &metricsfakes.Provider{}
+	counters := map[string]*metricsfakes.Counter{}
+	fakeProvider.NewCounterStub = func(opts metrics.CounterOpts) metrics.Counter {
+		fakeCounter := &metricsfakes.Counter{}
+		fakeCounter.WithStub = func(lableValues ...string) metrics.Counter {
+			return fakeCounter
+		}
+		counters[opts.Name] = fakeCounter
+		return fakeCounter
+	}
+	gauges := map[string]*metricsfakes.Gauge{}
+	fakeProvider.NewGaugeStub = func(opts metrics.GaugeOpts) metrics.Gauge {
+		fakeGauge := &metricsfakes.Gauge{}
+		fakeGauge.WithStub = func(lableValues ...string) metrics.Gauge {
+			return fakeGauge
+		}
+		gauges[opts.Name] = fakeGauge
+		return fakeGauge
+	}
+	fakeHist := &metricsfakes.Histogram{}
+	fakeHist.WithReturns(fakeHist)
+	fakeProvider.NewHistogramReturns(fakeHist)
+	env.testHistoryDBProvider.EnableMetrics(fakeProvider)
+	env.testHistoryDBProvider.EnableDecodedTxCache(1, 0)
+	env.testHistoryDBProvider.EnableNoHistoryCache(1)
+	historydb := env.testHistoryDBProvider.GetDBHandle("ledger1")
+
+	bg, gb := testutil.NewBlockGenerator(t, "ledger1", false)
+	require.NoError(t, store.AddBlock(gb))
+	require.NoError(t, historydb.Commit(gb))
+	simulator, err := env.txmgr.NewTxSimulator(util2.GenerateUUID())
+	require.NoError(t, err)
+	require.NoError(t, simulator.SetState("ns1", "key1", []byte("value1")))
+	require.NoError(t, simulator.SetState("ns1", "key2", []byte("value2")))
+	simulator.Done()
+	simRes, err := simulator.GetTxSimulationResults()
+	require.NoError(t, err)
+	pubSimResBytes, err := simRes.GetPubSimulationBytes()
+	require.NoError(t, err)
+	block1 := bg.NextBlock([][]byte{pubSimResBytes})
+	require.NoError(t, store.AddBlock(block1))
+	require.NoError(t, historydb.Commit(block1))
+
+	qe, err := historydb.NewQueryExecutor(store)
+	require.NoError(t, err)
+	// the transaction retrieved for key1 is served from the cache for key2
+	testutilVerifyResults(t, qe, "ns1", "key1", []string{"value1"})
+	testutilVerifyResults(t, qe, "ns1", "key2", []string{"value2"})
+	require.Equal(t, 1, counters[cacheHitsOpts.Name].AddCallCount())
+	require.Equal(t, []string{"channel", "ledger1", "cache", decodedTxCacheName}, counters[cacheHitsOpts.Name].WithArgsForCall(0))
+	cacheBytes := gauges[cacheBytesOpts.Name]
+	require.Equal(t, 1, cacheBytes.SetCallCount())
+	require.Equal(t, float64(historydb.txCache.numBytes), cacheBytes.SetArgsForCall(0))
+
+	// a key without history is a miss for the no history cache first, and a hit afterwards
+	testutilVerifyResults(t, qe, "ns1", "key3", []string{})
+	testutilVerifyResults(t, qe, "ns1", "key3", []string{})
+	require.Equal(t, 2, counters[cacheHitsOpts.Name].AddCallCount())
+	require.Equal(t, []string{"channel", "ledger1", "cache", noHistoryCacheName}, counters[cacheHitsOpts.Name].WithArgsForCall(1))
+
+	// the no history cache holds a single key
+	testutilVerifyResults(t, qe, "ns1", "key4", []string{})
+	require.Equal(t, 1, counters[cacheEvictionsOpts.Name].AddCallCount())
+	require.Equal(t, []string{"channel", "ledger1", "cache", noHistoryCacheName}, counters[cacheEvictionsOpts.Name].WithArgsForCall(0))
+	cacheEntries := gauges[cacheEntriesOpts.Name]
+	require.Equal(t, float64(1), cacheEntries.SetArgsForCall(cacheEntries.SetCallCount()-1))
+
+	// one miss for the decoded transaction cache and four for the no history cache
+	require.Equal(t, 5, counters[cacheMissesOpts.Name].AddCallCount())
+}
@@ -0,0 +1,211 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/pkg/errors"
+)
+
+// EnableMigration enables the dual-write mode for migrating the history to the target provider, which holds the
+// history in a new format, for instance, with a different key normalization. Each block committed to the historydb
+// of a ledger is committed to the historydb of the ledger in the target as well, and a failure to commit to the target
+// is logged and counted without failing the commit. The historydb of the target is caught up via the recovery of
+// the ledger at start (see function `ShouldRecover`). In addition, the fraction shadowReadSampleRate of the history
+// queries are repeated in the background against both the historydbs and their results are compared, so that the
+// divergence of the target, if any, is reported (see function `MigrationStatus`) before the target is cut over. A
+// shadowReadSampleRate of 0 disables the shadow reads. The target is closed and dropped along with this provider.
+// Note that the target cannot be caught up for a ledger bootstrapped from a snapshot before the migration is enabled,
+// as the blocks prior to the snapshot are not available for the recovery.
+func (p *DBProvider) EnableMigration(target *DBProvider, shadowReadSampleRate float64) error {
+	if shadowReadSampleRate < 0 || shadowReadSampleRate > 1 {
+		return errors.Errorf("invalid shadow read sample rate [%g], expected a value between 0 and 1", shadowReadSampleRate)
+	}
+	p.migrationTarget = target
+	p.shadowReadSampleRate = shadowReadSampleRate
+	return nil
+}
+
+// MigrationStatus captures the progress of the migration of the history of a ledger to the migration target, as
+// enabled via function `EnableMigration`, since the ledger was opened
+type MigrationStatus struct {
+	// IndexedHeight and TargetIndexedHeight are the indexed heights of the historydb and of the migration target
+	IndexedHeight       uint64
+	TargetIndexedHeight uint64
+	// ShadowReads is the number of history queries compared and Divergences is the number of them whose results differ
+	ShadowReads uint64
+	Divergences uint64
+	// LastDivergence describes the most recent divergence, if any
+	LastDivergence string
+	// CommitFailures is the number of blocks that could not be committed to the migration target
+	CommitFailures uint64
+}
+
+// MigrationStatus returns the status of the migration of the history of this ledger to the migration target
+func (d *DB) MigrationStatus() (*MigrationStatus, error) {
+	m := d.migration
+	if m == nil {
+		return nil, errors.New("history migration not enabled")
+	}
+	indexedHeight, err := d.IndexedHeight()
+	if err != nil {
+		return nil, err
+	}
+	targetIndexedHeight, err := m.target.IndexedHeight()
+	if err != nil {
+		return nil, err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return &MigrationStatus{
+		IndexedHeight:       indexedHeight,
+		TargetIndexedHeight: targetIndexedHeight,
+		ShadowReads:         m.shadowReads,
+		Divergences:         m.divergences,
+		LastDivergence:      m.lastDivergence,
+		CommitFailures:      m.commitFailures,
+	}, nil
+}
+
+// migration dual-writes the history of a ledger to the migration target and compares the sampled queries
+type migration struct {
+	db         *DB
+	target     *DB
+	sampleRate float64
+	// shadowReadSlot bounds the shadow reads in progress to one per ledger, so that the shadow reads add a bounded
+	// load. A query sampled while a shadow read is in progress is not compared
+	shadowReadSlot chan struct{}
+	shadowReadsWG  sync.WaitGroup
+
+	lock           sync.Mutex
+	shadowReads    uint64
+	divergences    uint64
+	lastDivergence string
+	commitFailures uint64
+}
+
+func newMigration(db *DB, target *DB, sampleRate float64) *migration {
+	return &migration{
+		db:             db,
+		target:         target,
+		sampleRate:     sampleRate,
+		shadowReadSlot: make(chan struct{}, 1),
+	}
+}
+
+// commit commits the block to the migration target, if the block is the one expected next by the target. The blocks
+// already committed to the target are skipped, as during the recovery of the ledger
+func (m *migration) commit(block *common.Block) {
+	nextBlock, err := m.target.NextIndexBatchBlock()
+	if err == nil && block.Header.Number < nextBlock {
+		return
+	}
+	if err == nil && block.Header.Number > nextBlock {
+		err = errors.Errorf("the migration target expects block [%d] next", nextBlock)
+	}
+	if err == nil {
+		err = m.target.Commit(block)
+	}
+	if err != nil {
+		logger.Warnw("Error while committing block to the migration target of the history database",
+			"channel", m.db.name, "blockNum", block.Header.Number, "error", err)
+		m.lock.Lock()
+		m.commitFailures++
+		m.lock.Unlock()
+		m.db.stats.migrationCommitFailure()
+	}
+}
+
+// maybeShadowRead compares, in the background, the history of the key in the historydb with that in the migration
+// target, if the query is sampled
+func (m *migration) maybeShadowRead(ns, key string, txFetcher TxFetcher) {
+	if m.sampleRate == 0 || rand.Float64() >= m.sampleRate {
+		return
+	}
+	select {
+	case m.shadowReadSlot <- struct{}{}:
+	default:
+		return
+	}
+	m.shadowReadsWG.Add(1)
+	go func() {
+		defer func() {
+			<-m.shadowReadSlot
+			m.shadowReadsWG.Done()
+		}()
+		divergence, err := m.compare(ns, key, txFetcher)
+		if err != nil {
+			logger.Warnw("Error during the shadow read of the history migration", "channel", m.db.name,
+				"namespace", ns, "key", key, "error", err)
+			return
+		}
+		m.lock.Lock()
+		m.shadowReads++
+		if divergence != "" {
+			m.divergences++
+			m.lastDivergence = divergence
+		}
+		m.lock.Unlock()
+		m.db.stats.shadowRead(divergence != "")
+		if divergence != "" {
+			logger.Warnw("The migration target of the history database diverges", "channel", m.db.name,
+				"divergence", divergence)
+		}
+	}()
+}
+
+// compare returns the description of the difference, if any, between the history of the key in the historydb and
+// that in the migration target. Only the entries for the blocks indexed by both are compared
+func (m *migration) compare(ns, key string, txFetcher TxFetcher) (string, error) {
+	height, err := m.db.IndexedHeight()
+	if err != nil {
+		return "", err
+	}
+	targetHeight, err := m.target.IndexedHeight()
+	if err != nil {
+		return "", err
+	}
+	if targetHeight < height {
+		height = targetHeight
+	}
+	entries, err := scanUpTo(m.db, ns, key, height, txFetcher)
+	if err != nil {
+		return "", err
+	}
+	targetEntries, err := scanUpTo(m.target, ns, key, height, txFetcher)
+	if err != nil {
+		return "", err
+	}
+	for i := 0; i < len(entries) || i < len(targetEntries); i++ {
+		if i == len(entries) || i == len(targetEntries) {
+			return fmt.Sprintf("namespace [%s] key [%s] has [%d] entries up to height [%d], whereas the migration target has [%d] entries",
+				ns, key, len(entries), height, len(targetEntries)), nil
+		}
+		e, t := entries[i], targetEntries[i]
+		if e.BlockNum != t.BlockNum || e.TranNum != t.TranNum || !proto.Equal(e.KeyModification, t.KeyModification) {
+			return fmt.Sprintf("namespace [%s] key [%s] differs at entry [%d], block [%d] transaction [%d] whereas the migration target has block [%d] transaction [%d]",
+				ns, key, i, e.BlockNum, e.TranNum, t.BlockNum, t.TranNum), nil
+		}
+	}
+	return "", nil
+}
+
+func scanUpTo(db *DB, ns, key string, height uint64, txFetcher TxFetcher) ([]*Entry, error) {
+	var entries []*Entry
+	err := db.Scan(ns, key, txFetcher, func(e *Entry) error {
+		if e.BlockNum < height {
+			entries = append(entries, e)
+		}
+		return nil
+	})
+	return entries, err
+}
@@ -0,0 +1,109 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric/common/ledger/testutil"
+	util2 "github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigration(t *testing.T) {
+	env := newTestHistoryEnv(t)
+	defer env.cleanup()
+	store, err := env.testBlockStorageEnv.provider.Open("ledger1")
+	require.NoError(t, err)
+	defer store.Shutdown()
+
+	bg, gb := testutil.NewBlockGenerator(t, "ledger1", false)
+	nextBlock := func(i int) *common.Block {
+		simulator, err := env.txmgr.NewTxSimulator(util2.GenerateUUID())
+		require.NoError(t, err)
+		// the key is in the decomposed form, which the migration target normalizes to the composed form
+		require.NoError(t, simulator.SetState("ns1", "cafe\u0301", []byte{byte(i)}))
+		simulator.Done()
+		simRes, err := simulator.GetTxSimulationResults()
+		require.NoError(t, err)
+		pubSimResBytes, err := simRes.GetPubSimulationBytes()
+		require.NoError(t, err)
+		block := bg.NextBlock([][]byte{pubSimResBytes})
+		require.NoError(t, store.AddBlock(block))
+		return block
+	}
+	historydb := env.testHistoryDBProvider.GetDBHandle("ledger1")
+	require.NoError(t, store.AddBlock(gb))
+	require.NoError(t, historydb.Commit(gb))
+	blocks := []*common.Block{gb}
+	for i := 1; i <= 2; i++ {
+		blocks = append(blocks, nextBlock(i))
+		require.NoError(t, historydb.Commit(blocks[i]))
+	}
+	_, err = historydb.MigrationStatus()
+	require.EqualError(t, err, "history migration not enabled")
+
+	targetProvider, err := NewDBProvider(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, targetProvider.EnableKeyNormalization([]string{"ns1"}))
+	require.EqualError(t, env.testHistoryDBProvider.EnableMigration(targetProvider, 1.5),
+		"invalid shadow read sample rate [1.5], expected a value between 0 and 1")
+	require.NoError(t, env.testHistoryDBProvider.EnableMigration(targetProvider, 1))
+	historydb = env.testHistoryDBProvider.GetDBHandle("ledger1")
+
+	// a block cannot be committed to the migration target ahead of the blocks missing in the target
+	blocks = append(blocks, nextBlock(3))
+	require.NoError(t, historydb.Commit(blocks[3]))
+	status, err := historydb.MigrationStatus()
+	require.NoError(t, err)
+	require.Equal(t, &MigrationStatus{IndexedHeight: 4, CommitFailures: 1}, status)
+
+	// the recovery catches up the migration target, without recommitting the blocks to the historydb
+	recover, nextRequiredBlock, err := historydb.ShouldRecover(3)
+	require.NoError(t, err)
+	require.True(t, recover)
+	require.Equal(t, uint64(0), nextRequiredBlock)
+	for _, b := range blocks {
+		require.NoError(t, historydb.CommitLostBlock(&ledger.BlockAndPvtData{Block: b}))
+	}
+	recover, _, err = historydb.ShouldRecover(3)
+	require.NoError(t, err)
+	require.False(t, recover)
+
+	// the blocks are dual-written after the target is caught up
+	blocks = append(blocks, nextBlock(4))
+	require.NoError(t, historydb.Commit(blocks[4]))
+	status, err = historydb.MigrationStatus()
+	require.NoError(t, err)
+	require.Equal(t, &MigrationStatus{IndexedHeight: 5, TargetIndexedHeight: 5, CommitFailures: 1}, status)
+
+	// the history in the target is under the normalized key and the sampled query matches the historydb
+	targetQE, err := targetProvider.GetDBHandle("ledger1").NewQueryExecutor(store)
+	require.NoError(t, err)
+	testutilVerifyResults(t, targetQE, "ns1", "caf\u00e9", []string{"\x04", "\x03", "\x02", "\x01"})
+	qe, err := historydb.NewQueryExecutor(store)
+	require.NoError(t, err)
+	testutilVerifyResults(t, qe, "ns1", "cafe\u0301", []string{"\x04", "\x03", "\x02", "\x01"})
+	historydb.migration.shadowReadsWG.Wait()
+	status, err = historydb.MigrationStatus()
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), status.ShadowReads)
+	require.Zero(t, status.Divergences)
+
+	// a missing entry in the target is reported as a divergence
+	require.NoError(t, historydb.migration.target.levelDB.Delete(constructDataKey("ns1", "caf\u00e9", 2, 0), true))
+	testutilVerifyResults(t, qe, "ns1", "cafe\u0301", []string{"\x04", "\x03", "\x02", "\x01"})
+	historydb.migration.shadowReadsWG.Wait()
+	status, err = historydb.MigrationStatus()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), status.ShadowReads)
+	require.Equal(t, uint64(1), status.Divergences)
+	require.Equal(t, "namespace [ns1] key [cafe\u0301] differs at entry [1], block [2] transaction [0] whereas the migration target has block [3] transaction [0]",
+		status.LastDivergence)
+}
@@ -0,0 +1,156 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"container/list"
+	"sync"
+
+	commonledger "github.com/hyperledger/fabric/common/ledger"
+)
+
+// noHistoryCache is an LRU set of the keys that are known to have no history entries, so that the repeated history
+// queries for the keys that were never written, as issued by the reconciliation jobs, are answered without seeking
+// the leveldb. A key is identified by the start key of its range scan. The keys of a write batch are removed from the
+// set once the batch is written. As a query may find no entries for a key just before a write batch for the key is
+// written, the key is added only if no write batch is written between the seek and the addition, as tracked by the
+// generation of the set. A nil noHistoryCache is valid and caches nothing.
+type noHistoryCache struct {
+	maxKeys    int
+	mutex      sync.Mutex
+	generation uint64
+	entries    map[string]*list.Element
+	lru        *list.List
+	stats      *ledgerStats
+}
+
+// EnableNoHistoryCache enables caching, per ledger, up to maxKeys keys that are found by the history queries to have
+// no history. A maxKeys of 0 or less leaves the cache disabled.
+func (p *DBProvider) EnableNoHistoryCache(maxKeys int) {
+	p.noHistoryCacheSize = maxKeys
+}
+
+func newNoHistoryCache(maxKeys int, stats *ledgerStats) *noHistoryCache {
+	if maxKeys <= 0 {
+		return nil
+	}
+	return &noHistoryCache{
+		maxKeys: maxKeys,
+		entries: map[string]*list.Element{},
+		lru:     list.New(),
+		stats:   stats,
+	}
+}
+
+// currentGeneration returns the generation to be passed to function `add` for the keys found to have no history
+// by a seek that starts after this call
+func (c *noHistoryCache) currentGeneration() uint64 {
+	if c == nil {
+		return 0
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.generation
+}
+
+func (c *noHistoryCache) contains(scanKey []byte) bool {
+	if c == nil {
+		return false
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	elem, ok := c.entries[string(scanKey)]
+	if !ok {
+		c.stats.cacheMiss(noHistoryCacheName)
+		return false
+	}
+	c.lru.MoveToFront(elem)
+	c.stats.cacheHit(noHistoryCacheName)
+	return true
+}
+
+// peek is same as function `contains`, except that it neither affects the recency of the key nor the metrics
+func (c *noHistoryCache) peek(scanKey []byte) bool {
+	if c == nil {
+		return false
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	_, ok := c.entries[string(scanKey)]
+	return ok
+}
+
+// add adds a key found to have no history, unless a write batch is written since the given generation
+func (c *noHistoryCache) add(scanKey []byte, generation uint64) {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if generation != c.generation {
+		return
+	}
+	if _, ok := c.entries[string(scanKey)]; ok {
+		return
+	}
+	c.entries[string(scanKey)] = c.lru.PushFront(string(scanKey))
+	for c.lru.Len() > c.maxKeys {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(string))
+		c.stats.cacheEviction(noHistoryCacheName)
+	}
+	c.stats.updateCacheSize(noHistoryCacheName, c.lru.Len(), 0)
+}
+
+// invalidate removes the keys of a write batch, as tracked by the index stats tracker, once the batch is written
+func (c *noHistoryCache) invalidate(scanKeys map[string]struct{}) {
+	if c == nil || len(scanKeys) == 0 {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.generation++
+	for k := range scanKeys {
+		if elem, ok := c.entries[k]; ok {
+			c.lru.Remove(elem)
+			delete(c.entries, k)
+		}
+	}
+	c.stats.updateCacheSize(noHistoryCacheName, c.lru.Len(), 0)
+}
+
+// purge removes all the keys. The keys found to have no history by the seeks in progress are not added afterwards
+func (c *noHistoryCache) purge() {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.generation++
+	c.entries = map[string]*list.Element{}
+	c.lru.Init()
+	c.stats.updateCacheSize(noHistoryCacheName, 0, 0)
+}
+
+func (c *noHistoryCache) len() int {
+	if c == nil {
+		return 0
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.lru.Len()
+}
+
+// emptyHistoryScanner is returned by the history queries for the keys in the noHistoryCache
+type emptyHistoryScanner struct{}
+
+func (s *emptyHistoryScanner) Next() (commonledger.QueryResult, error) {
+	return nil, nil
+}
+
+func (s *emptyHistoryScanner) Close() {}
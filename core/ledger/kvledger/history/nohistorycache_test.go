@@ -0,0 +1,98 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric/common/ledger/testutil"
+	util2 "github.com/hyperledger/fabric/common/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNoHistoryCache(t *testing.T) {
+	env := newTestHistoryEnv(t)
+	defer env.cleanup()
+	store, err := env.testBlockStorageEnv.provider.Open("ledger1")
+	require.NoError(t, err)
+	defer store.Shutdown()
+
+	env.testHistoryDBProvider.EnableNoHistoryCache(2)
+	historydb := env.testHistoryDBProvider.GetDBHandle("ledger1")
+	bg, gb := testutil.NewBlockGenerator(t, "ledger1", false)
+	commit := func(block *common.Block) {
+		require.NoError(t, store.AddBlock(block))
+		require.NoError(t, historydb.Commit(block))
+	}
+	nextBlock := func(key, value string) *common.Block {
+		simulator, err := env.txmgr.NewTxSimulator(util2.GenerateUUID())
+		require.NoError(t, err)
+		require.NoError(t, simulator.SetState("ns1", key, []byte(value)))
+		simulator.Done()
+		simRes, err := simulator.GetTxSimulationResults()
+		require.NoError(t, err)
+		pubSimResBytes, err := simRes.GetPubSimulationBytes()
+		require.NoError(t, err)
+		return bg.NextBlock([][]byte{pubSimResBytes})
+	}
+	scanKey := func(key string) []byte {
+		return constructRangeScan("ns1", key).startKey
+	}
+	commit(gb)
+	commit(nextBlock("key1", "value1"))
+
+	qe, err := historydb.NewQueryExecutor(store)
+	require.NoError(t, err)
+	testutilVerifyResults(t, qe, "ns1", "key1", []string{"value1"})
+	testutilVerifyResults(t, qe, "ns1", "key2", []string{})
+	testutilVerifyResults(t, qe, "ns1", "key3", []string{})
+	// only the keys without history are cached
+	require.Equal(t, 2, historydb.noHistoryCache.len())
+	require.False(t, historydb.noHistoryCache.contains(scanKey("key1")))
+	require.True(t, historydb.noHistoryCache.contains(scanKey("key2")))
+	require.True(t, historydb.noHistoryCache.contains(scanKey("key3")))
+
+	// the least recently used key is evicted
+	testutilVerifyResults(t, qe, "ns1", "key4", []string{})
+	require.Equal(t, 2, historydb.noHistoryCache.len())
+	require.False(t, historydb.noHistoryCache.contains(scanKey("key2")))
+
+	// a key is removed once its history is written
+	commit(nextBlock("key3", "value3"))
+	require.False(t, historydb.noHistoryCache.contains(scanKey("key3")))
+	qe, err = historydb.NewQueryExecutor(store)
+	require.NoError(t, err)
+	testutilVerifyResults(t, qe, "ns1", "key3", []string{"value3"})
+
+	t.Run("write-between-seek-and-add", func(t *testing.T) {
+		generation := historydb.noHistoryCache.currentGeneration()
+		commit(nextBlock("key5", "value5"))
+		historydb.noHistoryCache.add(scanKey("key5"), generation)
+		require.False(t, historydb.noHistoryCache.contains(scanKey("key5")))
+	})
+
+	t.Run("group-commit", func(t *testing.T) {
+		require.NoError(t, env.testHistoryDBProvider.EnableGroupCommit(10, time.Hour))
+		historydb := env.testHistoryDBProvider.GetDBHandle("ledger1")
+		qe, err := historydb.NewQueryExecutor(store)
+		require.NoError(t, err)
+		testutilVerifyResults(t, qe, "ns1", "key6", []string{})
+		require.True(t, historydb.noHistoryCache.contains(scanKey("key6")))
+
+		// the key is removed when the pending writes are flushed, rather than when the block is committed
+		historydb.groupCommit.lastCommit = time.Now()
+		block := nextBlock("key6", "value6")
+		require.NoError(t, store.AddBlock(block))
+		require.NoError(t, historydb.Commit(block))
+		require.True(t, historydb.HasPendingWrites())
+		require.True(t, historydb.noHistoryCache.contains(scanKey("key6")))
+		require.NoError(t, historydb.Flush())
+		require.False(t, historydb.noHistoryCache.contains(scanKey("key6")))
+	})
+}
@@ -0,0 +1,64 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// IndexingDisabledError is returned by the history queries for a namespace for which the history indexing is
+// disabled via function `DisableIndexing`
+type IndexingDisabledError struct {
+	Namespace string
+}
+
+func (e *IndexingDisabledError) Error() string {
+	return fmt.Sprintf("history indexing disabled for namespace [%s]", e.Namespace)
+}
+
+// DisableIndexing disables the history indexing for the given namespaces, such as those of the chaincodes that use
+// the state as a cache and rewrite their keys at a high rate, so as to save the disk space and the commit time
+// spent on a history that is never queried. The writes to these namespaces are skipped by the block commits, the
+// snapshot import, the backfill, and the index batches applied from a replication stream. The history entries
+// written before the indexing is disabled are retained, but the history queries for such a namespace fail with an
+// IndexingDisabledError, as the history would be incomplete.
+func (p *DBProvider) DisableIndexing(namespaces []string) error {
+	if len(namespaces) == 0 {
+		p.indexingDisabled = nil
+		return nil
+	}
+	indexingDisabled := map[string]struct{}{}
+	for _, ns := range namespaces {
+		if ns == "" {
+			return errors.New("invalid namespace for disabling the history indexing, the namespace cannot be empty")
+		}
+		indexingDisabled[ns] = struct{}{}
+	}
+	p.indexingDisabled = indexingDisabled
+	return nil
+}
+
+// isIndexed returns false if the history indexing is disabled for the namespace
+func (d *DB) isIndexed(ns string) bool {
+	_, disabled := d.indexingDisabled[ns]
+	return !disabled
+}
+
+// isIndexedEntry returns false if the history indexing is disabled for the namespace of the given dataKey, or the
+// key of the dataKey is excluded by the key indexing policy of the namespace
+func (d *DB) isIndexedEntry(key dataKey) (bool, error) {
+	if len(d.indexingDisabled) == 0 && len(d.keyIndexingPolicies) == 0 && len(d.loadChaincodeHints()) == 0 {
+		return true, nil
+	}
+	ns, k, _, _, err := decodeDataKey(key)
+	if err != nil {
+		return false, err
+	}
+	return d.isKeyIndexed(ns, k), nil
+}
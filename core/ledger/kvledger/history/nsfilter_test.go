@@ -0,0 +1,82 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric/common/ledger/testutil"
+	util2 "github.com/hyperledger/fabric/common/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDisableIndexing(t *testing.T) {
+	env := newTestHistoryEnv(t)
+	defer env.cleanup()
+	store, err := env.testBlockStorageEnv.provider.Open("ledger1")
+	require.NoError(t, err)
+	defer store.Shutdown()
+
+	require.EqualError(t, env.testHistoryDBProvider.DisableIndexing([]string{"cachecc", ""}),
+		"invalid namespace for disabling the history indexing, the namespace cannot be empty")
+	require.NoError(t, env.testHistoryDBProvider.DisableIndexing([]string{"cachecc"}))
+	historydb := env.testHistoryDBProvider.GetDBHandle("ledger1")
+
+	bg, gb := testutil.NewBlockGenerator(t, "ledger1", false)
+	require.NoError(t, store.AddBlock(gb))
+	require.NoError(t, historydb.Commit(gb))
+	simulator, err := env.txmgr.NewTxSimulator(util2.GenerateUUID())
+	require.NoError(t, err)
+	require.NoError(t, simulator.SetState("ns1", "key1", []byte("value1")))
+	require.NoError(t, simulator.SetState("cachecc", "key1", []byte("value1")))
+	simulator.Done()
+	simRes, err := simulator.GetTxSimulationResults()
+	require.NoError(t, err)
+	pubSimResBytes, err := simRes.GetPubSimulationBytes()
+	require.NoError(t, err)
+	block := bg.NextBlock([][]byte{pubSimResBytes})
+	require.NoError(t, store.AddBlock(block))
+	require.NoError(t, historydb.Commit(block))
+
+	indexedNamespaces := func(historydb *DB) map[string]int {
+		namespaces := map[string]int{}
+		require.NoError(t, historydb.ScanIndex("", "", func(e *IndexEntry) error {
+			namespaces[e.Namespace]++
+			return nil
+		}))
+		return namespaces
+	}
+	require.Equal(t, map[string]int{"ns1": 1}, indexedNamespaces(historydb))
+
+	qe, err := historydb.NewQueryExecutor(store)
+	require.NoError(t, err)
+	itr, err := qe.GetHistoryForKey("ns1", "key1")
+	require.NoError(t, err)
+	kmod, err := itr.Next()
+	require.NoError(t, err)
+	require.NotNil(t, kmod)
+	itr.Close()
+	_, err = qe.GetHistoryForKey("cachecc", "key1")
+	require.Equal(t, &IndexingDisabledError{Namespace: "cachecc"}, err)
+	require.EqualError(t, err, "history indexing disabled for namespace [cachecc]")
+
+	// the index batch includes the writes to all the namespaces, and the subscriber skips the disabled ones
+	batch, err := historydb.NewIndexBatch(block)
+	require.NoError(t, err)
+	require.Len(t, batch.Entries, 2)
+
+	subscriberProvider, err := NewDBProvider(t.TempDir())
+	require.NoError(t, err)
+	defer subscriberProvider.Close()
+	require.NoError(t, subscriberProvider.DisableIndexing([]string{"cachecc"}))
+	subscriber := subscriberProvider.GetDBHandle("ledger1")
+	genesisBatch, err := historydb.NewIndexBatch(gb)
+	require.NoError(t, err)
+	require.NoError(t, subscriber.ApplyIndexBatch(genesisBatch))
+	require.NoError(t, subscriber.ApplyIndexBatch(batch))
+	require.Equal(t, map[string]int{"ns1": 1}, indexedNamespaces(subscriber))
+}
@@ -0,0 +1,120 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	pageTokenVersion   = byte(1)
+	pageTokenKeySize   = 32
+	pageTokenQuerySize = 8
+	pageTokenMACSize   = 16
+)
+
+// InvalidPageTokenError is returned for a page token that is malformed, that was not issued by this peer process, or
+// that was issued for a different query
+type InvalidPageTokenError struct {
+	Reason string
+}
+
+func (e *InvalidPageTokenError) Error() string {
+	return fmt.Sprintf("invalid page token: %s", e.Reason)
+}
+
+// StalePageTokenError is returned for a page token issued at a height of the history above the current height, i.e.,
+// before the history was rolled back, reset or rebuilt. The listing is to be restarted from the first page
+type StalePageTokenError struct {
+	TokenHeight   uint64
+	IndexedHeight uint64
+}
+
+func (e *StalePageTokenError) Error() string {
+	return fmt.Sprintf("stale page token: the token was issued at the history height [%d], the history is now at height [%d]",
+		e.TokenHeight, e.IndexedHeight)
+}
+
+func newPageTokenKey() ([]byte, error) {
+	key := make([]byte, pageTokenKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, errors.Wrap(err, "error while generating the key for the page tokens")
+	}
+	return key, nil
+}
+
+// pageToken is the continuation of a paged query. The position is where the next page starts and the queryHash
+// identifies the query, so that a token cannot be passed to another query or another ledger
+type pageToken struct {
+	position  []byte
+	height    uint64
+	queryHash []byte
+}
+
+// pageQueryHash returns the hash of a query whose pages are continued via the page tokens
+func pageQueryHash(ledgerID, query string, params ...string) []byte {
+	h := sha256.New()
+	for _, s := range append([]string{ledgerID, query}, params...) {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	return h.Sum(nil)[:pageTokenQuerySize]
+}
+
+// seal encodes the token as version~height~queryHash~position~mac, where the mac is a truncated HMAC-SHA256 of the
+// rest of the token under the given key, in the unpadded URL-safe base64
+func (t *pageToken) seal(key []byte) string {
+	var height [binary.MaxVarintLen64]byte
+	buf := []byte{pageTokenVersion}
+	buf = append(buf, height[:binary.PutUvarint(height[:], t.height)]...)
+	buf = append(buf, t.queryHash...)
+	buf = append(buf, t.position...)
+	buf = append(buf, pageTokenMAC(key, buf)...)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// openPageToken verifies and decodes a token sealed via function `seal` for the query with the given hash
+func openPageToken(key []byte, token string, queryHash []byte) (*pageToken, error) {
+	buf, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, &InvalidPageTokenError{Reason: "the token is not encoded in the URL-safe base64"}
+	}
+	if len(buf) < 1+pageTokenQuerySize+pageTokenMACSize || buf[0] != pageTokenVersion {
+		return nil, &InvalidPageTokenError{Reason: "the token is malformed"}
+	}
+	payload, mac := buf[:len(buf)-pageTokenMACSize], buf[len(buf)-pageTokenMACSize:]
+	if !hmac.Equal(mac, pageTokenMAC(key, payload)) {
+		return nil, &InvalidPageTokenError{Reason: "the token was not issued by this peer or was altered"}
+	}
+	height, n := binary.Uvarint(payload[1:])
+	if n <= 0 || len(payload) < 1+n+pageTokenQuerySize {
+		return nil, &InvalidPageTokenError{Reason: "the token is malformed"}
+	}
+	t := &pageToken{
+		height:    height,
+		queryHash: payload[1+n : 1+n+pageTokenQuerySize],
+		position:  payload[1+n+pageTokenQuerySize:],
+	}
+	if !bytes.Equal(t.queryHash, queryHash) {
+		return nil, &InvalidPageTokenError{Reason: "the token was issued for a different query"}
+	}
+	return t, nil
+}
+
+func pageTokenMAC(key, payload []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(payload)
+	return mac.Sum(nil)[:pageTokenMACSize]
+}
@@ -0,0 +1,85 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric/common/ledger/testutil"
+	util2 "github.com/hyperledger/fabric/common/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListIndexedKeysPage(t *testing.T) {
+	env := newTestHistoryEnv(t)
+	defer env.cleanup()
+	store, err := env.testBlockStorageEnv.provider.Open("ledger1")
+	require.NoError(t, err)
+	defer store.Shutdown()
+
+	historydb := env.testHistoryDBProvider.GetDBHandle("ledger1")
+	bg, gb := testutil.NewBlockGenerator(t, "ledger1", false)
+	commit := func(block *common.Block) {
+		require.NoError(t, store.AddBlock(block))
+		require.NoError(t, historydb.Commit(block))
+	}
+	commit(gb)
+	for i := 0; i < 2; i++ {
+		simulator, err := env.txmgr.NewTxSimulator(util2.GenerateUUID())
+		require.NoError(t, err)
+		for _, key := range []string{"key2", "key10", "key1", "k"} {
+			require.NoError(t, simulator.SetState("ns1", key, []byte("value")))
+		}
+		require.NoError(t, simulator.SetState("ns2", "other", []byte("value")))
+		simulator.Done()
+		simRes, err := simulator.GetTxSimulationResults()
+		require.NoError(t, err)
+		pubSimResBytes, err := simRes.GetPubSimulationBytes()
+		require.NoError(t, err)
+		commit(bg.NextBlock([][]byte{pubSimResBytes}))
+	}
+
+	page, err := historydb.ListIndexedKeysPage("ns1", "", 2)
+	require.NoError(t, err)
+	require.Equal(t, []string{"k", "key1"}, page.Keys)
+	require.True(t, page.HasMore)
+	token := page.NextPageToken
+	require.NotEmpty(t, token)
+	page, err = historydb.ListIndexedKeysPage("ns1", token, 2)
+	require.NoError(t, err)
+	require.Equal(t, &IndexedKeys{Keys: []string{"key2", "key10"}, EstimatedTotal: 4}, page)
+
+	// the token cannot be passed to a different namespace or ledger
+	_, err = historydb.ListIndexedKeysPage("ns2", token, 2)
+	require.Equal(t, &InvalidPageTokenError{Reason: "the token was issued for a different query"}, err)
+	_, err = env.testHistoryDBProvider.GetDBHandle("ledger2").ListIndexedKeysPage("ns1", token, 2)
+	require.EqualError(t, err, "invalid page token: the token was issued for a different query")
+
+	// an altered token, a token issued by another peer and a malformed token are rejected
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	require.NoError(t, err)
+	raw[len(raw)-pageTokenMACSize-1]++
+	_, err = historydb.ListIndexedKeysPage("ns1", base64.RawURLEncoding.EncodeToString(raw), 2)
+	require.Equal(t, &InvalidPageTokenError{Reason: "the token was not issued by this peer or was altered"}, err)
+	foreignKey, err := newPageTokenKey()
+	require.NoError(t, err)
+	foreignToken := (&pageToken{position: []byte("key1"), height: 3, queryHash: pageQueryHash("ledger1", "ListIndexedKeys", "ns1")}).seal(foreignKey)
+	_, err = historydb.ListIndexedKeysPage("ns1", foreignToken, 2)
+	require.Equal(t, &InvalidPageTokenError{Reason: "the token was not issued by this peer or was altered"}, err)
+	_, err = historydb.ListIndexedKeysPage("ns1", "YQ", 2)
+	require.Equal(t, &InvalidPageTokenError{Reason: "the token is malformed"}, err)
+	_, err = historydb.ListIndexedKeysPage("ns1", "!", 2)
+	require.Equal(t, &InvalidPageTokenError{Reason: "the token is not encoded in the URL-safe base64"}, err)
+
+	// the token is stale once the history is rolled back below the height at which the token was issued
+	require.NoError(t, env.testHistoryDBProvider.Rollback("ledger1", 1))
+	_, err = historydb.ListIndexedKeysPage("ns1", token, 2)
+	require.Equal(t, &StalePageTokenError{TokenHeight: 3, IndexedHeight: 2}, err)
+	require.EqualError(t, err, "stale page token: the token was issued at the history height [3], the history is now at height [2]")
+}
@@ -0,0 +1,427 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric/common/ledger/blkstorage"
+	"github.com/hyperledger/fabric/common/ledger/util/leveldbhelper"
+	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/rwsetutil"
+	"github.com/pkg/errors"
+)
+
+const (
+	// projectionsDBName is the name of the db, within the historydb leveldb, that records the checkpoints of the
+	// history projectors, keyed by the ledger and the projector
+	projectionsDBName = "_projections"
+	// projectionsListenerName is the name of the commit listener that delivers the blocks to the history projectors
+	projectionsListenerName = "_projections"
+)
+
+// ProjectionStatus is the status of a history projector for a ledger
+type ProjectionStatus struct {
+	// NextBlock is the block that the projector applies next, i.e., the height of the blocks applied
+	NextBlock uint64
+	// Replaying is true while the projection is being replayed (see function `ReplayProjection`)
+	Replaying bool
+	// Suspended is true if the projector is suspended for the ledger, in which case Error is the cause
+	Suspended bool
+	Error     string
+}
+
+// RegisterProjector registers a projector to which the blocks committed, after the registration, to the historydbs
+// obtained from this provider are delivered, as per its checkpoint for each ledger. The projectors are delivered the
+// blocks by a commit listener (see function `RegisterCommitListener`). A projector that has not applied a block
+// before, such as a projector registered for an existing ledger, applies the blocks starting from block 0. If a block
+// to be delivered is after the block that the projector applies next, the projector is suspended for the ledger, as
+// it is if it fails to apply a block or if the history of the blocks that it has applied is invalidated (see function
+// `NotifyInvalidations`), and the blocks are applied again via function `ReplayProjection`. The
+// projectors are expected to be registered before the historydbs are used.
+func (p *DBProvider) RegisterProjector(projector ledger.HistoryProjector) error {
+	name := projector.Name()
+	if name == "" || bytes.Contains([]byte(name), compositeKeySep) {
+		return errors.Errorf("invalid history projector name [%s], the name cannot be empty or contain the byte 0x00", name)
+	}
+	if p.projections == nil {
+		projections := &projections{
+			db:      p.leveldbProvider.GetDBHandle(projectionsDBName),
+			states:  map[projectionKey]*projectionState{},
+			heights: map[string]uint64{},
+		}
+		if err := p.RegisterCommitListener(projections); err != nil {
+			return err
+		}
+		p.projections = projections
+	}
+	if p.projections.projector(name) != nil {
+		return errors.Errorf("history projector [%s] is already registered", name)
+	}
+	p.projections.projectors = append(p.projections.projectors, projector)
+	return nil
+}
+
+// ProjectionStatus returns the status of the given projector for the ledger
+func (d *DB) ProjectionStatus(name string) (*ProjectionStatus, error) {
+	pr := d.provider.projections
+	if pr == nil || pr.projector(name) == nil {
+		return nil, errors.Errorf("history projector [%s] is not registered", name)
+	}
+	pr.mutex.Lock()
+	defer pr.mutex.Unlock()
+	state, err := pr.state(d.name, name)
+	if err != nil {
+		return nil, err
+	}
+	return &ProjectionStatus{
+		NextBlock: state.nextBlock,
+		Replaying: state.replaying,
+		Suspended: state.suspended,
+		Error:     state.err,
+	}, nil
+}
+
+// ReplayProjection resets the given projector for the ledger to the block fromBlock and applies the blocks starting
+// from fromBlock, retrieved from the block store, until the projector catches up with the blocks committed to the
+// history, after which the projector applies the blocks as they are committed. The blocks committed during the replay
+// are applied by the replay. A suspended projector is resumed by the replay, which suspends the projector again if
+// it fails to apply a block. The replay of a projector for a ledger cannot be started while another is in progress.
+func (d *DB) ReplayProjection(name string, fromBlock uint64, blockStore *blkstorage.BlockStore) error {
+	pr := d.provider.projections
+	var projector ledger.HistoryProjector
+	if pr != nil {
+		projector = pr.projector(name)
+	}
+	if projector == nil {
+		return errors.Errorf("history projector [%s] is not registered", name)
+	}
+
+	pr.mutex.Lock()
+	state, err := pr.state(d.name, name)
+	if err != nil {
+		pr.mutex.Unlock()
+		return err
+	}
+	if state.replaying {
+		pr.mutex.Unlock()
+		return errors.Errorf("history projector [%s] is already being replayed for channel [%s]", name, d.name)
+	}
+	*state = projectionState{nextBlock: fromBlock, replaying: true}
+	err = pr.writeState(d.name, name, state)
+	pr.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	logger.Infow("Replaying history projection", "channel", d.name, "projector", name, "fromBlock", fromBlock)
+
+	replayErr := func() error {
+		if err := projector.Reset(d.name, fromBlock); err != nil {
+			return errors.WithMessagef(err, "error while resetting history projector [%s] to block [%d]", name, fromBlock)
+		}
+		for {
+			pr.mutex.Lock()
+			height, ok := pr.heights[d.name]
+			if !ok {
+				if height, err = d.IndexedHeight(); err != nil {
+					pr.mutex.Unlock()
+					return err
+				}
+			}
+			if state.nextBlock >= height {
+				// the blocks committed hereafter are applied by the commit listener
+				state.replaying = false
+				pr.mutex.Unlock()
+				return nil
+			}
+			blockNum := state.nextBlock
+			pr.mutex.Unlock()
+
+			block, err := blockStore.RetrieveBlockByNumber(blockNum)
+			if err != nil {
+				return errors.WithMessagef(err, "error while retrieving block [%d] for replaying history projector [%s]", blockNum, name)
+			}
+			commit, err := d.newHistoryCommit(block)
+			if err != nil {
+				return err
+			}
+			pr.mutex.Lock()
+			err = pr.apply(projector, state, commit.LedgerID, commit.BlockNum, projectionChanges(commit))
+			suspended := state.suspended
+			pr.mutex.Unlock()
+			if err != nil {
+				return err
+			}
+			if suspended {
+				return errors.Errorf("history projector [%s] failed to apply block [%d]: %s", name, blockNum, state.err)
+			}
+		}
+	}()
+	if replayErr == nil {
+		logger.Infow("Replayed history projection", "channel", d.name, "projector", name, "height", state.nextBlock)
+		return nil
+	}
+	pr.mutex.Lock()
+	defer pr.mutex.Unlock()
+	state.replaying = false
+	if !state.suspended {
+		pr.suspend(d.name, name, state, replayErr)
+	}
+	return replayErr
+}
+
+// projections delivers the blocks committed to the history to the registered projectors. It is registered as a
+// commit listener, which is invoked for the blocks of all the ledgers of the DBProvider
+type projections struct {
+	db         *leveldbhelper.DBHandle
+	projectors []ledger.HistoryProjector
+
+	// mutex guards the states and the heights, and serializes the delivery of the blocks to the projectors
+	mutex  sync.Mutex
+	states map[projectionKey]*projectionState
+	// heights holds, per ledger, the height of the blocks committed to the history
+	heights map[string]uint64
+}
+
+type projectionKey struct {
+	ledgerID, name string
+}
+
+// projectionState is the checkpoint of a projector for a ledger. The replaying flag is not persisted
+type projectionState struct {
+	nextBlock uint64
+	suspended bool
+	err       string
+	replaying bool
+}
+
+func (pr *projections) Name() string {
+	return projectionsListenerName
+}
+
+// HandleHistoryCommit delivers the block to each projector for which the block is the next block to be applied. A
+// projector that fails to apply the block is suspended, without failing the commit
+func (pr *projections) HandleHistoryCommit(commit *ledger.HistoryCommit) error {
+	changes := projectionChanges(commit)
+	pr.mutex.Lock()
+	defer pr.mutex.Unlock()
+	if commit.BlockNum+1 > pr.heights[commit.LedgerID] {
+		pr.heights[commit.LedgerID] = commit.BlockNum + 1
+	}
+	for _, projector := range pr.projectors {
+		state, err := pr.state(commit.LedgerID, projector.Name())
+		if err != nil {
+			return err
+		}
+		// a block before the next block is delivered again as the history recommits the blocks after its savepoint
+		if state.suspended || state.replaying || commit.BlockNum < state.nextBlock {
+			continue
+		}
+		if commit.BlockNum > state.nextBlock {
+			if err := pr.suspend(commit.LedgerID, projector.Name(), state, errors.Errorf(
+				"the projector has not applied blocks [%d] to [%d]", state.nextBlock, commit.BlockNum-1)); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := pr.apply(projector, state, commit.LedgerID, commit.BlockNum, changes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HandleHistoryInvalidation suspends the projectors that have applied the invalidated blocks. The projectors that have
+// not applied a block are suspended as well, as the checkpoints are removed along with the history by a rollback or
+// a reset of the ledger, after which the blocks starting from block 0 are committed again
+func (pr *projections) HandleHistoryInvalidation(invalidation *ledger.HistoryInvalidation) error {
+	pr.mutex.Lock()
+	defer pr.mutex.Unlock()
+	for _, projector := range pr.projectors {
+		state, err := pr.state(invalidation.LedgerID, projector.Name())
+		if err != nil {
+			return err
+		}
+		if state.suspended || (state.nextBlock != 0 && state.nextBlock <= invalidation.FromBlock) {
+			continue
+		}
+		if err := pr.suspend(invalidation.LedgerID, projector.Name(), state, errors.Errorf(
+			"the history of blocks [%d] to [%d] is invalidated by %s", invalidation.FromBlock, invalidation.ToBlock,
+			invalidation.Reason)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apply applies the block to the projector and records the checkpoint, or suspends the projector if it fails to
+// apply the block. The returned error is that of recording the state. The caller is expected to hold the mutex
+func (pr *projections) apply(projector ledger.HistoryProjector, state *projectionState, ledgerID string, blockNum uint64,
+	changes []*ledger.HistoryKeyChange) error {
+	if err := applyProjection(projector, ledgerID, blockNum, changes); err != nil {
+		return pr.suspend(ledgerID, projector.Name(), state, errors.WithMessagef(err, "error while applying block [%d]", blockNum))
+	}
+	state.nextBlock = blockNum + 1
+	return pr.writeState(ledgerID, projector.Name(), state)
+}
+
+// applyProjection invokes the projector, recovering from a panic so that a faulty projector does not halt the commit
+func applyProjection(projector ledger.HistoryProjector, ledgerID string, blockNum uint64, changes []*ledger.HistoryKeyChange) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Errorf("history projector panicked: %v", r)
+		}
+	}()
+	return projector.Apply(ledgerID, blockNum, changes)
+}
+
+// suspend suspends the projector for the ledger. The caller is expected to hold the mutex
+func (pr *projections) suspend(ledgerID, name string, state *projectionState, cause error) error {
+	logger.Errorw("Suspending history projector, the projection is to be replayed to resume the projector",
+		"channel", ledgerID, "projector", name, "nextBlock", state.nextBlock, "error", cause)
+	state.suspended, state.err = true, cause.Error()
+	return pr.writeState(ledgerID, name, state)
+}
+
+func (pr *projections) projector(name string) ledger.HistoryProjector {
+	for _, projector := range pr.projectors {
+		if projector.Name() == name {
+			return projector
+		}
+	}
+	return nil
+}
+
+// state returns the cached state of the projector for the ledger, which is loaded from the db on first use. The caller
+// is expected to hold the mutex
+func (pr *projections) state(ledgerID, name string) (*projectionState, error) {
+	key := projectionKey{ledgerID, name}
+	if state, ok := pr.states[key]; ok {
+		return state, nil
+	}
+	b, err := pr.db.Get(constructProjectionStateKey(ledgerID, name))
+	if err != nil {
+		return nil, err
+	}
+	state := &projectionState{}
+	if b != nil {
+		if state, err = projectionStateFromBytes(b); err != nil {
+			return nil, err
+		}
+	}
+	pr.states[key] = state
+	return state, nil
+}
+
+func (pr *projections) writeState(ledgerID, name string, state *projectionState) error {
+	return errors.WithMessagef(
+		pr.db.Put(constructProjectionStateKey(ledgerID, name), state.toBytes(), true),
+		"error while recording the checkpoint of history projector [%s] for channel [%s]", name, ledgerID,
+	)
+}
+
+// dropProjections removes the checkpoints of the projectors for the ledger
+func (p *DBProvider) dropProjections(name string) error {
+	db := p.leveldbProvider.GetDBHandle(projectionsDBName)
+	prefix := constructProjectionStateKey(name, "")
+	itr, err := db.GetIterator(prefix, append(prefix, 0xff))
+	if err != nil {
+		return err
+	}
+	defer itr.Release()
+	batch := db.NewUpdateBatch()
+	for itr.Next() {
+		batch.Delete(append([]byte{}, itr.Key()...))
+	}
+	if err := itr.Error(); err != nil {
+		return errors.Wrap(err, "internal leveldb error while iterating for history projector checkpoints")
+	}
+	if err := db.WriteBatch(batch, true); err != nil {
+		return err
+	}
+	p.evictProjectionStates(name)
+	return nil
+}
+
+// evictProjectionStates removes the cached states of the projectors for the ledger, so that these are loaded again
+func (p *DBProvider) evictProjectionStates(name string) {
+	if p.projections == nil {
+		return
+	}
+	p.projections.mutex.Lock()
+	defer p.projections.mutex.Unlock()
+	for key := range p.projections.states {
+		if key.ledgerID == name {
+			delete(p.projections.states, key)
+		}
+	}
+}
+
+// newHistoryCommit returns the writes of the block, as passed to the commit listeners
+func (d *DB) newHistoryCommit(block *common.Block) (*ledger.HistoryCommit, error) {
+	commit := &ledger.HistoryCommit{LedgerID: d.name, BlockNum: block.Header.Number}
+	_, err := d.visitBlockTxs(block, func(tranNo uint64, chdr *common.ChannelHeader, _ *common.Payload, txRWSet *rwsetutil.TxRwSet) error {
+		commit.Txs = append(commit.Txs, newHistoryTxWrites(tranNo, chdr, txRWSet))
+		return nil
+	})
+	return commit, err
+}
+
+// projectionChanges returns the changes of the keys, as delivered to the projectors, for the writes of a block
+func projectionChanges(commit *ledger.HistoryCommit) []*ledger.HistoryKeyChange {
+	var changes []*ledger.HistoryKeyChange
+	for _, tx := range commit.Txs {
+		for _, w := range tx.Writes {
+			changes = append(changes, &ledger.HistoryKeyChange{
+				Namespace: w.Namespace,
+				Key:       w.Write.Key,
+				TranNum:   tx.TranNum,
+				TxID:      tx.TxID,
+				Value:     w.Write.Value,
+				IsDelete:  rwsetutil.IsKVWriteDelete(w.Write),
+			})
+		}
+	}
+	return changes
+}
+
+func constructProjectionStateKey(ledgerID, name string) []byte {
+	return append(append([]byte(ledgerID), compositeKeySep...), name...)
+}
+
+func (s *projectionState) toBytes() []byte {
+	buf := proto.NewBuffer(nil)
+	// the errors are always nil for the encoding of varints and bytes
+	_ = buf.EncodeVarint(s.nextBlock)
+	suspended := uint64(0)
+	if s.suspended {
+		suspended = 1
+	}
+	_ = buf.EncodeVarint(suspended)
+	_ = buf.EncodeStringBytes(s.err)
+	return buf.Bytes()
+}
+
+func projectionStateFromBytes(b []byte) (*projectionState, error) {
+	buf := proto.NewBuffer(b)
+	nextBlock, err := buf.DecodeVarint()
+	if err != nil {
+		return nil, errors.Wrap(err, "error while decoding the history projector checkpoint")
+	}
+	suspended, err := buf.DecodeVarint()
+	if err != nil {
+		return nil, errors.Wrap(err, "error while decoding the history projector checkpoint")
+	}
+	errString, err := buf.DecodeStringBytes()
+	if err != nil {
+		return nil, errors.Wrap(err, "error while decoding the history projector checkpoint")
+	}
+	return &projectionState{nextBlock: nextBlock, suspended: suspended == 1, err: errString}, nil
+}
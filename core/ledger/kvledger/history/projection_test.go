@@ -0,0 +1,170 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric/common/ledger/testutil"
+	util2 "github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+type testProjector struct {
+	name       string
+	blocks     []uint64
+	changes    [][]*ledger.HistoryKeyChange
+	failBlock  uint64
+	err        error
+	panicBlock uint64
+	resetErr   error
+	resets     []uint64
+}
+
+func (p *testProjector) Name() string {
+	return p.name
+}
+
+func (p *testProjector) Reset(ledgerID string, fromBlock uint64) error {
+	if p.resetErr != nil {
+		return p.resetErr
+	}
+	p.resets = append(p.resets, fromBlock)
+	p.blocks, p.changes = nil, nil
+	return nil
+}
+
+func (p *testProjector) Apply(ledgerID string, blockNum uint64, changes []*ledger.HistoryKeyChange) error {
+	if p.err != nil && blockNum == p.failBlock {
+		return p.err
+	}
+	if p.panicBlock != 0 && blockNum == p.panicBlock {
+		panic("projector bug")
+	}
+	p.blocks = append(p.blocks, blockNum)
+	p.changes = append(p.changes, changes)
+	return nil
+}
+
+func TestProjection(t *testing.T) {
+	env := newTestHistoryEnv(t)
+	defer env.cleanup()
+	provider := env.testHistoryDBProvider
+	store, err := env.testBlockStorageEnv.provider.Open("ledger1")
+	require.NoError(t, err)
+	defer store.Shutdown()
+
+	require.EqualError(t, provider.RegisterProjector(&testProjector{}),
+		"invalid history projector name [], the name cannot be empty or contain the byte 0x00")
+	projector1 := &testProjector{name: "projector1"}
+	projector2 := &testProjector{name: "projector2", failBlock: 1, err: errors.New("projector error")}
+	projector3 := &testProjector{name: "projector3", panicBlock: 2}
+	for _, p := range []*testProjector{projector1, projector2, projector3} {
+		require.NoError(t, provider.RegisterProjector(p))
+	}
+	require.EqualError(t, provider.RegisterProjector(&testProjector{name: "projector1"}),
+		"history projector [projector1] is already registered")
+
+	historydb := provider.GetDBHandle("ledger1")
+	bg, gb := testutil.NewBlockGenerator(t, "ledger1", false)
+	commit := func(block *common.Block) {
+		require.NoError(t, store.AddBlock(block))
+		require.NoError(t, historydb.Commit(block))
+	}
+	commitValues := func(values ...string) {
+		var txs [][]byte
+		for _, value := range values {
+			simulator, err := env.txmgr.NewTxSimulator(util2.GenerateUUID())
+			require.NoError(t, err)
+			require.NoError(t, simulator.SetState("ns1", "key1", []byte(value)))
+			require.NoError(t, simulator.DeleteState("ns1", "key2"))
+			simulator.Done()
+			simRes, err := simulator.GetTxSimulationResults()
+			require.NoError(t, err)
+			pubSimResBytes, err := simRes.GetPubSimulationBytes()
+			require.NoError(t, err)
+			txs = append(txs, pubSimResBytes)
+		}
+		commit(bg.NextBlock(txs))
+	}
+	verifyStatus := func(name string, expected *ProjectionStatus) {
+		status, err := historydb.ProjectionStatus(name)
+		require.NoError(t, err)
+		require.Equal(t, expected, status)
+	}
+
+	// the failures of projector2 at block 1 and of projector3 at block 2 suspend these without failing the commits
+	commit(gb)
+	commitValues("value1", "value2")
+	commitValues("value3")
+	require.Equal(t, []uint64{0, 1, 2}, projector1.blocks)
+	require.Equal(t, []*ledger.HistoryKeyChange{
+		{Namespace: "ns1", Key: "key1", TranNum: 0, TxID: projector1.changes[1][0].TxID, Value: []byte("value1")},
+		{Namespace: "ns1", Key: "key2", TranNum: 0, TxID: projector1.changes[1][0].TxID, IsDelete: true},
+		{Namespace: "ns1", Key: "key1", TranNum: 1, TxID: projector1.changes[1][2].TxID, Value: []byte("value2")},
+		{Namespace: "ns1", Key: "key2", TranNum: 1, TxID: projector1.changes[1][2].TxID, IsDelete: true},
+	}, projector1.changes[1])
+	require.Equal(t, []uint64{0}, projector2.blocks)
+	require.Equal(t, []uint64{0, 1}, projector3.blocks)
+	verifyStatus("projector1", &ProjectionStatus{NextBlock: 3})
+	verifyStatus("projector2", &ProjectionStatus{NextBlock: 1, Suspended: true,
+		Error: "error while applying block [1]: projector error"})
+	verifyStatus("projector3", &ProjectionStatus{NextBlock: 2, Suspended: true,
+		Error: "error while applying block [2]: history projector panicked: projector bug"})
+	_, err = historydb.ProjectionStatus("projector4")
+	require.EqualError(t, err, "history projector [projector4] is not registered")
+
+	// a projector registered for an existing ledger is suspended for the blocks that it has not applied
+	projector4 := &testProjector{name: "projector4"}
+	require.NoError(t, provider.RegisterProjector(projector4))
+	commitValues("value4")
+	require.Empty(t, projector4.blocks)
+	verifyStatus("projector4", &ProjectionStatus{NextBlock: 0, Suspended: true,
+		Error: "the projector has not applied blocks [0] to [2]"})
+	require.NoError(t, historydb.ReplayProjection("projector4", 0, store))
+	require.Equal(t, []uint64{0, 1, 2, 3}, projector4.blocks)
+	verifyStatus("projector4", &ProjectionStatus{NextBlock: 4})
+
+	// the replay resumes a suspended projector, which suspends it again if the projector fails again
+	err = historydb.ReplayProjection("projector2", 1, store)
+	require.EqualError(t, err, "history projector [projector2] failed to apply block [1]: error while applying block [1]: projector error")
+	require.Equal(t, []uint64{1}, projector2.resets)
+	verifyStatus("projector2", &ProjectionStatus{NextBlock: 1, Suspended: true,
+		Error: "error while applying block [1]: projector error"})
+	projector2.err = nil
+	require.NoError(t, historydb.ReplayProjection("projector2", 1, store))
+	require.Equal(t, []uint64{1, 2, 3}, projector2.blocks)
+	commitValues("value5")
+	require.Equal(t, []uint64{1, 2, 3, 4}, projector2.blocks)
+	verifyStatus("projector2", &ProjectionStatus{NextBlock: 5})
+
+	projector3.resetErr = errors.New("reset error")
+	err = historydb.ReplayProjection("projector3", 0, store)
+	require.EqualError(t, err, "error while resetting history projector [projector3] to block [0]: reset error")
+	verifyStatus("projector3", &ProjectionStatus{NextBlock: 0, Suspended: true,
+		Error: "error while resetting history projector [projector3] to block [0]: reset error"})
+	require.EqualError(t, historydb.ReplayProjection("projector5", 0, store), "history projector [projector5] is not registered")
+
+	// the projectors that have applied the blocks removed by a rollback are suspended when the invalidation is delivered
+	require.NoError(t, provider.Rollback("ledger1", 3))
+	historydb = provider.GetDBHandle("ledger1")
+	require.NoError(t, historydb.NotifyInvalidations())
+	verifyStatus("projector1", &ProjectionStatus{NextBlock: 5, Suspended: true,
+		Error: "the history of blocks [4] to [4] is invalidated by history_rollback"})
+	verifyStatus("projector3", &ProjectionStatus{NextBlock: 0, Suspended: true,
+		Error: "error while resetting history projector [projector3] to block [0]: reset error"})
+
+	require.NoError(t, provider.Drop("ledger1"))
+	historydb = provider.GetDBHandle("ledger1")
+	verifyStatus("projector1", &ProjectionStatus{})
+
+	_, err = projectionStateFromBytes([]byte{0xff})
+	require.Error(t, err)
+}
@@ -7,74 +7,269 @@ SPDX-License-Identifier: Apache-2.0
 package history
 
 import (
-	"github.com/hyperledger/fabric-protos-go/common"
+	"sync"
+	"time"
+
 	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
 	commonledger "github.com/hyperledger/fabric/common/ledger"
-	"github.com/hyperledger/fabric/common/ledger/blkstorage"
 	"github.com/hyperledger/fabric/common/ledger/util/leveldbhelper"
 	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/rwsetutil"
-	protoutil "github.com/hyperledger/fabric/protoutil"
 	"github.com/pkg/errors"
 	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"go.uber.org/zap/zapcore"
 )
 
 // QueryExecutor is a query executor against the LevelDB history DB
 type QueryExecutor struct {
-	levelDB    *leveldbhelper.DBHandle
-	blockStore *blkstorage.BlockStore
+	levelDB   *leveldbhelper.DBHandle
+	txFetcher TxFetcher
+	historyDB *DB
+	// priority is the priority with which the queries are scheduled
+	priority QueryPriority
+	// client is the identity of the client to whose quotas the queries are accounted, if any
+	client string
+
+	// indexedHeight is the indexed height of the historydb as of the first query. The subsequent queries exclude the
+	// entries for the blocks indexed afterwards, so that the results of all the queries reflect the same height
+	indexedHeightOnce sync.Once
+	indexedHeight     uint64
+	indexedHeightErr  error
+}
+
+// EnableQueryPrefetch enables reading ahead, for each history query, up to depth history entries following the one
+// being consumed and retrieving their transactions from the block store in the background. This overlaps the block
+// file reads with the processing of the results by the consumer. A depth of 0 or less leaves the prefetch disabled.
+func (p *DBProvider) EnableQueryPrefetch(depth int) {
+	p.prefetchDepth = depth
+}
+
+// IndexedHeight returns the height of the blocks that are reflected in the results of the queries
+func (q *QueryExecutor) IndexedHeight() (uint64, error) {
+	q.indexedHeightOnce.Do(func() {
+		q.indexedHeight, q.indexedHeightErr = q.historyDB.IndexedHeight()
+	})
+	return q.indexedHeight, q.indexedHeightErr
 }
 
 // GetHistoryForKey implements method in interface `ledger.HistoryQueryExecutor`
 func (q *QueryExecutor) GetHistoryForKey(namespace string, key string) (commonledger.ResultsIterator, error) {
+	if q.historyDB.quotas == nil || q.client == "" {
+		return q.getHistoryForKey(namespace, key)
+	}
+	if quotaErr := q.historyDB.quotas.admit(q.client); quotaErr != nil {
+		q.historyDB.stats.quotaRejection(q.client, quotaErr.Resource)
+		return nil, quotaErr
+	}
+	itr, err := q.getHistoryForKey(namespace, key)
+	if err != nil {
+		return nil, err
+	}
+	return &quotaScanner{ResultsIterator: itr, quotas: q.historyDB.quotas, client: q.client, stats: q.historyDB.stats}, nil
+}
+
+func (q *QueryExecutor) getHistoryForKey(namespace string, key string) (commonledger.ResultsIterator, error) {
+	if !q.historyDB.isIndexed(namespace) {
+		return nil, &IndexingDisabledError{Namespace: namespace}
+	}
+	originalKey := key
+	key = q.historyDB.normalizeKey(namespace, key)
+	if !q.historyDB.isKeyIndexed(namespace, key) {
+		return nil, &KeyNotIndexedError{Namespace: namespace, Key: key}
+	}
+	if m := q.historyDB.migration; m != nil && originalKey != "" {
+		m.maybeShadowRead(namespace, originalKey, q.txFetcher)
+	}
+	// an error in reading the indexed height is returned after the iterator is obtained, as the
+	// error in obtaining the iterator, if any, is the more relevant one
+	indexedHeight, heightErr := q.IndexedHeight()
 	rangeScan := constructRangeScan(namespace, key)
+	noHistoryCache := q.historyDB.noHistoryCache
+	if heightErr == nil && noHistoryCache.contains(rangeScan.startKey) {
+		return &emptyHistoryScanner{}, nil
+	}
+	scanner := &historyScanner{
+		rangeScan:     rangeScan,
+		namespace:     namespace,
+		key:           key,
+		txFetcher:     q.txFetcher,
+		historyDB:     q.historyDB,
+		indexedHeight: indexedHeight,
+		prefetchDepth: q.historyDB.prefetchDepth,
+		policy:        readThrough,
+	}
+	if q.priority == Batch {
+		scanner.policy = readAround
+	}
+	if q.historyDB.slowQueries != nil {
+		scanner.timing = &queryTiming{start: time.Now()}
+	}
+	if resultCache := q.historyDB.queryResultCache; resultCache != nil && heightErr == nil {
+		results, ok, generation := resultCache.get(rangeScan.startKey, indexedHeight)
+		if ok {
+			return &cachedHistoryScanner{results: results}, nil
+		}
+		scanner.resultCache, scanner.resultCacheGeneration = resultCache, generation
+	}
+	if q.historyDB.scanners.leakDetectionEnabled() {
+		scanner.leakInfo = newScannerLeakInfo()
+	}
+	// the scanner is registered before obtaining the iterator, so that the iterator is not obtained beyond the limit
+	if err := q.historyDB.scanners.register(scanner); err != nil {
+		return nil, err
+	}
+	generation := noHistoryCache.currentGeneration()
+	seekStart := time.Now()
 	dbItr, err := q.levelDB.GetIterator(rangeScan.startKey, rangeScan.endKey)
 	if err != nil {
+		q.historyDB.scanners.unregister(scanner)
 		return nil, err
 	}
+	if heightErr != nil {
+		dbItr.Release()
+		q.historyDB.scanners.unregister(scanner)
+		return nil, heightErr
+	}
+	scanner.dbItr = dbItr
 
 	// By default, dbItr is in the orderer of oldest to newest and its cursor is at the beginning of the entries.
 	// Need to call Last() and Next() to move the cursor to the end of the entries so that we can iterate
 	// the entries in the order of newest to oldest.
 	if dbItr.Last() {
 		dbItr.Next()
+	} else if dbItr.Error() == nil {
+		noHistoryCache.add(rangeScan.startKey, generation)
+	}
+	if scanner.timing != nil {
+		scanner.timing.addIndexScan(seekStart)
 	}
-	return &historyScanner{rangeScan, namespace, key, dbItr, q.blockStore}, nil
+	return scanner, nil
 }
 
 // historyScanner implements ResultsIterator for iterating through history results
 type historyScanner struct {
-	rangeScan  *rangeScan
-	namespace  string
-	key        string
-	dbItr      iterator.Iterator
-	blockStore *blkstorage.BlockStore
+	rangeScan     *rangeScan
+	namespace     string
+	key           string
+	dbItr         iterator.Iterator
+	txFetcher     TxFetcher
+	historyDB     *DB
+	indexedHeight uint64
+	// policy is the policy for the lookups of the transactions in the decoded transaction cache, which also
+	// determines the priority with which the transactions are retrieved from the block store
+	policy cachePolicy
+
+	// prefetchDepth is the number of history records read ahead of the consumer, whose transactions are retrieved
+	// from the block store in the background, so that the block file reads overlap with the consumption of results
+	prefetchDepth int
+	lookahead     []*historyRecord
+	lookaheadErr  error
+
+	// mutex serializes the use of the scanner by the client with its release by the leak detection
+	mutex sync.Mutex
+	// leakInfo is recorded for the leak detection, if enabled when the scanner is created
+	leakInfo *scannerLeakInfo
+	// releasedErr is set once the scanner is released by the leak detection
+	releasedErr error
+
+	// resultCache, if not nil, is the cache to which the results are added once the scanner is exhausted. It is
+	// reset if the results are not to be cached, as for an error or too many results
+	resultCache           *queryResultCache
+	resultCacheGeneration uint64
+	results               []*queryresult.KeyModification
+
+	// timing is the breakdown of the time taken by the query, recorded if the slow query log is enabled, and
+	// exhausted is set once the scanner returns all its results
+	timing    *queryTiming
+	exhausted bool
+}
+
+// historyRecord is a history entry read from the index by the scanner
+type historyRecord struct {
+	blockNum  uint64
+	tranNum   uint64
+	inlineVal []byte
+	// fetched is closed once the transaction is retrieved by the prefetch. It is nil if the record was not prefetched
+	fetched chan struct{}
+	tx      *decodedTx
+	err     error
+}
+
+// historyRecordPool holds the history records released by the scanners once the results for the records are returned
+var historyRecordPool = sync.Pool{
+	New: func() interface{} {
+		return &historyRecord{}
+	},
+}
+
+func newHistoryRecord(blockNum, tranNum uint64) *historyRecord {
+	record := historyRecordPool.Get().(*historyRecord)
+	record.blockNum, record.tranNum = blockNum, tranNum
+	return record
+}
+
+// release returns the record to the pool. A record whose transaction may still be retrieved by the prefetch
+// must not be released. The inline value is not reused, as the result decoded from it may refer to it
+func (r *historyRecord) release() {
+	*r = historyRecord{}
+	historyRecordPool.Put(r)
 }
 
 // Next iterates to the next key, in the order of newest to oldest, from history scanner.
 // It decodes blockNumTranNumBytes to get blockNum and tranNum,
 // loads the block:tran from block storage, finds the key and returns the result.
 func (scanner *historyScanner) Next() (commonledger.QueryResult, error) {
-	// call Prev because history query result is returned from newest to oldest
-	if !scanner.dbItr.Prev() {
-		return nil, nil
+	scanner.mutex.Lock()
+	defer scanner.mutex.Unlock()
+	if scanner.releasedErr != nil {
+		return nil, scanner.releasedErr
+	}
+	if scanner.leakInfo != nil {
+		defer scanner.leakInfo.markUsed()
+	}
+	result, err := scanner.next()
+	if scanner.resultCache != nil {
+		scanner.collectResult(result, err)
 	}
+	if err == nil {
+		if result == nil {
+			scanner.exhausted = true
+		} else if scanner.timing != nil {
+			scanner.timing.numResults++
+		}
+	}
+	return result, err
+}
 
-	historyKey := scanner.dbItr.Key()
-	blockNum, tranNum, err := scanner.rangeScan.decodeBlockNumTranNum(historyKey)
-	if err != nil {
+func (scanner *historyScanner) next() (commonledger.QueryResult, error) {
+	record, err := scanner.nextRecord()
+	if err != nil || record == nil {
 		return nil, err
 	}
-	logger.Debugf("Found history record for namespace:%s key:%s at blockNumTranNum %v:%v\n",
-		scanner.namespace, scanner.key, blockNum, tranNum)
+	// the record is consumed by the time Next returns, including the wait for its prefetch, if any
+	defer record.release()
+	scanner.prefetch()
+	blockNum, tranNum := record.blockNum, record.tranNum
+	// the logging is guarded, as boxing the arguments allocates per result even if the debug level is disabled
+	debugEnabled := logger.IsEnabledFor(zapcore.DebugLevel)
+	if debugEnabled {
+		logger.Debugf("Found history record for namespace:%s key:%s at blockNumTranNum %v:%v\n",
+			scanner.namespace, scanner.key, blockNum, tranNum)
+	}
+
+	// history entries imported from a snapshot carry the key modification inline, as the
+	// corresponding transaction is not available in the block store
+	if len(record.inlineVal) > 0 {
+		return scanner.historyDB.decodeInlineEntry(scanner.namespace, scanner.key, record.inlineVal)
+	}
 
 	// Get the transaction from block storage that is associated with this history record
-	tranEnvelope, err := scanner.blockStore.RetrieveTxByBlockNumTranNum(blockNum, tranNum)
+	tx, err := scanner.retrieveTx(record)
 	if err != nil {
 		return nil, err
 	}
 
 	// Get the txid, key write value, timestamp, and delete indicator associated with this transaction
-	queryResult, err := getKeyModificationFromTran(tranEnvelope, scanner.namespace, scanner.key)
+	queryResult, err := getKeyModificationFromTran(tx, scanner.namespace, scanner.key, scanner.historyDB.normalizeKey)
 	if err != nil {
 		return nil, err
 	}
@@ -83,48 +278,161 @@ func (scanner *historyScanner) Next() (commonledger.QueryResult, error) {
 		logger.Errorf("No namespace or key is found for namespace %s and key %s with decoded blockNum %d and tranNum %d", scanner.namespace, scanner.key, blockNum, tranNum)
 		return nil, errors.Errorf("no namespace or key is found for namespace %s and key %s with decoded blockNum %d and tranNum %d", scanner.namespace, scanner.key, blockNum, tranNum)
 	}
-	logger.Debugf("Found historic key value for namespace:%s key:%s from transaction %s",
-		scanner.namespace, scanner.key, queryResult.(*queryresult.KeyModification).TxId)
+	if debugEnabled {
+		logger.Debugf("Found historic key value for namespace:%s key:%s from transaction %s",
+			scanner.namespace, scanner.key, queryResult.(*queryresult.KeyModification).TxId)
+	}
 	return queryResult, nil
 }
 
+// collectResult collects the results returned by the scanner and adds them to the queryResultCache once the scanner
+// is exhausted. A scanner closed before it is exhausted does not add its results
+func (scanner *historyScanner) collectResult(result commonledger.QueryResult, err error) {
+	switch {
+	case err != nil:
+		scanner.resultCache, scanner.results = nil, nil
+	case result == nil:
+		scanner.resultCache.add(scanner.rangeScan.startKey, scanner.indexedHeight, scanner.resultCacheGeneration, scanner.results)
+		scanner.resultCache, scanner.results = nil, nil
+	case len(scanner.results) >= scanner.resultCache.maxEntries:
+		scanner.resultCache, scanner.results = nil, nil
+	default:
+		scanner.results = append(scanner.results, result.(*queryresult.KeyModification))
+	}
+}
+
 func (scanner *historyScanner) Close() {
+	scanner.mutex.Lock()
+	defer scanner.mutex.Unlock()
+	if scanner.releasedErr != nil {
+		// already released by the leak detection
+		return
+	}
 	scanner.dbItr.Release()
+	scanner.historyDB.scanners.unregister(scanner)
+	if scanner.timing != nil {
+		scanner.historyDB.slowQueries.record(scanner.historyDB.name, scanner.namespace, scanner.key,
+			scanner.indexedHeight, scanner.timing, scanner.exhausted)
+	}
 }
 
-// getTxIDandKeyWriteValueFromTran inspects a transaction for writes to a given key
-func getKeyModificationFromTran(tranEnvelope *common.Envelope, namespace string, key string) (commonledger.QueryResult, error) {
-	logger.Debugf("Entering getKeyModificationFromTran %s:%s", namespace, key)
+// nextRecord returns the next history record, from the records read ahead by the prefetch, if any
+func (scanner *historyScanner) nextRecord() (*historyRecord, error) {
+	if len(scanner.lookahead) > 0 {
+		record := scanner.lookahead[0]
+		scanner.lookahead = scanner.lookahead[1:]
+		return record, nil
+	}
+	if scanner.lookaheadErr != nil {
+		return nil, scanner.lookaheadErr
+	}
+	return scanner.readRecord()
+}
 
-	// extract action from the envelope
-	payload, err := protoutil.UnmarshalPayload(tranEnvelope.Payload)
-	if err != nil {
-		return nil, err
+// readRecord reads the next history record from the index, skipping the entries for the blocks indexed after
+// the query executor is created
+func (scanner *historyScanner) readRecord() (*historyRecord, error) {
+	if scanner.timing != nil {
+		defer scanner.timing.addIndexScan(time.Now())
 	}
+	for {
+		// call Prev because history query result is returned from newest to oldest
+		if !scanner.dbItr.Prev() {
+			return nil, nil
+		}
+		blockNum, tranNum, err := scanner.rangeScan.decodeBlockNumTranNum(scanner.dbItr.Key())
+		if err != nil {
+			return nil, err
+		}
+		if blockNum >= scanner.indexedHeight {
+			continue
+		}
+		record := newHistoryRecord(blockNum, tranNum)
+		if val := scanner.dbItr.Value(); len(val) > 0 {
+			// the iterator reuses the buffer of the value once moved
+			record.inlineVal = append([]byte(nil), val...)
+		}
+		return record, nil
+	}
+}
 
-	tx, err := protoutil.UnmarshalTransaction(payload.Data)
-	if err != nil {
-		return nil, err
+// prefetch reads ahead up to prefetchDepth history records and starts retrieving their transactions in the background.
+// The consecutive records in the same block, as for a key written by multiple transactions in a block, are retrieved
+// together, so that the block is read once if the fetcher supports the batched retrieval
+func (scanner *historyScanner) prefetch() {
+	var sameBlockRecords []*historyRecord
+	for len(scanner.lookahead) < scanner.prefetchDepth && scanner.lookaheadErr == nil {
+		record, err := scanner.readRecord()
+		if err != nil {
+			// returned to the consumer once the records read ahead so far are consumed
+			scanner.lookaheadErr = err
+			break
+		}
+		if record == nil {
+			break
+		}
+		if len(record.inlineVal) == 0 {
+			record.fetched = make(chan struct{})
+			if len(sameBlockRecords) > 0 && sameBlockRecords[0].blockNum != record.blockNum {
+				scanner.startRetrieval(sameBlockRecords)
+				sameBlockRecords = nil
+			}
+			sameBlockRecords = append(sameBlockRecords, record)
+		}
+		scanner.lookahead = append(scanner.lookahead, record)
 	}
+	scanner.startRetrieval(sameBlockRecords)
+}
 
-	_, respPayload, err := protoutil.GetPayloads(tx.Actions[0])
-	if err != nil {
-		return nil, err
+// startRetrieval retrieves the transactions for the given records, which are in the same block, in the background
+func (scanner *historyScanner) startRetrieval(records []*historyRecord) {
+	if len(records) == 0 {
+		return
 	}
+	go func() {
+		tranNums := make([]uint64, len(records))
+		for i, record := range records {
+			tranNums[i] = record.tranNum
+		}
+		txs, err := scanner.historyDB.retrieveTxs(scanner.txFetcher, records[0].blockNum, tranNums, scanner.policy)
+		for i, record := range records {
+			if err != nil {
+				record.err = err
+			} else {
+				record.tx = txs[i]
+			}
+			close(record.fetched)
+		}
+	}()
+}
 
-	chdr, err := protoutil.UnmarshalChannelHeader(payload.Header.ChannelHeader)
-	if err != nil {
-		return nil, err
+// retrieveTx returns the transaction for the history record, waiting for the prefetch of the transaction, if started
+func (scanner *historyScanner) retrieveTx(record *historyRecord) (*decodedTx, error) {
+	if scanner.timing != nil {
+		defer scanner.timing.addBlockFetch(time.Now())
+	}
+	if record.fetched != nil {
+		<-record.fetched
+		return record.tx, record.err
 	}
+	return scanner.historyDB.retrieveTx(scanner.txFetcher, record.blockNum, record.tranNum, scanner.policy)
+}
 
-	txID := chdr.TxId
-	timestamp := chdr.Timestamp
+// getKeyModificationFromTran inspects a decoded transaction for writes to a given key, where the key of a write is
+// compared as normalized by the given function normalizeKey
+func getKeyModificationFromTran(tx *decodedTx, namespace string, key string, normalizeKey func(ns, key string) string) (commonledger.QueryResult, error) {
+	debugEnabled := logger.IsEnabledFor(zapcore.DebugLevel)
+	if debugEnabled {
+		logger.Debugf("Entering getKeyModificationFromTran %s:%s", namespace, key)
+	}
 
-	txRWSet := &rwsetutil.TxRwSet{}
+	txID := tx.channelHeader.TxId
+	timestamp := tx.channelHeader.Timestamp
 
-	// Get the Result from the Action and then Unmarshal
-	// it into a TxReadWriteSet using custom unmarshalling
-	if err = txRWSet.FromProtoBytes(respPayload.Results); err != nil {
+	// Get the TxReadWriteSet unmarshalled from the Result of the Action, which
+	// is parsed once per transaction and shared by the keys written by it
+	txRWSet, err := tx.txRWSet()
+	if err != nil {
 		return nil, err
 	}
 
@@ -133,17 +441,21 @@ func getKeyModificationFromTran(tranEnvelope *common.Envelope, namespace string,
 		if nsRWSet.NameSpace == namespace {
 			// got the correct namespace, now find the key write
 			for _, kvWrite := range nsRWSet.KvRwSet.Writes {
-				if kvWrite.Key == key {
+				if normalizeKey(namespace, kvWrite.Key) == key {
 					return &queryresult.KeyModification{
 						TxId: txID, Value: kvWrite.Value,
 						Timestamp: timestamp, IsDelete: rwsetutil.IsKVWriteDelete(kvWrite),
 					}, nil
 				}
 			} // end keys loop
-			logger.Debugf("key [%s] not found in namespace [%s]'s writeset", key, namespace)
+			if debugEnabled {
+				logger.Debugf("key [%s] not found in namespace [%s]'s writeset", key, namespace)
+			}
 			return nil, nil
 		} // end if
 	} // end namespaces loop
-	logger.Debugf("namespace [%s] not found in transaction's ReadWriteSets", namespace)
+	if debugEnabled {
+		logger.Debugf("namespace [%s] not found in transaction's ReadWriteSets", namespace)
+	}
 	return nil, nil
 }
@@ -0,0 +1,212 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/hyperledger/fabric-protos-go/msp"
+	commonledger "github.com/hyperledger/fabric/common/ledger"
+	"github.com/pkg/errors"
+)
+
+// the resources accounted to the quotas of the clients, as reported in the label "resource" of the quota metrics
+const (
+	quotaResourceEntries = "entries_scanned"
+	quotaResourceBytes   = "bytes_returned"
+)
+
+// QueryQuotaExceededError is returned by the history queries of a client whose usage in the current quota window has
+// reached a limit set via function `EnableQueryQuotas`
+type QueryQuotaExceededError struct {
+	Client string
+	// Resource is either "entries_scanned" or "bytes_returned"
+	Resource string
+	Limit    uint64
+	Window   time.Duration
+	// RetryAfter is the time remaining until the usage of the client is reset
+	RetryAfter time.Duration
+}
+
+func (e *QueryQuotaExceededError) Error() string {
+	return fmt.Sprintf("history query quota exceeded for client [%s]: the %s in a window of [%s] reached the limit [%d], retry after [%s]",
+		e.Client, e.Resource, e.Window, e.Limit, e.RetryAfter)
+}
+
+type queryClientKey struct{}
+
+// WithQueryClient returns a context that carries the identity of the client on whose behalf the history queries are
+// issued, so that the queries via a query executor created for the context are accounted to the quotas of the client.
+// The identity is expected to be established by the caller from the authenticated credentials of the client.
+func WithQueryClient(ctx context.Context, client string) context.Context {
+	return context.WithValue(ctx, queryClientKey{}, client)
+}
+
+// QueryClientFromContext returns the identity of the client carried by the context, as set via function
+// `WithQueryClient`, or an empty string if the context carries none
+func QueryClientFromContext(ctx context.Context) string {
+	client, _ := ctx.Value(queryClientKey{}).(string)
+	return client
+}
+
+// QueryClientForIdentity returns the identity of a client, as accounted to the quotas, for the given serialized
+// identity. The identity is formed by the MSP ID and a hash of the serialized identity, so that the clients whose
+// certificates share a subject across the MSPs or the CAs are accounted separately.
+func QueryClientForIdentity(serializedIdentity []byte) string {
+	hash := sha256.Sum256(serializedIdentity)
+	id := &msp.SerializedIdentity{}
+	if err := proto.Unmarshal(serializedIdentity, id); err != nil || id.Mspid == "" {
+		return fmt.Sprintf("%x", hash[:8])
+	}
+	return fmt.Sprintf("%s/%x", id.Mspid, hash[:8])
+}
+
+// EnableQueryQuotas limits, per client and across the ledgers, the number of the history entries scanned and the
+// bytes of the values returned by the history queries in each window of the given duration. Once a limit is reached,
+// the queries of the client, including the ones in progress, fail with a QueryQuotaExceededError until the window
+// ends. Only the queries via a query executor created for a context that carries a client identity (see function
+// `WithQueryClient`) are accounted. A maxEntries or a maxBytes of 0 leaves the corresponding resource unlimited.
+func (p *DBProvider) EnableQueryQuotas(window time.Duration, maxEntries, maxBytes int) error {
+	if window < 0 || maxEntries < 0 || maxBytes < 0 {
+		return errors.Errorf("invalid query quota config: window [%s], maxEntries [%d] and maxBytes [%d] must not be negative",
+			window, maxEntries, maxBytes)
+	}
+	if maxEntries == 0 && maxBytes == 0 {
+		p.quotas = nil
+		return nil
+	}
+	if window == 0 {
+		return errors.New("invalid query quota config: the window must be set if a limit is set")
+	}
+	p.quotas = newQueryQuotas(window, uint64(maxEntries), uint64(maxBytes))
+	return nil
+}
+
+// queryQuotas tracks the usage of the clients in fixed windows. The usage of a client is reset once its window, which
+// starts with its first query after the previous window ends, elapses. A nil queryQuotas is valid and limits nothing.
+type queryQuotas struct {
+	window     time.Duration
+	maxEntries uint64
+	maxBytes   uint64
+	now        func() time.Time
+
+	mutex sync.Mutex
+	usage map[string]*clientUsage
+}
+
+type clientUsage struct {
+	windowStart time.Time
+	entries     uint64
+	bytes       uint64
+}
+
+func newQueryQuotas(window time.Duration, maxEntries, maxBytes uint64) *queryQuotas {
+	return &queryQuotas{
+		window:     window,
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		now:        time.Now,
+		usage:      map[string]*clientUsage{},
+	}
+}
+
+// admit returns an error if the client has already reached a limit in the current window
+func (q *queryQuotas) admit(client string) *QueryQuotaExceededError {
+	return q.charge(client, 0, 0)
+}
+
+// charge adds the given usage to the usage of the client in the current window and returns an error if the usage
+// exceeds a limit, or has reached one for a query that is yet to scan an entry, i.e., when admitted
+func (q *queryQuotas) charge(client string, entries, bytes uint64) *QueryQuotaExceededError {
+	if q == nil {
+		return nil
+	}
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	now := q.now()
+	usage := q.usage[client]
+	if usage == nil || now.Sub(usage.windowStart) >= q.window {
+		if usage == nil {
+			q.removeExpired(now)
+		}
+		usage = &clientUsage{windowStart: now}
+		q.usage[client] = usage
+	}
+	usage.entries += entries
+	usage.bytes += bytes
+	exceeds := func(used, limit uint64) bool {
+		if limit == 0 {
+			return false
+		}
+		if entries == 0 && bytes == 0 {
+			return used >= limit
+		}
+		return used > limit
+	}
+	var resource string
+	var limit uint64
+	switch {
+	case exceeds(usage.entries, q.maxEntries):
+		resource, limit = quotaResourceEntries, q.maxEntries
+	case exceeds(usage.bytes, q.maxBytes):
+		resource, limit = quotaResourceBytes, q.maxBytes
+	default:
+		return nil
+	}
+	return &QueryQuotaExceededError{
+		Client:     client,
+		Resource:   resource,
+		Limit:      limit,
+		Window:     q.window,
+		RetryAfter: usage.windowStart.Add(q.window).Sub(now),
+	}
+}
+
+// removeExpired removes the usage of the clients whose window has elapsed, so that the usage of the clients that no
+// longer query is not retained
+func (q *queryQuotas) removeExpired(now time.Time) {
+	for client, usage := range q.usage {
+		if now.Sub(usage.windowStart) >= q.window {
+			delete(q.usage, client)
+		}
+	}
+}
+
+// quotaScanner accounts the results returned by a history scanner to the quotas of the client. Each result is a
+// history entry scanned and the size of its value is the bytes returned. Once the client exceeds a limit, the scanner
+// returns the QueryQuotaExceededError in place of the result
+type quotaScanner struct {
+	commonledger.ResultsIterator
+	quotas *queryQuotas
+	client string
+	stats  *ledgerStats
+	err    error
+}
+
+func (s *quotaScanner) Next() (commonledger.QueryResult, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	result, err := s.ResultsIterator.Next()
+	if err != nil || result == nil {
+		return result, err
+	}
+	size := uint64(len(result.(*queryresult.KeyModification).Value))
+	s.stats.queryUsage(s.client, 1, size)
+	if quotaErr := s.quotas.charge(s.client, 1, size); quotaErr != nil {
+		s.stats.quotaRejection(s.client, quotaErr.Resource)
+		s.err = quotaErr
+		return nil, quotaErr
+	}
+	return result, nil
+}
@@ -0,0 +1,184 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric-protos-go/msp"
+	"github.com/hyperledger/fabric/common/ledger/testutil"
+	"github.com/hyperledger/fabric/common/metrics"
+	"github.com/hyperledger/fabric/common/metrics/metricsfakes"
+	util2 "github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/protoutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryQuotas(t *testing.T) {
+	env := newTestHistoryEnv(t)
+	defer env.cleanup()
+	store, err := env.testBlockStorageEnv.provider.Open("ledger1")
+	require.NoError(t, err)
+	defer store.Shutdown()
+
+	fakeProvider := &metricsfakes.Provider{}
+	quotaCounters := map[string]*metricsfakes.Counter{}
+	fakeProvider.NewCounterStub = func(opts metrics.CounterOpts) metrics.Counter {
+		fakeCounter := &metricsfakes.Counter{}
+		fakeCounter.WithStub = func(labelValues ...string) metrics.Counter {
+			if opts.Name != "quota_rejections" && opts.Name != "client_entries_scanned" {
+				return fakeCounter
+			}
+			name := opts.Name
+			for i := 1; i < len(labelValues); i += 2 {
+				name += "." + labelValues[i]
+			}
+			c, ok := quotaCounters[name]
+			if !ok {
+				c = &metricsfakes.Counter{}
+				quotaCounters[name] = c
+			}
+			return c
+		}
+		return fakeCounter
+	}
+	fakeGauge := &metricsfakes.Gauge{}
+	fakeGauge.WithReturns(fakeGauge)
+	fakeProvider.NewGaugeReturns(fakeGauge)
+	fakeHist := &metricsfakes.Histogram{}
+	fakeHist.WithReturns(fakeHist)
+	fakeProvider.NewHistogramReturns(fakeHist)
+	env.testHistoryDBProvider.EnableMetrics(fakeProvider)
+	require.NoError(t, env.testHistoryDBProvider.EnableQueryQuotas(time.Minute, 3, 0))
+	now := time.Now()
+	env.testHistoryDBProvider.quotas.now = func() time.Time { return now }
+
+	historydb := env.testHistoryDBProvider.GetDBHandle("ledger1")
+	bg, gb := testutil.NewBlockGenerator(t, "ledger1", false)
+	commit := func(block *common.Block) {
+		require.NoError(t, store.AddBlock(block))
+		require.NoError(t, historydb.Commit(block))
+	}
+	nextBlock := func(key, value string) *common.Block {
+		simulator, err := env.txmgr.NewTxSimulator(util2.GenerateUUID())
+		require.NoError(t, err)
+		require.NoError(t, simulator.SetState("ns1", key, []byte(value)))
+		simulator.Done()
+		simRes, err := simulator.GetTxSimulationResults()
+		require.NoError(t, err)
+		pubSimResBytes, err := simRes.GetPubSimulationBytes()
+		require.NoError(t, err)
+		return bg.NextBlock([][]byte{pubSimResBytes})
+	}
+	commit(gb)
+	commit(nextBlock("key1", "value1"))
+	commit(nextBlock("key1", "value2"))
+	commit(nextBlock("key2", "value1"))
+	commit(nextBlock("key2", "value2"))
+
+	qe, err := historydb.NewQueryExecutorForContext(WithQueryClient(context.Background(), "client1"), store)
+	require.NoError(t, err)
+	testutilVerifyResults(t, qe, "ns1", "key1", []string{"value2", "value1"})
+	itr, err := qe.GetHistoryForKey("ns1", "key2")
+	require.NoError(t, err)
+	result, err := itr.Next()
+	require.NoError(t, err)
+	require.NotNil(t, result)
+	// the scan fails once the client exceeds the quota
+	expectedErr := &QueryQuotaExceededError{
+		Client:     "client1",
+		Resource:   "entries_scanned",
+		Limit:      3,
+		Window:     time.Minute,
+		RetryAfter: time.Minute,
+	}
+	_, err = itr.Next()
+	require.Equal(t, expectedErr, err)
+	require.EqualError(t, err, "history query quota exceeded for client [client1]: the entries_scanned in a window of [1m0s] reached the limit [3], retry after [1m0s]")
+	_, err = itr.Next()
+	require.Equal(t, expectedErr, err)
+	itr.Close()
+
+	// the new queries of the client are rejected until the window ends
+	now = now.Add(40 * time.Second)
+	_, err = qe.GetHistoryForKey("ns1", "key1")
+	expectedErr.RetryAfter = 20 * time.Second
+	require.Equal(t, expectedErr, err)
+	require.Equal(t, 2, quotaCounters["quota_rejections.ledger1.client1.entries_scanned"].AddCallCount())
+	require.Equal(t, 4, quotaCounters["client_entries_scanned.ledger1.client1"].AddCallCount())
+
+	// the other clients, and the queries that carry no client, are not affected
+	qe2, err := historydb.NewQueryExecutorForContext(WithQueryClient(context.Background(), "client2"), store)
+	require.NoError(t, err)
+	testutilVerifyResults(t, qe2, "ns1", "key1", []string{"value2", "value1"})
+	qe3, err := historydb.NewQueryExecutor(store)
+	require.NoError(t, err)
+	testutilVerifyResults(t, qe3, "ns1", "key1", []string{"value2", "value1"})
+	testutilVerifyResults(t, qe3, "ns1", "key2", []string{"value2", "value1"})
+
+	// the usage of the client is reset once the window ends
+	now = now.Add(20 * time.Second)
+	testutilVerifyResults(t, qe, "ns1", "key2", []string{"value2", "value1"})
+	require.Len(t, env.testHistoryDBProvider.quotas.usage, 2)
+	// the usage of the clients whose window has ended is removed once a new client queries
+	now = now.Add(time.Minute)
+	qe4, err := historydb.NewQueryExecutorForContext(WithQueryClient(context.Background(), "client3"), store)
+	require.NoError(t, err)
+	testutilVerifyResults(t, qe4, "ns1", "key1", []string{"value2", "value1"})
+	require.Len(t, env.testHistoryDBProvider.quotas.usage, 1)
+}
+
+func TestQueryQuotaBytes(t *testing.T) {
+	quotas := newQueryQuotas(time.Minute, 0, 10)
+	now := time.Now()
+	quotas.now = func() time.Time { return now }
+	require.Nil(t, quotas.admit("client1"))
+	require.Nil(t, quotas.charge("client1", 1, 6))
+	require.Nil(t, quotas.charge("client1", 1, 4))
+	require.Equal(t, &QueryQuotaExceededError{
+		Client:     "client1",
+		Resource:   "bytes_returned",
+		Limit:      10,
+		Window:     time.Minute,
+		RetryAfter: time.Minute,
+	}, quotas.admit("client1"))
+	require.Nil(t, quotas.admit("client2"))
+
+	var nilQuotas *queryQuotas
+	require.Nil(t, nilQuotas.charge("client1", 100, 100))
+}
+
+func TestEnableQueryQuotas(t *testing.T) {
+	p := &DBProvider{}
+	require.EqualError(t, p.EnableQueryQuotas(-time.Second, 1, 1),
+		"invalid query quota config: window [-1s], maxEntries [1] and maxBytes [1] must not be negative")
+	require.EqualError(t, p.EnableQueryQuotas(time.Second, -1, 1),
+		"invalid query quota config: window [1s], maxEntries [-1] and maxBytes [1] must not be negative")
+	require.EqualError(t, p.EnableQueryQuotas(0, 1, 0), "invalid query quota config: the window must be set if a limit is set")
+	require.NoError(t, p.EnableQueryQuotas(time.Second, 1, 0))
+	require.NotNil(t, p.quotas)
+	require.NoError(t, p.EnableQueryQuotas(0, 0, 0))
+	require.Nil(t, p.quotas)
+}
+
+func TestQueryClientFromContext(t *testing.T) {
+	require.Equal(t, "", QueryClientFromContext(context.Background()))
+	require.Equal(t, "client1", QueryClientFromContext(WithQueryClient(context.Background(), "client1")))
+}
+
+func TestQueryClientForIdentity(t *testing.T) {
+	client1 := protoutil.MarshalOrPanic(&msp.SerializedIdentity{Mspid: "Org1MSP", IdBytes: []byte("cert")})
+	client2 := protoutil.MarshalOrPanic(&msp.SerializedIdentity{Mspid: "Org2MSP", IdBytes: []byte("cert")})
+	require.Regexp(t, "^Org1MSP/[0-9a-f]{16}$", QueryClientForIdentity(client1))
+	require.Equal(t, QueryClientForIdentity(client1), QueryClientForIdentity(client1))
+	require.NotEqual(t, QueryClientForIdentity(client1), QueryClientForIdentity(client2))
+	// an identity that cannot be unmarshalled is still accounted by its hash
+	require.Regexp(t, "^[0-9a-f]{16}$", QueryClientForIdentity([]byte("garbage")))
+}
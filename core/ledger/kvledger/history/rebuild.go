@@ -0,0 +1,449 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"bytes"
+	"math"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric/common/ledger/blkstorage"
+	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/hyperledger/fabric/core/ledger/internal/version"
+	"github.com/pkg/errors"
+)
+
+var (
+	rebuildProgressInterval = 10 * time.Second
+	rebuildSwapBatchSize    = 1024 * 1024
+)
+
+// ErrRebuildStopped is returned by function `Run` of an OnlineRebuild if the rebuild is stopped before completion
+var ErrRebuildStopped = errors.New("history rebuild stopped")
+
+// RebuildProgress captures the progress of a history rebuild
+type RebuildProgress struct {
+	BlocksProcessed uint64
+	TotalBlocks     uint64
+	BlocksPerSec    float64
+	ETA             time.Duration
+}
+
+// Rebuild rebuilds the history for the ledger `name` from the blocks in the blockStore, starting from the block
+// fromBlock. The history entries for the blocks prior to fromBlock are carried over as is, which allows for a
+// partial rebuild, for instance, after a corruption is detected in a known range of blocks. A fromBlock of 0
+// rebuilds the complete history.
+//
+// The history is built in the side db provider `sideProvider` and the historydb for the ledger in this provider
+// is not touched until the rebuild completes. The rebuild checkpoints after every block (via the savepoint in the
+// side db) and hence, when invoked again after an interruption, resumes from the last block committed to the
+// side db, regardless of the fromBlock passed.
+//
+// For a ledger bootstrapped from a snapshot, the history entries for the blocks up to the snapshot cannot be
+// rebuilt from the block store. These entries are always carried over and fromBlock, if not 0, cannot be prior
+// to the first block after the snapshot.
+//
+// Once the rebuild catches up with the block store, the rebuilt history replaces the history for the ledger
+// in this provider. The savepoint is removed first and written last, so that an interruption during the
+// replacement leaves the historydb without a savepoint, which in turn causes the peer to recommit all the
+// blocks to the historydb at start, should the rebuild not be retried. Retrying the rebuild repeats the replacement.
+// The progress is passed to the function reportProgress, if not nil, periodically and at the end. The invalidation
+// of the rebuilt blocks is recorded for the commit listeners once the rebuilt history is swapped in.
+func (p *DBProvider) Rebuild(name string, sideProvider *DBProvider, blockStore *blkstorage.BlockStore,
+	fromBlock uint64, reportProgress func(*RebuildProgress)) error {
+	side := sideProvider.GetDBHandle(name)
+	side.views = p.views
+	bcInfo, err := blockStore.GetBlockchainInfo()
+	if err != nil {
+		return err
+	}
+
+	var startBlock uint64
+	sideSavepoint, err := side.GetLastSavepoint()
+	if err != nil {
+		return err
+	}
+	if sideSavepoint != nil {
+		startBlock = sideSavepoint.BlockNum + 1
+		logger.Infow("Resuming the interrupted history rebuild", "channel", name, "startBlock", startBlock)
+	} else {
+		var firstAvailableBlock uint64
+		if bcInfo.BootstrappingSnapshotInfo != nil {
+			firstAvailableBlock = bcInfo.BootstrappingSnapshotInfo.LastBlockInSnapshot + 1
+		}
+		if fromBlock != 0 && fromBlock < firstAvailableBlock {
+			return errors.Errorf("cannot rebuild history from block [%d] as the ledger is bootstrapped from a snapshot and the first available block is [%d]",
+				fromBlock, firstAvailableBlock)
+		}
+		if fromBlock >= bcInfo.Height {
+			return errors.Errorf("cannot rebuild history from block [%d] as the ledger height is [%d]", fromBlock, bcInfo.Height)
+		}
+		startBlock = fromBlock
+		if startBlock < firstAvailableBlock {
+			startBlock = firstAvailableBlock
+		}
+		if startBlock < bcInfo.Height {
+			// recorded in the side db so that the rebuild, if resumed, invalidates the blocks starting from startBlock
+			if err := sideProvider.RecordInvalidation(&ledger.HistoryInvalidation{
+				LedgerID:  name,
+				FromBlock: startBlock,
+				ToBlock:   bcInfo.Height - 1,
+				Reason:    ledger.HistoryInvalidationReasonHistoryRebuild,
+			}); err != nil {
+				return err
+			}
+		}
+		if startBlock > 0 {
+			if err := copyHistory(p.GetDBHandle(name), side, startBlock-1, false, nil); err != nil {
+				return err
+			}
+		}
+	}
+
+	logger.Infow("Rebuilding history", "channel", name, "startBlock", startBlock, "height", bcInfo.Height)
+	progress := &RebuildProgress{TotalBlocks: bcInfo.Height - startBlock}
+	if startBlock < bcInfo.Height {
+		if err := commitBlocks(side, blockStore, startBlock, bcInfo.Height, progress, reportProgress); err != nil {
+			return err
+		}
+	}
+	if reportProgress != nil {
+		reportProgress(progress)
+	}
+
+	if err := p.replaceHistory(name, side); err != nil {
+		return err
+	}
+	logger.Infow("Rebuilt history swapped in", "channel", name)
+	invalidations, err := sideProvider.pendingInvalidations(name)
+	if err != nil {
+		return err
+	}
+	for _, pending := range invalidations {
+		pending.invalidation.ToBlock = bcInfo.Height - 1
+		if err := p.RecordInvalidation(pending.invalidation); err != nil {
+			return err
+		}
+	}
+	return sideProvider.Drop(name)
+}
+
+// OnlineRebuild is a rebuild of the history of a ledger while the ledger is open (see function `PrepareRebuild`)
+type OnlineRebuild struct {
+	upgrade    *formatUpgrade
+	blockStore *blkstorage.BlockStore
+}
+
+// PrepareRebuild prepares rebuilding the history of this ledger from the blocks in the blockStore, starting from the
+// block fromBlock, while the blocks continue to be committed and the history continues to be served, unlike function
+// `Rebuild`, which requires the peer to be stopped. The entries for the blocks prior to fromBlock are carried over as
+// is and a fromBlock of 0 rebuilds the complete history, except for the entries for the blocks up to the snapshot
+// from which the ledger was bootstrapped, which are always carried over. The history is rebuilt as the history of the
+// next generation, in the configured index format, as for an upgrade of the index format (see function
+// `UpgradeFormat`), and is served when the ledger is opened next, at which time the invalidation of the rebuilt
+// blocks is recorded for the commit listeners. An error is returned if fromBlock is not indexed yet or if an upgrade
+// or a rebuild is in progress or pending the next opening of the ledger. The rebuild is performed by function `Run`,
+// which is expected to be invoked for the returned rebuild.
+func (d *DB) PrepareRebuild(fromBlock uint64, blockStore *blkstorage.BlockStore) (*OnlineRebuild, error) {
+	if d.format == nil {
+		return nil, errors.Errorf("history index format of channel [%s] not detected", d.name)
+	}
+	bcInfo, err := blockStore.GetBlockchainInfo()
+	if err != nil {
+		return nil, err
+	}
+	if bcInfo.BootstrappingSnapshotInfo != nil {
+		firstAvailableBlock := bcInfo.BootstrappingSnapshotInfo.LastBlockInSnapshot + 1
+		if fromBlock != 0 && fromBlock < firstAvailableBlock {
+			return nil, errors.Errorf("cannot rebuild history from block [%d] as the ledger is bootstrapped from a snapshot and the first available block is [%d]",
+				fromBlock, firstAvailableBlock)
+		}
+		if fromBlock < firstAvailableBlock {
+			fromBlock = firstAvailableBlock
+		}
+	}
+	target := d.provider.configuredIndexFormat()
+	target.Capabilities = mergeCapabilities(d.format.format.Capabilities, target.Capabilities)
+	upgrade, err := d.startNextGeneration(target, &fromBlock)
+	if err != nil {
+		return nil, err
+	}
+	return &OnlineRebuild{upgrade: upgrade, blockStore: blockStore}, nil
+}
+
+// Run rebuilds the history, as prepared by function `PrepareRebuild`. A rebuild interrupted by a stop of the peer, or
+// via the stop signal, in which case ErrRebuildStopped is returned, is to be prepared again
+func (r *OnlineRebuild) Run(stop <-chan struct{}) error {
+	d := r.upgrade.db
+	fromBlock := *r.upgrade.rebuildFrom
+	logger.Infow("Rebuilding history in the background", "channel", d.name, "startBlock", fromBlock)
+	if err := r.upgrade.run(r.blockStore, stop); err != nil {
+		if err == ErrFormatUpgradeStopped {
+			return ErrRebuildStopped
+		}
+		return err
+	}
+	logger.Infow("History rebuilt, the rebuilt history is served when the channel is opened next", "channel", d.name, "startBlock", fromBlock)
+	return nil
+}
+
+// recordRebuildInvalidation records the invalidation of the blocks rebuilt by a rebuild via function `PrepareRebuild`,
+// if this history was built by the rebuild, and then removes the record of the blocks rebuilt
+func (d *DB) recordRebuildInvalidation() error {
+	b, err := d.levelDB.Get(rebuildInvalidationKey)
+	if err != nil || b == nil {
+		return err
+	}
+	fromBlock, toBlock, err := decodeRebuildInvalidation(b)
+	if err != nil {
+		return err
+	}
+	if err := d.provider.RecordInvalidation(&ledger.HistoryInvalidation{
+		LedgerID:  d.name,
+		FromBlock: fromBlock,
+		ToBlock:   toBlock,
+		Reason:    ledger.HistoryInvalidationReasonHistoryRebuild,
+	}); err != nil {
+		return err
+	}
+	return d.levelDB.Delete(rebuildInvalidationKey, true)
+}
+
+func encodeRebuildInvalidation(fromBlock, toBlock uint64) []byte {
+	buf := proto.NewBuffer(nil)
+	_ = buf.EncodeVarint(fromBlock)
+	_ = buf.EncodeVarint(toBlock)
+	return buf.Bytes()
+}
+
+func decodeRebuildInvalidation(b []byte) (uint64, uint64, error) {
+	buf := proto.NewBuffer(b)
+	fromBlock, err := buf.DecodeVarint()
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "error while decoding the blocks rebuilt in the history")
+	}
+	toBlock, err := buf.DecodeVarint()
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "error while decoding the blocks rebuilt in the history")
+	}
+	return fromBlock, toBlock, nil
+}
+
+func commitBlocks(side *DB, blockStore *blkstorage.BlockStore, startBlock, height uint64,
+	progress *RebuildProgress, reportProgress func(*RebuildProgress)) error {
+	itr, err := blockStore.RetrieveBlocks(startBlock)
+	if err != nil {
+		return err
+	}
+	defer itr.Close()
+
+	startTime := time.Now()
+	lastReported := startTime
+	for blockNum := startBlock; blockNum < height; blockNum++ {
+		res, err := itr.Next()
+		if err != nil {
+			return err
+		}
+		if err := side.Commit(res.(*common.Block)); err != nil {
+			return err
+		}
+		progress.BlocksProcessed++
+
+		now := time.Now()
+		if now.Sub(lastReported) < rebuildProgressInterval && blockNum+1 < height {
+			continue
+		}
+		lastReported = now
+		progress.BlocksPerSec = float64(progress.BlocksProcessed) / now.Sub(startTime).Seconds()
+		progress.ETA = time.Duration(float64(progress.TotalBlocks-progress.BlocksProcessed) / progress.BlocksPerSec * float64(time.Second))
+		logger.Infow("Rebuilding history", "channel", side.name,
+			"blocksProcessed", progress.BlocksProcessed, "totalBlocks", progress.TotalBlocks,
+			"blocksPerSec", progress.BlocksPerSec, "eta", progress.ETA.Round(time.Second))
+		if reportProgress != nil && blockNum+1 < height {
+			reportProgress(progress)
+		}
+	}
+	return nil
+}
+
+// copyHistory copies the entries for the blocks up to and including lastBlock, along with the bookkeeping
+// information, from the historydb to the side db and sets the savepoint of the side db to lastBlock.
+// The index statistics are not copied but computed for the copied entries, as are the rows of the transaction index if
+// it is enabled for the side db. The rows of the history views and of the transaction index and the recorded value
+// sizes are copied for the copied entries only and the chaincode approvals for the blocks up to lastBlock only. If
+// convertKeys is true, the keys of the entries, including those in the rows and in the recorded value sizes, are
+// converted to the key normalization of the side db. The copy checks for the stop signal, if any, before writing each
+// batch and returns ErrFormatUpgradeStopped if signaled, as the copy is stopped only by an upgrade of the index format.
+func copyHistory(from, to *DB, lastBlock uint64, convertKeys bool, stop <-chan struct{}) error {
+	itr, err := from.levelDB.GetIterator(nil, nil)
+	if err != nil {
+		return err
+	}
+	defer itr.Release()
+
+	batch := to.levelDB.NewUpdateBatch()
+	statsTracker := newIndexStatsTracker(to)
+	for itr.Next() {
+		if err := itr.Error(); err != nil {
+			return errors.Wrap(err, "internal leveldb error while iterating for history entries")
+		}
+		key, val := itr.Key(), itr.Value()
+		// the tracked values of the keys may be for the blocks after lastBlock, which are indexed again
+		if bytes.Equal(key, savePointKey) || bytes.HasPrefix(key, indexStatsKeyPrefix) || bytes.HasPrefix(key, valueStateKeyPrefix) {
+			continue
+		}
+		if isDataKey(key) {
+			ns, k, blockNum, tranNum, err := decodeDataKey(key)
+			if err != nil {
+				return err
+			}
+			if blockNum > lastBlock {
+				continue
+			}
+			if convertKeys {
+				// a delta encoded value is stored in full, as its base may be merged with the entry of another form
+				// of the key
+				if isDeltaEncoded(val) {
+					km, err := from.decodeInlineEntry(ns, k, val)
+					if err != nil {
+						return err
+					}
+					if val, err = marshalKeyModification(km); err != nil {
+						return err
+					}
+				}
+				if normalizedKey := to.normalizeKey(ns, k); normalizedKey != k {
+					k = normalizedKey
+					key = constructDataKey(ns, k, blockNum, tranNum)
+				}
+			}
+			if err := statsTracker.add(ns, k, blockNum, len(key)+len(val)); err != nil {
+				return err
+			}
+			// the rows of the transaction index are added for the entries indexed before the index was enabled
+			to.addTxKeyRow(batch, blockNum, tranNum, key)
+		}
+		if bytes.HasPrefix(key, viewRowKeyPrefix) {
+			dataKey, err := decodeViewRowKey(key)
+			if err != nil {
+				return err
+			}
+			ns, k, blockNum, tranNum, err := decodeDataKey(dataKey)
+			if err != nil {
+				return err
+			}
+			if blockNum > lastBlock {
+				continue
+			}
+			if convertKeys {
+				if normalizedKey := to.normalizeKey(ns, k); normalizedKey != k {
+					key = append(append([]byte{}, key[:len(key)-len(dataKey)]...), constructDataKey(ns, normalizedKey, blockNum, tranNum)...)
+				}
+			}
+		}
+		if bytes.HasPrefix(key, txKeyKeyPrefix) {
+			blockNum, tranNum, entryKey, err := decodeTxKeyRowKey(key)
+			if err != nil {
+				return err
+			}
+			if blockNum > lastBlock {
+				continue
+			}
+			if convertKeys {
+				ns, k, _, _, err := decodeDataKey(entryKey)
+				if err != nil {
+					return err
+				}
+				if normalizedKey := to.normalizeKey(ns, k); normalizedKey != k {
+					key = append(constructTxKeyPrefix(blockNum, tranNum), constructDataKey(ns, normalizedKey, blockNum, tranNum)...)
+				}
+			}
+		}
+		if bytes.HasPrefix(key, valueSizeKeyPrefix) {
+			ns, k, blockNum, tranNum, err := decodeDataKey(decodeValueSizeKey(key))
+			if err != nil {
+				return err
+			}
+			if blockNum > lastBlock {
+				continue
+			}
+			if convertKeys {
+				if normalizedKey := to.normalizeKey(ns, k); normalizedKey != k {
+					key = constructValueSizeKey(constructDataKey(ns, normalizedKey, blockNum, tranNum))
+				}
+			}
+		}
+		if bytes.HasPrefix(key, lifecycleApprovalKeyPrefix) {
+			blockNum, err := decodeLifecycleApprovalBlockNum(key)
+			if err != nil {
+				return err
+			}
+			if blockNum > lastBlock {
+				continue
+			}
+		}
+		batch.Put(key, val)
+		if batch.Size() >= rebuildSwapBatchSize {
+			select {
+			case <-stop:
+				return ErrFormatUpgradeStopped
+			default:
+			}
+			statsTracker.flush(batch)
+			if err := to.levelDB.WriteBatch(batch, true); err != nil {
+				return err
+			}
+			batch.Reset()
+		}
+	}
+	batch.Put(savePointKey, version.NewHeight(lastBlock, math.MaxUint64).ToBytes())
+	statsTracker.flush(batch)
+	return to.levelDB.WriteBatch(batch, true)
+}
+
+// replaceHistory replaces the history for the ledger `name` with the content of the side db `from`
+func (p *DBProvider) replaceHistory(name string, from *DB) error {
+	if err := p.GetDBHandle(name).levelDB.Delete(savePointKey, true); err != nil {
+		return err
+	}
+	if err := p.dropHistory(name); err != nil {
+		return err
+	}
+	to := p.GetDBHandle(name)
+
+	itr, err := from.levelDB.GetIterator(nil, nil)
+	if err != nil {
+		return err
+	}
+	defer itr.Release()
+
+	var savepoint []byte
+	batch := to.levelDB.NewUpdateBatch()
+	for itr.Next() {
+		if err := itr.Error(); err != nil {
+			return errors.Wrap(err, "internal leveldb error while iterating for history entries")
+		}
+		key := itr.Key()
+		if bytes.Equal(key, savePointKey) {
+			savepoint = append([]byte{}, itr.Value()...)
+			continue
+		}
+		batch.Put(key, itr.Value())
+		if batch.Size() >= rebuildSwapBatchSize {
+			if err := to.levelDB.WriteBatch(batch, true); err != nil {
+				return err
+			}
+			batch.Reset()
+		}
+	}
+	if savepoint != nil {
+		batch.Put(savePointKey, savepoint)
+	}
+	return to.levelDB.WriteBatch(batch, true)
+}
@@ -0,0 +1,273 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric/common/ledger/testutil"
+	util2 "github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/hyperledger/fabric/core/ledger/internal/version"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRebuild(t *testing.T) {
+	env := newTestHistoryEnv(t)
+	defer env.cleanup()
+	store, err := env.testBlockStorageEnv.provider.Open("ledger1")
+	require.NoError(t, err)
+	defer store.Shutdown()
+
+	historydb := env.testHistoryDBProvider.GetDBHandle("ledger1")
+	otherHistorydb := env.testHistoryDBProvider.GetDBHandle("ledger2")
+	bg, gb := testutil.NewBlockGenerator(t, "ledger1", false)
+	require.NoError(t, store.AddBlock(gb))
+	require.NoError(t, historydb.Commit(gb))
+	blocks := []*common.Block{gb}
+	for i := 1; i <= 5; i++ {
+		simulator, err := env.txmgr.NewTxSimulator(util2.GenerateUUID())
+		require.NoError(t, err)
+		require.NoError(t, simulator.SetState("ns1", "key1", []byte{byte(i)}))
+		simulator.Done()
+		simRes, err := simulator.GetTxSimulationResults()
+		require.NoError(t, err)
+		pubSimResBytes, err := simRes.GetPubSimulationBytes()
+		require.NoError(t, err)
+		block := bg.NextBlock([][]byte{pubSimResBytes})
+		require.NoError(t, store.AddBlock(block))
+		require.NoError(t, historydb.Commit(block))
+		require.NoError(t, otherHistorydb.Commit(block))
+		blocks = append(blocks, block)
+	}
+
+	// lose a history entry in the middle
+	require.NoError(t, historydb.levelDB.Delete(constructDataKey("ns1", "key1", 3, 0), true))
+	qe, err := historydb.NewQueryExecutor(store)
+	require.NoError(t, err)
+	testutilVerifyResults(t, qe, "ns1", "key1", []string{"\x05", "\x04", "\x02", "\x01"})
+
+	// simulate an interrupted rebuild, which has processed the blocks up to block 2
+	sideProvider, err := NewDBProvider(t.TempDir())
+	require.NoError(t, err)
+	defer sideProvider.Close()
+	side := sideProvider.GetDBHandle("ledger1")
+	for _, b := range blocks[:3] {
+		require.NoError(t, side.Commit(b))
+	}
+
+	var reported []*RebuildProgress
+	reportProgress := func(p *RebuildProgress) {
+		c := *p
+		reported = append(reported, &c)
+	}
+	require.NoError(t, env.testHistoryDBProvider.Rebuild("ledger1", sideProvider, store, 0, reportProgress))
+
+	require.NotEmpty(t, reported)
+	lastReported := reported[len(reported)-1]
+	require.Equal(t, uint64(3), lastReported.BlocksProcessed)
+	require.Equal(t, uint64(3), lastReported.TotalBlocks)
+	require.Zero(t, lastReported.ETA)
+
+	historydb = env.testHistoryDBProvider.GetDBHandle("ledger1")
+	qe, err = historydb.NewQueryExecutor(store)
+	require.NoError(t, err)
+	testutilVerifyResults(t, qe, "ns1", "key1", []string{"\x05", "\x04", "\x03", "\x02", "\x01"})
+	savepoint, err := historydb.GetLastSavepoint()
+	require.NoError(t, err)
+	require.Equal(t, version.NewHeight(5, 1), savepoint)
+
+	// the side db is cleaned up
+	empty, err := sideProvider.GetDBHandle("ledger1").levelDB.IsEmpty()
+	require.NoError(t, err)
+	require.True(t, empty)
+
+	// the history of the other ledger is not touched
+	qe, err = otherHistorydb.NewQueryExecutor(store)
+	require.NoError(t, err)
+	testutilVerifyResults(t, qe, "ns1", "key1", []string{"\x05", "\x04", "\x03", "\x02", "\x01"})
+
+	// rebuilding again, with nothing to resume from, rebuilds the history from the genesis block
+	reported = nil
+	require.NoError(t, env.testHistoryDBProvider.Rebuild("ledger1", sideProvider, store, 0, reportProgress))
+	require.Equal(t, uint64(6), reported[len(reported)-1].BlocksProcessed)
+	qe, err = env.testHistoryDBProvider.GetDBHandle("ledger1").NewQueryExecutor(store)
+	require.NoError(t, err)
+	testutilVerifyResults(t, qe, "ns1", "key1", []string{"\x05", "\x04", "\x03", "\x02", "\x01"})
+}
+
+func TestCopyHistory(t *testing.T) {
+	env := newTestHistoryEnv(t)
+	defer env.cleanup()
+
+	from := env.testHistoryDBProvider.GetDBHandle("from")
+	to := env.testHistoryDBProvider.GetDBHandle("to")
+	require.NoError(t, from.levelDB.Put(constructDataKey("ns", "key", 1, 0), []byte("imported"), true))
+	require.NoError(t, from.levelDB.Put(constructDataKey("ns", "key", 3, 0), []byte{}, true))
+	require.NoError(t, from.levelDB.Put(backfillProgressKey, (&BackfillProgress{Done: true}).toBytes(), true))
+	require.NoError(t, from.levelDB.Put(savePointKey, version.NewHeight(3, 0).ToBytes(), true))
+
+	require.NoError(t, copyHistory(from, to, 2, false, nil))
+
+	val, err := to.levelDB.Get(constructDataKey("ns", "key", 1, 0))
+	require.NoError(t, err)
+	require.Equal(t, []byte("imported"), val)
+	val, err = to.levelDB.Get(constructDataKey("ns", "key", 3, 0))
+	require.NoError(t, err)
+	require.Nil(t, val)
+	progress, err := to.BackfillProgress()
+	require.NoError(t, err)
+	require.True(t, progress.Done)
+	savepoint, err := to.GetLastSavepoint()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), savepoint.BlockNum)
+}
+
+func TestPartialRebuild(t *testing.T) {
+	env := newTestHistoryEnv(t)
+	defer env.cleanup()
+	store, err := env.testBlockStorageEnv.provider.Open("ledger1")
+	require.NoError(t, err)
+	defer store.Shutdown()
+
+	historydb := env.testHistoryDBProvider.GetDBHandle("ledger1")
+	bg, gb := testutil.NewBlockGenerator(t, "ledger1", false)
+	require.NoError(t, store.AddBlock(gb))
+	require.NoError(t, historydb.Commit(gb))
+	for i := 1; i <= 5; i++ {
+		simulator, err := env.txmgr.NewTxSimulator(util2.GenerateUUID())
+		require.NoError(t, err)
+		require.NoError(t, simulator.SetState("ns1", "key1", []byte{byte(i)}))
+		simulator.Done()
+		simRes, err := simulator.GetTxSimulationResults()
+		require.NoError(t, err)
+		pubSimResBytes, err := simRes.GetPubSimulationBytes()
+		require.NoError(t, err)
+		block := bg.NextBlock([][]byte{pubSimResBytes})
+		require.NoError(t, store.AddBlock(block))
+		require.NoError(t, historydb.Commit(block))
+	}
+
+	// lose the entries for the blocks 2 and 4
+	require.NoError(t, historydb.levelDB.Delete(constructDataKey("ns1", "key1", 2, 0), true))
+	require.NoError(t, historydb.levelDB.Delete(constructDataKey("ns1", "key1", 4, 0), true))
+
+	sideProvider, err := NewDBProvider(t.TempDir())
+	require.NoError(t, err)
+	defer sideProvider.Close()
+
+	t.Run("invalid-from-block", func(t *testing.T) {
+		err := env.testHistoryDBProvider.Rebuild("ledger1", sideProvider, store, 6, nil)
+		require.EqualError(t, err, "cannot rebuild history from block [6] as the ledger height is [6]")
+	})
+
+	t.Run("rebuild-from-block-3", func(t *testing.T) {
+		var lastReported *RebuildProgress
+		require.NoError(t, env.testHistoryDBProvider.Rebuild("ledger1", sideProvider, store, 3,
+			func(p *RebuildProgress) { lastReported = p },
+		))
+		require.Equal(t, uint64(3), lastReported.BlocksProcessed)
+
+		// entry for the block 4 is rebuilt, whereas the history prior to block 3 is preserved as is
+		qe, err := env.testHistoryDBProvider.GetDBHandle("ledger1").NewQueryExecutor(store)
+		require.NoError(t, err)
+		testutilVerifyResults(t, qe, "ns1", "key1", []string{"\x05", "\x04", "\x03", "\x01"})
+		savepoint, err := env.testHistoryDBProvider.GetDBHandle("ledger1").GetLastSavepoint()
+		require.NoError(t, err)
+		require.Equal(t, version.NewHeight(5, 1), savepoint)
+	})
+}
+
+func TestOnlineRebuild(t *testing.T) {
+	env := newTestHistoryEnv(t)
+	defer env.cleanup()
+	provider := env.testHistoryDBProvider
+	store, err := env.testBlockStorageEnv.provider.Open("ledger1")
+	require.NoError(t, err)
+	defer store.Shutdown()
+
+	historydb := provider.GetDBHandle("ledger1")
+	_, err = historydb.PrepareRebuild(0, store)
+	require.EqualError(t, err, "history index format of channel [ledger1] not detected")
+	require.NoError(t, historydb.DetectIndexFormat())
+	_, err = historydb.PrepareRebuild(0, store)
+	require.EqualError(t, err, "cannot rebuild the history of channel [ledger1] as no block is indexed yet")
+
+	bg, gb := testutil.NewBlockGenerator(t, "ledger1", false)
+	commit := func(block *common.Block) {
+		require.NoError(t, store.AddBlock(block))
+		require.NoError(t, historydb.Commit(block))
+	}
+	commitValue := func(i int) {
+		simulator, err := env.txmgr.NewTxSimulator(util2.GenerateUUID())
+		require.NoError(t, err)
+		require.NoError(t, simulator.SetState("ns1", "key1", []byte{byte(i)}))
+		simulator.Done()
+		simRes, err := simulator.GetTxSimulationResults()
+		require.NoError(t, err)
+		pubSimResBytes, err := simRes.GetPubSimulationBytes()
+		require.NoError(t, err)
+		commit(bg.NextBlock([][]byte{pubSimResBytes}))
+	}
+	commit(gb)
+	for i := 1; i <= 3; i++ {
+		commitValue(i)
+	}
+	_, err = historydb.PrepareRebuild(4, store)
+	require.EqualError(t, err, "cannot rebuild history from block [4] as the history of channel [ledger1] is indexed up to block [3]")
+
+	// the entry lost for block 2 is restored by the rebuild
+	require.NoError(t, historydb.levelDB.Delete(constructDataKey("ns1", "key1", 2, 0), true))
+	stop := make(chan struct{})
+	close(stop)
+	rebuild, err := historydb.PrepareRebuild(2, store)
+	require.NoError(t, err)
+	require.Equal(t, ErrRebuildStopped, rebuild.Run(stop))
+	rebuild, err = historydb.PrepareRebuild(2, store)
+	require.NoError(t, err)
+	_, err = historydb.PrepareRebuild(1, store)
+	require.EqualError(t, err, "an upgrade or a rebuild of the history of channel [ledger1] is in progress or pending the next opening of the channel")
+	require.NoError(t, rebuild.Run(nil))
+
+	// the history continues to be served as is and the blocks committed after the rebuild are indexed in both the
+	// histories until the ledger is opened next
+	commitValue(4)
+	qe, err := historydb.NewQueryExecutor(store)
+	require.NoError(t, err)
+	testutilVerifyResults(t, qe, "ns1", "key1", []string{"\x04", "\x03", "\x01"})
+	_, err = historydb.PrepareRebuild(1, store)
+	require.EqualError(t, err, "an upgrade or a rebuild of the history of channel [ledger1] is in progress or pending the next opening of the channel")
+
+	historydb = provider.GetDBHandle("ledger1")
+	require.NoError(t, historydb.DetectIndexFormat())
+	qe, err = historydb.NewQueryExecutor(store)
+	require.NoError(t, err)
+	testutilVerifyResults(t, qe, "ns1", "key1", []string{"\x04", "\x03", "\x02", "\x01"})
+	savepoint, err := historydb.GetLastSavepoint()
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), savepoint.BlockNum)
+
+	// the invalidation of the rebuilt blocks is recorded along with the drop of the previous history
+	require.NoError(t, historydb.UpgradeFormat(store, nil))
+	invalidations, err := provider.pendingInvalidations("ledger1")
+	require.NoError(t, err)
+	require.Len(t, invalidations, 1)
+	require.Equal(t, &ledger.HistoryInvalidation{
+		LedgerID:  "ledger1",
+		FromBlock: 2,
+		ToBlock:   3,
+		Reason:    ledger.HistoryInvalidationReasonHistoryRebuild,
+	}, invalidations[0].invalidation)
+	empty, err := provider.leveldbProvider.GetDBHandle("ledger1").IsEmpty()
+	require.NoError(t, err)
+	require.True(t, empty)
+	require.NoError(t, historydb.UpgradeFormat(store, nil))
+	invalidations, err = provider.pendingInvalidations("ledger1")
+	require.NoError(t, err)
+	require.Len(t, invalidations, 1)
+}
@@ -0,0 +1,125 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/hyperledger/fabric/common/ledger/dataformat"
+	"github.com/hyperledger/fabric/common/ledger/util/leveldbhelper"
+	"github.com/hyperledger/fabric/common/metrics/disabled"
+	"github.com/hyperledger/fabric/internal/fileutil"
+	"github.com/pkg/errors"
+)
+
+// NewReadOnlyDBProvider opens, for reads only, the history database at the given path. This is intended for serving
+// the history queries from a replica created via function `Checkpoint`, for instance, to run analytics queries in a
+// separate process without contending with the writes of the committing peer. As leveldb does not allow for concurrent
+// access, the history database of a running peer cannot be opened via this function. The commits to the historydbs
+// obtained from the returned provider fail. The history views of the replica can be queried once the views, the field
+// indexes, or the full-text search are registered with the returned provider, as with the committing peer.
+func NewReadOnlyDBProvider(path string) (*DBProvider, error) {
+	logger.Debugf("constructing read-only HistoryDBProvider dbPath=%s", path)
+	levelDBProvider, err := leveldbhelper.NewProvider(
+		&leveldbhelper.Conf{
+			DBPath:         path,
+			ExpectedFormat: dataformat.CurrentFormat,
+			ReadOnly:       true,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &DBProvider{
+		leveldbProvider: levelDBProvider,
+		stats:           newStats(&disabled.Provider{}),
+		scanners:        newScannerRegistry(),
+	}, nil
+}
+
+// Checkpoint writes a replica of the history for this ledger to a new history database at the given dir. The replica
+// is consistent as of the point in time when the checkpoint starts, as the history is read from a point in time view
+// of the historydb, while the block commits continue. The key modifications are resolved from the block store and
+// stored inline in the replica, so that the replica can serve the history queries without the block store. The index
+// statistics, the rows of the history views, and the savepoint are carried over as is. The replica can be opened via
+// function `NewReadOnlyDBProvider` and, for a refresh, a new checkpoint is expected to be created in a different dir.
+func (d *DB) Checkpoint(dir string, txFetcher TxFetcher) error {
+	if _, err := fileutil.CreateDirIfMissing(dir); err != nil {
+		return err
+	}
+	empty, err := fileutil.DirEmpty(dir)
+	if err != nil {
+		return err
+	}
+	if !empty {
+		return errors.Errorf("dir [%s] for the history checkpoint is not empty", dir)
+	}
+
+	itr, err := d.levelDB.GetIterator(nil, nil)
+	if err != nil {
+		return err
+	}
+	defer itr.Release()
+
+	replicaProvider, err := NewDBProvider(dir)
+	if err != nil {
+		return err
+	}
+	defer replicaProvider.Close()
+	replica := replicaProvider.GetDBHandle(d.name)
+
+	batch := replica.levelDB.NewUpdateBatch()
+	put := func(key, val []byte) error {
+		batch.Put(key, val)
+		if batch.Size() >= importHistoryBatchSize {
+			if err := replica.levelDB.WriteBatch(batch, true); err != nil {
+				return err
+			}
+			batch.Reset()
+		}
+		return nil
+	}
+	var numEntries uint64
+	resolver := d.newResolvePool(txFetcher, func(key []byte, keyModification *queryresult.KeyModification) error {
+		val, err := proto.Marshal(keyModification)
+		if err != nil {
+			return errors.Wrap(err, "error while marshalling key modification")
+		}
+		numEntries++
+		return put(key, val)
+	})
+	defer resolver.close()
+	for itr.Next() {
+		if err := itr.Error(); err != nil {
+			return errors.Wrap(err, "internal leveldb error while iterating for history entries")
+		}
+		key, val := itr.Key(), itr.Value()
+		if isDataKey(key) && len(val) == 0 {
+			if err := resolver.add(key, val); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := put(key, val); err != nil {
+			return err
+		}
+	}
+	if err := resolver.flush(); err != nil {
+		return err
+	}
+	if err := replica.levelDB.WriteBatch(batch, true); err != nil {
+		return err
+	}
+	// the index format is carried over, so that the queries to the replica are served in the format of the history
+	if d.format != nil {
+		if err := replicaProvider.writeIndexFormat(d.name, &indexFormatDescriptor{format: d.format.format}); err != nil {
+			return err
+		}
+	}
+	logger.Infow("Created history checkpoint", "channel", d.name, "dir", dir, "resolvedEntries", numEntries)
+	return nil
+}
@@ -0,0 +1,89 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/ledger/testutil"
+	util2 "github.com/hyperledger/fabric/common/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckpointAndReadOnlyReplica(t *testing.T) {
+	env := newTestHistoryEnv(t)
+	defer env.cleanup()
+	store, err := env.testBlockStorageEnv.provider.Open("ledger1")
+	require.NoError(t, err)
+	defer store.Shutdown()
+
+	require.NoError(t, env.testHistoryDBProvider.EnableFieldIndexes(map[string][]string{"ns1": {"owner"}}))
+	historydb := env.testHistoryDBProvider.GetDBHandle("ledger1")
+	bg, gb := testutil.NewBlockGenerator(t, "ledger1", false)
+	require.NoError(t, store.AddBlock(gb))
+	require.NoError(t, historydb.Commit(gb))
+	commit := func(owner string) {
+		simulator, err := env.txmgr.NewTxSimulator(util2.GenerateUUID())
+		require.NoError(t, err)
+		require.NoError(t, simulator.SetState("ns1", "key1", []byte(`{"owner":"`+owner+`"}`)))
+		simulator.Done()
+		simRes, err := simulator.GetTxSimulationResults()
+		require.NoError(t, err)
+		pubSimResBytes, err := simRes.GetPubSimulationBytes()
+		require.NoError(t, err)
+		block := bg.NextBlock([][]byte{pubSimResBytes})
+		require.NoError(t, store.AddBlock(block))
+		require.NoError(t, historydb.Commit(block))
+	}
+	commit("alice")
+	commit("bob")
+
+	replicaDir := filepath.Join(t.TempDir(), "replica")
+	require.NoError(t, historydb.Checkpoint(replicaDir, store))
+	// the commits after the checkpoint are not in the replica
+	commit("alice")
+
+	replicaProvider, err := NewReadOnlyDBProvider(replicaDir)
+	require.NoError(t, err)
+	defer replicaProvider.Close()
+	require.NoError(t, replicaProvider.EnableFieldIndexes(map[string][]string{"ns1": {"owner"}}))
+	replica := replicaProvider.GetDBHandle("ledger1")
+
+	savepoint, err := replica.GetLastSavepoint()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), savepoint.BlockNum)
+
+	// the replica serves the queries without the block store
+	qe, err := replica.NewQueryExecutor(nil)
+	require.NoError(t, err)
+	testutilVerifyResults(t, qe, "ns1", "key1", []string{`{"owner":"bob"}`, `{"owner":"alice"}`})
+	var blockNums []uint64
+	require.NoError(t, replica.GetHistoryByField("ns1", "owner", "alice", nil, func(e *Entry) error {
+		blockNums = append(blockNums, e.BlockNum)
+		return nil
+	}))
+	require.Equal(t, []uint64{1}, blockNums)
+	stats, err := replica.GetIndexStats("ns1")
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), stats.TotalIndexEntries)
+
+	require.Error(t, replica.Commit(bg.NextBlock(nil)))
+
+	t.Run("non-empty-dir", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "file"), []byte("content"), 0o644))
+		err := historydb.Checkpoint(dir, store)
+		require.EqualError(t, err, "dir ["+dir+"] for the history checkpoint is not empty")
+	})
+
+	t.Run("non-existent-replica", func(t *testing.T) {
+		_, err := NewReadOnlyDBProvider(t.TempDir())
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,244 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/hyperledger/fabric-protos-go/ledger/rwset/kvrwset"
+	"github.com/hyperledger/fabric/common/ledger/blkstorage"
+	"github.com/hyperledger/fabric/core/ledger/internal/version"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/rwsetutil"
+	"github.com/pkg/errors"
+)
+
+// IndexBatch is the set of the history index entries added for a block. The index batches are streamed, in the
+// order of the blocks, from a peer that maintains the history to a subscriber, such as a standby indexer or a peer
+// that replicates the history instead of building it from the blocks.
+type IndexBatch struct {
+	BlockNum uint64
+	// NumTxs is the number of transactions in the block
+	NumTxs  uint64
+	Entries []*IndexBatchEntry
+	// Digest is the hash of the block number, the number of transactions, and the entries. Two peers that index
+	// the same block produce the same digest, regardless of the history views configured on them
+	Digest []byte
+}
+
+// IndexBatchEntry is a history index entry along with the key modification, so that a subscriber
+// can serve the history queries for the entry without the block
+type IndexBatchEntry struct {
+	Key             []byte
+	KeyModification *queryresult.KeyModification
+}
+
+// NewIndexBatch returns the index batch for the block, as indexed by function `Commit`. The batch includes the writes
+// that are not indexed by the peer, i.e., those to the namespaces for which the history indexing is disabled and to
+// the keys excluded by the key indexing policies, so that the batch does not depend on the configuration of the peer.
+// Such writes are skipped by function `ApplyIndexBatch` as per the configuration of the subscriber
+func (d *DB) NewIndexBatch(block *common.Block) (*IndexBatch, error) {
+	blockNum := block.Header.Number
+	batch := &IndexBatch{BlockNum: blockNum}
+	numTxs, err := d.visitBlockWrites(block, func(tranNo uint64, chdr *common.ChannelHeader, ns string, kvWrite *kvrwset.KVWrite) error {
+		batch.Entries = append(batch.Entries, &IndexBatchEntry{
+			Key: constructDataKey(ns, d.normalizeKey(ns, kvWrite.Key), blockNum, tranNo),
+			KeyModification: &queryresult.KeyModification{
+				TxId:      chdr.TxId,
+				Value:     kvWrite.Value,
+				Timestamp: chdr.Timestamp,
+				IsDelete:  rwsetutil.IsKVWriteDelete(kvWrite),
+			},
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	batch.NumTxs = numTxs
+	sort.Slice(batch.Entries, func(i, j int) bool {
+		return bytes.Compare(batch.Entries[i].Key, batch.Entries[j].Key) < 0
+	})
+	batch.Digest = batch.computeDigest()
+	return batch, nil
+}
+
+// StreamIndexBatches invokes the function send with the index batches for the blocks starting from fromBlock up to
+// the savepoint of the historydb. A subscriber resumes the stream by passing the block following its own savepoint
+// (see function `NextIndexBatchBlock`). The stream stops at the first error returned by send and returns that error.
+func (d *DB) StreamIndexBatches(fromBlock uint64, blockStore *blkstorage.BlockStore, send func(*IndexBatch) error) error {
+	savepoint, err := d.GetLastSavepoint()
+	if err != nil {
+		return err
+	}
+	if savepoint == nil {
+		return errors.Errorf("no history found for ledger [%s]", d.name)
+	}
+	if fromBlock > savepoint.BlockNum+1 {
+		return errors.Errorf("cannot stream index batches from block [%d] as the history savepoint is at block [%d]",
+			fromBlock, savepoint.BlockNum)
+	}
+	if fromBlock == savepoint.BlockNum+1 {
+		return nil
+	}
+	bcInfo, err := blockStore.GetBlockchainInfo()
+	if err != nil {
+		return err
+	}
+	if bcInfo.BootstrappingSnapshotInfo != nil && fromBlock <= bcInfo.BootstrappingSnapshotInfo.LastBlockInSnapshot {
+		return errors.Errorf("cannot stream index batches from block [%d] as the ledger is bootstrapped from a snapshot and the first available block is [%d]",
+			fromBlock, bcInfo.BootstrappingSnapshotInfo.LastBlockInSnapshot+1)
+	}
+
+	itr, err := blockStore.RetrieveBlocks(fromBlock)
+	if err != nil {
+		return err
+	}
+	defer itr.Close()
+	for blockNum := fromBlock; blockNum <= savepoint.BlockNum; blockNum++ {
+		res, err := itr.Next()
+		if err != nil {
+			return err
+		}
+		batch, err := d.NewIndexBatch(res.(*common.Block))
+		if err != nil {
+			return err
+		}
+		if err := send(batch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NextIndexBatchBlock returns the number of the block whose index batch is expected to be applied next
+func (d *DB) NextIndexBatchBlock() (uint64, error) {
+	savepoint, err := d.GetLastSavepoint()
+	if err != nil || savepoint == nil {
+		return 0, err
+	}
+	return savepoint.BlockNum + 1, nil
+}
+
+// ApplyIndexBatch adds the entries of an index batch received from the stream to the historydb, along with the
+// updates to the index statistics and the rows of the history views, and moves the savepoint to the block of the
+// batch. The batch is expected to be for the block following the savepoint and to match its digest. The key
+// modifications are stored inline, as with the entries imported from a snapshot.
+func (d *DB) ApplyIndexBatch(batch *IndexBatch) error {
+	if !bytes.Equal(batch.Digest, batch.computeDigest()) {
+		return errors.Errorf("digest mismatch for the index batch for block [%d]", batch.BlockNum)
+	}
+	nextBlock, err := d.NextIndexBatchBlock()
+	if err != nil {
+		return err
+	}
+	if batch.BlockNum != nextBlock {
+		return errors.Errorf("index batch for block [%d] cannot be applied, expected the batch for block [%d]", batch.BlockNum, nextBlock)
+	}
+
+	d.statsLock.Lock()
+	defer d.statsLock.Unlock()
+	if err := d.flushPendingLocked(); err != nil {
+		return err
+	}
+	statsTracker := newIndexStatsTracker(d)
+	dbBatch := d.levelDB.NewUpdateBatch()
+	for _, e := range batch.Entries {
+		if e.KeyModification == nil {
+			return errors.Errorf("index batch for block [%d] contains an entry without the key modification", batch.BlockNum)
+		}
+		ns, key, blockNum, tranNum, err := decodeDataKey(e.Key)
+		if err != nil {
+			return err
+		}
+		if blockNum != batch.BlockNum {
+			return errors.Errorf("index batch for block [%d] contains an entry for block [%d]", batch.BlockNum, blockNum)
+		}
+		if !d.isKeyIndexed(ns, key) {
+			continue
+		}
+		if _, ok := d.skipUnchangedNamespaces[ns]; ok {
+			unchanged, err := statsTracker.isUnchanged(ns, key, e.KeyModification.Value, e.KeyModification.IsDelete)
+			if err != nil {
+				return err
+			}
+			if unchanged {
+				continue
+			}
+		}
+		val, err := statsTracker.marshalInline(ns, key, blockNum, tranNum, e.KeyModification)
+		if err != nil {
+			return err
+		}
+		dbBatch.Put(e.Key, val)
+		if _, ok := d.valueSizeNamespaces[ns]; ok {
+			addValueSize(dbBatch, e.Key, len(e.KeyModification.Value), e.KeyModification.IsDelete)
+		}
+		d.addTxKeyRow(dbBatch, blockNum, tranNum, e.Key)
+		if err := statsTracker.add(ns, key, blockNum, len(e.Key)+len(val)); err != nil {
+			return err
+		}
+		if err := d.addViewRows(dbBatch, e.Key, ns, key, e.KeyModification.Value, e.KeyModification.IsDelete); err != nil {
+			return err
+		}
+	}
+	dbBatch.Put(savePointKey, version.NewHeight(batch.BlockNum, batch.NumTxs).ToBytes())
+	batchKeys := statsTracker.flush(dbBatch)
+	if err := d.levelDB.WriteBatch(dbBatch, true); err != nil {
+		return err
+	}
+	d.noHistoryCache.invalidate(batchKeys)
+	d.queryResultCache.invalidate(batchKeys, batch.BlockNum+1)
+	return nil
+}
+
+// VerifyIndexBatch verifies that an index batch received from the stream, including its entries, is equivalent to the
+// index batch built locally from the block
+func (d *DB) VerifyIndexBatch(batch *IndexBatch, block *common.Block) error {
+	local, err := d.NewIndexBatch(block)
+	if err != nil {
+		return err
+	}
+	if batch.BlockNum != local.BlockNum || !bytes.Equal(batch.Digest, local.Digest) || !bytes.Equal(batch.computeDigest(), local.Digest) {
+		return errors.Errorf("index batch for block [%d] with digest [%x] does not match the locally built index batch for block [%d] with digest [%x]",
+			batch.BlockNum, batch.Digest, local.BlockNum, local.Digest)
+	}
+	return nil
+}
+
+func (b *IndexBatch) computeDigest() []byte {
+	h := sha256.New()
+	writeUint64 := func(n uint64) {
+		var buf [8]byte
+		binary.BigEndian.PutUint64(buf[:], n)
+		h.Write(buf[:])
+	}
+	writeBytes := func(b []byte) {
+		writeUint64(uint64(len(b)))
+		h.Write(b)
+	}
+	writeUint64(b.BlockNum)
+	writeUint64(b.NumTxs)
+	for _, e := range b.Entries {
+		writeBytes(e.Key)
+		km := e.KeyModification
+		writeBytes([]byte(km.GetTxId()))
+		writeBytes(km.GetValue())
+		writeUint64(uint64(km.GetTimestamp().GetSeconds()))
+		writeUint64(uint64(km.GetTimestamp().GetNanos()))
+		if km.GetIsDelete() {
+			writeUint64(1)
+		} else {
+			writeUint64(0)
+		}
+	}
+	return h.Sum(nil)
+}
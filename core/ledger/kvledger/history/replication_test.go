@@ -0,0 +1,113 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric/common/ledger/testutil"
+	util2 "github.com/hyperledger/fabric/common/util"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndexBatchReplication(t *testing.T) {
+	env := newTestHistoryEnv(t)
+	defer env.cleanup()
+	store, err := env.testBlockStorageEnv.provider.Open("ledger1")
+	require.NoError(t, err)
+	defer store.Shutdown()
+
+	publisher := env.testHistoryDBProvider.GetDBHandle("ledger1")
+	bg, gb := testutil.NewBlockGenerator(t, "ledger1", false)
+	require.NoError(t, store.AddBlock(gb))
+	require.NoError(t, publisher.Commit(gb))
+	blocks := []*common.Block{gb}
+	for i := 1; i <= 4; i++ {
+		simulator, err := env.txmgr.NewTxSimulator(util2.GenerateUUID())
+		require.NoError(t, err)
+		require.NoError(t, simulator.SetState("ns1", "key1", []byte{byte(i)}))
+		if i == 3 {
+			require.NoError(t, simulator.DeleteState("ns1", "key2"))
+		} else {
+			require.NoError(t, simulator.SetState("ns1", "key2", []byte{byte(i)}))
+		}
+		simulator.Done()
+		simRes, err := simulator.GetTxSimulationResults()
+		require.NoError(t, err)
+		pubSimResBytes, err := simRes.GetPubSimulationBytes()
+		require.NoError(t, err)
+		block := bg.NextBlock([][]byte{pubSimResBytes})
+		require.NoError(t, store.AddBlock(block))
+		require.NoError(t, publisher.Commit(block))
+		blocks = append(blocks, block)
+	}
+
+	subscriberProvider, err := NewDBProvider(t.TempDir())
+	require.NoError(t, err)
+	defer subscriberProvider.Close()
+	subscriber := subscriberProvider.GetDBHandle("ledger1")
+
+	// the subscriber applies the batches up to block 2 and then the stream is interrupted
+	errInterrupted := errors.New("interrupted")
+	err = publisher.StreamIndexBatches(0, store, func(b *IndexBatch) error {
+		if b.BlockNum > 2 {
+			return errInterrupted
+		}
+		require.NoError(t, publisher.VerifyIndexBatch(b, blocks[b.BlockNum]))
+		return subscriber.ApplyIndexBatch(b)
+	})
+	require.Equal(t, errInterrupted, err)
+
+	// the stream resumes from the savepoint of the subscriber
+	nextBlock, err := subscriber.NextIndexBatchBlock()
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), nextBlock)
+	require.NoError(t, publisher.StreamIndexBatches(nextBlock, store, subscriber.ApplyIndexBatch))
+
+	publisherSavepoint, err := publisher.GetLastSavepoint()
+	require.NoError(t, err)
+	subscriberSavepoint, err := subscriber.GetLastSavepoint()
+	require.NoError(t, err)
+	require.Equal(t, publisherSavepoint, subscriberSavepoint)
+
+	// the subscriber serves the history queries without the block store
+	qe, err := subscriber.NewQueryExecutor(nil)
+	require.NoError(t, err)
+	testutilVerifyResults(t, qe, "ns1", "key1", []string{"\x04", "\x03", "\x02", "\x01"})
+	var publisherKeys, subscriberKeys [][]byte
+	require.NoError(t, publisher.ScanIndex("", "", func(e *IndexEntry) error {
+		publisherKeys = append(publisherKeys, e.RawKey)
+		return nil
+	}))
+	require.NoError(t, subscriber.ScanIndex("", "", func(e *IndexEntry) error {
+		subscriberKeys = append(subscriberKeys, e.RawKey)
+		return nil
+	}))
+	require.Equal(t, publisherKeys, subscriberKeys)
+	stats, err := subscriber.GetIndexStats("ns1")
+	require.NoError(t, err)
+	require.Equal(t, uint64(8), stats.TotalIndexEntries)
+
+	// a caught up subscriber receives no batches
+	require.NoError(t, publisher.StreamIndexBatches(5, store, func(b *IndexBatch) error {
+		return errors.New("unexpected batch")
+	}))
+	err = publisher.StreamIndexBatches(6, store, subscriber.ApplyIndexBatch)
+	require.EqualError(t, err, "cannot stream index batches from block [6] as the history savepoint is at block [4]")
+
+	t.Run("invalid-batches", func(t *testing.T) {
+		batch, err := publisher.NewIndexBatch(blocks[1])
+		require.NoError(t, err)
+		require.EqualError(t, subscriber.ApplyIndexBatch(batch), "index batch for block [1] cannot be applied, expected the batch for block [5]")
+
+		batch.Entries[0].KeyModification.Value = []byte("tampered")
+		require.EqualError(t, subscriber.ApplyIndexBatch(batch), "digest mismatch for the index batch for block [1]")
+		require.Contains(t, publisher.VerifyIndexBatch(batch, blocks[1]).Error(), "does not match the locally built index batch for block [1]")
+	})
+}
@@ -0,0 +1,124 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"sync"
+
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+)
+
+// EnableParallelDecoding enables resolving the key modifications of the history entries visited by the bulk scans
+// (functions `Scan`, `ExportHistory`, and `Checkpoint`) on up to workers goroutines, instead of on the goroutine
+// iterating the entries. Unmarshalling the transactions and their read-write sets is CPU bound and hence, this lets
+// a scan over a large number of entries use multiple cores. The entries are still delivered in order. A workers of
+// 1 or less leaves the entries to be resolved one at a time.
+func (p *DBProvider) EnableParallelDecoding(workers int) {
+	p.decodeWorkers = workers
+}
+
+// resolvePool resolves the key modifications of the history entries added by a bulk scan on a bounded number of
+// worker goroutines and delivers the resolved entries, in the order they are added, to the deliver function on the
+// goroutine adding the entries. At most twice as many entries as workers are in flight, so that the workers are kept
+// busy while the deliver function processes the head of the queue, without reading far ahead of the consumer.
+// A resolvePool with fewer than two workers resolves and delivers each entry as it is added.
+type resolvePool struct {
+	historyDB *DB
+	txFetcher TxFetcher
+	deliver   func(key []byte, keyModification *queryresult.KeyModification) error
+
+	maxInFlight int
+	jobs        chan *resolveJob
+	inFlight    []*resolveJob
+	wg          sync.WaitGroup
+}
+
+type resolveJob struct {
+	key             []byte
+	val             []byte
+	done            chan struct{}
+	keyModification *queryresult.KeyModification
+	err             error
+}
+
+func (d *DB) newResolvePool(txFetcher TxFetcher, deliver func([]byte, *queryresult.KeyModification) error) *resolvePool {
+	pool := &resolvePool{
+		historyDB: d,
+		txFetcher: txFetcher,
+		deliver:   deliver,
+	}
+	if d.decodeWorkers < 2 {
+		return pool
+	}
+	pool.maxInFlight = 2 * d.decodeWorkers
+	pool.jobs = make(chan *resolveJob, pool.maxInFlight)
+	for i := 0; i < d.decodeWorkers; i++ {
+		pool.wg.Add(1)
+		go func() {
+			defer pool.wg.Done()
+			for job := range pool.jobs {
+				job.keyModification, job.err = d.resolveKeyModification(job.key, job.val, txFetcher, readAround)
+				close(job.done)
+			}
+		}()
+	}
+	return pool
+}
+
+// add adds a history entry to be resolved. The key and the value are copied, as the iterator reuses their buffers.
+// An error returned by the deliver function, or in resolving an entry, is returned by a subsequent add or the flush
+func (p *resolvePool) add(key, val []byte) error {
+	if p.jobs == nil {
+		keyModification, err := p.historyDB.resolveKeyModification(key, val, p.txFetcher, readAround)
+		if err != nil {
+			return err
+		}
+		return p.deliver(key, keyModification)
+	}
+	job := &resolveJob{
+		key:  append([]byte(nil), key...),
+		val:  append([]byte(nil), val...),
+		done: make(chan struct{}),
+	}
+	p.inFlight = append(p.inFlight, job)
+	p.jobs <- job
+	for len(p.inFlight) >= p.maxInFlight {
+		if err := p.deliverNext(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flush delivers the entries in flight
+func (p *resolvePool) flush() error {
+	for len(p.inFlight) > 0 {
+		if err := p.deliverNext(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *resolvePool) deliverNext() error {
+	job := p.inFlight[0]
+	p.inFlight = p.inFlight[1:]
+	<-job.done
+	if job.err != nil {
+		return job.err
+	}
+	return p.deliver(job.key, job.keyModification)
+}
+
+// close stops the workers, once they finish resolving the entries in flight, which are not delivered
+func (p *resolvePool) close() {
+	if p.jobs == nil {
+		return
+	}
+	close(p.jobs)
+	p.wg.Wait()
+}
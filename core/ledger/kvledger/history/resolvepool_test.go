@@ -0,0 +1,95 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/ledger/testutil"
+	util2 "github.com/hyperledger/fabric/common/util"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParallelDecoding(t *testing.T) {
+	env := newTestHistoryEnv(t)
+	defer env.cleanup()
+	store, err := env.testBlockStorageEnv.provider.Open("ledger1")
+	require.NoError(t, err)
+	defer store.Shutdown()
+
+	historydb := env.testHistoryDBProvider.GetDBHandle("ledger1")
+	bg, gb := testutil.NewBlockGenerator(t, "ledger1", false)
+	require.NoError(t, store.AddBlock(gb))
+	require.NoError(t, historydb.Commit(gb))
+	for i := 1; i <= 10; i++ {
+		simulator, err := env.txmgr.NewTxSimulator(util2.GenerateUUID())
+		require.NoError(t, err)
+		for k := 0; k < 5; k++ {
+			require.NoError(t, simulator.SetState("ns1", fmt.Sprintf("key%d", k), []byte(fmt.Sprintf("value%d-%d", k, i))))
+		}
+		simulator.Done()
+		simRes, err := simulator.GetTxSimulationResults()
+		require.NoError(t, err)
+		pubSimResBytes, err := simRes.GetPubSimulationBytes()
+		require.NoError(t, err)
+		block := bg.NextBlock([][]byte{pubSimResBytes})
+		require.NoError(t, store.AddBlock(block))
+		require.NoError(t, historydb.Commit(block))
+	}
+
+	scan := func(historydb *DB) []string {
+		var values []string
+		require.NoError(t, historydb.Scan("ns1", "", store, func(e *Entry) error {
+			values = append(values, fmt.Sprintf("%s:%d:%s", e.Key, e.BlockNum, e.KeyModification.Value))
+			return nil
+		}))
+		return values
+	}
+	expectedValues := scan(historydb)
+	require.Len(t, expectedValues, 50)
+	expectedHashes, err := historydb.ExportHistory(t.TempDir(), testNewHashFunc, store)
+	require.NoError(t, err)
+
+	env.testHistoryDBProvider.EnableParallelDecoding(4)
+	historydb = env.testHistoryDBProvider.GetDBHandle("ledger1")
+
+	// the entries are delivered in order, as resolved sequentially
+	require.Equal(t, expectedValues, scan(historydb))
+	hashes, err := historydb.ExportHistory(t.TempDir(), testNewHashFunc, store)
+	require.NoError(t, err)
+	require.Equal(t, expectedHashes, hashes)
+
+	replicaDir := filepath.Join(t.TempDir(), "replica")
+	require.NoError(t, historydb.Checkpoint(replicaDir, store))
+	replicaProvider, err := NewReadOnlyDBProvider(replicaDir)
+	require.NoError(t, err)
+	defer replicaProvider.Close()
+	require.Equal(t, expectedValues, scan(replicaProvider.GetDBHandle("ledger1")))
+
+	t.Run("visit-error", func(t *testing.T) {
+		numVisited := 0
+		err := historydb.Scan("ns1", "", store, func(e *Entry) error {
+			if numVisited++; numVisited == 12 {
+				return errors.New("visit-error")
+			}
+			return nil
+		})
+		require.EqualError(t, err, "visit-error")
+		require.Equal(t, 12, numVisited)
+	})
+
+	t.Run("resolve-error", func(t *testing.T) {
+		fetcher := &countingTxFetcher{TxFetcher: store, err: errors.New("fetch error")}
+		err := historydb.Scan("ns1", "", fetcher, func(e *Entry) error {
+			return nil
+		})
+		require.EqualError(t, err, "fetch error")
+	})
+}
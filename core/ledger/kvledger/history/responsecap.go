@@ -0,0 +1,134 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/pkg/errors"
+)
+
+// errResponseCapReached is returned by the deliver function of a sweep to stop the sweep once the response is full
+var errResponseCapReached = errors.New("history response cap reached")
+
+// ResponseTooLargeError is returned by the history queries that return all their results at once, when the results
+// exceed a response cap set via function `EnableResponseCaps`. The results are to be retrieved in pages instead
+type ResponseTooLargeError struct {
+	MaxEntries uint64
+	MaxBytes   uint64
+}
+
+func (e *ResponseTooLargeError) Error() string {
+	return fmt.Sprintf("history response too large: the results exceed the cap of [%d] entries or [%d] bytes, "+
+		"the results are to be retrieved in pages", e.MaxEntries, e.MaxBytes)
+}
+
+// EnableResponseCaps caps the number of the results and the bytes of the values returned in a single response of the
+// history queries that return all their results at once, i.e., functions `GetHistoriesForKeys` and
+// `ListIndexedKeys`, so that a client cannot make the peer hold an arbitrarily large response in memory. A paged
+// query returns a page truncated at the caps along with the continuation of the query. A maxEntries or a maxBytes of
+// 0 leaves the corresponding size uncapped. A page holds at least one result, even if its size exceeds maxBytes.
+// The full-text search (function `SearchHistory`) fails once its matches exceed the caps. The queries that return an
+// iterator are not capped, as their results are not held in memory.
+func (p *DBProvider) EnableResponseCaps(maxEntries, maxBytes int) error {
+	if maxEntries < 0 || maxBytes < 0 {
+		return errors.Errorf("invalid response cap config: maxEntries [%d] and maxBytes [%d] must not be negative",
+			maxEntries, maxBytes)
+	}
+	if maxEntries == 0 && maxBytes == 0 {
+		p.responseCaps = nil
+		return nil
+	}
+	p.responseCaps = &responseCaps{maxEntries: uint64(maxEntries), maxBytes: uint64(maxBytes)}
+	return nil
+}
+
+// responseCaps are the caps on the size of a response. A nil responseCaps is valid and caps nothing
+type responseCaps struct {
+	maxEntries uint64
+	maxBytes   uint64
+}
+
+func (c *responseCaps) newCounter() *responseCounter {
+	return &responseCounter{caps: c}
+}
+
+func (c *responseCaps) tooLargeError() *ResponseTooLargeError {
+	return &ResponseTooLargeError{MaxEntries: c.maxEntries, MaxBytes: c.maxBytes}
+}
+
+// responseCounter accounts the results added to a response against the caps
+type responseCounter struct {
+	caps    *responseCaps
+	entries uint64
+	bytes   uint64
+}
+
+// add returns false, without accounting the result, if the result of the given size does not fit in the response.
+// The first result always fits
+func (r *responseCounter) add(size int) bool {
+	if r.caps != nil && r.entries > 0 {
+		if r.caps.maxEntries > 0 && r.entries+1 > r.caps.maxEntries {
+			return false
+		}
+		if r.caps.maxBytes > 0 && r.bytes+uint64(size) > r.caps.maxBytes {
+			return false
+		}
+	}
+	r.entries++
+	r.bytes += uint64(size)
+	return true
+}
+
+// KeyHistoriesPage is a page of the histories of the keys (see function `GetHistoriesForKeysPage`)
+type KeyHistoriesPage struct {
+	// Histories holds the history entries in the page for each of the requested keys whose history is swept in the
+	// page, in the order of newest to oldest. The history of a key that is truncated by the response caps is
+	// continued in the next page with the older entries of the key
+	Histories map[string][]*queryresult.KeyModification
+	// NextPageToken continues the histories after the page, if the page is truncated, and is empty otherwise
+	NextPageToken string
+}
+
+// GetHistoriesForKeysPage returns the histories of the keys in the namespace like function `GetHistoriesForKeys`,
+// except that the results are truncated at the response caps (see function `EnableResponseCaps`) and continued by
+// passing the NextPageToken of the page along with the same namespace and keys. An empty token returns the first
+// page. The tokens are sealed and bound to the query as for function `ListIndexedKeysPage`. The pages sweep the keys in
+// the order of the index, which is not the order of the requested keys, and the entries of each key in the order of
+// newest to oldest, across the pages as within a page. Hence, appending the entries of a key from each page, in the
+// order of the pages, yields the history of the key as returned by function `GetHistoriesForKeys`.
+func (d *DB) GetHistoriesForKeysPage(ns string, keys []string, token string, txFetcher TxFetcher) (*KeyHistoriesPage, error) {
+	height, err := d.IndexedHeight()
+	if err != nil {
+		return nil, err
+	}
+	sortedKeys := append([]string(nil), keys...)
+	sort.Strings(sortedKeys)
+	queryHash := pageQueryHash(d.name, "GetHistoriesForKeys", append([]string{ns}, sortedKeys...)...)
+	var startAt []byte
+	if token != "" {
+		t, err := openPageToken(d.provider.pageTokenKey, token, queryHash)
+		if err != nil {
+			return nil, err
+		}
+		if t.height > height {
+			return nil, &StalePageTokenError{TokenHeight: t.height, IndexedHeight: height}
+		}
+		startAt = t.position
+	}
+	histories, next, err := d.getHistoriesForKeys(ns, keys, txFetcher, startAt)
+	if err != nil {
+		return nil, err
+	}
+	page := &KeyHistoriesPage{Histories: histories}
+	if next != nil {
+		page.NextPageToken = (&pageToken{position: next, height: height, queryHash: queryHash}).seal(d.provider.pageTokenKey)
+	}
+	return page, nil
+}
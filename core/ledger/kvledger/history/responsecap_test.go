@@ -0,0 +1,133 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/hyperledger/fabric/common/ledger/testutil"
+	util2 "github.com/hyperledger/fabric/common/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseCaps(t *testing.T) {
+	env := newTestHistoryEnv(t)
+	defer env.cleanup()
+	store, err := env.testBlockStorageEnv.provider.Open("ledger1")
+	require.NoError(t, err)
+	defer store.Shutdown()
+
+	historydb := env.testHistoryDBProvider.GetDBHandle("ledger1")
+	bg, gb := testutil.NewBlockGenerator(t, "ledger1", false)
+	commit := func(block *common.Block) {
+		require.NoError(t, store.AddBlock(block))
+		require.NoError(t, historydb.Commit(block))
+	}
+	commit(gb)
+	writes := [][]string{
+		{"key1", "key10", "k"},
+		{"key1", "key2"},
+		{"key10", "key3"},
+	}
+	for i, keys := range writes {
+		simulator, err := env.txmgr.NewTxSimulator(util2.GenerateUUID())
+		require.NoError(t, err)
+		for _, key := range keys {
+			require.NoError(t, simulator.SetState("ns1", key, []byte(fmt.Sprintf("%s-%d", key, i))))
+		}
+		simulator.Done()
+		simRes, err := simulator.GetTxSimulationResults()
+		require.NoError(t, err)
+		pubSimResBytes, err := simRes.GetPubSimulationBytes()
+		require.NoError(t, err)
+		commit(bg.NextBlock([][]byte{pubSimResBytes}))
+	}
+
+	values := func(histories map[string][]*queryresult.KeyModification) map[string][]string {
+		vals := map[string][]string{}
+		for key, modifications := range histories {
+			vals[key] = []string{}
+			for _, m := range modifications {
+				vals[key] = append(vals[key], string(m.Value))
+			}
+		}
+		return vals
+	}
+	keys := []string{"key10", "missing", "key1", "k", "key3"}
+
+	require.NoError(t, env.testHistoryDBProvider.EnableResponseCaps(2, 0))
+	for _, decodeWorkers := range []int{0, 4} {
+		t.Run(fmt.Sprintf("decodeWorkers-%d", decodeWorkers), func(t *testing.T) {
+			env.testHistoryDBProvider.EnableParallelDecoding(decodeWorkers)
+			historydb := env.testHistoryDBProvider.GetDBHandle("ledger1")
+
+			_, err := historydb.GetHistoriesForKeys("ns1", keys, store)
+			require.Equal(t, &ResponseTooLargeError{MaxEntries: 2}, err)
+
+			// the keys are swept in the order of the index, and the entries of a key from newest to oldest, and a page
+			// is truncated within the history of a key
+			merged := map[string][]string{}
+			merge := func(page *KeyHistoriesPage) {
+				for key, vals := range values(page.Histories) {
+					merged[key] = append(append([]string{}, merged[key]...), vals...)
+				}
+			}
+			page, err := historydb.GetHistoriesForKeysPage("ns1", keys, "", store)
+			require.NoError(t, err)
+			require.Equal(t, map[string][]string{"k": {"k-0"}, "key1": {"key1-1"}}, values(page.Histories))
+			merge(page)
+			require.NotEmpty(t, page.NextPageToken)
+			// the token is bound to the keys, though not to their order
+			_, err = historydb.GetHistoriesForKeysPage("ns1", []string{"key10"}, page.NextPageToken, store)
+			require.Equal(t, &InvalidPageTokenError{Reason: "the token was issued for a different query"}, err)
+			page, err = historydb.GetHistoriesForKeysPage("ns1", []string{"k", "key1", "key3", "key10", "missing"}, page.NextPageToken, store)
+			require.NoError(t, err)
+			require.Equal(t, map[string][]string{"key1": {"key1-0"}, "key3": {"key3-2"}}, values(page.Histories))
+			merge(page)
+			page, err = historydb.GetHistoriesForKeysPage("ns1", keys, page.NextPageToken, store)
+			require.NoError(t, err)
+			require.Equal(t, map[string][]string{"key10": {"key10-2", "key10-0"}, "missing": {}}, values(page.Histories))
+			require.Empty(t, page.NextPageToken)
+			merge(page)
+
+			// the pages, appended in order, hold the histories in the order of the unpaged query
+			require.NoError(t, env.testHistoryDBProvider.EnableResponseCaps(0, 0))
+			histories, err := env.testHistoryDBProvider.GetDBHandle("ledger1").GetHistoriesForKeys("ns1", keys, store)
+			require.NoError(t, err)
+			require.Equal(t, values(histories), merged)
+			require.NoError(t, env.testHistoryDBProvider.EnableResponseCaps(2, 0))
+		})
+	}
+
+	// a result larger than maxBytes is returned on its own
+	require.NoError(t, env.testHistoryDBProvider.EnableResponseCaps(0, 6))
+	historydb = env.testHistoryDBProvider.GetDBHandle("ledger1")
+	page, err := historydb.GetHistoriesForKeysPage("ns1", []string{"key10"}, "", store)
+	require.NoError(t, err)
+	require.Equal(t, map[string][]string{"key10": {"key10-2"}}, values(page.Histories))
+	indexedKeys, err := historydb.ListIndexedKeys("ns1", "", 10)
+	require.NoError(t, err)
+	require.Equal(t, []string{"k", "key1"}, indexedKeys.Keys)
+	require.True(t, indexedKeys.HasMore)
+
+	// the responses are not capped once the caps are disabled
+	require.NoError(t, env.testHistoryDBProvider.EnableResponseCaps(0, 0))
+	historydb = env.testHistoryDBProvider.GetDBHandle("ledger1")
+	histories, err := historydb.GetHistoriesForKeys("ns1", keys, store)
+	require.NoError(t, err)
+	require.Len(t, histories, 5)
+	page, err = historydb.GetHistoriesForKeysPage("ns1", keys, "", store)
+	require.NoError(t, err)
+	require.Equal(t, histories, page.Histories)
+	require.Empty(t, page.NextPageToken)
+
+	require.EqualError(t, env.testHistoryDBProvider.EnableResponseCaps(-1, 0),
+		"invalid response cap config: maxEntries [-1] and maxBytes [0] must not be negative")
+}
@@ -0,0 +1,194 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	commonledger "github.com/hyperledger/fabric/common/ledger"
+)
+
+// queryResultCache is an LRU cache of the complete results of the history queries, so that the repeated queries for
+// the hot keys, as issued by the dashboards polling the history, are answered without scanning the index or retrieving
+// the transactions. A key is identified by the start key of its range scan. The results for a key are added once a
+// query iterates them to the end, and are served to the queries at the indexed height at which they were added. As the
+// indexed height advances with each write batch, the results for the keys of the batch are removed and those for the
+// other keys remain valid at the new height. A result computed at a height other than the current one, or while a write
+// batch is written, as tracked by the generation of the cache, is not added. A nil queryResultCache is valid and caches
+// nothing.
+type queryResultCache struct {
+	maxKeys    int
+	maxEntries int
+	mutex      sync.Mutex
+	// height is the indexed height reflected in the cached results. It is read from the historydb on the first lookup
+	// and then advanced by the write batches
+	height      uint64
+	heightKnown bool
+	readHeight  func() (uint64, error)
+	generation  uint64
+	entries     map[string]*list.Element
+	lru         *list.List
+	stats       *ledgerStats
+}
+
+type cachedQueryResult struct {
+	scanKey string
+	results []*queryresult.KeyModification
+}
+
+// EnableQueryResultCache enables caching, per ledger, the results of the history queries for up to maxKeys keys.
+// The keys with more than maxEntriesPerKey history entries are not cached. A maxKeys or a maxEntriesPerKey of 0 or
+// less leaves the cache disabled.
+func (p *DBProvider) EnableQueryResultCache(maxKeys, maxEntriesPerKey int) {
+	p.queryResultCacheSize = maxKeys
+	p.queryResultCacheMaxEntries = maxEntriesPerKey
+}
+
+func newQueryResultCache(maxKeys, maxEntries int, stats *ledgerStats, readHeight func() (uint64, error)) *queryResultCache {
+	if maxKeys <= 0 || maxEntries <= 0 {
+		return nil
+	}
+	return &queryResultCache{
+		maxKeys:    maxKeys,
+		maxEntries: maxEntries,
+		readHeight: readHeight,
+		entries:    map[string]*list.Element{},
+		lru:        list.New(),
+		stats:      stats,
+	}
+}
+
+// get returns the cached results for the key, if cached for the given indexed height, along with the generation to be
+// passed to function `add` for the results computed by a query that starts after this call
+func (c *queryResultCache) get(scanKey []byte, indexedHeight uint64) ([]*queryresult.KeyModification, bool, uint64) {
+	if c == nil {
+		return nil, false, 0
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !c.heightKnown {
+		// read under the mutex, so that a write batch written after the read is not missed by the invalidation
+		height, err := c.readHeight()
+		if err != nil {
+			c.stats.cacheMiss(queryResultCacheName)
+			return nil, false, c.generation
+		}
+		c.height, c.heightKnown = height, true
+	}
+	elem, ok := c.entries[string(scanKey)]
+	if !ok || indexedHeight != c.height {
+		c.stats.cacheMiss(queryResultCacheName)
+		return nil, false, c.generation
+	}
+	c.lru.MoveToFront(elem)
+	c.stats.cacheHit(queryResultCacheName)
+	return elem.Value.(*cachedQueryResult).results, true, c.generation
+}
+
+// peek returns the number of the cached results for the key, if cached for the given indexed height, without
+// affecting the recency of the key or the metrics
+func (c *queryResultCache) peek(scanKey []byte, indexedHeight uint64) (int, bool) {
+	if c == nil {
+		return 0, false
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	elem, ok := c.entries[string(scanKey)]
+	if !ok || !c.heightKnown || indexedHeight != c.height {
+		return 0, false
+	}
+	return len(elem.Value.(*cachedQueryResult).results), true
+}
+
+// add adds the complete results of a query for the key at the given indexed height, unless a write batch is written
+// since the given generation or the height is not the current one
+func (c *queryResultCache) add(scanKey []byte, indexedHeight uint64, generation uint64, results []*queryresult.KeyModification) {
+	if c == nil || len(results) > c.maxEntries {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if generation != c.generation || !c.heightKnown || indexedHeight != c.height {
+		return
+	}
+	if _, ok := c.entries[string(scanKey)]; ok {
+		return
+	}
+	c.entries[string(scanKey)] = c.lru.PushFront(&cachedQueryResult{scanKey: string(scanKey), results: results})
+	for c.lru.Len() > c.maxKeys {
+		oldest := c.lru.Back()
+		c.remove(oldest)
+		c.stats.cacheEviction(queryResultCacheName)
+	}
+	c.stats.updateCacheSize(queryResultCacheName, c.lru.Len(), 0)
+}
+
+// invalidate removes the results for the keys of a write batch, as tracked by the index stats tracker, once the batch
+// is written, and advances the height of the cache to the given indexed height. A height of 0 leaves the height
+// unchanged, as for the batches that do not advance the savepoint
+func (c *queryResultCache) invalidate(scanKeys map[string]struct{}, height uint64) {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.generation++
+	if height > 0 {
+		c.height, c.heightKnown = height, true
+	}
+	for k := range scanKeys {
+		if elem, ok := c.entries[k]; ok {
+			c.remove(elem)
+		}
+	}
+	c.stats.updateCacheSize(queryResultCacheName, c.lru.Len(), 0)
+}
+
+func (c *queryResultCache) remove(elem *list.Element) {
+	delete(c.entries, c.lru.Remove(elem).(*cachedQueryResult).scanKey)
+}
+
+// purge removes all the results. The results computed by the queries in progress are not added afterwards
+func (c *queryResultCache) purge() {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.generation++
+	c.entries = map[string]*list.Element{}
+	c.lru.Init()
+	c.stats.updateCacheSize(queryResultCacheName, 0, 0)
+}
+
+func (c *queryResultCache) len() int {
+	if c == nil {
+		return 0
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.lru.Len()
+}
+
+// cachedHistoryScanner is returned by the history queries for the keys in the queryResultCache. The cached results
+// are shared by the scanners and must not be modified by the consumers
+type cachedHistoryScanner struct {
+	results []*queryresult.KeyModification
+}
+
+func (s *cachedHistoryScanner) Next() (commonledger.QueryResult, error) {
+	if len(s.results) == 0 {
+		return nil, nil
+	}
+	result := s.results[0]
+	s.results = s.results[1:]
+	return result, nil
+}
+
+func (s *cachedHistoryScanner) Close() {}
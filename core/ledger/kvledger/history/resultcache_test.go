@@ -0,0 +1,104 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric/common/ledger/testutil"
+	util2 "github.com/hyperledger/fabric/common/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryResultCache(t *testing.T) {
+	env := newTestHistoryEnv(t)
+	defer env.cleanup()
+	store, err := env.testBlockStorageEnv.provider.Open("ledger1")
+	require.NoError(t, err)
+	defer store.Shutdown()
+
+	env.testHistoryDBProvider.EnableQueryResultCache(2, 2)
+	historydb := env.testHistoryDBProvider.GetDBHandle("ledger1")
+	bg, gb := testutil.NewBlockGenerator(t, "ledger1", false)
+	commit := func(block *common.Block) {
+		require.NoError(t, store.AddBlock(block))
+		require.NoError(t, historydb.Commit(block))
+	}
+	nextBlock := func(key, value string) *common.Block {
+		simulator, err := env.txmgr.NewTxSimulator(util2.GenerateUUID())
+		require.NoError(t, err)
+		require.NoError(t, simulator.SetState("ns1", key, []byte(value)))
+		simulator.Done()
+		simRes, err := simulator.GetTxSimulationResults()
+		require.NoError(t, err)
+		pubSimResBytes, err := simRes.GetPubSimulationBytes()
+		require.NoError(t, err)
+		return bg.NextBlock([][]byte{pubSimResBytes})
+	}
+	isCached := func(qe *QueryExecutor, key string) bool {
+		itr, err := qe.GetHistoryForKey("ns1", key)
+		require.NoError(t, err)
+		defer itr.Close()
+		_, ok := itr.(*cachedHistoryScanner)
+		return ok
+	}
+	commit(gb)
+	commit(nextBlock("key1", "value1"))
+	commit(nextBlock("key2", "value1"))
+	for i := 1; i <= 3; i++ {
+		commit(nextBlock("key3", "value"))
+	}
+
+	qe, err := historydb.NewQueryExecutor(store)
+	require.NoError(t, err)
+	testutilVerifyResults(t, qe, "ns1", "key1", []string{"value1"})
+	testutilVerifyResults(t, qe, "ns1", "key3", []string{"value", "value", "value"})
+	// the results are cached once iterated to the end, and only for the keys within the limit on the entries
+	require.Equal(t, 1, historydb.queryResultCache.len())
+	require.True(t, isCached(qe.(*QueryExecutor), "key1"))
+	require.False(t, isCached(qe.(*QueryExecutor), "key3"))
+	// a scanner closed before it is exhausted does not add its results
+	require.False(t, isCached(qe.(*QueryExecutor), "key2"))
+	require.False(t, isCached(qe.(*QueryExecutor), "key2"))
+	testutilVerifyResults(t, qe, "ns1", "key1", []string{"value1"})
+	testutilVerifyResults(t, qe, "ns1", "key2", []string{"value1"})
+	require.Equal(t, 2, historydb.queryResultCache.len())
+
+	// the results for a key are removed once a block writes the key, and those for the other keys remain valid
+	commit(nextBlock("key1", "value2"))
+	require.Equal(t, 1, historydb.queryResultCache.len())
+	newQE, err := historydb.NewQueryExecutor(store)
+	require.NoError(t, err)
+	require.True(t, isCached(newQE.(*QueryExecutor), "key2"))
+	require.False(t, isCached(newQE.(*QueryExecutor), "key1"))
+	testutilVerifyResults(t, newQE, "ns1", "key1", []string{"value2", "value1"})
+	require.True(t, isCached(newQE.(*QueryExecutor), "key1"))
+
+	// a query executor at an older height neither uses nor adds the cached results
+	require.False(t, isCached(qe.(*QueryExecutor), "key2"))
+	testutilVerifyResults(t, qe, "ns1", "key1", []string{"value1"})
+	testutilVerifyResults(t, newQE, "ns1", "key1", []string{"value2", "value1"})
+
+	t.Run("write-between-lookup-and-add", func(t *testing.T) {
+		height, err := historydb.IndexedHeight()
+		require.NoError(t, err)
+		results, ok, generation := historydb.queryResultCache.get(constructRangeScan("ns1", "key4").startKey, height)
+		require.False(t, ok)
+		require.Nil(t, results)
+		// a batch that does not advance the indexed height, as written by the backfill
+		historydb.queryResultCache.invalidate(map[string]struct{}{}, 0)
+		historydb.queryResultCache.add(constructRangeScan("ns1", "key4").startKey, height, generation, nil)
+		_, ok, _ = historydb.queryResultCache.get(constructRangeScan("ns1", "key4").startKey, height)
+		require.False(t, ok)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		require.Nil(t, newQueryResultCache(0, 10, nil, nil))
+		require.Nil(t, newQueryResultCache(10, 0, nil, nil))
+	})
+}
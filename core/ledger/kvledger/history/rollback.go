@@ -0,0 +1,157 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"bytes"
+	"math"
+
+	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/hyperledger/fabric/core/ledger/internal/version"
+	"github.com/pkg/errors"
+)
+
+// Rollback rolls back the history for the ledger `name` to the block lastBlock, i.e., removes the entries, the rows of
+// the history views and of the transaction index, the recorded value sizes, and the chaincode approvals for the
+// blocks after lastBlock and sets the savepoint to lastBlock, as if the blocks after lastBlock were never committed.
+// This aligns the history with a block store rolled back or restored to lastBlock. The index statistics are recomputed
+// for the namespaces that had the entries removed, and the samples of the on-disk size of these namespaces are
+// removed, as they are taken again by the size sampling. The tracked values of the keys whose unchanged writes are
+// skipped are removed as well.
+//
+// The savepoint is removed first and written last, so that an interruption leaves the historydb without a savepoint,
+// which in turn causes the peer to recommit all the blocks to the historydb at start, should the rollback not be
+// retried. This function is to be invoked while the ledger is not opened.
+func (p *DBProvider) Rollback(name string, lastBlock uint64) error {
+	db := p.GetDBHandle(name)
+	savepoint, err := db.GetLastSavepoint()
+	if err != nil {
+		return err
+	}
+	if savepoint == nil {
+		return errors.Errorf("no history found for ledger [%s]", name)
+	}
+	if lastBlock > savepoint.BlockNum {
+		return errors.Errorf("cannot rollback the history to block [%d] as the history savepoint is at block [%d]",
+			lastBlock, savepoint.BlockNum)
+	}
+	if lastBlock == savepoint.BlockNum {
+		return nil
+	}
+	if err := db.levelDB.Delete(savePointKey, true); err != nil {
+		return err
+	}
+	if err := p.RecordInvalidation(&ledger.HistoryInvalidation{
+		LedgerID:  name,
+		FromBlock: lastBlock + 1,
+		ToBlock:   savepoint.BlockNum,
+		Reason:    ledger.HistoryInvalidationReasonHistoryRollback,
+	}); err != nil {
+		return err
+	}
+
+	itr, err := db.levelDB.GetIterator(nil, nil)
+	if err != nil {
+		return err
+	}
+	defer itr.Release()
+
+	// the statistics of the remaining entries of each namespace, of which those of the namespaces with removed
+	// entries replace the stored statistics
+	remaining := map[string]*IndexStats{}
+	rolledBack := map[string]struct{}{}
+	var previousScanKey []byte
+	batch := db.levelDB.NewUpdateBatch()
+	for itr.Next() {
+		if err := itr.Error(); err != nil {
+			return errors.Wrap(err, "internal leveldb error while iterating for history entries")
+		}
+		key := itr.Key()
+		var blockNum uint64
+		switch {
+		case bytes.Equal(key, savePointKey):
+			continue
+		case isDataKey(key):
+			ns, k, b, _, err := decodeDataKey(key)
+			if err != nil {
+				return err
+			}
+			if blockNum = b; blockNum > lastBlock {
+				rolledBack[ns] = struct{}{}
+				break
+			}
+			s, ok := remaining[ns]
+			if !ok {
+				s = &IndexStats{}
+				remaining[ns] = s
+			}
+			// the entries of a key are contiguous and hence, a key is distinct from the key of the previous entry
+			if scanKey := constructRangeScan(ns, k).startKey; !bytes.Equal(scanKey, previousScanKey) {
+				s.DistinctKeys++
+				previousScanKey = scanKey
+			}
+			s.TotalIndexEntries++
+			s.TotalVersions++
+			s.ApproxSizeBytes += uint64(len(key) + len(itr.Value()))
+			if blockNum > s.LastIndexedBlock {
+				s.LastIndexedBlock = blockNum
+			}
+		case bytes.HasPrefix(key, viewRowKeyPrefix):
+			dataKey, err := decodeViewRowKey(key)
+			if err != nil {
+				return err
+			}
+			if _, _, blockNum, _, err = decodeDataKey(dataKey); err != nil {
+				return err
+			}
+		case bytes.HasPrefix(key, lifecycleApprovalKeyPrefix):
+			if blockNum, err = decodeLifecycleApprovalBlockNum(key); err != nil {
+				return err
+			}
+		case bytes.HasPrefix(key, txKeyKeyPrefix):
+			if blockNum, _, _, err = decodeTxKeyRowKey(key); err != nil {
+				return err
+			}
+		case bytes.HasPrefix(key, valueSizeKeyPrefix):
+			if _, _, blockNum, _, err = decodeDataKey(decodeValueSizeKey(key)); err != nil {
+				return err
+			}
+		case bytes.HasPrefix(key, valueStateKeyPrefix):
+			// the tracked value of a key may be for a removed block
+			blockNum = math.MaxUint64
+		default:
+			continue
+		}
+		if blockNum <= lastBlock {
+			continue
+		}
+		batch.Delete(key)
+		if batch.Size() >= rebuildSwapBatchSize {
+			if err := db.levelDB.WriteBatch(batch, true); err != nil {
+				return err
+			}
+			batch.Reset()
+		}
+	}
+
+	for ns := range rolledBack {
+		batch.Delete(constructSizeSampleKey(ns))
+		s, ok := remaining[ns]
+		if !ok {
+			batch.Delete(constructIndexStatsKey(ns))
+			continue
+		}
+		batch.Put(constructIndexStatsKey(ns), s.toBytes())
+		db.stats.updateDistinctKeys(ns, s.DistinctKeys)
+	}
+	batch.Put(savePointKey, version.NewHeight(lastBlock, math.MaxUint64).ToBytes())
+	if err := db.levelDB.WriteBatch(batch, true); err != nil {
+		return err
+	}
+	logger.Infow("History rolled back", "channel", name, "lastBlock", lastBlock, "previousSavepoint", savepoint.BlockNum)
+	return nil
+}
@@ -0,0 +1,94 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"math"
+	"testing"
+
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric/common/ledger/testutil"
+	util2 "github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/ledger/internal/version"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRollback(t *testing.T) {
+	env := newTestHistoryEnv(t)
+	defer env.cleanup()
+	store, err := env.testBlockStorageEnv.provider.Open("ledger1")
+	require.NoError(t, err)
+	defer store.Shutdown()
+
+	require.NoError(t, env.testHistoryDBProvider.RegisterView(&ownerView{}))
+	historydb := env.testHistoryDBProvider.GetDBHandle("ledger1")
+	bg, gb := testutil.NewBlockGenerator(t, "ledger1", false)
+	require.NoError(t, store.AddBlock(gb))
+	require.NoError(t, historydb.Commit(gb))
+	blocks := []*common.Block{gb}
+	for i := 1; i <= 5; i++ {
+		simulator, err := env.txmgr.NewTxSimulator(util2.GenerateUUID())
+		require.NoError(t, err)
+		require.NoError(t, simulator.SetState("ns1", "key1", []byte{byte(i)}))
+		if i >= 4 {
+			// ns2 has the history for the blocks to be rolled back only
+			require.NoError(t, simulator.SetState("ns2", "key2", []byte("alice")))
+		}
+		simulator.Done()
+		simRes, err := simulator.GetTxSimulationResults()
+		require.NoError(t, err)
+		pubSimResBytes, err := simRes.GetPubSimulationBytes()
+		require.NoError(t, err)
+		block := bg.NextBlock([][]byte{pubSimResBytes})
+		require.NoError(t, store.AddBlock(block))
+		require.NoError(t, historydb.Commit(block))
+		blocks = append(blocks, block)
+	}
+	require.NoError(t, historydb.SampleIndexSizes())
+
+	require.EqualError(t, env.testHistoryDBProvider.Rollback("ledger1", 6),
+		"cannot rollback the history to block [6] as the history savepoint is at block [5]")
+	require.EqualError(t, env.testHistoryDBProvider.Rollback("ledger2", 2), "no history found for ledger [ledger2]")
+	require.NoError(t, env.testHistoryDBProvider.Rollback("ledger1", 5))
+
+	require.NoError(t, env.testHistoryDBProvider.Rollback("ledger1", 3))
+	historydb = env.testHistoryDBProvider.GetDBHandle("ledger1")
+	savepoint, err := historydb.GetLastSavepoint()
+	require.NoError(t, err)
+	require.Equal(t, version.NewHeight(3, math.MaxUint64), savepoint)
+	qe, err := historydb.NewQueryExecutor(store)
+	require.NoError(t, err)
+	testutilVerifyResults(t, qe, "ns1", "key1", []string{"\x03", "\x02", "\x01"})
+	testutilVerifyResults(t, qe, "ns2", "key2", []string{})
+	require.NoError(t, historydb.QueryView("owner", "alice", store, func(e *Entry) error {
+		t.Fatalf("unexpected view entry for block [%d]", e.BlockNum)
+		return nil
+	}))
+
+	stats, err := historydb.GetIndexStats("ns1")
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), stats.DistinctKeys)
+	require.Equal(t, uint64(3), stats.TotalIndexEntries)
+	require.Equal(t, uint64(3), stats.LastIndexedBlock)
+	require.Zero(t, stats.DiskSizeBytes)
+	stats, err = historydb.GetIndexStats("ns2")
+	require.NoError(t, err)
+	require.Equal(t, &IndexStats{}, stats)
+
+	// the blocks after the rollback are committed again
+	for _, b := range blocks[4:] {
+		require.NoError(t, historydb.Commit(b))
+	}
+	qe, err = historydb.NewQueryExecutor(store)
+	require.NoError(t, err)
+	testutilVerifyResults(t, qe, "ns1", "key1", []string{"\x05", "\x04", "\x03", "\x02", "\x01"})
+	testutilVerifyResults(t, qe, "ns2", "key2", []string{"alice", "alice"})
+	stats, err = historydb.GetIndexStats("ns1")
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), stats.TotalIndexEntries)
+	require.Equal(t, uint64(5), stats.LastIndexedBlock)
+}
@@ -0,0 +1,180 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/hyperledger/fabric/common/ledger/blkstorage"
+	"github.com/hyperledger/fabric/protoutil"
+	"github.com/pkg/errors"
+)
+
+// Entry is a history entry along with the key modification resolved for it
+type Entry struct {
+	Namespace       string
+	Key             string
+	BlockNum        uint64
+	TranNum         uint64
+	KeyModification *queryresult.KeyModification
+	// BlockHash and PreviousBlockHash are the header hash of the block containing the entry and the hash of the
+	// previous block in the chain. These are populated only by function `ScanWithBlockHashes` and only for the
+	// blocks available in the block store
+	BlockHash         []byte
+	PreviousBlockHash []byte
+}
+
+// IndexEntry is a history index entry as stored in the historyDB, decoded without consulting the block store
+type IndexEntry struct {
+	RawKey    []byte
+	Namespace string
+	Key       string
+	BlockNum  uint64
+	TranNum   uint64
+	// Inline is true if the key modification is stored inline as the value of the entry, i.e., the entry was
+	// imported from a snapshot or backfilled from an archive, or its chaincode requests the values to be inlined
+	Inline bool
+	// DecodeErr is set if the entry could not be decoded, in which case only the RawKey is populated
+	DecodeErr error
+}
+
+// Scan invokes the function visit for the history entries in the namespace ns or, if key is not empty,
+// for the history entries of the given key only. The entries are visited in the order of keys and, for a key,
+// in the order of oldest to newest. The key modification for each entry is resolved from the block store,
+// unless stored inline. As a scan is typically a bulk export, the transactions it retrieves from the block store
+// are not added to the decoded transaction cache. The scan stops at the first error returned by visit and returns that error.
+func (d *DB) Scan(ns, key string, txFetcher TxFetcher, visit func(*Entry) error) error {
+	return d.scan(ns, key, txFetcher, nil, visit)
+}
+
+// ScanWithBlockHashes is same as function `Scan`, except that each entry carries the hash of the block containing
+// the entry and the hash of the previous block, so that an exported history can be anchored to the hash chain without
+// querying the blocks separately. Each block is retrieved once during the scan and its hashes are held until the scan
+// completes. The entries imported from a snapshot, for the blocks not available in the block store, carry no hashes.
+func (d *DB) ScanWithBlockHashes(ns, key string, blockStore *blkstorage.BlockStore, visit func(*Entry) error) error {
+	bcInfo, err := blockStore.GetBlockchainInfo()
+	if err != nil {
+		return err
+	}
+	hashes := &blockHashes{
+		blockStore: blockStore,
+		hashes:     map[uint64][2][]byte{},
+	}
+	if bsi := bcInfo.GetBootstrappingSnapshotInfo(); bsi != nil {
+		hashes.firstBlock = bsi.LastBlockInSnapshot + 1
+	}
+	return d.scan(ns, key, blockStore, hashes, visit)
+}
+
+func (d *DB) scan(ns, key string, txFetcher TxFetcher, hashes *blockHashes, visit func(*Entry) error) error {
+	key = d.normalizeKey(ns, key)
+	itr, err := d.levelDB.GetIterator(scanRange(ns, key))
+	if err != nil {
+		return err
+	}
+	defer itr.Release()
+
+	resolver := d.newResolvePool(txFetcher, func(k []byte, keyModification *queryresult.KeyModification) error {
+		entryNs, entryKey, blockNum, tranNum, err := decodeDataKey(k)
+		if err != nil {
+			return err
+		}
+		e := &Entry{
+			Namespace:       entryNs,
+			Key:             entryKey,
+			BlockNum:        blockNum,
+			TranNum:         tranNum,
+			KeyModification: keyModification,
+		}
+		if hashes != nil {
+			if e.BlockHash, e.PreviousBlockHash, err = hashes.get(blockNum); err != nil {
+				return err
+			}
+		}
+		return visit(e)
+	})
+	defer resolver.close()
+	for itr.Next() {
+		if err := itr.Error(); err != nil {
+			return errors.Wrap(err, "internal leveldb error while iterating for history entries")
+		}
+		if err := resolver.add(itr.Key(), itr.Value()); err != nil {
+			return err
+		}
+	}
+	return resolver.flush()
+}
+
+// ScanIndex invokes the function visit for the raw history index entries in the namespace ns or, if key is not
+// empty, for the entries of the given key only. If ns is empty, all the history index entries are visited. Unlike
+// function `Scan`, the block store is not consulted and an entry that cannot be decoded is passed to visit with
+// the DecodeErr set, instead of failing the scan. This is intended for inspecting an index that may be damaged.
+func (d *DB) ScanIndex(ns, key string, visit func(*IndexEntry) error) error {
+	var startKey, endKey []byte
+	if ns != "" {
+		startKey, endKey = scanRange(ns, d.normalizeKey(ns, key))
+	}
+	itr, err := d.levelDB.GetIterator(startKey, endKey)
+	if err != nil {
+		return err
+	}
+	defer itr.Release()
+
+	for itr.Next() {
+		if err := itr.Error(); err != nil {
+			return errors.Wrap(err, "internal leveldb error while iterating for history entries")
+		}
+		k := itr.Key()
+		if !isDataKey(k) {
+			continue
+		}
+		e := &IndexEntry{
+			RawKey: append([]byte(nil), k...),
+			Inline: len(itr.Value()) > 0,
+		}
+		e.Namespace, e.Key, e.BlockNum, e.TranNum, e.DecodeErr = decodeDataKey(k)
+		if err := visit(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scanRange returns the range of the data keys for the given namespace or, if key is not empty,
+// for the given key in the namespace
+func scanRange(ns, key string) ([]byte, []byte) {
+	if key != "" {
+		rangeScan := constructRangeScan(ns, key)
+		return rangeScan.startKey, rangeScan.endKey
+	}
+	return append([]byte(ns), compositeKeySep...), append([]byte(ns), compositeKeySep[0]+1)
+}
+
+// blockHashes retrieves, and holds for the duration of a scan, the header hash and the previous hash of the blocks
+type blockHashes struct {
+	blockStore *blkstorage.BlockStore
+	// firstBlock is the first block available in the block store
+	firstBlock uint64
+	hashes     map[uint64][2][]byte
+}
+
+// get returns the header hash and the previous hash of the block, or nil hashes if the block is not available
+// in the block store
+func (h *blockHashes) get(blockNum uint64) ([]byte, []byte, error) {
+	if blockNum < h.firstBlock {
+		return nil, nil, nil
+	}
+	if cached, ok := h.hashes[blockNum]; ok {
+		return cached[0], cached[1], nil
+	}
+	block, err := h.blockStore.RetrieveBlockByNumber(blockNum)
+	if err != nil {
+		return nil, nil, errors.WithMessagef(err, "error while retrieving block [%d] for its hash", blockNum)
+	}
+	blockHash := protoutil.BlockHeaderHash(block.Header)
+	h.hashes[blockNum] = [2][]byte{blockHash, block.Header.PreviousHash}
+	return blockHash, block.Header.PreviousHash, nil
+}
@@ -0,0 +1,188 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/hyperledger/fabric/common/ledger/testutil"
+	util2 "github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/protoutil"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScan(t *testing.T) {
+	env := newTestHistoryEnv(t)
+	defer env.cleanup()
+	store, err := env.testBlockStorageEnv.provider.Open("ledger1")
+	require.NoError(t, err)
+	defer store.Shutdown()
+
+	historydb := env.testHistoryDBProvider.GetDBHandle("ledger1")
+	bg, gb := testutil.NewBlockGenerator(t, "ledger1", false)
+	require.NoError(t, store.AddBlock(gb))
+	require.NoError(t, historydb.Commit(gb))
+	for i := 1; i <= 2; i++ {
+		simulator, err := env.txmgr.NewTxSimulator(util2.GenerateUUID())
+		require.NoError(t, err)
+		require.NoError(t, simulator.SetState("ns1", "key1", []byte{byte(i)}))
+		require.NoError(t, simulator.SetState("ns1", "key2", []byte{byte(i)}))
+		require.NoError(t, simulator.SetState("ns10", "key1", []byte{byte(i)}))
+		if i == 2 {
+			require.NoError(t, simulator.DeleteState("ns1", "key1"))
+		}
+		simulator.Done()
+		simRes, err := simulator.GetTxSimulationResults()
+		require.NoError(t, err)
+		pubSimResBytes, err := simRes.GetPubSimulationBytes()
+		require.NoError(t, err)
+		block := bg.NextBlock([][]byte{pubSimResBytes})
+		require.NoError(t, store.AddBlock(block))
+		require.NoError(t, historydb.Commit(block))
+	}
+
+	type result struct {
+		ns, key  string
+		blockNum uint64
+		isDelete bool
+	}
+	scan := func(ns, key string) []result {
+		var results []result
+		require.NoError(t, historydb.Scan(ns, key, store, func(e *Entry) error {
+			results = append(results, result{e.Namespace, e.Key, e.BlockNum, e.KeyModification.IsDelete})
+			return nil
+		}))
+		return results
+	}
+
+	require.Equal(t,
+		[]result{
+			{"ns1", "key1", 1, false},
+			{"ns1", "key1", 2, true},
+			{"ns1", "key2", 1, false},
+			{"ns1", "key2", 2, false},
+		},
+		scan("ns1", ""),
+	)
+	require.Equal(t,
+		[]result{
+			{"ns1", "key2", 1, false},
+			{"ns1", "key2", 2, false},
+		},
+		scan("ns1", "key2"),
+	)
+	require.Nil(t, scan("ns2", ""))
+
+	err = historydb.Scan("ns1", "", store, func(e *Entry) error { return errors.New("visit-error") })
+	require.EqualError(t, err, "visit-error")
+}
+
+func TestScanWithBlockHashes(t *testing.T) {
+	env := newTestHistoryEnv(t)
+	defer env.cleanup()
+	store, err := env.testBlockStorageEnv.provider.Open("ledger1")
+	require.NoError(t, err)
+	defer store.Shutdown()
+
+	historydb := env.testHistoryDBProvider.GetDBHandle("ledger1")
+	bg, gb := testutil.NewBlockGenerator(t, "ledger1", false)
+	require.NoError(t, store.AddBlock(gb))
+	require.NoError(t, historydb.Commit(gb))
+	blocks := []*common.Block{gb}
+	for i := 1; i <= 2; i++ {
+		simulator, err := env.txmgr.NewTxSimulator(util2.GenerateUUID())
+		require.NoError(t, err)
+		require.NoError(t, simulator.SetState("ns1", "key1", []byte{byte(i)}))
+		require.NoError(t, simulator.SetState("ns1", "key2", []byte{byte(i)}))
+		simulator.Done()
+		simRes, err := simulator.GetTxSimulationResults()
+		require.NoError(t, err)
+		pubSimResBytes, err := simRes.GetPubSimulationBytes()
+		require.NoError(t, err)
+		block := bg.NextBlock([][]byte{pubSimResBytes})
+		require.NoError(t, store.AddBlock(block))
+		require.NoError(t, historydb.Commit(block))
+		blocks = append(blocks, block)
+	}
+
+	numEntries := 0
+	require.NoError(t, historydb.ScanWithBlockHashes("ns1", "", store, func(e *Entry) error {
+		numEntries++
+		block := blocks[e.BlockNum]
+		require.Equal(t, protoutil.BlockHeaderHash(block.Header), e.BlockHash)
+		require.Equal(t, block.Header.PreviousHash, e.PreviousBlockHash)
+		require.Equal(t, protoutil.BlockHeaderHash(blocks[e.BlockNum-1].Header), e.PreviousBlockHash)
+		return nil
+	}))
+	require.Equal(t, 4, numEntries)
+
+	// a plain scan does not retrieve the blocks
+	require.NoError(t, historydb.Scan("ns1", "key1", store, func(e *Entry) error {
+		require.Nil(t, e.BlockHash)
+		require.Nil(t, e.PreviousBlockHash)
+		return nil
+	}))
+
+	// an inline entry for a block beyond the block store fails the scan
+	inline := protoutil.MarshalOrPanic(&queryresult.KeyModification{TxId: "txid", Value: []byte("value")})
+	require.NoError(t, historydb.levelDB.Put(constructDataKey("ns1", "key3", 5, 0), inline, true))
+	err = historydb.ScanWithBlockHashes("ns1", "key3", store, func(e *Entry) error { return nil })
+	require.Contains(t, err.Error(), "error while retrieving block [5] for its hash")
+}
+
+func TestScanIndex(t *testing.T) {
+	env := newTestHistoryEnv(t)
+	defer env.cleanup()
+
+	historydb := env.testHistoryDBProvider.GetDBHandle("ledger1")
+	batch := historydb.levelDB.NewUpdateBatch()
+	batch.Put(constructDataKey("ns1", "key1", 1, 0), []byte{})
+	batch.Put(constructDataKey("ns1", "key1", 2, 3), []byte("inline-key-modification"))
+	batch.Put(constructDataKey("ns2", "key1", 1, 0), []byte{})
+	batch.Put([]byte("ns3-malformed"), []byte{})
+	batch.Put(savePointKey, []byte("savepoint"))
+	batch.Put(backfillProgressKey, []byte("progress"))
+	require.NoError(t, historydb.levelDB.WriteBatch(batch, true))
+
+	type result struct {
+		ns, key           string
+		blockNum, tranNum uint64
+		inline, decodeErr bool
+	}
+	scanIndex := func(ns, key string) []result {
+		var results []result
+		require.NoError(t, historydb.ScanIndex(ns, key, func(e *IndexEntry) error {
+			results = append(results, result{e.Namespace, e.Key, e.BlockNum, e.TranNum, e.Inline, e.DecodeErr != nil})
+			return nil
+		}))
+		return results
+	}
+
+	require.Equal(t,
+		[]result{
+			{"ns1", "key1", 1, 0, false, false},
+			{"ns1", "key1", 2, 3, true, false},
+			{"ns2", "key1", 1, 0, false, false},
+			{"", "", 0, 0, false, true},
+		},
+		scanIndex("", ""),
+	)
+	require.Equal(t,
+		[]result{
+			{"ns1", "key1", 1, 0, false, false},
+			{"ns1", "key1", 2, 3, true, false},
+		},
+		scanIndex("ns1", "key1"),
+	)
+	require.Nil(t, scanIndex("ns1", "key2"))
+
+	err := historydb.ScanIndex("", "", func(e *IndexEntry) error { return errors.New("visit-error") })
+	require.EqualError(t, err, "visit-error")
+}
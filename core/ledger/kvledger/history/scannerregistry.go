@@ -0,0 +1,243 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// TooManyOpenScannersError is returned by the history queries when the number of the history scanners that are open,
+// across the ledgers, has reached the limit set via function `EnableMaxOpenScanners`
+type TooManyOpenScannersError struct {
+	MaxOpenScanners int
+}
+
+func (e *TooManyOpenScannersError) Error() string {
+	return fmt.Sprintf("the number of open history scanners has reached the limit [%d], close the scanners that are no longer in use", e.MaxOpenScanners)
+}
+
+// EnableMaxOpenScanners limits the number of the history scanners, i.e., the iterators returned by the history
+// queries, that are open at a time across the ledgers. Each open scanner holds a leveldb iterator, which retains
+// the memtables and the table files as of its creation and hence, the scanners leaked or hoarded by the clients
+// exhaust the memory and the file descriptors over time. Once the limit is reached, the history queries fail with
+// a TooManyOpenScannersError until some scanners are closed. A max of 0 or less leaves the number unlimited.
+func (p *DBProvider) EnableMaxOpenScanners(max int) {
+	p.scanners.setMaxOpen(max)
+}
+
+// EnableScannerLeakDetection enables releasing the history scanners that are neither advanced nor closed for the
+// given idleTimeout, as is the case with the scanners leaked by the clients. The leveldb iterator of such a scanner
+// is released and the scanner is logged along with the stack that created it, so that the offending client can be
+// identified. The subsequent calls to the Next method of a released scanner return an error. As the scanners are
+// checked periodically, a scanner may remain open for up to one and a half times the idleTimeout. An idleTimeout of
+// 0 or less leaves the leak detection disabled.
+func (p *DBProvider) EnableScannerLeakDetection(idleTimeout time.Duration) {
+	p.scanners.startLeakDetection(idleTimeout)
+}
+
+// scannerRegistry tracks the history scanners that are open across the ledgers of a DBProvider. The scanners used
+// internally by the bulk scans, which are closed before the scan returns, are not tracked.
+type scannerRegistry struct {
+	mutex   sync.Mutex
+	maxOpen int
+	open    map[*historyScanner]struct{}
+	// numOpen is the number of open scanners per ledger, as reported in the metrics
+	numOpen map[string]int
+
+	// idleTimeout is the duration after which an idle scanner is released by the leak detection, if enabled
+	idleTimeout time.Duration
+	stop        chan struct{}
+	stopped     sync.WaitGroup
+}
+
+func newScannerRegistry() *scannerRegistry {
+	return &scannerRegistry{
+		open:    map[*historyScanner]struct{}{},
+		numOpen: map[string]int{},
+	}
+}
+
+func (r *scannerRegistry) setMaxOpen(max int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.maxOpen = max
+}
+
+// register adds a scanner being opened, unless the limit on the open scanners is reached
+func (r *scannerRegistry) register(scanner *historyScanner) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.maxOpen > 0 && len(r.open) >= r.maxOpen {
+		return &TooManyOpenScannersError{MaxOpenScanners: r.maxOpen}
+	}
+	r.open[scanner] = struct{}{}
+	r.updateNumOpen(scanner.historyDB, 1)
+	return nil
+}
+
+// unregister removes a scanner being closed. A scanner closed more than once is removed once
+func (r *scannerRegistry) unregister(scanner *historyScanner) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, ok := r.open[scanner]; !ok {
+		return
+	}
+	delete(r.open, scanner)
+	r.updateNumOpen(scanner.historyDB, -1)
+}
+
+func (r *scannerRegistry) updateNumOpen(historyDB *DB, delta int) {
+	r.numOpen[historyDB.name] += delta
+	historyDB.stats.updateOpenScanners(r.numOpen[historyDB.name])
+	if r.numOpen[historyDB.name] == 0 {
+		delete(r.numOpen, historyDB.name)
+	}
+}
+
+// leakDetectionEnabled returns true if the scanners are to record their creation stack and the time of their last use
+func (r *scannerRegistry) leakDetectionEnabled() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.idleTimeout > 0
+}
+
+func (r *scannerRegistry) startLeakDetection(idleTimeout time.Duration) {
+	r.close()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.idleTimeout = idleTimeout
+	if idleTimeout <= 0 {
+		return
+	}
+	r.stop = make(chan struct{})
+	r.stopped.Add(1)
+	go func(stop chan struct{}) {
+		defer r.stopped.Done()
+		ticker := time.NewTicker(idleTimeout / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case now := <-ticker.C:
+				r.releaseIdleScanners(now, idleTimeout)
+			}
+		}
+	}(r.stop)
+}
+
+// releaseIdleScanners releases the scanners that are idle for the idleTimeout as of now and returns their number
+func (r *scannerRegistry) releaseIdleScanners(now time.Time, idleTimeout time.Duration) int {
+	r.mutex.Lock()
+	var idle []*historyScanner
+	for scanner := range r.open {
+		// the scanners created with the leak detection disabled are not tracked for idleness
+		if scanner.leakInfo != nil && now.Sub(scanner.leakInfo.lastUsedTime()) >= idleTimeout {
+			idle = append(idle, scanner)
+		}
+	}
+	r.mutex.Unlock()
+
+	numReleased := 0
+	for _, scanner := range idle {
+		if scanner.releaseIfIdle(now, idleTimeout) {
+			numReleased++
+		}
+	}
+	return numReleased
+}
+
+// close stops the leak detection, if started
+func (r *scannerRegistry) close() {
+	r.mutex.Lock()
+	stop := r.stop
+	r.stop = nil
+	r.mutex.Unlock()
+	if stop != nil {
+		close(stop)
+		r.stopped.Wait()
+	}
+}
+
+func (r *scannerRegistry) len() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return len(r.open)
+}
+
+// scannerLeakInfo is recorded for a scanner if the leak detection is enabled
+type scannerLeakInfo struct {
+	// lastUsed is the time, in unix nanoseconds, at which the scanner was created or last advanced
+	lastUsed  int64
+	createdAt time.Time
+	// creationStack is the stack of the function that created the scanner, formatted only if the scanner leaks
+	creationStack []uintptr
+}
+
+func newScannerLeakInfo() *scannerLeakInfo {
+	now := time.Now()
+	pcs := make([]uintptr, 32)
+	// skip the frames of runtime.Callers, this function, and function `GetHistoryForKey`
+	n := runtime.Callers(3, pcs)
+	return &scannerLeakInfo{
+		lastUsed:      now.UnixNano(),
+		createdAt:     now,
+		creationStack: pcs[:n],
+	}
+}
+
+func (i *scannerLeakInfo) markUsed() {
+	atomic.StoreInt64(&i.lastUsed, time.Now().UnixNano())
+}
+
+func (i *scannerLeakInfo) formatCreationStack() string {
+	var b strings.Builder
+	frames := runtime.CallersFrames(i.creationStack)
+	for {
+		frame, more := frames.Next()
+		fmt.Fprintf(&b, "%s\n\t%s:%d\n", frame.Function, frame.File, frame.Line)
+		if !more {
+			return b.String()
+		}
+	}
+}
+
+// lastUsedTime returns the time at which the scanner was created or last advanced
+func (i *scannerLeakInfo) lastUsedTime() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&i.lastUsed))
+}
+
+// releaseIfIdle releases the iterator of the scanner and logs the scanner as leaked, if the scanner is idle for the
+// idleTimeout as of now. A scanner being advanced concurrently is not idle
+func (scanner *historyScanner) releaseIfIdle(now time.Time, idleTimeout time.Duration) bool {
+	if !scanner.mutex.TryLock() {
+		return false
+	}
+	defer scanner.mutex.Unlock()
+	if scanner.leakInfo == nil || scanner.releasedErr != nil {
+		return false
+	}
+	idle := now.Sub(scanner.leakInfo.lastUsedTime())
+	if idle < idleTimeout {
+		return false
+	}
+	scanner.releasedErr = errors.Errorf("history scanner for namespace [%s] key [%s] was released after being idle for longer than %s",
+		scanner.namespace, scanner.key, idleTimeout)
+	scanner.dbItr.Release()
+	scanner.historyDB.scanners.unregister(scanner)
+	logger.Warnw("Released a leaked history scanner that was neither advanced nor closed",
+		"channel", scanner.historyDB.name, "namespace", scanner.namespace, "key", scanner.key,
+		"idle", idle.String(), "createdAt", scanner.leakInfo.createdAt, "creationStack", scanner.leakInfo.formatCreationStack())
+	return true
+}
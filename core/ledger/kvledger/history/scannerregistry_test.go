@@ -0,0 +1,163 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"testing"
+	"time"
+
+	commonledger "github.com/hyperledger/fabric/common/ledger"
+	"github.com/hyperledger/fabric/common/ledger/testutil"
+	"github.com/hyperledger/fabric/common/metrics"
+	"github.com/hyperledger/fabric/common/metrics/metricsfakes"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaxOpenScanners(t *testing.T) {
+	env := newTestHistoryEnv(t)
+	defer env.cleanup()
+	store, err := env.testBlockStorageEnv.provider.Open("ledger1")
+	require.NoError(t, err)
+	defer store.Shutdown()
+
+	fakeProvider := &metricsfakes.Provider{}
+	openScannersGauges := map[string]*metricsfakes.Gauge{}
+	fakeProvider.NewGaugeStub = func(opts metrics.GaugeOpts) metrics.Gauge {
+		fakeGauge := &metricsfakes.Gauge{}
+		fakeGauge.WithStub = func(labelValues ...string) metrics.Gauge {
+			if opts.Name != "open_scanners" {
+				return fakeGauge
+			}
+			g, ok := openScannersGauges[labelValues[1]]
+			if !ok {
+				g = &metricsfakes.Gauge{}
+				openScannersGauges[labelValues[1]] = g
+			}
+			return g
+		}
+		return fakeGauge
+	}
+	fakeProvider.NewCounterReturns(&metricsfakes.Counter{})
+	fakeHist := &metricsfakes.Histogram{}
+	fakeHist.WithReturns(fakeHist)
+	fakeProvider.NewHistogramReturns(fakeHist)
+	env.testHistoryDBProvider.EnableMetrics(fakeProvider)
+	env.testHistoryDBProvider.EnableMaxOpenScanners(2)
+
+	historydb1 := env.testHistoryDBProvider.GetDBHandle("ledger1")
+	historydb2 := env.testHistoryDBProvider.GetDBHandle("ledger2")
+	_, gb := testutil.NewBlockGenerator(t, "ledger1", false)
+	require.NoError(t, store.AddBlock(gb))
+	require.NoError(t, historydb1.Commit(gb))
+	require.NoError(t, historydb2.Commit(gb))
+	openScanners := func(ledgerid string) float64 {
+		g := openScannersGauges[ledgerid]
+		return g.SetArgsForCall(g.SetCallCount() - 1)
+	}
+
+	qe1, err := historydb1.NewQueryExecutor(store)
+	require.NoError(t, err)
+	qe2, err := historydb2.NewQueryExecutor(store)
+	require.NoError(t, err)
+	itr1, err := qe1.GetHistoryForKey("ns1", "key1")
+	require.NoError(t, err)
+	itr2, err := qe2.GetHistoryForKey("ns1", "key1")
+	require.NoError(t, err)
+	require.Equal(t, 2, env.testHistoryDBProvider.scanners.len())
+	require.Equal(t, float64(1), openScanners("ledger1"))
+	require.Equal(t, float64(1), openScanners("ledger2"))
+
+	// the limit applies across the ledgers
+	_, err = qe1.GetHistoryForKey("ns1", "key2")
+	require.Equal(t, &TooManyOpenScannersError{MaxOpenScanners: 2}, err)
+	require.EqualError(t, err, "the number of open history scanners has reached the limit [2], close the scanners that are no longer in use")
+
+	// a scanner closed more than once frees a single slot
+	itr2.Close()
+	itr2.Close()
+	require.Equal(t, 1, env.testHistoryDBProvider.scanners.len())
+	require.Equal(t, float64(0), openScanners("ledger2"))
+	var itr3 commonledger.ResultsIterator
+	itr3, err = qe1.GetHistoryForKey("ns1", "key2")
+	require.NoError(t, err)
+	require.Equal(t, float64(2), openScanners("ledger1"))
+	_, err = qe2.GetHistoryForKey("ns1", "key2")
+	require.IsType(t, &TooManyOpenScannersError{}, err)
+
+	itr1.Close()
+	itr3.Close()
+	require.Equal(t, 0, env.testHistoryDBProvider.scanners.len())
+	require.Equal(t, float64(0), openScanners("ledger1"))
+
+	t.Run("unlimited", func(t *testing.T) {
+		env.testHistoryDBProvider.EnableMaxOpenScanners(0)
+		var itrs []commonledger.ResultsIterator
+		for i := 0; i < 5; i++ {
+			itr, err := qe1.GetHistoryForKey("ns1", "key1")
+			require.NoError(t, err)
+			itrs = append(itrs, itr)
+		}
+		require.Equal(t, 5, env.testHistoryDBProvider.scanners.len())
+		for _, itr := range itrs {
+			itr.Close()
+		}
+		require.Equal(t, 0, env.testHistoryDBProvider.scanners.len())
+	})
+}
+
+func TestScannerLeakDetection(t *testing.T) {
+	env := newTestHistoryEnv(t)
+	defer env.cleanup()
+	store, err := env.testBlockStorageEnv.provider.Open("ledger1")
+	require.NoError(t, err)
+	defer store.Shutdown()
+
+	historydb := env.testHistoryDBProvider.GetDBHandle("ledger1")
+	_, gb := testutil.NewBlockGenerator(t, "ledger1", false)
+	require.NoError(t, store.AddBlock(gb))
+	require.NoError(t, historydb.Commit(gb))
+	qe, err := historydb.NewQueryExecutor(store)
+	require.NoError(t, err)
+	registry := env.testHistoryDBProvider.scanners
+
+	// a scanner created with the leak detection disabled is never released
+	untrackedItr, err := qe.GetHistoryForKey("ns1", "key1")
+	require.NoError(t, err)
+	defer untrackedItr.Close()
+
+	env.testHistoryDBProvider.EnableScannerLeakDetection(time.Hour)
+	itr, err := qe.GetHistoryForKey("ns1", "key1")
+	require.NoError(t, err)
+	scanner := itr.(*historyScanner)
+	require.Contains(t, scanner.leakInfo.formatCreationStack(), "TestScannerLeakDetection")
+	require.Equal(t, 0, registry.releaseIdleScanners(time.Now(), time.Hour))
+
+	// a scanner being advanced is not released
+	scanner.mutex.Lock()
+	require.Equal(t, 0, registry.releaseIdleScanners(time.Now().Add(2*time.Hour), time.Hour))
+	scanner.mutex.Unlock()
+
+	require.Equal(t, 1, registry.releaseIdleScanners(time.Now().Add(2*time.Hour), time.Hour))
+	require.Equal(t, 1, registry.len())
+	_, err = itr.Next()
+	require.EqualError(t, err, "history scanner for namespace [ns1] key [key1] was released after being idle for longer than 1h0m0s")
+	itr.Close()
+	require.Equal(t, 1, registry.len())
+
+	t.Run("released-in-background", func(t *testing.T) {
+		env.testHistoryDBProvider.EnableScannerLeakDetection(20 * time.Millisecond)
+		itr, err := qe.GetHistoryForKey("ns1", "key2")
+		require.NoError(t, err)
+		require.Equal(t, 2, registry.len())
+		require.Eventually(t, func() bool { return registry.len() == 1 }, 5*time.Second, 10*time.Millisecond)
+		_, err = itr.Next()
+		require.Error(t, err)
+
+		env.testHistoryDBProvider.EnableScannerLeakDetection(0)
+		require.Nil(t, registry.stop)
+	})
+}
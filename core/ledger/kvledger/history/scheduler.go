@@ -0,0 +1,152 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/metadata"
+)
+
+// QueryPriority classifies the history queries for scheduling their retrievals from the block store
+type QueryPriority int
+
+const (
+	// Interactive is the priority of the queries, such as GetHistoryForKey, whose latency matters to the caller
+	Interactive QueryPriority = iota
+	// Batch is the priority of the bulk queries, such as the scans and the exports, that are expected to yield
+	// to the interactive queries
+	Batch
+)
+
+// QueryPriorityMetadataKey is the gRPC metadata key with which a caller sets the priority of its history queries,
+// as "interactive" or "batch"
+const QueryPriorityMetadataKey = "history-query-priority"
+
+type queryPriorityKey struct{}
+
+// WithQueryPriority returns a context that carries the given priority for the history queries
+func WithQueryPriority(ctx context.Context, priority QueryPriority) context.Context {
+	return context.WithValue(ctx, queryPriorityKey{}, priority)
+}
+
+// QueryPriorityFromContext returns the priority for the history queries carried by the context, as set via function
+// `WithQueryPriority` or, otherwise, via the incoming gRPC metadata. A context that carries no priority, or an unknown
+// one in the metadata, is Interactive.
+func QueryPriorityFromContext(ctx context.Context) QueryPriority {
+	if priority, ok := ctx.Value(queryPriorityKey{}).(QueryPriority); ok {
+		return priority
+	}
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return Interactive
+	}
+	if values := md.Get(QueryPriorityMetadataKey); len(values) > 0 && values[0] == "batch" {
+		return Batch
+	}
+	return Interactive
+}
+
+// queryScheduler schedules the retrievals of the transactions from the block store by the history queries, so
+// that the bulk queries do not starve the interactive ones. The retrievals for the Batch queries are bounded to
+// maxBatchRetrievals at a time, and each of them waits, up to maxYield, for the in-flight retrievals for the
+// Interactive queries to complete. The bound on the wait keeps a steady stream of the interactive queries from
+// stalling the batch queries indefinitely. A nil queryScheduler is valid and schedules nothing.
+type queryScheduler struct {
+	batchSlots chan struct{}
+	maxYield   time.Duration
+
+	mutex sync.Mutex
+	// interactive is the number of the in-flight retrievals for the Interactive queries and idle, which is not nil
+	// while there are any, is closed once they complete
+	interactive int
+	idle        chan struct{}
+}
+
+// EnableQueryScheduling bounds the retrievals from the block store for the Batch queries, across the ledgers, to
+// maxBatchRetrievals at a time, with each of them yielding up to maxYield to the in-flight retrievals for the
+// Interactive queries. The bulk scans, such as function `Scan` and the history export, are Batch queries, as are the
+// queries via a query executor created for a context with the Batch priority. A maxBatchRetrievals of 0 leaves the
+// scheduling disabled.
+func (p *DBProvider) EnableQueryScheduling(maxBatchRetrievals int, maxYield time.Duration) error {
+	if maxBatchRetrievals < 0 || maxYield < 0 {
+		return errors.Errorf("invalid query scheduling config: maxBatchRetrievals [%d] and maxYield [%s] must not be negative",
+			maxBatchRetrievals, maxYield)
+	}
+	if maxBatchRetrievals == 0 {
+		p.scheduler = nil
+		return nil
+	}
+	p.scheduler = &queryScheduler{
+		batchSlots: make(chan struct{}, maxBatchRetrievals),
+		maxYield:   maxYield,
+	}
+	return nil
+}
+
+// begin schedules a retrieval, blocking a retrieval for a Batch query as required, and returns the function to be
+// invoked once the retrieval completes
+func (s *queryScheduler) begin(priority QueryPriority) (end func()) {
+	if s == nil {
+		return func() {}
+	}
+	if priority == Batch {
+		s.yield()
+		s.batchSlots <- struct{}{}
+		return func() { <-s.batchSlots }
+	}
+	s.mutex.Lock()
+	if s.interactive == 0 {
+		s.idle = make(chan struct{})
+	}
+	s.interactive++
+	s.mutex.Unlock()
+	return s.endInteractive
+}
+
+func (s *queryScheduler) endInteractive() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.interactive--
+	if s.interactive == 0 {
+		close(s.idle)
+		s.idle = nil
+	}
+}
+
+// yield waits, up to maxYield, for the in-flight retrievals for the Interactive queries to complete
+func (s *queryScheduler) yield() {
+	s.mutex.Lock()
+	idle := s.idle
+	s.mutex.Unlock()
+	if idle == nil || s.maxYield == 0 {
+		return
+	}
+	timer := time.NewTimer(s.maxYield)
+	defer timer.Stop()
+	select {
+	case <-idle:
+	case <-timer.C:
+	}
+}
+
+// NewQueryExecutorForContext is same as function `NewQueryExecutor`, except that the queries via the returned executor
+// are scheduled with the priority carried by the context (see function `QueryPriorityFromContext`). The queries with
+// the Batch priority also leave the decoded transaction cache untouched, as for the bulk scans. The queries are accounted
+// to the quotas of the client carried by the context, if any (see function `QueryClientFromContext`).
+func (d *DB) NewQueryExecutorForContext(ctx context.Context, txFetcher TxFetcher) (*QueryExecutor, error) {
+	return &QueryExecutor{
+		levelDB:   d.levelDB,
+		txFetcher: txFetcher,
+		historyDB: d,
+		priority:  QueryPriorityFromContext(ctx),
+		client:    QueryClientFromContext(ctx),
+	}, nil
+}
@@ -0,0 +1,116 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric/common/ledger/testutil"
+	util2 "github.com/hyperledger/fabric/common/util"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestQueryPriorityFromContext(t *testing.T) {
+	require.Equal(t, Interactive, QueryPriorityFromContext(context.Background()))
+	require.Equal(t, Batch, QueryPriorityFromContext(WithQueryPriority(context.Background(), Batch)))
+
+	incoming := func(priority string) context.Context {
+		return metadata.NewIncomingContext(context.Background(), metadata.Pairs(QueryPriorityMetadataKey, priority))
+	}
+	require.Equal(t, Batch, QueryPriorityFromContext(incoming("batch")))
+	require.Equal(t, Interactive, QueryPriorityFromContext(incoming("interactive")))
+	require.Equal(t, Interactive, QueryPriorityFromContext(incoming("unknown")))
+	// the priority set on the context takes precedence over the metadata
+	require.Equal(t, Interactive, QueryPriorityFromContext(WithQueryPriority(incoming("batch"), Interactive)))
+}
+
+func TestQueryScheduler(t *testing.T) {
+	p := &DBProvider{}
+	require.EqualError(t, p.EnableQueryScheduling(-1, time.Second),
+		"invalid query scheduling config: maxBatchRetrievals [-1] and maxYield [1s] must not be negative")
+	require.NoError(t, p.EnableQueryScheduling(0, time.Second))
+	require.Nil(t, p.scheduler)
+	// a nil scheduler schedules nothing
+	p.scheduler.begin(Batch)()
+
+	began := func(s *queryScheduler, priority QueryPriority) <-chan func() {
+		ch := make(chan func(), 1)
+		go func() { ch <- s.begin(priority) }()
+		return ch
+	}
+
+	t.Run("batch-yields-to-interactive", func(t *testing.T) {
+		require.NoError(t, p.EnableQueryScheduling(2, time.Hour))
+		endInteractive := p.scheduler.begin(Interactive)
+		batch := began(p.scheduler, Batch)
+		require.Never(t, func() bool { return len(batch) > 0 }, 100*time.Millisecond, 10*time.Millisecond)
+		endInteractive()
+		(<-batch)()
+	})
+
+	t.Run("yield-is-bounded", func(t *testing.T) {
+		require.NoError(t, p.EnableQueryScheduling(2, 50*time.Millisecond))
+		endInteractive := p.scheduler.begin(Interactive)
+		defer endInteractive()
+		batch := began(p.scheduler, Batch)
+		require.Eventually(t, func() bool { return len(batch) > 0 }, time.Second, 10*time.Millisecond)
+		(<-batch)()
+	})
+
+	t.Run("batch-concurrency-bounded", func(t *testing.T) {
+		require.NoError(t, p.EnableQueryScheduling(1, 0))
+		endBatch := p.scheduler.begin(Batch)
+		batch := began(p.scheduler, Batch)
+		require.Never(t, func() bool { return len(batch) > 0 }, 100*time.Millisecond, 10*time.Millisecond)
+		// the interactive retrievals are not bounded
+		p.scheduler.begin(Interactive)()
+		endBatch()
+		(<-batch)()
+	})
+}
+
+func TestBatchQueryExecutor(t *testing.T) {
+	env := newTestHistoryEnv(t)
+	defer env.cleanup()
+	store, err := env.testBlockStorageEnv.provider.Open("ledger1")
+	require.NoError(t, err)
+	defer store.Shutdown()
+
+	env.testHistoryDBProvider.EnableDecodedTxCache(10, 0)
+	require.NoError(t, env.testHistoryDBProvider.EnableQueryScheduling(1, time.Millisecond))
+	historydb := env.testHistoryDBProvider.GetDBHandle("ledger1")
+	bg, gb := testutil.NewBlockGenerator(t, "ledger1", false)
+	commit := func(block *common.Block) {
+		require.NoError(t, store.AddBlock(block))
+		require.NoError(t, historydb.Commit(block))
+	}
+	commit(gb)
+	simulator, err := env.txmgr.NewTxSimulator(util2.GenerateUUID())
+	require.NoError(t, err)
+	require.NoError(t, simulator.SetState("ns1", "key1", []byte("value1")))
+	simulator.Done()
+	simRes, err := simulator.GetTxSimulationResults()
+	require.NoError(t, err)
+	pubSimResBytes, err := simRes.GetPubSimulationBytes()
+	require.NoError(t, err)
+	commit(bg.NextBlock([][]byte{pubSimResBytes}))
+
+	// the batch queries leave the decoded transaction cache untouched
+	qe, err := historydb.NewQueryExecutorForContext(WithQueryPriority(context.Background(), Batch), store)
+	require.NoError(t, err)
+	testutilVerifyResults(t, qe, "ns1", "key1", []string{"value1"})
+	require.Equal(t, 0, historydb.txCache.len())
+
+	qe, err = historydb.NewQueryExecutorForContext(context.Background(), store)
+	require.NoError(t, err)
+	testutilVerifyResults(t, qe, "ns1", "key1", []string{"value1"})
+	require.Equal(t, 1, historydb.txCache.len())
+}
@@ -0,0 +1,209 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"bytes"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric/common/ledger/util/leveldbhelper"
+	"github.com/pkg/errors"
+)
+
+const (
+	// searchViewName is the name of the history view that maintains the full-text search index
+	searchViewName = "fulltext"
+	// maxSearchTermLength is the maximum length, in bytes, of an indexed term. Longer terms are not indexed
+	maxSearchTermLength = 64
+)
+
+// EnableFullTextSearch enables an inverted index over the values written to the given namespaces. A value is
+// indexed under each of its terms, where a term is a lowercased sequence of letters and digits. Values that are not
+// valid UTF-8 are not indexed. The index is maintained as a history view and hence, covers the history entries added
+// after the search is enabled and is rebuilt along with the history (see function `RegisterView`).
+func (p *DBProvider) EnableFullTextSearch(namespaces []string) error {
+	if len(namespaces) == 0 {
+		return nil
+	}
+	view := &searchView{namespaces: map[string]struct{}{}}
+	for _, ns := range namespaces {
+		if ns == "" || bytes.Contains([]byte(ns), compositeKeySep) {
+			return errors.Errorf("invalid namespace [%s] for full-text search, the namespace cannot be empty or contain the byte 0x00", ns)
+		}
+		view.namespaces[ns] = struct{}{}
+	}
+	return p.RegisterView(view)
+}
+
+// SearchHistory invokes the function visit for the history entries in the namespace ns whose written value contains
+// all the terms in the query. The terms are matched in full and regardless of the case. The entries are visited in the
+// order of keys and, for a key, in the order of oldest to newest. Deletes are not indexed and hence, never visited.
+// The postings of the terms are intersected as they are read from the index, so that the memory used does not grow
+// with the number of the entries indexed under a term. If the response caps are enabled (see function
+// `EnableResponseCaps`), the search fails with a ResponseTooLargeError once the matches exceed a cap, after the
+// matches within the caps are visited.
+func (d *DB) SearchHistory(ns, query string, txFetcher TxFetcher, visit func(*Entry) error) error {
+	view, ok := d.view(searchViewName).(*searchView)
+	if !ok || !view.indexes(ns) {
+		return errors.Errorf("full-text search is not enabled for namespace [%s]", ns)
+	}
+	terms := searchTerms([]byte(query))
+	if len(terms) == 0 {
+		return errors.Errorf("query [%s] contains no searchable terms", query)
+	}
+
+	postings := make([]*postingsIterator, 0, len(terms))
+	defer func() {
+		for _, p := range postings {
+			p.release()
+		}
+	}()
+	for _, term := range terms {
+		p, err := d.newPostingsIterator(searchViewName, constructSearchRow(ns, term))
+		if err != nil {
+			return err
+		}
+		postings = append(postings, p)
+	}
+
+	counter := d.responseCaps.newCounter()
+	for {
+		match, err := nextCommonDataKey(postings)
+		if err != nil || match == nil {
+			return err
+		}
+		e, err := d.resolveViewEntry(searchViewName, match, txFetcher)
+		if err != nil {
+			return err
+		}
+		if !counter.add(len(e.KeyModification.Value)) {
+			return d.responseCaps.tooLargeError()
+		}
+		if err := visit(e); err != nil {
+			return err
+		}
+		for _, p := range postings {
+			p.next()
+		}
+	}
+}
+
+// postingsIterator iterates, in the order of the dataKeys, over the dataKeys of the history entries indexed under a
+// row of a view
+type postingsIterator struct {
+	itr       *leveldbhelper.Iterator
+	rowPrefix []byte
+	valid     bool
+}
+
+func (d *DB) newPostingsIterator(viewName, row string) (*postingsIterator, error) {
+	rowPrefix := constructViewRowPrefix(viewName, row)
+	itr, err := d.levelDB.GetIterator(rowPrefix, append(rowPrefix, 0xff))
+	if err != nil {
+		return nil, err
+	}
+	p := &postingsIterator{itr: itr, rowPrefix: rowPrefix}
+	p.next()
+	return p, nil
+}
+
+func (p *postingsIterator) dataKey() dataKey {
+	return bytes.TrimPrefix(p.itr.Key(), p.rowPrefix)
+}
+
+func (p *postingsIterator) next() {
+	p.valid = p.itr.Next()
+}
+
+// seek moves the iterator to the first dataKey that is not less than the given dataKey
+func (p *postingsIterator) seek(k dataKey) {
+	p.valid = p.itr.Seek(append(append([]byte{}, p.rowPrefix...), k...))
+}
+
+func (p *postingsIterator) release() {
+	p.itr.Release()
+}
+
+// nextCommonDataKey advances the iterators to the next dataKey present in all of them and returns it, or nil once an
+// iterator is exhausted. Each iterator that is behind the largest current dataKey seeks forward to it, so that the
+// postings of the frequent terms are skipped over in the index rather than read in full
+func nextCommonDataKey(postings []*postingsIterator) (dataKey, error) {
+	for {
+		var max dataKey
+		for _, p := range postings {
+			if !p.valid {
+				return nil, errors.Wrap(p.itr.Error(), "internal leveldb error while iterating for history view rows")
+			}
+			if k := p.dataKey(); max == nil || bytes.Compare(k, max) > 0 {
+				max = k
+			}
+		}
+		max = append(dataKey{}, max...)
+		aligned := true
+		for _, p := range postings {
+			if bytes.Compare(p.dataKey(), max) < 0 {
+				p.seek(max)
+				aligned = false
+			}
+		}
+		if aligned {
+			return max, nil
+		}
+	}
+}
+
+// searchView is a history view that indexes the writes by the terms in the written values
+type searchView struct {
+	namespaces map[string]struct{}
+}
+
+func (v *searchView) Name() string {
+	return searchViewName
+}
+
+func (v *searchView) Project(ns, key string, value []byte, isDelete bool) ([]string, error) {
+	if !v.indexes(ns) || isDelete || !utf8.Valid(value) {
+		return nil, nil
+	}
+	var rows []string
+	for _, term := range searchTerms(value) {
+		rows = append(rows, constructSearchRow(ns, term))
+	}
+	return rows, nil
+}
+
+func (v *searchView) indexes(ns string) bool {
+	_, ok := v.namespaces[ns]
+	return ok
+}
+
+// searchTerms returns the distinct lowercased terms in the text, in the order of their first occurrence
+func searchTerms(text []byte) []string {
+	var terms []string
+	seen := map[string]struct{}{}
+	for _, field := range strings.FieldsFunc(string(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}) {
+		term := strings.ToLower(field)
+		if len(term) > maxSearchTermLength {
+			continue
+		}
+		if _, ok := seen[term]; ok {
+			continue
+		}
+		seen[term] = struct{}{}
+		terms = append(terms, term)
+	}
+	return terms
+}
+
+// constructSearchRow builds the row of the format namespace~term
+func constructSearchRow(ns, term string) string {
+	return ns + string(compositeKeySep) + term
+}
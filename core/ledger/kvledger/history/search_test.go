@@ -0,0 +1,111 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/ledger/testutil"
+	util2 "github.com/hyperledger/fabric/common/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchHistory(t *testing.T) {
+	env := newTestHistoryEnv(t)
+	defer env.cleanup()
+	store, err := env.testBlockStorageEnv.provider.Open("ledger1")
+	require.NoError(t, err)
+	defer store.Shutdown()
+
+	require.NoError(t, env.testHistoryDBProvider.EnableFullTextSearch([]string{"ns1"}))
+	historydb := env.testHistoryDBProvider.GetDBHandle("ledger1")
+	bg, gb := testutil.NewBlockGenerator(t, "ledger1", false)
+	require.NoError(t, store.AddBlock(gb))
+	require.NoError(t, historydb.Commit(gb))
+
+	writes := []map[string]string{
+		{"key1": `{"desc":"Red car, shipped"}`, "key2": "blue car"},
+		{"key1": "red bicycle", "key2": "\xff\xfe red car"},
+		{"key1": "", "key2": "RED CAR " + strings.Repeat("x", maxSearchTermLength+1)},
+	}
+	for _, blockWrites := range writes {
+		simulator, err := env.txmgr.NewTxSimulator(util2.GenerateUUID())
+		require.NoError(t, err)
+		for k, v := range blockWrites {
+			if v == "" {
+				require.NoError(t, simulator.DeleteState("ns1", k))
+				continue
+			}
+			require.NoError(t, simulator.SetState("ns1", k, []byte(v)))
+			require.NoError(t, simulator.SetState("ns2", k, []byte(v)))
+		}
+		simulator.Done()
+		simRes, err := simulator.GetTxSimulationResults()
+		require.NoError(t, err)
+		pubSimResBytes, err := simRes.GetPubSimulationBytes()
+		require.NoError(t, err)
+		block := bg.NextBlock([][]byte{pubSimResBytes})
+		require.NoError(t, store.AddBlock(block))
+		require.NoError(t, historydb.Commit(block))
+	}
+
+	type result struct {
+		key      string
+		blockNum uint64
+	}
+	search := func(query string) []result {
+		var results []result
+		require.NoError(t, historydb.SearchHistory("ns1", query, store, func(e *Entry) error {
+			results = append(results, result{e.Key, e.BlockNum})
+			return nil
+		}))
+		return results
+	}
+
+	require.Equal(t, []result{{"key1", 1}, {"key1", 2}, {"key2", 3}}, search("red"))
+	require.Equal(t, []result{{"key1", 1}, {"key2", 3}}, search("Red Car"))
+	require.Equal(t, []result{{"key1", 1}, {"key2", 1}, {"key2", 3}}, search("car"))
+	require.Equal(t, []result{{"key1", 1}}, search("shipped desc"))
+	require.Nil(t, search("re"))
+	require.Nil(t, search("blue bicycle"))
+
+	// the search fails once the matches exceed the response caps, after visiting the matches within the caps
+	require.NoError(t, env.testHistoryDBProvider.EnableResponseCaps(2, 0))
+	historydb = env.testHistoryDBProvider.GetDBHandle("ledger1")
+	var results []result
+	err = historydb.SearchHistory("ns1", "car", store, func(e *Entry) error {
+		results = append(results, result{e.Key, e.BlockNum})
+		return nil
+	})
+	require.Equal(t, &ResponseTooLargeError{MaxEntries: 2}, err)
+	require.Equal(t, []result{{"key1", 1}, {"key2", 1}}, results)
+	require.Equal(t, []result{{"key1", 1}, {"key2", 3}}, search("red car"))
+
+	err = historydb.SearchHistory("ns2", "red", store, func(e *Entry) error { return nil })
+	require.EqualError(t, err, "full-text search is not enabled for namespace [ns2]")
+	err = historydb.SearchHistory("ns1", " ,;", store, func(e *Entry) error { return nil })
+	require.EqualError(t, err, "query [ ,;] contains no searchable terms")
+}
+
+func TestEnableFullTextSearch(t *testing.T) {
+	provider, err := NewDBProvider(t.TempDir())
+	require.NoError(t, err)
+	defer provider.Close()
+
+	require.NoError(t, provider.EnableFullTextSearch(nil))
+	require.Empty(t, provider.views)
+	require.EqualError(t, provider.EnableFullTextSearch([]string{""}),
+		"invalid namespace [] for full-text search, the namespace cannot be empty or contain the byte 0x00")
+	require.NoError(t, provider.EnableFullTextSearch([]string{"ns1"}))
+	require.EqualError(t, provider.EnableFullTextSearch([]string{"ns1"}), "history view [fulltext] is already registered")
+}
+
+func TestSearchTerms(t *testing.T) {
+	require.Equal(t, []string{"red", "car", "year", "2024", "ünïcode"}, searchTerms([]byte(`{"Red":"car","year":2024} RED ÜNÏCODE`)))
+	require.Nil(t, searchTerms([]byte(" ,;")))
+}
@@ -0,0 +1,166 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const sizeSampleBytesLen = 24
+
+// sizeSample is a sample of the on-disk size of the index for a namespace, along with the ApproxSizeBytes of the
+// index statistics at the time of the sample
+type sizeSample struct {
+	diskSizeBytes   uint64
+	approxSizeBytes uint64
+	sampledAt       time.Time
+}
+
+// DiskUsage is the approximate disk usage of the history database of a channel (see function `GetDiskUsage`)
+type DiskUsage struct {
+	// TotalBytes is the size on the disk of all the data of the channel, including the bookkeeping data and the rows
+	// of the history views, which are not attributed to the namespaces
+	TotalBytes uint64
+	// Namespaces maps each namespace that has entries in the index to the size on the disk of its entries
+	Namespaces map[string]uint64
+}
+
+// GetDiskUsage returns the approximate disk usage of the history database of the channel and of each namespace
+// that has entries in the index. The sizes are computed on demand from the ranges of the sstables of leveldb and
+// hence, are cheap to compute and reflect the compression of leveldb. As for function `SampleIndexSizes`, the size of
+// the entries still held in the memtable is not counted until they are flushed.
+func (d *DB) GetDiskUsage() (*DiskUsage, error) {
+	return d.diskUsage(nil)
+}
+
+// SampleIndexSizes samples the on-disk size of the index for each namespace that has entries in the index, so that
+// the DiskSizeBytes of the index statistics is reconciled with the actual size on the disk. The incrementally
+// maintained ApproxSizeBytes counts the bytes written and hence, does not reflect the compression and the space
+// amplification of leveldb. The size of the entries still held in the memtable is not counted until they are
+// flushed. The rows of the history views are not attributed to the namespaces. The distinct keys of each namespace
+// are reported as well, so that the metric is available after a restart for the namespaces not written to since.
+func (d *DB) SampleIndexSizes() error {
+	d.statsLock.Lock()
+	defer d.statsLock.Unlock()
+	batch := d.levelDB.NewUpdateBatch()
+	now := time.Now()
+	_, err := d.diskUsage(func(ns string, stats *IndexStats, diskSize uint64) {
+		d.stats.updateDistinctKeys(ns, stats.DistinctKeys)
+		sample := &sizeSample{
+			diskSizeBytes:   diskSize,
+			approxSizeBytes: stats.ApproxSizeBytes,
+			sampledAt:       now,
+		}
+		batch.Put(constructSizeSampleKey(ns), sample.toBytes())
+	})
+	if err != nil {
+		return err
+	}
+	return d.levelDB.WriteBatch(batch, true)
+}
+
+// diskUsage computes the disk usage of the channel and of each namespace that has the index statistics. The
+// function onNamespace, if not nil, is invoked with the statistics and the on-disk size of each namespace
+func (d *DB) diskUsage(onNamespace func(ns string, stats *IndexStats, diskSize uint64)) (*DiskUsage, error) {
+	totalSize, err := d.levelDB.ApproximateSize(nil, nil)
+	if err != nil {
+		return nil, errors.WithMessage(err, "error while computing the disk usage of the history database")
+	}
+	usage := &DiskUsage{
+		TotalBytes: uint64(totalSize),
+		Namespaces: map[string]uint64{},
+	}
+	itr, err := d.levelDB.GetIterator(indexStatsKeyPrefix, append(append([]byte{}, indexStatsKeyPrefix...), 0xff))
+	if err != nil {
+		return nil, err
+	}
+	defer itr.Release()
+	for itr.Next() {
+		if err := itr.Error(); err != nil {
+			return nil, errors.Wrap(err, "internal leveldb error while iterating for index stats")
+		}
+		ns := string(bytes.TrimPrefix(itr.Key(), indexStatsKeyPrefix))
+		stats, err := indexStatsFromBytes(itr.Value())
+		if err != nil {
+			return nil, err
+		}
+		diskSize, err := d.levelDB.ApproximateSize(scanRange(ns, ""))
+		if err != nil {
+			return nil, errors.WithMessagef(err, "error while sampling the index size for namespace [%s]", ns)
+		}
+		usage.Namespaces[ns] = uint64(diskSize)
+		if onNamespace != nil {
+			onNamespace(ns, stats, uint64(diskSize))
+		}
+	}
+	if err := itr.Error(); err != nil {
+		return nil, errors.Wrap(err, "internal leveldb error while iterating for index stats")
+	}
+	return usage, nil
+}
+
+// RunIndexSizeSampling samples the index sizes, via function `SampleIndexSizes`, at the start and then every
+// interval until the stop channel is closed
+func (d *DB) RunIndexSizeSampling(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := d.SampleIndexSizes(); err != nil {
+			logger.Warnw("Error while sampling the history index sizes", "channel", d.name, "error", err)
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *DB) getSizeSample(ns string) (*sizeSample, error) {
+	b, err := d.levelDB.Get(constructSizeSampleKey(ns))
+	if err != nil || b == nil {
+		return nil, err
+	}
+	return sizeSampleFromBytes(b)
+}
+
+// estimateDiskSize returns the on-disk size of the index as of the given ApproxSizeBytes, by adding to the sampled
+// size the bytes written since the sample, scaled by the compression ratio observed by the sample
+func (s *sizeSample) estimateDiskSize(approxSizeBytes uint64) uint64 {
+	if approxSizeBytes <= s.approxSizeBytes || s.approxSizeBytes == 0 {
+		return s.diskSizeBytes
+	}
+	added := float64(approxSizeBytes-s.approxSizeBytes) * float64(s.diskSizeBytes) / float64(s.approxSizeBytes)
+	return s.diskSizeBytes + uint64(added)
+}
+
+func constructSizeSampleKey(ns string) []byte {
+	return append(append([]byte{}, sizeSampleKeyPrefix...), ns...)
+}
+
+func (s *sizeSample) toBytes() []byte {
+	b := make([]byte, sizeSampleBytesLen)
+	binary.BigEndian.PutUint64(b[0:8], s.diskSizeBytes)
+	binary.BigEndian.PutUint64(b[8:16], s.approxSizeBytes)
+	binary.BigEndian.PutUint64(b[16:24], uint64(s.sampledAt.UnixNano()))
+	return b
+}
+
+func sizeSampleFromBytes(b []byte) (*sizeSample, error) {
+	if len(b) != sizeSampleBytesLen {
+		return nil, errors.Errorf("unexpected length of the index size sample bytes: %d", len(b))
+	}
+	return &sizeSample{
+		diskSizeBytes:   binary.BigEndian.Uint64(b[0:8]),
+		approxSizeBytes: binary.BigEndian.Uint64(b[8:16]),
+		sampledAt:       time.Unix(0, int64(binary.BigEndian.Uint64(b[16:24]))),
+	}, nil
+}
@@ -0,0 +1,243 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/ledger/internal/version"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTestIndexEntries writes count entries for the namespace, starting from the entry from, along with the index
+// statistics of the namespace as of the entries written, and returns the ApproxSizeBytes of the statistics
+func writeTestIndexEntries(t *testing.T, historydb *DB, ns string, from, count int) uint64 {
+	approxSize := uint64(0)
+	batch := historydb.levelDB.NewUpdateBatch()
+	for i := 0; i < from+count; i++ {
+		key := constructDataKey(ns, fmt.Sprintf("key-%06d", i), uint64(i), 0)
+		val := []byte(fmt.Sprintf("value-%06d", i))
+		if i >= from {
+			batch.Put(key, val)
+		}
+		approxSize += uint64(len(key) + len(val))
+	}
+	batch.Put(constructIndexStatsKey(ns), (&IndexStats{TotalIndexEntries: uint64(from + count), ApproxSizeBytes: approxSize}).toBytes())
+	require.NoError(t, historydb.levelDB.WriteBatch(batch, true))
+	return approxSize
+}
+
+func TestIndexSizeSampling(t *testing.T) {
+	provider, err := NewDBProvider(t.TempDir())
+	require.NoError(t, err)
+	defer provider.Close()
+	historydb := provider.GetDBHandle("ledger1")
+
+	approxSize := 0
+	batch := historydb.levelDB.NewUpdateBatch()
+	for i := 0; i < 5000; i++ {
+		key := constructDataKey("ns1", fmt.Sprintf("key-%06d", i), uint64(i), 0)
+		val := []byte(fmt.Sprintf("value-%06d", i))
+		batch.Put(key, val)
+		approxSize += len(key) + len(val)
+	}
+	batch.Put(constructIndexStatsKey("ns1"), (&IndexStats{TotalIndexEntries: 5000, ApproxSizeBytes: uint64(approxSize)}).toBytes())
+	require.NoError(t, historydb.levelDB.WriteBatch(batch, true))
+	require.NoError(t, historydb.levelDB.CompactRange(nil, nil))
+
+	// no sample is taken yet
+	stats, err := historydb.GetIndexStats("ns1")
+	require.NoError(t, err)
+	require.Zero(t, stats.DiskSizeBytes)
+
+	require.NoError(t, historydb.SampleIndexSizes())
+	diskSize, err := historydb.levelDB.ApproximateSize(scanRange("ns1", ""))
+	require.NoError(t, err)
+	require.Greater(t, diskSize, int64(0))
+	stats, err = historydb.GetIndexStats("ns1")
+	require.NoError(t, err)
+	require.Equal(t, uint64(diskSize), stats.DiskSizeBytes)
+
+	// the entries added since the sample are accounted for at the sampled compression ratio
+	require.NoError(t, historydb.levelDB.Put(constructIndexStatsKey("ns1"),
+		(&IndexStats{TotalIndexEntries: 10000, ApproxSizeBytes: uint64(2 * approxSize)}).toBytes(), true))
+	stats, err = historydb.GetIndexStats("ns1")
+	require.NoError(t, err)
+	require.Equal(t, uint64(2*diskSize), stats.DiskSizeBytes)
+
+	// a namespace without entries is not sampled
+	stats, err = historydb.GetIndexStats("ns2")
+	require.NoError(t, err)
+	require.Equal(t, &IndexStats{}, stats)
+}
+
+func TestGetDiskUsage(t *testing.T) {
+	provider, err := NewDBProvider(t.TempDir())
+	require.NoError(t, err)
+	defer provider.Close()
+	historydb := provider.GetDBHandle("ledger1")
+
+	usage, err := historydb.GetDiskUsage()
+	require.NoError(t, err)
+	require.Equal(t, &DiskUsage{Namespaces: map[string]uint64{}}, usage)
+
+	batch := historydb.levelDB.NewUpdateBatch()
+	for i := 0; i < 5000; i++ {
+		for _, ns := range []string{"ns1", "ns2"} {
+			batch.Put(constructDataKey(ns, fmt.Sprintf("key-%06d", i), uint64(i), 0), []byte(fmt.Sprintf("value-%06d", i)))
+		}
+	}
+	batch.Put(constructIndexStatsKey("ns1"), (&IndexStats{TotalIndexEntries: 5000}).toBytes())
+	batch.Put(constructIndexStatsKey("ns2"), (&IndexStats{TotalIndexEntries: 5000}).toBytes())
+	require.NoError(t, historydb.levelDB.WriteBatch(batch, true))
+	require.NoError(t, historydb.levelDB.CompactRange(nil, nil))
+
+	usage, err = historydb.GetDiskUsage()
+	require.NoError(t, err)
+	require.Len(t, usage.Namespaces, 2)
+	for _, ns := range []string{"ns1", "ns2"} {
+		diskSize, err := historydb.levelDB.ApproximateSize(scanRange(ns, ""))
+		require.NoError(t, err)
+		require.Greater(t, diskSize, int64(0))
+		require.Equal(t, uint64(diskSize), usage.Namespaces[ns])
+	}
+	require.GreaterOrEqual(t, usage.TotalBytes, usage.Namespaces["ns1"]+usage.Namespaces["ns2"])
+
+	// the disk usage is not persisted as a sample of the index sizes
+	stats, err := historydb.GetIndexStats("ns1")
+	require.NoError(t, err)
+	require.Zero(t, stats.DiskSizeBytes)
+}
+
+func TestEstimateDiskSize(t *testing.T) {
+	sample := &sizeSample{diskSizeBytes: 100, approxSizeBytes: 400}
+	require.Equal(t, uint64(100), sample.estimateDiskSize(400))
+	require.Equal(t, uint64(125), sample.estimateDiskSize(500))
+	// the size does not shrink below the sample, as the entries are never removed from the index
+	require.Equal(t, uint64(100), sample.estimateDiskSize(300))
+	require.Equal(t, uint64(100), (&sizeSample{diskSizeBytes: 100}).estimateDiskSize(500))
+}
+
+func TestRunIndexSizeSampling(t *testing.T) {
+	provider, err := NewDBProvider(t.TempDir())
+	require.NoError(t, err)
+	defer provider.Close()
+	historydb := provider.GetDBHandle("ledger1")
+	writeTestIndexEntries(t, historydb, "ns1", 0, 100)
+
+	sampledAt := func() time.Time {
+		sample, err := historydb.getSizeSample("ns1")
+		require.NoError(t, err)
+		if sample == nil {
+			return time.Time{}
+		}
+		return sample.sampledAt
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		historydb.RunIndexSizeSampling(50*time.Millisecond, stop)
+		close(done)
+	}()
+
+	// the sizes are sampled at the start and then every interval
+	require.Eventually(t, func() bool { return !sampledAt().IsZero() }, time.Second, 5*time.Millisecond)
+	first := sampledAt()
+	require.Eventually(t, func() bool { return sampledAt().After(first) }, time.Second, 5*time.Millisecond)
+	require.GreaterOrEqual(t, sampledAt().Sub(first), 40*time.Millisecond)
+
+	// no sample is taken once stopped
+	close(stop)
+	<-done
+	last := sampledAt()
+	time.Sleep(100 * time.Millisecond)
+	require.Equal(t, last, sampledAt())
+}
+
+func TestIndexSizeEstimateAccuracy(t *testing.T) {
+	provider, err := NewDBProvider(t.TempDir())
+	require.NoError(t, err)
+	defer provider.Close()
+	historydb := provider.GetDBHandle("ledger1")
+
+	writeTestIndexEntries(t, historydb, "ns1", 0, 5000)
+	require.NoError(t, historydb.levelDB.CompactRange(nil, nil))
+	require.NoError(t, historydb.SampleIndexSizes())
+
+	// the entries written since the sample, of the same compressibility, are estimated within a few percent of their
+	// size on the disk once flushed
+	writeTestIndexEntries(t, historydb, "ns1", 5000, 15000)
+	require.NoError(t, historydb.levelDB.CompactRange(nil, nil))
+	stats, err := historydb.GetIndexStats("ns1")
+	require.NoError(t, err)
+	diskSize, err := historydb.levelDB.ApproximateSize(scanRange("ns1", ""))
+	require.NoError(t, err)
+	require.InEpsilon(t, float64(diskSize), float64(stats.DiskSizeBytes), 0.1)
+
+	// a new sample replaces the estimate with the size on the disk
+	require.NoError(t, historydb.SampleIndexSizes())
+	stats, err = historydb.GetIndexStats("ns1")
+	require.NoError(t, err)
+	require.Equal(t, uint64(diskSize), stats.DiskSizeBytes)
+}
+
+func TestIndexSizeSamplingAfterCompactionAndRollback(t *testing.T) {
+	env := newTestHistoryEnv(t)
+	defer env.cleanup()
+	historydb := env.testHistoryDBProvider.GetDBHandle("ledger1")
+	writeTestIndexEntries(t, historydb, "ns1", 0, 5000)
+	writeTestIndexEntries(t, historydb, "ns2", 0, 5000)
+	require.NoError(t, historydb.levelDB.Put(savePointKey, version.NewHeight(4999, 0).ToBytes(), true))
+	require.NoError(t, historydb.levelDB.CompactRange(nil, nil))
+	require.NoError(t, historydb.SampleIndexSizes())
+	sampled := map[string]uint64{}
+	for _, ns := range []string{"ns1", "ns2"} {
+		stats, err := historydb.GetIndexStats(ns)
+		require.NoError(t, err)
+		require.NotZero(t, stats.DiskSizeBytes)
+		sampled[ns] = stats.DiskSizeBytes
+	}
+
+	// the space reclaimed by a compaction is reflected by the next sample, as the estimate does not shrink below the
+	// sample
+	batch := historydb.levelDB.NewUpdateBatch()
+	for i := 2500; i < 5000; i++ {
+		batch.Delete(constructDataKey("ns1", fmt.Sprintf("key-%06d", i), uint64(i), 0))
+	}
+	require.NoError(t, historydb.levelDB.WriteBatch(batch, true))
+	_, err := historydb.Compact("ns1")
+	require.NoError(t, err)
+	stats, err := historydb.GetIndexStats("ns1")
+	require.NoError(t, err)
+	require.Equal(t, sampled["ns1"], stats.DiskSizeBytes)
+	require.NoError(t, historydb.SampleIndexSizes())
+	stats, err = historydb.GetIndexStats("ns1")
+	require.NoError(t, err)
+	require.Less(t, stats.DiskSizeBytes, sampled["ns1"])
+	sampled["ns1"] = stats.DiskSizeBytes
+
+	// a rollback discards the samples of the namespaces with removed entries, which are no longer estimated until
+	// sampled again, and retains those of the other namespaces
+	require.NoError(t, env.testHistoryDBProvider.Rollback("ledger1", 2499))
+	historydb = env.testHistoryDBProvider.GetDBHandle("ledger1")
+	stats, err = historydb.GetIndexStats("ns2")
+	require.NoError(t, err)
+	require.Zero(t, stats.DiskSizeBytes)
+	stats, err = historydb.GetIndexStats("ns1")
+	require.NoError(t, err)
+	require.Equal(t, sampled["ns1"], stats.DiskSizeBytes)
+
+	_, err = historydb.Compact("")
+	require.NoError(t, err)
+	require.NoError(t, historydb.SampleIndexSizes())
+	stats, err = historydb.GetIndexStats("ns2")
+	require.NoError(t, err)
+	require.NotZero(t, stats.DiskSizeBytes)
+	require.Less(t, stats.DiskSizeBytes, sampled["ns2"])
+}
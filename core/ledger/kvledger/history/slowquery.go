@@ -0,0 +1,118 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hyperledger/fabric/common/flogging"
+)
+
+// slowQueryLogger is the channel for the slow history queries, so that its level can be controlled independently
+// of the other history logs
+var slowQueryLogger = flogging.MustGetLogger("history.slowquery")
+
+const (
+	// slowQueryLogWindow and slowQueryLogLimit bound the rate of the slow query logs to slowQueryLogLimit entries
+	// per slowQueryLogWindow across the channels. The queries not logged due to the limit are counted and the count
+	// is reported with the next entry logged
+	slowQueryLogWindow = time.Minute
+	slowQueryLogLimit  = 20
+)
+
+// slowQueryLog logs the history queries that take minDuration or longer, or that return minResults or more
+// results, along with the time spent reading the index and retrieving the transactions from the block store, so that
+// the clients issuing the abusive queries and the keys with the excessive history can be identified.
+// A nil slowQueryLog is valid and logs nothing.
+type slowQueryLog struct {
+	minDuration time.Duration
+	minResults  int
+
+	mutex       sync.Mutex
+	windowStart time.Time
+	logged      int
+	suppressed  int
+}
+
+// queryTiming is the breakdown of the time taken by a history query, along with the number of results returned
+type queryTiming struct {
+	start time.Time
+	// indexScan is the time spent seeking and iterating the history index
+	indexScan time.Duration
+	// blockFetch is the time spent retrieving the transactions from the block store, including waiting for the
+	// transactions being retrieved in the background by the prefetch
+	blockFetch time.Duration
+	numResults int
+}
+
+func (t *queryTiming) addIndexScan(start time.Time) {
+	t.indexScan += time.Since(start)
+}
+
+func (t *queryTiming) addBlockFetch(start time.Time) {
+	t.blockFetch += time.Since(start)
+}
+
+// EnableSlowQueryLog enables logging, on the logger "history.slowquery" at the warning level, the history queries for
+// a key that take minDuration or longer, or return minResults or more results. A value of 0 or less for either
+// disables the corresponding criterion.
+func (p *DBProvider) EnableSlowQueryLog(minDuration time.Duration, minResults int) {
+	p.slowQueries = newSlowQueryLog(minDuration, minResults)
+}
+
+func newSlowQueryLog(minDuration time.Duration, minResults int) *slowQueryLog {
+	if minDuration <= 0 && minResults <= 0 {
+		return nil
+	}
+	return &slowQueryLog{
+		minDuration: minDuration,
+		minResults:  minResults,
+	}
+}
+
+func (l *slowQueryLog) isSlow(duration time.Duration, numResults int) bool {
+	return (l.minDuration > 0 && duration >= l.minDuration) || (l.minResults > 0 && numResults >= l.minResults)
+}
+
+// record logs the query for the key, if slow and within the rate limit
+func (l *slowQueryLog) record(channel, ns, key string, indexedHeight uint64, timing *queryTiming, exhausted bool) {
+	if l == nil {
+		return
+	}
+	duration := time.Since(timing.start)
+	if !l.isSlow(duration, timing.numResults) {
+		return
+	}
+	l.mutex.Lock()
+	now := time.Now()
+	if now.Sub(l.windowStart) >= slowQueryLogWindow {
+		l.windowStart, l.logged = now, 0
+	}
+	if l.logged >= slowQueryLogLimit {
+		l.suppressed++
+		l.mutex.Unlock()
+		return
+	}
+	l.logged++
+	suppressed := l.suppressed
+	l.suppressed = 0
+	l.mutex.Unlock()
+
+	slowQueryLogger.Warnw("Slow history query",
+		"channel", channel,
+		"namespace", ns,
+		"key", key,
+		"indexedHeight", indexedHeight,
+		"duration", duration,
+		"indexScan", timing.indexScan,
+		"blockFetch", timing.blockFetch,
+		"results", timing.numResults,
+		"exhausted", exhausted,
+		"suppressed", suppressed,
+	)
+}
@@ -0,0 +1,102 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric/common/flogging/floggingtest"
+	"github.com/hyperledger/fabric/common/ledger/testutil"
+	util2 "github.com/hyperledger/fabric/common/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSlowQueryLog(t *testing.T) {
+	oldLogger := slowQueryLogger
+	defer func() { slowQueryLogger = oldLogger }()
+	l, recorder := floggingtest.NewTestLogger(t)
+	slowQueryLogger = l
+
+	env := newTestHistoryEnv(t)
+	defer env.cleanup()
+	store, err := env.testBlockStorageEnv.provider.Open("ledger1")
+	require.NoError(t, err)
+	defer store.Shutdown()
+
+	env.testHistoryDBProvider.EnableSlowQueryLog(0, 2)
+	historydb := env.testHistoryDBProvider.GetDBHandle("ledger1")
+	bg, gb := testutil.NewBlockGenerator(t, "ledger1", false)
+	commit := func(block *common.Block) {
+		require.NoError(t, store.AddBlock(block))
+		require.NoError(t, historydb.Commit(block))
+	}
+	nextBlock := func(key, value string) *common.Block {
+		simulator, err := env.txmgr.NewTxSimulator(util2.GenerateUUID())
+		require.NoError(t, err)
+		require.NoError(t, simulator.SetState("ns1", key, []byte(value)))
+		simulator.Done()
+		simRes, err := simulator.GetTxSimulationResults()
+		require.NoError(t, err)
+		pubSimResBytes, err := simRes.GetPubSimulationBytes()
+		require.NoError(t, err)
+		return bg.NextBlock([][]byte{pubSimResBytes})
+	}
+	commit(gb)
+	commit(nextBlock("key1", "value1"))
+	commit(nextBlock("key1", "value2"))
+	commit(nextBlock("key2", "value1"))
+
+	qe, err := historydb.NewQueryExecutor(store)
+	require.NoError(t, err)
+	query := func(key string) {
+		itr, err := qe.GetHistoryForKey("ns1", key)
+		require.NoError(t, err)
+		defer itr.Close()
+		for {
+			result, err := itr.Next()
+			require.NoError(t, err)
+			if result == nil {
+				return
+			}
+		}
+	}
+	query("key2")
+	require.Empty(t, recorder.Entries())
+	query("key1")
+	entries := recorder.EntriesContaining("Slow history query")
+	require.Len(t, entries, 1)
+	require.Contains(t, entries[0], "channel=ledger1")
+	require.Contains(t, entries[0], "key=key1 ")
+	require.Contains(t, entries[0], "results=2 ")
+	require.Contains(t, entries[0], "exhausted=true")
+	require.Contains(t, entries[0], "blockFetch=")
+
+	t.Run("rate-limit", func(t *testing.T) {
+		recorder.Reset()
+		slowQueries := newSlowQueryLog(time.Nanosecond, 0)
+		for i := 0; i < slowQueryLogLimit+2; i++ {
+			slowQueries.record("ledger1", "ns1", "key1", 1, &queryTiming{start: time.Now().Add(-time.Second)}, true)
+		}
+		require.Len(t, recorder.EntriesContaining("Slow history query"), slowQueryLogLimit)
+
+		// the queries not logged are reported with the first entry logged in the next window
+		recorder.Reset()
+		slowQueries.windowStart = slowQueries.windowStart.Add(-slowQueryLogWindow)
+		slowQueries.record("ledger1", "ns1", "key1", 1, &queryTiming{start: time.Now().Add(-time.Second)}, true)
+		entries := recorder.EntriesContaining("Slow history query")
+		require.Len(t, entries, 1)
+		require.Contains(t, entries[0], "suppressed=2")
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		require.Nil(t, newSlowQueryLog(0, 0))
+		var slowQueries *slowQueryLog
+		slowQueries.record("ledger1", "ns1", "key1", 1, &queryTiming{start: time.Now()}, true)
+	})
+}
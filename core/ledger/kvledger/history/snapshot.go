@@ -0,0 +1,449 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/hyperledger/fabric/common/ledger/blkstorage"
+	"github.com/hyperledger/fabric/common/ledger/snapshot"
+	"github.com/hyperledger/fabric/internal/fileutil"
+	"github.com/pkg/errors"
+)
+
+var importHistoryBatchSize = 1024 * 1024
+
+const (
+	snapshotFileFormat       = byte(1)
+	snapshotDataFileName     = "history.data"
+	snapshotMetadataFileName = "history.metadata"
+	snapshotManifestFileName = "history_manifest.json"
+)
+
+// historyManifest lists the hashes of the history files in a snapshot. The history files are not covered by the
+// signable metadata of the snapshot, as their content depends on the history config of the exporting peer and the
+// snapshot hash is expected to be the same across the peers of a channel. Instead, the importing peer verifies the
+// history files against this manifest
+type historyManifest struct {
+	FilesAndHashes map[string]string `json:"history_files_raw_hashes"`
+}
+
+// ExportHistory exports the history from the historyDB to a file in the given dir.
+// A peer that is bootstrapped from a snapshot does not have the blocks prior to the snapshot
+// in its block store and hence, the history index alone is not sufficient for serving the
+// history queries for those blocks. Therefore, for each history entry, the key is written to
+// the file as is, followed by the key modification (txID, value, timestamp, and delete marker)
+// resolved from the block store. The importing peer stores the key modification inline as
+// the value of the history entry. The savepoint is not exported; the importing peer derives
+// it from the snapshot height.
+//
+// The hashes of the history files are returned and also recorded in a manifest file in the given
+// dir. The caller is expected not to include these hashes in the signable metadata of the snapshot,
+// so that the snapshot hash does not depend on the history config of this peer.
+func (d *DB) ExportHistory(dir string, newHashFunc snapshot.NewHashFunc, blockStore *blkstorage.BlockStore) (map[string][]byte, error) {
+	if err := d.Flush(); err != nil {
+		return nil, err
+	}
+	itr, err := d.levelDB.GetIterator(nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer itr.Release()
+
+	var numEntries uint64
+	var dataFileWriter *snapshot.FileWriter
+	defer func() {
+		if dataFileWriter != nil {
+			dataFileWriter.Close()
+		}
+	}()
+	resolver := d.newResolvePool(blockStore, func(key []byte, keyModification *queryresult.KeyModification) error {
+		if numEntries == 0 { // first entry, create the data file
+			var err error
+			if dataFileWriter, err = snapshot.CreateFile(filepath.Join(dir, snapshotDataFileName), snapshotFileFormat, newHashFunc); err != nil {
+				return err
+			}
+		}
+		if err := dataFileWriter.EncodeBytes(key); err != nil {
+			return err
+		}
+		if err := dataFileWriter.EncodeProtoMessage(keyModification); err != nil {
+			return err
+		}
+		numEntries++
+		return nil
+	})
+	defer resolver.close()
+	for itr.Next() {
+		if err := itr.Error(); err != nil {
+			return nil, errors.Wrap(err, "internal leveldb error while iterating for history entries")
+		}
+		if !isDataKey(itr.Key()) {
+			continue
+		}
+		if err := resolver.add(itr.Key(), itr.Value()); err != nil {
+			return nil, err
+		}
+	}
+	if err := resolver.flush(); err != nil {
+		return nil, err
+	}
+
+	if dataFileWriter == nil {
+		return nil, nil
+	}
+
+	dataHash, err := dataFileWriter.Done()
+	if err != nil {
+		return nil, err
+	}
+	metadataFileWriter, err := snapshot.CreateFile(filepath.Join(dir, snapshotMetadataFileName), snapshotFileFormat, newHashFunc)
+	if err != nil {
+		return nil, err
+	}
+	defer metadataFileWriter.Close()
+	bcInfo, err := blockStore.GetBlockchainInfo()
+	if err != nil {
+		return nil, err
+	}
+	if err = metadataFileWriter.EncodeUVarint(numEntries); err != nil {
+		return nil, err
+	}
+	if err = metadataFileWriter.EncodeUVarint(bcInfo.Height - 1); err != nil {
+		return nil, err
+	}
+	if err = metadataFileWriter.EncodeBytes(bcInfo.CurrentBlockHash); err != nil {
+		return nil, err
+	}
+	metadataHash, err := metadataFileWriter.Done()
+	if err != nil {
+		return nil, err
+	}
+
+	filesAndHashes := map[string][]byte{
+		snapshotDataFileName:     dataHash,
+		snapshotMetadataFileName: metadataHash,
+	}
+	if err := writeHistoryManifest(dir, filesAndHashes); err != nil {
+		return nil, err
+	}
+	return filesAndHashes, nil
+}
+
+func writeHistoryManifest(dir string, filesAndHashes map[string][]byte) error {
+	manifest := &historyManifest{
+		FilesAndHashes: map[string]string{},
+	}
+	for f, h := range filesAndHashes {
+		manifest.FilesAndHashes[f] = hex.EncodeToString(h)
+	}
+	manifestBytes, err := json.MarshalIndent(manifest, "", "    ")
+	if err != nil {
+		return errors.Wrap(err, "error while marshalling history manifest to JSON")
+	}
+	return fileutil.CreateAndSyncFile(filepath.Join(dir, snapshotManifestFileName), manifestBytes, 0o444)
+}
+
+// SnapshotInfo captures the information from the snapshot metadata against which the history
+// data in a snapshot is verified before import
+type SnapshotInfo struct {
+	LastBlockNum  uint64
+	LastBlockHash []byte
+	// NewHashFunc is used for verifying the history files against the history manifest
+	NewHashFunc snapshot.NewHashFunc
+}
+
+// ImportFromSnapshot imports the history index entries, if present in the snapshot dir, into
+// the historyDB for the given ledger. If the snapshot does not contain history data (for instance,
+// when the exporting peer did not enable the export of history), this function is a no-op and
+// the history is available only for the blocks committed after the snapshot.
+//
+// The history data is verified in full before any entry is written. The history files are
+// expected to match the hashes in the history manifest, to have been exported at the same block as
+// the snapshot (matched on block number and hash), and to contain exactly the number of well-formed
+// and ordered entries recorded in the metadata, none of which is for a block beyond the snapshot.
+// The caller is expected to set the starting savepoint separately via `MarkStartingSavepoint`.
+func (p *DBProvider) ImportFromSnapshot(name string, dir string, snapshotInfo *SnapshotInfo) error {
+	exist, err := HasBackfillArchive(dir)
+	if err != nil {
+		return err
+	}
+	if !exist {
+		return nil
+	}
+	db := p.GetDBHandle(name)
+	empty, err := db.levelDB.IsEmpty()
+	if err != nil {
+		return err
+	}
+	if !empty {
+		return errors.New(fmt.Sprintf(
+			"history for ledger [%s] exists. Incremental import is not supported. "+
+				"Remove the existing ledger data before retry",
+			name,
+		))
+	}
+	if err := verifyHistoryArchive(dir, snapshotInfo); err != nil {
+		return errors.WithMessage(err, "error while verifying history data in the snapshot")
+	}
+
+	archive, err := openHistoryArchive(dir)
+	if err != nil {
+		return err
+	}
+	defer archive.close()
+
+	batch := db.levelDB.NewUpdateBatch()
+	statsTracker := newIndexStatsTracker(db)
+	for i := uint64(0); i < archive.numEntries; i++ {
+		key, val, err := archive.next()
+		if err != nil {
+			return err
+		}
+		indexed, err := db.isIndexedEntry(key)
+		if err != nil {
+			return err
+		}
+		if !indexed {
+			continue
+		}
+		batch.Put(key, val)
+		if err := trackEntry(statsTracker, key, val); err != nil {
+			return err
+		}
+		if err := db.addViewRowsForInlineEntry(batch, key, val); err != nil {
+			return err
+		}
+		if err := db.addTxKeyRowForEntry(batch, key); err != nil {
+			return err
+		}
+		if batch.Size() >= importHistoryBatchSize {
+			statsTracker.flush(batch)
+			if err := db.levelDB.WriteBatch(batch, true); err != nil {
+				return err
+			}
+			batch.Reset()
+		}
+	}
+	statsTracker.flush(batch)
+	return db.levelDB.WriteBatch(batch, true)
+}
+
+// trackEntry accounts for a history entry, with the given key and value, in the index statistics
+func trackEntry(statsTracker *indexStatsTracker, key, val []byte) error {
+	ns, k, blockNum, _, err := decodeDataKey(key)
+	if err != nil {
+		return err
+	}
+	return statsTracker.add(ns, k, blockNum, len(key)+len(val))
+}
+
+func verifyHistoryArchive(dir string, snapshotInfo *SnapshotInfo) error {
+	if err := verifyHistoryManifest(dir, snapshotInfo.NewHashFunc); err != nil {
+		return err
+	}
+	archive, err := openHistoryArchive(dir)
+	if err != nil {
+		return err
+	}
+	defer archive.close()
+
+	if archive.lastBlockNum != snapshotInfo.LastBlockNum || !bytes.Equal(archive.lastBlockHash, snapshotInfo.LastBlockHash) {
+		return errors.Errorf(
+			"history data is exported at block [%d] with hash [%x], whereas the snapshot is for block [%d] with hash [%x]",
+			archive.lastBlockNum, archive.lastBlockHash, snapshotInfo.LastBlockNum, snapshotInfo.LastBlockHash,
+		)
+	}
+	for i := uint64(0); i < archive.numEntries; i++ {
+		if _, _, err := archive.next(); err != nil {
+			return errors.WithMessagef(err, "error while reading history entry [%d] of [%d]", i+1, archive.numEntries)
+		}
+	}
+	return archive.verifyEnd()
+}
+
+// verifyHistoryManifest verifies the history files in the given dir against the hashes recorded in the history manifest
+func verifyHistoryManifest(dir string, newHashFunc snapshot.NewHashFunc) error {
+	manifestBytes, err := ioutil.ReadFile(filepath.Join(dir, snapshotManifestFileName))
+	if os.IsNotExist(err) {
+		return errors.Errorf("history files are not accompanied by the manifest [%s]", snapshotManifestFileName)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "error while reading the history manifest")
+	}
+	manifest := &historyManifest{}
+	if err := json.Unmarshal(manifestBytes, manifest); err != nil {
+		return errors.Wrap(err, "error while unmarshalling the history manifest")
+	}
+	for _, f := range []string{snapshotDataFileName, snapshotMetadataFileName} {
+		expectedHashInHex, ok := manifest.FilesAndHashes[f]
+		if !ok {
+			return errors.Errorf("history file [%s] is not listed in the manifest", f)
+		}
+		if err := verifyHistoryFileHash(dir, f, expectedHashInHex, newHashFunc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func verifyHistoryFileHash(dir, file string, expectedHashInHex string, newHashFunc snapshot.NewHashFunc) error {
+	hashImpl, err := newHashFunc()
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(filepath.Join(dir, file))
+	if err != nil {
+		return errors.Wrapf(err, "error while opening history file [%s]", file)
+	}
+	defer f.Close()
+	if _, err := io.Copy(hashImpl, bufio.NewReader(f)); err != nil {
+		return errors.Wrapf(err, "error while reading history file [%s]", file)
+	}
+	hashInHex := hex.EncodeToString(hashImpl.Sum(nil))
+	if hashInHex != expectedHashInHex {
+		return errors.Errorf("hash mismatch for history file [%s]. Expected hash = [%s], Actual hash = [%s]",
+			file, expectedHashInHex, hashInHex,
+		)
+	}
+	return nil
+}
+
+// historyArchive reads the history entries from the files produced by function `ExportHistory`.
+// While reading, each entry is checked to be a well-formed history entry for a block not beyond
+// the export height and to be in the strictly increasing order of keys, as written by the export
+type historyArchive struct {
+	numEntries    uint64
+	lastBlockNum  uint64
+	lastBlockHash []byte
+	dataReader    *snapshot.FileReader
+	previousKey   []byte
+}
+
+func openHistoryArchive(dir string) (*historyArchive, error) {
+	metadataReader, err := snapshot.OpenFile(filepath.Join(dir, snapshotMetadataFileName), snapshotFileFormat)
+	if err != nil {
+		return nil, err
+	}
+	defer metadataReader.Close()
+	numEntries, err := metadataReader.DecodeUVarInt()
+	if err != nil {
+		return nil, err
+	}
+	lastBlockNum, err := metadataReader.DecodeUVarInt()
+	if err != nil {
+		return nil, err
+	}
+	lastBlockHash, err := metadataReader.DecodeBytes()
+	if err != nil {
+		return nil, err
+	}
+	dataReader, err := snapshot.OpenFile(filepath.Join(dir, snapshotDataFileName), snapshotFileFormat)
+	if err != nil {
+		return nil, err
+	}
+	return &historyArchive{
+		numEntries:    numEntries,
+		lastBlockNum:  lastBlockNum,
+		lastBlockHash: lastBlockHash,
+		dataReader:    dataReader,
+	}, nil
+}
+
+// next returns the next history entry in the archive. The returned value is the
+// key modification marshalled for storing inline in the historydb
+func (a *historyArchive) next() ([]byte, []byte, error) {
+	key, err := a.dataReader.DecodeBytes()
+	if err != nil {
+		return nil, nil, err
+	}
+	if !isDataKey(key) {
+		return nil, nil, errors.Errorf("invalid history entry, unexpected key [%x]", key)
+	}
+	_, _, blockNum, _, err := decodeDataKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if blockNum > a.lastBlockNum {
+		return nil, nil, errors.Errorf("invalid history entry for key [%x], block number [%d] is beyond the export height [%d]",
+			key, blockNum, a.lastBlockNum)
+	}
+	if a.previousKey != nil && bytes.Compare(key, a.previousKey) <= 0 {
+		return nil, nil, errors.Errorf("invalid history entry for key [%x], keys are not in increasing order", key)
+	}
+	a.previousKey = key
+
+	keyModification := &queryresult.KeyModification{}
+	if err := a.dataReader.DecodeProtoMessage(keyModification); err != nil {
+		return nil, nil, err
+	}
+	val, err := proto.Marshal(keyModification)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error while marshalling key modification")
+	}
+	return key, val, nil
+}
+
+// verifyEnd returns an error if the data file contains data beyond the number of entries recorded in the metadata
+func (a *historyArchive) verifyEnd() error {
+	_, err := a.dataReader.DecodeUVarInt()
+	if errors.Cause(err) == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return errors.Errorf("history data file contains more entries than [%d] recorded in the metadata", a.numEntries)
+}
+
+func (a *historyArchive) close() {
+	a.dataReader.Close()
+}
+
+// resolveKeyModification returns the key modification for a history entry. If the key modification
+// is stored inline (i.e., the entry was imported from a snapshot), it is decoded from the value.
+// Otherwise, the key modification is retrieved from the transaction in the block store, via the decoded tx cache
+// as per the given cache policy.
+func (d *DB) resolveKeyModification(key, val []byte, txFetcher TxFetcher, policy cachePolicy) (*queryresult.KeyModification, error) {
+	ns, k, blockNum, tranNum, err := decodeDataKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(val) > 0 {
+		return d.decodeInlineEntry(ns, k, val)
+	}
+	tx, err := d.retrieveTx(txFetcher, blockNum, tranNum, policy)
+	if err != nil {
+		return nil, err
+	}
+	queryResult, err := getKeyModificationFromTran(tx, ns, k, d.normalizeKey)
+	if err != nil {
+		return nil, err
+	}
+	if queryResult == nil {
+		return nil, errors.Errorf("no namespace or key is found for namespace %s and key %s with decoded blockNum %d and tranNum %d", ns, k, blockNum, tranNum)
+	}
+	return queryResult.(*queryresult.KeyModification), nil
+}
+
+func decodeInlineKeyModification(val []byte) (*queryresult.KeyModification, error) {
+	keyModification := &queryresult.KeyModification{}
+	if err := proto.Unmarshal(val, keyModification); err != nil {
+		return nil, errors.Wrap(err, "error while unmarshalling inline key modification")
+	}
+	return keyModification, nil
+}
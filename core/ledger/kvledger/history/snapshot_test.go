@@ -0,0 +1,271 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/hyperledger/fabric/common/ledger/snapshot"
+	"github.com/hyperledger/fabric/common/ledger/testutil"
+	util2 "github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/ledger/internal/version"
+	"github.com/stretchr/testify/require"
+)
+
+var testNewHashFunc = func() (hash.Hash, error) {
+	return sha256.New(), nil
+}
+
+func TestExportAndImportHistory(t *testing.T) {
+	env := newTestHistoryEnv(t)
+	defer env.cleanup()
+	provider := env.testBlockStorageEnv.provider
+	store, err := provider.Open("ledger1")
+	require.NoError(t, err)
+	defer store.Shutdown()
+
+	historydb := env.testHistoryDBProvider.GetDBHandle("ledger1")
+	bg, gb := testutil.NewBlockGenerator(t, "ledger1", false)
+	require.NoError(t, store.AddBlock(gb))
+	require.NoError(t, historydb.Commit(gb))
+
+	t.Run("no-history-entries", func(t *testing.T) {
+		snapshotDir := t.TempDir()
+		filesAndHashes, err := historydb.ExportHistory(snapshotDir, testNewHashFunc, store)
+		require.NoError(t, err)
+		require.Nil(t, filesAndHashes)
+		files, err := os.ReadDir(snapshotDir)
+		require.NoError(t, err)
+		require.Len(t, files, 0)
+	})
+
+	for i := 1; i <= 3; i++ {
+		simulator, err := env.txmgr.NewTxSimulator(util2.GenerateUUID())
+		require.NoError(t, err)
+		require.NoError(t, simulator.SetState("ns1", "key1", []byte{byte(i)}))
+		require.NoError(t, simulator.SetState("ns2", "key2", []byte{byte(i)}))
+		simulator.Done()
+		simRes, err := simulator.GetTxSimulationResults()
+		require.NoError(t, err)
+		pubSimResBytes, err := simRes.GetPubSimulationBytes()
+		require.NoError(t, err)
+		block := bg.NextBlock([][]byte{pubSimResBytes})
+		require.NoError(t, store.AddBlock(block))
+		require.NoError(t, historydb.Commit(block))
+	}
+
+	snapshotDir := t.TempDir()
+	filesAndHashes, err := historydb.ExportHistory(snapshotDir, testNewHashFunc, store)
+	require.NoError(t, err)
+	require.Len(t, filesAndHashes, 2)
+	for f, h := range filesAndHashes {
+		fileContent, err := os.ReadFile(filepath.Join(snapshotDir, f))
+		require.NoError(t, err)
+		expectedHash := sha256.Sum256(fileContent)
+		require.Equal(t, expectedHash[:], h)
+	}
+	manifestBytes, err := os.ReadFile(filepath.Join(snapshotDir, snapshotManifestFileName))
+	require.NoError(t, err)
+	manifest := &historyManifest{}
+	require.NoError(t, json.Unmarshal(manifestBytes, manifest))
+	require.Equal(t,
+		map[string]string{
+			snapshotDataFileName:     hex.EncodeToString(filesAndHashes[snapshotDataFileName]),
+			snapshotMetadataFileName: hex.EncodeToString(filesAndHashes[snapshotMetadataFileName]),
+		},
+		manifest.FilesAndHashes,
+	)
+	bcInfo, err := store.GetBlockchainInfo()
+	require.NoError(t, err)
+	snapshotInfo := &SnapshotInfo{
+		LastBlockNum:  bcInfo.Height - 1,
+		LastBlockHash: bcInfo.CurrentBlockHash,
+		NewHashFunc:   testNewHashFunc,
+	}
+
+	t.Run("import-into-empty-db", func(t *testing.T) {
+		p := env.testHistoryDBProvider
+		require.NoError(t, p.ImportFromSnapshot("ledger1-from-snapshot", snapshotDir, snapshotInfo))
+		require.NoError(t, p.MarkStartingSavepoint("ledger1-from-snapshot", version.NewHeight(3, 1)))
+
+		// the block store of a peer bootstrapped from a snapshot does not have the pre-snapshot blocks
+		emptyStore, err := provider.Open("ledger1-from-snapshot")
+		require.NoError(t, err)
+		defer emptyStore.Shutdown()
+
+		importedDB := p.GetDBHandle("ledger1-from-snapshot")
+		qe, err := importedDB.NewQueryExecutor(emptyStore)
+		require.NoError(t, err)
+		testutilVerifyResults(t, qe, "ns1", "key1", []string{"\x03", "\x02", "\x01"})
+		testutilVerifyResults(t, qe, "ns2", "key2", []string{"\x03", "\x02", "\x01"})
+
+		savepoint, err := importedDB.GetLastSavepoint()
+		require.NoError(t, err)
+		require.Equal(t, version.NewHeight(3, 1), savepoint)
+
+		// re-exporting the imported history produces the same data file. The metadata file differs,
+		// as the export height is taken from the block store, which is empty in this test
+		reexportDir := t.TempDir()
+		reexportedFilesAndHashes, err := importedDB.ExportHistory(reexportDir, testNewHashFunc, emptyStore)
+		require.NoError(t, err)
+		require.Equal(t, filesAndHashes[snapshotDataFileName], reexportedFilesAndHashes[snapshotDataFileName])
+	})
+
+	t.Run("import-without-history-files", func(t *testing.T) {
+		p := env.testHistoryDBProvider
+		require.NoError(t, p.ImportFromSnapshot("ledger-no-history", t.TempDir(), &SnapshotInfo{}))
+		empty, err := p.GetDBHandle("ledger-no-history").levelDB.IsEmpty()
+		require.NoError(t, err)
+		require.True(t, empty)
+	})
+
+	t.Run("import-into-non-empty-db", func(t *testing.T) {
+		err := env.testHistoryDBProvider.ImportFromSnapshot("ledger1", snapshotDir, snapshotInfo)
+		require.EqualError(t, err, "history for ledger [ledger1] exists. Incremental import is not supported. "+
+			"Remove the existing ledger data before retry")
+	})
+
+	// copySnapshotDir copies the history files after modifying the data file. If rehash is set, the manifest
+	// is regenerated for the modified data file, so that the verification goes past the manifest
+	copySnapshotDir := func(t *testing.T, modifyData func([]byte) []byte, rehash bool) string {
+		dir := t.TempDir()
+		metadata, err := os.ReadFile(filepath.Join(snapshotDir, snapshotMetadataFileName))
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(dir, snapshotMetadataFileName), metadata, 0o644))
+		data, err := os.ReadFile(filepath.Join(snapshotDir, snapshotDataFileName))
+		require.NoError(t, err)
+		data = modifyData(data)
+		require.NoError(t, os.WriteFile(filepath.Join(dir, snapshotDataFileName), data, 0o644))
+		if !rehash {
+			manifest, err := os.ReadFile(filepath.Join(snapshotDir, snapshotManifestFileName))
+			require.NoError(t, err)
+			require.NoError(t, os.WriteFile(filepath.Join(dir, snapshotManifestFileName), manifest, 0o644))
+			return dir
+		}
+		dataHash := sha256.Sum256(data)
+		metadataHash := sha256.Sum256(metadata)
+		require.NoError(t, writeHistoryManifest(dir, map[string][]byte{
+			snapshotDataFileName:     dataHash[:],
+			snapshotMetadataFileName: metadataHash[:],
+		}))
+		return dir
+	}
+
+	verifyNothingImported := func(t *testing.T, ledgerID string) {
+		empty, err := env.testHistoryDBProvider.GetDBHandle(ledgerID).levelDB.IsEmpty()
+		require.NoError(t, err)
+		require.True(t, empty)
+	}
+
+	t.Run("import-with-truncated-data-file", func(t *testing.T) {
+		dir := copySnapshotDir(t, func(data []byte) []byte { return data[:len(data)/2] }, true)
+		err := env.testHistoryDBProvider.ImportFromSnapshot("ledger-truncated", dir, snapshotInfo)
+		require.ErrorContains(t, err, "error while verifying history data in the snapshot")
+		verifyNothingImported(t, "ledger-truncated")
+	})
+
+	t.Run("import-with-data-file-not-matching-manifest", func(t *testing.T) {
+		dir := copySnapshotDir(t, func(data []byte) []byte { return append(data, 0x01, 0x02) }, false)
+		err := env.testHistoryDBProvider.ImportFromSnapshot("ledger-hash-mismatch", dir, snapshotInfo)
+		require.ErrorContains(t, err, "error while verifying history data in the snapshot: "+
+			"hash mismatch for history file [history.data]")
+		verifyNothingImported(t, "ledger-hash-mismatch")
+	})
+
+	t.Run("import-with-trailing-data", func(t *testing.T) {
+		dir := copySnapshotDir(t, func(data []byte) []byte { return append(data, 0x01, 0x02) }, true)
+		err := env.testHistoryDBProvider.ImportFromSnapshot("ledger-trailing-data", dir, snapshotInfo)
+		require.EqualError(t, err, "error while verifying history data in the snapshot: "+
+			"history data file contains more entries than [6] recorded in the metadata")
+		verifyNothingImported(t, "ledger-trailing-data")
+	})
+
+	t.Run("import-without-manifest", func(t *testing.T) {
+		dir := copySnapshotDir(t, func(data []byte) []byte { return data }, false)
+		require.NoError(t, os.Remove(filepath.Join(dir, snapshotManifestFileName)))
+		err := env.testHistoryDBProvider.ImportFromSnapshot("ledger-no-manifest", dir, snapshotInfo)
+		require.EqualError(t, err, "error while verifying history data in the snapshot: "+
+			"history files are not accompanied by the manifest [history_manifest.json]")
+		verifyNothingImported(t, "ledger-no-manifest")
+	})
+
+	t.Run("import-with-mismatched-block", func(t *testing.T) {
+		err := env.testHistoryDBProvider.ImportFromSnapshot("ledger-mismatched-block", snapshotDir,
+			&SnapshotInfo{
+				LastBlockNum:  snapshotInfo.LastBlockNum,
+				LastBlockHash: []byte("another-hash"),
+				NewHashFunc:   testNewHashFunc,
+			},
+		)
+		require.EqualError(t, err, fmt.Sprintf("error while verifying history data in the snapshot: "+
+			"history data is exported at block [3] with hash [%x], whereas the snapshot is for block [3] with hash [%x]",
+			snapshotInfo.LastBlockHash, []byte("another-hash"),
+		))
+		verifyNothingImported(t, "ledger-mismatched-block")
+	})
+}
+
+func TestHistoryArchiveRejectsInvalidEntries(t *testing.T) {
+	writeArchive := func(t *testing.T, lastBlockNum uint64, keys ...[]byte) string {
+		dir := t.TempDir()
+		dataWriter, err := snapshot.CreateFile(filepath.Join(dir, snapshotDataFileName), snapshotFileFormat, testNewHashFunc)
+		require.NoError(t, err)
+		defer dataWriter.Close()
+		for _, k := range keys {
+			require.NoError(t, dataWriter.EncodeBytes(k))
+			require.NoError(t, dataWriter.EncodeProtoMessage(&queryresult.KeyModification{TxId: "txid"}))
+		}
+		dataHash, err := dataWriter.Done()
+		require.NoError(t, err)
+
+		metadataWriter, err := snapshot.CreateFile(filepath.Join(dir, snapshotMetadataFileName), snapshotFileFormat, testNewHashFunc)
+		require.NoError(t, err)
+		defer metadataWriter.Close()
+		require.NoError(t, metadataWriter.EncodeUVarint(uint64(len(keys))))
+		require.NoError(t, metadataWriter.EncodeUVarint(lastBlockNum))
+		require.NoError(t, metadataWriter.EncodeBytes([]byte("hash")))
+		metadataHash, err := metadataWriter.Done()
+		require.NoError(t, err)
+		require.NoError(t, writeHistoryManifest(dir, map[string][]byte{
+			snapshotDataFileName:     dataHash,
+			snapshotMetadataFileName: metadataHash,
+		}))
+		return dir
+	}
+	snapshotInfo := &SnapshotInfo{LastBlockNum: 5, LastBlockHash: []byte("hash"), NewHashFunc: testNewHashFunc}
+
+	t.Run("keys-out-of-order", func(t *testing.T) {
+		dir := writeArchive(t, 5, constructDataKey("ns", "key2", 1, 0), constructDataKey("ns", "key1", 1, 0))
+		err := verifyHistoryArchive(dir, snapshotInfo)
+		require.ErrorContains(t, err, "keys are not in increasing order")
+	})
+
+	t.Run("block-beyond-export-height", func(t *testing.T) {
+		dir := writeArchive(t, 5, constructDataKey("ns", "key1", 6, 0))
+		err := verifyHistoryArchive(dir, snapshotInfo)
+		require.ErrorContains(t, err, "block number [6] is beyond the export height [5]")
+	})
+
+	t.Run("non-data-key", func(t *testing.T) {
+		dir := writeArchive(t, 5, savePointKey)
+		err := verifyHistoryArchive(dir, snapshotInfo)
+		require.ErrorContains(t, err, "invalid history entry, unexpected key [73]")
+	})
+
+	t.Run("valid-entries", func(t *testing.T) {
+		dir := writeArchive(t, 5, constructDataKey("ns", "key1", 1, 0), constructDataKey("ns", "key1", 5, 0))
+		require.NoError(t, verifyHistoryArchive(dir, snapshotInfo))
+	})
+}
@@ -0,0 +1,178 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"encoding/binary"
+
+	"github.com/hyperledger/fabric/common/ledger/util/leveldbhelper"
+	"github.com/pkg/errors"
+)
+
+// IndexStats captures the statistics of the history index for a namespace. The statistics are maintained
+// incrementally, as the entries are added to the index, and hence, are available without scanning the index.
+// For a history index populated by a peer version that did not maintain the statistics, the statistics cover
+// only the entries added afterwards, until the history is rebuilt.
+type IndexStats struct {
+	// DistinctKeys is the number of keys that have at least one entry in the index. It is incremented when the first
+	// entry for a key is added and is also reported by the metric "ledger_history_distinct_keys"
+	DistinctKeys uint64
+	// TotalIndexEntries is the number of entries in the index
+	TotalIndexEntries uint64
+	// TotalVersions is the number of key modifications, including deletes. The index holds a single
+	// entry per key modification and hence, this is same as TotalIndexEntries
+	TotalVersions uint64
+	// ApproxSizeBytes is the sum of the sizes of the keys and values of the entries in the index
+	ApproxSizeBytes uint64
+	// LastIndexedBlock is the highest block number across the entries in the index
+	LastIndexedBlock uint64
+	// DiskSizeBytes is the approximate size on the disk of the entries in the index, after the compression. It is
+	// estimated from the most recent sample of the on-disk size (see function `SampleIndexSizes`), with the entries
+	// added since the sample accounted for at the compression ratio observed by the sample. It is zero until the
+	// namespace is first sampled.
+	DiskSizeBytes uint64
+}
+
+const indexStatsBytesLen = 40
+
+// GetIndexStats returns the statistics of the history index for the given namespace. A namespace with no
+// entries in the index has all the statistics as zero.
+func (d *DB) GetIndexStats(ns string) (*IndexStats, error) {
+	b, err := d.levelDB.Get(constructIndexStatsKey(ns))
+	if err != nil {
+		return nil, err
+	}
+	if b == nil {
+		return &IndexStats{}, nil
+	}
+	stats, err := indexStatsFromBytes(b)
+	if err != nil {
+		return nil, err
+	}
+	sample, err := d.getSizeSample(ns)
+	if err != nil || sample == nil {
+		return stats, err
+	}
+	stats.DiskSizeBytes = sample.estimateDiskSize(stats.ApproxSizeBytes)
+	return stats, nil
+}
+
+// indexStatsTracker accumulates the updates to the index statistics for the entries being added to an
+// update batch. The caller is expected to invoke function `flush` just before writing the batch and to
+// hold the statsLock of the db from the first invocation of function `add` until the batch is written
+type indexStatsTracker struct {
+	db    *DB
+	stats map[string]*IndexStats
+	// batchKeys contains the ns and key (encoded as the range scan start key) of the entries in the batch
+	batchKeys map[string]struct{}
+	// valueStates contains the updated value states of the keys whose unchanged writes are skipped, keyed by the
+	// value state key (see function `isUnchanged`)
+	valueStates map[string]*valueState
+	// inlineBases contains the latest inline values of the keys whose inline values are delta encoded, keyed by the
+	// range scan start key (see function `marshalInline`)
+	inlineBases map[string]*inlineBase
+}
+
+func newIndexStatsTracker(db *DB) *indexStatsTracker {
+	return &indexStatsTracker{
+		db:          db,
+		stats:       map[string]*IndexStats{},
+		batchKeys:   map[string]struct{}{},
+		valueStates: map[string]*valueState{},
+		inlineBases: map[string]*inlineBase{},
+	}
+}
+
+// add accounts for an entry for the given key that is being added to the batch
+func (t *indexStatsTracker) add(ns, key string, blockNum uint64, entrySize int) error {
+	s, ok := t.stats[ns]
+	if !ok {
+		var err error
+		if s, err = t.db.GetIndexStats(ns); err != nil {
+			return err
+		}
+		t.stats[ns] = s
+	}
+
+	rangeScan := constructRangeScan(ns, key)
+	if _, ok := t.batchKeys[string(rangeScan.startKey)]; !ok {
+		exists, err := t.db.hasEntries(rangeScan)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			s.DistinctKeys++
+		}
+		t.batchKeys[string(rangeScan.startKey)] = struct{}{}
+	}
+	s.TotalIndexEntries++
+	s.TotalVersions++
+	s.ApproxSizeBytes += uint64(entrySize)
+	if blockNum > s.LastIndexedBlock {
+		s.LastIndexedBlock = blockNum
+	}
+	return nil
+}
+
+// flush adds the updated statistics and value states to the batch, reports the distinct keys of the updated
+// namespaces, and resets the tracker for the next batch. Returns the keys of the entries in the batch
+func (t *indexStatsTracker) flush(batch *leveldbhelper.UpdateBatch) map[string]struct{} {
+	for ns, s := range t.stats {
+		batch.Put(constructIndexStatsKey(ns), s.toBytes())
+		t.db.stats.updateDistinctKeys(ns, s.DistinctKeys)
+	}
+	for stateKey, s := range t.valueStates {
+		batch.Put([]byte(stateKey), s.toBytes())
+	}
+	batchKeys := t.batchKeys
+	t.stats = map[string]*IndexStats{}
+	t.batchKeys = map[string]struct{}{}
+	t.valueStates = map[string]*valueState{}
+	t.inlineBases = map[string]*inlineBase{}
+	return batchKeys
+}
+
+// hasEntries returns true if the index contains at least one entry within the given range
+func (d *DB) hasEntries(rangeScan *rangeScan) (bool, error) {
+	itr, err := d.levelDB.GetIterator(rangeScan.startKey, rangeScan.endKey)
+	if err != nil {
+		return false, err
+	}
+	defer itr.Release()
+	exists := itr.Next()
+	if err := itr.Error(); err != nil {
+		return false, errors.Wrap(err, "internal leveldb error while iterating for history entries")
+	}
+	return exists, nil
+}
+
+func constructIndexStatsKey(ns string) []byte {
+	return append(append([]byte{}, indexStatsKeyPrefix...), ns...)
+}
+
+func (s *IndexStats) toBytes() []byte {
+	b := make([]byte, indexStatsBytesLen)
+	binary.BigEndian.PutUint64(b[0:8], s.DistinctKeys)
+	binary.BigEndian.PutUint64(b[8:16], s.TotalIndexEntries)
+	binary.BigEndian.PutUint64(b[16:24], s.TotalVersions)
+	binary.BigEndian.PutUint64(b[24:32], s.ApproxSizeBytes)
+	binary.BigEndian.PutUint64(b[32:40], s.LastIndexedBlock)
+	return b
+}
+
+func indexStatsFromBytes(b []byte) (*IndexStats, error) {
+	if len(b) != indexStatsBytesLen {
+		return nil, errors.Errorf("unexpected length of the index stats bytes: %d", len(b))
+	}
+	return &IndexStats{
+		DistinctKeys:      binary.BigEndian.Uint64(b[0:8]),
+		TotalIndexEntries: binary.BigEndian.Uint64(b[8:16]),
+		TotalVersions:     binary.BigEndian.Uint64(b[16:24]),
+		ApproxSizeBytes:   binary.BigEndian.Uint64(b[24:32]),
+		LastIndexedBlock:  binary.BigEndian.Uint64(b[32:40]),
+	}, nil
+}
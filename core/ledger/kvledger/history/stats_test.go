@@ -0,0 +1,135 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric/common/ledger/testutil"
+	util2 "github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/ledger/internal/version"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndexStats(t *testing.T) {
+	env := newTestHistoryEnv(t)
+	defer env.cleanup()
+	provider := env.testBlockStorageEnv.provider
+	store, err := provider.Open("ledger1")
+	require.NoError(t, err)
+	defer store.Shutdown()
+
+	historydb := env.testHistoryDBProvider.GetDBHandle("ledger1")
+	bg, gb := testutil.NewBlockGenerator(t, "ledger1", false)
+	require.NoError(t, store.AddBlock(gb))
+	require.NoError(t, historydb.Commit(gb))
+
+	stats, err := historydb.GetIndexStats("ns1")
+	require.NoError(t, err)
+	require.Equal(t, &IndexStats{}, stats)
+
+	for i := 1; i <= 3; i++ {
+		var txs [][]byte
+		// two transactions per block, both writing key1
+		for j := 0; j < 2; j++ {
+			simulator, err := env.txmgr.NewTxSimulator(util2.GenerateUUID())
+			require.NoError(t, err)
+			require.NoError(t, simulator.SetState("ns1", "key1", []byte{byte(i)}))
+			if i == 3 && j == 1 {
+				require.NoError(t, simulator.DeleteState("ns1", "key2"))
+			} else {
+				require.NoError(t, simulator.SetState("ns1", "key2", []byte{byte(i)}))
+			}
+			require.NoError(t, simulator.SetState("ns2", "key1", []byte{byte(i)}))
+			simulator.Done()
+			simRes, err := simulator.GetTxSimulationResults()
+			require.NoError(t, err)
+			pubSimResBytes, err := simRes.GetPubSimulationBytes()
+			require.NoError(t, err)
+			txs = append(txs, pubSimResBytes)
+		}
+		block := bg.NextBlock(txs)
+		require.NoError(t, store.AddBlock(block))
+		require.NoError(t, historydb.Commit(block))
+	}
+
+	stats, err = historydb.GetIndexStats("ns1")
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), stats.DistinctKeys)
+	require.Equal(t, uint64(12), stats.TotalIndexEntries)
+	require.Equal(t, uint64(12), stats.TotalVersions)
+	require.Equal(t, uint64(3), stats.LastIndexedBlock)
+	expectedSize := 0
+	require.NoError(t, historydb.ScanIndex("ns1", "", func(e *IndexEntry) error {
+		expectedSize += len(e.RawKey)
+		return nil
+	}))
+	require.Equal(t, uint64(expectedSize), stats.ApproxSizeBytes)
+
+	stats, err = historydb.GetIndexStats("ns2")
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), stats.DistinctKeys)
+	require.Equal(t, uint64(6), stats.TotalIndexEntries)
+
+	t.Run("import-from-snapshot", func(t *testing.T) {
+		snapshotDir := t.TempDir()
+		_, err := historydb.ExportHistory(snapshotDir, testNewHashFunc, store)
+		require.NoError(t, err)
+		bcInfo, err := store.GetBlockchainInfo()
+		require.NoError(t, err)
+		snapshotInfo := &SnapshotInfo{
+			LastBlockNum:  bcInfo.Height - 1,
+			LastBlockHash: bcInfo.CurrentBlockHash,
+			NewHashFunc:   testNewHashFunc,
+		}
+		require.NoError(t, env.testHistoryDBProvider.ImportFromSnapshot("ledger1-from-snapshot", snapshotDir, snapshotInfo))
+
+		importedStats, err := env.testHistoryDBProvider.GetDBHandle("ledger1-from-snapshot").GetIndexStats("ns1")
+		require.NoError(t, err)
+		require.Equal(t, uint64(2), importedStats.DistinctKeys)
+		require.Equal(t, uint64(12), importedStats.TotalIndexEntries)
+		require.Equal(t, uint64(3), importedStats.LastIndexedBlock)
+		// the imported entries hold the key modifications inline
+		require.Greater(t, importedStats.ApproxSizeBytes, stats.ApproxSizeBytes)
+	})
+
+	t.Run("backfill", func(t *testing.T) {
+		archiveDir := t.TempDir()
+		_, err := historydb.ExportHistory(archiveDir, testNewHashFunc, store)
+		require.NoError(t, err)
+
+		p := env.testHistoryDBProvider
+		require.NoError(t, p.MarkStartingSavepoint("ledger1-backfilled", version.NewHeight(2, 1)))
+		db := p.GetDBHandle("ledger1-backfilled")
+		// block 3, committed after the snapshot, writes key1 that is also present in the backfilled history
+		require.NoError(t, db.levelDB.Put(constructDataKey("ns1", "key1", 3, 0), []byte{}, true))
+		require.NoError(t, db.levelDB.Put(constructIndexStatsKey("ns1"),
+			(&IndexStats{DistinctKeys: 1, TotalIndexEntries: 1, TotalVersions: 1, LastIndexedBlock: 3}).toBytes(), true))
+		require.NoError(t, db.Backfill(archiveDir, 2, nil))
+
+		backfilledStats, err := db.GetIndexStats("ns1")
+		require.NoError(t, err)
+		require.Equal(t, uint64(2), backfilledStats.DistinctKeys)
+		require.Equal(t, uint64(9), backfilledStats.TotalIndexEntries)
+		require.Equal(t, uint64(3), backfilledStats.LastIndexedBlock)
+	})
+
+	t.Run("copy-history", func(t *testing.T) {
+		to := env.testHistoryDBProvider.GetDBHandle("ledger1-copy")
+		require.NoError(t, copyHistory(historydb, to, 1, false, nil))
+		copiedStats, err := to.GetIndexStats("ns1")
+		require.NoError(t, err)
+		require.Equal(t, &IndexStats{
+			DistinctKeys:      2,
+			TotalIndexEntries: 4,
+			TotalVersions:     4,
+			ApproxSizeBytes:   copiedStats.ApproxSizeBytes,
+			LastIndexedBlock:  1,
+		}, copiedStats)
+		require.NotZero(t, copiedStats.ApproxSizeBytes)
+	})
+}
@@ -0,0 +1,373 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric/common/ledger/blkstorage"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/rwsetutil"
+	"github.com/hyperledger/fabric/internal/pkg/txflags"
+	protoutil "github.com/hyperledger/fabric/protoutil"
+	"github.com/pkg/errors"
+)
+
+// ErrTxCacheWarmUpStopped is returned by function `WarmUpTxCache` if the warmup is stopped before completion
+var ErrTxCacheWarmUpStopped = errors.New("decoded transaction cache warmup stopped")
+
+// decodedTx holds the parts of a transaction envelope that are needed for resolving the key modifications
+// of the history entries for the transaction. The results are parsed into the read-write set on first use
+// and the parsed read-write set is retained for the subsequent keys
+type decodedTx struct {
+	channelHeader *common.ChannelHeader
+	results       []byte
+
+	rwSetOnce sync.Once
+	rwSet     *rwsetutil.TxRwSet
+	rwSetErr  error
+}
+
+// txRWSet returns the read-write set parsed from the results of the transaction
+func (tx *decodedTx) txRWSet() (*rwsetutil.TxRwSet, error) {
+	tx.rwSetOnce.Do(func() {
+		rwSet := &rwsetutil.TxRwSet{}
+		if tx.rwSetErr = rwSet.FromProtoBytes(tx.results); tx.rwSetErr == nil {
+			tx.rwSet = rwSet
+		}
+	})
+	return tx.rwSet, tx.rwSetErr
+}
+
+// size approximates the memory held by the decoded transaction with the size of the serialized results, which
+// dominate both the decoded envelope and the parsed read-write set
+func (tx *decodedTx) size() int {
+	return len(tx.results)
+}
+
+// cachePolicy controls whether a lookup adds the transaction retrieved on a cache miss to the decoded transaction cache
+type cachePolicy int
+
+const (
+	// readThrough adds the transaction retrieved on a miss to the cache, as is appropriate for the interactive queries
+	readThrough cachePolicy = iota
+	// readAround serves a lookup from the cache, if present, but leaves the cache untouched on a miss, so that a bulk
+	// scan over the complete history does not evict the transactions cached for the interactive queries
+	readAround
+)
+
+// priority returns the priority with which the retrievals from the block store are scheduled for the lookups with
+// the policy. The lookups that read around the cache are those of the bulk queries
+func (p cachePolicy) priority() QueryPriority {
+	if p == readAround {
+		return Batch
+	}
+	return Interactive
+}
+
+type txLoc struct {
+	blockNum uint64
+	tranNum  uint64
+}
+
+// txCache is an LRU cache of the decoded transactions retrieved from the block store. The history entries of the
+// multiple keys written by a transaction resolve to the same transaction and hence, the cache saves retrieving and
+// unmarshalling the transaction envelope, and parsing its read-write set, again for each of the keys. The cache
+// is bounded by both the number of transactions and their total size, as a single transaction may be large.
+// A nil txCache is valid and caches nothing.
+type txCache struct {
+	maxTxs   int
+	maxBytes int
+	mutex    sync.Mutex
+	entries  map[txLoc]*list.Element
+	lru      *list.List
+	numBytes int
+	stats    *ledgerStats
+}
+
+type txCacheEntry struct {
+	loc txLoc
+	tx  *decodedTx
+}
+
+type txCacheConfig struct {
+	maxTxs   int
+	maxBytes int
+}
+
+// EnableDecodedTxCache enables caching, per ledger, up to maxTxs decoded transactions for resolving the key
+// modifications of the history entries. If maxBytes is positive, the least recently used transactions are also
+// evicted once the total size of the results of the cached transactions exceeds maxBytes. A transaction larger
+// than maxBytes is not cached. A maxTxs of 0 or less leaves the cache disabled.
+func (p *DBProvider) EnableDecodedTxCache(maxTxs, maxBytes int) {
+	if maxTxs <= 0 {
+		p.txCacheConf = nil
+		return
+	}
+	p.txCacheConf = &txCacheConfig{
+		maxTxs:   maxTxs,
+		maxBytes: maxBytes,
+	}
+}
+
+func newTxCache(conf *txCacheConfig, stats *ledgerStats) *txCache {
+	if conf == nil {
+		return nil
+	}
+	return &txCache{
+		maxTxs:   conf.maxTxs,
+		maxBytes: conf.maxBytes,
+		entries:  map[txLoc]*list.Element{},
+		lru:      list.New(),
+		stats:    stats,
+	}
+}
+
+// retrieveTx returns the decoded transaction at the given block and transaction number from the decoded transaction
+// cache or, if not cached, retrieves and decodes the transaction from the block store. The concurrent retrievals of
+// the same transaction are coalesced into one. The retrieved transaction is added to the cache as per the given policy
+func (d *DB) retrieveTx(txFetcher TxFetcher, blockNum, tranNum uint64, policy cachePolicy) (*decodedTx, error) {
+	loc := txLoc{blockNum, tranNum}
+	if tx := d.txCache.lookup(loc); tx != nil {
+		return tx, nil
+	}
+	tx, err := d.txRetrievals.do(loc, func() (*decodedTx, error) {
+		end := d.scheduler.begin(policy.priority())
+		defer end()
+		tranEnvelope, err := txFetcher.RetrieveTxByBlockNumTranNum(blockNum, tranNum)
+		if err != nil {
+			return nil, err
+		}
+		return decodeTx(tranEnvelope)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if policy == readThrough {
+		d.txCache.put(loc, tx)
+	}
+	return tx, nil
+}
+
+// retrieveTxs returns the decoded transactions at the given transaction numbers in a block, in the order of the given
+// tranNums, as function `retrieveTx` does for each of them. If the fetcher is a BatchTxFetcher, the transactions that
+// are not in the decoded transaction cache are retrieved with a single read of the block. Such a batch is not
+// coalesced with the concurrent retrievals of the same transactions.
+func (d *DB) retrieveTxs(txFetcher TxFetcher, blockNum uint64, tranNums []uint64, policy cachePolicy) ([]*decodedTx, error) {
+	txs := make([]*decodedTx, len(tranNums))
+	batchFetcher, ok := txFetcher.(BatchTxFetcher)
+	if !ok || len(tranNums) == 1 {
+		for i, tranNum := range tranNums {
+			tx, err := d.retrieveTx(txFetcher, blockNum, tranNum, policy)
+			if err != nil {
+				return nil, err
+			}
+			txs[i] = tx
+		}
+		return txs, nil
+	}
+
+	var missing []int
+	var missingTranNums []uint64
+	for i, tranNum := range tranNums {
+		if txs[i] = d.txCache.lookup(txLoc{blockNum, tranNum}); txs[i] == nil {
+			missing = append(missing, i)
+			missingTranNums = append(missingTranNums, tranNum)
+		}
+	}
+	if len(missing) == 0 {
+		return txs, nil
+	}
+	end := d.scheduler.begin(policy.priority())
+	tranEnvelopes, err := batchFetcher.RetrieveTxsByBlockNumTranNums(blockNum, missingTranNums)
+	end()
+	if err != nil {
+		return nil, err
+	}
+	for j, i := range missing {
+		tx, err := decodeTx(tranEnvelopes[j])
+		if err != nil {
+			return nil, err
+		}
+		if policy == readThrough {
+			d.txCache.put(txLoc{blockNum, tranNums[i]}, tx)
+		}
+		txs[i] = tx
+	}
+	return txs, nil
+}
+
+// lookup returns the cached transaction, if any, and records the hit or the miss in the metrics
+func (c *txCache) lookup(loc txLoc) *decodedTx {
+	if c == nil {
+		return nil
+	}
+	tx := c.get(loc)
+	if tx == nil {
+		c.stats.cacheMiss(decodedTxCacheName)
+		return nil
+	}
+	c.stats.cacheHit(decodedTxCacheName)
+	return tx
+}
+
+func (c *txCache) get(loc txLoc) *decodedTx {
+	if c == nil {
+		return nil
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	elem, ok := c.entries[loc]
+	if !ok {
+		return nil
+	}
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*txCacheEntry).tx
+}
+
+func (c *txCache) put(loc txLoc, tx *decodedTx) {
+	if c == nil || (c.maxBytes > 0 && tx.size() > c.maxBytes) {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if _, ok := c.entries[loc]; ok {
+		// added by a concurrent query in the meantime
+		return
+	}
+	c.entries[loc] = c.lru.PushFront(&txCacheEntry{loc: loc, tx: tx})
+	c.numBytes += tx.size()
+	for c.lru.Len() > c.maxTxs || (c.maxBytes > 0 && c.numBytes > c.maxBytes) {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		entry := oldest.Value.(*txCacheEntry)
+		delete(c.entries, entry.loc)
+		c.numBytes -= entry.tx.size()
+		c.stats.cacheEviction(decodedTxCacheName)
+	}
+	c.stats.updateCacheSize(decodedTxCacheName, c.lru.Len(), c.numBytes)
+}
+
+func (c *txCache) purge() {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.entries = map[txLoc]*list.Element{}
+	c.lru.Init()
+	c.numBytes = 0
+	c.stats.updateCacheSize(decodedTxCacheName, 0, 0)
+}
+
+func (c *txCache) len() int {
+	if c == nil {
+		return 0
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.lru.Len()
+}
+
+// WarmUpTxCache populates the decoded transaction cache with the valid endorser transactions of the last numBlocks
+// indexed blocks. The recently modified keys are typically the ones being queried and hence, the warmup saves serving
+// the first history queries after a peer restart entirely from the block files. The blocks are loaded from the oldest
+// to the newest, so that the transactions of the newest blocks are the last to be evicted. The warmup checks for the
+// stop signal after loading each block and returns ErrTxCacheWarmUpStopped if signaled.
+func (d *DB) WarmUpTxCache(blockStore *blkstorage.BlockStore, numBlocks uint64, stop <-chan struct{}) error {
+	if d.txCache == nil || numBlocks == 0 {
+		return nil
+	}
+	indexedHeight, err := d.IndexedHeight()
+	if err != nil {
+		return err
+	}
+	bcInfo, err := blockStore.GetBlockchainInfo()
+	if err != nil {
+		return err
+	}
+	// the blocks prior to the snapshot from which the ledger was bootstrapped are not available in the block store
+	var firstBlock uint64
+	if bsi := bcInfo.GetBootstrappingSnapshotInfo(); bsi != nil {
+		firstBlock = bsi.LastBlockInSnapshot + 1
+	}
+	if indexedHeight > firstBlock+numBlocks {
+		firstBlock = indexedHeight - numBlocks
+	}
+
+	numTxs := 0
+	for blockNum := firstBlock; blockNum < indexedHeight; blockNum++ {
+		select {
+		case <-stop:
+			return ErrTxCacheWarmUpStopped
+		default:
+		}
+		block, err := blockStore.RetrieveBlockByNumber(blockNum)
+		if err != nil {
+			return err
+		}
+		txsFilter := txflags.ValidationFlags(block.Metadata.Metadata[common.BlockMetadataIndex_TRANSACTIONS_FILTER])
+		for tranNum, envBytes := range block.Data.Data {
+			if txsFilter.IsInvalid(tranNum) {
+				continue
+			}
+			env, err := protoutil.GetEnvelopeFromBlock(envBytes)
+			if err != nil {
+				return err
+			}
+			if !isEndorserTransaction(env) {
+				continue
+			}
+			tx, err := decodeTx(env)
+			if err != nil {
+				return err
+			}
+			d.txCache.put(txLoc{blockNum, uint64(tranNum)}, tx)
+			numTxs++
+		}
+	}
+	logger.Infow("Warmed up the decoded transaction cache", "channel", d.name,
+		"fromBlock", firstBlock, "height", indexedHeight, "transactions", numTxs)
+	return nil
+}
+
+func isEndorserTransaction(env *common.Envelope) bool {
+	payload, err := protoutil.UnmarshalPayload(env.Payload)
+	if err != nil || payload.Header == nil {
+		return false
+	}
+	chdr, err := protoutil.UnmarshalChannelHeader(payload.Header.ChannelHeader)
+	return err == nil && common.HeaderType(chdr.Type) == common.HeaderType_ENDORSER_TRANSACTION
+}
+
+// decodeTx unmarshals the transaction envelope down to the channel header and the results of the first action
+func decodeTx(tranEnvelope *common.Envelope) (*decodedTx, error) {
+	payload, err := protoutil.UnmarshalPayload(tranEnvelope.Payload)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := protoutil.UnmarshalTransaction(payload.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	_, respPayload, err := protoutil.GetPayloads(tx.Actions[0])
+	if err != nil {
+		return nil, err
+	}
+
+	chdr, err := protoutil.UnmarshalChannelHeader(payload.Header.ChannelHeader)
+	if err != nil {
+		return nil, err
+	}
+	return &decodedTx{
+		channelHeader: chdr,
+		results:       respPayload.Results,
+	}, nil
+}
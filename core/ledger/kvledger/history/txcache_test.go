@@ -0,0 +1,182 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric/common/ledger/testutil"
+	util2 "github.com/hyperledger/fabric/common/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodedTxCache(t *testing.T) {
+	env := newTestHistoryEnv(t)
+	defer env.cleanup()
+	store, err := env.testBlockStorageEnv.provider.Open("ledger1")
+	require.NoError(t, err)
+	defer store.Shutdown()
+
+	env.testHistoryDBProvider.EnableDecodedTxCache(2, 0)
+	historydb := env.testHistoryDBProvider.GetDBHandle("ledger1")
+	bg, gb := testutil.NewBlockGenerator(t, "ledger1", false)
+	commit := func(block *common.Block) {
+		require.NoError(t, store.AddBlock(block))
+		require.NoError(t, historydb.Commit(block))
+	}
+	commit(gb)
+
+	// each transaction writes key1 and key2
+	var txs [][]byte
+	for i := 1; i <= 3; i++ {
+		simulator, err := env.txmgr.NewTxSimulator(util2.GenerateUUID())
+		require.NoError(t, err)
+		require.NoError(t, simulator.SetState("ns1", "key1", []byte(fmt.Sprintf("value1-%d", i))))
+		require.NoError(t, simulator.SetState("ns1", "key2", []byte(fmt.Sprintf("value2-%d", i))))
+		simulator.Done()
+		simRes, err := simulator.GetTxSimulationResults()
+		require.NoError(t, err)
+		pubSimResBytes, err := simRes.GetPubSimulationBytes()
+		require.NoError(t, err)
+		txs = append(txs, pubSimResBytes)
+	}
+	commit(bg.NextBlock(txs))
+
+	qe, err := historydb.NewQueryExecutor(store)
+	require.NoError(t, err)
+	testutilVerifyResults(t, qe, "ns1", "key1", []string{"value1-3", "value1-2", "value1-1"})
+	// the cache holds the two most recently used transactions
+	require.Equal(t, 2, historydb.txCache.len())
+	require.NotNil(t, historydb.txCache.get(txLoc{1, 1}))
+	require.NotNil(t, historydb.txCache.get(txLoc{1, 0}))
+	require.Nil(t, historydb.txCache.get(txLoc{1, 2}))
+
+	// the read-write set of a cached transaction is parsed once and reused for the other keys
+	cachedTx := historydb.txCache.get(txLoc{1, 0})
+	require.NotNil(t, cachedTx.rwSet)
+	rwSet, err := cachedTx.txRWSet()
+	require.NoError(t, err)
+	require.Same(t, cachedTx.rwSet, rwSet)
+	testutilVerifyResults(t, qe, "ns1", "key2", []string{"value2-3", "value2-2", "value2-1"})
+	require.Equal(t, 2, historydb.txCache.len())
+
+	t.Run("bulk-scan-read-around", func(t *testing.T) {
+		historydb := env.testHistoryDBProvider.GetDBHandle("ledger1")
+		var values []string
+		require.NoError(t, historydb.Scan("ns1", "", store, func(e *Entry) error {
+			values = append(values, string(e.KeyModification.Value))
+			return nil
+		}))
+		require.Equal(t, []string{"value1-1", "value1-2", "value1-3", "value2-1", "value2-2", "value2-3"}, values)
+		// the scan does not populate the cache
+		require.Equal(t, 0, historydb.txCache.len())
+
+		// the transactions already in the cache are used by the scan
+		qe, err := historydb.NewQueryExecutor(store)
+		require.NoError(t, err)
+		testutilVerifyResults(t, qe, "ns1", "key1", []string{"value1-3", "value1-2", "value1-1"})
+		cachedTx := historydb.txCache.get(txLoc{1, 0})
+		require.NotNil(t, cachedTx)
+		tx, err := historydb.retrieveTx(store, 1, 0, readAround)
+		require.NoError(t, err)
+		require.Same(t, cachedTx, tx)
+		tx, err = historydb.retrieveTx(store, 1, 2, readAround)
+		require.NoError(t, err)
+		require.NotNil(t, tx)
+		require.Nil(t, historydb.txCache.get(txLoc{1, 2}))
+	})
+
+	t.Run("max-bytes", func(t *testing.T) {
+		txSize := cachedTx.size()
+		env.testHistoryDBProvider.EnableDecodedTxCache(10, 2*txSize)
+		historydb := env.testHistoryDBProvider.GetDBHandle("ledger1")
+		qe, err := historydb.NewQueryExecutor(store)
+		require.NoError(t, err)
+		testutilVerifyResults(t, qe, "ns1", "key1", []string{"value1-3", "value1-2", "value1-1"})
+		require.LessOrEqual(t, historydb.txCache.numBytes, 2*txSize)
+		require.Equal(t, historydb.txCache.numBytes/txSize, historydb.txCache.len())
+
+		// a transaction larger than the budget is not cached
+		env.testHistoryDBProvider.EnableDecodedTxCache(10, txSize/2)
+		historydb = env.testHistoryDBProvider.GetDBHandle("ledger1")
+		qe, err = historydb.NewQueryExecutor(store)
+		require.NoError(t, err)
+		testutilVerifyResults(t, qe, "ns1", "key1", []string{"value1-3", "value1-2", "value1-1"})
+		require.Equal(t, 0, historydb.txCache.len())
+		require.Equal(t, 0, historydb.txCache.numBytes)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		env.testHistoryDBProvider.EnableDecodedTxCache(0, 0)
+		historydb := env.testHistoryDBProvider.GetDBHandle("ledger1")
+		require.Nil(t, historydb.txCache)
+		qe, err := historydb.NewQueryExecutor(store)
+		require.NoError(t, err)
+		testutilVerifyResults(t, qe, "ns1", "key1", []string{"value1-3", "value1-2", "value1-1"})
+		require.Equal(t, 0, historydb.txCache.len())
+	})
+}
+
+func TestWarmUpTxCache(t *testing.T) {
+	env := newTestHistoryEnv(t)
+	defer env.cleanup()
+	store, err := env.testBlockStorageEnv.provider.Open("ledger1")
+	require.NoError(t, err)
+	defer store.Shutdown()
+
+	env.testHistoryDBProvider.EnableDecodedTxCache(10, 0)
+	historydb := env.testHistoryDBProvider.GetDBHandle("ledger1")
+	bg, gb := testutil.NewBlockGenerator(t, "ledger1", false)
+	commit := func(block *common.Block) {
+		require.NoError(t, store.AddBlock(block))
+		require.NoError(t, historydb.Commit(block))
+	}
+	commit(gb)
+	for i := 1; i <= 3; i++ {
+		simulator, err := env.txmgr.NewTxSimulator(util2.GenerateUUID())
+		require.NoError(t, err)
+		require.NoError(t, simulator.SetState("ns1", "key1", []byte(fmt.Sprintf("value%d", i))))
+		simulator.Done()
+		simRes, err := simulator.GetTxSimulationResults()
+		require.NoError(t, err)
+		pubSimResBytes, err := simRes.GetPubSimulationBytes()
+		require.NoError(t, err)
+		commit(bg.NextBlock([][]byte{pubSimResBytes}))
+	}
+
+	// a restarted peer gets a new, empty cache
+	historydb = env.testHistoryDBProvider.GetDBHandle("ledger1")
+	require.NoError(t, historydb.WarmUpTxCache(store, 2, nil))
+	require.Equal(t, 2, historydb.txCache.len())
+	require.NotNil(t, historydb.txCache.get(txLoc{3, 0}))
+	require.NotNil(t, historydb.txCache.get(txLoc{2, 0}))
+	require.Nil(t, historydb.txCache.get(txLoc{1, 0}))
+
+	t.Run("more-blocks-than-height", func(t *testing.T) {
+		historydb := env.testHistoryDBProvider.GetDBHandle("ledger1")
+		require.NoError(t, historydb.WarmUpTxCache(store, 100, nil))
+		// the genesis block carries a config transaction, which is not cached
+		require.Equal(t, 3, historydb.txCache.len())
+	})
+
+	t.Run("stopped", func(t *testing.T) {
+		historydb := env.testHistoryDBProvider.GetDBHandle("ledger1")
+		stop := make(chan struct{})
+		close(stop)
+		require.Equal(t, ErrTxCacheWarmUpStopped, historydb.WarmUpTxCache(store, 2, stop))
+		require.Equal(t, 0, historydb.txCache.len())
+	})
+
+	t.Run("cache-disabled", func(t *testing.T) {
+		env.testHistoryDBProvider.EnableDecodedTxCache(0, 0)
+		historydb := env.testHistoryDBProvider.GetDBHandle("ledger1")
+		require.NoError(t, historydb.WarmUpTxCache(store, 2, nil))
+		require.Equal(t, 0, historydb.txCache.len())
+	})
+}
@@ -0,0 +1,112 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"bytes"
+
+	"github.com/hyperledger/fabric/common/ledger/util"
+	"github.com/hyperledger/fabric/common/ledger/util/leveldbhelper"
+	"github.com/pkg/errors"
+)
+
+// EnableTransactionIndex enables maintaining, along with the history entries, an index from the block and transaction
+// number of each entry to the key of the entry, which is queried by function `GetKeysForTransaction`. The index is
+// maintained by the block commits, the index batches applied from a replication stream, the imports from a snapshot,
+// and the backfill from an archive. The entries indexed before the transaction index is enabled are added to the
+// transaction index by a rebuild of the history or by an upgrade of the index format.
+func (p *DBProvider) EnableTransactionIndex() {
+	p.transactionIndex = true
+}
+
+// GetKeysForTransaction returns the history entries of the keys written by the transaction at the given block and
+// transaction number, in the order of keys, from the transaction index, i.e., without retrieving the transaction from
+// the block store. The keys that are not indexed in the history are not returned, whereas function
+// `GetWritesForTransaction` decodes the transaction for all its writes along with the values.
+func (d *DB) GetKeysForTransaction(blockNum, tranNum uint64) ([]*IndexEntry, error) {
+	if !d.transactionIndex {
+		return nil, errors.New("the transaction index of the history is not enabled")
+	}
+	prefix := constructTxKeyPrefix(blockNum, tranNum)
+	itr, err := d.levelDB.GetIterator(prefix, append(prefix, 0xff))
+	if err != nil {
+		return nil, err
+	}
+	defer itr.Release()
+
+	var entries []*IndexEntry
+	for itr.Next() {
+		entryKey := dataKey(bytes.TrimPrefix(itr.Key(), prefix))
+		ns, key, _, _, err := decodeDataKey(entryKey)
+		if err != nil {
+			return nil, err
+		}
+		val, err := d.levelDB.Get(entryKey)
+		if err != nil {
+			return nil, err
+		}
+		if val == nil {
+			return nil, errors.Errorf("history entry not found for the transaction index row of namespace [%s] key [%s] at block [%d] transaction [%d]",
+				ns, key, blockNum, tranNum)
+		}
+		entries = append(entries, &IndexEntry{
+			RawKey:    append([]byte{}, entryKey...),
+			Namespace: ns,
+			Key:       key,
+			BlockNum:  blockNum,
+			TranNum:   tranNum,
+			Inline:    len(val) > 0,
+		})
+	}
+	if err := itr.Error(); err != nil {
+		return nil, errors.Wrap(err, "internal leveldb error while iterating for transaction index rows")
+	}
+	return entries, nil
+}
+
+// addTxKeyRow adds to the batch the row of the transaction index for the history entry of the given dataKey, if the
+// transaction index is enabled
+func (d *DB) addTxKeyRow(batch *leveldbhelper.UpdateBatch, blockNum, tranNum uint64, key dataKey) {
+	if d.transactionIndex {
+		batch.Put(append(constructTxKeyPrefix(blockNum, tranNum), key...), emptyValue)
+	}
+}
+
+// addTxKeyRowForEntry is same as function `addTxKeyRow`, except that the block and transaction number are decoded
+// from the dataKey, as for the entries imported from an archive
+func (d *DB) addTxKeyRowForEntry(batch *leveldbhelper.UpdateBatch, key dataKey) error {
+	if !d.transactionIndex {
+		return nil
+	}
+	_, _, blockNum, tranNum, err := decodeDataKey(key)
+	if err != nil {
+		return err
+	}
+	d.addTxKeyRow(batch, blockNum, tranNum, key)
+	return nil
+}
+
+func constructTxKeyPrefix(blockNum, tranNum uint64) []byte {
+	k := append([]byte{}, txKeyKeyPrefix...)
+	k = append(k, util.EncodeOrderPreservingVarUint64(blockNum)...)
+	return append(k, util.EncodeOrderPreservingVarUint64(tranNum)...)
+}
+
+// decodeTxKeyRowKey returns the block and transaction number and the dataKey of a row of the transaction index
+func decodeTxKeyRowKey(rowKey []byte) (uint64, uint64, dataKey, error) {
+	remaining := rowKey[len(txKeyKeyPrefix):]
+	blockNum, n, err := util.DecodeOrderPreservingVarUint64(remaining)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	remaining = remaining[n:]
+	tranNum, n, err := util.DecodeOrderPreservingVarUint64(remaining)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	return blockNum, tranNum, dataKey(remaining[n:]), nil
+}
@@ -0,0 +1,115 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric/common/ledger/testutil"
+	util2 "github.com/hyperledger/fabric/common/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransactionIndex(t *testing.T) {
+	env := newTestHistoryEnv(t)
+	defer env.cleanup()
+	provider := env.testHistoryDBProvider
+	store, err := env.testBlockStorageEnv.provider.Open("ledger1")
+	require.NoError(t, err)
+	defer store.Shutdown()
+
+	historydb := provider.GetDBHandle("ledger1")
+	_, err = historydb.GetKeysForTransaction(1, 0)
+	require.EqualError(t, err, "the transaction index of the history is not enabled")
+
+	bg, gb := testutil.NewBlockGenerator(t, "ledger1", false)
+	var blocks []*common.Block
+	commit := func(block *common.Block) {
+		require.NoError(t, store.AddBlock(block))
+		require.NoError(t, historydb.Commit(block))
+		blocks = append(blocks, block)
+	}
+	commit(gb)
+	// each transaction writes the given keys of ns1 and key0 of ns2
+	commitTxs := func(txKeys ...[]string) {
+		var txs [][]byte
+		for _, keys := range txKeys {
+			simulator, err := env.txmgr.NewTxSimulator(util2.GenerateUUID())
+			require.NoError(t, err)
+			for _, key := range keys {
+				require.NoError(t, simulator.SetState("ns1", key, []byte(key)))
+			}
+			require.NoError(t, simulator.SetState("ns2", "key0", []byte("value")))
+			simulator.Done()
+			simRes, err := simulator.GetTxSimulationResults()
+			require.NoError(t, err)
+			pubSimResBytes, err := simRes.GetPubSimulationBytes()
+			require.NoError(t, err)
+			txs = append(txs, pubSimResBytes)
+		}
+		commit(bg.NextBlock(txs))
+	}
+	// the block committed before the transaction index is enabled is not in the index
+	commitTxs([]string{"key1"})
+	provider.EnableTransactionIndex()
+	historydb = provider.GetDBHandle("ledger1")
+	commitTxs([]string{"key2", "key1"}, []string{"key3"})
+
+	verifyKeys := func(db *DB, blockNum, tranNum uint64, expected ...string) {
+		entries, err := db.GetKeysForTransaction(blockNum, tranNum)
+		require.NoError(t, err)
+		var actual []string
+		for _, e := range entries {
+			require.Equal(t, blockNum, e.BlockNum)
+			require.Equal(t, tranNum, e.TranNum)
+			require.Equal(t, []byte(constructDataKey(e.Namespace, e.Key, blockNum, tranNum)), e.RawKey)
+			actual = append(actual, e.Namespace+":"+e.Key)
+		}
+		require.Equal(t, expected, actual)
+	}
+	verifyKeys(historydb, 1, 0)
+	verifyKeys(historydb, 2, 0, "ns1:key1", "ns1:key2", "ns2:key0")
+	verifyKeys(historydb, 2, 1, "ns1:key3", "ns2:key0")
+	verifyKeys(historydb, 2, 2)
+
+	// the index is maintained when the index batches are applied from a replication stream
+	replicaProvider, err := NewDBProvider(t.TempDir())
+	require.NoError(t, err)
+	defer replicaProvider.Close()
+	replicaProvider.EnableTransactionIndex()
+	replica := replicaProvider.GetDBHandle("ledger1")
+	for _, block := range blocks {
+		batch, err := historydb.NewIndexBatch(block)
+		require.NoError(t, err)
+		require.NoError(t, replica.ApplyIndexBatch(batch))
+	}
+	verifyKeys(replica, 1, 0, "ns1:key1", "ns2:key0")
+	verifyKeys(replica, 2, 1, "ns1:key3", "ns2:key0")
+	entries, err := replica.GetKeysForTransaction(2, 1)
+	require.NoError(t, err)
+	require.True(t, entries[0].Inline)
+
+	// a partial rebuild adds the entries indexed before the index was enabled to the index
+	sideProvider, err := NewDBProvider(t.TempDir())
+	require.NoError(t, err)
+	defer sideProvider.Close()
+	sideProvider.EnableTransactionIndex()
+	require.NoError(t, provider.Rebuild("ledger1", sideProvider, store, 2, nil))
+	historydb = provider.GetDBHandle("ledger1")
+	verifyKeys(historydb, 1, 0, "ns1:key1", "ns2:key0")
+	verifyKeys(historydb, 2, 0, "ns1:key1", "ns1:key2", "ns2:key0")
+
+	// the rows for the blocks after the rollback height are removed
+	require.NoError(t, provider.Rollback("ledger1", 1))
+	historydb = provider.GetDBHandle("ledger1")
+	verifyKeys(historydb, 1, 0, "ns1:key1", "ns2:key0")
+	verifyKeys(historydb, 2, 0)
+
+	_, _, _, err = decodeTxKeyRowKey([]byte{0x00, 't', 0x01})
+	require.Error(t, err)
+}
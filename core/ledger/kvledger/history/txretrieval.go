@@ -0,0 +1,76 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"sync"
+
+	"github.com/hyperledger/fabric-protos-go/common"
+)
+
+// TxFetcher retrieves a transaction by the number of the block and the position of the transaction in the block.
+// The history queries retrieve the transactions referenced by the history entries via a TxFetcher, which is the
+// block store of the ledger (*blkstorage.BlockStore) on a peer. The retrieved transactions are decoded and served
+// read-through the decoded transaction cache of the historydb, i.e., a transaction is looked up in the cache first and
+// retrieved via the TxFetcher on a miss, with the concurrent retrievals of the same transaction coalesced into one.
+type TxFetcher interface {
+	RetrieveTxByBlockNumTranNum(blockNum uint64, tranNum uint64) (*common.Envelope, error)
+}
+
+// BatchTxFetcher is a TxFetcher that also retrieves multiple transactions of a block with a single read of the block,
+// as the block store does. The history queries that read ahead multiple history entries in the same block retrieve
+// their transactions in a batch, if the TxFetcher is a BatchTxFetcher.
+type BatchTxFetcher interface {
+	TxFetcher
+	RetrieveTxsByBlockNumTranNums(blockNum uint64, tranNums []uint64) ([]*common.Envelope, error)
+}
+
+// txRetrievalGroup coalesces the concurrent retrievals of the same transaction, so that the history queries running
+// concurrently for a hot key trigger a single read of the block file, and a single decoding of the transaction,
+// instead of one per query. A retrieval that starts after an earlier one completes is not coalesced with it, as the
+// completed transactions are expected to be served by the decoded transaction cache, if enabled.
+type txRetrievalGroup struct {
+	mutex      sync.Mutex
+	retrievals map[txLoc]*txRetrieval
+}
+
+type txRetrieval struct {
+	done chan struct{}
+	tx   *decodedTx
+	err  error
+	// numWaiters is the number of the callers waiting on the retrieval started by another caller
+	numWaiters int
+}
+
+func newTxRetrievalGroup() *txRetrievalGroup {
+	return &txRetrievalGroup{
+		retrievals: map[txLoc]*txRetrieval{},
+	}
+}
+
+// do invokes the function retrieve for the transaction at the given location, unless a retrieval of the same
+// transaction is in progress, in which case it waits for that retrieval and returns its outcome
+func (g *txRetrievalGroup) do(loc txLoc, retrieve func() (*decodedTx, error)) (*decodedTx, error) {
+	g.mutex.Lock()
+	if r, ok := g.retrievals[loc]; ok {
+		r.numWaiters++
+		g.mutex.Unlock()
+		<-r.done
+		return r.tx, r.err
+	}
+	r := &txRetrieval{done: make(chan struct{})}
+	g.retrievals[loc] = r
+	g.mutex.Unlock()
+
+	r.tx, r.err = retrieve()
+
+	g.mutex.Lock()
+	delete(g.retrievals, loc)
+	g.mutex.Unlock()
+	close(r.done)
+	return r.tx, r.err
+}
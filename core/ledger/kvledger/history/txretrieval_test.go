@@ -0,0 +1,237 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric/common/ledger/testutil"
+	util2 "github.com/hyperledger/fabric/common/util"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+type countingTxFetcher struct {
+	TxFetcher
+	numFetches int32
+	err        error
+}
+
+func (f *countingTxFetcher) RetrieveTxByBlockNumTranNum(blockNum, tranNum uint64) (*common.Envelope, error) {
+	atomic.AddInt32(&f.numFetches, 1)
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.TxFetcher.RetrieveTxByBlockNumTranNum(blockNum, tranNum)
+}
+
+func TestTxFetcher(t *testing.T) {
+	env := newTestHistoryEnv(t)
+	defer env.cleanup()
+	store, err := env.testBlockStorageEnv.provider.Open("ledger1")
+	require.NoError(t, err)
+	defer store.Shutdown()
+
+	historydb := env.testHistoryDBProvider.GetDBHandle("ledger1")
+	bg, gb := testutil.NewBlockGenerator(t, "ledger1", false)
+	require.NoError(t, store.AddBlock(gb))
+	require.NoError(t, historydb.Commit(gb))
+	simulator, err := env.txmgr.NewTxSimulator(util2.GenerateUUID())
+	require.NoError(t, err)
+	require.NoError(t, simulator.SetState("ns1", "key1", []byte("value1")))
+	require.NoError(t, simulator.SetState("ns1", "key2", []byte("value2")))
+	simulator.Done()
+	simRes, err := simulator.GetTxSimulationResults()
+	require.NoError(t, err)
+	pubSimResBytes, err := simRes.GetPubSimulationBytes()
+	require.NoError(t, err)
+	block1 := bg.NextBlock([][]byte{pubSimResBytes})
+	require.NoError(t, store.AddBlock(block1))
+	require.NoError(t, historydb.Commit(block1))
+
+	// without the cache, the transaction is fetched for each key
+	fetcher := &countingTxFetcher{TxFetcher: store}
+	qe, err := historydb.NewQueryExecutor(fetcher)
+	require.NoError(t, err)
+	testutilVerifyResults(t, qe, "ns1", "key1", []string{"value1"})
+	testutilVerifyResults(t, qe, "ns1", "key2", []string{"value2"})
+	require.Equal(t, int32(2), fetcher.numFetches)
+
+	// with the cache, the transaction is fetched once and served read-through the cache afterwards
+	env.testHistoryDBProvider.EnableDecodedTxCache(10, 0)
+	historydb = env.testHistoryDBProvider.GetDBHandle("ledger1")
+	fetcher = &countingTxFetcher{TxFetcher: store}
+	qe, err = historydb.NewQueryExecutor(fetcher)
+	require.NoError(t, err)
+	testutilVerifyResults(t, qe, "ns1", "key1", []string{"value1"})
+	testutilVerifyResults(t, qe, "ns1", "key2", []string{"value2"})
+	require.Equal(t, int32(1), fetcher.numFetches)
+
+	// the error from the fetcher is returned by the query
+	historydb = env.testHistoryDBProvider.GetDBHandle("ledger1")
+	fetcher = &countingTxFetcher{TxFetcher: store, err: errors.New("fetch error")}
+	qe, err = historydb.NewQueryExecutor(fetcher)
+	require.NoError(t, err)
+	itr, err := qe.GetHistoryForKey("ns1", "key1")
+	require.NoError(t, err)
+	defer itr.Close()
+	_, err = itr.Next()
+	require.EqualError(t, err, "fetch error")
+	require.Equal(t, 0, historydb.txCache.len())
+}
+
+func TestTxRetrievalGroup(t *testing.T) {
+	g := newTxRetrievalGroup()
+	loc := txLoc{blockNum: 1, tranNum: 2}
+	numWaiters := func() int {
+		g.mutex.Lock()
+		defer g.mutex.Unlock()
+		if r, ok := g.retrievals[loc]; ok {
+			return r.numWaiters
+		}
+		return 0
+	}
+
+	var numRetrievals int32
+	release := make(chan struct{})
+	retrieved := &decodedTx{results: []byte("results")}
+	retrieve := func() (*decodedTx, error) {
+		atomic.AddInt32(&numRetrievals, 1)
+		<-release
+		return retrieved, nil
+	}
+
+	// the concurrent retrievals wait for the one in progress
+	var wg sync.WaitGroup
+	results := make([]*decodedTx, 10)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tx, err := g.do(loc, retrieve)
+			require.NoError(t, err)
+			results[i] = tx
+		}(i)
+	}
+	require.Eventually(t, func() bool { return numWaiters() == 9 }, time.Second, time.Millisecond)
+	close(release)
+	wg.Wait()
+	require.Equal(t, int32(1), atomic.LoadInt32(&numRetrievals))
+	for _, tx := range results {
+		require.Same(t, retrieved, tx)
+	}
+	require.Empty(t, g.retrievals)
+
+	// a retrieval after the completion of the earlier one is not coalesced
+	_, err := g.do(loc, retrieve)
+	require.NoError(t, err)
+	require.Equal(t, int32(2), atomic.LoadInt32(&numRetrievals))
+
+	// the error is returned to all the waiters
+	release = make(chan struct{})
+	errCh := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := g.do(loc, func() (*decodedTx, error) {
+				<-release
+				return nil, errors.New("retrieval error")
+			})
+			errCh <- err
+		}()
+	}
+	require.Eventually(t, func() bool { return numWaiters() == 1 }, time.Second, time.Millisecond)
+	close(release)
+	require.EqualError(t, <-errCh, "retrieval error")
+	require.EqualError(t, <-errCh, "retrieval error")
+}
+
+type countingBatchTxFetcher struct {
+	BatchTxFetcher
+	numFetches      int32
+	numBatchFetches int32
+	// batchTranNums are the tranNums requested in the batches
+	batchTranNums []uint64
+}
+
+func (f *countingBatchTxFetcher) RetrieveTxByBlockNumTranNum(blockNum, tranNum uint64) (*common.Envelope, error) {
+	atomic.AddInt32(&f.numFetches, 1)
+	return f.BatchTxFetcher.RetrieveTxByBlockNumTranNum(blockNum, tranNum)
+}
+
+func (f *countingBatchTxFetcher) RetrieveTxsByBlockNumTranNums(blockNum uint64, tranNums []uint64) ([]*common.Envelope, error) {
+	atomic.AddInt32(&f.numBatchFetches, 1)
+	f.batchTranNums = append(f.batchTranNums, tranNums...)
+	return f.BatchTxFetcher.RetrieveTxsByBlockNumTranNums(blockNum, tranNums)
+}
+
+func TestBatchTxFetcher(t *testing.T) {
+	env := newTestHistoryEnv(t)
+	defer env.cleanup()
+	store, err := env.testBlockStorageEnv.provider.Open("ledger1")
+	require.NoError(t, err)
+	defer store.Shutdown()
+
+	env.testHistoryDBProvider.EnableQueryPrefetch(8)
+	historydb := env.testHistoryDBProvider.GetDBHandle("ledger1")
+	bg, gb := testutil.NewBlockGenerator(t, "ledger1", false)
+	require.NoError(t, store.AddBlock(gb))
+	require.NoError(t, historydb.Commit(gb))
+	// the three transactions in the block write key1
+	var txs [][]byte
+	for i := 1; i <= 3; i++ {
+		simulator, err := env.txmgr.NewTxSimulator(util2.GenerateUUID())
+		require.NoError(t, err)
+		require.NoError(t, simulator.SetState("ns1", "key1", []byte(fmt.Sprintf("value%d", i))))
+		simulator.Done()
+		simRes, err := simulator.GetTxSimulationResults()
+		require.NoError(t, err)
+		pubSimResBytes, err := simRes.GetPubSimulationBytes()
+		require.NoError(t, err)
+		txs = append(txs, pubSimResBytes)
+	}
+	block1 := bg.NextBlock(txs)
+	require.NoError(t, store.AddBlock(block1))
+	require.NoError(t, historydb.Commit(block1))
+
+	// the first record is retrieved by itself and the two records read ahead are retrieved in a batch
+	fetcher := &countingBatchTxFetcher{BatchTxFetcher: store}
+	qe, err := historydb.NewQueryExecutor(fetcher)
+	require.NoError(t, err)
+	testutilVerifyResults(t, qe, "ns1", "key1", []string{"value3", "value2", "value1"})
+	require.Equal(t, int32(1), fetcher.numFetches)
+	require.Equal(t, int32(1), fetcher.numBatchFetches)
+
+	// a fetcher without the batched retrieval retrieves each transaction
+	singleFetcher := &countingTxFetcher{TxFetcher: store}
+	qe, err = historydb.NewQueryExecutor(singleFetcher)
+	require.NoError(t, err)
+	testutilVerifyResults(t, qe, "ns1", "key1", []string{"value3", "value2", "value1"})
+	require.Equal(t, int32(3), singleFetcher.numFetches)
+
+	t.Run("cached-transactions-not-fetched", func(t *testing.T) {
+		env.testHistoryDBProvider.EnableDecodedTxCache(10, 0)
+		historydb := env.testHistoryDBProvider.GetDBHandle("ledger1")
+		cachedTx, err := historydb.retrieveTx(store, 1, 1, readThrough)
+		require.NoError(t, err)
+
+		fetcher := &countingBatchTxFetcher{BatchTxFetcher: store}
+		txs, err := historydb.retrieveTxs(fetcher, 1, []uint64{2, 1, 0}, readThrough)
+		require.NoError(t, err)
+		require.Len(t, txs, 3)
+		require.Same(t, cachedTx, txs[1])
+		require.Equal(t, []uint64{2, 0}, fetcher.batchTranNums)
+		require.Equal(t, 3, historydb.txCache.len())
+		for i, tranNum := range []uint64{2, 1, 0} {
+			require.Same(t, historydb.txCache.get(txLoc{1, tranNum}), txs[i])
+		}
+	})
+}
@@ -0,0 +1,61 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/hyperledger/fabric-protos-go/ledger/rwset/kvrwset"
+	"github.com/hyperledger/fabric/common/ledger/blkstorage"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/rwsetutil"
+	"github.com/pkg/errors"
+)
+
+// GetWritesForTransaction returns the writes of the transaction at the given block and transaction number, in the
+// order of the read-write set of the transaction, with an entry per written key that carries the written value. The
+// transaction is decoded from its block as it is for indexing the history, so that a transaction marked invalid, or
+// one that is not an endorser transaction, is reported as an error. The writes are returned regardless of whether
+// the keys are indexed in the history.
+func (d *DB) GetWritesForTransaction(blockStore *blkstorage.BlockStore, blockNum, tranNum uint64) ([]*Entry, error) {
+	block, err := blockStore.RetrieveBlockByNumber(blockNum)
+	if err != nil {
+		return nil, err
+	}
+	if tranNum >= uint64(len(block.Data.Data)) {
+		return nil, errors.Errorf("transaction [%d] not found in block [%d], which has [%d] transactions",
+			tranNum, blockNum, len(block.Data.Data))
+	}
+	var writes []*Entry
+	found := false
+	if _, err := d.visitBlockTxs(block, func(txNum uint64, chdr *common.ChannelHeader, _ *common.Payload, txRWSet *rwsetutil.TxRwSet) error {
+		if txNum != tranNum {
+			return nil
+		}
+		found = true
+		return visitTxWrites(txNum, chdr, txRWSet, func(_ uint64, chdr *common.ChannelHeader, ns string, kvWrite *kvrwset.KVWrite) error {
+			writes = append(writes, &Entry{
+				Namespace: ns,
+				Key:       kvWrite.Key,
+				BlockNum:  blockNum,
+				TranNum:   tranNum,
+				KeyModification: &queryresult.KeyModification{
+					TxId:      chdr.TxId,
+					Value:     kvWrite.Value,
+					Timestamp: chdr.Timestamp,
+					IsDelete:  rwsetutil.IsKVWriteDelete(kvWrite),
+				},
+			})
+			return nil
+		})
+	}); err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, errors.Errorf("transaction [%d] of block [%d] is not a valid endorser transaction", tranNum, blockNum)
+	}
+	return writes, nil
+}
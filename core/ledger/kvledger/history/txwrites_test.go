@@ -0,0 +1,81 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/hyperledger/fabric/common/ledger/testutil"
+	util2 "github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/internal/pkg/txflags"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetWritesForTransaction(t *testing.T) {
+	env := newTestHistoryEnv(t)
+	defer env.cleanup()
+	store, err := env.testBlockStorageEnv.provider.Open("ledger1")
+	require.NoError(t, err)
+	defer store.Shutdown()
+	historydb := env.testHistoryDB
+
+	bg, gb := testutil.NewBlockGenerator(t, "ledger1", false)
+	require.NoError(t, store.AddBlock(gb))
+	require.NoError(t, historydb.Commit(gb))
+
+	var txs [][]byte
+	for _, value := range []string{"value1", "value2"} {
+		simulator, err := env.txmgr.NewTxSimulator(util2.GenerateUUID())
+		require.NoError(t, err)
+		require.NoError(t, simulator.SetState("ns1", "key1", []byte(value)))
+		require.NoError(t, simulator.DeleteState("ns1", "key2"))
+		require.NoError(t, simulator.SetState("ns2", "key3", []byte(value)))
+		simulator.Done()
+		simRes, err := simulator.GetTxSimulationResults()
+		require.NoError(t, err)
+		pubSimResBytes, err := simRes.GetPubSimulationBytes()
+		require.NoError(t, err)
+		txs = append(txs, pubSimResBytes)
+	}
+	block1 := bg.NextBlock(txs)
+	txsFilter := txflags.ValidationFlags(block1.Metadata.Metadata[common.BlockMetadataIndex_TRANSACTIONS_FILTER])
+	txsFilter.SetFlag(1, peer.TxValidationCode_INVALID_OTHER_REASON)
+	block1.Metadata.Metadata[common.BlockMetadataIndex_TRANSACTIONS_FILTER] = txsFilter
+	require.NoError(t, store.AddBlock(block1))
+	require.NoError(t, historydb.Commit(block1))
+
+	writes, err := historydb.GetWritesForTransaction(store, 1, 0)
+	require.NoError(t, err)
+	require.Len(t, writes, 3)
+	type write struct {
+		ns, key, value string
+		isDelete       bool
+	}
+	var actual []write
+	for _, w := range writes {
+		require.Equal(t, uint64(1), w.BlockNum)
+		require.Equal(t, uint64(0), w.TranNum)
+		require.NotEmpty(t, w.KeyModification.TxId)
+		actual = append(actual, write{w.Namespace, w.Key, string(w.KeyModification.Value), w.KeyModification.IsDelete})
+	}
+	require.Equal(t, []write{
+		{ns: "ns1", key: "key1", value: "value1"},
+		{ns: "ns1", key: "key2", isDelete: true},
+		{ns: "ns2", key: "key3", value: "value1"},
+	}, actual)
+
+	_, err = historydb.GetWritesForTransaction(store, 1, 1)
+	require.EqualError(t, err, "transaction [1] of block [1] is not a valid endorser transaction")
+	_, err = historydb.GetWritesForTransaction(store, 1, 2)
+	require.EqualError(t, err, "transaction [2] not found in block [1], which has [2] transactions")
+	_, err = historydb.GetWritesForTransaction(store, 0, 0)
+	require.EqualError(t, err, "transaction [0] of block [0] is not a valid endorser transaction")
+	_, err = historydb.GetWritesForTransaction(store, 2, 0)
+	require.Error(t, err)
+}
@@ -0,0 +1,162 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+
+	"github.com/hyperledger/fabric/common/ledger/util/leveldbhelper"
+	"github.com/pkg/errors"
+)
+
+// valueStateBytesLen is the length of an encoded valueState, i.e., the unchanged writes, the delete marker, and the
+// hash of the value
+const valueStateBytesLen = 8 + 1 + sha256.Size
+
+// KeyStats captures the statistics of the history of a key that are maintained in addition to its history entries
+type KeyStats struct {
+	// UnchangedWrites is the number of writes to the key that were not indexed as the value was identical to the
+	// previous value of the key (see function `EnableUnchangedWriteSkipping`)
+	UnchangedWrites uint64
+}
+
+// EnableUnchangedWriteSkipping enables, for the given namespaces, skipping the indexing of a write whose value is
+// identical to the previous value of the key, as is the case for the chaincodes that put the state without checking
+// whether it changed. Instead of an entry, such a write increments the count of the unchanged writes of the key,
+// which is returned by function `GetKeyStats`. A delete of a deleted key is an unchanged write as well. The previous
+// value of each key is tracked via its hash, starting from the first write after the skipping is enabled, which is
+// always indexed. The tracking applies to the block commits, including those replayed by a rebuild of the history, and
+// to the index batches applied from a replication stream. The tracked hashes are not carried over by a rebuild, an
+// upgrade of the index format, or a rollback, after which the first write to each key is indexed again. As the
+// history queries of a peer that skips the unchanged writes return fewer entries than those of a peer that does not,
+// the capability CapabilityUnchangedWriteSkipping is required to be enabled (see function `EnableCapabilities`).
+func (p *DBProvider) EnableUnchangedWriteSkipping(namespaces []string) error {
+	if len(namespaces) == 0 {
+		p.skipUnchangedNamespaces = nil
+		return nil
+	}
+	if !p.capabilityEnabled(CapabilityUnchangedWriteSkipping) {
+		return errors.Errorf("skipping the unchanged writes requires the capability [%s] to be enabled", CapabilityUnchangedWriteSkipping)
+	}
+	skipUnchangedNamespaces := map[string]struct{}{}
+	for _, ns := range namespaces {
+		if ns == "" {
+			return errors.New("invalid namespace for skipping the unchanged writes, the namespace cannot be empty")
+		}
+		skipUnchangedNamespaces[ns] = struct{}{}
+	}
+	p.skipUnchangedNamespaces = skipUnchangedNamespaces
+	return nil
+}
+
+// GetKeyStats returns the statistics of the history of the given key. A key that is not tracked has all the
+// statistics as zero.
+func (d *DB) GetKeyStats(ns, key string) (*KeyStats, error) {
+	b, err := d.levelDB.Get(constructValueStateKey(ns, d.normalizeKey(ns, key)))
+	if err != nil {
+		return nil, err
+	}
+	if b == nil {
+		return &KeyStats{}, nil
+	}
+	s, err := valueStateFromBytes(b)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyStats{UnchangedWrites: s.unchangedWrites}, nil
+}
+
+// valueState is the tracked value of a key, for which the unchanged writes are skipped
+type valueState struct {
+	unchangedWrites uint64
+	isDelete        bool
+	valueHash       []byte
+}
+
+// isUnchanged returns true if the write does not change the tracked value of the key, in which case the count of the
+// unchanged writes of the key is incremented. Otherwise, the write is tracked as the value of the key. The updated
+// state is written along with the batch by function `flush`
+func (t *indexStatsTracker) isUnchanged(ns, key string, value []byte, isDelete bool) (bool, error) {
+	stateKey := string(constructValueStateKey(ns, key))
+	state, ok := t.valueStates[stateKey]
+	if !ok {
+		b, err := t.db.levelDB.Get([]byte(stateKey))
+		if err != nil {
+			return false, err
+		}
+		if b != nil {
+			if state, err = valueStateFromBytes(b); err != nil {
+				return false, err
+			}
+		}
+	}
+	if state.isSameWrite(value, isDelete) {
+		state.unchangedWrites++
+		t.valueStates[stateKey] = state
+		return true, nil
+	}
+	if state == nil {
+		state = &valueState{}
+	}
+	state.track(value, isDelete)
+	t.valueStates[stateKey] = state
+	return false, nil
+}
+
+// isSameWrite returns true if the write leaves the tracked value unchanged, i.e., the write is skipped. A nil
+// valueState tracks no value and hence, no write is the same
+func (s *valueState) isSameWrite(value []byte, isDelete bool) bool {
+	if s == nil {
+		return false
+	}
+	valueHash := sha256.Sum256(value)
+	return s.isDelete == isDelete && bytes.Equal(s.valueHash, valueHash[:])
+}
+
+// track tracks the value of the write as the value of the key
+func (s *valueState) track(value []byte, isDelete bool) {
+	valueHash := sha256.Sum256(value)
+	s.isDelete, s.valueHash = isDelete, valueHash[:]
+}
+
+// clearValueState removes the tracked value of the key of the given dataKey, as the entry being added to the batch may
+// be for a write later than the tracked one
+func clearValueState(batch *leveldbhelper.UpdateBatch, key []byte) error {
+	ns, k, _, _, err := decodeDataKey(key)
+	if err != nil {
+		return err
+	}
+	batch.Delete(constructValueStateKey(ns, k))
+	return nil
+}
+
+func constructValueStateKey(ns, key string) []byte {
+	return append(append([]byte{}, valueStateKeyPrefix...), constructRangeScan(ns, key).startKey...)
+}
+
+func (s *valueState) toBytes() []byte {
+	b := make([]byte, valueStateBytesLen)
+	binary.BigEndian.PutUint64(b[0:8], s.unchangedWrites)
+	if s.isDelete {
+		b[8] = 1
+	}
+	copy(b[9:], s.valueHash)
+	return b
+}
+
+func valueStateFromBytes(b []byte) (*valueState, error) {
+	if len(b) != valueStateBytesLen {
+		return nil, errors.Errorf("unexpected length of the value state bytes: %d", len(b))
+	}
+	return &valueState{
+		unchangedWrites: binary.BigEndian.Uint64(b[0:8]),
+		isDelete:        b[8] == 1,
+		valueHash:       append([]byte{}, b[9:]...),
+	}, nil
+}
@@ -0,0 +1,134 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric/common/ledger/testutil"
+	util2 "github.com/hyperledger/fabric/common/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnchangedWriteSkipping(t *testing.T) {
+	env := newTestHistoryEnv(t)
+	defer env.cleanup()
+	provider := env.testHistoryDBProvider
+	require.EqualError(t, provider.EnableUnchangedWriteSkipping([]string{"ns1", ""}),
+		"skipping the unchanged writes requires the capability [UnchangedWriteSkipping] to be enabled")
+	require.NoError(t, provider.EnableCapabilities([]string{CapabilityUnchangedWriteSkipping}))
+	require.EqualError(t, provider.EnableUnchangedWriteSkipping([]string{"ns1", ""}),
+		"invalid namespace for skipping the unchanged writes, the namespace cannot be empty")
+	require.NoError(t, provider.EnableUnchangedWriteSkipping([]string{"ns1"}))
+
+	store, err := env.testBlockStorageEnv.provider.Open("ledger1")
+	require.NoError(t, err)
+	defer store.Shutdown()
+	historydb := provider.GetDBHandle("ledger1")
+	bg, gb := testutil.NewBlockGenerator(t, "ledger1", false)
+	var blocks []*common.Block
+	commit := func(block *common.Block) {
+		require.NoError(t, store.AddBlock(block))
+		require.NoError(t, historydb.Commit(block))
+		blocks = append(blocks, block)
+	}
+	commit(gb)
+	// each value is written by a transaction, with nil for a delete
+	commitValues := func(values ...[]byte) {
+		var txs [][]byte
+		for _, v := range values {
+			simulator, err := env.txmgr.NewTxSimulator(util2.GenerateUUID())
+			require.NoError(t, err)
+			for _, ns := range []string{"ns1", "ns2"} {
+				if v == nil {
+					require.NoError(t, simulator.DeleteState(ns, "key1"))
+				} else {
+					require.NoError(t, simulator.SetState(ns, "key1", v))
+				}
+			}
+			simulator.Done()
+			simRes, err := simulator.GetTxSimulationResults()
+			require.NoError(t, err)
+			pubSimResBytes, err := simRes.GetPubSimulationBytes()
+			require.NoError(t, err)
+			txs = append(txs, pubSimResBytes)
+		}
+		commit(bg.NextBlock(txs))
+	}
+	commitValues([]byte("v1"))
+	commitValues([]byte("v1"), []byte("v2"), []byte("v2"))
+	commitValues(nil)
+	commitValues(nil, []byte("v2"))
+
+	qe, err := historydb.NewQueryExecutor(store)
+	require.NoError(t, err)
+	testutilVerifyResults(t, qe, "ns1", "key1", []string{"v2", "", "v2", "v1"})
+	testutilVerifyResults(t, qe, "ns2", "key1", []string{"v2", "", "", "v2", "v2", "v1", "v1"})
+	keyStats, err := historydb.GetKeyStats("ns1", "key1")
+	require.NoError(t, err)
+	require.Equal(t, &KeyStats{UnchangedWrites: 3}, keyStats)
+	keyStats, err = historydb.GetKeyStats("ns2", "key1")
+	require.NoError(t, err)
+	require.Equal(t, &KeyStats{}, keyStats)
+	stats, err := historydb.GetIndexStats("ns1")
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), stats.TotalIndexEntries)
+
+	// the unchanged writes are skipped when the index batches are applied from a replication stream
+	replicaProvider, err := NewDBProvider(t.TempDir())
+	require.NoError(t, err)
+	defer replicaProvider.Close()
+	require.NoError(t, replicaProvider.EnableCapabilities([]string{CapabilityUnchangedWriteSkipping}))
+	require.NoError(t, replicaProvider.EnableUnchangedWriteSkipping([]string{"ns1"}))
+	replica := replicaProvider.GetDBHandle("ledger1")
+	for _, block := range blocks {
+		batch, err := historydb.NewIndexBatch(block)
+		require.NoError(t, err)
+		require.NoError(t, replica.ApplyIndexBatch(batch))
+	}
+	replicaQE, err := replica.NewQueryExecutor(nil)
+	require.NoError(t, err)
+	testutilVerifyResults(t, replicaQE, "ns1", "key1", []string{"v2", "", "v2", "v1"})
+	keyStats, err = replica.GetKeyStats("ns1", "key1")
+	require.NoError(t, err)
+	require.Equal(t, &KeyStats{UnchangedWrites: 3}, keyStats)
+
+	// the tracked values are removed by a rollback and the first write to a key afterwards is indexed
+	require.NoError(t, provider.Rollback("ledger1", 3))
+	historydb = provider.GetDBHandle("ledger1")
+	keyStats, err = historydb.GetKeyStats("ns1", "key1")
+	require.NoError(t, err)
+	require.Equal(t, &KeyStats{}, keyStats)
+	require.NoError(t, historydb.Commit(blocks[4]))
+	qe, err = historydb.NewQueryExecutor(store)
+	require.NoError(t, err)
+	testutilVerifyResults(t, qe, "ns1", "key1", []string{"v2", "", "", "v2", "v1"})
+
+	// the version gap check expects only the writes that change the values to be indexed, and accepts an unchanged
+	// write indexed after the tracked values were removed
+	report, err := historydb.CheckVersionGaps("ns1", "", 1, 4, store)
+	require.NoError(t, err)
+	require.False(t, report.HasGaps())
+	require.Equal(t, uint64(3), report.UnchangedWrites)
+	// the value of the key before the checked blocks is that of its latest entry, the delete in block 3
+	report, err = historydb.CheckVersionGaps("ns1", "key1", 4, 4, store)
+	require.NoError(t, err)
+	require.False(t, report.HasGaps())
+	require.Equal(t, uint64(1), report.UnchangedWrites)
+	require.NoError(t, historydb.levelDB.Delete(constructDataKey("ns1", "key1", 4, 1), true))
+	report, err = historydb.CheckVersionGaps("ns1", "key1", 4, 4, store)
+	require.NoError(t, err)
+	require.Equal(t, []*VersionRef{{Namespace: "ns1", Key: "key1", BlockNum: 4, TranNum: 1}}, report.Missing)
+
+	valueStateBytes := (&valueState{unchangedWrites: 2, isDelete: true, valueHash: make([]byte, 32)}).toBytes()
+	decoded, err := valueStateFromBytes(valueStateBytes)
+	require.NoError(t, err)
+	require.Equal(t, &valueState{unchangedWrites: 2, isDelete: true, valueHash: make([]byte, 32)}, decoded)
+	_, err = valueStateFromBytes(valueStateBytes[1:])
+	require.EqualError(t, err, "unexpected length of the value state bytes: 40")
+}
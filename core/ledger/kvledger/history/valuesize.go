@@ -0,0 +1,108 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/common/ledger/util/leveldbhelper"
+	"github.com/pkg/errors"
+)
+
+// ValueSize is the size of the value written to a key by a transaction
+type ValueSize struct {
+	BlockNum uint64
+	TranNum  uint64
+	// Size is the size of the value in bytes, which is 0 for a delete
+	Size     uint64
+	IsDelete bool
+}
+
+// EnableValueSizeTracking enables, for the given namespaces, recording the size of the value of each indexed write,
+// which is returned by function `GetValueSizeHistory`. The sizes are recorded by the block commits, including those
+// replayed by a rebuild of the history, and by the index batches applied from a replication stream. The sizes are not
+// recorded for the entries imported from a snapshot or backfilled from an archive.
+func (p *DBProvider) EnableValueSizeTracking(namespaces []string) error {
+	if len(namespaces) == 0 {
+		p.valueSizeNamespaces = nil
+		return nil
+	}
+	valueSizeNamespaces := map[string]struct{}{}
+	for _, ns := range namespaces {
+		if ns == "" {
+			return errors.New("invalid namespace for the value size tracking, the namespace cannot be empty")
+		}
+		valueSizeNamespaces[ns] = struct{}{}
+	}
+	p.valueSizeNamespaces = valueSizeNamespaces
+	return nil
+}
+
+// GetValueSizeHistory returns the sizes of the values written to the given key, in the order of the writes, so that
+// the growth of a value can be followed without retrieving the values. Only the writes for which the size was recorded
+// are returned (see function `EnableValueSizeTracking`).
+func (d *DB) GetValueSizeHistory(ns, key string) ([]*ValueSize, error) {
+	rangeScan := constructRangeScan(ns, d.normalizeKey(ns, key))
+	itr, err := d.levelDB.GetIterator(
+		append(append([]byte{}, valueSizeKeyPrefix...), rangeScan.startKey...),
+		append(append([]byte{}, valueSizeKeyPrefix...), rangeScan.endKey...),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer itr.Release()
+
+	var sizes []*ValueSize
+	for itr.Next() {
+		blockNum, tranNum, err := rangeScan.decodeBlockNumTranNum(decodeValueSizeKey(itr.Key()))
+		if err != nil {
+			return nil, err
+		}
+		size, isDelete, err := decodeValueSize(itr.Value())
+		if err != nil {
+			return nil, err
+		}
+		sizes = append(sizes, &ValueSize{BlockNum: blockNum, TranNum: tranNum, Size: size, IsDelete: isDelete})
+	}
+	if err := itr.Error(); err != nil {
+		return nil, errors.Wrap(err, "internal leveldb error while iterating for value sizes")
+	}
+	return sizes, nil
+}
+
+// addValueSize adds to the batch the size of the value written by the history entry of the given dataKey
+func addValueSize(batch *leveldbhelper.UpdateBatch, key dataKey, size int, isDelete bool) {
+	buf := proto.NewBuffer(nil)
+	// the error is always nil for the encoding of a varint
+	_ = buf.EncodeVarint(uint64(size))
+	deleteMarker := byte(0)
+	if isDelete {
+		deleteMarker = 1
+	}
+	batch.Put(constructValueSizeKey(key), append(buf.Bytes(), deleteMarker))
+}
+
+func constructValueSizeKey(key dataKey) []byte {
+	return append(append([]byte{}, valueSizeKeyPrefix...), key...)
+}
+
+// decodeValueSizeKey returns the dataKey of the history entry whose value size is recorded under the given key
+func decodeValueSizeKey(key []byte) dataKey {
+	return dataKey(key[len(valueSizeKeyPrefix):])
+}
+
+func decodeValueSize(b []byte) (uint64, bool, error) {
+	buf := proto.NewBuffer(b)
+	size, err := buf.DecodeVarint()
+	if err != nil {
+		return 0, false, errors.Wrap(err, "error while decoding the value size")
+	}
+	marker := buf.Unread()
+	if len(marker) != 1 {
+		return 0, false, errors.Errorf("unexpected length of the value size bytes: %d", len(b))
+	}
+	return size, marker[0] == 1, nil
+}
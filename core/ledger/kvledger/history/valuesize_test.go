@@ -0,0 +1,102 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric/common/ledger/testutil"
+	util2 "github.com/hyperledger/fabric/common/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValueSizeHistory(t *testing.T) {
+	env := newTestHistoryEnv(t)
+	defer env.cleanup()
+	provider := env.testHistoryDBProvider
+	require.EqualError(t, provider.EnableValueSizeTracking([]string{"ns1", ""}),
+		"invalid namespace for the value size tracking, the namespace cannot be empty")
+	require.NoError(t, provider.EnableValueSizeTracking([]string{"ns1"}))
+
+	historydb := provider.GetDBHandle("ledger1")
+	bg, gb := testutil.NewBlockGenerator(t, "ledger1", false)
+	var blocks []*common.Block
+	commit := func(block *common.Block) {
+		require.NoError(t, historydb.Commit(block))
+		blocks = append(blocks, block)
+	}
+	commit(gb)
+	// each value is written by a transaction, with nil for a delete
+	commitValues := func(values ...[]byte) {
+		var txs [][]byte
+		for _, v := range values {
+			simulator, err := env.txmgr.NewTxSimulator(util2.GenerateUUID())
+			require.NoError(t, err)
+			for _, ns := range []string{"ns1", "ns2"} {
+				if v == nil {
+					require.NoError(t, simulator.DeleteState(ns, "key1"))
+				} else {
+					require.NoError(t, simulator.SetState(ns, "key1", v))
+				}
+			}
+			simulator.Done()
+			simRes, err := simulator.GetTxSimulationResults()
+			require.NoError(t, err)
+			pubSimResBytes, err := simRes.GetPubSimulationBytes()
+			require.NoError(t, err)
+			txs = append(txs, pubSimResBytes)
+		}
+		commit(bg.NextBlock(txs))
+	}
+	commitValues([]byte("v"))
+	commitValues([]byte("vv"), []byte("vvvv"))
+	commitValues(nil)
+
+	expectedSizes := []*ValueSize{
+		{BlockNum: 1, TranNum: 0, Size: 1},
+		{BlockNum: 2, TranNum: 0, Size: 2},
+		{BlockNum: 2, TranNum: 1, Size: 4},
+		{BlockNum: 3, TranNum: 0, IsDelete: true},
+	}
+	sizes, err := historydb.GetValueSizeHistory("ns1", "key1")
+	require.NoError(t, err)
+	require.Equal(t, expectedSizes, sizes)
+	sizes, err = historydb.GetValueSizeHistory("ns2", "key1")
+	require.NoError(t, err)
+	require.Nil(t, sizes)
+	sizes, err = historydb.GetValueSizeHistory("ns1", "key2")
+	require.NoError(t, err)
+	require.Nil(t, sizes)
+
+	// the sizes are recorded when the index batches are applied from a replication stream
+	replicaProvider, err := NewDBProvider(t.TempDir())
+	require.NoError(t, err)
+	defer replicaProvider.Close()
+	require.NoError(t, replicaProvider.EnableValueSizeTracking([]string{"ns1"}))
+	replica := replicaProvider.GetDBHandle("ledger1")
+	for _, block := range blocks {
+		batch, err := historydb.NewIndexBatch(block)
+		require.NoError(t, err)
+		require.NoError(t, replica.ApplyIndexBatch(batch))
+	}
+	sizes, err = replica.GetValueSizeHistory("ns1", "key1")
+	require.NoError(t, err)
+	require.Equal(t, expectedSizes, sizes)
+
+	// the sizes for the blocks after the rollback height are removed
+	require.NoError(t, provider.Rollback("ledger1", 1))
+	historydb = provider.GetDBHandle("ledger1")
+	sizes, err = historydb.GetValueSizeHistory("ns1", "key1")
+	require.NoError(t, err)
+	require.Equal(t, expectedSizes[:1], sizes)
+
+	_, _, err = decodeValueSize([]byte{0x01})
+	require.EqualError(t, err, "unexpected length of the value size bytes: 1")
+	_, _, err = decodeValueSize(nil)
+	require.EqualError(t, err, "error while decoding the value size: unexpected EOF")
+}
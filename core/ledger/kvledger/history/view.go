@@ -0,0 +1,180 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"bytes"
+
+	"github.com/hyperledger/fabric/common/ledger/util"
+	"github.com/hyperledger/fabric/common/ledger/util/leveldbhelper"
+	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/pkg/errors"
+)
+
+// RegisterView registers a view whose rows are maintained for the history entries added, to the historydbs
+// obtained from this provider, after the registration. The rows for the existing entries can be computed by
+// rebuilding the complete history, except for the entries imported from a snapshot, which are carried over by
+// the rebuild. The views are expected to be registered before the historydbs are used.
+func (p *DBProvider) RegisterView(view ledger.HistoryView) error {
+	name := view.Name()
+	if name == "" || bytes.Contains([]byte(name), compositeKeySep) {
+		return errors.Errorf("invalid history view name [%s], the name cannot be empty or contain the byte 0x00", name)
+	}
+	for _, v := range p.views {
+		if v.Name() == name {
+			return errors.Errorf("history view [%s] is already registered", name)
+		}
+	}
+	p.views = append(p.views, view)
+	return nil
+}
+
+// QueryView invokes the function visit for the history entries indexed under the given row of the view. The entries
+// are visited in the order of keys and, for a key, in the order of oldest to newest. The key modification for each
+// entry is resolved from the block store, unless stored inline. The query stops at the first error returned by visit
+// and returns that error.
+func (d *DB) QueryView(viewName, row string, txFetcher TxFetcher, visit func(*Entry) error) error {
+	if d.view(viewName) == nil {
+		return errors.Errorf("history view [%s] is not registered", viewName)
+	}
+	return d.queryViewRow(viewName, row, txFetcher, visit)
+}
+
+// queryViewRow invokes the function visit for the history entries indexed under the given row of the view, whether
+// the view is registered or the rows are maintained for the indexing hints of a chaincode
+func (d *DB) queryViewRow(viewName, row string, txFetcher TxFetcher, visit func(*Entry) error) error {
+	rowPrefix := constructViewRowPrefix(viewName, row)
+	itr, err := d.levelDB.GetIterator(rowPrefix, append(rowPrefix, 0xff))
+	if err != nil {
+		return err
+	}
+	defer itr.Release()
+
+	for itr.Next() {
+		if err := itr.Error(); err != nil {
+			return errors.Wrap(err, "internal leveldb error while iterating for history view rows")
+		}
+		e, err := d.resolveViewEntry(viewName, dataKey(bytes.TrimPrefix(itr.Key(), rowPrefix)), txFetcher)
+		if err != nil {
+			return err
+		}
+		if err := visit(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveViewEntry returns the history entry, with the given dataKey, indexed in the view
+func (d *DB) resolveViewEntry(viewName string, entryKey dataKey, txFetcher TxFetcher) (*Entry, error) {
+	ns, key, blockNum, tranNum, err := decodeDataKey(entryKey)
+	if err != nil {
+		return nil, err
+	}
+	val, err := d.levelDB.Get(entryKey)
+	if err != nil {
+		return nil, err
+	}
+	if val == nil {
+		return nil, errors.Errorf("history entry for namespace [%s] key [%s] at block [%d] transaction [%d] indexed in view [%s] is missing",
+			ns, key, blockNum, tranNum, viewName)
+	}
+	keyModification, err := d.resolveKeyModification(entryKey, val, txFetcher, readThrough)
+	if err != nil {
+		return nil, err
+	}
+	return &Entry{
+		Namespace:       ns,
+		Key:             key,
+		BlockNum:        blockNum,
+		TranNum:         tranNum,
+		KeyModification: keyModification,
+	}, nil
+}
+
+func (d *DB) view(name string) ledger.HistoryView {
+	for _, v := range d.views {
+		if v.Name() == name {
+			return v
+		}
+	}
+	return nil
+}
+
+// addViewRows adds to the batch the rows of all the views for the history entry with the given dataKey
+func (d *DB) addViewRows(batch *leveldbhelper.UpdateBatch, dataKey dataKey, ns, key string, value []byte, isDelete bool) error {
+	for _, v := range d.views {
+		rows, err := v.Project(ns, key, value, isDelete)
+		if err != nil {
+			return errors.WithMessagef(err, "error while projecting the write to namespace [%s] key [%s] into history view [%s]",
+				ns, key, v.Name())
+		}
+		for _, row := range rows {
+			batch.Put(constructViewRowKey(v.Name(), row, dataKey), emptyValue)
+		}
+	}
+	// the field indexes declared by the chaincode share the rows with the field indexes configured on the peer
+	if hints := d.hintsFor(ns); hints != nil && hints.fieldView != nil {
+		rows, err := hints.fieldView.Project(ns, key, value, isDelete)
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			batch.Put(constructViewRowKey(fieldIndexViewName, row, dataKey), emptyValue)
+		}
+	}
+	return nil
+}
+
+// addViewRowsForInlineEntry adds to the batch the rows of all the views for a history entry that holds the key
+// modification inline, i.e., an entry imported from a snapshot or backfilled from an archive
+func (d *DB) addViewRowsForInlineEntry(batch *leveldbhelper.UpdateBatch, key, val []byte) error {
+	if len(d.views) == 0 && len(d.loadChaincodeHints()) == 0 {
+		return nil
+	}
+	ns, k, _, _, err := decodeDataKey(key)
+	if err != nil {
+		return err
+	}
+	keyModification, err := d.decodeInlineEntry(ns, k, val)
+	if err != nil {
+		return err
+	}
+	return d.addViewRows(batch, key, ns, k, keyModification.Value, keyModification.IsDelete)
+}
+
+// constructViewRowKey builds the key of the format viewRowKeyPrefix~viewName~len(row)~row~dataKey
+func constructViewRowKey(viewName, row string, dataKey dataKey) []byte {
+	return append(constructViewRowPrefix(viewName, row), dataKey...)
+}
+
+func constructViewRowPrefix(viewName, row string) []byte {
+	k := append([]byte{}, viewRowKeyPrefix...)
+	k = append(k, viewName...)
+	k = append(k, compositeKeySep...)
+	k = append(k, util.EncodeOrderPreservingVarUint64(uint64(len(row)))...)
+	return append(k, row...)
+}
+
+// decodeViewRowKey decodes the dataKey from a key constructed via function `constructViewRowKey`
+func decodeViewRowKey(viewRowKey []byte) (dataKey, error) {
+	remaining := bytes.TrimPrefix(viewRowKey, viewRowKeyPrefix)
+	sepIndex := bytes.Index(remaining, compositeKeySep)
+	if sepIndex == -1 {
+		return nil, errors.Errorf("invalid view row key [%x], view name separator not found", viewRowKey)
+	}
+	remaining = remaining[sepIndex+1:]
+	rowLen, rowLenBytesConsumed, err := util.DecodeOrderPreservingVarUint64(remaining)
+	if err != nil {
+		return nil, err
+	}
+	remaining = remaining[rowLenBytesConsumed:]
+	if uint64(len(remaining)) < rowLen {
+		return nil, errors.Errorf("invalid view row key [%x], insufficient bytes for row of length %d", viewRowKey, rowLen)
+	}
+	return dataKey(remaining[rowLen:]), nil
+}
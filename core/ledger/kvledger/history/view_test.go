@@ -0,0 +1,189 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package history
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric/common/ledger/testutil"
+	util2 "github.com/hyperledger/fabric/common/util"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// ownerView indexes the writes by the value, which is treated as the owner of the key.
+// The deletes are left out of the view
+type ownerView struct {
+	projectErr error
+}
+
+func (v *ownerView) Name() string {
+	return "owner"
+}
+
+func (v *ownerView) Project(ns, key string, value []byte, isDelete bool) ([]string, error) {
+	if v.projectErr != nil {
+		return nil, v.projectErr
+	}
+	if isDelete {
+		return nil, nil
+	}
+	return []string{string(value)}, nil
+}
+
+func TestRegisterView(t *testing.T) {
+	provider, err := NewDBProvider(t.TempDir())
+	require.NoError(t, err)
+	defer provider.Close()
+
+	require.NoError(t, provider.RegisterView(&ownerView{}))
+	require.EqualError(t, provider.RegisterView(&ownerView{}), "history view [owner] is already registered")
+	require.EqualError(t, provider.RegisterView(&namedView{""}),
+		"invalid history view name [], the name cannot be empty or contain the byte 0x00")
+	require.EqualError(t, provider.RegisterView(&namedView{"a\x00b"}),
+		"invalid history view name [a\x00b], the name cannot be empty or contain the byte 0x00")
+}
+
+type namedView struct {
+	name string
+}
+
+func (v *namedView) Name() string {
+	return v.name
+}
+
+func (v *namedView) Project(ns, key string, value []byte, isDelete bool) ([]string, error) {
+	return nil, nil
+}
+
+func TestQueryView(t *testing.T) {
+	env := newTestHistoryEnv(t)
+	defer env.cleanup()
+	store, err := env.testBlockStorageEnv.provider.Open("ledger1")
+	require.NoError(t, err)
+	defer store.Shutdown()
+
+	view := &ownerView{}
+	require.NoError(t, env.testHistoryDBProvider.RegisterView(view))
+	historydb := env.testHistoryDBProvider.GetDBHandle("ledger1")
+	bg, gb := testutil.NewBlockGenerator(t, "ledger1", false)
+	require.NoError(t, store.AddBlock(gb))
+	require.NoError(t, historydb.Commit(gb))
+	for i, owner := range []string{"alice", "bob", "alice"} {
+		simulator, err := env.txmgr.NewTxSimulator(util2.GenerateUUID())
+		require.NoError(t, err)
+		require.NoError(t, simulator.SetState("ns1", "key1", []byte(owner)))
+		if i == 2 {
+			require.NoError(t, simulator.DeleteState("ns1", "key2"))
+		} else {
+			require.NoError(t, simulator.SetState("ns1", "key2", []byte("alice")))
+		}
+		simulator.Done()
+		simRes, err := simulator.GetTxSimulationResults()
+		require.NoError(t, err)
+		pubSimResBytes, err := simRes.GetPubSimulationBytes()
+		require.NoError(t, err)
+		block := bg.NextBlock([][]byte{pubSimResBytes})
+		require.NoError(t, store.AddBlock(block))
+		require.NoError(t, historydb.Commit(block))
+	}
+
+	type result struct {
+		key      string
+		blockNum uint64
+		value    string
+	}
+	queryView := func(db *DB, row string) []result {
+		var results []result
+		require.NoError(t, db.QueryView("owner", row, store, func(e *Entry) error {
+			require.Equal(t, "ns1", e.Namespace)
+			results = append(results, result{e.Key, e.BlockNum, string(e.KeyModification.Value)})
+			return nil
+		}))
+		return results
+	}
+
+	require.Equal(t,
+		[]result{
+			{"key1", 1, "alice"},
+			{"key1", 3, "alice"},
+			{"key2", 1, "alice"},
+			{"key2", 2, "alice"},
+		},
+		queryView(historydb, "alice"),
+	)
+	require.Equal(t, []result{{"key1", 2, "bob"}}, queryView(historydb, "bob"))
+	require.Nil(t, queryView(historydb, "ali"))
+
+	// the view rows are not history index entries
+	stats, err := historydb.GetIndexStats("ns1")
+	require.NoError(t, err)
+	require.Equal(t, uint64(6), stats.TotalIndexEntries)
+
+	err = historydb.QueryView("unknown", "alice", store, func(e *Entry) error { return nil })
+	require.EqualError(t, err, "history view [unknown] is not registered")
+	err = historydb.QueryView("owner", "alice", store, func(e *Entry) error { return errors.New("visit-error") })
+	require.EqualError(t, err, "visit-error")
+
+	t.Run("copy-history", func(t *testing.T) {
+		to := env.testHistoryDBProvider.GetDBHandle("ledger1-copy")
+		require.NoError(t, copyHistory(historydb, to, 1, false, nil))
+		require.Equal(t,
+			[]result{
+				{"key1", 1, "alice"},
+				{"key2", 1, "alice"},
+			},
+			queryView(to, "alice"),
+		)
+		require.Nil(t, queryView(to, "bob"))
+	})
+
+	t.Run("import-from-snapshot", func(t *testing.T) {
+		snapshotDir := t.TempDir()
+		_, err := historydb.ExportHistory(snapshotDir, testNewHashFunc, store)
+		require.NoError(t, err)
+		bcInfo, err := store.GetBlockchainInfo()
+		require.NoError(t, err)
+		snapshotInfo := &SnapshotInfo{
+			LastBlockNum:  bcInfo.Height - 1,
+			LastBlockHash: bcInfo.CurrentBlockHash,
+			NewHashFunc:   testNewHashFunc,
+		}
+		require.NoError(t, env.testHistoryDBProvider.ImportFromSnapshot("ledger1-from-snapshot", snapshotDir, snapshotInfo))
+		imported := env.testHistoryDBProvider.GetDBHandle("ledger1-from-snapshot")
+		require.Equal(t, queryView(historydb, "alice"), queryView(imported, "alice"))
+		require.Equal(t, queryView(historydb, "bob"), queryView(imported, "bob"))
+	})
+
+	t.Run("project-error", func(t *testing.T) {
+		view.projectErr = errors.New("project-error")
+		defer func() { view.projectErr = nil }()
+		simulator, err := env.txmgr.NewTxSimulator(util2.GenerateUUID())
+		require.NoError(t, err)
+		require.NoError(t, simulator.SetState("ns1", "key1", []byte("bob")))
+		simulator.Done()
+		simRes, err := simulator.GetTxSimulationResults()
+		require.NoError(t, err)
+		pubSimResBytes, err := simRes.GetPubSimulationBytes()
+		require.NoError(t, err)
+		err = historydb.Commit(bg.NextBlock([][]byte{pubSimResBytes}))
+		require.EqualError(t, err, "error while projecting the write to namespace [ns1] key [key1] into history view [owner]: project-error")
+		savepoint, err := historydb.GetLastSavepoint()
+		require.NoError(t, err)
+		require.Equal(t, uint64(3), savepoint.BlockNum)
+	})
+}
+
+func TestDecodeViewRowKey(t *testing.T) {
+	dataKey := constructDataKey("ns1", "key1", 5, 2)
+	decoded, err := decodeViewRowKey(constructViewRowKey("owner", "alice", dataKey))
+	require.NoError(t, err)
+	require.Equal(t, dataKey, decoded)
+
+	_, err = decodeViewRowKey(append([]byte{}, viewRowKeyPrefix...))
+	require.EqualError(t, err, "invalid view row key [0076], view name separator not found")
+}
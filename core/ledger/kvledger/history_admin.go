@@ -0,0 +1,411 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package kvledger
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/hyperledger/fabric/common/flogging"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/history"
+	"github.com/pkg/errors"
+)
+
+const (
+	// historyAdminAPIVersion is the version of the admin API, which is the version in the path of its URLs. The
+	// version is changed only for the changes to the API that are incompatible with the existing clients
+	historyAdminAPIVersion        = "v1"
+	historyAdminURLBaseV1         = "/history/" + historyAdminAPIVersion + "/"
+	historyAdminURLBaseV1Channels = historyAdminURLBaseV1 + "channels"
+	historyAdminURLBaseV1Version  = historyAdminURLBaseV1 + "version"
+
+	historyAdminChannelIDKey        = "channelID"
+	historyAdminProjectorKey        = "projector"
+	historyAdminURLWithChannelIDKey = historyAdminURLBaseV1Channels + "/{" + historyAdminChannelIDKey + "}"
+	historyAdminURLWithProjectorKey = historyAdminURLWithChannelIDKey + "/projections/{" + historyAdminProjectorKey + "}"
+)
+
+// HistoryAPIVersion is the response to a request for the version of the admin API and the capabilities of the history
+// of the peer, so that a client can discover the features supported by the peer before using them
+type HistoryAPIVersion struct {
+	APIVersion string `json:"apiVersion"`
+	// SupportedCapabilities are the history capabilities supported by the version of the peer and
+	// EnabledCapabilities are the ones enabled by its configuration
+	SupportedCapabilities []string `json:"supportedCapabilities"`
+	EnabledCapabilities   []string `json:"enabledCapabilities"`
+}
+
+// HistoryChannelList is the response to a request for the channels whose history is served by the admin API
+type HistoryChannelList struct {
+	Channels []string `json:"channels"`
+}
+
+// HistoryChannelStats is the response to a request for the statistics of the history of a channel
+type HistoryChannelStats struct {
+	// IndexedHeight is the height of the blocks indexed in the history and BlockHeight is the height of the block store
+	IndexedHeight uint64                    `json:"indexedHeight"`
+	BlockHeight   uint64                    `json:"blockHeight"`
+	Backfill      *history.BackfillProgress `json:"backfill,omitempty"`
+	DiskUsage     *history.DiskUsage        `json:"diskUsage"`
+	// IndexStats are the statistics of the index for the namespace given in the request, if any
+	IndexStats *history.IndexStats `json:"indexStats,omitempty"`
+	// Migration is the status of the migration of the history to the migration target, if the migration is enabled
+	Migration *history.MigrationStatus `json:"migration,omitempty"`
+	// Compaction is the status of the last compaction of the history started via the admin API, if any
+	Compaction *HistoryCompactionStatus `json:"compaction,omitempty"`
+}
+
+type HistoryAdminErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// historyAdminHandler handles the HTTP requests to the admin API of the history, which lets the operators inspect and
+// maintain the history of the open ledgers without the access to the file system of the peer or a restart of the peer.
+// The handler is registered with the admin handler registry of the ledger, which authenticates the clients
+type historyAdminHandler struct {
+	logger            *flogging.FabricLogger
+	ledgers           *historyLedgers
+	historydbProvider *history.DBProvider
+	router            *mux.Router
+}
+
+func newHistoryAdminHandler(ledgers *historyLedgers, historydbProvider *history.DBProvider) *historyAdminHandler {
+	handler := &historyAdminHandler{
+		logger:            flogging.MustGetLogger("kvledger.historyadmin"),
+		ledgers:           ledgers,
+		historydbProvider: historydbProvider,
+		router:            mux.NewRouter(),
+	}
+
+	// swagger:operation GET /history/v1/version history historyAPIVersion
+	// ---
+	// summary: Returns the version of the admin API and the supported and enabled history capabilities of the peer.
+	// responses:
+	//    '200':
+	//       description: Successfully retrieved the version.
+	handler.router.HandleFunc(historyAdminURLBaseV1Version, handler.serveVersion).Methods(http.MethodGet)
+
+	// swagger:operation GET /history/v1/channels history listHistoryChannels
+	// ---
+	// summary: Returns the channels whose history is served by the admin API.
+	// responses:
+	//    '200':
+	//       description: Successfully retrieved the channels.
+	handler.router.HandleFunc(historyAdminURLBaseV1Channels, handler.serveListChannels).Methods(http.MethodGet)
+
+	// swagger:operation GET /history/v1/channels/{channelID}/stats history historyStats
+	// ---
+	// summary: Returns the freshness, the disk usage, and the migration and compaction status of the history of a channel, and the index statistics of a namespace.
+	// parameters:
+	// - name: channelID
+	//   in: path
+	//   required: true
+	//   type: string
+	// - name: namespace
+	//   in: query
+	//   required: false
+	//   type: string
+	// responses:
+	//    '200':
+	//       description: Successfully retrieved the statistics.
+	//    '404':
+	//       description: The channel does not exist.
+	handler.router.HandleFunc(historyAdminURLWithChannelIDKey+"/stats", handler.serveStats).Methods(http.MethodGet)
+
+	// swagger:operation POST /history/v1/channels/{channelID}/compact history compactHistory
+	// ---
+	// summary: Starts compacting, in the background, the history of a namespace of a channel, or all the history of the channel if no namespace is given. The status of the compaction is returned by the stats of the channel.
+	// parameters:
+	// - name: channelID
+	//   in: path
+	//   required: true
+	//   type: string
+	// - name: namespace
+	//   in: query
+	//   required: false
+	//   type: string
+	// responses:
+	//    '202':
+	//       description: Successfully started the compaction.
+	//    '404':
+	//       description: The channel does not exist.
+	//    '409':
+	//       description: A compaction of the history of the channel is in progress.
+	handler.router.HandleFunc(historyAdminURLWithChannelIDKey+"/compact", handler.serveCompact).Methods(http.MethodPost)
+
+	// swagger:operation POST /history/v1/channels/{channelID}/caches/purge history purgeHistoryCaches
+	// ---
+	// summary: Removes all the entries from the caches of the history of a channel.
+	// responses:
+	//    '204':
+	//       description: Successfully purged the caches.
+	//    '404':
+	//       description: The channel does not exist.
+	handler.router.HandleFunc(historyAdminURLWithChannelIDKey+"/caches/purge", handler.servePurgeCaches).Methods(http.MethodPost)
+
+	// swagger:operation POST /history/v1/channels/{channelID}/rebuild history rebuildHistory
+	// ---
+	// summary: Starts rebuilding the history of a channel from a block in the background. The rebuilt history is served from the next start of the peer.
+	// parameters:
+	// - name: channelID
+	//   in: path
+	//   required: true
+	//   type: string
+	// - name: fromBlock
+	//   in: query
+	//   required: false
+	//   type: integer
+	// responses:
+	//    '202':
+	//       description: Successfully started the rebuild.
+	//    '400':
+	//       description: Bad request.
+	//    '404':
+	//       description: The channel does not exist.
+	handler.router.HandleFunc(historyAdminURLWithChannelIDKey+"/rebuild", handler.serveRebuild).Methods(http.MethodPost)
+
+	// swagger:operation GET /history/v1/channels/{channelID}/projections/{projector} history historyProjectionStatus
+	// ---
+	// summary: Returns the checkpoint and the suspension of a history projector for a channel.
+	// responses:
+	//    '200':
+	//       description: Successfully retrieved the status.
+	//    '400':
+	//       description: Bad request.
+	//    '404':
+	//       description: The channel does not exist.
+	handler.router.HandleFunc(historyAdminURLWithProjectorKey, handler.serveProjectionStatus).Methods(http.MethodGet)
+
+	// swagger:operation POST /history/v1/channels/{channelID}/projections/{projector}/replay history replayHistoryProjection
+	// ---
+	// summary: Rebuilds the projection of a history projector for a channel by replaying the blocks from a block.
+	// parameters:
+	// - name: fromBlock
+	//   in: query
+	//   required: false
+	//   type: integer
+	// responses:
+	//    '204':
+	//       description: Successfully replayed the projection.
+	//    '400':
+	//       description: Bad request.
+	//    '404':
+	//       description: The channel does not exist.
+	handler.router.HandleFunc(historyAdminURLWithProjectorKey+"/replay", handler.serveReplayProjection).Methods(http.MethodPost)
+
+	handler.registerQueryRoutes()
+	handler.registerOpsRoutes()
+
+	handler.router.NotFoundHandler = http.HandlerFunc(handler.serveNotFound)
+	handler.router.MethodNotAllowedHandler = http.HandlerFunc(handler.serveNotAllowed)
+	return handler
+}
+
+func (h *historyAdminHandler) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	h.router.ServeHTTP(resp, req)
+}
+
+func (h *historyAdminHandler) serveVersion(resp http.ResponseWriter, req *http.Request) {
+	h.sendResponseOK(resp, &HistoryAPIVersion{
+		APIVersion:            historyAdminAPIVersion,
+		SupportedCapabilities: history.SupportedCapabilities(),
+		EnabledCapabilities:   h.historydbProvider.EnabledCapabilities(),
+	})
+}
+
+func (h *historyAdminHandler) serveListChannels(resp http.ResponseWriter, req *http.Request) {
+	channelList := &HistoryChannelList{Channels: []string{}}
+	for _, l := range h.ledgers.list() {
+		channelList.Channels = append(channelList.Channels, l.ledgerID)
+	}
+	resp.Header().Set("Cache-Control", "no-store")
+	h.sendResponseOK(resp, channelList)
+}
+
+func (h *historyAdminHandler) serveStats(resp http.ResponseWriter, req *http.Request) {
+	l, ok := h.ledger(resp, req)
+	if !ok {
+		return
+	}
+	health := l.HistoryHealth()
+	if health.Err != nil {
+		h.sendResponseJsonError(resp, http.StatusInternalServerError, health.Err)
+		return
+	}
+	stats := &HistoryChannelStats{
+		IndexedHeight: health.IndexedHeight,
+		BlockHeight:   health.BlockHeight,
+		Backfill:      health.Backfill,
+	}
+	var err error
+	if stats.DiskUsage, err = l.historyDB.GetDiskUsage(); err != nil {
+		h.sendResponseJsonError(resp, http.StatusInternalServerError, err)
+		return
+	}
+	if namespace := req.URL.Query().Get("namespace"); namespace != "" {
+		if stats.IndexStats, err = l.historyDB.GetIndexStats(namespace); err != nil {
+			h.sendResponseJsonError(resp, http.StatusInternalServerError, err)
+			return
+		}
+	}
+	if l.config.HistoryDBConfig.MigrationTargetDir != "" {
+		if stats.Migration, err = l.historyDB.MigrationStatus(); err != nil {
+			h.sendResponseJsonError(resp, http.StatusInternalServerError, err)
+			return
+		}
+	}
+	stats.Compaction = l.historyCompactionStatus()
+	resp.Header().Set("Cache-Control", "no-store")
+	h.sendResponseOK(resp, stats)
+}
+
+func (h *historyAdminHandler) serveCompact(resp http.ResponseWriter, req *http.Request) {
+	l, ok := h.ledger(resp, req)
+	if !ok {
+		return
+	}
+	namespace := req.URL.Query().Get("namespace")
+	status, err := l.startHistoryCompaction(namespace)
+	switch {
+	case err == errHistoryCompactionInProgress:
+		h.sendResponseJsonError(resp, http.StatusConflict, err)
+		return
+	case err != nil:
+		h.sendResponseJsonError(resp, http.StatusInternalServerError, err)
+		return
+	}
+	h.logger.Infow("Started history compaction", "channel", l.ledgerID, "namespace", namespace)
+	h.sendResponse(resp, http.StatusAccepted, status)
+}
+
+func (h *historyAdminHandler) servePurgeCaches(resp http.ResponseWriter, req *http.Request) {
+	l, ok := h.ledger(resp, req)
+	if !ok {
+		return
+	}
+	l.historyDB.PurgeCaches()
+	h.logger.Infow("Purged history caches", "channel", l.ledgerID)
+	resp.WriteHeader(http.StatusNoContent)
+}
+
+func (h *historyAdminHandler) serveRebuild(resp http.ResponseWriter, req *http.Request) {
+	l, ok := h.ledger(resp, req)
+	if !ok {
+		return
+	}
+	fromBlock, ok := h.fromBlock(resp, req)
+	if !ok {
+		return
+	}
+	if err := l.StartHistoryRebuild(fromBlock); err != nil {
+		h.sendResponseJsonError(resp, http.StatusBadRequest, err)
+		return
+	}
+	h.logger.Infow("Started history rebuild", "channel", l.ledgerID, "fromBlock", fromBlock)
+	resp.WriteHeader(http.StatusAccepted)
+}
+
+func (h *historyAdminHandler) serveProjectionStatus(resp http.ResponseWriter, req *http.Request) {
+	l, ok := h.ledger(resp, req)
+	if !ok {
+		return
+	}
+	status, err := l.historyDB.ProjectionStatus(mux.Vars(req)[historyAdminProjectorKey])
+	if err != nil {
+		h.sendResponseJsonError(resp, http.StatusBadRequest, err)
+		return
+	}
+	resp.Header().Set("Cache-Control", "no-store")
+	h.sendResponseOK(resp, status)
+}
+
+func (h *historyAdminHandler) serveReplayProjection(resp http.ResponseWriter, req *http.Request) {
+	l, ok := h.ledger(resp, req)
+	if !ok {
+		return
+	}
+	fromBlock, ok := h.fromBlock(resp, req)
+	if !ok {
+		return
+	}
+	projector := mux.Vars(req)[historyAdminProjectorKey]
+	if err := l.historyDB.ReplayProjection(projector, fromBlock, l.blockStore); err != nil {
+		h.sendResponseJsonError(resp, http.StatusBadRequest, err)
+		return
+	}
+	h.logger.Infow("Replayed history projection", "channel", l.ledgerID, "projector", projector, "fromBlock", fromBlock)
+	resp.WriteHeader(http.StatusNoContent)
+}
+
+func (h *historyAdminHandler) serveNotFound(resp http.ResponseWriter, req *http.Request) {
+	h.sendResponseJsonError(resp, http.StatusNotFound, errors.Errorf("invalid path: %s", req.URL.Path))
+}
+
+func (h *historyAdminHandler) serveNotAllowed(resp http.ResponseWriter, req *http.Request) {
+	h.sendResponseJsonError(resp, http.StatusMethodNotAllowed, errors.Errorf("invalid request method: %s", req.Method))
+}
+
+// ledger returns the open ledger of the channel in the request. If the ledger is not open, the error response is sent
+// and ok is false
+func (h *historyAdminHandler) ledger(resp http.ResponseWriter, req *http.Request) (*kvLedger, bool) {
+	channelID := mux.Vars(req)[historyAdminChannelIDKey]
+	l := h.ledgers.get(channelID)
+	if l == nil {
+		h.sendResponseJsonError(resp, http.StatusNotFound, errors.Errorf("channel [%s] does not exist", channelID))
+		return nil, false
+	}
+	return l, true
+}
+
+// fromBlock returns the fromBlock query parameter of the request, which defaults to 0. If the parameter is invalid,
+// the error response is sent and ok is false
+func (h *historyAdminHandler) fromBlock(resp http.ResponseWriter, req *http.Request) (uint64, bool) {
+	return h.blockNumParam(resp, req, "fromBlock", false)
+}
+
+// blockNumParam returns the block number in the given query parameter of the request, which defaults to 0 unless
+// required. If the parameter is invalid, or missing while required, the error response is sent and ok is false
+func (h *historyAdminHandler) blockNumParam(resp http.ResponseWriter, req *http.Request, name string, required bool) (uint64, bool) {
+	param := req.URL.Query().Get(name)
+	if param == "" {
+		if required {
+			h.sendResponseJsonError(resp, http.StatusBadRequest, errors.Errorf("%s is required", name))
+			return 0, false
+		}
+		return 0, true
+	}
+	blockNum, err := strconv.ParseUint(param, 10, 64)
+	if err != nil {
+		h.sendResponseJsonError(resp, http.StatusBadRequest, errors.Errorf("invalid %s: %s", name, param))
+		return 0, false
+	}
+	return blockNum, true
+}
+
+func (h *historyAdminHandler) sendResponseJsonError(resp http.ResponseWriter, code int, err error) {
+	encoder := json.NewEncoder(resp)
+	resp.Header().Set("Content-Type", "application/json")
+	resp.WriteHeader(code)
+	if err := encoder.Encode(&HistoryAdminErrorResponse{Error: err.Error()}); err != nil {
+		h.logger.Errorf("failed to encode error, err: %s", err)
+	}
+}
+
+func (h *historyAdminHandler) sendResponseOK(resp http.ResponseWriter, content interface{}) {
+	h.sendResponse(resp, http.StatusOK, content)
+}
+
+func (h *historyAdminHandler) sendResponse(resp http.ResponseWriter, code int, content interface{}) {
+	encoder := json.NewEncoder(resp)
+	resp.Header().Set("Content-Type", "application/json")
+	resp.WriteHeader(code)
+	if err := encoder.Encode(content); err != nil {
+		h.logger.Errorf("failed to encode content, err: %s", err)
+	}
+}
@@ -0,0 +1,333 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package kvledger
+
+import (
+	"net/http"
+	"path/filepath"
+
+	"github.com/gorilla/mux"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/history"
+	"github.com/pkg/errors"
+)
+
+const (
+	historyAdminChaincodeKey = "chaincode"
+	historyAdminViewKey      = "view"
+)
+
+// HistoryCheckpointResult is the response to a request for a checkpoint of the history of a channel
+type HistoryCheckpointResult struct {
+	// Dir is the dir to which the read-only replica of the history is written
+	Dir string `json:"dir"`
+}
+
+// registerOpsRoutes registers the routes for the diagnostics of the history, i.e., the checks of the history against
+// the blocks and the state, and for copying the history out of the peer, i.e., the backups, the checkpoints, and the
+// replication stream of the index batches
+func (h *historyAdminHandler) registerOpsRoutes() {
+	// swagger:operation GET /history/v1/channels/{channelID}/namespaces/{namespace}/gaps history checkHistoryVersionGaps
+	// ---
+	// summary: Checks the versions recorded in the history of a namespace, or of a key, against the writes in a range of blocks and reports the gaps.
+	// parameters:
+	// - name: key
+	//   in: query
+	//   required: false
+	//   type: string
+	// - name: fromBlock
+	//   in: query
+	//   required: false
+	//   type: integer
+	// - name: toBlock
+	//   in: query
+	//   required: true
+	//   type: integer
+	// responses:
+	//    '200':
+	//       description: Successfully checked the history.
+	//    '400':
+	//       description: Bad request.
+	//    '404':
+	//       description: The channel does not exist.
+	h.router.HandleFunc(historyAdminURLWithNamespaceKey+"/gaps", h.serveVersionGaps).Methods(http.MethodGet)
+
+	// swagger:operation GET /history/v1/channels/{channelID}/namespaces/{namespace}/crosscheck history crossCheckHistoryWithState
+	// ---
+	// summary: Compares the latest history of the keys of a namespace, or of a key, with the state and reports the keys for which they disagree.
+	// parameters:
+	// - name: key
+	//   in: query
+	//   required: false
+	//   type: string
+	// responses:
+	//    '200':
+	//       description: Successfully checked the history.
+	//    '400':
+	//       description: Bad request.
+	//    '404':
+	//       description: The channel does not exist.
+	h.router.HandleFunc(historyAdminURLWithNamespaceKey+"/crosscheck", h.serveCrossCheck).Methods(http.MethodGet)
+
+	// swagger:operation GET /history/v1/channels/{channelID}/chaincodes/{chaincode}/definitions history chaincodeDefinitionHistory
+	// ---
+	// summary: Returns the commits of the definition of a chaincode, along with the approvals for each sequence, from the oldest to the newest.
+	// responses:
+	//    '200':
+	//       description: Successfully retrieved the commits.
+	//    '404':
+	//       description: The channel does not exist.
+	h.router.HandleFunc(historyAdminURLWithChannelIDKey+"/chaincodes/{"+historyAdminChaincodeKey+"}/definitions",
+		h.serveChaincodeDefinitions).Methods(http.MethodGet)
+
+	// swagger:operation GET /history/v1/channels/{channelID}/views/{view} history queryHistoryView
+	// ---
+	// summary: Streams, as newline delimited JSON, the history entries indexed under a row of a history view.
+	// parameters:
+	// - name: row
+	//   in: query
+	//   required: true
+	//   type: string
+	// responses:
+	//    '200':
+	//       description: Successfully started streaming the entries.
+	//    '400':
+	//       description: Bad request.
+	//    '404':
+	//       description: The channel does not exist.
+	h.router.HandleFunc(historyAdminURLWithChannelIDKey+"/views/{"+historyAdminViewKey+"}", h.serveView).Methods(http.MethodGet)
+
+	// swagger:operation GET /history/v1/channels/{channelID}/indexbatches history streamHistoryIndexBatches
+	// ---
+	// summary: Streams, as newline delimited JSON, the history index batches from a block up to the history savepoint, for replicating the history to a subscriber.
+	// parameters:
+	// - name: fromBlock
+	//   in: query
+	//   required: false
+	//   type: integer
+	// responses:
+	//    '200':
+	//       description: Successfully started streaming the batches.
+	//    '400':
+	//       description: Bad request.
+	//    '404':
+	//       description: The channel does not exist.
+	h.router.HandleFunc(historyAdminURLWithChannelIDKey+"/indexbatches", h.serveIndexBatches).Methods(http.MethodGet)
+
+	// swagger:operation GET /history/v1/channels/{channelID}/backup history backupHistory
+	// ---
+	// summary: Streams an incremental backup of the history of a channel, for the blocks from a block up to the history savepoint.
+	// parameters:
+	// - name: sinceBlock
+	//   in: query
+	//   required: false
+	//   type: integer
+	// produces:
+	// - application/octet-stream
+	// responses:
+	//    '200':
+	//       description: Successfully started streaming the backup.
+	//    '400':
+	//       description: Bad request.
+	//    '404':
+	//       description: The channel does not exist.
+	h.router.HandleFunc(historyAdminURLWithChannelIDKey+"/backup", h.serveBackup).Methods(http.MethodGet)
+
+	// swagger:operation POST /history/v1/channels/{channelID}/restore history restoreHistory
+	// ---
+	// summary: Restores the history of a channel from a backup in the body of the request. The backups are expected to be restored in the order they were taken.
+	// consumes:
+	// - application/octet-stream
+	// responses:
+	//    '200':
+	//       description: Successfully restored the backup.
+	//    '400':
+	//       description: Bad request.
+	//    '404':
+	//       description: The channel does not exist.
+	h.router.HandleFunc(historyAdminURLWithChannelIDKey+"/restore", h.serveRestore).Methods(http.MethodPost)
+
+	// swagger:operation POST /history/v1/channels/{channelID}/checkpoint history checkpointHistory
+	// ---
+	// summary: Writes a read-only replica of the history of a channel to a new dir with the given name, under the dir historyReplicas/{channelID} of the ledger.
+	// parameters:
+	// - name: name
+	//   in: query
+	//   required: true
+	//   type: string
+	// responses:
+	//    '201':
+	//       description: Successfully written the replica.
+	//    '400':
+	//       description: Bad request.
+	//    '404':
+	//       description: The channel does not exist.
+	h.router.HandleFunc(historyAdminURLWithChannelIDKey+"/checkpoint", h.serveCheckpoint).Methods(http.MethodPost)
+}
+
+func (h *historyAdminHandler) serveVersionGaps(resp http.ResponseWriter, req *http.Request) {
+	l, ok := h.ledger(resp, req)
+	if !ok {
+		return
+	}
+	fromBlock, ok := h.fromBlock(resp, req)
+	if !ok {
+		return
+	}
+	toBlock, ok := h.blockNumParam(resp, req, "toBlock", true)
+	if !ok {
+		return
+	}
+	report, err := l.historyDB.CheckVersionGaps(
+		mux.Vars(req)[historyAdminNamespaceKey], req.URL.Query().Get("key"), fromBlock, toBlock, l.blockStore,
+	)
+	if err != nil {
+		h.sendResponseJsonError(resp, http.StatusBadRequest, err)
+		return
+	}
+	resp.Header().Set("Cache-Control", "no-store")
+	h.sendResponseOK(resp, report)
+}
+
+func (h *historyAdminHandler) serveCrossCheck(resp http.ResponseWriter, req *http.Request) {
+	l, ok := h.ledger(resp, req)
+	if !ok {
+		return
+	}
+	report, err := l.historyDB.CrossCheckState(
+		mux.Vars(req)[historyAdminNamespaceKey], req.URL.Query().Get("key"), l.stateDB, l.blockStore,
+	)
+	if err != nil {
+		h.sendResponseJsonError(resp, http.StatusBadRequest, err)
+		return
+	}
+	resp.Header().Set("Cache-Control", "no-store")
+	h.sendResponseOK(resp, report)
+}
+
+func (h *historyAdminHandler) serveChaincodeDefinitions(resp http.ResponseWriter, req *http.Request) {
+	l, ok := h.ledger(resp, req)
+	if !ok {
+		return
+	}
+	commits, err := l.historyDB.GetChaincodeDefinitionHistory(mux.Vars(req)[historyAdminChaincodeKey], l.blockStore)
+	if err != nil {
+		h.sendResponseJsonError(resp, http.StatusInternalServerError, err)
+		return
+	}
+	if commits == nil {
+		commits = []*history.ChaincodeDefinitionCommit{}
+	}
+	h.sendResponseOK(resp, commits)
+}
+
+func (h *historyAdminHandler) serveView(resp http.ResponseWriter, req *http.Request) {
+	l, ok := h.ledger(resp, req)
+	if !ok {
+		return
+	}
+	row := req.URL.Query().Get("row")
+	if row == "" {
+		h.sendResponseJsonError(resp, http.StatusBadRequest, errors.New("row is required"))
+		return
+	}
+	h.sendResponseStream(resp, func(visit func(interface{}) error) error {
+		return l.historyDB.QueryView(mux.Vars(req)[historyAdminViewKey], row, l.blockStore,
+			func(e *history.Entry) error { return visit(e) },
+		)
+	})
+}
+
+func (h *historyAdminHandler) serveIndexBatches(resp http.ResponseWriter, req *http.Request) {
+	l, ok := h.ledger(resp, req)
+	if !ok {
+		return
+	}
+	fromBlock, ok := h.fromBlock(resp, req)
+	if !ok {
+		return
+	}
+	h.sendResponseStream(resp, func(visit func(interface{}) error) error {
+		return l.historyDB.StreamIndexBatches(fromBlock, l.blockStore,
+			func(batch *history.IndexBatch) error { return visit(batch) },
+		)
+	})
+}
+
+func (h *historyAdminHandler) serveBackup(resp http.ResponseWriter, req *http.Request) {
+	l, ok := h.ledger(resp, req)
+	if !ok {
+		return
+	}
+	sinceBlock, ok := h.blockNumParam(resp, req, "sinceBlock", false)
+	if !ok {
+		return
+	}
+	resp.Header().Set("Content-Type", "application/octet-stream")
+	w := &countingResponseWriter{ResponseWriter: resp}
+	info, err := l.historyDB.Backup(sinceBlock, w)
+	if err != nil {
+		if w.written == 0 {
+			h.sendResponseJsonError(resp, http.StatusBadRequest, err)
+			return
+		}
+		// the status has been sent along with a part of the backup, hence the response is aborted so that the client
+		// does not take the truncated backup for a complete one
+		h.logger.Errorw("Error while streaming history backup", "channel", l.ledgerID, "sinceBlock", sinceBlock, "error", err)
+		panic(http.ErrAbortHandler)
+	}
+	h.logger.Infow("Streamed history backup", "channel", l.ledgerID, "sinceBlock", sinceBlock,
+		"savepoint", info.Savepoint.BlockNum, "entries", info.NumEntries)
+}
+
+func (h *historyAdminHandler) serveRestore(resp http.ResponseWriter, req *http.Request) {
+	l, ok := h.ledger(resp, req)
+	if !ok {
+		return
+	}
+	info, err := l.historyDB.Restore(req.Body)
+	if err != nil {
+		h.sendResponseJsonError(resp, http.StatusBadRequest, err)
+		return
+	}
+	h.logger.Infow("Restored history backup", "channel", l.ledgerID, "sinceBlock", info.SinceBlock,
+		"savepoint", info.Savepoint.BlockNum, "entries", info.NumEntries)
+	h.sendResponseOK(resp, info)
+}
+
+func (h *historyAdminHandler) serveCheckpoint(resp http.ResponseWriter, req *http.Request) {
+	l, ok := h.ledger(resp, req)
+	if !ok {
+		return
+	}
+	// the replica is confined to the dir of the replicas of the channel, so that a client cannot write to an arbitrary
+	// path on the peer
+	name := req.URL.Query().Get("name")
+	if name == "" || name == "." || name == ".." || filepath.Base(name) != name {
+		h.sendResponseJsonError(resp, http.StatusBadRequest, errors.Errorf("invalid checkpoint name: %s", name))
+		return
+	}
+	dir := filepath.Join(HistoryReplicasPath(l.config.RootFSPath), l.ledgerID, name)
+	if err := l.historyDB.Checkpoint(dir, l.blockStore); err != nil {
+		h.sendResponseJsonError(resp, http.StatusBadRequest, err)
+		return
+	}
+	h.logger.Infow("Checkpointed history", "channel", l.ledgerID, "dir", dir)
+	h.sendResponse(resp, http.StatusCreated, &HistoryCheckpointResult{Dir: dir})
+}
+
+// countingResponseWriter counts the bytes of the body written to the response, so that an error can be reported as
+// an error response until the body is written
+type countingResponseWriter struct {
+	http.ResponseWriter
+	written int
+}
+
+func (w *countingResponseWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.written += n
+	return n, err
+}
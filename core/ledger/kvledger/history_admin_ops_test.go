@@ -0,0 +1,169 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package kvledger
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/ledger/testutil"
+	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/history"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistoryAdminOps(t *testing.T) {
+	conf := testConfig(t)
+	conf.HistoryDBConfig.MigrationTargetDir = t.TempDir()
+	provider, handler := newTestHistoryAdminHandler(t, conf, &valueHistoryView{})
+	defer provider.Close()
+
+	blkGenerator, genesisBlk := testutil.NewBlockGenerator(t, "testLedger", false)
+	lgr, err := provider.CreateFromGenesisBlock(genesisBlk)
+	require.NoError(t, err)
+	defer lgr.Close()
+	kvlgr := lgr.(*kvLedger)
+	for i, value := range []string{"value1.1", "value1.2", "value1.1"} {
+		blockAndPvtdata := prepareNextBlockForTest(t, kvlgr, blkGenerator, fmt.Sprintf("SimulateForBlk%d", i+1),
+			map[string]string{"key1": value}, nil)
+		require.NoError(t, kvlgr.CommitLegacy(blockAndPvtdata, &ledger.CommitOptions{}))
+	}
+
+	serve := func(method, target string) *httptest.ResponseRecorder {
+		return serveHistoryAdmin(handler, method, target)
+	}
+	scanValues := func(db *history.DB, txFetcher history.TxFetcher) []string {
+		var values []string
+		require.NoError(t, db.Scan("ns", "key1", txFetcher, func(e *history.Entry) error {
+			values = append(values, string(e.KeyModification.Value))
+			return nil
+		}))
+		return values
+	}
+
+	t.Run("stats", func(t *testing.T) {
+		resp := serve(http.MethodGet, "/history/v1/channels/testLedger/stats")
+		require.Equal(t, http.StatusOK, resp.Code)
+		stats := &HistoryChannelStats{}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), stats))
+		require.Equal(t, &history.MigrationStatus{IndexedHeight: 4, TargetIndexedHeight: 4}, stats.Migration)
+	})
+
+	t.Run("view", func(t *testing.T) {
+		resp := serve(http.MethodGet, "/history/v1/channels/testLedger/views/value?row=value1.1")
+		require.Equal(t, http.StatusOK, resp.Code)
+		var blockNums []uint64
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			entry := &history.Entry{}
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), entry))
+			blockNums = append(blockNums, entry.BlockNum)
+		}
+		require.Equal(t, []uint64{1, 3}, blockNums)
+
+		requireHistoryAdminError(t, serve(http.MethodGet, "/history/v1/channels/testLedger/views/value"),
+			http.StatusBadRequest, "row is required")
+	})
+
+	t.Run("index-batches", func(t *testing.T) {
+		subscriberProvider, err := history.NewDBProvider(t.TempDir())
+		require.NoError(t, err)
+		defer subscriberProvider.Close()
+		subscriber := subscriberProvider.GetDBHandle("testLedger")
+
+		resp := serve(http.MethodGet, "/history/v1/channels/testLedger/indexbatches?fromBlock=0")
+		require.Equal(t, http.StatusOK, resp.Code)
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			batch := &history.IndexBatch{}
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), batch))
+			require.NoError(t, subscriber.ApplyIndexBatch(batch))
+		}
+		require.Equal(t, []string{"value1.1", "value1.2", "value1.1"}, scanValues(subscriber, nil))
+
+		requireHistoryAdminError(t, serve(http.MethodGet, "/history/v1/channels/testLedger/indexbatches?fromBlock=x"),
+			http.StatusBadRequest, "invalid fromBlock: x")
+	})
+
+	t.Run("version-gaps", func(t *testing.T) {
+		resp := serve(http.MethodGet, "/history/v1/channels/testLedger/namespaces/ns/gaps?key=key1&fromBlock=1&toBlock=10")
+		require.Equal(t, http.StatusOK, resp.Code)
+		report := &history.VersionGapReport{}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), report))
+		require.Equal(t, uint64(3), report.ToBlock)
+		require.Equal(t, uint64(3), report.EntriesChecked)
+		require.Empty(t, report.Missing)
+
+		requireHistoryAdminError(t, serve(http.MethodGet, "/history/v1/channels/testLedger/namespaces/ns/gaps"),
+			http.StatusBadRequest, "toBlock is required")
+	})
+
+	t.Run("cross-check", func(t *testing.T) {
+		resp := serve(http.MethodGet, "/history/v1/channels/testLedger/namespaces/ns/crosscheck")
+		require.Equal(t, http.StatusOK, resp.Code)
+		report := &history.StateCrossCheckReport{}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), report))
+		require.Equal(t, uint64(1), report.KeysChecked)
+		require.Empty(t, report.Mismatches)
+	})
+
+	t.Run("chaincode-definitions", func(t *testing.T) {
+		resp := serve(http.MethodGet, "/history/v1/channels/testLedger/chaincodes/cc1/definitions")
+		require.Equal(t, http.StatusOK, resp.Code)
+		require.JSONEq(t, "[]", resp.Body.String())
+	})
+
+	t.Run("backup-restore", func(t *testing.T) {
+		resp := serve(http.MethodGet, "/history/v1/channels/testLedger/backup?sinceBlock=1")
+		require.Equal(t, http.StatusOK, resp.Code)
+		require.Equal(t, "application/octet-stream", resp.Header().Get("Content-Type"))
+		backup := resp.Body.Bytes()
+
+		// restoring the backup to the history that already holds its entries leaves the history intact
+		restoreResp := httptest.NewRecorder()
+		handler.ServeHTTP(restoreResp,
+			httptest.NewRequest(http.MethodPost, "/history/v1/channels/testLedger/restore", bytes.NewReader(backup)))
+		require.Equal(t, http.StatusOK, restoreResp.Code)
+		info := &history.BackupInfo{}
+		require.NoError(t, json.Unmarshal(restoreResp.Body.Bytes(), info))
+		require.Equal(t, "testLedger", info.LedgerID)
+		require.Equal(t, uint64(1), info.SinceBlock)
+		require.Equal(t, uint64(3), info.Savepoint.BlockNum)
+		require.Equal(t, []string{"value1.1", "value1.2", "value1.1"}, scanValues(kvlgr.historyDB, kvlgr.blockStore))
+
+		requireHistoryAdminError(t, serve(http.MethodGet, "/history/v1/channels/testLedger/backup?sinceBlock=10"),
+			http.StatusBadRequest, "cannot take a backup since block [10] as the history savepoint is at block [3]")
+		requireHistoryAdminError(t, serve(http.MethodPost, "/history/v1/channels/testLedger/restore"),
+			http.StatusBadRequest, "error while reading the history backup header: EOF")
+	})
+
+	t.Run("checkpoint", func(t *testing.T) {
+		resp := serve(http.MethodPost, "/history/v1/channels/testLedger/checkpoint?name=replica1")
+		require.Equal(t, http.StatusCreated, resp.Code)
+		result := &HistoryCheckpointResult{}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), result))
+		require.Equal(t, filepath.Join(HistoryReplicasPath(conf.RootFSPath), "testLedger", "replica1"), result.Dir)
+
+		replicaProvider, err := history.NewReadOnlyDBProvider(result.Dir)
+		require.NoError(t, err)
+		defer replicaProvider.Close()
+		require.Equal(t, []string{"value1.1", "value1.2", "value1.1"}, scanValues(replicaProvider.GetDBHandle("testLedger"), nil))
+
+		requireHistoryAdminError(t, serve(http.MethodPost, "/history/v1/channels/testLedger/checkpoint?name=replica1"),
+			http.StatusBadRequest, fmt.Sprintf("dir [%s] for the history checkpoint is not empty", result.Dir))
+		requireHistoryAdminError(t, serve(http.MethodPost, "/history/v1/channels/testLedger/checkpoint?name=..%2Freplica2"),
+			http.StatusBadRequest, "invalid checkpoint name: ../replica2")
+		requireHistoryAdminError(t, serve(http.MethodPost, "/history/v1/channels/testLedger/checkpoint"),
+			http.StatusBadRequest, "invalid checkpoint name: ")
+	})
+}
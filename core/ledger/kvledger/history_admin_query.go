@@ -0,0 +1,438 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package kvledger
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/history"
+	"github.com/pkg/errors"
+)
+
+const (
+	historyAdminNamespaceKey        = "namespace"
+	historyAdminFieldKey            = "field"
+	historyAdminBlockNumKey         = "blockNum"
+	historyAdminTranNumKey          = "tranNum"
+	historyAdminURLWithNamespaceKey = historyAdminURLWithChannelIDKey + "/namespaces/{" + historyAdminNamespaceKey + "}"
+	historyAdminURLWithTransaction  = historyAdminURLWithChannelIDKey + "/blocks/{" + historyAdminBlockNumKey + "}" +
+		"/transactions/{" + historyAdminTranNumKey + "}"
+
+	// historyAdminDefaultListLimit is the number of keys listed in a page if the request does not give a limit
+	historyAdminDefaultListLimit = 100
+)
+
+// registerQueryRoutes registers the routes for the queries of the history that are not served by the history query
+// executor of the chaincodes, i.e., the queries across the keys of a namespace, the queries by the transaction, and
+// the explanation of the query plans. The queries are served from the history of the peer and hence, may lag behind
+// the block commits with the asynchronous history commit
+func (h *historyAdminHandler) registerQueryRoutes() {
+	// swagger:operation GET /history/v1/channels/{channelID}/namespaces/{namespace}/keys history listHistoryKeys
+	// ---
+	// summary: Lists a page of the keys that have history in a namespace. A page is continued by the nextPageToken of the previous page.
+	// parameters:
+	// - name: pageToken
+	//   in: query
+	//   required: false
+	//   type: string
+	// - name: limit
+	//   in: query
+	//   required: false
+	//   type: integer
+	// responses:
+	//    '200':
+	//       description: Successfully listed the keys.
+	//    '400':
+	//       description: Bad request.
+	//    '404':
+	//       description: The channel does not exist.
+	h.router.HandleFunc(historyAdminURLWithNamespaceKey+"/keys", h.serveListKeys).Methods(http.MethodGet)
+
+	// swagger:operation GET /history/v1/channels/{channelID}/namespaces/{namespace}/histories history historiesForKeys
+	// ---
+	// summary: Returns a page of the histories of the keys given by the repeated key parameter, each from the newest to the oldest entry.
+	// parameters:
+	// - name: key
+	//   in: query
+	//   required: true
+	//   type: array
+	//   items:
+	//     type: string
+	//   collectionFormat: multi
+	// - name: pageToken
+	//   in: query
+	//   required: false
+	//   type: string
+	// responses:
+	//    '200':
+	//       description: Successfully retrieved the histories.
+	//    '400':
+	//       description: Bad request.
+	//    '404':
+	//       description: The channel does not exist.
+	h.router.HandleFunc(historyAdminURLWithNamespaceKey+"/histories", h.serveHistoriesForKeys).Methods(http.MethodGet)
+
+	// swagger:operation GET /history/v1/channels/{channelID}/namespaces/{namespace}/fields/{field} history historyByField
+	// ---
+	// summary: Streams, as newline delimited JSON, the history entries of a namespace whose written JSON value has the given value for a field.
+	// parameters:
+	// - name: value
+	//   in: query
+	//   required: true
+	//   type: string
+	// responses:
+	//    '200':
+	//       description: Successfully started streaming the entries.
+	//    '400':
+	//       description: Bad request.
+	//    '404':
+	//       description: The channel does not exist.
+	h.router.HandleFunc(historyAdminURLWithNamespaceKey+"/fields/{"+historyAdminFieldKey+"}", h.serveHistoryByField).Methods(http.MethodGet)
+
+	// swagger:operation GET /history/v1/channels/{channelID}/namespaces/{namespace}/search history searchHistory
+	// ---
+	// summary: Streams, as newline delimited JSON, the history entries of a namespace whose written value contains all the terms of a query.
+	// parameters:
+	// - name: query
+	//   in: query
+	//   required: true
+	//   type: string
+	// responses:
+	//    '200':
+	//       description: Successfully started streaming the entries.
+	//    '400':
+	//       description: Bad request.
+	//    '404':
+	//       description: The channel does not exist.
+	h.router.HandleFunc(historyAdminURLWithNamespaceKey+"/search", h.serveSearch).Methods(http.MethodGet)
+
+	// swagger:operation GET /history/v1/channels/{channelID}/namespaces/{namespace}/keystats history historyKeyStats
+	// ---
+	// summary: Returns the statistics of the history of a key.
+	// parameters:
+	// - name: key
+	//   in: query
+	//   required: true
+	//   type: string
+	// responses:
+	//    '200':
+	//       description: Successfully retrieved the statistics.
+	//    '400':
+	//       description: Bad request.
+	//    '404':
+	//       description: The channel does not exist.
+	h.router.HandleFunc(historyAdminURLWithNamespaceKey+"/keystats", h.serveKeyStats).Methods(http.MethodGet)
+
+	// swagger:operation GET /history/v1/channels/{channelID}/namespaces/{namespace}/valuesizes history historyValueSizes
+	// ---
+	// summary: Returns the sizes of the values written to a key, in the order of the writes.
+	// parameters:
+	// - name: key
+	//   in: query
+	//   required: true
+	//   type: string
+	// responses:
+	//    '200':
+	//       description: Successfully retrieved the sizes.
+	//    '400':
+	//       description: Bad request.
+	//    '404':
+	//       description: The channel does not exist.
+	h.router.HandleFunc(historyAdminURLWithNamespaceKey+"/valuesizes", h.serveValueSizes).Methods(http.MethodGet)
+
+	// swagger:operation GET /history/v1/channels/{channelID}/namespaces/{namespace}/explain history explainHistoryQuery
+	// ---
+	// summary: Returns the plan for a history query for a key or, with scan set, for a scan of a namespace or a key, without executing it.
+	// parameters:
+	// - name: key
+	//   in: query
+	//   required: false
+	//   type: string
+	// - name: scan
+	//   in: query
+	//   required: false
+	//   type: boolean
+	// responses:
+	//    '200':
+	//       description: Successfully retrieved the plan.
+	//    '400':
+	//       description: Bad request.
+	//    '404':
+	//       description: The channel does not exist.
+	h.router.HandleFunc(historyAdminURLWithNamespaceKey+"/explain", h.serveExplain).Methods(http.MethodGet)
+
+	// swagger:operation GET /history/v1/channels/{channelID}/blocks/{blockNum}/transactions/{tranNum}/writes history historyTransactionWrites
+	// ---
+	// summary: Returns the writes of a transaction, along with the written values, decoded from its block.
+	// responses:
+	//    '200':
+	//       description: Successfully retrieved the writes.
+	//    '400':
+	//       description: Bad request.
+	//    '404':
+	//       description: The channel does not exist.
+	h.router.HandleFunc(historyAdminURLWithTransaction+"/writes", h.serveTransactionWrites).Methods(http.MethodGet)
+
+	// swagger:operation GET /history/v1/channels/{channelID}/blocks/{blockNum}/transactions/{tranNum}/keys history historyTransactionKeys
+	// ---
+	// summary: Returns the keys written by a transaction, from the transaction index of the history.
+	// responses:
+	//    '200':
+	//       description: Successfully retrieved the keys.
+	//    '400':
+	//       description: Bad request.
+	//    '404':
+	//       description: The channel does not exist.
+	h.router.HandleFunc(historyAdminURLWithTransaction+"/keys", h.serveTransactionKeys).Methods(http.MethodGet)
+}
+
+func (h *historyAdminHandler) serveListKeys(resp http.ResponseWriter, req *http.Request) {
+	l, ok := h.ledger(resp, req)
+	if !ok {
+		return
+	}
+	limit := historyAdminDefaultListLimit
+	if param := req.URL.Query().Get("limit"); param != "" {
+		var err error
+		if limit, err = strconv.Atoi(param); err != nil {
+			h.sendResponseJsonError(resp, http.StatusBadRequest, errors.Errorf("invalid limit: %s", param))
+			return
+		}
+	}
+	keys, err := l.historyDB.ListIndexedKeysPage(mux.Vars(req)[historyAdminNamespaceKey], req.URL.Query().Get("pageToken"), limit)
+	if err != nil {
+		h.sendResponseJsonError(resp, http.StatusBadRequest, err)
+		return
+	}
+	h.sendResponseOK(resp, keys)
+}
+
+func (h *historyAdminHandler) serveHistoriesForKeys(resp http.ResponseWriter, req *http.Request) {
+	l, ok := h.ledger(resp, req)
+	if !ok {
+		return
+	}
+	keys := req.URL.Query()["key"]
+	if len(keys) == 0 {
+		h.sendResponseJsonError(resp, http.StatusBadRequest, errors.New("at least one key is required"))
+		return
+	}
+	page, err := l.historyDB.GetHistoriesForKeysPage(
+		mux.Vars(req)[historyAdminNamespaceKey], keys, req.URL.Query().Get("pageToken"), l.blockStore,
+	)
+	if err != nil {
+		h.sendResponseJsonError(resp, http.StatusBadRequest, err)
+		return
+	}
+	h.sendResponseOK(resp, page)
+}
+
+func (h *historyAdminHandler) serveHistoryByField(resp http.ResponseWriter, req *http.Request) {
+	l, ok := h.ledger(resp, req)
+	if !ok {
+		return
+	}
+	vars := mux.Vars(req)
+	value := req.URL.Query().Get("value")
+	h.sendResponseStream(resp, func(visit func(interface{}) error) error {
+		return l.historyDB.GetHistoryByField(vars[historyAdminNamespaceKey], vars[historyAdminFieldKey], value, l.blockStore,
+			func(e *history.Entry) error { return visit(e) },
+		)
+	})
+}
+
+func (h *historyAdminHandler) serveSearch(resp http.ResponseWriter, req *http.Request) {
+	l, ok := h.ledger(resp, req)
+	if !ok {
+		return
+	}
+	query := req.URL.Query().Get("query")
+	if query == "" {
+		h.sendResponseJsonError(resp, http.StatusBadRequest, errors.New("query is required"))
+		return
+	}
+	h.sendResponseStream(resp, func(visit func(interface{}) error) error {
+		return l.historyDB.SearchHistory(mux.Vars(req)[historyAdminNamespaceKey], query, l.blockStore,
+			func(e *history.Entry) error { return visit(e) },
+		)
+	})
+}
+
+func (h *historyAdminHandler) serveKeyStats(resp http.ResponseWriter, req *http.Request) {
+	l, ok := h.ledger(resp, req)
+	if !ok {
+		return
+	}
+	key, ok := h.key(resp, req)
+	if !ok {
+		return
+	}
+	stats, err := l.historyDB.GetKeyStats(mux.Vars(req)[historyAdminNamespaceKey], key)
+	if err != nil {
+		h.sendResponseJsonError(resp, http.StatusInternalServerError, err)
+		return
+	}
+	resp.Header().Set("Cache-Control", "no-store")
+	h.sendResponseOK(resp, stats)
+}
+
+func (h *historyAdminHandler) serveValueSizes(resp http.ResponseWriter, req *http.Request) {
+	l, ok := h.ledger(resp, req)
+	if !ok {
+		return
+	}
+	key, ok := h.key(resp, req)
+	if !ok {
+		return
+	}
+	sizes, err := l.historyDB.GetValueSizeHistory(mux.Vars(req)[historyAdminNamespaceKey], key)
+	if err != nil {
+		h.sendResponseJsonError(resp, http.StatusInternalServerError, err)
+		return
+	}
+	if sizes == nil {
+		sizes = []*history.ValueSize{}
+	}
+	h.sendResponseOK(resp, sizes)
+}
+
+func (h *historyAdminHandler) serveExplain(resp http.ResponseWriter, req *http.Request) {
+	l, ok := h.ledger(resp, req)
+	if !ok {
+		return
+	}
+	namespace := mux.Vars(req)[historyAdminNamespaceKey]
+	key := req.URL.Query().Get("key")
+	scan := false
+	if param := req.URL.Query().Get("scan"); param != "" {
+		var err error
+		if scan, err = strconv.ParseBool(param); err != nil {
+			h.sendResponseJsonError(resp, http.StatusBadRequest, errors.Errorf("invalid scan: %s", param))
+			return
+		}
+	}
+
+	var plan *history.QueryPlan
+	var err error
+	switch {
+	case scan:
+		plan, err = l.historyDB.ExplainScan(namespace, key)
+	case key == "":
+		h.sendResponseJsonError(resp, http.StatusBadRequest, errors.New("key is required, unless explaining a scan"))
+		return
+	default:
+		var qe ledger.HistoryQueryExecutor
+		if qe, err = l.historyDB.NewQueryExecutor(l.blockStore); err == nil {
+			plan, err = qe.(*history.QueryExecutor).ExplainHistoryForKey(namespace, key)
+		}
+	}
+	if err != nil {
+		h.sendResponseJsonError(resp, http.StatusBadRequest, err)
+		return
+	}
+	resp.Header().Set("Cache-Control", "no-store")
+	h.sendResponseOK(resp, plan)
+}
+
+func (h *historyAdminHandler) serveTransactionWrites(resp http.ResponseWriter, req *http.Request) {
+	l, ok := h.ledger(resp, req)
+	if !ok {
+		return
+	}
+	blockNum, tranNum, ok := h.transaction(resp, req)
+	if !ok {
+		return
+	}
+	writes, err := l.historyDB.GetWritesForTransaction(l.blockStore, blockNum, tranNum)
+	if err != nil {
+		h.sendResponseJsonError(resp, http.StatusBadRequest, err)
+		return
+	}
+	h.sendResponseOK(resp, writes)
+}
+
+func (h *historyAdminHandler) serveTransactionKeys(resp http.ResponseWriter, req *http.Request) {
+	l, ok := h.ledger(resp, req)
+	if !ok {
+		return
+	}
+	blockNum, tranNum, ok := h.transaction(resp, req)
+	if !ok {
+		return
+	}
+	entries, err := l.historyDB.GetKeysForTransaction(blockNum, tranNum)
+	if err != nil {
+		h.sendResponseJsonError(resp, http.StatusBadRequest, err)
+		return
+	}
+	if entries == nil {
+		entries = []*history.IndexEntry{}
+	}
+	h.sendResponseOK(resp, entries)
+}
+
+// key returns the key query parameter of the request. If the parameter is missing, the error response is sent and ok
+// is false
+func (h *historyAdminHandler) key(resp http.ResponseWriter, req *http.Request) (string, bool) {
+	key := req.URL.Query().Get("key")
+	if key == "" {
+		h.sendResponseJsonError(resp, http.StatusBadRequest, errors.New("key is required"))
+		return "", false
+	}
+	return key, true
+}
+
+// transaction returns the block and the transaction number in the path of the request. If either is invalid, the error
+// response is sent and ok is false
+func (h *historyAdminHandler) transaction(resp http.ResponseWriter, req *http.Request) (uint64, uint64, bool) {
+	vars := mux.Vars(req)
+	blockNum, err := strconv.ParseUint(vars[historyAdminBlockNumKey], 10, 64)
+	if err != nil {
+		h.sendResponseJsonError(resp, http.StatusBadRequest, errors.Errorf("invalid blockNum: %s", vars[historyAdminBlockNumKey]))
+		return 0, 0, false
+	}
+	tranNum, err := strconv.ParseUint(vars[historyAdminTranNumKey], 10, 64)
+	if err != nil {
+		h.sendResponseJsonError(resp, http.StatusBadRequest, errors.Errorf("invalid tranNum: %s", vars[historyAdminTranNumKey]))
+		return 0, 0, false
+	}
+	return blockNum, tranNum, true
+}
+
+// sendResponseStream sends the results visited by the query as newline delimited JSON, so that a query over a large
+// history is not held in memory. If the query fails before visiting a result, the error response is sent instead.
+// Otherwise, as the status has already been sent, the error is sent as the last line of the stream, in the form of
+// an error response
+func (h *historyAdminHandler) sendResponseStream(resp http.ResponseWriter, query func(visit func(interface{}) error) error) {
+	encoder := json.NewEncoder(resp)
+	started := false
+	start := func() {
+		resp.Header().Set("Content-Type", "application/x-ndjson")
+		resp.WriteHeader(http.StatusOK)
+		started = true
+	}
+	err := query(func(result interface{}) error {
+		if !started {
+			start()
+		}
+		return encoder.Encode(result)
+	})
+	switch {
+	case err == nil && !started:
+		start()
+	case err != nil && !started:
+		h.sendResponseJsonError(resp, http.StatusBadRequest, err)
+	case err != nil:
+		if err := encoder.Encode(&HistoryAdminErrorResponse{Error: err.Error()}); err != nil {
+			h.logger.Errorf("failed to encode error, err: %s", err)
+		}
+	}
+}
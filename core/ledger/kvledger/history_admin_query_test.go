@@ -0,0 +1,196 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package kvledger
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/ledger/testutil"
+	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/history"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistoryAdminQueries(t *testing.T) {
+	conf := testConfig(t)
+	conf.HistoryDBConfig.FieldIndexes = map[string][]string{"ns": {"owner"}}
+	conf.HistoryDBConfig.FullTextSearchNamespaces = []string{"ns"}
+	conf.HistoryDBConfig.ValueSizeTrackingNamespaces = []string{"ns"}
+	conf.HistoryDBConfig.TransactionIndex = true
+	conf.HistoryDBConfig.Capabilities = []string{history.CapabilityUnchangedWriteSkipping}
+	conf.HistoryDBConfig.SkipUnchangedWriteNamespaces = []string{"ns"}
+	provider, handler := newTestHistoryAdminHandler(t, conf)
+	defer provider.Close()
+
+	blkGenerator, genesisBlk := testutil.NewBlockGenerator(t, "testLedger", false)
+	lgr, err := provider.CreateFromGenesisBlock(genesisBlk)
+	require.NoError(t, err)
+	defer lgr.Close()
+	kvlgr := lgr.(*kvLedger)
+	for i, values := range [][]string{
+		{`{"owner":"alice"}`, "red car"},
+		{`{"owner":"bob"}`, "blue car"},
+		{`{"owner":"alice"}`, "red bicycle"},
+		{`{"owner":"alice"}`, "green bicycle"},
+	} {
+		blockAndPvtdata := prepareNextBlockForTest(t, kvlgr, blkGenerator, fmt.Sprintf("SimulateForBlk%d", i+1),
+			map[string]string{"key1": values[0], "key2": values[1]}, nil)
+		require.NoError(t, kvlgr.CommitLegacy(blockAndPvtdata, &ledger.CommitOptions{}))
+	}
+
+	serve := func(target string) *httptest.ResponseRecorder {
+		return serveHistoryAdmin(handler, http.MethodGet, target)
+	}
+	requireEntryBlocks := func(target string, expectedKey string, expectedBlocks []uint64) {
+		resp := serve(target)
+		require.Equal(t, http.StatusOK, resp.Code)
+		require.Equal(t, "application/x-ndjson", resp.Header().Get("Content-Type"))
+		var blockNums []uint64
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			entry := &history.Entry{}
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), entry))
+			require.Equal(t, expectedKey, entry.Key)
+			blockNums = append(blockNums, entry.BlockNum)
+		}
+		require.Equal(t, expectedBlocks, blockNums)
+	}
+
+	t.Run("history-by-field", func(t *testing.T) {
+		requireEntryBlocks("/history/v1/channels/testLedger/namespaces/ns/fields/owner?value=alice", "key1", []uint64{1, 3})
+
+		requireHistoryAdminError(t, serve("/history/v1/channels/testLedger/namespaces/ns/fields/color?value=red"),
+			http.StatusBadRequest, "field [color] is not indexed for namespace [ns]")
+	})
+
+	t.Run("search", func(t *testing.T) {
+		requireEntryBlocks("/history/v1/channels/testLedger/namespaces/ns/search?query=red", "key2", []uint64{1, 3})
+
+		requireHistoryAdminError(t, serve("/history/v1/channels/testLedger/namespaces/ns/search"),
+			http.StatusBadRequest, "query is required")
+	})
+
+	t.Run("key-stats", func(t *testing.T) {
+		resp := serve("/history/v1/channels/testLedger/namespaces/ns/keystats?key=key1")
+		require.Equal(t, http.StatusOK, resp.Code)
+		stats := &history.KeyStats{}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), stats))
+		require.Equal(t, &history.KeyStats{UnchangedWrites: 1}, stats)
+
+		requireHistoryAdminError(t, serve("/history/v1/channels/testLedger/namespaces/ns/keystats"),
+			http.StatusBadRequest, "key is required")
+	})
+
+	t.Run("value-sizes", func(t *testing.T) {
+		resp := serve("/history/v1/channels/testLedger/namespaces/ns/valuesizes?key=key1")
+		require.Equal(t, http.StatusOK, resp.Code)
+		var sizes []*history.ValueSize
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &sizes))
+		require.Equal(t, []*history.ValueSize{
+			{BlockNum: 1, TranNum: 0, Size: 17},
+			{BlockNum: 2, TranNum: 0, Size: 15},
+			{BlockNum: 3, TranNum: 0, Size: 17},
+		}, sizes)
+	})
+
+	t.Run("list-keys", func(t *testing.T) {
+		resp := serve("/history/v1/channels/testLedger/namespaces/ns/keys?limit=1")
+		require.Equal(t, http.StatusOK, resp.Code)
+		keys := &history.IndexedKeys{}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), keys))
+		require.Equal(t, []string{"key1"}, keys.Keys)
+		require.True(t, keys.HasMore)
+		require.Equal(t, uint64(2), keys.EstimatedTotal)
+
+		resp = serve("/history/v1/channels/testLedger/namespaces/ns/keys?limit=1&pageToken=" + url.QueryEscape(keys.NextPageToken))
+		require.Equal(t, http.StatusOK, resp.Code)
+		keys = &history.IndexedKeys{}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), keys))
+		require.Equal(t, []string{"key2"}, keys.Keys)
+		require.False(t, keys.HasMore)
+
+		requireHistoryAdminError(t, serve("/history/v1/channels/testLedger/namespaces/ns/keys?limit=x"),
+			http.StatusBadRequest, "invalid limit: x")
+		requireHistoryAdminError(t, serve("/history/v1/channels/testLedger/namespaces/ns/keys?limit=0"),
+			http.StatusBadRequest, "invalid limit [0] for listing the indexed keys, must be positive")
+	})
+
+	t.Run("histories-for-keys", func(t *testing.T) {
+		resp := serve("/history/v1/channels/testLedger/namespaces/ns/histories?key=key1&key=key2")
+		require.Equal(t, http.StatusOK, resp.Code)
+		page := &history.KeyHistoriesPage{}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), page))
+		require.Len(t, page.Histories["key1"], 3)
+		require.Equal(t, []byte(`{"owner":"alice"}`), page.Histories["key1"][0].Value)
+		require.Len(t, page.Histories["key2"], 4)
+		require.Equal(t, []byte("green bicycle"), page.Histories["key2"][0].Value)
+		require.Empty(t, page.NextPageToken)
+
+		requireHistoryAdminError(t, serve("/history/v1/channels/testLedger/namespaces/ns/histories"),
+			http.StatusBadRequest, "at least one key is required")
+	})
+
+	t.Run("explain", func(t *testing.T) {
+		resp := serve("/history/v1/channels/testLedger/namespaces/ns/explain?key=key1")
+		require.Equal(t, http.StatusOK, resp.Code)
+		plan := &history.QueryPlan{}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), plan))
+		require.Equal(t, "ns", plan.Namespace)
+		require.Equal(t, "key1", plan.Key)
+		require.Equal(t, uint64(5), plan.IndexedHeight)
+
+		resp = serve("/history/v1/channels/testLedger/namespaces/ns/explain?scan=true")
+		require.Equal(t, http.StatusOK, resp.Code)
+		plan = &history.QueryPlan{}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), plan))
+		require.Equal(t, "ns", plan.Namespace)
+		require.Empty(t, plan.Key)
+
+		requireHistoryAdminError(t, serve("/history/v1/channels/testLedger/namespaces/ns/explain"),
+			http.StatusBadRequest, "key is required, unless explaining a scan")
+		requireHistoryAdminError(t, serve("/history/v1/channels/testLedger/namespaces/ns/explain?scan=x"),
+			http.StatusBadRequest, "invalid scan: x")
+	})
+
+	t.Run("transaction-writes", func(t *testing.T) {
+		resp := serve("/history/v1/channels/testLedger/blocks/1/transactions/0/writes")
+		require.Equal(t, http.StatusOK, resp.Code)
+		var writes []*history.Entry
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &writes))
+		require.Len(t, writes, 2)
+		require.Equal(t, "key1", writes[0].Key)
+		require.Equal(t, []byte(`{"owner":"alice"}`), writes[0].KeyModification.Value)
+		require.Equal(t, "key2", writes[1].Key)
+		require.Equal(t, []byte("red car"), writes[1].KeyModification.Value)
+
+		requireHistoryAdminError(t, serve("/history/v1/channels/testLedger/blocks/x/transactions/0/writes"),
+			http.StatusBadRequest, "invalid blockNum: x")
+		requireHistoryAdminError(t, serve("/history/v1/channels/testLedger/blocks/1/transactions/x/writes"),
+			http.StatusBadRequest, "invalid tranNum: x")
+	})
+
+	t.Run("transaction-keys", func(t *testing.T) {
+		resp := serve("/history/v1/channels/testLedger/blocks/1/transactions/0/keys")
+		require.Equal(t, http.StatusOK, resp.Code)
+		var entries []*history.IndexEntry
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &entries))
+		require.Len(t, entries, 2)
+		require.Equal(t, "key1", entries[0].Key)
+		require.Equal(t, "key2", entries[1].Key)
+	})
+
+	t.Run("non-existing-channel", func(t *testing.T) {
+		requireHistoryAdminError(t, serve("/history/v1/channels/non-existing-channel/namespaces/ns/keys"),
+			http.StatusNotFound, "channel [non-existing-channel] does not exist")
+	})
+}
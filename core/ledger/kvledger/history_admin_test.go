@@ -0,0 +1,199 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package kvledger
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hyperledger/fabric/bccsp/sw"
+	"github.com/hyperledger/fabric/common/ledger/testutil"
+	"github.com/hyperledger/fabric/common/metrics/disabled"
+	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/history"
+	"github.com/hyperledger/fabric/core/ledger/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type testAdminHandlerRegistry struct {
+	handlers map[string]http.Handler
+}
+
+func (r *testAdminHandlerRegistry) RegisterAdminHandler(pattern string, handler http.Handler) {
+	r.handlers[pattern] = handler
+}
+
+// newTestHistoryAdminHandler returns a provider created with the given config and history views, and the history admin
+// handler registered by the provider
+func newTestHistoryAdminHandler(t *testing.T, conf *ledger.Config, views ...ledger.HistoryView) (*Provider, http.Handler) {
+	cryptoProvider, err := sw.NewDefaultSecurityLevelWithKeystore(sw.NewDummyKeyStore())
+	require.NoError(t, err)
+	registry := &testAdminHandlerRegistry{handlers: map[string]http.Handler{}}
+	provider, err := NewProvider(
+		&ledger.Initializer{
+			DeployedChaincodeInfoProvider:   &mock.DeployedChaincodeInfoProvider{},
+			MetricsProvider:                 &disabled.Provider{},
+			Config:                          conf,
+			HashProvider:                    cryptoProvider,
+			HealthCheckRegistry:             &mock.HealthCheckRegistry{},
+			AdminHandlerRegistry:            registry,
+			ChaincodeLifecycleEventProvider: &mock.ChaincodeLifecycleEventProvider{},
+			MembershipInfoProvider:          &mock.MembershipInfoProvider{},
+			HistoryViews:                    views,
+		},
+	)
+	require.NoError(t, err)
+	handler := registry.handlers["/history/v1/"]
+	require.NotNil(t, handler)
+	return provider, handler
+}
+
+func serveHistoryAdmin(handler http.Handler, method, target string) *httptest.ResponseRecorder {
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(method, target, nil))
+	return resp
+}
+
+func requireHistoryAdminError(t *testing.T, resp *httptest.ResponseRecorder, code int, expectedErr string) {
+	require.Equal(t, code, resp.Code)
+	errResp := &HistoryAdminErrorResponse{}
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), errResp))
+	require.Equal(t, expectedErr, errResp.Error)
+}
+
+func TestHistoryAdminHandler(t *testing.T) {
+	provider, handler := newTestHistoryAdminHandler(t, testConfig(t))
+	defer provider.Close()
+	projector := &testHistoryProjector{}
+	require.NoError(t, provider.historydbProvider.RegisterProjector(projector))
+
+	blkGenerator, genesisBlk := testutil.NewBlockGenerator(t, "testLedger", false)
+	lgr, err := provider.CreateFromGenesisBlock(genesisBlk)
+	require.NoError(t, err)
+	kvlgr := lgr.(*kvLedger)
+	blockAndPvtdata := prepareNextBlockForTest(t, kvlgr, blkGenerator, "SimulateForBlk1",
+		map[string]string{"key1": "value1.1"}, nil)
+	require.NoError(t, kvlgr.CommitLegacy(blockAndPvtdata, &ledger.CommitOptions{}))
+
+	serve := func(method, target string) *httptest.ResponseRecorder {
+		return serveHistoryAdmin(handler, method, target)
+	}
+	requireError := func(resp *httptest.ResponseRecorder, code int, expectedErr string) {
+		requireHistoryAdminError(t, resp, code, expectedErr)
+	}
+
+	t.Run("version", func(t *testing.T) {
+		resp := serve(http.MethodGet, "/history/v1/version")
+		require.Equal(t, http.StatusOK, resp.Code)
+		version := &HistoryAPIVersion{}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), version))
+		require.Equal(t, &HistoryAPIVersion{
+			APIVersion: "v1",
+			SupportedCapabilities: []string{
+				history.CapabilityDeltaEncoding, history.CapabilityUnchangedWriteSkipping, history.CapabilityValueInlining,
+			},
+			EnabledCapabilities: []string{},
+		}, version)
+	})
+
+	t.Run("list-channels", func(t *testing.T) {
+		resp := serve(http.MethodGet, "/history/v1/channels")
+		require.Equal(t, http.StatusOK, resp.Code)
+		channelList := &HistoryChannelList{}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), channelList))
+		require.Equal(t, []string{"testLedger"}, channelList.Channels)
+	})
+
+	t.Run("stats", func(t *testing.T) {
+		resp := serve(http.MethodGet, "/history/v1/channels/testLedger/stats?namespace=ns")
+		require.Equal(t, http.StatusOK, resp.Code)
+		stats := &HistoryChannelStats{}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), stats))
+		require.Equal(t, uint64(2), stats.IndexedHeight)
+		require.Equal(t, uint64(2), stats.BlockHeight)
+		require.NotNil(t, stats.DiskUsage)
+		require.Equal(t, uint64(1), stats.IndexStats.TotalIndexEntries)
+
+		requireError(serve(http.MethodGet, "/history/v1/channels/non-existing-channel/stats"),
+			http.StatusNotFound, "channel [non-existing-channel] does not exist")
+	})
+
+	t.Run("compact", func(t *testing.T) {
+		resp := serve(http.MethodPost, "/history/v1/channels/testLedger/compact?namespace=ns")
+		require.Equal(t, http.StatusAccepted, resp.Code)
+		status := &HistoryCompactionStatus{}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), status))
+		require.Equal(t, "ns", status.Namespace)
+		require.True(t, status.Running)
+
+		// the status of the compaction is reported by the stats once the compaction completes
+		kvlgr.historyCompactionWG.Wait()
+		resp = serve(http.MethodGet, "/history/v1/channels/testLedger/stats")
+		require.Equal(t, http.StatusOK, resp.Code)
+		stats := &HistoryChannelStats{}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), stats))
+		require.Equal(t, "ns", stats.Compaction.Namespace)
+		require.False(t, stats.Compaction.Running)
+		require.NotNil(t, stats.Compaction.EndTime)
+		require.Empty(t, stats.Compaction.Error)
+
+		// a compaction is not started while another compaction is running
+		kvlgr.historyCompactionLock.Lock()
+		kvlgr.historyCompaction.Running = true
+		kvlgr.historyCompactionLock.Unlock()
+		requireError(serve(http.MethodPost, "/history/v1/channels/testLedger/compact"),
+			http.StatusConflict, "a compaction of the history is in progress")
+		kvlgr.historyCompactionLock.Lock()
+		kvlgr.historyCompaction.Running = false
+		kvlgr.historyCompactionLock.Unlock()
+
+		requireError(serve(http.MethodGet, "/history/v1/channels/testLedger/compact"),
+			http.StatusMethodNotAllowed, "invalid request method: GET")
+	})
+
+	t.Run("purge-caches", func(t *testing.T) {
+		require.Equal(t, http.StatusNoContent, serve(http.MethodPost, "/history/v1/channels/testLedger/caches/purge").Code)
+	})
+
+	t.Run("projections", func(t *testing.T) {
+		require.Equal(t, []uint64{0, 1}, projector.blocks)
+		require.Equal(t, http.StatusNoContent,
+			serve(http.MethodPost, "/history/v1/channels/testLedger/projections/projector1/replay?fromBlock=1").Code)
+		require.Equal(t, []uint64{1}, projector.blocks)
+
+		resp := serve(http.MethodGet, "/history/v1/channels/testLedger/projections/projector1")
+		require.Equal(t, http.StatusOK, resp.Code)
+		status := &history.ProjectionStatus{}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), status))
+		require.Equal(t, &history.ProjectionStatus{NextBlock: 2}, status)
+
+		requireError(serve(http.MethodPost, "/history/v1/channels/testLedger/projections/projector1/replay?fromBlock=x"),
+			http.StatusBadRequest, "invalid fromBlock: x")
+		requireError(serve(http.MethodPost, "/history/v1/channels/testLedger/projections/projector2/replay"),
+			http.StatusBadRequest, "history projector [projector2] is not registered")
+	})
+
+	t.Run("rebuild", func(t *testing.T) {
+		requireError(serve(http.MethodPost, "/history/v1/channels/testLedger/rebuild?fromBlock=x"),
+			http.StatusBadRequest, "invalid fromBlock: x")
+		requireError(serve(http.MethodPost, "/history/v1/channels/testLedger/rebuild?fromBlock=2"),
+			http.StatusBadRequest, "cannot rebuild history from block [2] as the history of channel [testLedger] is indexed up to block [1]")
+		require.Equal(t, http.StatusAccepted, serve(http.MethodPost, "/history/v1/channels/testLedger/rebuild?fromBlock=1").Code)
+		requireError(serve(http.MethodPost, "/history/v1/channels/testLedger/rebuild"), http.StatusBadRequest,
+			"an upgrade or a rebuild of the history of channel [testLedger] is in progress or pending the next opening of the channel")
+	})
+
+	t.Run("closed-ledger", func(t *testing.T) {
+		lgr.Close()
+		requireError(serve(http.MethodPost, "/history/v1/channels/testLedger/caches/purge"),
+			http.StatusNotFound, "channel [testLedger] does not exist")
+		requireError(serve(http.MethodGet, "/history/v1/unknown"),
+			http.StatusNotFound, "invalid path: /history/v1/unknown")
+	})
+}
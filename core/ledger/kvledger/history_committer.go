@@ -0,0 +1,93 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package kvledger
+
+import (
+	"sync"
+
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/history"
+	"github.com/pkg/errors"
+)
+
+// asyncHistoryCommitter commits the blocks to the history database in a background goroutine, so that the block
+// commit does not wait for the history writes. The blocks are queued in the order of the commits and at most maxLag
+// blocks are queued. Once the queue is full, the block commit waits for the history commit to catch up. The history
+// queries reflect the blocks committed to the history database, i.e., the indexed height.
+type asyncHistoryCommitter struct {
+	ledgerID      string
+	historyDB     *history.DB
+	commitJournal *commitJournal
+	blocks        chan *common.Block
+	done          chan struct{}
+	// afterCommit is invoked after each block is committed to the history database
+	afterCommit func()
+
+	mutex           sync.Mutex
+	committed       *sync.Cond
+	committedHeight uint64
+}
+
+func newAsyncHistoryCommitter(ledgerID string, historyDB *history.DB, commitJournal *commitJournal,
+	maxLag int, height uint64, afterCommit func()) *asyncHistoryCommitter {
+	if maxLag < 1 {
+		maxLag = 1
+	}
+	c := &asyncHistoryCommitter{
+		ledgerID:        ledgerID,
+		historyDB:       historyDB,
+		commitJournal:   commitJournal,
+		blocks:          make(chan *common.Block, maxLag),
+		done:            make(chan struct{}),
+		afterCommit:     afterCommit,
+		committedHeight: height,
+	}
+	c.committed = sync.NewCond(&c.mutex)
+	go c.run()
+	return c
+}
+
+// commit queues the block for the history commit
+func (c *asyncHistoryCommitter) commit(block *common.Block) {
+	c.blocks <- block
+}
+
+func (c *asyncHistoryCommitter) run() {
+	defer close(c.done)
+	for block := range c.blocks {
+		blockNum := block.Header.Number
+		logger.Debugf("[%s] Committing block [%d] transactions to history database", c.ledgerID, blockNum)
+		if err := c.historyDB.Commit(block); err != nil {
+			panic(errors.WithMessage(err, "Error during commit to history db"))
+		}
+		if !c.historyDB.HasPendingWrites() {
+			if err := c.commitJournal.clearIntents(blockNum); err != nil {
+				logger.Warnw("Failed to clear the commit intents", "channel", c.ledgerID, "blockNum", blockNum, "error", err)
+			}
+		}
+		c.afterCommit()
+		c.mutex.Lock()
+		c.committedHeight = blockNum + 1
+		c.committed.Broadcast()
+		c.mutex.Unlock()
+	}
+}
+
+// waitFor waits until the blocks up to the given height are committed to the history database
+func (c *asyncHistoryCommitter) waitFor(height uint64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for c.committedHeight < height {
+		c.committed.Wait()
+	}
+}
+
+// stop waits for the queued blocks to be committed and stops the background goroutine
+func (c *asyncHistoryCommitter) stop() {
+	close(c.blocks)
+	<-c.done
+}
@@ -0,0 +1,155 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package kvledger
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hyperledger/fabric/core/ledger/kvledger/history"
+	"github.com/pkg/errors"
+)
+
+// historyHealthCheckComponent is the name of the component under which the health check of the history is registered
+const historyHealthCheckComponent = "history"
+
+// HistoryHealth captures the freshness of the history of a ledger
+type HistoryHealth struct {
+	// IndexedHeight is the height of the blocks indexed in the history and BlockHeight is the height of the block store
+	IndexedHeight uint64
+	BlockHeight   uint64
+	// SavepointPresent is false if the history has no savepoint, i.e., no block is indexed in the history
+	SavepointPresent bool
+	// Backfill is the progress of the backfill of the history, for the blocks prior to the snapshot from which the
+	// ledger is bootstrapped
+	Backfill *history.BackfillProgress
+	// Err is the error in accessing the history database or the block store, if any
+	Err error
+}
+
+// HistoryHealth returns the freshness of the history of the ledger
+func (l *kvLedger) HistoryHealth() *HistoryHealth {
+	health := &HistoryHealth{}
+	if l.historyDB == nil {
+		health.Err = errors.New("history database not enabled")
+		return health
+	}
+	bcInfo, err := l.blockStore.GetBlockchainInfo()
+	if err != nil {
+		health.Err = errors.WithMessage(err, "error while retrieving the block height")
+		return health
+	}
+	health.BlockHeight = bcInfo.Height
+	savepoint, err := l.historyDB.GetLastSavepoint()
+	if err != nil {
+		health.Err = errors.WithMessage(err, "error while reading the history savepoint")
+		return health
+	}
+	if savepoint != nil {
+		health.SavepointPresent = true
+		health.IndexedHeight = savepoint.BlockNum + 1
+	}
+	if health.Backfill, err = l.historyDB.BackfillProgress(); err != nil {
+		health.Err = errors.WithMessage(err, "error while reading the history backfill progress")
+	}
+	return health
+}
+
+// unreadyReason returns the reason for which the history is not ready, or an empty string if the history is ready
+func (h *HistoryHealth) unreadyReason(maxLag uint64) string {
+	switch {
+	case h.Err != nil:
+		return h.Err.Error()
+	case !h.SavepointPresent && h.BlockHeight > 0:
+		return "the history has no savepoint"
+	case h.BlockHeight > h.IndexedHeight+maxLag:
+		return fmt.Sprintf("the history is at height [%d], lagging behind the block height [%d] by more than [%d] blocks",
+			h.IndexedHeight, h.BlockHeight, maxLag)
+	case h.Backfill != nil && h.Backfill.TotalEntries > 0 && !h.Backfill.Done:
+		return fmt.Sprintf("the history is being backfilled, [%d] of [%d] entries are processed",
+			h.Backfill.EntriesProcessed, h.Backfill.TotalEntries)
+	}
+	return ""
+}
+
+// historyLedgers tracks the open ledgers for the health check and the admin API of the history
+type historyLedgers struct {
+	mutex   sync.Mutex
+	ledgers map[string]*kvLedger
+}
+
+func newHistoryLedgers() *historyLedgers {
+	return &historyLedgers{
+		ledgers: map[string]*kvLedger{},
+	}
+}
+
+func (h *historyLedgers) add(l *kvLedger) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.ledgers[l.ledgerID] = l
+}
+
+func (h *historyLedgers) remove(ledgerID string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	delete(h.ledgers, ledgerID)
+}
+
+// get returns the open ledger with the given ID, or nil if the ledger is not open
+func (h *historyLedgers) get(ledgerID string) *kvLedger {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.ledgers[ledgerID]
+}
+
+// list returns the open ledgers, sorted by their IDs
+func (h *historyLedgers) list() []*kvLedger {
+	h.mutex.Lock()
+	ledgers := make([]*kvLedger, 0, len(h.ledgers))
+	for _, l := range h.ledgers {
+		ledgers = append(ledgers, l)
+	}
+	h.mutex.Unlock()
+	sort.Slice(ledgers, func(i, j int) bool { return ledgers[i].ledgerID < ledgers[j].ledgerID })
+	return ledgers
+}
+
+// historyHealthChecker checks the freshness of the history of the open ledgers. It is registered with the health
+// check registry of the peer, whose operations endpoint serves the health checks over HTTP at /healthz
+type historyHealthChecker struct {
+	maxLag  uint64
+	ledgers *historyLedgers
+}
+
+func newHistoryHealthChecker(maxLag uint64, ledgers *historyLedgers) *historyHealthChecker {
+	return &historyHealthChecker{
+		maxLag:  maxLag,
+		ledgers: ledgers,
+	}
+}
+
+// HealthCheck returns an error that lists the channels whose history is not ready, i.e., cannot be accessed, lags
+// behind the block store by more than maxLag blocks, or is being backfilled
+func (c *historyHealthChecker) HealthCheck(ctx context.Context) error {
+	var unready []string
+	for _, l := range c.ledgers.list() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if reason := l.HistoryHealth().unreadyReason(c.maxLag); reason != "" {
+			unready = append(unready, fmt.Sprintf("channel [%s]: %s", l.ledgerID, reason))
+		}
+	}
+	if len(unready) > 0 {
+		return errors.Errorf("history is not ready for %s", strings.Join(unready, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package kvledger
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/ledger/testutil"
+	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/history"
+	"github.com/hyperledger/fabric/core/ledger/mock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistoryHealthCheck(t *testing.T) {
+	conf := testConfig(t)
+	conf.HistoryDBConfig.HealthCheckMaxLag = 1
+	provider := testutilNewProvider(conf, t, &mock.DeployedChaincodeInfoProvider{})
+	defer provider.Close()
+	registry := provider.initializer.HealthCheckRegistry.(*mock.HealthCheckRegistry)
+	var checker *historyHealthChecker
+	for i := 0; i < registry.RegisterCheckerCallCount(); i++ {
+		if component, c := registry.RegisterCheckerArgsForCall(i); component == "history" {
+			checker = c.(*historyHealthChecker)
+		}
+	}
+	require.NotNil(t, checker)
+
+	blkGenerator, genesisBlk := testutil.NewBlockGenerator(t, "testLedger", false)
+	lgr, err := provider.CreateFromGenesisBlock(genesisBlk)
+	require.NoError(t, err)
+	kvlgr := lgr.(*kvLedger)
+	blockAndPvtdata := prepareNextBlockForTest(t, kvlgr, blkGenerator, "SimulateForBlk1",
+		map[string]string{"key1": "value1.1"}, nil)
+	require.NoError(t, kvlgr.CommitLegacy(blockAndPvtdata, &ledger.CommitOptions{}))
+	require.NoError(t, checker.HealthCheck(context.Background()))
+	require.Equal(t, &HistoryHealth{IndexedHeight: 2, BlockHeight: 2, SavepointPresent: true, Backfill: &history.BackfillProgress{}},
+		kvlgr.HistoryHealth())
+
+	// the blocks added to the block store, but not to the history, make the history lag behind
+	for _, block := range blkGenerator.NextTestBlocks(2) {
+		require.NoError(t, kvlgr.blockStore.AddBlock(block))
+	}
+	require.EqualError(t, checker.HealthCheck(context.Background()),
+		"history is not ready for channel [testLedger]: the history is at height [2], lagging behind the block height [4] by more than [1] blocks")
+
+	// the closed ledgers are not checked
+	lgr.Close()
+	require.NoError(t, checker.HealthCheck(context.Background()))
+}
+
+func TestHistoryHealthUnreadyReason(t *testing.T) {
+	for _, tc := range []struct {
+		health   *HistoryHealth
+		expected string
+	}{
+		{&HistoryHealth{IndexedHeight: 5, BlockHeight: 6, SavepointPresent: true}, ""},
+		{&HistoryHealth{}, ""},
+		{&HistoryHealth{Err: errors.New("leveldb: closed")}, "leveldb: closed"},
+		{&HistoryHealth{BlockHeight: 1}, "the history has no savepoint"},
+		{
+			&HistoryHealth{IndexedHeight: 6, BlockHeight: 6, SavepointPresent: true, Backfill: &history.BackfillProgress{EntriesProcessed: 3, TotalEntries: 10}},
+			"the history is being backfilled, [3] of [10] entries are processed",
+		},
+		{
+			&HistoryHealth{IndexedHeight: 6, BlockHeight: 6, SavepointPresent: true, Backfill: &history.BackfillProgress{EntriesProcessed: 10, TotalEntries: 10, Done: true}},
+			"",
+		},
+	} {
+		require.Equal(t, tc.expected, tc.health.unreadyReason(1))
+	}
+}
@@ -0,0 +1,100 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package kvledger
+
+import (
+	"os"
+
+	"github.com/hyperledger/fabric/common/ledger/blkstorage"
+	"github.com/hyperledger/fabric/common/metrics/disabled"
+	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/history"
+	"github.com/hyperledger/fabric/internal/fileutil"
+)
+
+// loadHistorySavepoints returns, for each of the given ledgers that has a history, the last block committed to the
+// history, i.e., the last block from which the history commit listeners may have derived data. A rollback or a reset
+// of the ledgers drops the history and hence, the savepoints are to be loaded before the history is dropped.
+func loadHistorySavepoints(rootFSPath string, ledgerIDs []string) (map[string]uint64, error) {
+	historyDBPath := HistoryDBPath(rootFSPath)
+	exists, err := fileutil.DirExists(historyDBPath)
+	if err != nil || !exists {
+		return nil, err
+	}
+	historydbProvider, err := history.NewDBProvider(historyDBPath)
+	if err != nil {
+		return nil, err
+	}
+	defer historydbProvider.Close()
+	savepoints := map[string]uint64{}
+	for _, ledgerID := range ledgerIDs {
+		savepoint, err := historydbProvider.GetDBHandle(ledgerID).GetLastSavepoint()
+		if err != nil {
+			return nil, err
+		}
+		if savepoint != nil {
+			savepoints[ledgerID] = savepoint.BlockNum
+		}
+	}
+	return savepoints, nil
+}
+
+// recordHistoryInvalidations records the invalidations of the history, which are delivered to the history commit
+// listeners when the ledgers are opened. As the history DB is dropped, the invalidations are recorded in a separate
+// DB, from which these are imported to the history DB when the peer starts (see function `importHistoryInvalidations`).
+func recordHistoryInvalidations(rootFSPath string, invalidations []*ledger.HistoryInvalidation) error {
+	if len(invalidations) == 0 {
+		return nil
+	}
+	historydbProvider, err := history.NewDBProvider(HistoryInvalidationsDBPath(rootFSPath))
+	if err != nil {
+		return err
+	}
+	defer historydbProvider.Close()
+	for _, invalidation := range invalidations {
+		if err := historydbProvider.RecordInvalidation(invalidation); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// importHistoryInvalidations moves the invalidations recorded by a rollback or a reset of the ledgers to the history DB
+func importHistoryInvalidations(rootFSPath string, historydbProvider *history.DBProvider) error {
+	invalidationsDBPath := HistoryInvalidationsDBPath(rootFSPath)
+	exists, err := fileutil.DirExists(invalidationsDBPath)
+	if err != nil || !exists {
+		return err
+	}
+	invalidationsProvider, err := history.NewDBProvider(invalidationsDBPath)
+	if err != nil {
+		return err
+	}
+	err = historydbProvider.ImportInvalidations(invalidationsProvider)
+	invalidationsProvider.Close()
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(invalidationsDBPath)
+}
+
+// listLedgerIDs returns the ids of the ledgers in the block store
+func listLedgerIDs(rootFSPath string) ([]string, error) {
+	blkStoreProvider, err := blkstorage.NewProvider(
+		blkstorage.NewConf(
+			BlockStorePath(rootFSPath),
+			maxBlockFileSize,
+		),
+		&blkstorage.IndexConfig{AttrsToIndex: attrsToIndex},
+		&disabled.Provider{},
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer blkStoreProvider.Close()
+	return blkStoreProvider.List()
+}
@@ -0,0 +1,79 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package kvledger
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/ledger/testutil"
+	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/hyperledger/fabric/core/ledger/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type testInvalidationListener struct {
+	invalidations []*ledger.HistoryInvalidation
+}
+
+func (l *testInvalidationListener) Name() string {
+	return "invalidation-listener"
+}
+
+func (l *testInvalidationListener) HandleHistoryCommit(commit *ledger.HistoryCommit) error {
+	return nil
+}
+
+func (l *testInvalidationListener) HandleHistoryInvalidation(invalidation *ledger.HistoryInvalidation) error {
+	l.invalidations = append(l.invalidations, invalidation)
+	return nil
+}
+
+func TestHistoryInvalidations(t *testing.T) {
+	conf := testConfig(t)
+	provider := testutilNewProvider(conf, t, &mock.DeployedChaincodeInfoProvider{})
+	blkGenerator, genesisBlk := testutil.NewBlockGenerator(t, "testLedgerid", false)
+	lgr, err := provider.CreateFromGenesisBlock(genesisBlk)
+	require.NoError(t, err)
+	kvlgr := lgr.(*kvLedger)
+	for i := 1; i <= 4; i++ {
+		blockAndPvtdata := prepareNextBlockForTest(t, kvlgr, blkGenerator, fmt.Sprintf("SimulateForBlk%d", i),
+			map[string]string{"key1": fmt.Sprintf("value1.%d", i)}, nil)
+		require.NoError(t, kvlgr.CommitLegacy(blockAndPvtdata, &ledger.CommitOptions{}))
+	}
+	lgr.Close()
+	provider.Close()
+
+	// the invalidations are delivered when the ledger is opened
+	openLedger := func() *testInvalidationListener {
+		provider := testutilNewProvider(conf, t, &mock.DeployedChaincodeInfoProvider{})
+		defer provider.Close()
+		listener := &testInvalidationListener{}
+		require.NoError(t, provider.historydbProvider.RegisterCommitListener(listener))
+		lgr, err := provider.Open("testLedgerid")
+		require.NoError(t, err)
+		lgr.Close()
+		return listener
+	}
+
+	require.NoError(t, RollbackHistory(conf, "testLedgerid", 3))
+	require.Equal(t, []*ledger.HistoryInvalidation{
+		{LedgerID: "testLedgerid", FromBlock: 4, ToBlock: 4, Reason: ledger.HistoryInvalidationReasonHistoryRollback},
+	}, openLedger().invalidations)
+	// the delivered invalidations are not delivered again
+	require.Empty(t, openLedger().invalidations)
+
+	require.NoError(t, RollbackKVLedger(conf.RootFSPath, "testLedgerid", 2))
+	require.Equal(t, []*ledger.HistoryInvalidation{
+		{LedgerID: "testLedgerid", FromBlock: 3, ToBlock: 4, Reason: ledger.HistoryInvalidationReasonLedgerRollback},
+	}, openLedger().invalidations)
+
+	require.NoError(t, ResetAllKVLedgers(conf.RootFSPath))
+	require.Equal(t, []*ledger.HistoryInvalidation{
+		{LedgerID: "testLedgerid", FromBlock: 1, ToBlock: 2, Reason: ledger.HistoryInvalidationReasonLedgerReset},
+	}, openLedger().invalidations)
+}
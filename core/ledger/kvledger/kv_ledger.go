@@ -7,9 +7,11 @@ SPDX-License-Identifier: Apache-2.0
 package kvledger
 
 import (
+	"context"
 	"encoding/hex"
 	"fmt"
 	"math"
+	"path/filepath"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -56,6 +58,7 @@ type kvLedger struct {
 	pvtdataStore     *pvtdatastorage.Store
 
 	txmgr                  *txmgr.LockBasedTxMgr
+	stateDB                *privacyenabledstate.DB
 	historyDB              *history.DB
 	configHistoryRetriever *collectionConfigHistoryRetriever
 	snapshotMgr            *snapshotMgr
@@ -72,6 +75,37 @@ type kvLedger struct {
 
 	commitNotifierLock sync.Mutex
 	commitNotifier     *commitNotifier
+
+	historyBackfillStop chan struct{}
+	historyBackfillWG   sync.WaitGroup
+
+	txCacheWarmUpStop chan struct{}
+	txCacheWarmUpWG   sync.WaitGroup
+
+	historySizeSamplingStop chan struct{}
+	historySizeSamplingWG   sync.WaitGroup
+
+	historyFormatUpgradeStop chan struct{}
+	historyFormatUpgradeWG   sync.WaitGroup
+
+	// historyRebuildStop is closed, under the historyRebuildLock, when the ledger is closed
+	historyRebuildLock sync.Mutex
+	historyRebuildStop chan struct{}
+	historyRebuildWG   sync.WaitGroup
+
+	// historyCompaction is the status of the last compaction of the history started via the admin API. A compaction
+	// cannot be stopped and hence, is waited for when the ledger is closed
+	historyCompactionLock   sync.Mutex
+	historyCompaction       *HistoryCompactionStatus
+	historyCompactionClosed bool
+	historyCompactionWG     sync.WaitGroup
+
+	commitJournal *commitJournal
+	// historyLedgers, if the history database is enabled, tracks the ledger while the ledger is open, for the health
+	// check and the admin API of the history
+	historyLedgers *historyLedgers
+	// historyCommitter is set when the history database is committed asynchronously to the block commits
+	historyCommitter *asyncHistoryCommitter
 }
 
 type lgrInitializer struct {
@@ -82,6 +116,7 @@ type lgrInitializer struct {
 	pvtdataStore             *pvtdatastorage.Store
 	stateDB                  *privacyenabledstate.DB
 	historyDB                *history.DB
+	historyLedgers           *historyLedgers
 	configHistoryMgr         *confighistory.Mgr
 	stateListeners           []ledger.StateListener
 	bookkeeperProvider       *bookkeeping.Provider
@@ -101,12 +136,18 @@ func newKVLedger(initializer *lgrInitializer) (*kvLedger, error) {
 		bootSnapshotMetadata: initializer.bootSnapshotMetadata,
 		blockStore:           initializer.blockStore,
 		pvtdataStore:         initializer.pvtdataStore,
+		stateDB:              initializer.stateDB,
 		historyDB:            initializer.historyDB,
 		hashProvider:         initializer.hashProvider,
 		config:               initializer.config,
 		blockAPIsRWLock:      &sync.RWMutex{},
 	}
 
+	l.commitJournal = newCommitJournal(
+		ledgerID,
+		initializer.bookkeeperProvider.GetDBHandle(ledgerID, bookkeeping.CommitJournal),
+	)
+
 	btlPolicy := pvtdatapolicy.ConstructBTLPolicy(&collectionInfoRetriever{ledgerID, l, initializer.ccInfoProvider})
 
 	rwsetHashFunc := func(data []byte) ([]byte, error) {
@@ -165,10 +206,34 @@ func newKVLedger(initializer *lgrInitializer) (*kvLedger, error) {
 		}
 	}
 
+	// the history db applies the indexing manifests of the chaincodes, which are held in memory and hence,
+	// requires the existing invocable chaincodes each time the ledger is opened
+	if l.historyDB != nil && initializer.ccLifecycleEventProvider != nil {
+		logger.Debugf("Register history db for chaincode lifecycle events")
+		if err := initializer.ccLifecycleEventProvider.RegisterListener(ledgerID, l.historyDB, true); err != nil {
+			return nil, errors.WithMessage(err, "error while registering history db for chaincode lifecycle events")
+		}
+	}
+
 	// Recover both state DB and history DB if they are out of sync with block storage
 	if err := l.recoverDBs(); err != nil {
 		return nil, err
 	}
+	if l.historyDB != nil && l.config.HistoryDBConfig.AsyncCommit {
+		bcInfo, err := l.blockStore.GetBlockchainInfo()
+		if err != nil {
+			return nil, err
+		}
+		l.historyCommitter = newAsyncHistoryCommitter(
+			ledgerID, l.historyDB, l.commitJournal, l.config.HistoryDBConfig.AsyncCommitMaxLag, bcInfo.Height,
+			func() {
+				// the blockchain info of the block store is held in memory
+				if bcInfo, err := l.blockStore.GetBlockchainInfo(); err == nil {
+					l.updateHistoryIndexLag(bcInfo.Height)
+				}
+			},
+		)
+	}
 	l.configHistoryRetriever = &collectionConfigHistoryRetriever{
 		Retriever:                     initializer.configHistoryMgr.GetRetriever(ledgerID),
 		DeployedChaincodeInfoProvider: txmgrInitializer.CCInfoProvider,
@@ -180,9 +245,156 @@ func newKVLedger(initializer *lgrInitializer) (*kvLedger, error) {
 	}
 
 	l.stats = initializer.stats
+	bcInfo, err := l.blockStore.GetBlockchainInfo()
+	if err != nil {
+		return nil, err
+	}
+	l.updateHistoryIndexLag(bcInfo.Height)
+	if err := l.startHistoryBackfill(); err != nil {
+		return nil, err
+	}
+	l.startTxCacheWarmUp()
+	l.startHistorySizeSampling()
+	l.startHistoryFormatUpgrade()
+	if l.historyDB != nil {
+		l.historyRebuildStop = make(chan struct{})
+	}
+	if initializer.historyLedgers != nil {
+		l.historyLedgers = initializer.historyLedgers
+		l.historyLedgers.add(l)
+	}
 	return l, nil
 }
 
+// startHistoryFormatUpgrade starts upgrading, in the background, the history to the configured index format, if
+// required, and dropping the history in the previous format after an upgrade. The upgrade is deferred to the next
+// start of the ledger while the history backfill is in progress, as the backfill adds to the history being upgraded
+func (l *kvLedger) startHistoryFormatUpgrade() {
+	if l.historyDB == nil || l.historyBackfillStop != nil {
+		return
+	}
+	l.historyFormatUpgradeStop = make(chan struct{})
+	l.historyFormatUpgradeWG.Add(1)
+	go func() {
+		defer l.historyFormatUpgradeWG.Done()
+		err := l.historyDB.UpgradeFormat(l.blockStore, l.historyFormatUpgradeStop)
+		switch {
+		case err == history.ErrFormatUpgradeStopped:
+			logger.Infow("History index format upgrade stopped, will start over on restart", "channel", l.ledgerID)
+		case err != nil:
+			logger.Errorw("Error while upgrading the history index format", "channel", l.ledgerID, "error", err)
+		}
+	}()
+}
+
+// StartHistoryRebuild starts rebuilding, in the background, the history of the ledger from the block fromBlock while
+// the blocks continue to be committed, as opposed to function `RebuildHistory`, which is invoked while the peer is shut
+// down. The rebuilt history is served from the next start of the ledger (see function `history.DB.PrepareRebuild`). An
+// error is returned if the rebuild cannot be started, for instance, while the history backfill is in progress
+func (l *kvLedger) StartHistoryRebuild(fromBlock uint64) error {
+	if l.historyDB == nil {
+		return errors.New("history database not enabled")
+	}
+	l.historyRebuildLock.Lock()
+	defer l.historyRebuildLock.Unlock()
+	if l.historyRebuildStop == nil {
+		return errors.Errorf("ledger [%s] is closed", l.ledgerID)
+	}
+	if l.historyBackfillStop != nil {
+		progress, err := l.historyDB.BackfillProgress()
+		if err != nil {
+			return err
+		}
+		if !progress.Done {
+			return errors.New("the history cannot be rebuilt while the history backfill is in progress")
+		}
+	}
+	rebuild, err := l.historyDB.PrepareRebuild(fromBlock, l.blockStore)
+	if err != nil {
+		return err
+	}
+	stop := l.historyRebuildStop
+	l.historyRebuildWG.Add(1)
+	go func() {
+		defer l.historyRebuildWG.Done()
+		err := rebuild.Run(stop)
+		switch {
+		case err == history.ErrRebuildStopped:
+			logger.Infow("History rebuild stopped, the rebuild is to be requested again", "channel", l.ledgerID)
+		case err != nil:
+			logger.Errorw("Error while rebuilding history", "channel", l.ledgerID, "fromBlock", fromBlock, "error", err)
+		}
+	}()
+	return nil
+}
+
+// startHistorySizeSampling starts sampling, in the background, the on-disk size of the history index
+// per namespace, if configured
+func (l *kvLedger) startHistorySizeSampling() {
+	interval := l.config.HistoryDBConfig.SizeSamplingInterval
+	if l.historyDB == nil || interval <= 0 {
+		return
+	}
+	l.historySizeSamplingStop = make(chan struct{})
+	l.historySizeSamplingWG.Add(1)
+	go func() {
+		defer l.historySizeSamplingWG.Done()
+		l.historyDB.RunIndexSizeSampling(interval, l.historySizeSamplingStop)
+	}()
+}
+
+// startTxCacheWarmUp starts loading, in the background, the transactions of the most recent blocks into
+// the decoded transaction cache of the history database, if configured
+func (l *kvLedger) startTxCacheWarmUp() {
+	numBlocks := l.config.HistoryDBConfig.DecodedTxCacheWarmUpBlocks
+	if l.historyDB == nil || numBlocks <= 0 {
+		return
+	}
+	l.txCacheWarmUpStop = make(chan struct{})
+	l.txCacheWarmUpWG.Add(1)
+	go func() {
+		defer l.txCacheWarmUpWG.Done()
+		err := l.historyDB.WarmUpTxCache(l.blockStore, uint64(numBlocks), l.txCacheWarmUpStop)
+		switch {
+		case err == history.ErrTxCacheWarmUpStopped:
+			logger.Debugw("Decoded transaction cache warmup stopped", "channel", l.ledgerID)
+		case err != nil:
+			logger.Warnw("Error while warming up the decoded transaction cache", "channel", l.ledgerID, "error", err)
+		}
+	}()
+}
+
+// startHistoryBackfill starts backfilling, in the background, the history for the blocks prior
+// to the snapshot from which the ledger was bootstrapped, if an archive is available for this ledger
+func (l *kvLedger) startHistoryBackfill() error {
+	if l.historyDB == nil || l.bootSnapshotMetadata == nil || l.config.HistoryDBConfig.BackfillArchiveDir == "" {
+		return nil
+	}
+	archiveDir := filepath.Join(l.config.HistoryDBConfig.BackfillArchiveDir, l.ledgerID)
+	exists, err := history.HasBackfillArchive(archiveDir)
+	if err != nil || !exists {
+		return err
+	}
+	progress, err := l.historyDB.BackfillProgress()
+	if err != nil || progress.Done {
+		return err
+	}
+
+	l.historyBackfillStop = make(chan struct{})
+	l.historyBackfillWG.Add(1)
+	go func() {
+		defer l.historyBackfillWG.Done()
+		err := l.historyDB.Backfill(archiveDir, l.bootSnapshotMetadata.LastBlockNumber, l.historyBackfillStop)
+		switch {
+		case err == history.ErrBackfillStopped:
+			logger.Infow("History backfill stopped, will resume on restart", "channel", l.ledgerID)
+		case err != nil:
+			logger.Errorw("Error while backfilling history", "channel", l.ledgerID, "archiveDir", archiveDir, "error", err)
+		}
+	}()
+	return nil
+}
+
 func (l *kvLedger) registerStateDBIndexCreatorForChaincodeLifecycleEvents(
 	stateDBIndexCreator cceventmgmt.ChaincodeLifecycleEventListener,
 	deployedChaincodesInfoExtractor ledger.DeployedChaincodeInfoProvider,
@@ -356,9 +568,24 @@ func (l *kvLedger) isPvtDataStoreAheadOfBlockStore() (bool, error) {
 
 func (l *kvLedger) recoverDBs() error {
 	logger.Debugf("Entering recoverDB()")
+	if l.historyDB != nil {
+		// the invalidations are delivered before the invalidated blocks are committed again to the history
+		if err := l.historyDB.NotifyInvalidations(); err != nil {
+			return err
+		}
+	}
+	if err := l.repairFromCommitJournal(); err != nil {
+		return err
+	}
 	if err := l.syncStateAndHistoryDBWithBlockstore(); err != nil {
 		return err
 	}
+	if l.historyDB != nil {
+		// the recommitted blocks may be held back by the group commit
+		if err := l.historyDB.Flush(); err != nil {
+			return err
+		}
+	}
 	return l.syncStateDBWithOldBlkPvtdata()
 }
 
@@ -590,6 +817,23 @@ func (l *kvLedger) NewHistoryQueryExecutor() (ledger.HistoryQueryExecutor, error
 	return nil, nil
 }
 
+// NewHistoryQueryExecutorForClient implements method in interface `ledger.ClientHistoryQueryExecutorProvider`.
+// The queries are scheduled with the priority carried by the context, as set via history.WithQueryPriority or the
+// incoming gRPC metadata, and are accounted to the quotas of the client with the given serialized identity
+func (l *kvLedger) NewHistoryQueryExecutorForClient(ctx context.Context, creator []byte) (ledger.HistoryQueryExecutor, error) {
+	if l.historyDB == nil {
+		return nil, nil
+	}
+	if len(creator) > 0 {
+		ctx = history.WithQueryClient(ctx, history.QueryClientForIdentity(creator))
+	}
+	qe, err := l.historyDB.NewQueryExecutorForContext(ctx, l.blockStore)
+	if err != nil {
+		return nil, err
+	}
+	return qe, nil
+}
+
 // CommitLegacy commits the block and the corresponding pvt data in an atomic operation.
 // It synchronizes commit, snapshot generation and snapshot requests via events and commitProceed channels.
 // Before committing a block, it sends a commitStart event and waits for a message from commitProceed.
@@ -687,6 +931,11 @@ func (l *kvLedger) commit(pvtdataAndBlock *ledger.BlockAndPvtData, commitOpts *l
 		}] = u.Version
 	}
 
+	if l.historyDB != nil {
+		if err := l.commitJournal.recordIntent(blockNo); err != nil {
+			return err
+		}
+	}
 	if err = l.commitToPvtAndBlockStore(pvtdataAndBlock, purgeMarkers); err != nil {
 		return err
 	}
@@ -702,11 +951,21 @@ func (l *kvLedger) commit(pvtdataAndBlock *ledger.BlockAndPvtData, commitOpts *l
 
 	// History database could be written in parallel with state and/or async as a future optimization,
 	// although it has not been a bottleneck...no need to clutter the log with elapsed duration.
-	if l.historyDB != nil {
+	switch {
+	case l.historyCommitter != nil:
+		logger.Debugf("[%s] Queueing block [%d] for the asynchronous commit to history database", l.ledgerID, blockNo)
+		l.historyCommitter.commit(block)
+	case l.historyDB != nil:
 		logger.Debugf("[%s] Committing block [%d] transactions to history database", l.ledgerID, blockNo)
 		if err := l.historyDB.Commit(block); err != nil {
 			panic(errors.WithMessage(err, "Error during commit to history db"))
 		}
+		// with the group commit, the intents are retained until the history writes of the pending blocks are flushed
+		if !l.historyDB.HasPendingWrites() {
+			if err := l.commitJournal.clearIntents(blockNo); err != nil {
+				logger.Warnw("Failed to clear the commit intents", "channel", l.ledgerID, "blockNum", blockNo, "error", err)
+			}
+		}
 	}
 
 	logger.Infof("[%s] Committed block [%d] with %d transaction(s) in %dms (state_validation=%dms block_and_pvtdata_commit=%dms state_commit=%dms)"+
@@ -719,6 +978,7 @@ func (l *kvLedger) commit(pvtdataAndBlock *ledger.BlockAndPvtData, commitOpts *l
 		l.commitHash,
 	)
 
+	l.updateHistoryIndexLag(blockNo + 1)
 	l.updateBlockStats(
 		elapsedBlockProcessing,
 		elapsedBlockstorageAndPvtdataCommit,
@@ -803,6 +1063,21 @@ func (l *kvLedger) updateBlockStats(
 	l.stats.updateTransactionsStats(txstatsInfo)
 }
 
+// updateHistoryIndexLag updates the number of the blocks committed to the block store, up to the given height, and
+// not yet written to the history database, which is expected to be non-zero only with the asynchronous history commit
+// or the group commit. The height applied to the history database is tracked in memory, so that the lag is updated on
+// every block commit without a read of the history database
+func (l *kvLedger) updateHistoryIndexLag(blockHeight uint64) {
+	if l.historyDB == nil || l.stats == nil {
+		return
+	}
+	var lag uint64
+	if appliedHeight := l.historyDB.AppliedHeight(); blockHeight > appliedHeight {
+		lag = blockHeight - appliedHeight
+	}
+	l.stats.updateHistoryIndexLag(lag)
+}
+
 func (l *kvLedger) addBlockCommitHash(block *common.Block, updateBatchBytes []byte) {
 	var valueBytes []byte
 
@@ -1009,6 +1284,45 @@ func (l *kvLedger) sendCommitNotification(blockNum uint64, txStatsInfo []*valida
 // or snapshot generation before calling this function. Otherwise, the ledger may have unknown behavior
 // and cause panic.
 func (l *kvLedger) Close() {
+	if l.historyLedgers != nil {
+		l.historyLedgers.remove(l.ledgerID)
+	}
+	if l.historyBackfillStop != nil {
+		close(l.historyBackfillStop)
+		l.historyBackfillWG.Wait()
+	}
+	if l.txCacheWarmUpStop != nil {
+		close(l.txCacheWarmUpStop)
+		l.txCacheWarmUpWG.Wait()
+	}
+	if l.historySizeSamplingStop != nil {
+		close(l.historySizeSamplingStop)
+		l.historySizeSamplingWG.Wait()
+	}
+	if l.historyFormatUpgradeStop != nil {
+		close(l.historyFormatUpgradeStop)
+		l.historyFormatUpgradeWG.Wait()
+		l.historyFormatUpgradeStop = nil
+	}
+	l.historyRebuildLock.Lock()
+	if l.historyRebuildStop != nil {
+		close(l.historyRebuildStop)
+		l.historyRebuildStop = nil
+	}
+	l.historyRebuildLock.Unlock()
+	l.historyRebuildWG.Wait()
+	l.historyCompactionLock.Lock()
+	l.historyCompactionClosed = true
+	l.historyCompactionLock.Unlock()
+	l.historyCompactionWG.Wait()
+	if l.historyCommitter != nil {
+		l.historyCommitter.stop()
+	}
+	if l.historyDB != nil {
+		if err := l.historyDB.Flush(); err != nil {
+			logger.Warnw("Failed to flush the pending history writes", "channel", l.ledgerID, "error", err)
+		}
+	}
 	l.blockStore.Shutdown()
 	l.txmgr.Shutdown()
 	l.snapshotMgr.shutdown()
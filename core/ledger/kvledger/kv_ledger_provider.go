@@ -60,6 +60,7 @@ type Provider struct {
 	pvtdataStoreProvider *pvtdatastorage.Provider
 	dbProvider           *privacyenabledstate.DBProvider
 	historydbProvider    *history.DBProvider
+	historyLedgers       *historyLedgers
 	configHistoryMgr     *confighistory.Mgr
 	stateListeners       []ledger.StateListener
 	bookkeepingProvider  *bookkeeping.Provider
@@ -174,7 +175,7 @@ func (p *Provider) initPvtDataStoreProvider() error {
 	return nil
 }
 
-func (p *Provider) initHistoryDBProvider() error {
+func (p *Provider) initHistoryDBProvider() (err error) {
 	if !p.initializer.Config.HistoryDBConfig.Enabled {
 		return nil
 	}
@@ -185,10 +186,168 @@ func (p *Provider) initHistoryDBProvider() error {
 	if err != nil {
 		return err
 	}
+	defer func() {
+		if err != nil {
+			historydbProvider.Close()
+		}
+	}()
+	// the configuration is applied, and hence validated, before the invalidations are imported and the migration
+	// target is opened, as these modify the files of the peer
+	if err := p.configureHistoryDBProvider(historydbProvider); err != nil {
+		return err
+	}
+	if err := importHistoryInvalidations(p.initializer.Config.RootFSPath, historydbProvider); err != nil {
+		return err
+	}
+	if p.initializer.Config.HistoryDBConfig.MigrationTargetDir != "" {
+		if err := p.enableHistoryMigration(historydbProvider); err != nil {
+			return err
+		}
+	}
+	historyLedgers := newHistoryLedgers()
+	if maxLag := p.initializer.Config.HistoryDBConfig.HealthCheckMaxLag; maxLag > 0 {
+		historyHealthChecker := newHistoryHealthChecker(uint64(maxLag), historyLedgers)
+		if err := p.initializer.HealthCheckRegistry.RegisterChecker(historyHealthCheckComponent, historyHealthChecker); err != nil {
+			return errors.WithMessage(err, "error while registering the health check of the history")
+		}
+	}
+	if p.initializer.AdminHandlerRegistry != nil {
+		p.initializer.AdminHandlerRegistry.RegisterAdminHandler(historyAdminURLBaseV1, newHistoryAdminHandler(historyLedgers, historydbProvider))
+	}
+	p.historyLedgers = historyLedgers
 	p.historydbProvider = historydbProvider
 	return nil
 }
 
+// configureHistoryDBProvider registers the views, the listeners and the projectors of the initializer with the
+// history database and applies the history configuration, which fails for an invalid configuration
+func (p *Provider) configureHistoryDBProvider(provider *history.DBProvider) error {
+	config := p.initializer.Config.HistoryDBConfig
+	for _, view := range p.initializer.HistoryViews {
+		if err := provider.RegisterView(view); err != nil {
+			return err
+		}
+	}
+	for _, listener := range p.initializer.HistoryCommitListeners {
+		if err := provider.RegisterCommitListener(listener); err != nil {
+			return err
+		}
+	}
+	for _, projector := range p.initializer.HistoryProjectors {
+		if err := provider.RegisterProjector(projector); err != nil {
+			return err
+		}
+	}
+	if err := provider.EnableFieldIndexes(config.FieldIndexes); err != nil {
+		return err
+	}
+	if err := provider.EnableFullTextSearch(config.FullTextSearchNamespaces); err != nil {
+		return err
+	}
+	provider.EnableMetrics(p.initializer.MetricsProvider)
+	provider.EnableDecodedTxCache(config.DecodedTxCacheSize, config.DecodedTxCacheMaxBytes)
+	provider.EnableQueryPrefetch(config.QueryPrefetchDepth)
+	provider.EnableNoHistoryCache(config.NoHistoryCacheSize)
+	provider.EnableQueryResultCache(config.QueryResultCacheSize, config.QueryResultCacheMaxEntries)
+	provider.EnableSlowQueryLog(config.SlowQueryThreshold, config.SlowQueryMinResults)
+	provider.EnableParallelDecoding(config.DecodeWorkers)
+	if err := provider.EnableQueryScheduling(config.MaxBatchRetrievals, config.BatchQueryMaxYield); err != nil {
+		return err
+	}
+	provider.EnableMaxOpenScanners(config.MaxOpenScanners)
+	provider.EnableScannerLeakDetection(config.ScannerIdleTimeout)
+	if err := provider.EnableQueryQuotas(config.QueryQuotaWindow, config.QueryQuotaMaxEntries, config.QueryQuotaMaxBytes); err != nil {
+		return err
+	}
+	if err := provider.EnableResponseCaps(config.MaxResponseEntries, config.MaxResponseBytes); err != nil {
+		return err
+	}
+	if err := provider.DisableIndexing(config.DisabledNamespaces); err != nil {
+		return err
+	}
+	if err := provider.EnableKeyNormalization(config.NormalizedKeyNamespaces); err != nil {
+		return err
+	}
+	if err := provider.EnableCapabilities(config.Capabilities); err != nil {
+		return err
+	}
+	if err := provider.EnableUnchangedWriteSkipping(config.SkipUnchangedWriteNamespaces); err != nil {
+		return err
+	}
+	if err := provider.EnableDeltaEncoding(config.DeltaEncodingNamespaces, config.DeltaEncodingCheckpointInterval); err != nil {
+		return err
+	}
+	if err := provider.EnableValueSizeTracking(config.ValueSizeTrackingNamespaces); err != nil {
+		return err
+	}
+	if config.TransactionIndex {
+		provider.EnableTransactionIndex()
+	}
+	if err := provider.EnableScheduledCompaction(config.CompactionWindowStart, config.CompactionWindowDuration); err != nil {
+		return err
+	}
+	if err := provider.SetKeyIndexingPolicies(config.IncludeKeys, config.ExcludeKeys); err != nil {
+		return err
+	}
+	return provider.EnableGroupCommit(config.GroupCommitMaxBlocks, config.GroupCommitFlushInterval)
+}
+
+// enableHistoryMigration opens the history database to which the history is migrated, with the same views and
+// indexing options as the history database except for the key normalization, and enables the dual-write mode
+func (p *Provider) enableHistoryMigration(historydbProvider *history.DBProvider) error {
+	config := p.initializer.Config.HistoryDBConfig
+	target, err := history.NewDBProvider(config.MigrationTargetDir)
+	if err != nil {
+		return err
+	}
+	if err := p.configureHistoryMigrationTarget(target); err != nil {
+		target.Close()
+		return err
+	}
+	if err := historydbProvider.EnableMigration(target, config.MigrationShadowReadSampleRate); err != nil {
+		target.Close()
+		return err
+	}
+	return nil
+}
+
+func (p *Provider) configureHistoryMigrationTarget(target *history.DBProvider) error {
+	config := p.initializer.Config.HistoryDBConfig
+	for _, view := range p.initializer.HistoryViews {
+		if err := target.RegisterView(view); err != nil {
+			return err
+		}
+	}
+	if err := target.EnableFieldIndexes(config.FieldIndexes); err != nil {
+		return err
+	}
+	if err := target.EnableFullTextSearch(config.FullTextSearchNamespaces); err != nil {
+		return err
+	}
+	if err := target.DisableIndexing(config.DisabledNamespaces); err != nil {
+		return err
+	}
+	if err := target.SetKeyIndexingPolicies(config.IncludeKeys, config.ExcludeKeys); err != nil {
+		return err
+	}
+	if err := target.EnableCapabilities(config.Capabilities); err != nil {
+		return err
+	}
+	if err := target.EnableUnchangedWriteSkipping(config.SkipUnchangedWriteNamespaces); err != nil {
+		return err
+	}
+	if err := target.EnableDeltaEncoding(config.DeltaEncodingNamespaces, config.DeltaEncodingCheckpointInterval); err != nil {
+		return err
+	}
+	if err := target.EnableValueSizeTracking(config.ValueSizeTrackingNamespaces); err != nil {
+		return err
+	}
+	if config.TransactionIndex {
+		target.EnableTransactionIndex()
+	}
+	return target.EnableKeyNormalization(config.MigrationNormalizedKeyNamespaces)
+}
+
 func (p *Provider) initConfigHistoryManager() error {
 	var err error
 	configHistoryMgr, err := confighistory.NewMgr(
@@ -361,6 +520,9 @@ func (p *Provider) open(ledgerID string, bootSnapshotMetadata *SnapshotMetadata,
 	var historyDB *history.DB
 	if p.historydbProvider != nil {
 		historyDB = p.historydbProvider.GetDBHandle(ledgerID)
+		if err := historyDB.DetectIndexFormat(); err != nil {
+			return nil, err
+		}
 	}
 
 	initializer := &lgrInitializer{
@@ -369,6 +531,7 @@ func (p *Provider) open(ledgerID string, bootSnapshotMetadata *SnapshotMetadata,
 		pvtdataStore:             pvtdataStore,
 		stateDB:                  db,
 		historyDB:                historyDB,
+		historyLedgers:           p.historyLedgers,
 		configHistoryMgr:         p.configHistoryMgr,
 		stateListeners:           p.stateListeners,
 		bookkeeperProvider:       p.bookkeepingProvider,
@@ -7,19 +7,24 @@ SPDX-License-Identifier: Apache-2.0
 package kvledger
 
 import (
+	"context"
+	"fmt"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/hyperledger/fabric-protos-go/common"
 	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
 	"github.com/hyperledger/fabric-protos-go/ledger/rwset"
 	"github.com/hyperledger/fabric-protos-go/ledger/rwset/kvrwset"
+	"github.com/hyperledger/fabric-protos-go/msp"
 	"github.com/hyperledger/fabric-protos-go/peer"
 	"github.com/hyperledger/fabric/common/flogging"
 	"github.com/hyperledger/fabric/common/ledger/testutil"
 	"github.com/hyperledger/fabric/common/util"
 	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/history"
 	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/rwsetutil"
 	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/validation"
 	"github.com/hyperledger/fabric/core/ledger/mock"
@@ -61,6 +66,122 @@ func TestKVLedgerNilHistoryDBProvider(t *testing.T) {
 	)
 }
 
+func TestHistoryViewRegistration(t *testing.T) {
+	provider := testutilNewProvider(testConfig(t), t, &mock.DeployedChaincodeInfoProvider{})
+	provider.Close()
+	provider.initializer.HistoryViews = []ledger.HistoryView{&valueHistoryView{}, &valueHistoryView{}}
+	_, err := NewProvider(provider.initializer)
+	require.EqualError(t, err, "history view [value] is already registered")
+}
+
+func TestHistoryGroupCommit(t *testing.T) {
+	conf := testConfig(t)
+	conf.HistoryDBConfig.GroupCommitMaxBlocks = 10
+	conf.HistoryDBConfig.GroupCommitFlushInterval = time.Hour
+	provider1 := testutilNewProvider(conf, t, &mock.DeployedChaincodeInfoProvider{})
+	defer provider1.Close()
+
+	bg, gb := testutil.NewBlockGenerator(t, "testLedger", false)
+	lgr, err := provider1.CreateFromGenesisBlock(gb)
+	require.NoError(t, err)
+	for _, value := range []string{"value1", "value2", "value3"} {
+		simulator, _ := lgr.NewTxSimulator(util.GenerateUUID())
+		require.NoError(t, simulator.SetState("ns", "key1", []byte(value)))
+		simulator.Done()
+		simRes, _ := simulator.GetTxSimulationResults()
+		pubSimBytes, _ := simRes.GetPubSimulationBytes()
+		require.NoError(t, lgr.CommitLegacy(&ledger.BlockAndPvtData{Block: bg.NextBlock([][]byte{pubSimBytes})}, &ledger.CommitOptions{}))
+	}
+	// the history writes of the blocks are held back and the commit intents are retained
+	require.True(t, lgr.(*kvLedger).historyDB.HasPendingWrites())
+	checkBCSummaryForTest(t, lgr, &bcSummary{stateDBSavePoint: 3})
+	pendingBlockNums, err := lgr.(*kvLedger).commitJournal.pendingIntents()
+	require.NoError(t, err)
+	require.Equal(t, []uint64{1, 2, 3}, pendingBlockNums)
+
+	// the peer fails before the pending writes are flushed
+	provider1.Close()
+	provider2 := testutilNewProvider(conf, t, &mock.DeployedChaincodeInfoProvider{})
+	defer provider2.Close()
+	lgr, err = provider2.Open("testLedger")
+	require.NoError(t, err)
+	defer lgr.Close()
+	require.False(t, lgr.(*kvLedger).historyDB.HasPendingWrites())
+	checkBCSummaryForTest(t, lgr,
+		&bcSummary{
+			historyDBSavePoint: 3,
+			historyKey:         "key1",
+			historyVals:        []string{"value3", "value2", "value1"},
+		},
+	)
+}
+
+func TestAsyncHistoryCommit(t *testing.T) {
+	conf := testConfig(t)
+	conf.HistoryDBConfig.AsyncCommit = true
+	conf.HistoryDBConfig.AsyncCommitMaxLag = 2
+	provider := testutilNewProvider(conf, t, &mock.DeployedChaincodeInfoProvider{})
+	defer provider.Close()
+
+	bg, gb := testutil.NewBlockGenerator(t, "testLedger", false)
+	lgr, err := provider.CreateFromGenesisBlock(gb)
+	require.NoError(t, err)
+	defer lgr.Close()
+	kvlgr := lgr.(*kvLedger)
+	require.NotNil(t, kvlgr.historyCommitter)
+	for _, value := range []string{"value1", "value2", "value3"} {
+		simulator, _ := lgr.NewTxSimulator(util.GenerateUUID())
+		require.NoError(t, simulator.SetState("ns", "key1", []byte(value)))
+		simulator.Done()
+		simRes, _ := simulator.GetTxSimulationResults()
+		pubSimBytes, _ := simRes.GetPubSimulationBytes()
+		require.NoError(t, lgr.CommitLegacy(&ledger.BlockAndPvtData{Block: bg.NextBlock([][]byte{pubSimBytes})}, &ledger.CommitOptions{}))
+	}
+
+	kvlgr.historyCommitter.waitFor(4)
+	indexedHeight, err := kvlgr.historyDB.IndexedHeight()
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), indexedHeight)
+	pendingBlockNums, err := kvlgr.commitJournal.pendingIntents()
+	require.NoError(t, err)
+	require.Empty(t, pendingBlockNums)
+
+	qe, err := lgr.NewHistoryQueryExecutor()
+	require.NoError(t, err)
+	checkHistoryDBForTest(t, lgr, "key1", []string{"value3", "value2", "value1"})
+	queryIndexedHeight, err := qe.(*history.QueryExecutor).IndexedHeight()
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), queryIndexedHeight)
+
+	// the query executor does not reflect the blocks indexed after its first query
+	simulator, _ := lgr.NewTxSimulator(util.GenerateUUID())
+	require.NoError(t, simulator.SetState("ns", "key1", []byte("value4")))
+	simulator.Done()
+	simRes, _ := simulator.GetTxSimulationResults()
+	pubSimBytes, _ := simRes.GetPubSimulationBytes()
+	require.NoError(t, lgr.CommitLegacy(&ledger.BlockAndPvtData{Block: bg.NextBlock([][]byte{pubSimBytes})}, &ledger.CommitOptions{}))
+	kvlgr.historyCommitter.waitFor(5)
+	itr, err := qe.GetHistoryForKey("ns", "key1")
+	require.NoError(t, err)
+	defer itr.Close()
+	res, err := itr.Next()
+	require.NoError(t, err)
+	require.Equal(t, []byte("value3"), res.(*queryresult.KeyModification).Value)
+	checkHistoryDBForTest(t, lgr, "key1", []string{"value4", "value3", "value2", "value1"})
+
+}
+
+// valueHistoryView indexes the writes by the value written
+type valueHistoryView struct{}
+
+func (v *valueHistoryView) Name() string {
+	return "value"
+}
+
+func (v *valueHistoryView) Project(ns, key string, value []byte, isDelete bool) ([]string, error) {
+	return []string{string(value)}, nil
+}
+
 func TestKVLedgerBlockStorage(t *testing.T) {
 	t.Run("green-path", func(t *testing.T) {
 		conf := testConfig(t)
@@ -1505,6 +1626,139 @@ func checkBCSummaryForTest(t *testing.T, l ledger.PeerLedger, expectedBCSummary
 	}
 }
 
+func TestHistoryUnchangedWriteSkipping(t *testing.T) {
+	conf := testConfig(t)
+	conf.HistoryDBConfig.Capabilities = []string{history.CapabilityUnchangedWriteSkipping}
+	conf.HistoryDBConfig.SkipUnchangedWriteNamespaces = []string{"ns"}
+	provider := testutilNewProvider(conf, t, &mock.DeployedChaincodeInfoProvider{})
+	defer provider.Close()
+	blkGenerator, genesisBlk := testutil.NewBlockGenerator(t, "testLedger", false)
+	lgr, err := provider.CreateFromGenesisBlock(genesisBlk)
+	require.NoError(t, err)
+	defer lgr.Close()
+	kvlgr := lgr.(*kvLedger)
+	for i, value := range []string{"value1.1", "value1.1", "value1.2"} {
+		blockAndPvtdata := prepareNextBlockForTest(t, kvlgr, blkGenerator, fmt.Sprintf("SimulateForBlk%d", i+1),
+			map[string]string{"key1": value}, nil)
+		require.NoError(t, kvlgr.CommitLegacy(blockAndPvtdata, &ledger.CommitOptions{}))
+	}
+	checkHistoryDBForTest(t, lgr, "key1", []string{"value1.2", "value1.1"})
+}
+
+func TestHistoryQueryQuotasPerClient(t *testing.T) {
+	conf := testConfig(t)
+	conf.HistoryDBConfig.QueryQuotaWindow = time.Minute
+	conf.HistoryDBConfig.QueryQuotaMaxEntries = 1
+	provider := testutilNewProvider(conf, t, &mock.DeployedChaincodeInfoProvider{})
+	defer provider.Close()
+	blkGenerator, genesisBlk := testutil.NewBlockGenerator(t, "testLedger", false)
+	lgr, err := provider.CreateFromGenesisBlock(genesisBlk)
+	require.NoError(t, err)
+	defer lgr.Close()
+	kvlgr := lgr.(*kvLedger)
+	for i, value := range []string{"value1.1", "value1.2"} {
+		blockAndPvtdata := prepareNextBlockForTest(t, kvlgr, blkGenerator, fmt.Sprintf("SimulateForBlk%d", i+1),
+			map[string]string{"key1": value}, nil)
+		require.NoError(t, kvlgr.CommitLegacy(blockAndPvtdata, &ledger.CommitOptions{}))
+	}
+
+	creator := protoutil.MarshalOrPanic(&msp.SerializedIdentity{Mspid: "Org1MSP", IdBytes: []byte("client1")})
+	qe, err := kvlgr.NewHistoryQueryExecutorForClient(context.Background(), creator)
+	require.NoError(t, err)
+	itr, err := qe.GetHistoryForKey("ns", "key1")
+	require.NoError(t, err)
+	defer itr.Close()
+	_, err = itr.Next()
+	require.NoError(t, err)
+	_, err = itr.Next()
+	quotaErr, ok := err.(*history.QueryQuotaExceededError)
+	require.True(t, ok)
+	require.Equal(t, history.QueryClientForIdentity(creator), quotaErr.Client)
+
+	// the queries of the other clients, and the ones not issued on behalf of a client, are not affected
+	otherCreator := protoutil.MarshalOrPanic(&msp.SerializedIdentity{Mspid: "Org1MSP", IdBytes: []byte("client2")})
+	qe, err = kvlgr.NewHistoryQueryExecutorForClient(context.Background(), otherCreator)
+	require.NoError(t, err)
+	itr2, err := qe.GetHistoryForKey("ns", "key1")
+	require.NoError(t, err)
+	defer itr2.Close()
+	_, err = itr2.Next()
+	require.NoError(t, err)
+	checkHistoryDBForTest(t, lgr, "key1", []string{"value1.2", "value1.1"})
+}
+
+type testHistoryProjector struct {
+	blocks []uint64
+}
+
+func (p *testHistoryProjector) Name() string {
+	return "projector1"
+}
+
+func (p *testHistoryProjector) Reset(ledgerID string, fromBlock uint64) error {
+	p.blocks = nil
+	return nil
+}
+
+func (p *testHistoryProjector) Apply(ledgerID string, blockNum uint64, changes []*ledger.HistoryKeyChange) error {
+	p.blocks = append(p.blocks, blockNum)
+	return nil
+}
+
+func TestHistoryProjection(t *testing.T) {
+	conf := testConfig(t)
+	provider := testutilNewProvider(conf, t, &mock.DeployedChaincodeInfoProvider{})
+	defer provider.Close()
+	projector := &testHistoryProjector{}
+	require.NoError(t, provider.historydbProvider.RegisterProjector(projector))
+	blkGenerator, genesisBlk := testutil.NewBlockGenerator(t, "testLedger", false)
+	lgr, err := provider.CreateFromGenesisBlock(genesisBlk)
+	require.NoError(t, err)
+	defer lgr.Close()
+	kvlgr := lgr.(*kvLedger)
+	blockAndPvtdata := prepareNextBlockForTest(t, kvlgr, blkGenerator, "SimulateForBlk1",
+		map[string]string{"key1": "value1.1"}, nil)
+	require.NoError(t, kvlgr.CommitLegacy(blockAndPvtdata, &ledger.CommitOptions{}))
+	require.Equal(t, []uint64{0, 1}, projector.blocks)
+}
+
+func TestHistoryMigration(t *testing.T) {
+	conf := testConfig(t)
+	provider := testutilNewProvider(conf, t, &mock.DeployedChaincodeInfoProvider{})
+	blkGenerator, genesisBlk := testutil.NewBlockGenerator(t, "testLedger", false)
+	lgr, err := provider.CreateFromGenesisBlock(genesisBlk)
+	require.NoError(t, err)
+	kvlgr := lgr.(*kvLedger)
+	blockAndPvtdata := prepareNextBlockForTest(t, kvlgr, blkGenerator, "SimulateForBlk1",
+		map[string]string{"key1": "value1.1"}, nil)
+	require.NoError(t, kvlgr.CommitLegacy(blockAndPvtdata, &ledger.CommitOptions{}))
+	_, err = kvlgr.historyDB.MigrationStatus()
+	require.EqualError(t, err, "history migration not enabled")
+	lgr.Close()
+	provider.Close()
+
+	// the migration target is caught up with the blocks committed before the migration when the ledger is opened
+	conf.HistoryDBConfig.MigrationTargetDir = t.TempDir()
+	conf.HistoryDBConfig.MigrationShadowReadSampleRate = 1
+	provider = testutilNewProvider(conf, t, &mock.DeployedChaincodeInfoProvider{})
+	defer provider.Close()
+	lgr, err = provider.Open("testLedger")
+	require.NoError(t, err)
+	defer lgr.Close()
+	kvlgr = lgr.(*kvLedger)
+	status, err := kvlgr.historyDB.MigrationStatus()
+	require.NoError(t, err)
+	require.Equal(t, &history.MigrationStatus{IndexedHeight: 2, TargetIndexedHeight: 2}, status)
+
+	blockAndPvtdata = prepareNextBlockForTest(t, kvlgr, blkGenerator, "SimulateForBlk2",
+		map[string]string{"key1": "value1.2"}, nil)
+	require.NoError(t, kvlgr.CommitLegacy(blockAndPvtdata, &ledger.CommitOptions{}))
+	status, err = kvlgr.historyDB.MigrationStatus()
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), status.TargetIndexedHeight)
+	checkHistoryDBForTest(t, lgr, "key1", []string{"value1.2", "value1.1"})
+}
+
 func checkStateDBForTest(t *testing.T, l ledger.PeerLedger, expectedKVs map[string]string, expectedPvtKVs map[string]string) {
 	simulator, _ := l.NewTxSimulator("checkStateDBForTest")
 	defer simulator.Done()
@@ -40,6 +40,23 @@ func HistoryDBPath(rootFSPath string) string {
 	return filepath.Join(rootFSPath, "historyLeveldb")
 }
 
+// HistoryRebuildDBPath returns the absolute path of the side DB in which the history DB is rebuilt
+func HistoryRebuildDBPath(rootFSPath string) string {
+	return filepath.Join(rootFSPath, "historyRebuildLeveldb")
+}
+
+// HistoryInvalidationsDBPath returns the absolute path of the DB in which the invalidations of the history are
+// recorded by a rollback or a reset of the ledgers, which drops the history DB, until the peer starts
+func HistoryInvalidationsDBPath(rootFSPath string) string {
+	return filepath.Join(rootFSPath, "historyInvalidationsLeveldb")
+}
+
+// HistoryReplicasPath returns the absolute path of the dir under which the read-only replicas of the history DB are
+// written, per channel, by the checkpoints requested via the admin API of the history
+func HistoryReplicasPath(rootFSPath string) string {
+	return filepath.Join(rootFSPath, "historyReplicas")
+}
+
 // ConfigHistoryDBPath returns the absolute path of configHistory DB
 func ConfigHistoryDBPath(rootFSPath string) string {
 	return filepath.Join(rootFSPath, "configHistory")
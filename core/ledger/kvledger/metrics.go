@@ -18,6 +18,7 @@ type stats struct {
 	blockAndPvtdataStoreCommitTime metrics.Histogram
 	statedbCommitTime              metrics.Histogram
 	transactionsCount              metrics.Counter
+	historyIndexLag                metrics.Gauge
 }
 
 func newStats(metricsProvider metrics.Provider) *stats {
@@ -26,6 +27,7 @@ func newStats(metricsProvider metrics.Provider) *stats {
 	stats.blockAndPvtdataStoreCommitTime = metricsProvider.NewHistogram(blockAndPvtdataStoreCommitTimeOpts)
 	stats.statedbCommitTime = metricsProvider.NewHistogram(statedbCommitTimeOpts)
 	stats.transactionsCount = metricsProvider.NewCounter(transactionCountOpts)
+	stats.historyIndexLag = metricsProvider.NewGauge(historyIndexLagOpts)
 	return stats
 }
 
@@ -52,6 +54,10 @@ func (s *ledgerStats) updateStatedbCommitTime(timeTaken time.Duration) {
 	s.stats.statedbCommitTime.With("channel", s.ledgerid).Observe(timeTaken.Seconds())
 }
 
+func (s *ledgerStats) updateHistoryIndexLag(lag uint64) {
+	s.stats.historyIndexLag.With("channel", s.ledgerid).Set(float64(lag))
+}
+
 func (s *ledgerStats) updateTransactionsStats(
 	txstatsInfo []*validation.TxStatInfo,
 ) {
@@ -114,4 +120,13 @@ var (
 		LabelNames:   []string{"channel", "transaction_type", "chaincode", "validation_code"},
 		StatsdFormat: "%{#fqname}.%{channel}.%{transaction_type}.%{chaincode}.%{validation_code}",
 	}
+
+	historyIndexLagOpts = metrics.GaugeOpts{
+		Namespace:    "ledger",
+		Subsystem:    "history",
+		Name:         "index_lag",
+		Help:         "Number of blocks committed to the ledger and not yet written to the history database.",
+		LabelNames:   []string{"channel"},
+		StatsdFormat: "%{#fqname}.%{channel}",
+	}
 )
@@ -141,12 +141,50 @@ func TestStatsBlockCommit(t *testing.T) {
 	)
 }
 
+func TestStatsHistoryIndexLag(t *testing.T) {
+	conf := testConfig(t)
+	conf.HistoryDBConfig.GroupCommitMaxBlocks = 10
+	conf.HistoryDBConfig.GroupCommitFlushInterval = time.Hour
+	testMetricProvider := testutilConstructMetricProvider()
+
+	cryptoProvider, err := sw.NewDefaultSecurityLevelWithKeystore(sw.NewDummyKeyStore())
+	require.NoError(t, err)
+	provider, err := NewProvider(
+		&lgr.Initializer{
+			DeployedChaincodeInfoProvider: &mock.DeployedChaincodeInfoProvider{},
+			MetricsProvider:               testMetricProvider.fakeProvider,
+			Config:                        conf,
+			HashProvider:                  cryptoProvider,
+		},
+	)
+	require.NoError(t, err)
+	defer provider.Close()
+
+	bg, gb := testutil.NewBlockGenerator(t, "ledger1", false)
+	l, err := provider.CreateFromGenesisBlock(gb)
+	require.NoError(t, err)
+	defer l.Close()
+	fakeLagGauge := testMetricProvider.fakeHistoryIndexLagGauge
+	require.Equal(t, []string{"channel", "ledger1"}, fakeLagGauge.WithArgsForCall(0))
+	require.Equal(t, float64(0), fakeLagGauge.SetArgsForCall(fakeLagGauge.SetCallCount()-1))
+
+	// the history writes of the blocks committed in quick succession are held back by the group commit
+	for i := 0; i < 2; i++ {
+		require.NoError(t, l.CommitLegacy(&lgr.BlockAndPvtData{Block: bg.NextBlock([][]byte{})}, &lgr.CommitOptions{}))
+	}
+	require.Equal(t, float64(2), fakeLagGauge.SetArgsForCall(fakeLagGauge.SetCallCount()-1))
+	require.NoError(t, l.(*kvLedger).historyDB.Flush())
+	l.(*kvLedger).updateHistoryIndexLag(3)
+	require.Equal(t, float64(0), fakeLagGauge.SetArgsForCall(fakeLagGauge.SetCallCount()-1))
+}
+
 type testMetricProvider struct {
 	fakeProvider                              *metricsfakes.Provider
 	fakeBlockProcessingTimeHist               *metricsfakes.Histogram
 	fakeBlockstorageCommitWithPvtDataTimeHist *metricsfakes.Histogram
 	fakeStatedbCommitTimeHist                 *metricsfakes.Histogram
 	fakeTransactionsCount                     *metricsfakes.Counter
+	fakeHistoryIndexLagGauge                  *metricsfakes.Gauge
 }
 
 func testutilConstructMetricProvider() *testMetricProvider {
@@ -155,7 +193,11 @@ func testutilConstructMetricProvider() *testMetricProvider {
 	fakeBlockstorageCommitWithPvtDataTimeHist := testutilConstructHist()
 	fakeStatedbCommitTimeHist := testutilConstructHist()
 	fakeTransactionsCount := testutilConstructCounter()
+	fakeHistoryIndexLagGauge := testutilConstructGauge()
 	fakeProvider.NewGaugeStub = func(opts metrics.GaugeOpts) metrics.Gauge {
+		if opts.Name == historyIndexLagOpts.Name && opts.Subsystem == historyIndexLagOpts.Subsystem {
+			return fakeHistoryIndexLagGauge
+		}
 		// return a gauge for metrics in common/ledger
 		return testutilConstructGauge()
 	}
@@ -186,6 +228,7 @@ func testutilConstructMetricProvider() *testMetricProvider {
 		fakeBlockstorageCommitWithPvtDataTimeHist,
 		fakeStatedbCommitTimeHist,
 		fakeTransactionsCount,
+		fakeHistoryIndexLagGauge,
 	}
 }
 
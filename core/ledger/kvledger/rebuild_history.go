@@ -0,0 +1,80 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package kvledger
+
+import (
+	"github.com/hyperledger/fabric/common/ledger/blkstorage"
+	"github.com/hyperledger/fabric/common/ledger/util/leveldbhelper"
+	"github.com/hyperledger/fabric/common/metrics/disabled"
+	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/history"
+	"github.com/pkg/errors"
+)
+
+// RebuildHistory rebuilds the history DB for a ledger from the block store, starting from the block fromBlock,
+// while leaving the history of the other ledgers and the history for the blocks prior to fromBlock intact.
+// A fromBlock of 0 rebuilds the complete history. The history is rebuilt in a side DB and swapped in once
+// complete. If interrupted, invoking this function again resumes the rebuild. This function is to be invoked
+// while the peer is shut down.
+func RebuildHistory(config *ledger.Config, ledgerID string, fromBlock uint64) error {
+	if !config.HistoryDBConfig.Enabled {
+		return errors.New("history database not enabled")
+	}
+	fileLock := leveldbhelper.NewFileLock(fileLockPath(config.RootFSPath))
+	if err := fileLock.Lock(); err != nil {
+		return errors.WithMessage(err, "as another peer node command is executing,"+
+			" wait for that command to complete its execution or terminate it before retrying")
+	}
+	defer fileLock.Unlock()
+
+	blkStoreProvider, err := blkstorage.NewProvider(
+		blkstorage.NewConf(
+			BlockStorePath(config.RootFSPath),
+			maxBlockFileSize,
+		),
+		&blkstorage.IndexConfig{AttrsToIndex: attrsToIndex},
+		&disabled.Provider{},
+	)
+	if err != nil {
+		return err
+	}
+	defer blkStoreProvider.Close()
+
+	exists, err := blkStoreProvider.Exists(ledgerID)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return errors.Errorf("ledger [%s] does not exist", ledgerID)
+	}
+	blockStore, err := blkStoreProvider.Open(ledgerID)
+	if err != nil {
+		return err
+	}
+	defer blockStore.Shutdown()
+
+	historydbProvider, err := history.NewDBProvider(HistoryDBPath(config.RootFSPath))
+	if err != nil {
+		return err
+	}
+	defer historydbProvider.Close()
+
+	sideProvider, err := history.NewDBProvider(HistoryRebuildDBPath(config.RootFSPath))
+	if err != nil {
+		return err
+	}
+	defer sideProvider.Close()
+	if config.HistoryDBConfig.TransactionIndex {
+		sideProvider.EnableTransactionIndex()
+	}
+
+	if err := historydbProvider.Rebuild(ledgerID, sideProvider, blockStore, fromBlock, nil); err != nil {
+		return errors.WithMessagef(err, "error while rebuilding history for ledger [%s]", ledgerID)
+	}
+	logger.Infow("History has been successfully rebuilt", "ledgerID", ledgerID)
+	return nil
+}
@@ -0,0 +1,83 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package kvledger
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/hyperledger/fabric/common/ledger/testutil"
+	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/history"
+	"github.com/hyperledger/fabric/core/ledger/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRebuildHistory(t *testing.T) {
+	conf := testConfig(t)
+	provider := testutilNewProvider(conf, t, &mock.DeployedChaincodeInfoProvider{})
+
+	blkGenerator, genesisBlk := testutil.NewBlockGenerator(t, "testLedgerid", false)
+	lgr, err := provider.CreateFromGenesisBlock(genesisBlk)
+	require.NoError(t, err)
+	kvlgr := lgr.(*kvLedger)
+	for i, value := range []string{"value1.1", "value1.2"} {
+		blockAndPvtdata := prepareNextBlockForTest(t, kvlgr, blkGenerator, fmt.Sprintf("SimulateForBlk%d", i+1),
+			map[string]string{"key1": value},
+			nil,
+		)
+		require.NoError(t, kvlgr.CommitLegacy(blockAndPvtdata, &ledger.CommitOptions{}))
+	}
+
+	// rebuild should fail when provider is still open
+	err = RebuildHistory(conf, "testLedgerid", 0)
+	require.ErrorContains(t, err, "as another peer node command is executing")
+	lgr.Close()
+	provider.Close()
+
+	// lose the history
+	historydbProvider, err := history.NewDBProvider(HistoryDBPath(conf.RootFSPath))
+	require.NoError(t, err)
+	require.NoError(t, historydbProvider.Drop("testLedgerid"))
+	historydbProvider.Close()
+
+	require.EqualError(t, RebuildHistory(conf, "non-existing-ledger", 0), "ledger [non-existing-ledger] does not exist")
+	require.NoError(t, RebuildHistory(conf, "testLedgerid", 0))
+
+	historydbProvider, err = history.NewDBProvider(HistoryDBPath(conf.RootFSPath))
+	require.NoError(t, err)
+	savepoint, err := historydbProvider.GetDBHandle("testLedgerid").GetLastSavepoint()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), savepoint.BlockNum)
+	historydbProvider.Close()
+
+	provider = testutilNewProvider(conf, t, &mock.DeployedChaincodeInfoProvider{})
+	defer provider.Close()
+	lgr, err = provider.Open("testLedgerid")
+	require.NoError(t, err)
+	defer lgr.Close()
+	hqe, err := lgr.NewHistoryQueryExecutor()
+	require.NoError(t, err)
+	itr, err := hqe.GetHistoryForKey("ns", "key1")
+	require.NoError(t, err)
+	defer itr.Close()
+	for _, expectedValue := range []string{"value1.2", "value1.1"} {
+		res, err := itr.Next()
+		require.NoError(t, err)
+		require.Equal(t, expectedValue, string(res.(*queryresult.KeyModification).Value))
+	}
+	res, err := itr.Next()
+	require.NoError(t, err)
+	require.Nil(t, res)
+}
+
+func TestRebuildHistoryDisabled(t *testing.T) {
+	conf := testConfig(t)
+	conf.HistoryDBConfig.Enabled = false
+	require.EqualError(t, RebuildHistory(conf, "testLedgerid", 0), "history database not enabled")
+}
@@ -9,10 +9,12 @@ package kvledger
 import (
 	"github.com/hyperledger/fabric/common/ledger/blkstorage"
 	"github.com/hyperledger/fabric/common/ledger/util/leveldbhelper"
+	"github.com/hyperledger/fabric/core/ledger"
 	"github.com/pkg/errors"
 )
 
-// ResetAllKVLedgers resets all ledger to the genesis block.
+// ResetAllKVLedgers resets all ledger to the genesis block. The invalidation of the history of the blocks removed is
+// recorded for the history commit listeners.
 func ResetAllKVLedgers(rootFSPath string) error {
 	fileLockPath := fileLockPath(rootFSPath)
 	fileLock := leveldbhelper.NewFileLock(fileLockPath)
@@ -31,6 +33,15 @@ func ResetAllKVLedgers(rootFSPath string) error {
 		return errors.Errorf("cannot reset channels because the peer contains channel(s) %s that were bootstrapped from snapshot", ledgerIDs)
 	}
 
+	ledgerIDs, err = listLedgerIDs(rootFSPath)
+	if err != nil {
+		return err
+	}
+	historySavepoints, err := loadHistorySavepoints(rootFSPath, ledgerIDs)
+	if err != nil {
+		return err
+	}
+
 	logger.Info("Resetting all channel ledgers to genesis block")
 	logger.Infof("Ledger data folder from config = [%s]", rootFSPath)
 	if err := dropDBs(rootFSPath); err != nil {
@@ -39,6 +50,20 @@ func ResetAllKVLedgers(rootFSPath string) error {
 	if err := resetBlockStorage(rootFSPath); err != nil {
 		return err
 	}
+	var invalidations []*ledger.HistoryInvalidation
+	for _, ledgerID := range ledgerIDs {
+		if savepoint, ok := historySavepoints[ledgerID]; ok && savepoint > 0 {
+			invalidations = append(invalidations, &ledger.HistoryInvalidation{
+				LedgerID:  ledgerID,
+				FromBlock: 1,
+				ToBlock:   savepoint,
+				Reason:    ledger.HistoryInvalidationReasonLedgerReset,
+			})
+		}
+	}
+	if err := recordHistoryInvalidations(rootFSPath, invalidations); err != nil {
+		return err
+	}
 	logger.Info("All channel ledgers have been successfully reset to the genesis block")
 	return nil
 }
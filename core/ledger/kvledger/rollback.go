@@ -9,10 +9,12 @@ package kvledger
 import (
 	"github.com/hyperledger/fabric/common/ledger/blkstorage"
 	"github.com/hyperledger/fabric/common/ledger/util/leveldbhelper"
+	"github.com/hyperledger/fabric/core/ledger"
 	"github.com/pkg/errors"
 )
 
-// RollbackKVLedger rollbacks a ledger to a specified block number
+// RollbackKVLedger rollbacks a ledger to a specified block number. The invalidation of the history of the blocks
+// removed is recorded for the history commit listeners
 func RollbackKVLedger(rootFSPath, ledgerID string, blockNum uint64) error {
 	fileLockPath := fileLockPath(rootFSPath)
 	fileLock := leveldbhelper.NewFileLock(fileLockPath)
@@ -35,6 +37,11 @@ func RollbackKVLedger(rootFSPath, ledgerID string, blockNum uint64) error {
 		return err
 	}
 
+	historySavepoints, err := loadHistorySavepoints(rootFSPath, []string{ledgerID})
+	if err != nil {
+		return err
+	}
+
 	logger.Infof("Dropping databases")
 	if err := dropDBs(rootFSPath); err != nil {
 		return err
@@ -45,6 +52,16 @@ func RollbackKVLedger(rootFSPath, ledgerID string, blockNum uint64) error {
 	if err := blkstorage.Rollback(blockstorePath, ledgerID, blockNum, indexConfig); err != nil {
 		return err
 	}
+	if savepoint, ok := historySavepoints[ledgerID]; ok && savepoint > blockNum {
+		if err := recordHistoryInvalidations(rootFSPath, []*ledger.HistoryInvalidation{{
+			LedgerID:  ledgerID,
+			FromBlock: blockNum + 1,
+			ToBlock:   savepoint,
+			Reason:    ledger.HistoryInvalidationReasonLedgerRollback,
+		}}); err != nil {
+			return err
+		}
+	}
 	logger.Infof("The channel [%s] has been successfully rolled back to the block number [%d]", ledgerID, blockNum)
 	return nil
 }
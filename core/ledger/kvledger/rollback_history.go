@@ -0,0 +1,79 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package kvledger
+
+import (
+	"github.com/hyperledger/fabric/common/ledger/blkstorage"
+	"github.com/hyperledger/fabric/common/ledger/util/leveldbhelper"
+	"github.com/hyperledger/fabric/common/metrics/disabled"
+	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/history"
+	"github.com/pkg/errors"
+)
+
+// RollbackHistory rolls back the history DB of a ledger to the given block number, as if the blocks after the block
+// number were never committed, so that the history can be aligned with a block store restored to the block number
+// without rebuilding the history. Unlike the rollback of the ledger, the block store and the other databases are
+// not touched. The block number cannot be beyond the last block in the block store and, for a ledger bootstrapped
+// from a snapshot, prior to the last block in the snapshot. This function is to be invoked while the peer is shut down.
+func RollbackHistory(config *ledger.Config, ledgerID string, blockNum uint64) error {
+	if !config.HistoryDBConfig.Enabled {
+		return errors.New("history database not enabled")
+	}
+	fileLock := leveldbhelper.NewFileLock(fileLockPath(config.RootFSPath))
+	if err := fileLock.Lock(); err != nil {
+		return errors.WithMessage(err, "as another peer node command is executing,"+
+			" wait for that command to complete its execution or terminate it before retrying")
+	}
+	defer fileLock.Unlock()
+
+	blkStoreProvider, err := blkstorage.NewProvider(
+		blkstorage.NewConf(
+			BlockStorePath(config.RootFSPath),
+			maxBlockFileSize,
+		),
+		&blkstorage.IndexConfig{AttrsToIndex: attrsToIndex},
+		&disabled.Provider{},
+	)
+	if err != nil {
+		return err
+	}
+	defer blkStoreProvider.Close()
+	exists, err := blkStoreProvider.Exists(ledgerID)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return errors.Errorf("ledger [%s] does not exist", ledgerID)
+	}
+	blockStore, err := blkStoreProvider.Open(ledgerID)
+	if err != nil {
+		return err
+	}
+	bcInfo, err := blockStore.GetBlockchainInfo()
+	if err != nil {
+		return err
+	}
+	if blockNum >= bcInfo.Height {
+		return errors.Errorf("cannot rollback the history to block [%d] as the ledger height is [%d]", blockNum, bcInfo.Height)
+	}
+	if s := bcInfo.BootstrappingSnapshotInfo; s != nil && blockNum < s.LastBlockInSnapshot {
+		return errors.Errorf("cannot rollback the history to block [%d] as the ledger is bootstrapped from a snapshot at block [%d]",
+			blockNum, s.LastBlockInSnapshot)
+	}
+
+	historydbProvider, err := history.NewDBProvider(HistoryDBPath(config.RootFSPath))
+	if err != nil {
+		return err
+	}
+	defer historydbProvider.Close()
+	if err := historydbProvider.Rollback(ledgerID, blockNum); err != nil {
+		return err
+	}
+	logger.Infow("History has been successfully rolled back", "ledgerID", ledgerID, "blockNum", blockNum)
+	return nil
+}
@@ -24,6 +24,7 @@ import (
 	"github.com/hyperledger/fabric/core/ledger"
 	"github.com/hyperledger/fabric/core/ledger/confighistory"
 	"github.com/hyperledger/fabric/core/ledger/internal/version"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/history"
 	"github.com/hyperledger/fabric/core/ledger/kvledger/msgs"
 	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/pvtstatepurgemgmt"
 	"github.com/hyperledger/fabric/core/ledger/pvtdatapolicy"
@@ -129,6 +130,18 @@ func (l *kvLedger) generateSnapshot() error {
 	}
 	logger.Debugw("Exported public state and private state hashes", "channelID", l.ledgerID)
 
+	// the history files are verified on import via their own manifest and are deliberately kept out
+	// of the signable metadata, as their content depends on the history config of this peer
+	if l.historyDB != nil && l.config.HistoryDBConfig.IncludeInSnapshots {
+		if l.historyCommitter != nil {
+			l.historyCommitter.waitFor(lastBlockNum + 1)
+		}
+		if _, err := l.historyDB.ExportHistory(snapshotTempDir, newHashFunc, l.blockStore); err != nil {
+			return err
+		}
+		logger.Debugw("Exported history", "channelID", l.ledgerID)
+	}
+
 	if err := l.generateSnapshotMetadataFiles(
 		snapshotTempDir, txIDsExportSummary,
 		configsHistoryExportSummary, stateDBExportSummary,
@@ -320,6 +333,21 @@ func (p *Provider) CreateFromSnapshot(snapshotDir string) (ledger.PeerLedger, st
 	logger.Debugw("Imported data into statedb, purgeMgr, and pvtdata store", "ledgerID", ledgerID)
 
 	if p.historydbProvider != nil {
+		if err := p.historydbProvider.ImportFromSnapshot(ledgerID, snapshotDir,
+			&history.SnapshotInfo{
+				LastBlockNum:  lastBlockNum,
+				LastBlockHash: lastBlkHash,
+				NewHashFunc: func() (hash.Hash, error) {
+					return p.initializer.HashProvider.GetHash(snapshotHashOpts)
+				},
+			},
+		); err != nil {
+			return nil, "", p.deleteUnderConstructionLedger(
+				nil,
+				ledgerID,
+				errors.WithMessage(err, "error while importing data into history db"),
+			)
+		}
 		if err := p.historydbProvider.MarkStartingSavepoint(ledgerID, savepoint); err != nil {
 			return nil, "", p.deleteUnderConstructionLedger(
 				nil,
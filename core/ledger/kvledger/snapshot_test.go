@@ -7,9 +7,11 @@ SPDX-License-Identifier: Apache-2.0
 package kvledger
 
 import (
+	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"hash"
 	"io/ioutil"
 	"math"
 	"os"
@@ -208,6 +210,164 @@ func TestSnapshotGenerationAndNewLedgerCreation(t *testing.T) {
 	})
 }
 
+func TestSnapshotWithHistory(t *testing.T) {
+	conf := testConfig(t)
+	conf.HistoryDBConfig.IncludeInSnapshots = true
+	provider := testutilNewProvider(conf, t, &mock.DeployedChaincodeInfoProvider{})
+	defer provider.Close()
+
+	blkGenerator, genesisBlk := testutil.NewBlockGenerator(t, "testLedgerid", false)
+	lgr, err := provider.CreateFromGenesisBlock(genesisBlk)
+	require.NoError(t, err)
+	defer lgr.Close()
+	kvlgr := lgr.(*kvLedger)
+
+	blockAndPvtdata1 := prepareNextBlockForTest(t, kvlgr, blkGenerator, "SimulateForBlk1",
+		map[string]string{"key1": "value1.1"},
+		nil,
+	)
+	require.NoError(t, kvlgr.CommitLegacy(blockAndPvtdata1, &ledger.CommitOptions{}))
+	blockAndPvtdata2 := prepareNextBlockForTest(t, kvlgr, blkGenerator, "SimulateForBlk2",
+		map[string]string{"key1": "value1.2"},
+		nil,
+	)
+	require.NoError(t, kvlgr.CommitLegacy(blockAndPvtdata2, &ledger.CommitOptions{}))
+	require.NoError(t, kvlgr.generateSnapshot())
+	verifySnapshotOutput(t,
+		&expectedSnapshotOutput{
+			snapshotRootDir:   conf.SnapshotsConfig.RootDir,
+			ledgerID:          kvlgr.ledgerID,
+			lastBlockNumber:   2,
+			lastBlockHash:     protoutil.BlockHeaderHash(blockAndPvtdata2.Block.Header),
+			previousBlockHash: blockAndPvtdata2.Block.Header.PreviousHash,
+			lastCommitHash:    kvlgr.commitHash,
+			stateDBType:       simpleKeyValueDB,
+			expectedBinaryFiles: []string{
+				"txids.data", "txids.metadata",
+				"public_state.data", "public_state.metadata",
+			},
+			unsignedFiles: []string{
+				"history.data", "history.metadata", "history_manifest.json",
+			},
+		},
+	)
+
+	snapshotDir := SnapshotDirForLedgerBlockNum(conf.SnapshotsConfig.RootDir, kvlgr.ledgerID, 2)
+	createdLedger := testCreateLedgerFromSnapshot(t, snapshotDir, kvlgr.ledgerID)
+	defer createdLedger.Close()
+
+	hqe, err := createdLedger.NewHistoryQueryExecutor()
+	require.NoError(t, err)
+	itr, err := hqe.GetHistoryForKey("ns", "key1")
+	require.NoError(t, err)
+	defer itr.Close()
+	for _, expectedValue := range []string{"value1.2", "value1.1"} {
+		res, err := itr.Next()
+		require.NoError(t, err)
+		require.Equal(t, expectedValue, string(res.(*queryresult.KeyModification).Value))
+	}
+	res, err := itr.Next()
+	require.NoError(t, err)
+	require.Nil(t, res)
+}
+
+func TestSnapshotHistoryBackfill(t *testing.T) {
+	conf := testConfig(t)
+	provider := testutilNewProvider(conf, t, &mock.DeployedChaincodeInfoProvider{})
+	defer provider.Close()
+
+	blkGenerator, genesisBlk := testutil.NewBlockGenerator(t, "testLedgerid", false)
+	lgr, err := provider.CreateFromGenesisBlock(genesisBlk)
+	require.NoError(t, err)
+	defer lgr.Close()
+	kvlgr := lgr.(*kvLedger)
+
+	for i, value := range []string{"value1.1", "value1.2"} {
+		blockAndPvtdata := prepareNextBlockForTest(t, kvlgr, blkGenerator, fmt.Sprintf("SimulateForBlk%d", i+1),
+			map[string]string{"key1": value},
+			nil,
+		)
+		require.NoError(t, kvlgr.CommitLegacy(blockAndPvtdata, &ledger.CommitOptions{}))
+	}
+	// snapshot without history at block 2
+	require.NoError(t, kvlgr.generateSnapshot())
+	snapshotDir := SnapshotDirForLedgerBlockNum(conf.SnapshotsConfig.RootDir, kvlgr.ledgerID, 2)
+
+	// the archive is produced at a later height and the entries beyond the snapshot are expected to be skipped
+	blockAndPvtdata3 := prepareNextBlockForTest(t, kvlgr, blkGenerator, "SimulateForBlk3",
+		map[string]string{"key1": "value1.3"},
+		nil,
+	)
+	require.NoError(t, kvlgr.CommitLegacy(blockAndPvtdata3, &ledger.CommitOptions{}))
+	archiveRootDir := t.TempDir()
+	archiveDir := filepath.Join(archiveRootDir, kvlgr.ledgerID)
+	require.NoError(t, os.MkdirAll(archiveDir, 0o755))
+	_, err = kvlgr.historyDB.ExportHistory(archiveDir,
+		func() (hash.Hash, error) { return sha256.New(), nil },
+		kvlgr.blockStore,
+	)
+	require.NoError(t, err)
+
+	t.Run("history-files-exported-at-another-height", func(t *testing.T) {
+		tamperedSnapshotDir := t.TempDir()
+		for _, dir := range []string{snapshotDir, archiveDir} {
+			files, err := os.ReadDir(dir)
+			require.NoError(t, err)
+			for _, f := range files {
+				content, err := os.ReadFile(filepath.Join(dir, f.Name()))
+				require.NoError(t, err)
+				require.NoError(t, os.WriteFile(filepath.Join(tamperedSnapshotDir, f.Name()), content, 0o600))
+			}
+		}
+		p := testutilNewProvider(testConfig(t), t, &mock.DeployedChaincodeInfoProvider{})
+		defer p.Close()
+		_, _, err := p.CreateFromSnapshot(tamperedSnapshotDir)
+		require.EqualError(t, err, fmt.Sprintf("error while importing data into history db: "+
+			"error while verifying history data in the snapshot: "+
+			"history data is exported at block [3] with hash [%x], whereas the snapshot is for block [2] with hash [%x]",
+			protoutil.BlockHeaderHash(blockAndPvtdata3.Block.Header), blockAndPvtdata3.Block.Header.PreviousHash,
+		))
+	})
+
+	destConf := testConfig(t)
+	destConf.HistoryDBConfig.BackfillArchiveDir = archiveRootDir
+	destProvider := testutilNewProvider(destConf, t, &mock.DeployedChaincodeInfoProvider{})
+	defer destProvider.Close()
+	destLedger, _, err := destProvider.CreateFromSnapshot(snapshotDir)
+	require.NoError(t, err)
+	defer destLedger.Close()
+	createdLedger := destLedger.(*kvLedger)
+
+	require.Eventually(t, func() bool {
+		progress, err := createdLedger.historyDB.BackfillProgress()
+		require.NoError(t, err)
+		return progress.Done
+	}, time.Minute, 10*time.Millisecond)
+
+	blockAndPvtdata3.Block.Header.Number = 3
+	require.NoError(t, createdLedger.CommitLegacy(blockAndPvtdata3, &ledger.CommitOptions{}))
+
+	hqe, err := createdLedger.NewHistoryQueryExecutor()
+	require.NoError(t, err)
+	itr, err := hqe.GetHistoryForKey("ns", "key1")
+	require.NoError(t, err)
+	defer itr.Close()
+	for _, expectedValue := range []string{"value1.3", "value1.2", "value1.1"} {
+		res, err := itr.Next()
+		require.NoError(t, err)
+		require.Equal(t, expectedValue, string(res.(*queryresult.KeyModification).Value))
+	}
+	res, err := itr.Next()
+	require.NoError(t, err)
+	require.Nil(t, res)
+
+	stats, err := createdLedger.historyDB.GetIndexStats("ns")
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), stats.DistinctKeys)
+	require.Equal(t, uint64(3), stats.TotalIndexEntries)
+	require.Equal(t, uint64(3), stats.LastIndexedBlock)
+}
+
 func TestSnapshotDBTypeCouchDB(t *testing.T) {
 	conf := testConfig(t)
 	fmt.Printf("snapshotRootDir %s\n", conf.SnapshotsConfig.RootDir)
@@ -853,6 +1013,8 @@ type expectedSnapshotOutput struct {
 	lastCommitHash      []byte
 	stateDBType         string
 	expectedBinaryFiles []string
+	// unsignedFiles are expected in the snapshot dir but not in the signable metadata
+	unsignedFiles []string
 }
 
 func verifySnapshotOutput(
@@ -867,7 +1029,10 @@ func verifySnapshotOutput(
 	snapshotDir := SnapshotDirForLedgerBlockNum(o.snapshotRootDir, o.ledgerID, o.lastBlockNumber)
 	files, err := ioutil.ReadDir(snapshotDir)
 	require.NoError(t, err)
-	require.Len(t, files, len(o.expectedBinaryFiles)+2) // + 2 JSON files
+	require.Len(t, files, len(o.expectedBinaryFiles)+len(o.unsignedFiles)+2) // + 2 JSON files
+	for _, f := range o.unsignedFiles {
+		require.FileExists(t, filepath.Join(snapshotDir, f))
+	}
 
 	filesAndHashes := map[string]string{}
 	for _, f := range o.expectedBinaryFiles {
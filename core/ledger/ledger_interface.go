@@ -7,8 +7,10 @@ SPDX-License-Identifier: Apache-2.0
 package ledger
 
 import (
+	"context"
 	"fmt"
 	"hash"
+	"net/http"
 	"time"
 
 	"github.com/golang/protobuf/proto"
@@ -35,9 +37,13 @@ type Initializer struct {
 	ChaincodeLifecycleEventProvider ChaincodeLifecycleEventProvider
 	MetricsProvider                 metrics.Provider
 	HealthCheckRegistry             HealthCheckRegistry
+	AdminHandlerRegistry            AdminHandlerRegistry
 	Config                          *Config
 	CustomTxProcessors              map[common.HeaderType]CustomTxProcessor
 	HashProvider                    HashProvider
+	HistoryViews                    []HistoryView
+	HistoryCommitListeners          []HistoryCommitListener
+	HistoryProjectors               []HistoryProjector
 }
 
 // Config is a structure used to configure a ledger provider.
@@ -125,6 +131,140 @@ type PrivateDataConfig struct {
 // HistoryDBConfig is a structure used to configure the transaction history database.
 type HistoryDBConfig struct {
 	Enabled bool
+	// IncludeInSnapshots indicates whether the history index is exported when generating a snapshot
+	// so that a peer bootstrapped from the snapshot has the history for the pre-snapshot blocks as well.
+	// The exported history is verified on import against its own manifest and is not covered by the snapshot
+	// hash, so the snapshot hash does not depend on this setting.
+	IncludeInSnapshots bool
+	// BackfillArchiveDir is the directory that contains, per channel, the history files of a snapshot generated
+	// by a peer with the complete history. For a ledger bootstrapped from a snapshot without history, if the
+	// sub-directory <BackfillArchiveDir>/<channelName> exists, the history for the pre-snapshot blocks is
+	// backfilled from it in the background while the history for the subsequent blocks is built as usual.
+	BackfillArchiveDir string
+	// FieldIndexes maps a namespace to the fields of the JSON values written to the namespace that are indexed, so
+	// that the history can be queried by the value of a field. A field is the name of a top-level field or a dot
+	// separated path to a nested field.
+	FieldIndexes map[string][]string
+	// FullTextSearchNamespaces are the namespaces whose written values are indexed for full-text search
+	FullTextSearchNamespaces []string
+	// GroupCommitMaxBlocks is the maximum number of blocks whose history writes are coalesced into a single write
+	// batch when the blocks are committed in quick succession, as is the case when the peer is catching up with the
+	// channel. A value of 0 or 1 disables the group commit.
+	GroupCommitMaxBlocks int
+	// GroupCommitFlushInterval is the maximum duration for which the history writes of a block are held back by the
+	// group commit. A block committed within this duration of the previous block is considered part of a catch-up.
+	GroupCommitFlushInterval time.Duration
+	// AsyncCommit indicates whether the blocks are committed to the history database in the background, so that the
+	// block commit is not gated on the history writes. The history queries reflect the blocks indexed so far.
+	AsyncCommit bool
+	// AsyncCommitMaxLag is the maximum number of blocks that are queued for the asynchronous history commit. Once
+	// the limit is reached, the block commit waits for the history commit to catch up.
+	AsyncCommitMaxLag int
+	// DecodedTxCacheSize is the maximum number of decoded transactions that are cached, per channel, for resolving
+	// the key modifications returned by the history queries. A value of 0 disables the cache.
+	DecodedTxCacheSize int
+	// DecodedTxCacheMaxBytes bounds the total size of the transactions held by the decoded transaction cache, as
+	// measured by the size of their serialized read-write sets. A value of 0 leaves the size unbounded.
+	DecodedTxCacheMaxBytes int
+	// DecodedTxCacheWarmUpBlocks is the number of the most recent blocks whose transactions are loaded into the
+	// decoded transaction cache, in the background, when the ledger is opened. A value of 0 disables the warmup.
+	DecodedTxCacheWarmUpBlocks int
+	// QueryPrefetchDepth is the number of history entries, per history query, whose transactions are retrieved from
+	// the block store in the background ahead of the consumer. A value of 0 disables the prefetch.
+	QueryPrefetchDepth int
+	// NoHistoryCacheSize is the maximum number of keys, per channel, that are remembered to have no history, so that
+	// the repeated history queries for such keys are answered without reading the history database. A value of 0
+	// disables the cache.
+	NoHistoryCacheSize int
+	// QueryResultCacheSize is the maximum number of keys, per channel, whose history query results are cached, so
+	// that the repeated history queries for the hot keys are answered without reading the history database until a
+	// block writes the key. QueryResultCacheMaxEntries is the maximum number of results cached for a key. A value of 0
+	// for either disables the cache.
+	QueryResultCacheSize       int
+	QueryResultCacheMaxEntries int
+	// SlowQueryThreshold and SlowQueryMinResults are the duration and the number of results at or beyond which a
+	// history query for a key is logged, on the logger "history.slowquery", along with its timing breakdown. A value
+	// of 0 disables the corresponding criterion.
+	SlowQueryThreshold  time.Duration
+	SlowQueryMinResults int
+	// MaxBatchRetrievals is the maximum number of transactions retrieved from the block store at a time, across the
+	// channels, for the bulk history queries, such as the scans and the exports, and BatchQueryMaxYield is the
+	// maximum duration for which such a retrieval waits for the in-flight retrievals of the interactive history
+	// queries to complete. The history queries issued by the chaincodes while endorsing a proposal take the priority
+	// set by the client in the gRPC metadata of the proposal request. A MaxBatchRetrievals of 0 disables the scheduling.
+	MaxBatchRetrievals int
+	BatchQueryMaxYield time.Duration
+	// DecodeWorkers is the number of goroutines that decode the transactions for the bulk scans of the history, such
+	// as the history export for a snapshot. A value of 0 or 1 decodes the transactions on the scanning goroutine.
+	DecodeWorkers int
+	// MaxOpenScanners is the maximum number of history query iterators that are open at a time across the channels.
+	// Once reached, the history queries fail until some iterators are closed. A value of 0 leaves it unlimited.
+	MaxOpenScanners int
+	// ScannerIdleTimeout is the duration after which a history query iterator that is neither advanced nor closed is
+	// released and logged along with the stack that created it. A value of 0 disables the leak detection.
+	ScannerIdleTimeout time.Duration
+	// QueryQuotaWindow is the window in which the usage of the history queries of each client is limited to
+	// QueryQuotaMaxEntries history entries scanned and QueryQuotaMaxBytes bytes of values returned. Only the queries
+	// via a `ClientHistoryQueryExecutorProvider`, such as the ones issued by the chaincodes while endorsing a proposal,
+	// are accounted, to the creator of the proposal. A limit of 0 leaves the corresponding usage unlimited.
+	QueryQuotaWindow     time.Duration
+	QueryQuotaMaxEntries int
+	QueryQuotaMaxBytes   int
+	// MaxResponseEntries and MaxResponseBytes cap the number of the results and the bytes of the values in a single
+	// response of the history queries that return all their results at once. A paged query returns a page truncated at
+	// the caps along with a continuation token, and an unpaged query fails. A value of 0 leaves the size uncapped.
+	MaxResponseEntries int
+	MaxResponseBytes   int
+	// DisabledNamespaces are the namespaces for which the history is not indexed. The history queries for these
+	// namespaces fail, as their history would be incomplete.
+	DisabledNamespaces []string
+	// NormalizedKeyNamespaces are the namespaces whose keys are indexed, and looked up by the history queries, in the
+	// Unicode Normalization Form C, so that the visually identical keys encoded differently share a single history.
+	// The history indexed before a namespace is added here is upgraded in the background to be normalized.
+	NormalizedKeyNamespaces []string
+	// Capabilities are the enabled features of the history database that change the on-disk format of the history
+	// or the semantics of the history queries, such as "ValueInlining". The peer fails to start if a capability is
+	// not supported by its version, or if the history of a channel has been indexed with such a capability.
+	Capabilities []string
+	// SkipUnchangedWriteNamespaces are the namespaces for which a write whose value is identical to the previous
+	// value of the key is not indexed, but counted in the statistics of the key instead.
+	SkipUnchangedWriteNamespaces []string
+	// DeltaEncodingNamespaces are the namespaces whose values stored inline in the history are stored as deltas
+	// against the previous values of the keys, with every DeltaEncodingCheckpointInterval-th version of a key stored
+	// in full. The delta encoding requires the capability "DeltaEncoding".
+	DeltaEncodingNamespaces         []string
+	DeltaEncodingCheckpointInterval int
+	// ValueSizeTrackingNamespaces are the namespaces for which the size of the value of each indexed write is
+	// recorded, so that the growth of the values of a key can be queried without retrieving the values.
+	ValueSizeTrackingNamespaces []string
+	// TransactionIndex enables indexing the keys written by each transaction by its block and transaction number, so
+	// that the keys written by a transaction are looked up without decoding the transaction. The history indexed
+	// before is added to the index by a rebuild of the history.
+	TransactionIndex bool
+	// HealthCheckMaxLag is the maximum number of blocks by which the history of a channel may lag behind the block
+	// store before the health check of the history, served by the operations endpoint, reports the history as not
+	// ready. A value of 0 disables the health check.
+	HealthCheckMaxLag int
+	// IncludeKeys and ExcludeKeys map a namespace to the patterns of the keys that are indexed and that are not indexed
+	// respectively, where `*` matches any sequence of characters and `?` matches any single character. The keys of
+	// the namespaces that are in neither map are all indexed.
+	IncludeKeys map[string][]string
+	ExcludeKeys map[string][]string
+	// CompactionWindowStart, specified as HH:MM in the local time, and CompactionWindowDuration are the daily
+	// maintenance window in which the history dropped in bulk, such as that of a removed channel, is compacted to
+	// reclaim the disk space. A duration of 0 disables the scheduled compaction.
+	CompactionWindowStart    string
+	CompactionWindowDuration time.Duration
+	// SizeSamplingInterval is the interval at which the on-disk size of the history index is sampled per namespace,
+	// so that the size reported in the index statistics is reconciled with the disk. A value of 0 disables sampling.
+	SizeSamplingInterval time.Duration
+	// MigrationTargetDir, if not empty, is the directory of the history database to which the history is migrated.
+	// The history is dual-written to this database, which indexes the keys of the MigrationNormalizedKeyNamespaces in
+	// the Unicode Normalization Form C, and the fraction MigrationShadowReadSampleRate of the history queries are
+	// compared against it, so that the migrated history is verified before the peer is switched over to it.
+	MigrationTargetDir               string
+	MigrationShadowReadSampleRate    float64
+	MigrationNormalizedKeyNamespaces []string
 }
 
 // SnapshotsConfig is a structure used to configure snapshot function
@@ -226,6 +366,16 @@ type PeerLedger interface {
 	CommitNotificationsChannel(done <-chan struct{}) (<-chan *CommitNotification, error)
 }
 
+// ClientHistoryQueryExecutorProvider is implemented by the ledgers that schedule the history queries as per the priority
+// carried by the context of a request and account the queries to the quotas of the client that issues them (see
+// `HistoryDBConfig`). The callers that serve the requests of the authenticated clients are expected to obtain the
+// history query executors via this interface, when implemented by the ledger, instead of `NewHistoryQueryExecutor`
+type ClientHistoryQueryExecutorProvider interface {
+	// NewHistoryQueryExecutorForClient gives handle to a history query executor for the queries issued in the
+	// context of the given request on behalf of the client with the given serialized identity
+	NewHistoryQueryExecutorForClient(ctx context.Context, creator []byte) (HistoryQueryExecutor, error)
+}
+
 // SimpleQueryExecutor encapsulates basic functions
 type SimpleQueryExecutor interface {
 	// GetState gets the value for given namespace and key. For a chaincode, the namespace corresponds to the chaincodeId
@@ -734,6 +884,12 @@ type HealthCheckRegistry interface {
 	RegisterChecker(string, healthz.HealthChecker) error
 }
 
+// AdminHandlerRegistry registers the HTTP handlers of the admin APIs of the ledger, such as the admin API of the
+// history. The registry is expected to serve a handler only to the authenticated clients
+type AdminHandlerRegistry interface {
+	RegisterAdminHandler(pattern string, handler http.Handler)
+}
+
 // ChaincodeLifecycleEventListener interface enables ledger components (mainly, intended for statedb)
 // to be able to listen to chaincode lifecycle events. 'dbArtifactsTar' represents db specific artifacts
 // (such as index specs) packaged in a tar. Note that this interface is redefined here (in addition to
@@ -785,6 +941,120 @@ type CustomTxProcessor interface {
 	GenerateSimulationResults(txEnvelop *common.Envelope, simulator TxSimulator, initializingLedger bool) error
 }
 
+// HistoryView is a user-defined materialized view over the history of the keys. For each key write by a
+// valid transaction, the function `Project` is invoked and the write is indexed under each of the returned rows.
+// The rows are maintained in the history database, in the same update batch as the history entries, and can be
+// queried via the ledger for the history entries indexed under a row, for instance, all the writes to the assets
+// owned by a particular owner, if `Project` returns the owner field of the asset.
+// `Project` should be deterministic, as the rows are recomputed when the history is rebuilt. An error returned by
+// `Project` fails the history commit of the block.
+type HistoryView interface {
+	// Name returns the name of the view, which is used to query the view. The name cannot be empty
+	// and cannot contain the byte 0x00
+	Name() string
+	// Project returns the rows, for the write of value to key in namespace ns, under which the write is indexed
+	// in the view. value is nil if isDelete is true. Returning no rows leaves the write out of the view
+	Project(ns, key string, value []byte, isDelete bool) ([]string, error)
+}
+
+// HistoryCommitListener allows a custom code to maintain additional indexes, in-process, over the writes committed to
+// the history database. For each block committed to the history database, the function `HandleHistoryCommit` is
+// invoked with the writes of the valid transactions of the block, in the order of the blocks and from the goroutine
+// committing the history, before the history entries of the block are written. An error returned by
+// `HandleHistoryCommit` fails the history commit of the block. As the history database commits again the blocks after
+// its savepoint when the peer restarts, a listener is expected to handle a block that it has already handled, for
+// instance, by tracking the last block it has handled.
+type HistoryCommitListener interface {
+	// Name returns the name of the listener, which cannot be empty
+	Name() string
+	// HandleHistoryCommit handles the writes of a block committed to the history database
+	HandleHistoryCommit(commit *HistoryCommit) error
+}
+
+// HistoryCommit captures the writes of the valid transactions of a block committed to the history database
+type HistoryCommit struct {
+	LedgerID string
+	BlockNum uint64
+	Txs      []*HistoryTxWrites
+}
+
+// HistoryTxWrites captures the writes of a transaction, in the order of its read-write set. The writes include those
+// to the namespaces and keys that are not indexed in the history
+type HistoryTxWrites struct {
+	TranNum   uint64
+	TxID      string
+	Timestamp time.Time
+	Writes    []*HistoryWrite
+}
+
+// HistoryWrite is a write to a key in a namespace
+type HistoryWrite struct {
+	Namespace string
+	Write     *kvrwset.KVWrite
+}
+
+// HistoryInvalidationListener is implemented by a HistoryCommitListener that derives data from the blocks committed to
+// the history database and is to correct the derived data when the history of the blocks already committed is
+// invalidated, i.e., when the history is rolled back or rebuilt, or when the ledger is rolled back or reset. As the
+// invalidations are made while the peer is stopped, they are recorded and delivered when the ledger is opened, before
+// the blocks are committed again to the history. An invalidation is delivered again if the peer stops before the
+// invalidation is delivered to all the listeners.
+type HistoryInvalidationListener interface {
+	// HandleHistoryInvalidation handles an invalidation of the history of a range of blocks
+	HandleHistoryInvalidation(invalidation *HistoryInvalidation) error
+}
+
+// HistoryInvalidation captures the range of blocks, FromBlock to ToBlock both inclusive, whose history is invalidated,
+// along with the cause of the invalidation, which is one of the HistoryInvalidationReason values
+type HistoryInvalidation struct {
+	LedgerID  string
+	FromBlock uint64
+	ToBlock   uint64
+	Reason    string
+}
+
+const (
+	// HistoryInvalidationReasonHistoryRollback is the reason of the invalidation by a rollback of the history, after
+	// which the blocks after the rollback height are committed again to the history
+	HistoryInvalidationReasonHistoryRollback = "history_rollback"
+	// HistoryInvalidationReasonHistoryRebuild is the reason of the invalidation by a rebuild of the history, which
+	// replaces the entries of the rebuilt blocks
+	HistoryInvalidationReasonHistoryRebuild = "history_rebuild"
+	// HistoryInvalidationReasonLedgerRollback is the reason of the invalidation by a rollback of the ledger, which
+	// removes the blocks after the rollback height
+	HistoryInvalidationReasonLedgerRollback = "ledger_rollback"
+	// HistoryInvalidationReasonLedgerReset is the reason of the invalidation by a reset of the ledger to the genesis
+	// block
+	HistoryInvalidationReasonLedgerReset = "ledger_reset"
+)
+
+// HistoryProjector derives a read model, such as the balances of the accounts or the rollups of the assets, from the
+// changes of the keys committed to the history database. For each ledger, the function `Apply` is invoked for each
+// block, in the order of the blocks, with the changes made by the valid transactions of the block, in the order of
+// the transactions and of their writes. The last block applied is recorded, per ledger, as the checkpoint of the
+// projector, so that a block is applied only once, unless replayed. A block may however be applied again if the peer
+// stops before the checkpoint is recorded. An error returned by `Apply` does not fail the commit of the block, but
+// suspends the projector for the ledger until the projection is replayed from a height, for which the function
+// `Reset` is invoked before the blocks starting from that height are applied again.
+type HistoryProjector interface {
+	// Name returns the name of the projector, which cannot be empty and cannot contain the byte 0x00
+	Name() string
+	// Reset discards the read model derived, for the ledger, from the blocks starting from fromBlock
+	Reset(ledgerID string, fromBlock uint64) error
+	// Apply applies the changes made by a block of the ledger to the read model
+	Apply(ledgerID string, blockNum uint64, changes []*HistoryKeyChange) error
+}
+
+// HistoryKeyChange is a change of a key by a transaction, as delivered to the history projectors
+type HistoryKeyChange struct {
+	Namespace string
+	Key       string
+	TranNum   uint64
+	TxID      string
+	Value     []byte
+	IsDelete  bool
+}
+
 // InvalidTxError is expected to be thrown by a custom transaction processor
 // if it wants the ledger to record a particular transaction as invalid
 type InvalidTxError struct {
@@ -8,6 +8,7 @@ package ledgermgmt
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"sync"
 
@@ -56,9 +57,13 @@ type Initializer struct {
 	ChaincodeLifecycleEventProvider ledger.ChaincodeLifecycleEventProvider
 	MetricsProvider                 metrics.Provider
 	HealthCheckRegistry             ledger.HealthCheckRegistry
+	AdminHandlerRegistry            ledger.AdminHandlerRegistry
 	Config                          *ledger.Config
 	HashProvider                    ledger.HashProvider
 	EbMetadataProvider              MetadataProvider
+	HistoryViews                    []ledger.HistoryView
+	HistoryCommitListeners          []ledger.HistoryCommitListener
+	HistoryProjectors               []ledger.HistoryProjector
 }
 
 // NewLedgerMgr creates a new LedgerMgr
@@ -76,9 +81,13 @@ func NewLedgerMgr(initializer *Initializer) *LedgerMgr {
 			ChaincodeLifecycleEventProvider: initializer.ChaincodeLifecycleEventProvider,
 			MetricsProvider:                 initializer.MetricsProvider,
 			HealthCheckRegistry:             initializer.HealthCheckRegistry,
+			AdminHandlerRegistry:            initializer.AdminHandlerRegistry,
 			Config:                          initializer.Config,
 			CustomTxProcessors:              initializer.CustomTxProcessors,
 			HashProvider:                    initializer.HashProvider,
+			HistoryViews:                    initializer.HistoryViews,
+			HistoryCommitListeners:          initializer.HistoryCommitListeners,
+			HistoryProjectors:               initializer.HistoryProjectors,
 		},
 	)
 	if err != nil {
@@ -285,6 +294,15 @@ func (l *closableLedger) Close() {
 	l.ledgerMgr.closeLedger(l.id)
 }
 
+// NewHistoryQueryExecutorForClient implements method in interface `ledger.ClientHistoryQueryExecutorProvider` by
+// delegating to the underlying ledger, if it implements the interface
+func (l *closableLedger) NewHistoryQueryExecutorForClient(ctx context.Context, creator []byte) (ledger.HistoryQueryExecutor, error) {
+	if p, ok := l.PeerLedger.(ledger.ClientHistoryQueryExecutorProvider); ok {
+		return p.NewHistoryQueryExecutorForClient(ctx, creator)
+	}
+	return l.PeerLedger.NewHistoryQueryExecutor()
+}
+
 // lscc namespace listener for chaincode instantiate transactions (which manipulates data in 'lscc' namespace)
 // this code should be later moved to peer and passed via `Initialize` function of ledgermgmt
 func addListenerForCCEventsHandler(
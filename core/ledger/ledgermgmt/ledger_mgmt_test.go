@@ -7,6 +7,7 @@ SPDX-License-Identifier: Apache-2.0
 package ledgermgmt
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -51,6 +52,13 @@ func TestLedgerMgmt(t *testing.T) {
 	_, err := ledgerMgr.OpenLedger(ledgerID)
 	require.Equal(t, ErrLedgerAlreadyOpened, err)
 
+	// the ledgers handed out by the ledger mgr serve the history queries on behalf of a client
+	clientHQEProvider, ok := ledgers[0].(ledger.ClientHistoryQueryExecutorProvider)
+	require.True(t, ok)
+	hqe, err := clientHQEProvider.NewHistoryQueryExecutorForClient(context.Background(), []byte("creator"))
+	require.NoError(t, err)
+	require.NotNil(t, hqe)
+
 	l := ledgers[2]
 	l.Close()
 	// attempt to close the same ledger twice and ensure it doesn't panic
@@ -0,0 +1,171 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package ledgermgmt
+
+import (
+	"sync"
+
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// ChannelKeyModification is a key modification along with the channel on which it was committed
+type ChannelKeyModification struct {
+	ChannelID string
+	*queryresult.KeyModification
+}
+
+// ChannelState is the committed value of a key on a channel
+type ChannelState struct {
+	ChannelID string
+	Value     []byte
+}
+
+// MultiChannelQuerier fans the history and state queries out across the ledgers of multiple channels and merges
+// the results, attributing each result to its channel. This is intended for the applications that shard their
+// data across channels. The queries on the individual channels are not coordinated and hence, the merged results
+// do not represent a consistent snapshot across the channels.
+type MultiChannelQuerier struct {
+	channelIDs []string
+	ledgers    []ledger.PeerLedger
+}
+
+// NewMultiChannelQuerier returns a MultiChannelQuerier for the given channels. The ledgers of all the channels
+// are expected to be opened. The results are attributed to the channels in the order of the channelIDs passed
+func (m *LedgerMgr) NewMultiChannelQuerier(channelIDs ...string) (*MultiChannelQuerier, error) {
+	if len(channelIDs) == 0 {
+		return nil, errors.New("at least one channel is required for a multi-channel query")
+	}
+	q := &MultiChannelQuerier{}
+	seen := map[string]struct{}{}
+	for _, channelID := range channelIDs {
+		if _, ok := seen[channelID]; ok {
+			return nil, errors.Errorf("channel [%s] is specified more than once", channelID)
+		}
+		seen[channelID] = struct{}{}
+		l, err := m.getOpenedLedger(channelID)
+		if err != nil {
+			return nil, err
+		}
+		q.channelIDs = append(q.channelIDs, channelID)
+		q.ledgers = append(q.ledgers, l)
+	}
+	return q, nil
+}
+
+// GetHistoryForKey returns the history of the key across the channels, newest first. The history of a key on a
+// channel retains its order, the histories of the different channels are interleaved by the transaction timestamps
+func (q *MultiChannelQuerier) GetHistoryForKey(namespace, key string) ([]*ChannelKeyModification, error) {
+	histories := make([][]*queryresult.KeyModification, len(q.ledgers))
+	err := q.fanOut(func(i int, l ledger.PeerLedger) error {
+		qe, err := l.NewHistoryQueryExecutor()
+		if err != nil {
+			return err
+		}
+		if qe == nil {
+			return errors.New("history database not enabled")
+		}
+		itr, err := qe.GetHistoryForKey(namespace, key)
+		if err != nil {
+			return err
+		}
+		defer itr.Close()
+		for {
+			res, err := itr.Next()
+			if err != nil {
+				return err
+			}
+			if res == nil {
+				return nil
+			}
+			histories[i] = append(histories[i], res.(*queryresult.KeyModification))
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return q.mergeHistories(histories), nil
+}
+
+// GetState returns the committed value of the key on each of the channels where the key exists,
+// in the order of the channels
+func (q *MultiChannelQuerier) GetState(namespace, key string) ([]*ChannelState, error) {
+	values := make([][]byte, len(q.ledgers))
+	err := q.fanOut(func(i int, l ledger.PeerLedger) error {
+		qe, err := l.NewQueryExecutor()
+		if err != nil {
+			return err
+		}
+		defer qe.Done()
+		values[i], err = qe.GetState(namespace, key)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	var states []*ChannelState
+	for i, v := range values {
+		if v != nil {
+			states = append(states, &ChannelState{ChannelID: q.channelIDs[i], Value: v})
+		}
+	}
+	return states, nil
+}
+
+// fanOut invokes the function query concurrently for each of the ledgers and returns the first error encountered
+func (q *MultiChannelQuerier) fanOut(query func(i int, l ledger.PeerLedger) error) error {
+	errs := make([]error, len(q.ledgers))
+	var wg sync.WaitGroup
+	for i, l := range q.ledgers {
+		wg.Add(1)
+		go func(i int, l ledger.PeerLedger) {
+			defer wg.Done()
+			errs[i] = query(i, l)
+		}(i, l)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			return errors.WithMessagef(err, "error while querying channel [%s]", q.channelIDs[i])
+		}
+	}
+	return nil
+}
+
+// mergeHistories merges the per-channel histories, each newest first, by repeatedly picking the head with the
+// latest timestamp. A tie is resolved in favor of the channel that appears first
+func (q *MultiChannelQuerier) mergeHistories(histories [][]*queryresult.KeyModification) []*ChannelKeyModification {
+	var merged []*ChannelKeyModification
+	for {
+		next := -1
+		for i, h := range histories {
+			if len(h) == 0 {
+				continue
+			}
+			if next == -1 || timestampAfter(h[0].Timestamp, histories[next][0].Timestamp) {
+				next = i
+			}
+		}
+		if next == -1 {
+			return merged
+		}
+		merged = append(merged, &ChannelKeyModification{
+			ChannelID:       q.channelIDs[next],
+			KeyModification: histories[next][0],
+		})
+		histories[next] = histories[next][1:]
+	}
+}
+
+func timestampAfter(a, b *timestamppb.Timestamp) bool {
+	if a.GetSeconds() != b.GetSeconds() {
+		return a.GetSeconds() > b.GetSeconds()
+	}
+	return a.GetNanos() > b.GetNanos()
+}
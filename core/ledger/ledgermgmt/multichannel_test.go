@@ -0,0 +1,112 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package ledgermgmt
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/hyperledger/fabric/common/ledger/testutil"
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestMultiChannelQuerier(t *testing.T) {
+	_, ledgerMgr, cleanup := setup(t)
+	defer cleanup()
+
+	generators := map[string]*testutil.BlockGenerator{}
+	ledgers := map[string]ledger.PeerLedger{}
+	for _, channelID := range []string{"ch1", "ch2", "ch3"} {
+		bg, gb := testutil.NewBlockGenerator(t, channelID, false)
+		l, err := ledgerMgr.CreateLedger(channelID, gb)
+		require.NoError(t, err)
+		generators[channelID], ledgers[channelID] = bg, l
+	}
+	commit := func(channelID, value string) {
+		simulator, err := ledgers[channelID].NewTxSimulator(util.GenerateUUID())
+		require.NoError(t, err)
+		require.NoError(t, simulator.SetState("ns1", "key1", []byte(value)))
+		simulator.Done()
+		simRes, err := simulator.GetTxSimulationResults()
+		require.NoError(t, err)
+		pubSimBytes, err := simRes.GetPubSimulationBytes()
+		require.NoError(t, err)
+		block := generators[channelID].NextBlock([][]byte{pubSimBytes})
+		require.NoError(t, ledgers[channelID].CommitLegacy(&ledger.BlockAndPvtData{Block: block}, &ledger.CommitOptions{}))
+	}
+	commit("ch1", "ch1-v1")
+	commit("ch2", "ch2-v1")
+	commit("ch1", "ch1-v2")
+
+	q, err := ledgerMgr.NewMultiChannelQuerier("ch1", "ch2", "ch3")
+	require.NoError(t, err)
+
+	states, err := q.GetState("ns1", "key1")
+	require.NoError(t, err)
+	require.Equal(t,
+		[]*ChannelState{
+			{ChannelID: "ch1", Value: []byte("ch1-v2")},
+			{ChannelID: "ch2", Value: []byte("ch2-v1")},
+		},
+		states,
+	)
+
+	history, err := q.GetHistoryForKey("ns1", "key1")
+	require.NoError(t, err)
+	var actual []string
+	for _, h := range history {
+		actual = append(actual, h.ChannelID+":"+string(h.Value))
+	}
+	require.Equal(t, []string{"ch1:ch1-v2", "ch2:ch2-v1", "ch1:ch1-v1"}, actual)
+
+	_, err = ledgerMgr.NewMultiChannelQuerier()
+	require.EqualError(t, err, "at least one channel is required for a multi-channel query")
+	_, err = ledgerMgr.NewMultiChannelQuerier("ch1", "ch1")
+	require.EqualError(t, err, "channel [ch1] is specified more than once")
+	_, err = ledgerMgr.NewMultiChannelQuerier("ch1", "ch4")
+	require.EqualError(t, err, "Ledger not opened [ch4]")
+
+	q.ledgers[2] = &erroringLedger{PeerLedger: q.ledgers[2]}
+	_, err = q.GetState("ns1", "key1")
+	require.EqualError(t, err, "error while querying channel [ch3]: query-error")
+	_, err = q.GetHistoryForKey("ns1", "key1")
+	require.EqualError(t, err, "error while querying channel [ch3]: query-error")
+}
+
+type erroringLedger struct {
+	ledger.PeerLedger
+}
+
+func (l *erroringLedger) NewQueryExecutor() (ledger.QueryExecutor, error) {
+	return nil, errors.New("query-error")
+}
+
+func (l *erroringLedger) NewHistoryQueryExecutor() (ledger.HistoryQueryExecutor, error) {
+	return nil, errors.New("query-error")
+}
+
+func TestMergeHistories(t *testing.T) {
+	q := &MultiChannelQuerier{channelIDs: []string{"ch1", "ch2"}}
+	modification := func(txID string, seconds int64) *queryresult.KeyModification {
+		return &queryresult.KeyModification{TxId: txID, Timestamp: &timestamppb.Timestamp{Seconds: seconds}}
+	}
+	merged := q.mergeHistories([][]*queryresult.KeyModification{
+		// the history of a channel retains its order even if the timestamps are not in order
+		{modification("tx1", 5), modification("tx2", 7), modification("tx3", 1)},
+		{modification("tx4", 5), modification("tx5", 3)},
+	})
+	var actual []string
+	for _, m := range merged {
+		actual = append(actual, m.ChannelID+":"+m.TxId)
+	}
+	require.Equal(t, []string{"ch1:tx1", "ch1:tx2", "ch2:tx4", "ch2:tx5", "ch1:tx3"}, actual)
+	require.Nil(t, q.mergeHistories([][]*queryresult.KeyModification{nil, nil}))
+}
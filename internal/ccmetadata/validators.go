@@ -7,6 +7,7 @@ SPDX-License-Identifier: Apache-2.0
 package ccmetadata
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"path/filepath"
@@ -25,10 +26,16 @@ type fileValidator func(fileName string, fileBytes []byte) error
 // AllowedCharsCollectionName captures the regex pattern for a valid collection name
 const AllowedCharsCollectionName = "[A-Za-z0-9_-]+"
 
-// Currently, the only metadata expected and allowed is for META-INF/statedb/couchdb/indexes.
+// HistoryIndexingManifestPath is the path, in the chaincode package, of the manifest that declares the history
+// indexing hints of the chaincode
+const HistoryIndexingManifestPath = "META-INF/history/indexing.json"
+
+// Currently, the only metadata expected and allowed is for META-INF/statedb/couchdb/indexes and the history
+// indexing manifest.
 var fileValidators = map[*regexp.Regexp]fileValidator{
 	regexp.MustCompile("^META-INF/statedb/couchdb/indexes/.*[.]json"):                                                couchdbIndexFileValidator,
 	regexp.MustCompile("^META-INF/statedb/couchdb/collections/" + AllowedCharsCollectionName + "/indexes/.*[.]json"): couchdbIndexFileValidator,
+	regexp.MustCompile("^" + regexp.QuoteMeta(HistoryIndexingManifestPath) + "$"):                                    historyIndexingManifestValidator,
 }
 
 var collectionNameValid = regexp.MustCompile("^" + AllowedCharsCollectionName)
@@ -79,6 +86,9 @@ func ValidateMetadataFile(filePathName string, fileBytes []byte) error {
 func buildMetadataFileErrorMessage(filePathName string) string {
 	dir, filename := filepath.Split(filePathName)
 
+	if strings.HasPrefix(filePathName, "META-INF/history") {
+		return fmt.Sprintf("history metadata file must be %s, found: %s", HistoryIndexingManifestPath, filePathName)
+	}
 	if !strings.HasPrefix(filePathName, "META-INF/statedb") {
 		return fmt.Sprintf("metadata file path must begin with META-INF/statedb, found: %s", dir)
 	}
@@ -152,6 +162,33 @@ func couchdbIndexFileValidator(fileName string, fileBytes []byte) error {
 	return nil
 }
 
+// historyIndexingManifest mirrors the history indexing manifest, as parsed by the ledger, for validating
+// the manifest when the chaincode is packaged
+type historyIndexingManifest struct {
+	IncludeKeys  []string `json:"includeKeys"`
+	ExcludeKeys  []string `json:"excludeKeys"`
+	FieldIndexes []string `json:"fieldIndexes"`
+	InlineValues bool     `json:"inlineValues"`
+}
+
+// historyIndexingManifestValidator implements fileValidator
+func historyIndexingManifestValidator(fileName string, fileBytes []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(fileBytes))
+	decoder.DisallowUnknownFields()
+	manifest := &historyIndexingManifest{}
+	if err := decoder.Decode(manifest); err != nil {
+		return &InvalidIndexContentError{fmt.Sprintf("History indexing manifest [%s] is not valid: %s", fileName, err)}
+	}
+	for _, entries := range [][]string{manifest.IncludeKeys, manifest.ExcludeKeys, manifest.FieldIndexes} {
+		for _, entry := range entries {
+			if entry == "" || strings.Contains(entry, "\x00") {
+				return &InvalidIndexContentError{fmt.Sprintf("History indexing manifest [%s] is not valid: the key patterns and the fields cannot be empty or contain the byte 0x00", fileName)}
+			}
+		}
+	}
+	return nil
+}
+
 // isJSON tests a string to determine if it can be parsed as valid JSON
 func isJSON(s []byte) (bool, map[string]interface{}) {
 	var js map[string]interface{}
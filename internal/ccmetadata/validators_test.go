@@ -291,3 +291,21 @@ func cleanupDir(dir string) error {
 	}
 	return os.Mkdir(dir, os.ModePerm)
 }
+
+func TestHistoryIndexingManifest(t *testing.T) {
+	fileBytes := []byte(`{"includeKeys":["asset~*"],"excludeKeys":["lock~*"],"fieldIndexes":["owner"],"inlineValues":true}`)
+	require.NoError(t, ValidateMetadataFile(HistoryIndexingManifestPath, fileBytes))
+
+	for _, fileBytes := range [][]byte{
+		[]byte("invalid json"),
+		[]byte(`{"unknownField":true}`),
+		[]byte(`{"fieldIndexes":[""]}`),
+	} {
+		err := ValidateMetadataFile(HistoryIndexingManifestPath, fileBytes)
+		require.IsType(t, &InvalidIndexContentError{}, err)
+	}
+
+	err := ValidateMetadataFile("META-INF/history/other.json", []byte("{}"))
+	require.EqualError(t, err, "history metadata file must be META-INF/history/indexing.json, found: META-INF/history/other.json")
+	require.IsType(t, &UnhandledDirectoryError{}, err)
+}
@@ -0,0 +1,397 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package exporthistory
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/hyperledger/fabric/internal/cryptogen/csp"
+	"github.com/pkg/errors"
+)
+
+// FormatAudit is the canonical binary format of an audit bundle. An audit bundle starts with the auditMagic,
+// followed by a sequence of frames, each being uvarint(len(frame)) followed by the frame. The first byte of a
+// frame is its type, i.e., a header frame, a record frame per history entry, and a trailer frame at the end.
+// The fields of a frame are encoded as uvarint(len(field)) followed by the field for the strings and the bytes, as
+// big-endian 8 bytes for the numbers, and as a single byte for the booleans. The records are in the order of the
+// history index, i.e., by key and, for a key, by block and transaction number. A running digest covers the header
+// and the records, in order, where the digest after a frame is SHA-256(previous digest || frame), starting from an
+// empty previous digest. The trailer holds the number of records, the final digest and, if signed, the PEM encoded
+// certificate of the signer and its ECDSA signature over the final digest. Hence, the bundles exported by two peers
+// with the same history for the same filter are identical up to the signer and the signature, and the final digest
+// can be compared in place of the bundles.
+const FormatAudit = "audit"
+
+var auditMagic = []byte("FABRIC-HISTORY-AUDIT-V1\n")
+
+const (
+	auditHeaderFrame  byte = 'H'
+	auditRecordFrame  byte = 'R'
+	auditTrailerFrame byte = 'T'
+	// maxAuditFrameSize bounds the size of a frame while reading a bundle, so that a corrupted length does not
+	// cause an unbounded allocation
+	maxAuditFrameSize = 1 << 30
+)
+
+// AuditSigner signs the audit bundles, typically with the signing identity of the peer exporting the history
+type AuditSigner struct {
+	// Cert is the PEM encoded x509 certificate of the signer
+	Cert   []byte
+	signer *csp.ECDSASigner
+}
+
+// LoadAuditSigner loads the signer from an MSP directory, i.e., the certificate in the `signcerts` and the EC private
+// key in the `keystore` subdirectories, as is the layout of the local MSP of a peer
+func LoadAuditSigner(mspDir string) (*AuditSigner, error) {
+	certs, err := filepath.Glob(filepath.Join(mspDir, "signcerts", "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) != 1 {
+		return nil, errors.Errorf("expected a single signing certificate in %s, found %d", filepath.Join(mspDir, "signcerts"), len(certs))
+	}
+	cert, err := os.ReadFile(certs[0])
+	if err != nil {
+		return nil, err
+	}
+	if _, err := parseAuditCert(cert); err != nil {
+		return nil, err
+	}
+	key, err := csp.LoadPrivateKey(filepath.Join(mspDir, "keystore"))
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, errors.Errorf("no private key found in %s", filepath.Join(mspDir, "keystore"))
+	}
+	return &AuditSigner{Cert: cert, signer: &csp.ECDSASigner{PrivateKey: key}}, nil
+}
+
+// AuditBundle is the summary of an audit bundle, as verified by function `VerifyAuditBundle`
+type AuditBundle struct {
+	ChannelID   string
+	Filter      *Filter
+	NumRecords  uint64
+	FinalDigest []byte
+	// SignerCert is the PEM encoded certificate of the signer, or nil if the bundle is not signed. The signature is
+	// verified against this certificate; whether the certificate is trusted is for the auditor to decide
+	SignerCert []byte
+}
+
+type auditRecordWriter struct {
+	file    *os.File
+	writer  *bufio.Writer
+	digest  []byte
+	count   uint64
+	signer  *AuditSigner
+	scratch []byte
+}
+
+func newAuditRecordWriter(filePath, channelID string, filter *Filter, signer *AuditSigner) (*auditRecordWriter, error) {
+	f, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	w := &auditRecordWriter{
+		file:   f,
+		writer: bufio.NewWriter(f),
+		signer: signer,
+	}
+	if _, err := w.writer.Write(auditMagic); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := w.writeFrame(encodeAuditHeader(channelID, filter), true); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *auditRecordWriter) write(r *historyRecord) error {
+	w.count++
+	return w.writeFrame(r.toAuditFrame(w.scratch[:0]), true)
+}
+
+func (w *auditRecordWriter) close() error {
+	trailer := []byte{auditTrailerFrame}
+	trailer = appendAuditUint64(trailer, w.count)
+	trailer = appendAuditBytes(trailer, w.digest)
+	var cert, signature []byte
+	if w.signer != nil {
+		var err error
+		if signature, err = w.signer.signer.Sign(rand.Reader, w.digest, nil); err != nil {
+			w.file.Close()
+			return errors.Wrap(err, "error while signing the audit bundle")
+		}
+		cert = w.signer.Cert
+	}
+	trailer = appendAuditBytes(trailer, cert)
+	trailer = appendAuditBytes(trailer, signature)
+	if err := w.writeFrame(trailer, false); err != nil {
+		w.file.Close()
+		return err
+	}
+	if err := w.writer.Flush(); err != nil {
+		w.file.Close()
+		return err
+	}
+	if err := w.file.Sync(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+// writeFrame writes the length delimited frame and, if digested, advances the running digest over the frame
+func (w *auditRecordWriter) writeFrame(frame []byte, digested bool) error {
+	var lenBuf [binary.MaxVarintLen64]byte
+	if _, err := w.writer.Write(lenBuf[:binary.PutUvarint(lenBuf[:], uint64(len(frame)))]); err != nil {
+		return err
+	}
+	if _, err := w.writer.Write(frame); err != nil {
+		return err
+	}
+	if digested {
+		w.digest = nextAuditDigest(w.digest, frame)
+	}
+	w.scratch = frame
+	return nil
+}
+
+// VerifyAuditBundle reads the audit bundle at filePath, recomputes the running digest over its frames and, if the
+// bundle is signed, verifies the signature against the certificate in the bundle. This requires neither the peer
+// nor its ledger and hence, is intended for the offline verification of an exported bundle
+func VerifyAuditBundle(filePath string) (*AuditBundle, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+
+	magic := make([]byte, len(auditMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || !bytes.Equal(magic, auditMagic) {
+		return nil, errors.Errorf("%s is not an audit bundle", filePath)
+	}
+	frame, err := readAuditFrame(r)
+	if err != nil {
+		return nil, err
+	}
+	bundle, err := decodeAuditHeader(frame)
+	if err != nil {
+		return nil, err
+	}
+	digest := nextAuditDigest(nil, frame)
+
+	numRecords := uint64(0)
+	for {
+		frame, err := readAuditFrame(r)
+		if err != nil {
+			return nil, err
+		}
+		if frame[0] == auditRecordFrame {
+			numRecords++
+			digest = nextAuditDigest(digest, frame)
+			continue
+		}
+		if frame[0] != auditTrailerFrame {
+			return nil, errors.Errorf("unexpected frame type [%d] after %d records", frame[0], numRecords)
+		}
+		d := &auditDecoder{buf: frame[1:]}
+		count, finalDigest, cert, signature := d.uint64(), d.bytes(), d.bytes(), d.bytes()
+		if d.err != nil {
+			return nil, errors.WithMessage(d.err, "invalid trailer")
+		}
+		if count != numRecords {
+			return nil, errors.Errorf("the trailer records %d records, but the bundle contains %d", count, numRecords)
+		}
+		if !bytes.Equal(finalDigest, digest) {
+			return nil, errors.Errorf("the digest in the trailer [%x] does not match the digest of the records [%x]", finalDigest, digest)
+		}
+		if len(cert) != 0 || len(signature) != 0 {
+			if err := verifyAuditSignature(cert, digest, signature); err != nil {
+				return nil, err
+			}
+			bundle.SignerCert = cert
+		}
+		if _, err := r.ReadByte(); err != io.EOF {
+			return nil, errors.New("unexpected data after the trailer")
+		}
+		bundle.NumRecords = numRecords
+		bundle.FinalDigest = digest
+		return bundle, nil
+	}
+}
+
+func verifyAuditSignature(cert, digest, signature []byte) error {
+	x509Cert, err := parseAuditCert(cert)
+	if err != nil {
+		return err
+	}
+	publicKey, ok := x509Cert.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return errors.New("the certificate of the signer does not hold an ECDSA public key")
+	}
+	if !ecdsa.VerifyASN1(publicKey, digest, signature) {
+		return errors.New("the signature of the audit bundle is not valid")
+	}
+	return nil
+}
+
+func parseAuditCert(cert []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(cert)
+	if block == nil {
+		return nil, errors.New("the certificate of the signer is not PEM encoded")
+	}
+	x509Cert, err := x509.ParseCertificate(block.Bytes)
+	return x509Cert, errors.Wrap(err, "error while parsing the certificate of the signer")
+}
+
+func readAuditFrame(r *bufio.Reader) ([]byte, error) {
+	frameLen, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "error while reading the audit bundle, the bundle may be truncated")
+	}
+	if frameLen == 0 || frameLen > maxAuditFrameSize {
+		return nil, errors.Errorf("invalid frame length [%d]", frameLen)
+	}
+	frame := make([]byte, frameLen)
+	if _, err := io.ReadFull(r, frame); err != nil {
+		return nil, errors.Wrap(err, "error while reading the audit bundle, the bundle may be truncated")
+	}
+	return frame, nil
+}
+
+func nextAuditDigest(digest, frame []byte) []byte {
+	h := sha256.New()
+	h.Write(digest)
+	h.Write(frame)
+	return h.Sum(nil)
+}
+
+func encodeAuditHeader(channelID string, filter *Filter) []byte {
+	b := []byte{auditHeaderFrame}
+	b = appendAuditBytes(b, []byte(channelID))
+	b = appendAuditBytes(b, []byte(filter.Namespace))
+	b = appendAuditBytes(b, []byte(filter.Key))
+	b = appendAuditUint64(b, filter.StartBlock)
+	b = appendAuditUint64(b, filter.EndBlock)
+	return appendAuditBool(b, filter.DeletesOnly)
+}
+
+func decodeAuditHeader(frame []byte) (*AuditBundle, error) {
+	if frame[0] != auditHeaderFrame {
+		return nil, errors.Errorf("unexpected frame type [%d], expected the header", frame[0])
+	}
+	d := &auditDecoder{buf: frame[1:]}
+	bundle := &AuditBundle{
+		ChannelID: string(d.bytes()),
+		Filter: &Filter{
+			Namespace:   string(d.bytes()),
+			Key:         string(d.bytes()),
+			StartBlock:  d.uint64(),
+			EndBlock:    d.uint64(),
+			DeletesOnly: d.bool(),
+		},
+	}
+	if d.err != nil {
+		return nil, errors.WithMessage(d.err, "invalid header")
+	}
+	return bundle, nil
+}
+
+// toAuditFrame appends the record frame to buf. The timestamp is encoded as its seconds and nanoseconds since the
+// epoch, instead of the formatted timestamp of the other formats, so that the encoding does not depend on the
+// time formatting
+func (r *historyRecord) toAuditFrame(buf []byte) []byte {
+	b := append(buf, auditRecordFrame)
+	b = appendAuditBytes(b, []byte(r.Namespace))
+	b = appendAuditBytes(b, []byte(r.Key))
+	b = appendAuditUint64(b, r.BlockNum)
+	b = appendAuditUint64(b, r.TxNum)
+	b = appendAuditBytes(b, []byte(r.TxID))
+	b = appendAuditUint64(b, uint64(r.timestampSeconds))
+	b = appendAuditUint64(b, uint64(r.timestampNanos))
+	b = appendAuditBool(b, r.IsDelete)
+	return appendAuditBytes(b, []byte(r.Value))
+}
+
+func appendAuditBytes(b, field []byte) []byte {
+	var lenBuf [binary.MaxVarintLen64]byte
+	b = append(b, lenBuf[:binary.PutUvarint(lenBuf[:], uint64(len(field)))]...)
+	return append(b, field...)
+}
+
+func appendAuditUint64(b []byte, n uint64) []byte {
+	var nBuf [8]byte
+	binary.BigEndian.PutUint64(nBuf[:], n)
+	return append(b, nBuf[:]...)
+}
+
+func appendAuditBool(b []byte, v bool) []byte {
+	if v {
+		return append(b, 1)
+	}
+	return append(b, 0)
+}
+
+// auditDecoder decodes the fields of a frame, recording the first error encountered
+type auditDecoder struct {
+	buf []byte
+	err error
+}
+
+func (d *auditDecoder) bytes() []byte {
+	if d.err != nil {
+		return nil
+	}
+	n, consumed := binary.Uvarint(d.buf)
+	if consumed <= 0 || uint64(len(d.buf)-consumed) < n {
+		d.err = errors.New("insufficient bytes for a field")
+		return nil
+	}
+	field := d.buf[consumed : consumed+int(n)]
+	d.buf = d.buf[consumed+int(n):]
+	return field
+}
+
+func (d *auditDecoder) uint64() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	if len(d.buf) < 8 {
+		d.err = errors.New("insufficient bytes for a number")
+		return 0
+	}
+	n := binary.BigEndian.Uint64(d.buf)
+	d.buf = d.buf[8:]
+	return n
+}
+
+func (d *auditDecoder) bool() bool {
+	if d.err != nil {
+		return false
+	}
+	if len(d.buf) < 1 {
+		d.err = errors.New("insufficient bytes for a boolean")
+		return false
+	}
+	v := d.buf[0] == 1
+	d.buf = d.buf[1:]
+	return v
+}
@@ -0,0 +1,129 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package exporthistory
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/common/ledger/testutil"
+	"github.com/hyperledger/fabric/internal/cryptogen/csp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditBundle(t *testing.T) {
+	fsDir := t.TempDir()
+	require.NoError(t, testutil.CopyDir(SampleFileSystemDir, fsDir, false))
+	buildHistory(t, fsDir, "mychannel")
+	filter := &Filter{Namespace: "marbles", StartBlock: 1}
+
+	t.Run("deterministic", func(t *testing.T) {
+		outputFile1, count, err := ExportHistory(fsDir, "mychannel", filter, FormatAudit, t.TempDir())
+		require.NoError(t, err)
+		require.NotZero(t, count)
+		require.Equal(t, "mychannel_marbles_history.audit", filepath.Base(outputFile1))
+		outputFile2, _, err := ExportHistory(fsDir, "mychannel", filter, FormatAudit, t.TempDir())
+		require.NoError(t, err)
+
+		bundle1, err := os.ReadFile(outputFile1)
+		require.NoError(t, err)
+		bundle2, err := os.ReadFile(outputFile2)
+		require.NoError(t, err)
+		require.Equal(t, bundle1, bundle2)
+
+		bundle, err := VerifyAuditBundle(outputFile1)
+		require.NoError(t, err)
+		require.Equal(t, "mychannel", bundle.ChannelID)
+		require.Equal(t, filter, bundle.Filter)
+		require.Equal(t, uint64(count), bundle.NumRecords)
+		require.Len(t, bundle.FinalDigest, 32)
+		require.Nil(t, bundle.SignerCert)
+	})
+
+	t.Run("signed", func(t *testing.T) {
+		signer, err := LoadAuditSigner(newTestMSPDir(t))
+		require.NoError(t, err)
+		outputFile1, count, err := ExportAuditBundle(fsDir, "mychannel", filter, signer, t.TempDir())
+		require.NoError(t, err)
+		outputFile2, _, err := ExportHistory(fsDir, "mychannel", filter, FormatAudit, t.TempDir())
+		require.NoError(t, err)
+
+		signed, err := VerifyAuditBundle(outputFile1)
+		require.NoError(t, err)
+		require.Equal(t, signer.Cert, signed.SignerCert)
+		require.Equal(t, uint64(count), signed.NumRecords)
+		unsigned, err := VerifyAuditBundle(outputFile2)
+		require.NoError(t, err)
+		require.Equal(t, unsigned.FinalDigest, signed.FinalDigest)
+	})
+
+	t.Run("tampered", func(t *testing.T) {
+		signer, err := LoadAuditSigner(newTestMSPDir(t))
+		require.NoError(t, err)
+		outputFile, _, err := ExportAuditBundle(fsDir, "mychannel", filter, signer, t.TempDir())
+		require.NoError(t, err)
+		original, err := os.ReadFile(outputFile)
+		require.NoError(t, err)
+
+		// flip a byte of the first record
+		tampered := append([]byte(nil), original...)
+		recordStart := len(auditMagic) + 1 + len(encodeAuditHeader("mychannel", filter))
+		tampered[recordStart+30] ^= 0xff
+		require.NoError(t, os.WriteFile(outputFile, tampered, 0o644))
+		_, err = VerifyAuditBundle(outputFile)
+		require.ErrorContains(t, err, "does not match the digest of the records")
+
+		// truncate the trailer
+		require.NoError(t, os.WriteFile(outputFile, original[:len(original)-10], 0o644))
+		_, err = VerifyAuditBundle(outputFile)
+		require.ErrorContains(t, err, "the bundle may be truncated")
+
+		// corrupt the signature, which is the last field of the trailer
+		tampered = append([]byte(nil), original...)
+		tampered[len(tampered)-5] ^= 0xff
+		require.NoError(t, os.WriteFile(outputFile, tampered, 0o644))
+		_, err = VerifyAuditBundle(outputFile)
+		require.EqualError(t, err, "the signature of the audit bundle is not valid")
+
+		require.NoError(t, os.WriteFile(outputFile, []byte("not an audit bundle"), 0o644))
+		_, err = VerifyAuditBundle(outputFile)
+		require.EqualError(t, err, outputFile+" is not an audit bundle")
+	})
+
+	t.Run("invalid-msp", func(t *testing.T) {
+		_, err := LoadAuditSigner(t.TempDir())
+		require.ErrorContains(t, err, "expected a single signing certificate in")
+	})
+}
+
+// newTestMSPDir creates an MSP directory with a self-signed signing certificate and its private key
+func newTestMSPDir(t *testing.T) string {
+	mspDir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(mspDir, "keystore"), 0o755))
+	key, err := csp.GeneratePrivateKey(filepath.Join(mspDir, "keystore"))
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "peer0.org1.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public().(*ecdsa.PublicKey), key)
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(filepath.Join(mspDir, "signcerts"), 0o755))
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	require.NoError(t, os.WriteFile(filepath.Join(mspDir, "signcerts", "cert.pem"), cert, 0o644))
+	return mspDir
+}
@@ -0,0 +1,301 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package exporthistory
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/common/ledger/blkstorage"
+	"github.com/hyperledger/fabric/common/metrics/disabled"
+	"github.com/hyperledger/fabric/core/ledger/kvledger"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/history"
+	"github.com/hyperledger/fabric/internal/fileutil"
+	"github.com/hyperledger/fabric/internal/ledgerutil/jsonrw"
+	"github.com/pkg/errors"
+)
+
+const (
+	ledgersDataDirName = "ledgersData"
+
+	// FormatJSON and FormatCSV are the supported output formats, along with FormatAudit
+	FormatJSON = "json"
+	FormatCSV  = "csv"
+)
+
+// Filter specifies the history entries to be exported
+type Filter struct {
+	Namespace string
+	// Key, if not empty, restricts the export to the history of the given key
+	Key string
+	// StartBlock and EndBlock restrict the export to the entries within the block range, both inclusive.
+	// An EndBlock of 0 implies no upper limit
+	StartBlock uint64
+	EndBlock   uint64
+	// DeletesOnly restricts the export to the delete markers
+	DeletesOnly bool
+}
+
+// historyRecord represents a history entry in the output
+type historyRecord struct {
+	Namespace string `json:"namespace"`
+	Key       string `json:"key"`
+	BlockNum  uint64 `json:"blockNum"`
+	TxNum     uint64 `json:"txNum"`
+	TxID      string `json:"txId"`
+	Timestamp string `json:"timestamp"`
+	IsDelete  bool   `json:"isDelete"`
+	Value     string `json:"value"`
+	// timestampSeconds and timestampNanos are the timestamp as encoded in the audit format
+	timestampSeconds int64
+	timestampNanos   int32
+}
+
+var csvHeader = []string{"namespace", "key", "blockNum", "txNum", "txId", "timestamp", "isDelete", "value"}
+
+func (r *historyRecord) toCSV() []string {
+	return []string{
+		r.Namespace,
+		r.Key,
+		strconv.FormatUint(r.BlockNum, 10),
+		strconv.FormatUint(r.TxNum, 10),
+		r.TxID,
+		r.Timestamp,
+		strconv.FormatBool(r.IsDelete),
+		r.Value,
+	}
+}
+
+// ExportHistory exports the history of a namespace (or a key) of a channel from the peer file system at fsPath,
+// to a file in JSON, CSV, or the unsigned audit format in the outputDirLoc. The values are resolved from the block
+// store. The peer is expected to be offline while this function is invoked. Returns the path of the output file and
+// the number of exported entries.
+func ExportHistory(fsPath, channelID string, filter *Filter, format, outputDirLoc string) (string, int, error) {
+	return exportHistory(fsPath, channelID, filter, format, nil, outputDirLoc)
+}
+
+// ExportAuditBundle is same as function `ExportHistory` with the FormatAudit, except that the bundle is signed by
+// the given signer, so that the bundle can be attributed to the exporting peer when verified offline (see function
+// `VerifyAuditBundle`)
+func ExportAuditBundle(fsPath, channelID string, filter *Filter, signer *AuditSigner, outputDirLoc string) (string, int, error) {
+	return exportHistory(fsPath, channelID, filter, FormatAudit, signer, outputDirLoc)
+}
+
+func exportHistory(fsPath, channelID string, filter *Filter, format string, signer *AuditSigner, outputDirLoc string) (string, int, error) {
+	if filter.Namespace == "" {
+		return "", 0, errors.New("namespace must be specified. Aborting exporthistory")
+	}
+	if filter.EndBlock != 0 && filter.EndBlock < filter.StartBlock {
+		return "", 0, errors.Errorf("end block [%d] is less than start block [%d]. Aborting exporthistory", filter.EndBlock, filter.StartBlock)
+	}
+	if format != FormatJSON && format != FormatCSV && format != FormatAudit {
+		return "", 0, errors.Errorf("unsupported format [%s], supported formats are [%s], [%s], and [%s]. Aborting exporthistory",
+			format, FormatJSON, FormatCSV, FormatAudit)
+	}
+
+	ledgersDataDir := filepath.Join(fsPath, ledgersDataDirName)
+	historyDBPath := kvledger.HistoryDBPath(ledgersDataDir)
+	empty, err := fileutil.DirEmpty(historyDBPath)
+	if err != nil {
+		return "", 0, err
+	}
+	if empty {
+		return "", 0, errors.Errorf("history database at %s is empty. Aborting exporthistory", historyDBPath)
+	}
+
+	blockStoreProvider, err := blkstorage.NewProvider(
+		blkstorage.NewConf(kvledger.BlockStorePath(ledgersDataDir), 0),
+		&blkstorage.IndexConfig{
+			AttrsToIndex: []blkstorage.IndexableAttr{
+				blkstorage.IndexableAttrBlockNum,
+				blkstorage.IndexableAttrBlockHash,
+				blkstorage.IndexableAttrTxID,
+				blkstorage.IndexableAttrBlockNumTranNum,
+			},
+		},
+		&disabled.Provider{},
+	)
+	if err != nil {
+		return "", 0, err
+	}
+	defer blockStoreProvider.Close()
+	exists, err := blockStoreProvider.Exists(channelID)
+	if err != nil {
+		return "", 0, err
+	}
+	if !exists {
+		return "", 0, errors.Errorf("BlockStore for %s does not exist. Aborting exporthistory", channelID)
+	}
+	blockStore, err := blockStoreProvider.Open(channelID)
+	if err != nil {
+		return "", 0, err
+	}
+	defer blockStore.Shutdown()
+
+	historyDBProvider, err := history.NewDBProvider(historyDBPath)
+	if err != nil {
+		return "", 0, err
+	}
+	defer historyDBProvider.Close()
+
+	outputFileName := fmt.Sprintf("%s_%s_history.%s", channelID, filter.Namespace, format)
+	outputFilePath := filepath.Join(outputDirLoc, outputFileName)
+	exists, _, err = fileutil.FileExists(outputFilePath)
+	if err != nil {
+		return "", 0, err
+	}
+	if exists {
+		return "", 0, errors.Errorf("%s already exists in %s. Choose a different location or remove the existing results. Aborting exporthistory", outputFileName, outputDirLoc)
+	}
+
+	var w recordWriter
+	switch format {
+	case FormatJSON:
+		w, err = newJSONRecordWriter(outputFilePath, channelID, filter)
+	case FormatCSV:
+		w, err = newCSVRecordWriter(outputFilePath)
+	default:
+		w, err = newAuditRecordWriter(outputFilePath, channelID, filter, signer)
+	}
+	if err != nil {
+		return "", 0, err
+	}
+
+	count := 0
+	err = historyDBProvider.GetDBHandle(channelID).Scan(filter.Namespace, filter.Key, blockStore,
+		func(e *history.Entry) error {
+			if !filter.matches(e) {
+				return nil
+			}
+			count++
+			return w.write(toHistoryRecord(e))
+		},
+	)
+	if err != nil {
+		w.close()
+		return "", 0, err
+	}
+	if err := w.close(); err != nil {
+		return "", 0, err
+	}
+	return outputFilePath, count, nil
+}
+
+func (f *Filter) matches(e *history.Entry) bool {
+	if e.BlockNum < f.StartBlock {
+		return false
+	}
+	if f.EndBlock != 0 && e.BlockNum > f.EndBlock {
+		return false
+	}
+	return !f.DeletesOnly || e.KeyModification.IsDelete
+}
+
+func toHistoryRecord(e *history.Entry) *historyRecord {
+	r := &historyRecord{
+		Namespace: e.Namespace,
+		Key:       e.Key,
+		BlockNum:  e.BlockNum,
+		TxNum:     e.TranNum,
+		TxID:      e.KeyModification.TxId,
+		IsDelete:  e.KeyModification.IsDelete,
+		Value:     string(e.KeyModification.Value),
+	}
+	if ts := e.KeyModification.Timestamp; ts != nil {
+		r.Timestamp = ts.AsTime().UTC().Format(time.RFC3339Nano)
+		r.timestampSeconds, r.timestampNanos = ts.Seconds, ts.Nanos
+	}
+	return r
+}
+
+type recordWriter interface {
+	write(r *historyRecord) error
+	close() error
+}
+
+type jsonRecordWriter struct {
+	writer *jsonrw.JSONFileWriter
+}
+
+func newJSONRecordWriter(filePath, channelID string, filter *Filter) (*jsonRecordWriter, error) {
+	w, err := jsonrw.NewJSONFileWriter(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if err := w.OpenObject(); err != nil {
+		return nil, err
+	}
+	if err := w.AddField("ledgerid", channelID); err != nil {
+		return nil, err
+	}
+	if err := w.AddField("namespace", filter.Namespace); err != nil {
+		return nil, err
+	}
+	if filter.Key != "" {
+		if err := w.AddField("key", filter.Key); err != nil {
+			return nil, err
+		}
+	}
+	var emptySlice []interface{}
+	if err := w.AddField("history", emptySlice); err != nil {
+		return nil, err
+	}
+	return &jsonRecordWriter{writer: w}, nil
+}
+
+func (w *jsonRecordWriter) write(r *historyRecord) error {
+	return w.writer.AddEntry(r)
+}
+
+func (w *jsonRecordWriter) close() error {
+	if err := w.writer.CloseList(); err != nil {
+		return err
+	}
+	if err := w.writer.CloseObject(); err != nil {
+		return err
+	}
+	return w.writer.Close()
+}
+
+type csvRecordWriter struct {
+	file   *os.File
+	writer *csv.Writer
+}
+
+func newCSVRecordWriter(filePath string) (*csvRecordWriter, error) {
+	f, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	w := csv.NewWriter(f)
+	if err := w.Write(csvHeader); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &csvRecordWriter{file: f, writer: w}, nil
+}
+
+func (w *csvRecordWriter) write(r *historyRecord) error {
+	return w.writer.Write(r.toCSV())
+}
+
+func (w *csvRecordWriter) close() error {
+	w.writer.Flush()
+	if err := w.writer.Error(); err != nil {
+		w.file.Close()
+		return err
+	}
+	if err := w.file.Sync(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
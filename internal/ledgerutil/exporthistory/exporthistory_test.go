@@ -0,0 +1,163 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package exporthistory
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric/common/ledger/blkstorage"
+	"github.com/hyperledger/fabric/common/ledger/testutil"
+	"github.com/hyperledger/fabric/common/metrics/disabled"
+	"github.com/hyperledger/fabric/core/ledger/kvledger"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/history"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	TestDataDir         = "../testdata/"
+	SampleFileSystemDir = TestDataDir + "sample_prod/"
+)
+
+type exportedHistory struct {
+	Ledgerid  string           `json:"ledgerid"`
+	Namespace string           `json:"namespace"`
+	Key       string           `json:"key"`
+	History   []*historyRecord `json:"history"`
+}
+
+func TestExportHistory(t *testing.T) {
+	fsDir := t.TempDir()
+	require.NoError(t, testutil.CopyDir(SampleFileSystemDir, fsDir, false))
+	buildHistory(t, fsDir, "mychannel")
+
+	t.Run("json-namespace", func(t *testing.T) {
+		outputFile, count, err := ExportHistory(fsDir, "mychannel", &Filter{Namespace: "marbles"}, FormatJSON, t.TempDir())
+		require.NoError(t, err)
+		require.Equal(t, "mychannel_marbles_history.json", filepath.Base(outputFile))
+		exported := loadJSON(t, outputFile)
+		require.Equal(t, "mychannel", exported.Ledgerid)
+		require.Equal(t, "marbles", exported.Namespace)
+		require.Len(t, exported.History, count)
+		require.NotZero(t, count)
+		for _, r := range exported.History {
+			require.Equal(t, "marbles", r.Namespace)
+			require.NotEmpty(t, r.TxID)
+			require.NotEmpty(t, r.Timestamp)
+		}
+	})
+
+	t.Run("json-key-and-block-range", func(t *testing.T) {
+		_, allCount, err := ExportHistory(fsDir, "mychannel", &Filter{Namespace: "marbles", Key: "marble1"}, FormatJSON, t.TempDir())
+		require.NoError(t, err)
+		require.NotZero(t, allCount)
+
+		outputFile, count, err := ExportHistory(fsDir, "mychannel",
+			&Filter{Namespace: "marbles", Key: "marble1", StartBlock: 2, EndBlock: 3}, FormatJSON, t.TempDir())
+		require.NoError(t, err)
+		exported := loadJSON(t, outputFile)
+		require.Equal(t, "marble1", exported.Key)
+		require.Len(t, exported.History, count)
+		require.LessOrEqual(t, count, allCount)
+		for _, r := range exported.History {
+			require.Equal(t, "marble1", r.Key)
+			require.GreaterOrEqual(t, r.BlockNum, uint64(2))
+			require.LessOrEqual(t, r.BlockNum, uint64(3))
+		}
+	})
+
+	t.Run("csv-deletes-only", func(t *testing.T) {
+		_, allCount, err := ExportHistory(fsDir, "mychannel", &Filter{Namespace: "marbles"}, FormatCSV, t.TempDir())
+		require.NoError(t, err)
+
+		outputFile, count, err := ExportHistory(fsDir, "mychannel", &Filter{Namespace: "marbles", DeletesOnly: true}, FormatCSV, t.TempDir())
+		require.NoError(t, err)
+		require.Equal(t, "mychannel_marbles_history.csv", filepath.Base(outputFile))
+		require.LessOrEqual(t, count, allCount)
+		f, err := os.Open(outputFile)
+		require.NoError(t, err)
+		defer f.Close()
+		rows, err := csv.NewReader(f).ReadAll()
+		require.NoError(t, err)
+		require.Equal(t, csvHeader, rows[0])
+		require.Len(t, rows, count+1)
+		for _, row := range rows[1:] {
+			require.Equal(t, "true", row[6])
+		}
+	})
+
+	t.Run("output-file-exists", func(t *testing.T) {
+		outputDir := t.TempDir()
+		_, _, err := ExportHistory(fsDir, "mychannel", &Filter{Namespace: "marbles"}, FormatCSV, outputDir)
+		require.NoError(t, err)
+		_, _, err = ExportHistory(fsDir, "mychannel", &Filter{Namespace: "marbles"}, FormatCSV, outputDir)
+		require.ErrorContains(t, err, "mychannel_marbles_history.csv already exists in")
+	})
+
+	t.Run("invalid-inputs", func(t *testing.T) {
+		_, _, err := ExportHistory(fsDir, "mychannel", &Filter{}, FormatJSON, t.TempDir())
+		require.EqualError(t, err, "namespace must be specified. Aborting exporthistory")
+		_, _, err = ExportHistory(fsDir, "mychannel", &Filter{Namespace: "marbles", StartBlock: 3, EndBlock: 2}, FormatJSON, t.TempDir())
+		require.EqualError(t, err, "end block [2] is less than start block [3]. Aborting exporthistory")
+		_, _, err = ExportHistory(fsDir, "mychannel", &Filter{Namespace: "marbles"}, "xml", t.TempDir())
+		require.EqualError(t, err, "unsupported format [xml], supported formats are [json], [csv], and [audit]. Aborting exporthistory")
+		_, _, err = ExportHistory(fsDir, "non-existing-channel", &Filter{Namespace: "marbles"}, FormatJSON, t.TempDir())
+		require.EqualError(t, err, "BlockStore for non-existing-channel does not exist. Aborting exporthistory")
+		_, _, err = ExportHistory(t.TempDir(), "mychannel", &Filter{Namespace: "marbles"}, FormatJSON, t.TempDir())
+		require.ErrorContains(t, err, "no such file or directory")
+	})
+}
+
+// buildHistory builds the history db from the block store, as the sample file system contains only the block store
+func buildHistory(t *testing.T, fsPath, channelID string) {
+	ledgersDataDir := filepath.Join(fsPath, ledgersDataDirName)
+	blockStoreProvider, err := blkstorage.NewProvider(
+		blkstorage.NewConf(kvledger.BlockStorePath(ledgersDataDir), 0),
+		&blkstorage.IndexConfig{
+			AttrsToIndex: []blkstorage.IndexableAttr{
+				blkstorage.IndexableAttrBlockNum,
+				blkstorage.IndexableAttrBlockHash,
+				blkstorage.IndexableAttrTxID,
+				blkstorage.IndexableAttrBlockNumTranNum,
+			},
+		},
+		&disabled.Provider{},
+	)
+	require.NoError(t, err)
+	defer blockStoreProvider.Close()
+	blockStore, err := blockStoreProvider.Open(channelID)
+	require.NoError(t, err)
+	defer blockStore.Shutdown()
+
+	historyDBProvider, err := history.NewDBProvider(kvledger.HistoryDBPath(ledgersDataDir))
+	require.NoError(t, err)
+	defer historyDBProvider.Close()
+	historyDB := historyDBProvider.GetDBHandle(channelID)
+
+	bcInfo, err := blockStore.GetBlockchainInfo()
+	require.NoError(t, err)
+	itr, err := blockStore.RetrieveBlocks(0)
+	require.NoError(t, err)
+	defer itr.Close()
+	for i := uint64(0); i < bcInfo.Height; i++ {
+		res, err := itr.Next()
+		require.NoError(t, err)
+		require.NoError(t, historyDB.Commit(res.(*common.Block)))
+	}
+}
+
+func loadJSON(t *testing.T, filePath string) *exportedHistory {
+	b, err := os.ReadFile(filePath)
+	require.NoError(t, err)
+	exported := &exportedHistory{}
+	require.NoError(t, json.Unmarshal(b, exported))
+	return exported
+}
@@ -0,0 +1,97 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package inspecthistory
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/hyperledger/fabric/core/ledger/kvledger"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/history"
+	"github.com/hyperledger/fabric/internal/fileutil"
+	"github.com/pkg/errors"
+)
+
+const ledgersDataDirName = "ledgersData"
+
+// InspectHistory prints to out the savepoint and the backfill progress of the history database of a channel
+// from the peer file system at fsPath, followed by the decoded history index entries for the namespace or,
+// if key is not empty, for the given key in the namespace. If namespace is empty, all the index entries are
+// printed. The block store is not consulted, so that an index can be inspected even if it is inconsistent
+// with the block store. An entry that cannot be decoded is printed along with the decoding error. The peer
+// is expected to be offline while this function is invoked. Returns the number of printed entries.
+func InspectHistory(fsPath, channelID, namespace, key string, out io.Writer) (int, error) {
+	if namespace == "" && key != "" {
+		return 0, errors.New("namespace must be specified along with the key. Aborting inspecthistory")
+	}
+
+	historyDBPath := kvledger.HistoryDBPath(filepath.Join(fsPath, ledgersDataDirName))
+	empty, err := fileutil.DirEmpty(historyDBPath)
+	if err != nil {
+		return 0, err
+	}
+	if empty {
+		return 0, errors.Errorf("history database at %s is empty. Aborting inspecthistory", historyDBPath)
+	}
+	historyDBProvider, err := history.NewDBProvider(historyDBPath)
+	if err != nil {
+		return 0, err
+	}
+	defer historyDBProvider.Close()
+
+	historyDB := historyDBProvider.GetDBHandle(channelID)
+	savepoint, err := historyDB.GetLastSavepoint()
+	if err != nil {
+		return 0, err
+	}
+	if savepoint == nil {
+		return 0, errors.Errorf("no history found for channel [%s]. Aborting inspecthistory", channelID)
+	}
+	backfillProgress, err := historyDB.BackfillProgress()
+	if err != nil {
+		return 0, err
+	}
+
+	fmt.Fprintf(out, "History database: %s\n", historyDBPath)
+	fmt.Fprintf(out, "Channel: %s\n", channelID)
+	fmt.Fprintf(out, "Savepoint: block [%d] transaction [%d]\n", savepoint.BlockNum, savepoint.TxNum)
+	switch {
+	case backfillProgress.Done:
+		fmt.Fprintf(out, "Backfill: completed, [%d] entries processed\n", backfillProgress.EntriesProcessed)
+	case backfillProgress.TotalEntries == 0:
+		fmt.Fprintln(out, "Backfill: not started")
+	default:
+		fmt.Fprintf(out, "Backfill: in progress, [%d] of [%d] entries processed\n",
+			backfillProgress.EntriesProcessed, backfillProgress.TotalEntries)
+	}
+
+	switch {
+	case namespace == "":
+		fmt.Fprintln(out, "Entries:")
+	case key == "":
+		fmt.Fprintf(out, "Entries for namespace [%s]:\n", namespace)
+	default:
+		fmt.Fprintf(out, "Entries for namespace [%s] key [%s]:\n", namespace, key)
+	}
+	count := 0
+	err = historyDB.ScanIndex(namespace, key, func(e *history.IndexEntry) error {
+		count++
+		if e.DecodeErr != nil {
+			_, err := fmt.Fprintf(out, "  rawkey [%x] error [%s]\n", e.RawKey, e.DecodeErr)
+			return err
+		}
+		_, err := fmt.Fprintf(out, "  namespace [%s] key [%s] block [%d] transaction [%d] inline [%t] rawkey [%x]\n",
+			e.Namespace, e.Key, e.BlockNum, e.TranNum, e.Inline, e.RawKey)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	fmt.Fprintf(out, "Total entries: %d\n", count)
+	return count, nil
+}
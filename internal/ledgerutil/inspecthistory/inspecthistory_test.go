@@ -0,0 +1,116 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package inspecthistory
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric/common/ledger/blkstorage"
+	"github.com/hyperledger/fabric/common/ledger/testutil"
+	"github.com/hyperledger/fabric/common/metrics/disabled"
+	"github.com/hyperledger/fabric/core/ledger/kvledger"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/history"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	TestDataDir         = "../testdata/"
+	SampleFileSystemDir = TestDataDir + "sample_prod/"
+)
+
+func TestInspectHistory(t *testing.T) {
+	fsDir := t.TempDir()
+	require.NoError(t, testutil.CopyDir(SampleFileSystemDir, fsDir, false))
+	lastBlockNum := buildHistory(t, fsDir, "mychannel")
+
+	t.Run("namespace", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		count, err := InspectHistory(fsDir, "mychannel", "marbles", "", out)
+		require.NoError(t, err)
+		require.NotZero(t, count)
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		require.Equal(t, "Channel: mychannel", lines[1])
+		require.True(t, strings.HasPrefix(lines[2], fmt.Sprintf("Savepoint: block [%d] ", lastBlockNum)), lines[2])
+		require.Equal(t, "Backfill: not started", lines[3])
+		require.Equal(t, "Entries for namespace [marbles]:", lines[4])
+		require.Len(t, lines, count+6)
+		for _, l := range lines[5 : 5+count] {
+			require.True(t, strings.HasPrefix(l, "  namespace [marbles] key ["), l)
+		}
+	})
+
+	t.Run("key", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		count, err := InspectHistory(fsDir, "mychannel", "marbles", "marble1", out)
+		require.NoError(t, err)
+		require.NotZero(t, count)
+		require.Contains(t, out.String(), "Entries for namespace [marbles] key [marble1]:\n")
+		require.Equal(t, count, strings.Count(out.String(), "  namespace [marbles] key [marble1] block ["))
+	})
+
+	t.Run("all-namespaces", func(t *testing.T) {
+		count, err := InspectHistory(fsDir, "mychannel", "", "", &bytes.Buffer{})
+		require.NoError(t, err)
+		nsCount, err := InspectHistory(fsDir, "mychannel", "marbles", "", &bytes.Buffer{})
+		require.NoError(t, err)
+		require.GreaterOrEqual(t, count, nsCount)
+	})
+
+	t.Run("invalid-inputs", func(t *testing.T) {
+		_, err := InspectHistory(fsDir, "mychannel", "", "marble1", &bytes.Buffer{})
+		require.EqualError(t, err, "namespace must be specified along with the key. Aborting inspecthistory")
+		_, err = InspectHistory(fsDir, "non-existing-channel", "marbles", "", &bytes.Buffer{})
+		require.EqualError(t, err, "no history found for channel [non-existing-channel]. Aborting inspecthistory")
+		_, err = InspectHistory(t.TempDir(), "mychannel", "marbles", "", &bytes.Buffer{})
+		require.ErrorContains(t, err, "no such file or directory")
+	})
+}
+
+// buildHistory builds the history db from the block store, as the sample file system contains only the block store.
+// Returns the last block number
+func buildHistory(t *testing.T, fsPath, channelID string) uint64 {
+	ledgersDataDir := filepath.Join(fsPath, ledgersDataDirName)
+	blockStoreProvider, err := blkstorage.NewProvider(
+		blkstorage.NewConf(kvledger.BlockStorePath(ledgersDataDir), 0),
+		&blkstorage.IndexConfig{
+			AttrsToIndex: []blkstorage.IndexableAttr{
+				blkstorage.IndexableAttrBlockNum,
+				blkstorage.IndexableAttrBlockHash,
+				blkstorage.IndexableAttrTxID,
+				blkstorage.IndexableAttrBlockNumTranNum,
+			},
+		},
+		&disabled.Provider{},
+	)
+	require.NoError(t, err)
+	defer blockStoreProvider.Close()
+	blockStore, err := blockStoreProvider.Open(channelID)
+	require.NoError(t, err)
+	defer blockStore.Shutdown()
+
+	historyDBProvider, err := history.NewDBProvider(kvledger.HistoryDBPath(ledgersDataDir))
+	require.NoError(t, err)
+	defer historyDBProvider.Close()
+	historyDB := historyDBProvider.GetDBHandle(channelID)
+
+	bcInfo, err := blockStore.GetBlockchainInfo()
+	require.NoError(t, err)
+	itr, err := blockStore.RetrieveBlocks(0)
+	require.NoError(t, err)
+	defer itr.Close()
+	for i := uint64(0); i < bcInfo.Height; i++ {
+		res, err := itr.Next()
+		require.NoError(t, err)
+		require.NoError(t, historyDB.Commit(res.(*common.Block)))
+	}
+	return bcInfo.Height - 1
+}
@@ -0,0 +1,212 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package verifyhistory
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/hyperledger/fabric/common/ledger/blkstorage"
+	"github.com/hyperledger/fabric/common/metrics/disabled"
+	"github.com/hyperledger/fabric/core/ledger/kvledger"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/history"
+	"github.com/hyperledger/fabric/internal/fileutil"
+	"github.com/pkg/errors"
+)
+
+const (
+	ledgersDataDirName = "ledgersData"
+
+	// maxReportedIssues limits the number of issues recorded in the report. The total number of
+	// issues found is reported regardless
+	maxReportedIssues = 1000
+
+	// Types of the issues
+	IssueUndecodableEntry     = "undecodableEntry"
+	IssueVersionOrder         = "versionOrder"
+	IssueEntryBeyondSavepoint = "entryBeyondSavepoint"
+	IssueSavepointMismatch    = "savepointMismatch"
+)
+
+// Report is the outcome of the verification of the history database of a channel
+type Report struct {
+	LedgerID         string   `json:"ledgerid"`
+	SavepointBlock   uint64   `json:"savepointBlockNum"`
+	SavepointTx      uint64   `json:"savepointTxNum"`
+	BlockStoreHeight uint64   `json:"blockStoreHeight"`
+	EntriesChecked   uint64   `json:"entriesChecked"`
+	IssueCount       uint64   `json:"issueCount"`
+	Issues           []*Issue `json:"issues"`
+}
+
+// Issue is an inconsistency found in the history database
+type Issue struct {
+	Type     string `json:"type"`
+	RawKey   string `json:"rawKey,omitempty"`
+	BlockNum uint64 `json:"blockNum,omitempty"`
+	TxNum    uint64 `json:"txNum,omitempty"`
+	Detail   string `json:"detail"`
+}
+
+func (r *Report) addIssue(issue *Issue) {
+	r.IssueCount++
+	if len(r.Issues) < maxReportedIssues {
+		r.Issues = append(r.Issues, issue)
+	}
+}
+
+// VerifyHistory verifies the history database of a channel from the peer file system at fsPath and writes the
+// report, in JSON format, to a file in the outputDirLoc. The following are verified:
+//   - every history index entry can be decoded
+//   - the savepoint of the history database matches the last block in the block store
+//   - no entry is for a block beyond the savepoint
+//   - the entries of each key are in the strictly increasing order of block and transaction numbers
+//
+// The peer is expected to be offline while this function is invoked. Returns the report and the path of the
+// report file. A report with a non-zero IssueCount indicates that the history database is inconsistent.
+func VerifyHistory(fsPath, channelID, outputDirLoc string) (*Report, string, error) {
+	ledgersDataDir := filepath.Join(fsPath, ledgersDataDirName)
+	historyDBPath := kvledger.HistoryDBPath(ledgersDataDir)
+	empty, err := fileutil.DirEmpty(historyDBPath)
+	if err != nil {
+		return nil, "", err
+	}
+	if empty {
+		return nil, "", errors.Errorf("history database at %s is empty. Aborting verifyhistory", historyDBPath)
+	}
+
+	outputFileName := fmt.Sprintf("%s_history_verification.json", channelID)
+	outputFilePath := filepath.Join(outputDirLoc, outputFileName)
+	exists, _, err := fileutil.FileExists(outputFilePath)
+	if err != nil {
+		return nil, "", err
+	}
+	if exists {
+		return nil, "", errors.Errorf("%s already exists in %s. Choose a different location or remove the existing results. Aborting verifyhistory", outputFileName, outputDirLoc)
+	}
+
+	blockStoreHeight, err := blockStoreHeight(ledgersDataDir, channelID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	historyDBProvider, err := history.NewDBProvider(historyDBPath)
+	if err != nil {
+		return nil, "", err
+	}
+	defer historyDBProvider.Close()
+	historyDB := historyDBProvider.GetDBHandle(channelID)
+
+	report := &Report{
+		LedgerID:         channelID,
+		BlockStoreHeight: blockStoreHeight,
+		Issues:           []*Issue{},
+	}
+	savepoint, err := historyDB.GetLastSavepoint()
+	if err != nil {
+		return nil, "", err
+	}
+	if savepoint == nil {
+		return nil, "", errors.Errorf("no history found for channel [%s]. Aborting verifyhistory", channelID)
+	}
+	report.SavepointBlock, report.SavepointTx = savepoint.BlockNum, savepoint.TxNum
+	if savepoint.BlockNum+1 != blockStoreHeight {
+		report.addIssue(&Issue{
+			Type:     IssueSavepointMismatch,
+			BlockNum: savepoint.BlockNum,
+			Detail: fmt.Sprintf("history savepoint is at block [%d], whereas the block store height is [%d]",
+				savepoint.BlockNum, blockStoreHeight),
+		})
+	}
+
+	var previous *history.IndexEntry
+	err = historyDB.ScanIndex("", "", func(e *history.IndexEntry) error {
+		report.EntriesChecked++
+		rawKey := hex.EncodeToString(e.RawKey)
+		if e.DecodeErr != nil {
+			report.addIssue(&Issue{
+				Type:   IssueUndecodableEntry,
+				RawKey: rawKey,
+				Detail: e.DecodeErr.Error(),
+			})
+			return nil
+		}
+		if e.BlockNum > savepoint.BlockNum {
+			report.addIssue(&Issue{
+				Type:     IssueEntryBeyondSavepoint,
+				RawKey:   rawKey,
+				BlockNum: e.BlockNum,
+				TxNum:    e.TranNum,
+				Detail:   fmt.Sprintf("entry for namespace [%s] key [%s] is beyond the savepoint block [%d]", e.Namespace, e.Key, savepoint.BlockNum),
+			})
+		}
+		if previous != nil && previous.Namespace == e.Namespace && previous.Key == e.Key &&
+			(e.BlockNum < previous.BlockNum || (e.BlockNum == previous.BlockNum && e.TranNum <= previous.TranNum)) {
+			report.addIssue(&Issue{
+				Type:     IssueVersionOrder,
+				RawKey:   rawKey,
+				BlockNum: e.BlockNum,
+				TxNum:    e.TranNum,
+				Detail: fmt.Sprintf("entry for namespace [%s] key [%s] at block [%d] transaction [%d] does not follow the previous entry at block [%d] transaction [%d]",
+					e.Namespace, e.Key, e.BlockNum, e.TranNum, previous.BlockNum, previous.TranNum),
+			})
+		}
+		previous = e
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	reportBytes, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return nil, "", errors.Wrap(err, "error while marshalling the verification report")
+	}
+	if err := os.WriteFile(outputFilePath, reportBytes, 0o644); err != nil {
+		return nil, "", errors.Wrapf(err, "error while writing the verification report to %s", outputFilePath)
+	}
+	return report, outputFilePath, nil
+}
+
+func blockStoreHeight(ledgersDataDir, channelID string) (uint64, error) {
+	blockStoreProvider, err := blkstorage.NewProvider(
+		blkstorage.NewConf(kvledger.BlockStorePath(ledgersDataDir), 0),
+		&blkstorage.IndexConfig{
+			AttrsToIndex: []blkstorage.IndexableAttr{
+				blkstorage.IndexableAttrBlockNum,
+				blkstorage.IndexableAttrBlockHash,
+				blkstorage.IndexableAttrTxID,
+				blkstorage.IndexableAttrBlockNumTranNum,
+			},
+		},
+		&disabled.Provider{},
+	)
+	if err != nil {
+		return 0, err
+	}
+	defer blockStoreProvider.Close()
+	exists, err := blockStoreProvider.Exists(channelID)
+	if err != nil {
+		return 0, err
+	}
+	if !exists {
+		return 0, errors.Errorf("BlockStore for %s does not exist. Aborting verifyhistory", channelID)
+	}
+	blockStore, err := blockStoreProvider.Open(channelID)
+	if err != nil {
+		return 0, err
+	}
+	defer blockStore.Shutdown()
+	bcInfo, err := blockStore.GetBlockchainInfo()
+	if err != nil {
+		return 0, err
+	}
+	return bcInfo.Height, nil
+}
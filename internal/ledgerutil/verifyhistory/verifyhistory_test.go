@@ -0,0 +1,148 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package verifyhistory
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric/common/ledger/blkstorage"
+	"github.com/hyperledger/fabric/common/ledger/dataformat"
+	"github.com/hyperledger/fabric/common/ledger/testutil"
+	"github.com/hyperledger/fabric/common/ledger/util"
+	"github.com/hyperledger/fabric/common/ledger/util/leveldbhelper"
+	"github.com/hyperledger/fabric/common/metrics/disabled"
+	"github.com/hyperledger/fabric/core/ledger/kvledger"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/history"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	TestDataDir         = "../testdata/"
+	SampleFileSystemDir = TestDataDir + "sample_prod/"
+)
+
+func TestVerifyHistory(t *testing.T) {
+	fsDir := t.TempDir()
+	require.NoError(t, testutil.CopyDir(SampleFileSystemDir, fsDir, false))
+	height := buildHistory(t, fsDir, "mychannel")
+
+	t.Run("consistent-history", func(t *testing.T) {
+		report, outputFile, err := VerifyHistory(fsDir, "mychannel", t.TempDir())
+		require.NoError(t, err)
+		require.Equal(t, "mychannel_history_verification.json", filepath.Base(outputFile))
+		require.Equal(t, height, report.BlockStoreHeight)
+		require.Equal(t, height-1, report.SavepointBlock)
+		require.NotZero(t, report.EntriesChecked)
+		require.Zero(t, report.IssueCount)
+		require.Equal(t, report, loadReport(t, outputFile))
+	})
+
+	t.Run("output-file-exists", func(t *testing.T) {
+		outputDir := t.TempDir()
+		_, _, err := VerifyHistory(fsDir, "mychannel", outputDir)
+		require.NoError(t, err)
+		_, _, err = VerifyHistory(fsDir, "mychannel", outputDir)
+		require.ErrorContains(t, err, "mychannel_history_verification.json already exists in")
+	})
+
+	t.Run("invalid-inputs", func(t *testing.T) {
+		_, _, err := VerifyHistory(fsDir, "non-existing-channel", t.TempDir())
+		require.EqualError(t, err, "BlockStore for non-existing-channel does not exist. Aborting verifyhistory")
+		_, _, err = VerifyHistory(t.TempDir(), "mychannel", t.TempDir())
+		require.ErrorContains(t, err, "no such file or directory")
+	})
+
+	t.Run("inconsistent-history", func(t *testing.T) {
+		historyDBPath := kvledger.HistoryDBPath(filepath.Join(fsDir, ledgersDataDirName))
+		dbProvider, err := leveldbhelper.NewProvider(
+			&leveldbhelper.Conf{DBPath: historyDBPath, ExpectedFormat: dataformat.CurrentFormat},
+		)
+		require.NoError(t, err)
+		db := dbProvider.GetDBHandle("mychannel")
+		// savepoint behind the block store, which makes the entries for the last block to be beyond the savepoint
+		savepoint := append(util.EncodeOrderPreservingVarUint64(height-2), util.EncodeOrderPreservingVarUint64(0)...)
+		require.NoError(t, db.Put([]byte{'s'}, savepoint, true))
+		// an entry that cannot be decoded
+		require.NoError(t, db.Put([]byte("marbles-no-separator"), []byte{}, true))
+		// an entry for block 1 with a non-canonical encoding of the block number, which
+		// orders the entry after the other entries for the key
+		nonCanonicalKey := append([]byte("marbles\x00\x01\x07marble1\x00"), 0x08, 0, 0, 0, 0, 0, 0, 0, 0x01, 0x00)
+		require.NoError(t, db.Put(nonCanonicalKey, []byte{}, true))
+		dbProvider.Close()
+
+		report, outputFile, err := VerifyHistory(fsDir, "mychannel", t.TempDir())
+		require.NoError(t, err)
+		require.Equal(t, report, loadReport(t, outputFile))
+
+		issuesByType := map[string][]*Issue{}
+		for _, issue := range report.Issues {
+			issuesByType[issue.Type] = append(issuesByType[issue.Type], issue)
+		}
+		require.Len(t, issuesByType[IssueSavepointMismatch], 1)
+		require.NotEmpty(t, issuesByType[IssueEntryBeyondSavepoint])
+		for _, issue := range issuesByType[IssueEntryBeyondSavepoint] {
+			require.Equal(t, height-1, issue.BlockNum)
+		}
+		require.Len(t, issuesByType[IssueUndecodableEntry], 1)
+		require.Equal(t, "6d6172626c65732d6e6f2d736570617261746f72", issuesByType[IssueUndecodableEntry][0].RawKey)
+		require.Len(t, issuesByType[IssueVersionOrder], 1)
+		require.Equal(t, uint64(1), issuesByType[IssueVersionOrder][0].BlockNum)
+		require.Equal(t, uint64(len(report.Issues)), report.IssueCount)
+	})
+}
+
+// buildHistory builds the history db from the block store, as the sample file system contains only the block store.
+// Returns the block store height
+func buildHistory(t *testing.T, fsPath, channelID string) uint64 {
+	ledgersDataDir := filepath.Join(fsPath, ledgersDataDirName)
+	blockStoreProvider, err := blkstorage.NewProvider(
+		blkstorage.NewConf(kvledger.BlockStorePath(ledgersDataDir), 0),
+		&blkstorage.IndexConfig{
+			AttrsToIndex: []blkstorage.IndexableAttr{
+				blkstorage.IndexableAttrBlockNum,
+				blkstorage.IndexableAttrBlockHash,
+				blkstorage.IndexableAttrTxID,
+				blkstorage.IndexableAttrBlockNumTranNum,
+			},
+		},
+		&disabled.Provider{},
+	)
+	require.NoError(t, err)
+	defer blockStoreProvider.Close()
+	blockStore, err := blockStoreProvider.Open(channelID)
+	require.NoError(t, err)
+	defer blockStore.Shutdown()
+
+	historyDBProvider, err := history.NewDBProvider(kvledger.HistoryDBPath(ledgersDataDir))
+	require.NoError(t, err)
+	defer historyDBProvider.Close()
+	historyDB := historyDBProvider.GetDBHandle(channelID)
+
+	bcInfo, err := blockStore.GetBlockchainInfo()
+	require.NoError(t, err)
+	itr, err := blockStore.RetrieveBlocks(0)
+	require.NoError(t, err)
+	defer itr.Close()
+	for i := uint64(0); i < bcInfo.Height; i++ {
+		res, err := itr.Next()
+		require.NoError(t, err)
+		require.NoError(t, historyDB.Commit(res.(*common.Block)))
+	}
+	return bcInfo.Height
+}
+
+func loadReport(t *testing.T, filePath string) *Report {
+	b, err := os.ReadFile(filePath)
+	require.NoError(t, err)
+	report := &Report{}
+	require.NoError(t, json.Unmarshal(b, report))
+	return report
+}
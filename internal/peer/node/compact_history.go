@@ -0,0 +1,40 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package node
+
+import (
+	"github.com/hyperledger/fabric/core/ledger/kvledger"
+	"github.com/hyperledger/fabric/internal/peer/common"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+func compactHistoryCmd() *cobra.Command {
+	var channelID string
+	var namespace string
+
+	cmd := &cobra.Command{
+		Use:   "compact-history",
+		Short: "Compacts the history database for a channel.",
+		Long: "Compacts the history database for a channel, or for a namespace of the channel if a namespace is specified," +
+			" so that the disk space held by the deleted history is reclaimed. This is useful after a large amount of" +
+			" history is deleted, as the space is otherwise reclaimed only gradually. When the command is executed," +
+			" the peer must be offline.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if channelID == common.UndefinedParamValue {
+				return errors.New("Must supply channel ID")
+			}
+			config := ledgerConfig()
+			return kvledger.CompactHistory(config, channelID, namespace)
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringVarP(&channelID, "channelID", "c", common.UndefinedParamValue, "Channel for which the history is to be compacted.")
+	flags.StringVarP(&namespace, "namespace", "n", "", "Namespace for which the history is to be compacted. All the history of the channel is compacted if not specified.")
+
+	return cmd
+}
@@ -8,6 +8,7 @@ package node
 
 import (
 	"path/filepath"
+	"strings"
 	"time"
 
 	coreconfig "github.com/hyperledger/fabric/core/config"
@@ -41,6 +42,18 @@ func ledgerConfig() *ledger.Config {
 	if viper.IsSet("ledger.pvtdataStore.deprioritizedDataReconcilerInterval") {
 		deprioritizedDataReconcilerInterval = viper.GetDuration("ledger.pvtdataStore.deprioritizedDataReconcilerInterval")
 	}
+	historyGroupCommitFlushInterval := time.Second
+	if viper.IsSet("ledger.history.groupCommit.flushInterval") {
+		historyGroupCommitFlushInterval = viper.GetDuration("ledger.history.groupCommit.flushInterval")
+	}
+	historyDeltaEncodingCheckpointInterval := 16
+	if viper.IsSet("ledger.history.deltaEncoding.checkpointInterval") {
+		historyDeltaEncodingCheckpointInterval = viper.GetInt("ledger.history.deltaEncoding.checkpointInterval")
+	}
+	historyAsyncCommitMaxLag := 10
+	if viper.IsSet("ledger.history.asyncCommit.maxLag") {
+		historyAsyncCommitMaxLag = viper.GetInt("ledger.history.asyncCommit.maxLag")
+	}
 	purgedKeyAuditLogging := true
 	if viper.IsSet("ledger.pvtdataStore.purgedKeyAuditLogging") {
 		purgedKeyAuditLogging = viper.GetBool("ledger.pvtdataStore.purgedKeyAuditLogging")
@@ -66,7 +79,51 @@ func ledgerConfig() *ledger.Config {
 			PurgedKeyAuditLogging:               purgedKeyAuditLogging,
 		},
 		HistoryDBConfig: &ledger.HistoryDBConfig{
-			Enabled: viper.GetBool("ledger.history.enableHistoryDatabase"),
+			Enabled:                          viper.GetBool("ledger.history.enableHistoryDatabase"),
+			IncludeInSnapshots:               viper.GetBool("ledger.history.includeInSnapshots"),
+			BackfillArchiveDir:               coreconfig.GetPath("ledger.history.backfillArchiveDir"),
+			FieldIndexes:                     historyNamespaceEntries(viper.GetStringSlice("ledger.history.fieldIndexes")),
+			FullTextSearchNamespaces:         viper.GetStringSlice("ledger.history.fullTextSearchNamespaces"),
+			GroupCommitMaxBlocks:             viper.GetInt("ledger.history.groupCommit.maxBlocks"),
+			GroupCommitFlushInterval:         historyGroupCommitFlushInterval,
+			AsyncCommit:                      viper.GetBool("ledger.history.asyncCommit.enabled"),
+			AsyncCommitMaxLag:                historyAsyncCommitMaxLag,
+			DecodedTxCacheSize:               viper.GetInt("ledger.history.decodedTxCacheSize"),
+			DecodedTxCacheMaxBytes:           viper.GetInt("ledger.history.decodedTxCacheMaxBytes"),
+			DecodedTxCacheWarmUpBlocks:       viper.GetInt("ledger.history.decodedTxCacheWarmUpBlocks"),
+			QueryPrefetchDepth:               viper.GetInt("ledger.history.queryPrefetchDepth"),
+			NoHistoryCacheSize:               viper.GetInt("ledger.history.noHistoryCacheSize"),
+			QueryResultCacheSize:             viper.GetInt("ledger.history.queryResultCache.maxKeys"),
+			QueryResultCacheMaxEntries:       viper.GetInt("ledger.history.queryResultCache.maxEntriesPerKey"),
+			SlowQueryThreshold:               viper.GetDuration("ledger.history.slowQueryLog.threshold"),
+			SlowQueryMinResults:              viper.GetInt("ledger.history.slowQueryLog.minResults"),
+			MaxBatchRetrievals:               viper.GetInt("ledger.history.queryScheduling.maxBatchRetrievals"),
+			BatchQueryMaxYield:               viper.GetDuration("ledger.history.queryScheduling.maxYield"),
+			DecodeWorkers:                    viper.GetInt("ledger.history.decodeWorkers"),
+			MaxOpenScanners:                  viper.GetInt("ledger.history.maxOpenScanners"),
+			ScannerIdleTimeout:               viper.GetDuration("ledger.history.scannerIdleTimeout"),
+			QueryQuotaWindow:                 viper.GetDuration("ledger.history.queryQuota.window"),
+			QueryQuotaMaxEntries:             viper.GetInt("ledger.history.queryQuota.maxEntriesScanned"),
+			QueryQuotaMaxBytes:               viper.GetInt("ledger.history.queryQuota.maxBytesReturned"),
+			MaxResponseEntries:               viper.GetInt("ledger.history.responseCaps.maxEntries"),
+			MaxResponseBytes:                 viper.GetInt("ledger.history.responseCaps.maxBytes"),
+			IncludeKeys:                      historyNamespaceEntries(viper.GetStringSlice("ledger.history.includeKeys")),
+			ExcludeKeys:                      historyNamespaceEntries(viper.GetStringSlice("ledger.history.excludeKeys")),
+			CompactionWindowStart:            viper.GetString("ledger.history.compactionWindowStart"),
+			CompactionWindowDuration:         viper.GetDuration("ledger.history.compactionWindowDuration"),
+			SizeSamplingInterval:             viper.GetDuration("ledger.history.sizeSamplingInterval"),
+			DisabledNamespaces:               viper.GetStringSlice("ledger.history.disabledNamespaces"),
+			NormalizedKeyNamespaces:          viper.GetStringSlice("ledger.history.normalizedKeyNamespaces"),
+			Capabilities:                     viper.GetStringSlice("ledger.history.capabilities"),
+			SkipUnchangedWriteNamespaces:     viper.GetStringSlice("ledger.history.skipUnchangedWriteNamespaces"),
+			DeltaEncodingNamespaces:          viper.GetStringSlice("ledger.history.deltaEncoding.namespaces"),
+			DeltaEncodingCheckpointInterval:  historyDeltaEncodingCheckpointInterval,
+			ValueSizeTrackingNamespaces:      viper.GetStringSlice("ledger.history.valueSizeTrackingNamespaces"),
+			TransactionIndex:                 viper.GetBool("ledger.history.enableTransactionIndex"),
+			HealthCheckMaxLag:                viper.GetInt("ledger.history.healthCheckMaxLag"),
+			MigrationTargetDir:               coreconfig.GetPath("ledger.history.migration.targetDir"),
+			MigrationShadowReadSampleRate:    viper.GetFloat64("ledger.history.migration.shadowReadSampleRate"),
+			MigrationNormalizedKeyNamespaces: viper.GetStringSlice("ledger.history.migration.normalizedKeyNamespaces"),
 		},
 		SnapshotsConfig: &ledger.SnapshotsConfig{
 			RootDir: snapshotsRootDir,
@@ -90,3 +147,21 @@ func ledgerConfig() *ledger.Config {
 	}
 	return conf
 }
+
+// historyNamespaceEntries converts the entries, such as the field indexes or the key patterns, specified as
+// `namespace:value`, to a map of namespace to values. An entry without the separator is retained with an empty
+// value so that it is rejected by the ledger
+func historyNamespaceEntries(entries []string) map[string][]string {
+	if len(entries) == 0 {
+		return nil
+	}
+	values := map[string][]string{}
+	for _, entry := range entries {
+		ns, value := entry, ""
+		if i := strings.Index(entry, ":"); i != -1 {
+			ns, value = entry[:i], entry[i+1:]
+		}
+		values[ns] = append(values[ns], value)
+	}
+	return values
+}
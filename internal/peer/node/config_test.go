@@ -42,7 +42,10 @@ func TestLedgerConfig(t *testing.T) {
 					PurgedKeyAuditLogging:               true,
 				},
 				HistoryDBConfig: &ledger.HistoryDBConfig{
-					Enabled: false,
+					Enabled:                         false,
+					GroupCommitFlushInterval:        time.Second,
+					AsyncCommitMaxLag:               10,
+					DeltaEncodingCheckpointInterval: 16,
 				},
 				SnapshotsConfig: &ledger.SnapshotsConfig{
 					RootDir: "/peerfs/snapshots",
@@ -89,7 +92,10 @@ func TestLedgerConfig(t *testing.T) {
 					PurgedKeyAuditLogging:               true,
 				},
 				HistoryDBConfig: &ledger.HistoryDBConfig{
-					Enabled: false,
+					Enabled:                         false,
+					GroupCommitFlushInterval:        time.Second,
+					AsyncCommitMaxLag:               10,
+					DeltaEncodingCheckpointInterval: 16,
 				},
 				SnapshotsConfig: &ledger.SnapshotsConfig{
 					RootDir: "/peerfs/snapshots",
@@ -117,6 +123,50 @@ func TestLedgerConfig(t *testing.T) {
 				"ledger.pvtdataStore.purgedKeyAuditLogging":               false,
 				"ledger.pvtdataStore.deprioritizedDataReconcilerInterval": "180m",
 				"ledger.history.enableHistoryDatabase":                    true,
+				"ledger.history.includeInSnapshots":                       true,
+				"ledger.history.backfillArchiveDir":                       "/peerfs/historyArchives",
+				"ledger.history.fieldIndexes":                             []string{"marbles:owner", "marbles:owner.name", "assets:status"},
+				"ledger.history.fullTextSearchNamespaces":                 []string{"marbles"},
+				"ledger.history.groupCommit.maxBlocks":                    100,
+				"ledger.history.groupCommit.flushInterval":                "2s",
+				"ledger.history.asyncCommit.enabled":                      true,
+				"ledger.history.asyncCommit.maxLag":                       50,
+				"ledger.history.decodedTxCacheSize":                       1000,
+				"ledger.history.decodedTxCacheMaxBytes":                   64 * 1024 * 1024,
+				"ledger.history.decodedTxCacheWarmUpBlocks":               20,
+				"ledger.history.queryPrefetchDepth":                       8,
+				"ledger.history.noHistoryCacheSize":                       5000,
+				"ledger.history.queryResultCache.maxKeys":                 2000,
+				"ledger.history.queryResultCache.maxEntriesPerKey":        100,
+				"ledger.history.slowQueryLog.threshold":                   "500ms",
+				"ledger.history.slowQueryLog.minResults":                  10000,
+				"ledger.history.queryScheduling.maxBatchRetrievals":       4,
+				"ledger.history.queryScheduling.maxYield":                 "20ms",
+				"ledger.history.decodeWorkers":                            4,
+				"ledger.history.maxOpenScanners":                          1000,
+				"ledger.history.scannerIdleTimeout":                       "10m",
+				"ledger.history.queryQuota.window":                        "1m",
+				"ledger.history.queryQuota.maxEntriesScanned":             100000,
+				"ledger.history.queryQuota.maxBytesReturned":              64 * 1024 * 1024,
+				"ledger.history.responseCaps.maxEntries":                  10000,
+				"ledger.history.responseCaps.maxBytes":                    16 * 1024 * 1024,
+				"ledger.history.includeKeys":                              []string{"marbles:marble~*"},
+				"ledger.history.excludeKeys":                              []string{"marbles:lock~*", "marbles:counter"},
+				"ledger.history.compactionWindowStart":                    "03:30",
+				"ledger.history.compactionWindowDuration":                 "2h",
+				"ledger.history.sizeSamplingInterval":                     "1h",
+				"ledger.history.disabledNamespaces":                       []string{"cachecc"},
+				"ledger.history.normalizedKeyNamespaces":                  []string{"marbles"},
+				"ledger.history.capabilities":                             []string{"ValueInlining"},
+				"ledger.history.skipUnchangedWriteNamespaces":             []string{"marbles"},
+				"ledger.history.deltaEncoding.namespaces":                 []string{"documents"},
+				"ledger.history.deltaEncoding.checkpointInterval":         8,
+				"ledger.history.valueSizeTrackingNamespaces":              []string{"documents"},
+				"ledger.history.enableTransactionIndex":                   true,
+				"ledger.history.healthCheckMaxLag":                        20,
+				"ledger.history.migration.targetDir":                      "/peerfs/historyLeveldbV2",
+				"ledger.history.migration.shadowReadSampleRate":           0.01,
+				"ledger.history.migration.normalizedKeyNamespaces":        []string{"marbles", "assets"},
 				"ledger.snapshots.rootDir":                                "/peerfs/customLocationForsnapshots",
 			},
 			expected: &ledger.Config{
@@ -145,7 +195,54 @@ func TestLedgerConfig(t *testing.T) {
 					PurgedKeyAuditLogging:               false,
 				},
 				HistoryDBConfig: &ledger.HistoryDBConfig{
-					Enabled: true,
+					Enabled:            true,
+					IncludeInSnapshots: true,
+					BackfillArchiveDir: "/peerfs/historyArchives",
+					FieldIndexes: map[string][]string{
+						"marbles": {"owner", "owner.name"},
+						"assets":  {"status"},
+					},
+					FullTextSearchNamespaces:         []string{"marbles"},
+					GroupCommitMaxBlocks:             100,
+					GroupCommitFlushInterval:         2 * time.Second,
+					AsyncCommit:                      true,
+					AsyncCommitMaxLag:                50,
+					DecodedTxCacheSize:               1000,
+					DecodedTxCacheMaxBytes:           64 * 1024 * 1024,
+					DecodedTxCacheWarmUpBlocks:       20,
+					QueryPrefetchDepth:               8,
+					NoHistoryCacheSize:               5000,
+					QueryResultCacheSize:             2000,
+					QueryResultCacheMaxEntries:       100,
+					SlowQueryThreshold:               500 * time.Millisecond,
+					SlowQueryMinResults:              10000,
+					MaxBatchRetrievals:               4,
+					BatchQueryMaxYield:               20 * time.Millisecond,
+					DecodeWorkers:                    4,
+					MaxOpenScanners:                  1000,
+					ScannerIdleTimeout:               10 * time.Minute,
+					QueryQuotaWindow:                 time.Minute,
+					QueryQuotaMaxEntries:             100000,
+					QueryQuotaMaxBytes:               64 * 1024 * 1024,
+					MaxResponseEntries:               10000,
+					MaxResponseBytes:                 16 * 1024 * 1024,
+					IncludeKeys:                      map[string][]string{"marbles": {"marble~*"}},
+					ExcludeKeys:                      map[string][]string{"marbles": {"lock~*", "counter"}},
+					CompactionWindowStart:            "03:30",
+					CompactionWindowDuration:         2 * time.Hour,
+					SizeSamplingInterval:             time.Hour,
+					DisabledNamespaces:               []string{"cachecc"},
+					NormalizedKeyNamespaces:          []string{"marbles"},
+					Capabilities:                     []string{"ValueInlining"},
+					SkipUnchangedWriteNamespaces:     []string{"marbles"},
+					DeltaEncodingNamespaces:          []string{"documents"},
+					DeltaEncodingCheckpointInterval:  8,
+					ValueSizeTrackingNamespaces:      []string{"documents"},
+					TransactionIndex:                 true,
+					HealthCheckMaxLag:                20,
+					MigrationTargetDir:               "/peerfs/historyLeveldbV2",
+					MigrationShadowReadSampleRate:    0.01,
+					MigrationNormalizedKeyNamespaces: []string{"marbles", "assets"},
 				},
 				SnapshotsConfig: &ledger.SnapshotsConfig{
 					RootDir: "/peerfs/customLocationForsnapshots",
@@ -165,3 +262,14 @@ func TestLedgerConfig(t *testing.T) {
 		})
 	}
 }
+
+func TestHistoryNamespaceEntries(t *testing.T) {
+	require.Nil(t, historyNamespaceEntries(nil))
+	require.Equal(t,
+		map[string][]string{
+			"marbles": {"owner", "color:name"},
+			"assets":  {""},
+		},
+		historyNamespaceEntries([]string{"marbles:owner", "marbles:color:name", "assets"}),
+	)
+}
@@ -28,6 +28,9 @@ func Cmd() *cobra.Command {
 	nodeCmd.AddCommand(pauseCmd())
 	nodeCmd.AddCommand(resumeCmd())
 	nodeCmd.AddCommand(rebuildDBsCmd())
+	nodeCmd.AddCommand(rebuildHistoryCmd())
+	nodeCmd.AddCommand(compactHistoryCmd())
+	nodeCmd.AddCommand(rollbackHistoryCmd())
 	nodeCmd.AddCommand(unjoinCmd())
 	nodeCmd.AddCommand(upgradeDBsCmd())
 	return nodeCmd
@@ -0,0 +1,40 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package node
+
+import (
+	"github.com/hyperledger/fabric/core/ledger/kvledger"
+	"github.com/hyperledger/fabric/internal/peer/common"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+func rebuildHistoryCmd() *cobra.Command {
+	var channelID string
+	var fromBlockNumber uint64
+
+	cmd := &cobra.Command{
+		Use:   "rebuild-history",
+		Short: "Rebuilds the history database for a channel.",
+		Long: "Rebuilds the history database for a channel from the blocks stored on the peer, leaving the other databases" +
+			" and channels intact. If a starting block number is specified, the history prior to that block is preserved" +
+			" and only the history from that block onward is rebuilt. When the command is executed, the peer must be offline." +
+			" If interrupted, running the command again resumes the rebuild.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if channelID == common.UndefinedParamValue {
+				return errors.New("Must supply channel ID")
+			}
+			config := ledgerConfig()
+			return kvledger.RebuildHistory(config, channelID, fromBlockNumber)
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringVarP(&channelID, "channelID", "c", common.UndefinedParamValue, "Channel for which the history is to be rebuilt.")
+	flags.Uint64VarP(&fromBlockNumber, "fromBlockNumber", "b", 0, "Block number from which the history is to be rebuilt.")
+
+	return cmd
+}
@@ -0,0 +1,34 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package node
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRebuildHistoryCmd(t *testing.T) {
+	t.Run("when the channelID is not specified", func(t *testing.T) {
+		cmd := rebuildHistoryCmd()
+		cmd.SetArgs([]string{})
+		err := cmd.Execute()
+		require.EqualError(t, err, "Must supply channel ID")
+	})
+
+	t.Run("when the channel does not exist", func(t *testing.T) {
+		viper.Set("peer.fileSystemPath", t.TempDir())
+		viper.Set("ledger.history.enableHistoryDatabase", true)
+		defer viper.Reset()
+
+		cmd := rebuildHistoryCmd()
+		cmd.SetArgs([]string{"-c", "ch1"})
+		err := cmd.Execute()
+		require.EqualError(t, err, "ledger [ch1] does not exist")
+	})
+}
@@ -0,0 +1,41 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package node
+
+import (
+	"github.com/hyperledger/fabric/core/ledger/kvledger"
+	"github.com/hyperledger/fabric/internal/peer/common"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+func rollbackHistoryCmd() *cobra.Command {
+	var channelID string
+	var blockNumber uint64
+
+	cmd := &cobra.Command{
+		Use:   "rollback-history",
+		Short: "Rolls back the history database for a channel.",
+		Long: "Rolls back the history database for a channel to a specified block number, as if the blocks after the" +
+			" block number were never committed. This is useful to align the history database with a block store" +
+			" restored from a backup, without rebuilding the history. The block store and the other databases are not" +
+			" touched. When the peer starts after the rollback, it commits the blocks after the block number to the" +
+			" history database from its block store. When the command is executed, the peer must be offline.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if channelID == common.UndefinedParamValue {
+				return errors.New("Must supply channel ID")
+			}
+			config := ledgerConfig()
+			return kvledger.RollbackHistory(config, channelID, blockNumber)
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringVarP(&channelID, "channelID", "c", common.UndefinedParamValue, "Channel for which the history is to be rolled back.")
+	flags.Uint64VarP(&blockNumber, "blockNumber", "b", 0, "Block number to which the history needs to be rolled back to.")
+
+	return cmd
+}
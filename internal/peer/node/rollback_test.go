@@ -9,6 +9,7 @@ package node
 import (
 	"testing"
 
+	"github.com/spf13/viper"
 	"github.com/stretchr/testify/require"
 )
 
@@ -22,6 +23,9 @@ func TestRollbackCmd(t *testing.T) {
 	})
 
 	t.Run("when the specified channelID does not exist", func(t *testing.T) {
+		viper.Set("peer.fileSystemPath", t.TempDir())
+		defer viper.Reset()
+
 		cmd := rollbackCmd()
 		args := []string{"-c", "ch1", "-b", "10"}
 		cmd.SetArgs(args)
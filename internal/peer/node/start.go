@@ -426,6 +426,14 @@ func serve(args []string) error {
 		cb.HeaderType_CONFIG: &peer.ConfigTxProcessor{},
 	}
 
+	// the handlers are loaded before the ledgers are opened, as the history handlers are passed to the ledgers
+	libConf, err := library.LoadConfig()
+	if err != nil {
+		return errors.WithMessage(err, "could not decode peer handlers configuration")
+	}
+
+	reg := library.InitRegistry(libConf)
+
 	peerInstance.LedgerMgr = ledgermgmt.NewLedgerMgr(
 		&ledgermgmt.Initializer{
 			CustomTxProcessors:              txProcessors,
@@ -434,10 +442,14 @@ func serve(args []string) error {
 			ChaincodeLifecycleEventProvider: lifecycleCache,
 			MetricsProvider:                 metricsProvider,
 			HealthCheckRegistry:             opsSystem,
+			AdminHandlerRegistry:            &adminHandlerRegistry{opsSystem: opsSystem},
 			StateListeners:                  []ledger.StateListener{lifecycleCache},
 			Config:                          ledgerConfig(),
 			HashProvider:                    factory.GetDefault(),
 			EbMetadataProvider:              ebMetadataProvider,
+			HistoryViews:                    reg.Lookup(library.HistoryView).([]ledger.HistoryView),
+			HistoryCommitListeners:          reg.Lookup(library.HistoryCommitListener).([]ledger.HistoryCommitListener),
+			HistoryProjectors:               reg.Lookup(library.HistoryProjector).([]ledger.HistoryProjector),
 		},
 	)
 
@@ -707,13 +719,6 @@ func serve(args []string) error {
 
 	logger.Debugf("Running peer")
 
-	libConf, err := library.LoadConfig()
-	if err != nil {
-		return errors.WithMessage(err, "could not decode peer handlers configuration")
-	}
-
-	reg := library.InitRegistry(libConf)
-
 	authFilters := reg.Lookup(library.Auth).([]authHandler.Filter)
 	endorserSupport := &endorser.SupportImpl{
 		SignerSerializer: signingIdentity,
@@ -1273,6 +1278,16 @@ func newOperationsSystem(coreConfig *peer.Config) *operations.System {
 	})
 }
 
+// adminHandlerRegistry registers the admin handlers of the ledger with the operations endpoint as secure handlers, which
+// serve only the clients that present a certificate verified by the operations endpoint
+type adminHandlerRegistry struct {
+	opsSystem *operations.System
+}
+
+func (r *adminHandlerRegistry) RegisterAdminHandler(pattern string, handler http.Handler) {
+	r.opsSystem.RegisterHandler(pattern, handler, true)
+}
+
 func getDockerHostConfig() *docker.HostConfig {
 	dockerKey := func(key string) string { return "vm.docker.hostConfig." + key }
 	getInt64 := func(key string) int64 { return int64(viper.GetInt(dockerKey(key))) }
@@ -99,7 +99,7 @@ generateOrCheck \
         docs/wrappers/peer_channel_postscript.md \
         "${commands[@]}"
 
-commands=("peer node pause" "peer node rebuild-dbs" "peer node reset" "peer node resume" "peer node rollback" "peer node start" "peer node unjoin" "peer node upgrade-dbs")
+commands=("peer node compact-history" "peer node pause" "peer node rebuild-dbs" "peer node rebuild-history" "peer node reset" "peer node resume" "peer node rollback" "peer node rollback-history" "peer node start" "peer node unjoin" "peer node upgrade-dbs")
 generateOrCheck \
         docs/source/commands/peernode.md \
         docs/wrappers/peer_node_preamble.md \
@@ -141,7 +141,7 @@ generateOrCheck \
         docs/wrappers/osnadmin_channel_postscript.md \
         "${commands[@]}"
 
-commands=("ledgerutil compare" "ledgerutil identifytxs")
+commands=("ledgerutil compare" "ledgerutil identifytxs" "ledgerutil exporthistory" "ledgerutil inspecthistory" "ledgerutil verifyhistory" "ledgerutil verifyaudit")
 generateOrCheck \
         docs/source/commands/ledgerutil.md \
         docs/wrappers/ledgerutil_preamble.md \